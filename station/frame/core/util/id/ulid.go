@@ -0,0 +1,124 @@
+package id
+
+import (
+	"crypto/rand"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// crockford is the Crockford's Base32 alphabet used by ULIDs.
+// see https://github.com/ulid/spec
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULIDLength is the length of a canonical ULID string.
+const ULIDLength = 26
+
+var ErrInvalidULID = errors.New("invalid ulid")
+
+var (
+	ulidMu      sync.Mutex
+	ulidLastMs  uint64
+	ulidLastRnd [10]byte
+)
+
+// NextULID returns a new lexicographically sortable ULID.
+// IDs generated within the same millisecond are monotonic, so string comparison
+// of two ULIDs from this process always follows generation order.
+func NextULID() string {
+	return NewULID(time.Now())
+}
+
+// NewULID returns a ULID for the given time.
+func NewULID(t time.Time) string {
+	ms := uint64(t.UnixMilli())
+
+	ulidMu.Lock()
+	defer ulidMu.Unlock()
+
+	if ms <= ulidLastMs {
+		// same (or a skewed backwards) millisecond, increase the entropy part by one
+		// to keep the monotonic order.
+		ms = ulidLastMs
+		for i := len(ulidLastRnd) - 1; i >= 0; i-- {
+			ulidLastRnd[i]++
+			if ulidLastRnd[i] != 0 {
+				break
+			}
+		}
+	} else {
+		if _, err := rand.Read(ulidLastRnd[:]); err != nil {
+			panic(err)
+		}
+		ulidLastMs = ms
+	}
+
+	return encodeULID(ms, ulidLastRnd)
+}
+
+// ULIDTime returns the timestamp part of a ULID.
+func ULIDTime(ulid string) (time.Time, error) {
+	if len(ulid) != ULIDLength {
+		return time.Time{}, ErrInvalidULID
+	}
+
+	var ms uint64
+	for i := 0; i < 10; i++ {
+		v := strings.IndexByte(crockford, upper(ulid[i]))
+		if v < 0 {
+			return time.Time{}, ErrInvalidULID
+		}
+		ms = ms<<5 | uint64(v)
+	}
+
+	return time.UnixMilli(int64(ms)), nil
+}
+
+// IsULID reports whether s is a well-formed ULID.
+func IsULID(s string) bool {
+	if len(s) != ULIDLength || s[0] > '7' {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(crockford, upper(s[i])) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func encodeULID(ms uint64, rnd [10]byte) string {
+	var b [16]byte
+	b[0] = byte(ms >> 40)
+	b[1] = byte(ms >> 32)
+	b[2] = byte(ms >> 24)
+	b[3] = byte(ms >> 16)
+	b[4] = byte(ms >> 8)
+	b[5] = byte(ms)
+	copy(b[6:], rnd[:])
+
+	// 128 bits are encoded as 26 characters, the first one only carries 3 bits.
+	var out [ULIDLength]byte
+	var acc uint32
+	bits := uint(2) // 130 - 128 padding bits at the front
+	pos := 0
+	for _, v := range b {
+		acc = acc<<8 | uint32(v)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			out[pos] = crockford[(acc>>bits)&0x1f]
+			pos++
+		}
+	}
+
+	return string(out[:])
+}
+
+func upper(c byte) byte {
+	if c >= 'a' && c <= 'z' {
+		return c - ('a' - 'A')
+	}
+	return c
+}
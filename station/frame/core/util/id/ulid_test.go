@@ -0,0 +1,41 @@
+package id
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextULIDMonotonic(t *testing.T) {
+	prev := NextULID()
+	for i := 0; i < 10000; i++ {
+		next := NextULID()
+		if len(next) != ULIDLength {
+			t.Fatalf("NextULID() length = %d, want %d", len(next), ULIDLength)
+		}
+		if next <= prev {
+			t.Fatalf("NextULID() not monotonic: %s <= %s", next, prev)
+		}
+		prev = next
+	}
+}
+
+func TestULIDTime(t *testing.T) {
+	now := time.UnixMilli(time.Now().UnixMilli() + 3600*1000)
+	u := NewULID(now)
+
+	if !IsULID(u) {
+		t.Fatalf("IsULID(%s) = false", u)
+	}
+
+	got, err := ULIDTime(u)
+	if err != nil {
+		t.Fatalf("ULIDTime() error = %v", err)
+	}
+	if !got.Equal(now) {
+		t.Errorf("ULIDTime() = %v, want %v", got, now)
+	}
+
+	if _, err := ULIDTime("not-a-ulid"); err == nil {
+		t.Errorf("ULIDTime() expected error for malformed input")
+	}
+}
@@ -2,263 +2,546 @@ package activitypub
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
 
 	"github.com/cloudwego/hertz/pkg/app"
+	cfg "github.com/peers-touch/peers-touch/station/frame/core/config"
 	log "github.com/peers-touch/peers-touch/station/frame/core/logger"
 	"github.com/peers-touch/peers-touch/station/frame/core/store"
+	o "github.com/peers-touch/peers-touch/station/frame/object"
+	"github.com/peers-touch/peers-touch/station/frame/touch/activitypub/compose"
+	"github.com/peers-touch/peers-touch/station/frame/touch/activitypub/httpsig"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model"
 	"github.com/peers-touch/peers-touch/station/frame/touch/model/actor"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+	ap "github.com/peers-touch/peers-touch/station/frame/vendors/activitypub"
 )
 
-// HandleInboxActivity handles incoming ActivityPub activities
+const (
+	// ContentType is the media type ActivityPub documents are served with
+	ContentType = "application/activity+json"
+	// routePrefix is the name of the router the ActivityPub routes are mounted below
+	routePrefix = "activitypub"
+)
+
+// BaseURL returns the public root URL of the station
+func BaseURL() string {
+	return strings.TrimSuffix(cfg.Get("peers", "service", "server", "baseurl").String("https://localhost:8080"), "/")
+}
+
+// ActorIRI returns the IRI of the local actor named username
+func ActorIRI(username string) string {
+	return localIRI(username, "actor")
+}
+
+// SharedInboxIRI returns the IRI of the inbox shared by the local actors
+func SharedInboxIRI() string {
+	return fmt.Sprintf("%s/%s/inbox", BaseURL(), routePrefix)
+}
+
+func localIRI(username, name string) string {
+	return fmt.Sprintf("%s/%s/%s/%s", BaseURL(), routePrefix, url.PathEscape(username), name)
+}
+
+// HandleInboxActivity handles incoming ActivityPub activities, direct messages go to the
+// conversations bridging the actor with their author too.
 func HandleInboxActivity(c context.Context, ctx *app.RequestContext) {
-	user := ctx.Param("user")
-	if user == "" {
-		log.Warnf(c, "User parameter is required for inbox activity")
-		ctx.JSON(http.StatusBadRequest, "User parameter is required")
+	user, facade, ok := prepare(c, ctx, "inbox activity")
+	if !ok {
 		return
 	}
-
-	rds, err := store.GetRDS(c)
+	a, err := activeActor(c, facade, user)
 	if err != nil {
-		log.Errorf(c, "Failed to get database connection: %v", err)
-		ctx.JSON(http.StatusInternalServerError, "Database connection failed")
+		failed(c, ctx, err)
 		return
 	}
-
-	facade := actor.NewDefaultActivityPubFacade(rds)
-	_ = facade // TODO: Use facade to process activity for user
-
-	log.Infof(c, "Processing inbox activity for user: %s", user)
-	ctx.JSON(http.StatusOK, "Inbox activity processed successfully")
-}
-
-// CreateOutboxActivity creates a new activity in the outbox
-func CreateOutboxActivity(c context.Context, ctx *app.RequestContext) {
-	user := ctx.Param("user")
-	if user == "" {
-		log.Warnf(c, "User parameter is required for outbox activity")
-		ctx.JSON(http.StatusBadRequest, "User parameter is required")
+	activity, err := signedActivity(c, ctx)
+	if err != nil {
+		failed(c, ctx, err)
 		return
 	}
+	if err := facade.ReceiveActivityFor(o.ID(a.ID), (*o.Activity)(activity)); err != nil {
+		failed(c, ctx, err)
+		return
+	}
+	routeDirect(c, facade, activity)
+
+	log.Infof(c, "Received %s activity %s for user: %s", activity.Type, activity.ID, user)
+	ctx.SetStatusCode(http.StatusAccepted)
+}
 
+// HandleSharedInbox handles activities delivered once for every local actor of the
+// station, they go to the inboxes of the local actors they are meant for and direct
+// messages to the conversations bridging them with their author.
+func HandleSharedInbox(c context.Context, ctx *app.RequestContext) {
 	rds, err := store.GetRDS(c)
 	if err != nil {
 		log.Errorf(c, "Failed to get database connection: %v", err)
 		ctx.JSON(http.StatusInternalServerError, "Database connection failed")
 		return
 	}
+	activity, err := signedActivity(c, ctx)
+	if err != nil {
+		failed(c, ctx, err)
+		return
+	}
+	facade := actor.NewDefaultActivityPubFacade(rds).(*actor.DefaultActivityPubFacade)
+	if err := facade.ReceiveActivity((*o.Activity)(activity)); err != nil {
+		failed(c, ctx, err)
+		return
+	}
+	routeDirect(c, facade, activity)
 
-	facade := actor.NewDefaultActivityPubFacade(rds)
-	_ = facade // TODO: Use facade to create activity for user
-
-	log.Infof(c, "Creating outbox activity for user: %s", user)
-	ctx.JSON(http.StatusOK, "Outbox activity created successfully")
+	log.Infof(c, "Received %s activity %s in the shared inbox", activity.Type, activity.ID)
+	ctx.SetStatusCode(http.StatusAccepted)
 }
 
-// GetOutboxActivities retrieves activities from an actor's outbox
-func GetOutboxActivities(c context.Context, ctx *app.RequestContext) {
-	user := ctx.Param("user")
-	if user == "" {
-		log.Warnf(c, "User parameter is required for outbox activities")
-		ctx.JSON(http.StatusBadRequest, "User parameter is required")
-		return
+// signedActivity reads the activity posted to an inbox, its HTTP signature has to be
+// valid and made by its actor. Activities of domains the federation policy rejects are
+// refused before their signature is checked, the policy of the others is applied, and
+// suspended actors are refused.
+func signedActivity(c context.Context, ctx *app.RequestContext) (*ap.Activity, error) {
+	item, err := ap.UnmarshalJSON(ctx.Request.Body())
+	if err != nil {
+		return nil, model.ErrActivityInvalid
+	}
+	activity, err := ap.ToActivity(item)
+	if err != nil {
+		return nil, model.ErrActivityInvalid
 	}
-
 	rds, err := store.GetRDS(c)
 	if err != nil {
-		log.Errorf(c, "Failed to get database connection: %v", err)
-		ctx.JSON(http.StatusInternalServerError, "Database connection failed")
-		return
+		return nil, err
+	}
+	p, err := federation(rds)
+	if err != nil {
+		return nil, err
+	}
+	decision := p.For(link(activity.Actor))
+	if decision.Reject {
+		return nil, model.ErrFederationRejected
 	}
 
-	facade := actor.NewDefaultActivityPubFacade(rds)
-	_ = facade // TODO: Use facade to get activities for user
+	signer, err := verifyInbox(c, ctx, link(activity.Actor))
+	if err != nil {
+		return nil, err
+	}
+	if link(activity.Actor) != signer {
+		return nil, model.ErrSignatureActorMismatch
+	}
+	suspended, err := actor.NewDefaultActivityPubFacade(rds).(*actor.DefaultActivityPubFacade).Suspended(o.ID(signer))
+	if err != nil {
+		return nil, err
+	}
+	if suspended {
+		return nil, model.ErrActorSuspended
+	}
+	decision.Apply(activity)
+	return activity, nil
+}
 
-	log.Infof(c, "Retrieving outbox activities for user: %s", user)
-	ctx.JSON(http.StatusOK, []string{}) // TODO: Return actual activities
+// GetInboxActivities retrieves the activities of an actor's inbox
+func GetInboxActivities(c context.Context, ctx *app.RequestContext) {
+	respondCollection(c, ctx, "inbox", func(f *actor.DefaultActivityPubFacade, a *actor.Actor) (o.ItemCollection, error) {
+		return f.GetInbox(o.ID(a.ID))
+	})
 }
 
-// GetFollowers retrieves the followers collection for an actor
-func GetFollowers(c context.Context, ctx *app.RequestContext) {
-	user := ctx.Param("user")
-	if user == "" {
-		log.Warnf(c, "User parameter is required for followers")
-		ctx.JSON(http.StatusBadRequest, "User parameter is required")
+// CreateOutboxActivity creates a new activity in the outbox. Notes, Articles and other
+// objects are published in a Create, alone or in the Create of the body, addressed by
+// the visibility of the body when it has one: public, unlisted, followers or direct.
+// Follow, Like, Announce, Undo, Accept and Reject go through the relationships they
+// change.
+func CreateOutboxActivity(c context.Context, ctx *app.RequestContext) {
+	user, facade, ok := prepare(c, ctx, "outbox activity")
+	if !ok {
 		return
 	}
-
-	rds, err := store.GetRDS(c)
+	a, err := activeActor(c, facade, user)
 	if err != nil {
-		log.Errorf(c, "Failed to get database connection: %v", err)
-		ctx.JSON(http.StatusInternalServerError, "Database connection failed")
+		failed(c, ctx, err)
 		return
 	}
 
-	facade := actor.NewDefaultActivityPubFacade(rds)
-	_ = facade // TODO: Use facade to get followers for user
-
-	log.Infof(c, "Retrieving followers for user: %s", user)
-	ctx.JSON(http.StatusOK, []string{}) // TODO: Return actual followers
-}
-
-// GetFollowing retrieves who an actor is following
-func GetFollowing(c context.Context, ctx *app.RequestContext) {
-	user := ctx.Param("user")
-	if user == "" {
-		log.Warnf(c, "User parameter is required for following")
-		ctx.JSON(http.StatusBadRequest, "User parameter is required")
+	item, err := ap.UnmarshalJSON(ctx.Request.Body())
+	if err != nil || ap.IsNil(item) {
+		failed(c, ctx, model.ErrActivityInvalid)
+		return
+	}
+	var opts struct {
+		Visibility string `json:"visibility"`
+	}
+	_ = json.Unmarshal(ctx.Request.Body(), &opts)
+	visibility := ""
+	if opts.Visibility != "" {
+		if visibility, err = compose.ParseVisibility(opts.Visibility); err != nil {
+			failed(c, ctx, err)
+			return
+		}
+	}
+	actorID := o.ID(a.ID)
+	if !ap.ActivityTypes.Contains(item.GetType()) {
+		created, err := publishObject(c, facade, a, item, visibility)
+		if err != nil {
+			failed(c, ctx, err)
+			return
+		}
+		respondCreated(c, ctx, created)
 		return
 	}
 
-	rds, err := store.GetRDS(c)
+	activity, err := ap.ToActivity(item)
 	if err != nil {
-		log.Errorf(c, "Failed to get database connection: %v", err)
-		ctx.JSON(http.StatusInternalServerError, "Database connection failed")
+		failed(c, ctx, model.ErrActivityInvalid)
+		return
+	}
+	object := o.ID(link(activity.Object))
+	switch activity.Type {
+	case ap.FollowType:
+		err = facade.Follow(actorID, object)
+	case ap.LikeType:
+		err = facade.Like(actorID, object)
+	case ap.AnnounceType:
+		err = facade.Announce(actorID, object)
+	case ap.UndoType:
+		err = undo(facade, actorID, object)
+	case ap.AcceptType:
+		err = facade.AcceptFollow(actorID, object)
+	case ap.RejectType:
+		err = facade.RejectFollow(actorID, object)
+	case ap.CreateType:
+		// the object is published as it would be alone, addressed like its Create
+		_ = ap.OnObject(activity.Object, func(ob *ap.Object) error {
+			if len(ob.To) == 0 && len(ob.CC) == 0 && len(ob.Bto) == 0 && len(ob.BCC) == 0 {
+				ob.To, ob.CC, ob.Bto, ob.BCC = activity.To, activity.CC, activity.Bto, activity.BCC
+			}
+			return nil
+		})
+		created, err := publishObject(c, facade, a, activity.Object, visibility)
+		if err != nil {
+			failed(c, ctx, err)
+			return
+		}
+		respondCreated(c, ctx, created)
+		return
+	default:
+		created, err := facade.CreateActivity(o.ActivityVocabularyType(activity.Type), actorID, &activity.Object)
+		if err != nil {
+			failed(c, ctx, err)
+			return
+		}
+		respondCreated(c, ctx, created)
+		return
+	}
+	if err != nil {
+		failed(c, ctx, err)
 		return
 	}
 
-	facade := actor.NewDefaultActivityPubFacade(rds)
-	_ = facade // TODO: Use facade to get following for user
+	log.Infof(c, "Created %s outbox activity for user: %s", activity.Type, user)
+	ctx.SetStatusCode(http.StatusAccepted)
+}
+
+// GetOutboxActivities retrieves activities from an actor's outbox
+func GetOutboxActivities(c context.Context, ctx *app.RequestContext) {
+	respondPaged(c, ctx, "outbox", (*actor.DefaultActivityPubFacade).GetOutboxPage)
+}
+
+// GetFollowers retrieves the followers collection for an actor
+func GetFollowers(c context.Context, ctx *app.RequestContext) {
+	respondPaged(c, ctx, "followers", (*actor.DefaultActivityPubFacade).GetFollowersPage)
+}
 
-	log.Infof(c, "Retrieving following for user: %s", user)
-	ctx.JSON(http.StatusOK, []string{}) // TODO: Return actual following
+// GetFollowing retrieves who an actor is following
+func GetFollowing(c context.Context, ctx *app.RequestContext) {
+	respondPaged(c, ctx, "following", (*actor.DefaultActivityPubFacade).GetFollowingPage)
 }
 
 // GetLiked retrieves an actor's liked activities
 func GetLiked(c context.Context, ctx *app.RequestContext) {
-	user := ctx.Param("user")
-	if user == "" {
-		log.Warnf(c, "User parameter is required for liked")
-		ctx.JSON(http.StatusBadRequest, "User parameter is required")
+	respondPaged(c, ctx, "liked", (*actor.DefaultActivityPubFacade).GetLikedPage)
+}
+
+// CreateFollow creates a follow activity for the actor in the object of the body, its
+// IRI or its @user@host handle
+func CreateFollow(c context.Context, ctx *app.RequestContext) {
+	relate(c, ctx, "follow", func(f *actor.DefaultActivityPubFacade, actorID, object o.ID) error {
+		target, err := resolveTarget(c, string(object), true)
+		if err != nil {
+			return err
+		}
+		return f.Follow(actorID, o.ID(target))
+	})
+}
+
+// CreateUnfollow creates an unfollow activity for the actor in the object of the body,
+// its IRI or its @user@host handle
+func CreateUnfollow(c context.Context, ctx *app.RequestContext) {
+	relate(c, ctx, "unfollow", func(f *actor.DefaultActivityPubFacade, actorID, object o.ID) error {
+		target, err := resolveTarget(c, string(object), false)
+		if err != nil {
+			return err
+		}
+		return f.Unfollow(actorID, o.ID(target))
+	})
+}
+
+// CreateLike creates a like activity for the object IRI in the object of the body
+func CreateLike(c context.Context, ctx *app.RequestContext) {
+	relate(c, ctx, "like", (*actor.DefaultActivityPubFacade).Like)
+}
+
+// GetFollowRequests retrieves the follows waiting for the actor to accept or reject them
+func GetFollowRequests(c context.Context, ctx *app.RequestContext) {
+	respondCollection(c, ctx, "follow_requests", func(f *actor.DefaultActivityPubFacade, a *actor.Actor) (o.ItemCollection, error) {
+		return f.GetFollowRequests(o.ID(a.ID))
+	})
+}
+
+// AcceptFollow accepts the follow activity IRI in the object of the body
+func AcceptFollow(c context.Context, ctx *app.RequestContext) {
+	relate(c, ctx, "accept", (*actor.DefaultActivityPubFacade).AcceptFollow)
+}
+
+// RejectFollow rejects the follow activity IRI in the object of the body
+func RejectFollow(c context.Context, ctx *app.RequestContext) {
+	relate(c, ctx, "reject", (*actor.DefaultActivityPubFacade).RejectFollow)
+}
+
+// GetActor retrieves an actor's profile
+func GetActor(c context.Context, ctx *app.RequestContext) {
+	user, facade, ok := prepare(c, ctx, "actor")
+	if !ok {
 		return
 	}
-
-	rds, err := store.GetRDS(c)
+	a, err := activeActor(c, facade, user)
 	if err != nil {
-		log.Errorf(c, "Failed to get database connection: %v", err)
-		ctx.JSON(http.StatusInternalServerError, "Database connection failed")
+		failed(c, ctx, err)
 		return
 	}
+	respond(c, ctx, http.StatusOK, (*ap.Actor)(a))
+}
 
-	facade := actor.NewDefaultActivityPubFacade(rds)
-	_ = facade // TODO: Use facade to get liked activities for user
-
-	log.Infof(c, "Retrieving liked for user: %s", user)
-	ctx.JSON(http.StatusOK, []string{}) // TODO: Return actual liked activities
+// CreateUndo creates an undo activity for the activity IRI in the object of the body
+func CreateUndo(c context.Context, ctx *app.RequestContext) {
+	relate(c, ctx, "undo", func(f *actor.DefaultActivityPubFacade, actorID, object o.ID) error {
+		return undo(f, actorID, object)
+	})
 }
 
-// CreateFollow creates a follow activity
-func CreateFollow(c context.Context, ctx *app.RequestContext) {
-	user := ctx.Param("user")
+// prepare reads the user of the route and opens the facade, it answers the request
+// itself when it fails.
+func prepare(c context.Context, ctx *app.RequestContext, what string) (string, *actor.DefaultActivityPubFacade, bool) {
+	user := ctx.Param("username")
 	if user == "" {
-		log.Warnf(c, "User parameter is required for follow")
+		log.Warnf(c, "User parameter is required for %s", what)
 		ctx.JSON(http.StatusBadRequest, "User parameter is required")
-		return
+		return "", nil, false
 	}
 
 	rds, err := store.GetRDS(c)
 	if err != nil {
 		log.Errorf(c, "Failed to get database connection: %v", err)
 		ctx.JSON(http.StatusInternalServerError, "Database connection failed")
-		return
+		return "", nil, false
 	}
-
-	facade := actor.NewDefaultActivityPubFacade(rds)
-	_ = facade // TODO: Use facade to create follow for user
-
-	log.Infof(c, "Creating follow activity for user: %s", user)
-	ctx.JSON(http.StatusOK, "Follow activity created successfully")
+	return user, actor.NewDefaultActivityPubFacade(rds).(*actor.DefaultActivityPubFacade), true
 }
 
-// CreateUnfollow creates an unfollow activity
-func CreateUnfollow(c context.Context, ctx *app.RequestContext) {
-	user := ctx.Param("user")
-	if user == "" {
-		log.Warnf(c, "User parameter is required for unfollow")
-		ctx.JSON(http.StatusBadRequest, "User parameter is required")
-		return
+// localActor returns the ActivityPub actor of a local user, the first request for it
+// creates it from the touch actor. Actors stored without a key get one, and those
+// without the shared inbox are given it.
+func localActor(c context.Context, f *actor.DefaultActivityPubFacade, username string) (*actor.Actor, error) {
+	a, err := f.GetActor(o.ID(ActorIRI(username)))
+	if errors.Is(err, model.ErrActorNotFound) {
+		return provisionActor(c, f, username)
+	}
+	if err != nil {
+		return nil, err
 	}
+	if a.PublicKey.PublicKeyPem == "" {
+		return provisionActor(c, f, username)
+	}
+	if a.Endpoints == nil || link(a.Endpoints.SharedInbox) != SharedInboxIRI() {
+		a.Endpoints = &ap.Endpoints{SharedInbox: ap.IRI(SharedInboxIRI())}
+		if err := f.SaveLocalActor(a, ""); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
 
+// ProvisionActor creates the ActivityPub actor of a local user with a fresh key pair,
+// signup calls it for every new user.
+func ProvisionActor(c context.Context, username string) (*actor.Actor, error) {
 	rds, err := store.GetRDS(c)
 	if err != nil {
-		log.Errorf(c, "Failed to get database connection: %v", err)
-		ctx.JSON(http.StatusInternalServerError, "Database connection failed")
-		return
+		return nil, err
 	}
+	return provisionActor(c, actor.NewDefaultActivityPubFacade(rds).(*actor.DefaultActivityPubFacade), username)
+}
 
-	facade := actor.NewDefaultActivityPubFacade(rds)
-	_ = facade // TODO: Use facade to create unfollow for user
+func provisionActor(c context.Context, f *actor.DefaultActivityPubFacade, username string) (*actor.Actor, error) {
+	rds, err := store.GetRDS(c)
+	if err != nil {
+		return nil, err
+	}
+	var u db.Actor
+	if err := rds.Where("name = ?", username).Limit(1).Find(&u).Error; err != nil {
+		return nil, err
+	}
+	if u.ID == 0 {
+		return nil, model.ErrActorNotFound
+	}
+	publicKey, privateKey, err := httpsig.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
 
-	log.Infof(c, "Creating unfollow activity for user: %s", user)
-	ctx.JSON(http.StatusOK, "Unfollow activity created successfully")
+	iri := ActorIRI(username)
+	p := ap.PersonNew(ap.ID(iri))
+	p.Name = ap.DefaultNaturalLanguageValue(u.Name)
+	p.PreferredUsername = ap.DefaultNaturalLanguageValue(username)
+	p.Inbox = ap.IRI(localIRI(username, "inbox"))
+	p.Outbox = ap.IRI(localIRI(username, "outbox"))
+	p.Followers = ap.IRI(localIRI(username, "followers"))
+	p.Following = ap.IRI(localIRI(username, "following"))
+	p.Liked = ap.IRI(localIRI(username, "liked"))
+	p.Endpoints = &ap.Endpoints{SharedInbox: ap.IRI(SharedInboxIRI())}
+	p.PublicKey = ap.PublicKey{ID: ap.ID(actor.KeyID(iri)), Owner: ap.IRI(iri), PublicKeyPem: publicKey}
+	a := (*actor.Actor)(p)
+	if err := f.SaveLocalActor(a, privateKey); err != nil {
+		return nil, err
+	}
+	if cfg.Get("peers", "touch", "activitypub", "manually_approves_followers").Bool(false) {
+		if err := f.SetManuallyApproves(o.ID(iri), true); err != nil {
+			return nil, err
+		}
+	}
+	log.Infof(c, "Provisioned ActivityPub actor %s for user: %s", iri, username)
+	return a, nil
 }
 
-// CreateLike creates a like activity
-func CreateLike(c context.Context, ctx *app.RequestContext) {
-	user := ctx.Param("user")
-	if user == "" {
-		log.Warnf(c, "User parameter is required for like")
-		ctx.JSON(http.StatusBadRequest, "User parameter is required")
+// relate applies a relationship change of the local user to the object IRI of the body.
+func relate(c context.Context, ctx *app.RequestContext, what string, fn func(f *actor.DefaultActivityPubFacade, actorID, object o.ID) error) {
+	user, facade, ok := prepare(c, ctx, what)
+	if !ok {
 		return
 	}
-
-	rds, err := store.GetRDS(c)
+	var p struct {
+		Object string `json:"object"`
+	}
+	if err := json.Unmarshal(ctx.Request.Body(), &p); err != nil || p.Object == "" {
+		failed(c, ctx, model.ErrActivityInvalid)
+		return
+	}
+	a, err := activeActor(c, facade, user)
 	if err != nil {
-		log.Errorf(c, "Failed to get database connection: %v", err)
-		ctx.JSON(http.StatusInternalServerError, "Database connection failed")
+		failed(c, ctx, err)
+		return
+	}
+	if err := fn(facade, o.ID(a.ID), o.ID(p.Object)); err != nil {
+		failed(c, ctx, err)
 		return
 	}
 
-	facade := actor.NewDefaultActivityPubFacade(rds)
-	_ = facade // TODO: Use facade to create like for user
+	log.Infof(c, "Created %s activity for user: %s", what, user)
+	ctx.SetStatusCode(http.StatusAccepted)
+}
 
-	log.Infof(c, "Creating like activity for user: %s", user)
-	ctx.JSON(http.StatusOK, "Like activity created successfully")
+// undo reverses a Follow or Like the actor published before.
+func undo(f *actor.DefaultActivityPubFacade, actorID, activityID o.ID) error {
+	activity, err := f.GetActivity(activityID)
+	if err != nil {
+		return err
+	}
+	if link(activity.Actor) != string(actorID) {
+		return model.ErrActivityInvalid
+	}
+	switch activity.Type {
+	case ap.FollowType:
+		return f.Unfollow(actorID, o.ID(link(activity.Object)))
+	case ap.LikeType:
+		return f.Unlike(actorID, o.ID(link(activity.Object)))
+	}
+	return model.ErrActivityInvalid
 }
 
-// GetActor retrieves an actor's profile
-func GetActor(c context.Context, ctx *app.RequestContext) {
-	user := ctx.Param("user")
-	if user == "" {
-		log.Warnf(c, "User parameter is required for actor")
-		ctx.JSON(http.StatusBadRequest, "User parameter is required")
+func respondCollection(c context.Context, ctx *app.RequestContext, name string, load func(f *actor.DefaultActivityPubFacade, a *actor.Actor) (o.ItemCollection, error)) {
+	user, facade, ok := prepare(c, ctx, name)
+	if !ok {
 		return
 	}
-
-	rds, err := store.GetRDS(c)
+	a, err := localActor(c, facade, user)
 	if err != nil {
-		log.Errorf(c, "Failed to get database connection: %v", err)
-		ctx.JSON(http.StatusInternalServerError, "Database connection failed")
+		failed(c, ctx, err)
+		return
+	}
+	items, err := load(facade, a)
+	if err != nil {
+		failed(c, ctx, err)
 		return
 	}
 
-	facade := actor.NewDefaultActivityPubFacade(rds)
-	_ = facade // TODO: Use facade to get actor for user
-
-	log.Infof(c, "Retrieving actor for user: %s", user)
-	ctx.JSON(http.StatusOK, map[string]string{"type": "Person"}) // TODO: Return actual actor
+	col := ap.OrderedCollectionNew(ap.ID(localIRI(user, name)))
+	col.OrderedItems = ap.ItemCollection(items)
+	col.TotalItems = uint(len(items))
+	respond(c, ctx, http.StatusOK, col)
 }
 
-// CreateUndo creates an undo activity
-func CreateUndo(c context.Context, ctx *app.RequestContext) {
-	user := ctx.Param("user")
-	if user == "" {
-		log.Warnf(c, "User parameter is required for undo")
-		ctx.JSON(http.StatusBadRequest, "User parameter is required")
-		return
-	}
+func respondCreated(c context.Context, ctx *app.RequestContext, activity *o.Activity) {
+	ctx.Response.Header.Set("Location", string(activity.ID))
+	respond(c, ctx, http.StatusCreated, (*ap.Activity)(activity))
+}
 
-	rds, err := store.GetRDS(c)
+// respond writes the ActivityStreams document of item with its JSON-LD context.
+func respond(c context.Context, ctx *app.RequestContext, status int, item json.Marshaler) {
+	b, err := item.MarshalJSON()
 	if err != nil {
-		log.Errorf(c, "Failed to get database connection: %v", err)
-		ctx.JSON(http.StatusInternalServerError, "Database connection failed")
+		failed(c, ctx, err)
 		return
 	}
+	doc := []byte(`{"@context":"` + string(ap.ActivityBaseURI) + `"`)
+	if len(b) > 2 {
+		doc = append(append(doc, ','), b[1:]...)
+	} else {
+		doc = append(doc, '}')
+	}
+	ctx.Data(status, ContentType, doc)
+}
 
-	facade := actor.NewDefaultActivityPubFacade(rds)
-	_ = facade // TODO: Use facade to create undo for user
+func failed(c context.Context, ctx *app.RequestContext, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, model.ErrActorNotFound), errors.Is(err, model.ErrActivityNotFound), errors.Is(err, model.ErrActivityPubObjectNotFound), errors.Is(err, model.ErrPolicyNotFound), errors.Is(err, model.ErrReportNotFound), errors.Is(err, model.ErrTimelineNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, model.ErrActivityInvalid), errors.Is(err, model.ErrPolicyInvalid), errors.Is(err, model.ErrReportInvalid), errors.Is(err, model.ErrReportBadAction), errors.Is(err, model.ErrVisibilityInvalid), errors.Is(err, model.ErrBlockSelf),
+		errors.Is(err, model.ErrChatNotRemote), errors.Is(err, model.ErrChatTypeInvalid):
+		status = http.StatusBadRequest
+	case errors.Is(err, model.ErrActivityPubActorExists):
+		status = http.StatusConflict
+	case errors.Is(err, model.ErrActivityPubNotOwner), errors.Is(err, model.ErrFederationRejected), errors.Is(err, model.ErrActorSuspended):
+		status = http.StatusForbidden
+	case errors.Is(err, model.ErrSignatureInvalid), errors.Is(err, model.ErrSignatureKeyNotFound), errors.Is(err, model.ErrSignatureActorMismatch):
+		status = http.StatusUnauthorized
+	default:
+		log.Errorf(c, "ActivityPub request failed: %v", err)
+	}
+	var e *model.Error
+	if !errors.As(err, &e) {
+		e = model.UndefinedError(err)
+	}
+	ctx.JSON(status, e)
+}
 
-	log.Infof(c, "Creating undo activity for user: %s", user)
-	ctx.JSON(http.StatusOK, "Undo activity created successfully")
+func link(it ap.Item) string {
+	if ap.IsNil(it) {
+		return ""
+	}
+	return string(it.GetLink())
 }
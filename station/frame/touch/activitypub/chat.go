@@ -0,0 +1,312 @@
+package activitypub
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	cfg "github.com/peers-touch/peers-touch/station/frame/core/config"
+	log "github.com/peers-touch/peers-touch/station/frame/core/logger"
+	"github.com/peers-touch/peers-touch/station/frame/core/pkg/config/reader"
+	"github.com/peers-touch/peers-touch/station/frame/core/store"
+	"github.com/peers-touch/peers-touch/station/frame/core/util/id"
+	o "github.com/peers-touch/peers-touch/station/frame/object"
+	"github.com/peers-touch/peers-touch/station/frame/touch/activitypub/compose"
+	"github.com/peers-touch/peers-touch/station/frame/touch/message/service"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/actor"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+	"github.com/peers-touch/peers-touch/station/frame/touch/util"
+	ap "github.com/peers-touch/peers-touch/station/frame/vendors/activitypub"
+	"gorm.io/gorm"
+)
+
+// messageTypeText is the type of the messages of bridged conversations
+const messageTypeText = "text"
+
+// GetChats lists the conversations of the user bridged with remote actors
+func GetChats(c context.Context, ctx *app.RequestContext) {
+	user, facade, ok := prepare(c, ctx, "chats")
+	if !ok {
+		return
+	}
+	a, err := localActor(c, facade, user)
+	if err != nil {
+		failed(c, ctx, err)
+		return
+	}
+	chats, err := facade.GetChats(o.ID(a.ID))
+	if err != nil {
+		failed(c, ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, chats)
+}
+
+// OpenChat bridges a conversation of the user with the remote actor in the actor of the
+// body, its IRI or its @user@host handle. The messages of the user in it are delivered
+// as direct Notes, or as ChatMessages when the type of the body asks for them, and the
+// direct messages of the actor are added to it. The conversation the user has with the
+// actor already is returned as it is.
+func OpenChat(c context.Context, ctx *app.RequestContext) {
+	user, facade, ok := prepare(c, ctx, "chat")
+	if !ok {
+		return
+	}
+	var p struct {
+		Actor string `json:"actor"`
+		Type  string `json:"type"`
+	}
+	if err := json.Unmarshal(ctx.Request.Body(), &p); err != nil || p.Actor == "" {
+		failed(c, ctx, model.ErrActivityInvalid)
+		return
+	}
+	if p.Type == "" {
+		p.Type = db.ChatTypeNote
+	}
+	if p.Type != db.ChatTypeNote && p.Type != db.ChatTypeChatMessage {
+		failed(c, ctx, model.ErrChatTypeInvalid)
+		return
+	}
+	a, err := activeActor(c, facade, user)
+	if err != nil {
+		failed(c, ctx, err)
+		return
+	}
+	remote, err := resolveTarget(c, p.Actor, true)
+	if err != nil {
+		failed(c, ctx, err)
+		return
+	}
+	chat, err := openChat(c, facade, string(a.ID), remote, p.Type)
+	if err != nil {
+		failed(c, ctx, err)
+		return
+	}
+	log.Infof(c, "Chat %s of %s with %s opened", chat.ConvID, user, remote)
+	ctx.JSON(http.StatusOK, chat)
+}
+
+const (
+	defaultBridgeInterval = 5 * time.Second
+	defaultBridgeBatch    = 100
+	defaultBridgeMaxAge   = 24 * time.Hour
+)
+
+// startBridge periodically delivers the messages the local actors append to bridged
+// conversations. It is configured under peers.touch.activitypub.bridge: interval (0
+// disables it), batch and max_age. A message that fails to be delivered is tried again
+// the next time, until it is older than max_age.
+func startBridge(ctx context.Context, rds *gorm.DB) {
+	conf := func(key string) reader.Value {
+		return cfg.Get("peers", "touch", "activitypub", "bridge", key)
+	}
+	interval := conf("interval").Duration(defaultBridgeInterval)
+	batch := conf("batch").Int(defaultBridgeBatch)
+	maxAge := conf("max_age").Duration(defaultBridgeMaxAge)
+	facade := actor.NewDefaultActivityPubFacade(rds).(*actor.DefaultActivityPubFacade)
+	util.RunEvery(context.WithoutCancel(ctx), "activitypub-bridge", interval, func(ctx context.Context) error {
+		n, err := bridgePending(ctx, facade, time.Now().Add(-maxAge), batch)
+		if n > 0 {
+			log.Infof(ctx, "bridged %d messages", n)
+		}
+		return err
+	})
+}
+
+func init() {
+	store.InitTableHooks(startBridge)
+}
+
+// bridgePending delivers up to batch messages appended since the time that are pending,
+// it returns how many were delivered. Failures are logged, the message stays pending.
+func bridgePending(c context.Context, facade *actor.DefaultActivityPubFacade, since time.Time, batch int) (int, error) {
+	messages, err := facade.PendingChatMessages(since, batch)
+	if err != nil {
+		return 0, err
+	}
+	n, now := 0, time.Now()
+	for _, msg := range messages {
+		if msg.Expired(now) {
+			continue
+		}
+		if err := bridgeMessage(c, facade, msg); err != nil {
+			log.Warnf(c, "Bridge message %s of %s failed: %v", msg.ULID, msg.ConvID, err)
+			continue
+		}
+		n++
+	}
+	return n, nil
+}
+
+// bridgeMessage delivers a message appended to a bridged conversation to its remote
+// actor, as a direct object of the type of the chat replying to the object of its
+// parent. The object is published and linked to the message in one transaction, so the
+// message is published once. Messages of conversations that are not bridged, of other
+// members than the local actor of the chat and without a body are left alone.
+func bridgeMessage(c context.Context, facade *actor.DefaultActivityPubFacade, msg *db.Message) error {
+	if msg.Body == "" {
+		return nil
+	}
+	return facade.InTransaction(func(facade *actor.DefaultActivityPubFacade) error {
+		chat, err := facade.GetChatByConv(msg.ConvID)
+		if err != nil || chat == nil || msg.SenderDID != chat.LocalDID {
+			return err
+		}
+		a, err := facade.GetActor(o.ID(chat.LocalActorID))
+		if err != nil {
+			return err
+		}
+		suspended, err := facade.Suspended(o.ID(a.ID))
+		if err != nil {
+			return err
+		}
+		if suspended {
+			return model.ErrActorSuspended
+		}
+
+		ob := ap.ObjectNew(ap.ActivityVocabularyType(chat.ObjectType))
+		ob.To = ap.ItemCollection{ap.IRI(chat.RemoteActorID)}
+		ob.Content = ap.DefaultNaturalLanguageValue(msg.Body)
+		if msg.ParentID != "" {
+			parent, err := facade.ChatObject(chat, msg.ParentID)
+			if err != nil {
+				return err
+			}
+			if parent != "" {
+				ob.InReplyTo = ap.IRI(parent)
+			}
+		}
+		created, err := publishObject(c, facade, a, ob, "")
+		if err != nil {
+			return err
+		}
+		_, err = facade.LinkChatMessage(chat, o.ID(link(created.Object)), msg.ULID, true)
+		return err
+	})
+}
+
+// routeDirect adds the direct messages of a received activity to the conversations
+// bridging their local addressees with their author, opening the missing ones. Failures
+// are logged, the activity stays received.
+func routeDirect(c context.Context, facade *actor.DefaultActivityPubFacade, activity *ap.Activity) {
+	messages, err := facade.DirectMessages((*o.Activity)(activity))
+	if err != nil {
+		log.Warnf(c, "Route direct messages of %s failed: %v", activity.ID, err)
+		return
+	}
+	svc := service.NewMessageService()
+	for _, dm := range messages {
+		chat := dm.Chat
+		if chat == nil {
+			if chat, err = openChat(c, facade, dm.Local, dm.Remote, string(dm.Object.Type)); err != nil {
+				log.Warnf(c, "Open chat of %s with %s failed: %v", dm.Local, dm.Remote, err)
+				continue
+			}
+		}
+		ts := dm.Object.Published
+		if ts.IsZero() || ts.After(time.Now()) {
+			ts = time.Now()
+		}
+		ulid := id.NewULID(ts)
+		// ULIDs never go backwards, the one of a message published a while ago takes the
+		// time of the latest one issued, the message TS follows it so that both agree
+		if t, err := id.ULIDTime(ulid); err == nil {
+			ts = t
+		}
+		linked, err := facade.LinkChatMessage(chat, o.ID(dm.Object.ID), ulid, false)
+		if err != nil {
+			log.Warnf(c, "Link direct message %s failed: %v", dm.Object.ID, err)
+			continue
+		}
+		if !linked {
+			continue
+		}
+		req := &service.AppendReq{ULID: ulid, ConvID: chat.ConvID, SenderDID: chat.RemoteDID, TS: ts.UnixMilli(), Type: messageTypeText, Body: messageBody(dm.Object)}
+		if parent := link(dm.Object.InReplyTo); parent != "" {
+			if req.ParentID, err = facade.ChatMessage(chat, o.ID(parent)); err != nil {
+				log.Warnf(c, "Find the parent of direct message %s failed: %v", dm.Object.ID, err)
+			}
+		}
+		if _, err := svc.Append(c, req); err != nil {
+			log.Warnf(c, "Append direct message %s to %s failed: %v", dm.Object.ID, chat.ConvID, err)
+		}
+	}
+}
+
+// openChat opens the conversation bridging a local actor with a remote one, the local
+// actor owns it and the remote one is a member known by its ActivityPub DID.
+func openChat(c context.Context, facade *actor.DefaultActivityPubFacade, local, remote, typ string) (*db.ActivityPubChat, error) {
+	chat, err := facade.GetChat(o.ID(local), o.ID(remote))
+	if err != nil || chat != nil {
+		return chat, err
+	}
+	if strings.HasPrefix(remote, BaseURL()+"/") {
+		return nil, model.ErrChatNotRemote
+	}
+	if typ != db.ChatTypeNote && typ != db.ChatTypeChatMessage {
+		return nil, model.ErrChatTypeInvalid
+	}
+	l, err := facade.GetActor(o.ID(local))
+	if err != nil {
+		return nil, err
+	}
+	rds, err := store.GetRDS(c)
+	if err != nil {
+		return nil, err
+	}
+	var u db.Actor
+	if err := rds.Where("name = ?", l.PreferredUsername.First().Value.String()).Limit(1).Find(&u).Error; err != nil {
+		return nil, err
+	}
+	if u.ID == 0 {
+		return nil, model.ErrActorNotFound
+	}
+
+	remoteDID := db.ActivityPubDID(remote)
+	conv, err := service.NewConversationService().Create(c, &service.CreateConvReq{
+		ConvID:   id.NextULID(),
+		Type:     string(db.ConversationTypeActivityPub),
+		Title:    chatTitle(facade, remote),
+		OwnerDID: u.DID(),
+		Members:  []string{remoteDID},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return facade.SaveChat(&db.ActivityPubChat{
+		ConvID:        conv.ConvID,
+		LocalActorID:  local,
+		RemoteActorID: remote,
+		LocalDID:      u.DID(),
+		RemoteDID:     remoteDID,
+		ObjectType:    typ,
+	})
+}
+
+// chatTitle names a bridged conversation by the handle of its remote actor
+func chatTitle(facade *actor.DefaultActivityPubFacade, remote string) string {
+	a, err := facade.GetActor(o.ID(remote))
+	if err != nil {
+		return remote
+	}
+	name := a.PreferredUsername.First().Value.String()
+	u, err := url.Parse(remote)
+	if name == "" || err != nil {
+		return remote
+	}
+	return "@" + name + "@" + u.Host
+}
+
+// messageBody returns the text of a received object, its plain text source when it has
+// one.
+func messageBody(ob *ap.Object) string {
+	if len(ob.Source.Content) > 0 && (ob.Source.MediaType == "" || ob.Source.MediaType == plainText) {
+		return ob.Source.Content.First().Value.String()
+	}
+	return compose.PlainText(ob.Content.First().Value.String())
+}
@@ -0,0 +1,149 @@
+package activitypub
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/peers-touch/peers-touch/station/frame/core/util/id"
+	o "github.com/peers-touch/peers-touch/station/frame/object"
+	"github.com/peers-touch/peers-touch/station/frame/touch/internal/storetest"
+	"github.com/peers-touch/peers-touch/station/frame/touch/message/service"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/actor"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+	ap "github.com/peers-touch/peers-touch/station/frame/vendors/activitypub"
+	"gorm.io/gorm"
+)
+
+const bob = "https://remote.example/users/bob"
+
+// seedChat stores the local user alice and remote bob
+func seedChat(t *testing.T) (*gorm.DB, *actor.DefaultActivityPubFacade) {
+	t.Helper()
+	rds := storetest.Reset(t)
+	if err := rds.Create(&db.Actor{PeersActorID: "alice", Name: "alice", Email: "alice@station.example", PasswordHash: "-"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	facade := actor.NewDefaultActivityPubFacade(rds).(*actor.DefaultActivityPubFacade)
+	for _, a := range []struct {
+		iri, name string
+		local     bool
+	}{{ActorIRI("alice"), "alice", true}, {bob, "bob", false}} {
+		p := ap.PersonNew(ap.ID(a.iri))
+		p.PreferredUsername = ap.DefaultNaturalLanguageValue(a.name)
+		p.Inbox = ap.IRI(a.iri + "/inbox")
+		if err := facade.SaveActor((*actor.Actor)(p), a.local); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rds, facade
+}
+
+// directNote returns a Create of bob carrying a direct Note to alice
+func directNote(n int, inReplyTo string) *ap.Activity {
+	note := ap.ObjectNew(ap.NoteType)
+	note.ID = ap.ID(fmt.Sprintf("%s/notes/%d", bob, n))
+	note.AttributedTo = ap.IRI(bob)
+	note.To = ap.ItemCollection{ap.IRI(ActorIRI("alice"))}
+	note.Content = ap.DefaultNaturalLanguageValue("<p>hi alice</p>")
+	if inReplyTo != "" {
+		note.InReplyTo = ap.IRI(inReplyTo)
+	}
+	create := ap.ActivityNew(note.ID+"/activity", ap.CreateType, note)
+	create.Actor = ap.IRI(bob)
+	create.To = note.To
+	return create
+}
+
+func messagesOf(t *testing.T, rds *gorm.DB, convID string) []*db.Message {
+	t.Helper()
+	var messages []*db.Message
+	if err := rds.Where("conv_id = ?", convID).Order("ulid").Find(&messages).Error; err != nil {
+		t.Fatal(err)
+	}
+	return messages
+}
+
+func TestRouteDirect(t *testing.T) {
+	rds, facade := seedChat(t)
+	c := context.Background()
+	first := directNote(1, "")
+	routeDirect(c, facade, first)
+	routeDirect(c, facade, directNote(2, string(first.Object.GetLink())))
+	// received again, it is not added twice
+	routeDirect(c, facade, first)
+
+	chat, err := facade.GetChat(o.ID(ActorIRI("alice")), bob)
+	if err != nil || chat == nil {
+		t.Fatalf("chat = %v, %v", chat, err)
+	}
+	if chat.LocalDID != db.DIDPrefix+"alice" || chat.RemoteDID != db.ActivityPubDID(bob) || chat.ObjectType != db.ChatTypeNote {
+		t.Fatalf("chat = %+v", chat)
+	}
+	messages := messagesOf(t, rds, chat.ConvID)
+	if len(messages) != 2 {
+		t.Fatalf("messages = %d, want 2", len(messages))
+	}
+	for _, m := range messages {
+		if m.SenderDID != chat.RemoteDID || m.Body != "hi alice" || m.Type != messageTypeText {
+			t.Errorf("message = %+v", m)
+		}
+	}
+	if messages[0].ParentID != "" || messages[1].ParentID != messages[0].ULID {
+		t.Errorf("parents = %q, %q", messages[0].ParentID, messages[1].ParentID)
+	}
+}
+
+func TestBridgePending(t *testing.T) {
+	rds, facade := seedChat(t)
+	c := context.Background()
+	received := directNote(1, "")
+	routeDirect(c, facade, received)
+	chat, err := facade.GetChat(o.ID(ActorIRI("alice")), bob)
+	if err != nil || chat == nil {
+		t.Fatalf("chat = %v, %v", chat, err)
+	}
+	parent := messagesOf(t, rds, chat.ConvID)[0].ULID
+	msg, err := service.NewMessageService().Append(c, &service.AppendReq{ULID: id.NextULID(), ConvID: chat.ConvID, SenderDID: chat.LocalDID,
+		TS: time.Now().UnixMilli(), Type: messageTypeText, ParentID: parent, Body: "hi bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	since := time.Now().Add(-time.Hour)
+
+	// the message of a suspended actor stays pending
+	if err := rds.Model(&db.ActivityPubActor{}).Where("activity_pub_id = ?", ActorIRI("alice")).Update("suspended_at", time.Now()).Error; err != nil {
+		t.Fatal(err)
+	}
+	if n, err := bridgePending(c, facade, since, 10); err != nil || n != 0 {
+		t.Fatalf("bridged %d, %v while suspended", n, err)
+	}
+	if err := rds.Model(&db.ActivityPubActor{}).Where("activity_pub_id = ?", ActorIRI("alice")).Update("suspended_at", nil).Error; err != nil {
+		t.Fatal(err)
+	}
+	if n, err := bridgePending(c, facade, since, 10); err != nil || n != 1 {
+		t.Fatalf("bridged %d, %v", n, err)
+	}
+	if n, err := bridgePending(c, facade, since, 10); err != nil || n != 0 {
+		t.Fatalf("bridged %d again, %v", n, err)
+	}
+
+	iri, err := facade.ChatObject(chat, msg.ULID)
+	if err != nil || iri == "" {
+		t.Fatalf("object = %q, %v", iri, err)
+	}
+	ob, visibility, err := facade.GetPublishedObject(o.ID(iri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if visibility != db.VisibilityDirect || !ob.To.Contains(ap.IRI(bob)) || ob.Type != ap.NoteType {
+		t.Errorf("object = %s to %v, %s", ob.Type, ob.To, visibility)
+	}
+	if link(ob.InReplyTo) != string(received.Object.GetLink()) {
+		t.Errorf("in reply to %v, want %s", ob.InReplyTo, received.Object.GetLink())
+	}
+	if got := ob.Content.First().Value.String(); got != "<p>hi bob</p>" {
+		t.Errorf("content = %q", got)
+	}
+}
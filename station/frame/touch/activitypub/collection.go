@@ -0,0 +1,147 @@
+package activitypub
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	o "github.com/peers-touch/peers-touch/station/frame/object"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/actor"
+	ap "github.com/peers-touch/peers-touch/station/frame/vendors/activitypub"
+)
+
+type ownerKey struct{}
+
+// WithOwner marks the request context as authenticated for the actor of the route, its
+// collections are shown to it in full.
+func WithOwner(c context.Context) context.Context {
+	return context.WithValue(c, ownerKey{}, true)
+}
+
+func isOwner(c context.Context) bool {
+	owner, _ := c.Value(ownerKey{}).(bool)
+	return owner
+}
+
+// pageLoader loads a page of a collection of the actor
+type pageLoader func(f *actor.DefaultActivityPubFacade, actorID o.ID, q actor.PageQuery) (*actor.CollectionPage, error)
+
+// respondPaged answers a GET of a collection: the OrderedCollection linking its first
+// and last pages without a page query, and the OrderedCollectionPage it selects with
+// one. Pages are cut by max_id, the items older than it, and min_id, the items right
+// after it. Remote actors are told apart by the HTTP signature of their request.
+func respondPaged(c context.Context, ctx *app.RequestContext, name string, load pageLoader) {
+	user, facade, ok := prepare(c, ctx, name)
+	if !ok {
+		return
+	}
+	a, err := activeActor(c, facade, user)
+	if err != nil {
+		failed(c, ctx, err)
+		return
+	}
+	q, err := pageQuery(ctx)
+	if err != nil {
+		failed(c, ctx, err)
+		return
+	}
+	if isOwner(c) {
+		q.Viewer = o.ID(a.ID)
+	} else if len(ctx.Request.Header.Peek("Signature")) > 0 {
+		signer, err := verifyInbox(c, ctx, "")
+		if err != nil {
+			failed(c, ctx, err)
+			return
+		}
+		q.Viewer = o.ID(signer)
+	}
+
+	respondPages(c, ctx, localIRI(user, name), q, func(q actor.PageQuery) (*actor.CollectionPage, error) {
+		return load(facade, o.ID(a.ID), q)
+	})
+}
+
+// respondPages answers a GET of the paged collection with the id: the OrderedCollection
+// linking its first and last pages without a page query, and the OrderedCollectionPage q
+// selects with one.
+func respondPages(c context.Context, ctx *app.RequestContext, id string, q actor.PageQuery, load func(q actor.PageQuery) (*actor.CollectionPage, error)) {
+	if len(ctx.Query("page")) == 0 {
+		q.Limit = 1
+		page, err := load(q)
+		if err != nil {
+			failed(c, ctx, err)
+			return
+		}
+		col := ap.OrderedCollectionNew(ap.ID(id))
+		col.TotalItems = uint(page.Total)
+		col.First = ap.IRI(pageIRI(id, nil))
+		col.Last = ap.IRI(pageIRI(id, url.Values{"min_id": {"0"}}))
+		respond(c, ctx, http.StatusOK, col)
+		return
+	}
+
+	page, err := load(q)
+	if err != nil {
+		failed(c, ctx, err)
+		return
+	}
+	col := ap.OrderedCollectionNew(ap.ID(id))
+	p := ap.OrderedCollectionPageNew(col)
+	p.ID = ap.ID(pageIRI(id, cursor(q)))
+	p.TotalItems = uint(page.Total)
+	p.OrderedItems = ap.ItemCollection(page.Items)
+	p.First = ap.IRI(pageIRI(id, nil))
+	p.Last = ap.IRI(pageIRI(id, url.Values{"min_id": {"0"}}))
+	if page.HasOlder {
+		p.Next = ap.IRI(pageIRI(id, url.Values{"max_id": {strconv.FormatInt(page.Oldest, 10)}}))
+	}
+	if page.HasNewer {
+		p.Prev = ap.IRI(pageIRI(id, url.Values{"min_id": {strconv.FormatInt(page.Newest, 10)}}))
+	}
+	respond(c, ctx, http.StatusOK, p)
+}
+
+// pageQuery reads the cursor and size of the page asked for
+func pageQuery(ctx *app.RequestContext) (actor.PageQuery, error) {
+	var q actor.PageQuery
+	var err error
+	if v := ctx.Query("max_id"); v != "" {
+		if q.Before, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return q, model.ErrActivityInvalid
+		}
+	}
+	if v, ok := ctx.GetQuery("min_id"); ok {
+		if q.After, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return q, model.ErrActivityInvalid
+		}
+		q.Reverse = true
+	}
+	if v := ctx.Query("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil {
+			return q, model.ErrActivityInvalid
+		}
+	}
+	return q, nil
+}
+
+// cursor returns the query parameters selecting the page q asks for
+func cursor(q actor.PageQuery) url.Values {
+	v := url.Values{}
+	if q.Reverse {
+		v.Set("min_id", strconv.FormatInt(q.After, 10))
+	} else if q.Before > 0 {
+		v.Set("max_id", strconv.FormatInt(q.Before, 10))
+	}
+	return v
+}
+
+func pageIRI(collectionIRI string, cursor url.Values) string {
+	v := url.Values{"page": {"true"}}
+	for k, vs := range cursor {
+		v[k] = vs
+	}
+	return collectionIRI + "?" + v.Encode()
+}
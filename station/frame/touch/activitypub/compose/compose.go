@@ -0,0 +1,245 @@
+// Package compose turns what local users post into ActivityPub objects: the addressing
+// of a visibility level, and the mentions and hashtags of their text as tags and links.
+package compose
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/peers-touch/peers-touch/station/frame/touch/model"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+	ap "github.com/peers-touch/peers-touch/station/frame/vendors/activitypub"
+	"github.com/valyala/fastjson"
+)
+
+const (
+	// HashtagType is the type of the hashtag tags, an extension of ActivityStreams
+	HashtagType ap.ActivityVocabularyType = "Hashtag"
+	// ChatMessageType is the type of the one-to-one chat messages of Pleroma and
+	// Misskey, an extension of ActivityStreams
+	ChatMessageType ap.ActivityVocabularyType = db.ChatTypeChatMessage
+)
+
+// MaxMentions caps the mentions of a post that are resolved and addressed
+const MaxMentions = 50
+
+var (
+	// a mention is @user or @user@host, not preceded by what would make it part of a
+	// word, an address or a URL
+	mentionRe = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_/@.])(@([\p{L}\p{N}_][\p{L}\p{N}_.-]*[\p{L}\p{N}_]|[\p{L}\p{N}_])(?:@([A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*(?::[0-9]+)?))?)`)
+	// a hashtag is # and a word with at least a letter, not preceded by what would make
+	// it an entity, a fragment or part of a word
+	hashtagRe = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_&/#])(#([\p{L}\p{N}_]*\p{L}[\p{L}\p{N}_]*))`)
+
+	breakRe     = regexp.MustCompile(`(?i)<br\s*/?>`)
+	paragraphRe = regexp.MustCompile(`(?i)</p>\s*<p[^>]*>`)
+	tagRe       = regexp.MustCompile(`<[^>]*>`)
+)
+
+func init() {
+	// the decoder drops the types it does not know, hashtags are links and chat
+	// messages objects
+	ap.LinkTypes = append(ap.LinkTypes, HashtagType)
+	ap.ObjectTypes = append(ap.ObjectTypes, ChatMessageType)
+	typer := ap.ItemTyperFunc
+	ap.ItemTyperFunc = func(typ ap.ActivityVocabularyType) (ap.Item, error) {
+		switch typ {
+		case HashtagType:
+			return &ap.Link{Type: typ}, nil
+		case ChatMessageType:
+			return ap.ObjectNew(typ), nil
+		}
+		return typer(typ)
+	}
+	unmarshal := ap.JSONItemUnmarshal
+	ap.JSONItemUnmarshal = func(typ ap.ActivityVocabularyType, val *fastjson.Value, it ap.Item) error {
+		switch typ {
+		case HashtagType:
+			return ap.OnLink(it, func(l *ap.Link) error {
+				return ap.JSONLoadLink(val, l)
+			})
+		case ChatMessageType:
+			return ap.OnObject(it, func(ob *ap.Object) error {
+				return ap.JSONLoadObject(val, ob)
+			})
+		}
+		if unmarshal == nil {
+			return fmt.Errorf("unable to unmarshal custom type %s", typ)
+		}
+		return unmarshal(typ, val, it)
+	}
+}
+
+// Mention is a mention of the text of a post
+type Mention struct {
+	User string
+	// Host is empty for local users mentioned by name only
+	Host       string
+	start, end int
+}
+
+// Handle returns the mention as it is written, @user or @user@host
+func (m Mention) Handle() string {
+	if m.Host == "" {
+		return "@" + m.User
+	}
+	return "@" + m.User + "@" + m.Host
+}
+
+// Hashtag is a hashtag of the text of a post, Name without the #
+type Hashtag struct {
+	Name       string
+	start, end int
+}
+
+// Text is the text of a post with its mentions and hashtags
+type Text struct {
+	Source   string
+	Mentions []Mention
+	Hashtags []Hashtag
+}
+
+// Parse finds the mentions and hashtags of a text
+func Parse(s string) *Text {
+	t := &Text{Source: s}
+	for _, m := range mentionRe.FindAllStringSubmatchIndex(s, -1) {
+		mention := Mention{User: s[m[4]:m[5]], start: m[2], end: m[3]}
+		if m[6] >= 0 {
+			mention.Host = strings.ToLower(s[m[6]:m[7]])
+		}
+		t.Mentions = append(t.Mentions, mention)
+	}
+	for _, m := range hashtagRe.FindAllStringSubmatchIndex(s, -1) {
+		t.Hashtags = append(t.Hashtags, Hashtag{Name: s[m[4]:m[5]], start: m[2], end: m[3]})
+	}
+	return t
+}
+
+// Handles returns the distinct handles mentioned, in the order they first appear
+func (t *Text) Handles() []string {
+	var handles []string
+	seen := map[string]bool{}
+	for _, m := range t.Mentions {
+		h := strings.ToLower(m.Handle())
+		if !seen[h] {
+			seen[h] = true
+			handles = append(handles, m.Handle())
+		}
+	}
+	return handles
+}
+
+// Tags returns the distinct hashtags, without #, in the order they first appear
+func (t *Text) Tags() []string {
+	var tags []string
+	seen := map[string]bool{}
+	for _, h := range t.Hashtags {
+		if key := strings.ToLower(h.Name); !seen[key] {
+			seen[key] = true
+			tags = append(tags, h.Name)
+		}
+	}
+	return tags
+}
+
+// HTML renders the text as a plain text post: escaped, in paragraphs, with the hashtags
+// and the mentions whose lower case handle hrefs maps to a profile linked.
+func (t *Text) HTML(hrefs map[string]string, tagURL func(name string) string) string {
+	type span struct {
+		start, end int
+		html       string
+	}
+	var spans []span
+	for _, m := range t.Mentions {
+		if href, ok := hrefs[strings.ToLower(m.Handle())]; ok {
+			spans = append(spans, span{m.start, m.end, `<span class="h-card"><a href="` + html.EscapeString(href) +
+				`" class="u-url mention">@<span>` + html.EscapeString(m.User) + `</span></a></span>`})
+		}
+	}
+	for _, h := range t.Hashtags {
+		spans = append(spans, span{h.start, h.end, `<a href="` + html.EscapeString(tagURL(h.Name)) +
+			`" class="mention hashtag" rel="tag">#<span>` + html.EscapeString(h.Name) + `</span></a>`})
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+
+	var b strings.Builder
+	pos := 0
+	for _, s := range spans {
+		if s.start < pos {
+			continue
+		}
+		b.WriteString(html.EscapeString(t.Source[pos:s.start]))
+		b.WriteString(s.html)
+		pos = s.end
+	}
+	b.WriteString(html.EscapeString(t.Source[pos:]))
+
+	var out strings.Builder
+	for _, p := range strings.Split(strings.ReplaceAll(b.String(), "\r\n", "\n"), "\n\n") {
+		if p = strings.TrimSpace(p); p != "" {
+			out.WriteString("<p>" + strings.ReplaceAll(p, "\n", "<br>") + "</p>")
+		}
+	}
+	return out.String()
+}
+
+// PlainText turns the HTML of a post back into text: paragraphs are separated by blank
+// lines, line breaks kept and the other markup dropped.
+func PlainText(s string) string {
+	s = paragraphRe.ReplaceAllString(s, "\n\n")
+	s = breakRe.ReplaceAllString(s, "\n")
+	s = tagRe.ReplaceAllString(s, "")
+	return strings.TrimSpace(html.UnescapeString(s))
+}
+
+// MentionTag returns the tag of a mention of the actor
+func MentionTag(handle, actorIRI string) ap.Item {
+	return &ap.Mention{Type: ap.MentionType, Href: ap.IRI(actorIRI), Name: ap.DefaultNaturalLanguageValue(handle)}
+}
+
+// HashtagTag returns the tag of a hashtag, name without #, linking href
+func HashtagTag(name, href string) ap.Item {
+	return &ap.Link{Type: HashtagType, Href: ap.IRI(href), Name: ap.DefaultNaturalLanguageValue("#" + name)}
+}
+
+// ParseVisibility returns the visibility level of its name, private naming the
+// followers-only one as some clients do.
+func ParseVisibility(s string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case db.VisibilityPublic:
+		return db.VisibilityPublic, nil
+	case db.VisibilityUnlisted:
+		return db.VisibilityUnlisted, nil
+	case db.VisibilityFollowers, "private":
+		return db.VisibilityFollowers, nil
+	case db.VisibilityDirect:
+		return db.VisibilityDirect, nil
+	}
+	return "", model.ErrVisibilityInvalid
+}
+
+// Address returns the addressing of a post of the visibility by an actor with the
+// followers collection, the actors mentioned being addressed too. Public posts go to
+// the public collection, unlisted ones have it in cc only, followers-only ones go to
+// the followers and direct ones to the actors mentioned alone.
+func Address(visibility, followers string, mentioned []string) (to, cc ap.ItemCollection) {
+	actors := make(ap.ItemCollection, 0, len(mentioned))
+	for _, iri := range mentioned {
+		if !actors.Contains(ap.IRI(iri)) {
+			actors = append(actors, ap.IRI(iri))
+		}
+	}
+	switch visibility {
+	case db.VisibilityPublic:
+		return ap.ItemCollection{ap.PublicNS}, append(ap.ItemCollection{ap.IRI(followers)}, actors...)
+	case db.VisibilityUnlisted:
+		return ap.ItemCollection{ap.IRI(followers)}, append(ap.ItemCollection{ap.PublicNS}, actors...)
+	case db.VisibilityFollowers:
+		return ap.ItemCollection{ap.IRI(followers)}, actors
+	default:
+		return actors, nil
+	}
+}
@@ -0,0 +1,128 @@
+package compose
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+	ap "github.com/peers-touch/peers-touch/station/frame/vendors/activitypub"
+)
+
+func TestParse(t *testing.T) {
+	text := Parse("Hi @bob@Remote.example:8443 and @carol, cc @bob@remote.example:8443.\n" +
+		"mail alice@example.com, see https://example.com/@dave#frag #Go #go #日本 #123 &#39;")
+	if got, want := text.Handles(), []string{"@bob@remote.example:8443", "@carol"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("handles = %q, want %q", got, want)
+	}
+	if got, want := text.Tags(), []string{"Go", "日本"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("tags = %q, want %q", got, want)
+	}
+}
+
+func TestHTML(t *testing.T) {
+	text := Parse("Hi @bob@remote.example & @nobody!\n#Go\n\nbye <3")
+	got := text.HTML(map[string]string{"@bob@remote.example": "https://remote.example/@bob"}, func(name string) string {
+		return "https://station.example/tags/" + name
+	})
+	want := `<p>Hi <span class="h-card"><a href="https://remote.example/@bob" class="u-url mention">@<span>bob</span></a></span> &amp; @nobody!<br>` +
+		`<a href="https://station.example/tags/Go" class="mention hashtag" rel="tag">#<span>Go</span></a></p><p>bye &lt;3</p>`
+	if got != want {
+		t.Fatalf("html =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestPlainText(t *testing.T) {
+	source := "Hi @bob@remote.example & @nobody!\n#Go\n\nbye <3"
+	rendered := Parse(source).HTML(map[string]string{"@bob@remote.example": "https://remote.example/@bob"}, func(name string) string {
+		return "https://station.example/tags/" + name
+	})
+	if got, want := PlainText(rendered), "Hi @bob & @nobody!\n#Go\n\nbye <3"; got != want {
+		t.Fatalf("text = %q, want %q", got, want)
+	}
+	if got := PlainText(`<p>one<br/>two</p>`); got != "one\ntwo" {
+		t.Fatalf("text = %q", got)
+	}
+	tagURL := func(name string) string { return "https://station.example/tags/" + name }
+	client := `<p onclick="steal()">hi<img src=x onerror=alert(1)> #Go</p><p>&lt;script&gt;alert(2)&lt;/script&gt;</p>`
+	want := `<p>hi <a href="https://station.example/tags/Go" class="mention hashtag" rel="tag">#<span>Go</span></a></p>` +
+		`<p>&lt;script&gt;alert(2)&lt;/script&gt;</p>`
+	if got := Parse(PlainText(client)).HTML(nil, tagURL); got != want {
+		t.Fatalf("html of the client markup = %q, want %q", got, want)
+	}
+}
+
+func TestAddress(t *testing.T) {
+	followers := "https://station.example/activitypub/alice/followers"
+	bob := "https://remote.example/users/bob"
+	for _, tc := range []struct {
+		visibility string
+		to, cc     ap.ItemCollection
+	}{
+		{db.VisibilityPublic, ap.ItemCollection{ap.PublicNS}, ap.ItemCollection{ap.IRI(followers), ap.IRI(bob)}},
+		{db.VisibilityUnlisted, ap.ItemCollection{ap.IRI(followers)}, ap.ItemCollection{ap.PublicNS, ap.IRI(bob)}},
+		{db.VisibilityFollowers, ap.ItemCollection{ap.IRI(followers)}, ap.ItemCollection{ap.IRI(bob)}},
+		{db.VisibilityDirect, ap.ItemCollection{ap.IRI(bob)}, nil},
+	} {
+		to, cc := Address(tc.visibility, followers, []string{bob, bob})
+		if !reflect.DeepEqual(to, tc.to) || !reflect.DeepEqual(cc, tc.cc) {
+			t.Errorf("%s: to %v cc %v", tc.visibility, to, cc)
+		}
+	}
+
+	if v, err := ParseVisibility("Private"); err != nil || v != db.VisibilityFollowers {
+		t.Errorf("ParseVisibility(private) = %q, %v", v, err)
+	}
+	if _, err := ParseVisibility("everyone"); err == nil {
+		t.Error("unknown visibility parsed")
+	}
+}
+
+func TestHashtagTagsDecode(t *testing.T) {
+	note := ap.ObjectNew(ap.NoteType)
+	note.Tag = ap.ItemCollection{
+		MentionTag("@bob@remote.example", "https://remote.example/users/bob"),
+		HashtagTag("Go", "https://station.example/tags/go"),
+	}
+	b, err := note.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	it, err := ap.UnmarshalJSON(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ob, err := ap.ToObject(it)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ob.Tag) != 2 {
+		t.Fatalf("tags = %v", ob.Tag)
+	}
+	if l, err := ap.ToLink(ob.Tag[1]); err != nil || l.Type != HashtagType || l.Href != "https://station.example/tags/go" {
+		t.Fatalf("hashtag = %v, %v", ob.Tag[1], err)
+	}
+}
+
+func TestChatMessageDecode(t *testing.T) {
+	doc := `{"id":"https://remote.example/activities/1","type":"Create","actor":"https://remote.example/users/bob",
+		"object":{"id":"https://remote.example/objects/1","type":"ChatMessage","attributedTo":"https://remote.example/users/bob",
+		"to":["https://station.example/activitypub/alice/actor"],"content":"hi"}}`
+	it, err := ap.UnmarshalJSON([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := ap.ToActivity(it)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ob, err := ap.ToObject(a.Object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ob.Type != ChatMessageType || ob.ID != "https://remote.example/objects/1" || ob.Content.First().Value.String() != "hi" {
+		t.Fatalf("chat message = %+v", ob)
+	}
+	if !ob.To.Contains(ap.IRI("https://station.example/activitypub/alice/actor")) || !ap.ObjectTypes.Contains(ob.Type) {
+		t.Fatalf("chat message addressed to %v", ob.To)
+	}
+}
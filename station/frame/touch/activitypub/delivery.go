@@ -0,0 +1,137 @@
+package activitypub
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	cfg "github.com/peers-touch/peers-touch/station/frame/core/config"
+	log "github.com/peers-touch/peers-touch/station/frame/core/logger"
+	"github.com/peers-touch/peers-touch/station/frame/core/pkg/config/reader"
+	"github.com/peers-touch/peers-touch/station/frame/core/store"
+	o "github.com/peers-touch/peers-touch/station/frame/object"
+	"github.com/peers-touch/peers-touch/station/frame/touch/activitypub/delivery"
+	"github.com/peers-touch/peers-touch/station/frame/touch/activitypub/resolver"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/actor"
+	"github.com/peers-touch/peers-touch/station/frame/touch/util"
+	ap "github.com/peers-touch/peers-touch/station/frame/vendors/activitypub"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultDeliveryInterval = 5 * time.Second
+	deliveryTimeout         = 30 * time.Second
+)
+
+// startDelivery periodically delivers the queued outgoing activities. It is configured
+// under peers.touch.activitypub.delivery: interval (0 disables it), per_host, workers,
+// max_attempts, backoff, max_backoff, breaker_threshold, breaker_cooldown and dead_after.
+// Deliveries to the domains the federation policy rejects are dead-lettered.
+func startDelivery(ctx context.Context, rds *gorm.DB) {
+	conf := func(key string) reader.Value {
+		return cfg.Get("peers", "touch", "activitypub", "delivery", key)
+	}
+	interval := conf("interval").Duration(defaultDeliveryInterval)
+	opts := delivery.DefaultOptions()
+	opts.PerHost = conf("per_host").Int(opts.PerHost)
+	opts.Workers = conf("workers").Int(opts.Workers)
+	opts.MaxAttempts = conf("max_attempts").Int(opts.MaxAttempts)
+	opts.BaseBackoff = conf("backoff").Duration(opts.BaseBackoff)
+	opts.MaxBackoff = conf("max_backoff").Duration(opts.MaxBackoff)
+	opts.BreakerThreshold = conf("breaker_threshold").Int(opts.BreakerThreshold)
+	opts.BreakerCooldown = conf("breaker_cooldown").Duration(opts.BreakerCooldown)
+	opts.DeadAfter = conf("dead_after").Duration(opts.DeadAfter)
+	opts.Refuse = refuser(ctx, rds)
+
+	facade := actor.NewDefaultActivityPubFacade(rds).(*actor.DefaultActivityPubFacade)
+	sign := func(req *http.Request, sender string, body []byte) error {
+		return SignRequest(facade, sender, req, body)
+	}
+	resolve := func(ctx context.Context, iri string, shared bool) (string, error) {
+		return resolveInbox(ctx, iri, shared)
+	}
+	q := delivery.NewQueue(delivery.NewRDSStore(rds), resolver.NewClient(deliveryTimeout, allowPrivate), sign, resolve, opts)
+	util.RunEvery(context.WithoutCancel(ctx), "activitypub-delivery", interval, func(ctx context.Context) error {
+		n, err := q.Run(ctx)
+		if n > 0 {
+			log.Infof(ctx, "delivered %d activities", n)
+		}
+		return err
+	})
+}
+
+func init() {
+	store.InitTableHooks(startDelivery)
+}
+
+// resolveInbox resolves a remote actor for the inbox deliveries to it go to, its shared
+// inbox when shared is set and it has one.
+func resolveInbox(c context.Context, iri string, shared bool) (string, error) {
+	r, err := remoteResolver(c)
+	if err != nil {
+		return "", err
+	}
+	a, err := r.ResolveActor(c, iri)
+	if errors.Is(err, resolver.ErrGone) || errors.Is(err, resolver.ErrNotFound) {
+		return "", delivery.ErrRecipientGone
+	}
+	if errors.Is(err, resolver.ErrRefused) {
+		return "", delivery.ErrRefused
+	}
+	if err != nil {
+		return "", err
+	}
+	if shared && a.Endpoints != nil && !ap.IsNil(a.Endpoints.SharedInbox) {
+		return link(a.Endpoints.SharedInbox), nil
+	}
+	if ap.IsNil(a.Inbox) {
+		return "", model.ErrActivityInvalid
+	}
+	return link(a.Inbox), nil
+}
+
+// GetDeliveries reports the delivery of the outbox of an actor: the status of the
+// activity in the activity query, or the activities still being delivered.
+func GetDeliveries(c context.Context, ctx *app.RequestContext) {
+	user, facade, ok := prepare(c, ctx, "deliveries")
+	if !ok {
+		return
+	}
+	a, err := localActor(c, facade, user)
+	if err != nil {
+		failed(c, ctx, err)
+		return
+	}
+
+	if activityID := ctx.Query("activity"); activityID != "" {
+		activity, err := facade.GetActivity(o.ID(activityID))
+		if err != nil {
+			failed(c, ctx, err)
+			return
+		}
+		if link(activity.Actor) != string(a.ID) {
+			failed(c, ctx, model.ErrActivityNotFound)
+			return
+		}
+		status, err := facade.DeliveryStatus(o.ID(activityID))
+		if err != nil {
+			failed(c, ctx, err)
+			return
+		}
+		ctx.JSON(http.StatusOK, status)
+		return
+	}
+
+	items, err := facade.GetPendingActivities(o.ID(a.ID))
+	if err != nil {
+		failed(c, ctx, err)
+		return
+	}
+	col := ap.OrderedCollectionNew(ap.ID(localIRI(user, "outbox/deliveries")))
+	col.OrderedItems = ap.ItemCollection(items)
+	col.TotalItems = uint(len(items))
+	respond(c, ctx, http.StatusOK, col)
+}
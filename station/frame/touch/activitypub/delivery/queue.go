@@ -0,0 +1,363 @@
+// Package delivery sends outgoing activities to remote inboxes. Deliveries are queued in
+// the RDS, so they survive restarts, and are retried with exponential backoff. Hosts that
+// keep failing have their circuit opened and, once dead for long enough, their
+// deliveries are moved to the dead letters.
+package delivery
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/peers-touch/peers-touch/station/frame/core/logger"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+)
+
+// ContentType is the media type activities are posted with
+const ContentType = `application/ld+json; profile="https://www.w3.org/ns/activitystreams"`
+
+// maxResponseBytes caps what is read from the responses of inboxes.
+const maxResponseBytes = 64 << 10
+
+// ErrActivityGone is returned by Store.Payload when the activity was deleted before it
+// was delivered.
+var ErrActivityGone = errors.New("delivery: activity gone")
+
+// ErrRecipientGone is returned by a Resolver when the recipient was deleted on its server.
+var ErrRecipientGone = errors.New("delivery: recipient gone")
+
+// ErrRefused is the reason deliveries to hosts the federation policy rejects are
+// dead-lettered with, a Resolver returns it for such recipients.
+var ErrRefused = errors.New("delivery: host refused by the federation policy")
+
+// Store keeps the queue.
+type Store interface {
+	// Claim returns up to limit deliveries due at now whose host circuit is closed, and
+	// leases them until lease so a crashed run leaves them to a later one.
+	Claim(ctx context.Context, now time.Time, limit int, lease time.Time) ([]*db.ActivityPubDelivery, error)
+	// Payload returns the JSON document posted for the activity.
+	Payload(ctx context.Context, activityID string) ([]byte, error)
+	// Resolved records the inbox of a delivery. It drops the delivery and returns false
+	// when another delivery of the activity goes to the same inbox.
+	Resolved(ctx context.Context, d *db.ActivityPubDelivery) (bool, error)
+	// Delivered marks the delivery done.
+	Delivered(ctx context.Context, d *db.ActivityPubDelivery) error
+	// Retry saves the attempts, next attempt and last error of the delivery.
+	Retry(ctx context.Context, d *db.ActivityPubDelivery) error
+	// DeadLetter moves the delivery to the dead letters.
+	DeadLetter(ctx context.Context, d *db.ActivityPubDelivery, reason string) error
+	// Instance returns the delivery health of a host, a zero one for unknown hosts.
+	Instance(ctx context.Context, host string) (*db.ActivityPubInstance, error)
+	// SaveInstance stores the delivery health of a host.
+	SaveInstance(ctx context.Context, inst *db.ActivityPubInstance) error
+}
+
+// Signer signs a request posted for the sender, body is what the request sends.
+type Signer func(req *http.Request, senderID string, body []byte) error
+
+// Resolver returns the inbox of an actor, its shared inbox when shared is set and it
+// has one.
+type Resolver func(ctx context.Context, actorIRI string, shared bool) (string, error)
+
+// Options configure a Queue.
+type Options struct {
+	// Batch is the number of deliveries a run claims.
+	Batch int
+	// Workers caps the concurrent deliveries of a run, PerHost those to one host.
+	Workers int
+	PerHost int
+	// Lease is how long a claimed delivery stays with a run.
+	Lease time.Duration
+	// MaxAttempts is the number of attempts before a delivery is dead-lettered.
+	MaxAttempts int
+	// BaseBackoff doubles with every attempt, up to MaxBackoff.
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// BreakerThreshold consecutive failures open the circuit of a host for
+	// BreakerCooldown, doubling while it keeps failing.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	// DeadAfter is how long a host fails before its deliveries are dead-lettered.
+	DeadAfter time.Duration
+	// Refuse, when set, tells the hosts nothing is delivered to, their deliveries are
+	// dead-lettered.
+	Refuse func(host string) bool
+}
+
+// DefaultOptions returns the options the station runs the queue with.
+func DefaultOptions() Options {
+	return Options{
+		Batch:            100,
+		Workers:          16,
+		PerHost:          2,
+		Lease:            5 * time.Minute,
+		MaxAttempts:      12,
+		BaseBackoff:      30 * time.Second,
+		MaxBackoff:       12 * time.Hour,
+		BreakerThreshold: 5,
+		BreakerCooldown:  5 * time.Minute,
+		DeadAfter:        7 * 24 * time.Hour,
+	}
+}
+
+// Queue delivers the queued deliveries of a Store.
+type Queue struct {
+	store   Store
+	client  *http.Client
+	sign    Signer
+	resolve Resolver
+	opts    Options
+	now     func() time.Time
+
+	// mu serializes the updates of instances
+	mu sync.Mutex
+}
+
+// NewQueue creates a queue posting with the client.
+func NewQueue(store Store, client *http.Client, sign Signer, resolve Resolver, opts Options) *Queue {
+	def := DefaultOptions()
+	if opts.Batch <= 0 {
+		opts.Batch = def.Batch
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = def.Workers
+	}
+	if opts.PerHost <= 0 {
+		opts.PerHost = def.PerHost
+	}
+	if opts.Lease <= 0 {
+		opts.Lease = def.Lease
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = def.MaxAttempts
+	}
+	if opts.BaseBackoff <= 0 {
+		opts.BaseBackoff = def.BaseBackoff
+	}
+	if opts.MaxBackoff < opts.BaseBackoff {
+		opts.MaxBackoff = opts.BaseBackoff
+	}
+	if opts.BreakerThreshold <= 0 {
+		opts.BreakerThreshold = def.BreakerThreshold
+	}
+	if opts.BreakerCooldown <= 0 {
+		opts.BreakerCooldown = def.BreakerCooldown
+	}
+	if opts.DeadAfter <= 0 {
+		opts.DeadAfter = def.DeadAfter
+	}
+	return &Queue{store: store, client: client, sign: sign, resolve: resolve, opts: opts, now: time.Now}
+}
+
+// Run attempts the due deliveries once and returns how many were delivered.
+func (q *Queue) Run(ctx context.Context) (int, error) {
+	now := q.now()
+	due, err := q.store.Claim(ctx, now, q.opts.Batch, now.Add(q.opts.Lease))
+	if err != nil || len(due) == 0 {
+		return 0, err
+	}
+
+	workers := make(chan struct{}, q.opts.Workers)
+	hosts := map[string]chan struct{}{}
+	var delivered atomic.Int64
+	var wg sync.WaitGroup
+	for _, d := range due {
+		host := hosts[d.Host]
+		if host == nil {
+			host = make(chan struct{}, q.opts.PerHost)
+			hosts[d.Host] = host
+		}
+		wg.Add(1)
+		go func(d *db.ActivityPubDelivery) {
+			defer wg.Done()
+			host <- struct{}{}
+			defer func() { <-host }()
+			workers <- struct{}{}
+			defer func() { <-workers }()
+
+			ok, err := q.deliver(ctx, d)
+			if err != nil {
+				log.Warnf(ctx, "Delivery of %s to %s failed: %v", d.ActivityID, d.Target, err)
+			}
+			if ok {
+				delivered.Add(1)
+			}
+		}(d)
+	}
+	wg.Wait()
+	return int(delivered.Load()), nil
+}
+
+// deliver attempts one delivery and records how it went.
+func (q *Queue) deliver(ctx context.Context, d *db.ActivityPubDelivery) (bool, error) {
+	if q.opts.Refuse != nil && q.opts.Refuse(d.Host) {
+		return false, q.store.DeadLetter(ctx, d, ErrRefused.Error())
+	}
+	inst, err := q.instance(ctx, d.Host)
+	if err != nil {
+		return false, err
+	}
+	// the circuit may have opened since the delivery was claimed
+	if now := q.now(); inst.OpenUntil != nil && inst.OpenUntil.After(now) {
+		d.NextAttempt = *inst.OpenUntil
+		return false, q.store.Retry(ctx, d)
+	}
+
+	if d.Inbox == "" {
+		inbox, err := q.resolve(ctx, d.Target, d.Shared)
+		if errors.Is(err, ErrRecipientGone) || errors.Is(err, ErrRefused) {
+			return false, q.store.DeadLetter(ctx, d, err.Error())
+		}
+		if err != nil {
+			return false, q.failed(ctx, d, fmt.Errorf("resolve inbox: %w", err))
+		}
+		d.Inbox = inbox
+		if ok, err := q.store.Resolved(ctx, d); err != nil || !ok {
+			return false, err
+		}
+	}
+	// an actor on an allowed host may have its inbox on a refused one
+	if q.opts.Refuse != nil {
+		if u, err := url.Parse(d.Inbox); err == nil && q.opts.Refuse(u.Host) {
+			return false, q.store.DeadLetter(ctx, d, ErrRefused.Error())
+		}
+	}
+
+	body, err := q.store.Payload(ctx, d.ActivityID)
+	if errors.Is(err, ErrActivityGone) {
+		return false, q.store.DeadLetter(ctx, d, err.Error())
+	}
+	if err != nil {
+		return false, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.Inbox, bytes.NewReader(body))
+	if err != nil {
+		return false, q.store.DeadLetter(ctx, d, err.Error())
+	}
+	req.Header.Set("Content-Type", ContentType)
+	if err := q.sign(req, d.SenderID, body); err != nil {
+		return false, q.store.DeadLetter(ctx, d, fmt.Sprintf("sign: %v", err))
+	}
+
+	resp, err := q.client.Do(req)
+	if err != nil {
+		return false, q.failed(ctx, d, err)
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseBytes))
+	_ = resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		if err := q.hostSucceeded(ctx, d.Host); err != nil {
+			return false, err
+		}
+		d.Attempts++
+		return true, q.store.Delivered(ctx, d)
+	case permanent(resp.StatusCode):
+		// the host answers, it just does not want the activity
+		if err := q.hostSucceeded(ctx, d.Host); err != nil {
+			return false, err
+		}
+		d.Attempts++
+		return false, q.store.DeadLetter(ctx, d, fmt.Sprintf("inbox answered %s", resp.Status))
+	default:
+		return false, q.failed(ctx, d, fmt.Errorf("inbox answered %s", resp.Status))
+	}
+}
+
+// failed records a failed attempt, retrying the delivery later or giving up on it.
+func (q *Queue) failed(ctx context.Context, d *db.ActivityPubDelivery, cause error) error {
+	now := q.now()
+	d.Attempts++
+	d.LastError = cause.Error()
+	inst, err := q.hostFailed(ctx, d.Host, cause)
+	if err != nil {
+		return err
+	}
+	if d.Attempts >= q.opts.MaxAttempts {
+		return q.store.DeadLetter(ctx, d, fmt.Sprintf("gave up after %d attempts: %v", d.Attempts, cause))
+	}
+	if inst.FirstFailure != nil && now.Sub(*inst.FirstFailure) >= q.opts.DeadAfter {
+		return q.store.DeadLetter(ctx, d, fmt.Sprintf("%s failing since %s: %v", d.Host, inst.FirstFailure.Format(time.RFC3339), cause))
+	}
+
+	d.NextAttempt = now.Add(Backoff(d.Attempts, q.opts.BaseBackoff, q.opts.MaxBackoff))
+	if inst.OpenUntil != nil && inst.OpenUntil.After(d.NextAttempt) {
+		d.NextAttempt = *inst.OpenUntil
+	}
+	return q.store.Retry(ctx, d)
+}
+
+func (q *Queue) instance(ctx context.Context, host string) (*db.ActivityPubInstance, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.store.Instance(ctx, host)
+}
+
+// hostFailed counts a failure of the host, opening its circuit once it failed
+// BreakerThreshold times in a row.
+func (q *Queue) hostFailed(ctx context.Context, host string, cause error) (*db.ActivityPubInstance, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	inst, err := q.store.Instance(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	now := q.now()
+	inst.Failures++
+	inst.LastError = cause.Error()
+	if inst.FirstFailure == nil {
+		inst.FirstFailure = &now
+	}
+	if over := inst.Failures - q.opts.BreakerThreshold; over >= 0 {
+		until := now.Add(Backoff(over+1, q.opts.BreakerCooldown, q.opts.MaxBackoff))
+		inst.OpenUntil = &until
+	}
+	return inst, q.store.SaveInstance(ctx, inst)
+}
+
+// hostSucceeded closes the circuit of a host that answered.
+func (q *Queue) hostSucceeded(ctx context.Context, host string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	inst, err := q.store.Instance(ctx, host)
+	if err != nil {
+		return err
+	}
+	now := q.now()
+	// healthy hosts are written at most once a minute
+	if inst.Failures == 0 && inst.OpenUntil == nil && inst.LastSuccess != nil && now.Sub(*inst.LastSuccess) < time.Minute {
+		return nil
+	}
+	inst.Failures = 0
+	inst.FirstFailure = nil
+	inst.OpenUntil = nil
+	inst.LastError = ""
+	inst.LastSuccess = &now
+	return q.store.SaveInstance(ctx, inst)
+}
+
+// Backoff returns the wait before the next attempt: base, doubling with every attempt
+// up to max.
+func Backoff(attempt int, base, max time.Duration) time.Duration {
+	d := base
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		return max
+	}
+	return d
+}
+
+// permanent tells whether a response status means retrying will not help.
+func permanent(status int) bool {
+	return status >= 400 && status < 500 && status != http.StatusRequestTimeout && status != http.StatusTooManyRequests
+}
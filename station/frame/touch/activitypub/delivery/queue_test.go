@@ -0,0 +1,357 @@
+package delivery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+)
+
+// memStore is a Store in memory, it claims like the RDS one.
+type memStore struct {
+	mu         sync.Mutex
+	deliveries map[uint64]*db.ActivityPubDelivery
+	dead       map[uint64]string
+	instances  map[string]*db.ActivityPubInstance
+}
+
+func newMemStore(ds ...*db.ActivityPubDelivery) *memStore {
+	s := &memStore{deliveries: map[uint64]*db.ActivityPubDelivery{}, dead: map[uint64]string{}, instances: map[string]*db.ActivityPubInstance{}}
+	for i, d := range ds {
+		d.ID = uint64(i + 1)
+		d.Status = db.DeliveryPending
+		if d.Host == "" {
+			u, _ := url.Parse(d.Target)
+			d.Host = u.Host
+		}
+		s.deliveries[d.ID] = d
+	}
+	return s
+}
+
+func (s *memStore) Claim(_ context.Context, now time.Time, limit int, lease time.Time) ([]*db.ActivityPubDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []*db.ActivityPubDelivery
+	for _, d := range s.deliveries {
+		inst := s.instances[d.Host]
+		if d.Status != db.DeliveryPending || d.NextAttempt.After(now) || (inst != nil && inst.OpenUntil != nil && inst.OpenUntil.After(now)) {
+			continue
+		}
+		if len(out) == limit {
+			break
+		}
+		d.NextAttempt = lease
+		c := *d
+		out = append(out, &c)
+	}
+	return out, nil
+}
+
+func (s *memStore) Payload(context.Context, string) ([]byte, error) {
+	return []byte(`{"type":"Create"}`), nil
+}
+
+func (s *memStore) Resolved(_ context.Context, d *db.ActivityPubDelivery) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, other := range s.deliveries {
+		if other.ID != d.ID && other.ActivityID == d.ActivityID && other.Target == d.Inbox {
+			delete(s.deliveries, d.ID)
+			return false, nil
+		}
+	}
+	d.Target = d.Inbox
+	s.save(d)
+	return true, nil
+}
+
+func (s *memStore) Delivered(_ context.Context, d *db.ActivityPubDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d.Status = db.DeliveryDelivered
+	s.save(d)
+	return nil
+}
+
+func (s *memStore) Retry(_ context.Context, d *db.ActivityPubDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.save(d)
+	return nil
+}
+
+func (s *memStore) DeadLetter(_ context.Context, d *db.ActivityPubDelivery, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.deliveries, d.ID)
+	s.dead[d.ID] = reason
+	return nil
+}
+
+func (s *memStore) Instance(_ context.Context, host string) (*db.ActivityPubInstance, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if inst, ok := s.instances[host]; ok {
+		c := *inst
+		return &c, nil
+	}
+	return &db.ActivityPubInstance{Host: host}, nil
+}
+
+func (s *memStore) SaveInstance(_ context.Context, inst *db.ActivityPubInstance) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := *inst
+	s.instances[inst.Host] = &c
+	return nil
+}
+
+func (s *memStore) save(d *db.ActivityPubDelivery) {
+	c := *d
+	s.deliveries[d.ID] = &c
+}
+
+func (s *memStore) get(id uint64) *db.ActivityPubDelivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.deliveries[id]
+}
+
+// inbox stands in for a remote inbox answering with the status of status.
+type inbox struct {
+	*httptest.Server
+	status   atomic.Int32
+	received atomic.Int32
+	inFlight atomic.Int32
+	maxSeen  atomic.Int32
+	delay    time.Duration
+}
+
+func newInbox(t *testing.T, status int) *inbox {
+	in := &inbox{}
+	in.status.Store(int32(status))
+	in.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := in.inFlight.Add(1)
+		defer in.inFlight.Add(-1)
+		for {
+			m := in.maxSeen.Load()
+			if n <= m || in.maxSeen.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(in.delay)
+		if r.Header.Get("Signature") == "" || r.Header.Get("Content-Type") != ContentType {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		if !json.Valid(b) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		in.received.Add(1)
+		w.WriteHeader(int(in.status.Load()))
+	}))
+	t.Cleanup(in.Close)
+	return in
+}
+
+func testQueue(s Store, opts Options, resolve Resolver) *Queue {
+	sign := func(req *http.Request, sender string, _ []byte) error {
+		req.Header.Set("Signature", `keyId="`+sender+`#main-key"`)
+		return nil
+	}
+	return NewQueue(s, http.DefaultClient, sign, resolve, opts)
+}
+
+func TestQueueDelivers(t *testing.T) {
+	in := newInbox(t, http.StatusAccepted)
+	s := newMemStore(
+		&db.ActivityPubDelivery{ActivityID: "a1", SenderID: "alice", Target: in.URL + "/inbox", Inbox: in.URL + "/inbox"},
+		&db.ActivityPubDelivery{ActivityID: "a1", SenderID: "alice", Target: "https://remote.example/bob"},
+	)
+	resolve := func(_ context.Context, iri string, shared bool) (string, error) {
+		if iri != "https://remote.example/bob" {
+			return "", errors.New("unknown actor")
+		}
+		return in.URL + "/users/bob/inbox", nil
+	}
+	n, err := testQueue(s, Options{}, resolve).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || in.received.Load() != 2 {
+		t.Fatalf("delivered %d, received %d", n, in.received.Load())
+	}
+	if d := s.get(2); d.Status != db.DeliveryDelivered || d.Target != in.URL+"/users/bob/inbox" {
+		t.Fatalf("resolved delivery %+v", d)
+	}
+}
+
+func TestQueueRetriesWithBackoff(t *testing.T) {
+	in := newInbox(t, http.StatusServiceUnavailable)
+	s := newMemStore(&db.ActivityPubDelivery{ActivityID: "a1", SenderID: "alice", Target: in.URL + "/inbox", Inbox: in.URL + "/inbox"})
+	q := testQueue(s, Options{BaseBackoff: time.Minute, MaxBackoff: time.Hour, BreakerThreshold: 10}, nil)
+	now := time.Now()
+	q.now = func() time.Time { return now }
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		if _, err := q.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		d := s.get(1)
+		want := now.Add(Backoff(attempt, time.Minute, time.Hour))
+		if d.Attempts != attempt || !d.NextAttempt.Equal(want) {
+			t.Fatalf("attempt %d: attempts %d next in %v", attempt, d.Attempts, d.NextAttempt.Sub(now))
+		}
+		now = d.NextAttempt
+	}
+
+	// the instance recovers, the next run delivers
+	in.status.Store(http.StatusOK)
+	if n, _ := q.Run(context.Background()); n != 1 {
+		t.Fatalf("delivered %d after recovery", n)
+	}
+	if inst, _ := s.Instance(context.Background(), s.get(1).Host); inst.Failures != 0 || inst.LastSuccess == nil {
+		t.Fatalf("instance not reset: %+v", inst)
+	}
+}
+
+func TestQueueCircuitBreaker(t *testing.T) {
+	in := newInbox(t, http.StatusBadGateway)
+	s := newMemStore(
+		&db.ActivityPubDelivery{ActivityID: "a1", SenderID: "alice", Target: in.URL + "/1", Inbox: in.URL + "/1"},
+		&db.ActivityPubDelivery{ActivityID: "a2", SenderID: "alice", Target: in.URL + "/2", Inbox: in.URL + "/2"},
+	)
+	opts := Options{PerHost: 1, BaseBackoff: time.Second, MaxBackoff: time.Hour, BreakerThreshold: 1, BreakerCooldown: time.Hour, DeadAfter: 24 * time.Hour}
+	q := testQueue(s, opts, nil)
+	now := time.Now()
+	q.now = func() time.Time { return now }
+
+	if _, err := q.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// the first failure opens the circuit, the other delivery waits without an attempt
+	if got := in.received.Load(); got != 1 {
+		t.Fatalf("host received %d requests with its circuit open", got)
+	}
+	inst, _ := s.Instance(context.Background(), s.get(1).Host)
+	if inst.OpenUntil == nil || !inst.OpenUntil.Equal(now.Add(time.Hour)) {
+		t.Fatalf("circuit not open: %+v", inst)
+	}
+	if due, _ := s.Claim(context.Background(), now.Add(30*time.Minute), 10, now.Add(time.Hour)); len(due) != 0 {
+		t.Fatalf("claimed %d deliveries of an open host", len(due))
+	}
+
+	// a host failing for longer than DeadAfter is given up on
+	now = now.Add(25 * time.Hour)
+	if _, err := q.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.dead) == 0 {
+		t.Fatal("no dead letters for a dead host")
+	}
+}
+
+func TestQueueDeadLettersRejected(t *testing.T) {
+	in := newInbox(t, http.StatusGone)
+	s := newMemStore(&db.ActivityPubDelivery{ActivityID: "a1", SenderID: "alice", Target: in.URL + "/inbox", Inbox: in.URL + "/inbox"})
+	if _, err := testQueue(s, Options{}, nil).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if s.get(1) != nil || s.dead[1] == "" {
+		t.Fatalf("rejected delivery not dead-lettered: %v", s.dead)
+	}
+	if inst, _ := s.Instance(context.Background(), in.Listener.Addr().String()); inst.Failures != 0 {
+		t.Fatalf("a rejection counted against the host: %+v", inst)
+	}
+}
+
+func TestQueueDeadLettersRefusedHosts(t *testing.T) {
+	in := newInbox(t, http.StatusAccepted)
+	s := newMemStore(&db.ActivityPubDelivery{ActivityID: "a1", SenderID: "alice", Target: in.URL + "/inbox", Inbox: in.URL + "/inbox"})
+	opts := Options{Refuse: func(host string) bool { return host == in.Listener.Addr().String() }}
+	if _, err := testQueue(s, opts, nil).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if in.received.Load() != 0 || s.dead[1] != ErrRefused.Error() {
+		t.Fatalf("refused host received %d, dead letters %v", in.received.Load(), s.dead)
+	}
+}
+
+func TestQueueDeadLettersInboxesOnRefusedHosts(t *testing.T) {
+	in := newInbox(t, http.StatusAccepted)
+	// the actor is on an allowed host, its shared inbox on the refused one
+	s := newMemStore(&db.ActivityPubDelivery{ActivityID: "a1", SenderID: "alice", Target: "https://allowed.example/users/bob", Shared: true})
+	resolve := func(context.Context, string, bool) (string, error) { return in.URL + "/inbox", nil }
+	opts := Options{Refuse: func(host string) bool { return host == in.Listener.Addr().String() }}
+	if _, err := testQueue(s, opts, resolve).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if in.received.Load() != 0 || s.dead[1] != ErrRefused.Error() {
+		t.Fatalf("refused inbox received %d, dead letters %v", in.received.Load(), s.dead)
+	}
+}
+
+func TestQueuePerHostLimit(t *testing.T) {
+	in := newInbox(t, http.StatusAccepted)
+	in.delay = 20 * time.Millisecond
+	var ds []*db.ActivityPubDelivery
+	for i := 0; i < 8; i++ {
+		target := in.URL + "/inbox/" + string(rune('a'+i))
+		ds = append(ds, &db.ActivityPubDelivery{ActivityID: "a1", SenderID: "alice", Target: target, Inbox: target})
+	}
+	s := newMemStore(ds...)
+	n, err := testQueue(s, Options{PerHost: 2}, nil).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 8 {
+		t.Fatalf("delivered %d", n)
+	}
+	if m := in.maxSeen.Load(); m > 2 {
+		t.Fatalf("%d concurrent requests to one host", m)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for _, c := range []struct {
+		attempt int
+		want    time.Duration
+	}{{0, time.Second}, {1, time.Second}, {2, 2 * time.Second}, {4, 8 * time.Second}, {10, 10 * time.Second}} {
+		if got := Backoff(c.attempt, time.Second, 10*time.Second); got != c.want {
+			t.Errorf("Backoff(%d) = %v, want %v", c.attempt, got, c.want)
+		}
+	}
+}
+
+func TestPayloadStripsBlindCopies(t *testing.T) {
+	b, err := payload(`{"id":"https://a.example/1","type":"Create","bto":["x"],"bcc":["y"],"object":{"type":"Note","bcc":["y"]}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]interface{}
+	_ = json.Unmarshal(b, &doc)
+	if _, ok := doc["bto"]; ok {
+		t.Fatal("bto delivered")
+	}
+	if _, ok := doc["bcc"]; ok {
+		t.Fatal("bcc delivered")
+	}
+	if _, ok := doc["object"].(map[string]interface{})["bcc"]; ok {
+		t.Fatal("bcc of the object delivered")
+	}
+	if doc["@context"] != "https://www.w3.org/ns/activitystreams" {
+		t.Fatalf("context %v", doc["@context"])
+	}
+}
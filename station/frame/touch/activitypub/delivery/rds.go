@@ -0,0 +1,142 @@
+package delivery
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"time"
+
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+	ap "github.com/peers-touch/peers-touch/station/frame/vendors/activitypub"
+	"gorm.io/gorm"
+)
+
+// rdsStore keeps the queue in the activitypub_deliveries, activitypub_dead_letters and
+// activitypub_instances tables.
+type rdsStore struct {
+	rds *gorm.DB
+}
+
+// NewRDSStore returns the Store kept in the RDS.
+func NewRDSStore(rds *gorm.DB) Store {
+	return &rdsStore{rds: rds}
+}
+
+func (s *rdsStore) Claim(ctx context.Context, now time.Time, limit int, lease time.Time) ([]*db.ActivityPubDelivery, error) {
+	open := s.rds.Model(&db.ActivityPubInstance{}).Select("host").Where("open_until > ?", now)
+	var rows []*db.ActivityPubDelivery
+	err := s.rds.WithContext(ctx).Where("status = ? AND next_attempt <= ? AND host NOT IN (?)", db.DeliveryPending, now, open).
+		Order("next_attempt").Limit(limit).Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	claimed := rows[:0]
+	for _, d := range rows {
+		// another run may have taken it meanwhile, the lease goes to whoever moves it first
+		res := s.rds.WithContext(ctx).Model(&db.ActivityPubDelivery{}).
+			Where("id = ? AND next_attempt = ?", d.ID, d.NextAttempt).Update("next_attempt", lease)
+		if res.Error != nil {
+			return nil, res.Error
+		}
+		if res.RowsAffected == 1 {
+			d.NextAttempt = lease
+			claimed = append(claimed, d)
+		}
+	}
+	return claimed, nil
+}
+
+func (s *rdsStore) Payload(ctx context.Context, activityID string) ([]byte, error) {
+	var row db.ActivityPubActivity
+	if err := s.rds.WithContext(ctx).Where("activity_pub_id = ?", activityID).Limit(1).Find(&row).Error; err != nil {
+		return nil, err
+	}
+	if row.ID == 0 || row.Content == "" {
+		return nil, ErrActivityGone
+	}
+	return payload(row.Content)
+}
+
+func (s *rdsStore) Resolved(ctx context.Context, d *db.ActivityPubDelivery) (bool, error) {
+	var count int64
+	err := s.rds.WithContext(ctx).Model(&db.ActivityPubDelivery{}).
+		Where("activity_id = ? AND target = ? AND id <> ?", d.ActivityID, d.Inbox, d.ID).Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return false, s.rds.WithContext(ctx).Delete(&db.ActivityPubDelivery{}, d.ID).Error
+	}
+	d.Target = d.Inbox
+	if u, err := url.Parse(d.Inbox); err == nil && u.Host != "" {
+		d.Host = u.Host
+	}
+	return true, s.rds.WithContext(ctx).Model(d).Updates(map[string]interface{}{"target": d.Target, "inbox": d.Inbox, "host": d.Host}).Error
+}
+
+func (s *rdsStore) Delivered(ctx context.Context, d *db.ActivityPubDelivery) error {
+	now := time.Now()
+	d.Status = db.DeliveryDelivered
+	d.DeliveredAt = &now
+	return s.rds.WithContext(ctx).Model(d).Updates(map[string]interface{}{
+		"status": d.Status, "attempts": d.Attempts, "delivered_at": d.DeliveredAt, "last_error": "",
+	}).Error
+}
+
+func (s *rdsStore) Retry(ctx context.Context, d *db.ActivityPubDelivery) error {
+	return s.rds.WithContext(ctx).Model(d).Updates(map[string]interface{}{
+		"attempts": d.Attempts, "next_attempt": d.NextAttempt, "last_error": d.LastError,
+	}).Error
+}
+
+func (s *rdsStore) DeadLetter(ctx context.Context, d *db.ActivityPubDelivery, reason string) error {
+	return s.rds.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(&db.ActivityPubDeadLetter{
+			ID:         d.ID,
+			ActivityID: d.ActivityID,
+			SenderID:   d.SenderID,
+			Target:     d.Target,
+			Inbox:      d.Inbox,
+			Host:       d.Host,
+			Attempts:   d.Attempts,
+			Reason:     reason,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Delete(&db.ActivityPubDelivery{}, d.ID).Error
+	})
+}
+
+func (s *rdsStore) Instance(ctx context.Context, host string) (*db.ActivityPubInstance, error) {
+	var inst db.ActivityPubInstance
+	if err := s.rds.WithContext(ctx).Where("host = ?", host).Limit(1).Find(&inst).Error; err != nil {
+		return nil, err
+	}
+	inst.Host = host
+	return &inst, nil
+}
+
+func (s *rdsStore) SaveInstance(ctx context.Context, inst *db.ActivityPubInstance) error {
+	return s.rds.WithContext(ctx).Select("*").Save(inst).Error
+}
+
+// payload prepares a stored activity for delivery: bto and bcc, of the activity and of
+// its object, never leave the station and the document gets its JSON-LD context.
+func payload(content string) ([]byte, error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal([]byte(content), &doc); err != nil {
+		return nil, err
+	}
+	delete(doc, "bto")
+	delete(doc, "bcc")
+	if object, ok := doc["object"].(map[string]interface{}); ok {
+		delete(object, "bto")
+		delete(object, "bcc")
+	}
+	if _, ok := doc["@context"]; !ok {
+		doc["@context"] = ap.ActivityBaseURI.String()
+	}
+	return json.Marshal(doc)
+}
@@ -0,0 +1,359 @@
+// Package httpsig signs and verifies HTTP requests with the draft-cavage HTTP Signatures
+// the fediverse uses: rsa-sha256 over (request-target), host, date and digest.
+package httpsig
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// AlgorithmRSASHA256 is the algorithm outgoing requests are signed with.
+	AlgorithmRSASHA256 = "rsa-sha256"
+	// AlgorithmHS2019 leaves the algorithm to the key, only RSA keys are supported.
+	AlgorithmHS2019 = "hs2019"
+
+	RequestTarget = "(request-target)"
+	created       = "(created)"
+	expires       = "(expires)"
+
+	keyBits = 2048
+)
+
+var (
+	ErrMissing       = errors.New("httpsig: signature header missing")
+	ErrMalformed     = errors.New("httpsig: malformed signature header")
+	ErrAlgorithm     = errors.New("httpsig: unsupported algorithm")
+	ErrHeaders       = errors.New("httpsig: required header not signed")
+	ErrDate          = errors.New("httpsig: date outside the allowed clock skew")
+	ErrDigest        = errors.New("httpsig: digest does not match the body")
+	ErrBadSignature  = errors.New("httpsig: signature does not verify")
+	ErrKey           = errors.New("httpsig: invalid key")
+	ErrMissingHeader = errors.New("httpsig: signed header missing from the request")
+)
+
+// SignedHeaders are the headers outgoing requests sign, requests with a body sign
+// digest too.
+var SignedHeaders = []string{RequestTarget, "host", "date"}
+
+// Signature is a parsed Signature header.
+type Signature struct {
+	KeyID     string
+	Algorithm string
+	Headers   []string
+	Signature []byte
+	Created   int64
+	Expires   int64
+}
+
+// Parse parses the value of a Signature header, or of an Authorization header with the
+// Signature scheme.
+func Parse(v string) (*Signature, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return nil, ErrMissing
+	}
+	if len(v) > 10 && strings.EqualFold(v[:10], "signature ") {
+		v = v[10:]
+	}
+	s := &Signature{Headers: []string{created}}
+	for _, part := range splitParams(v) {
+		k, val, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, ErrMalformed
+		}
+		k = strings.ToLower(strings.TrimSpace(k))
+		val = strings.Trim(strings.TrimSpace(val), `"`)
+		switch k {
+		case "keyid":
+			s.KeyID = val
+		case "algorithm":
+			s.Algorithm = strings.ToLower(val)
+		case "headers":
+			s.Headers = strings.Fields(strings.ToLower(val))
+		case "signature":
+			b, err := base64.StdEncoding.DecodeString(val)
+			if err != nil {
+				return nil, ErrMalformed
+			}
+			s.Signature = b
+		case "created", "expires":
+			n, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return nil, ErrMalformed
+			}
+			if k == "created" {
+				s.Created = n
+			} else {
+				s.Expires = n
+			}
+		}
+	}
+	if s.KeyID == "" || len(s.Signature) == 0 || len(s.Headers) == 0 {
+		return nil, ErrMalformed
+	}
+	return s, nil
+}
+
+// String formats the signature as a Signature header value.
+func (s *Signature) String() string {
+	return fmt.Sprintf(`keyId="%s",algorithm="%s",headers="%s",signature="%s"`, s.KeyID, s.Algorithm, strings.Join(s.Headers, " "), base64.StdEncoding.EncodeToString(s.Signature))
+}
+
+// SigningString builds the string signed over the headers of a request.
+func (s *Signature) SigningString(method, target string, header http.Header) (string, error) {
+	lines := make([]string, 0, len(s.Headers))
+	for _, h := range s.Headers {
+		var v string
+		switch h {
+		case RequestTarget:
+			v = strings.ToLower(method) + " " + target
+		case created:
+			if s.Created == 0 {
+				return "", ErrMissingHeader
+			}
+			v = strconv.FormatInt(s.Created, 10)
+		case expires:
+			if s.Expires == 0 {
+				return "", ErrMissingHeader
+			}
+			v = strconv.FormatInt(s.Expires, 10)
+		default:
+			values := header.Values(h)
+			if len(values) == 0 {
+				return "", fmt.Errorf("%w: %s", ErrMissingHeader, h)
+			}
+			v = strings.Join(values, ", ")
+		}
+		lines = append(lines, h+": "+strings.TrimSpace(v))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// Verify checks the signature against the request with the public key of KeyID.
+func (s *Signature) Verify(key crypto.PublicKey, method, target string, header http.Header) error {
+	if s.Algorithm != "" && s.Algorithm != AlgorithmRSASHA256 && s.Algorithm != AlgorithmHS2019 {
+		return ErrAlgorithm
+	}
+	pub, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return ErrAlgorithm
+	}
+	str, err := s.SigningString(method, target, header)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256([]byte(str))
+	if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], s.Signature); err != nil {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// Has tells whether the signature covers the header.
+func (s *Signature) Has(h string) bool {
+	for _, v := range s.Headers {
+		if v == h {
+			return true
+		}
+	}
+	return false
+}
+
+// Options tell Verify what a request has to carry.
+type Options struct {
+	// MaxSkew is how far the Date header may be off from Now.
+	MaxSkew time.Duration
+	Now     time.Time
+}
+
+// VerifyRequest checks that a request signs (request-target), host and date, and digest
+// when it has a body, that the date is within the skew and the digest matches the body,
+// and that the signature verifies with the key lookup returns for its keyId.
+func VerifyRequest(method, target string, header http.Header, body []byte, opts Options, lookup func(keyID string) (crypto.PublicKey, error)) (*Signature, error) {
+	v := header.Get("Signature")
+	if v == "" {
+		v = header.Get("Authorization")
+	}
+	s, err := Parse(v)
+	if err != nil {
+		return nil, err
+	}
+	required := SignedHeaders
+	if len(body) > 0 || method == http.MethodPost {
+		required = append(required[:len(required):len(required)], "digest")
+	}
+	for _, h := range required {
+		if !s.Has(h) {
+			return s, fmt.Errorf("%w: %s", ErrHeaders, h)
+		}
+	}
+
+	date, err := http.ParseTime(header.Get("Date"))
+	if err != nil {
+		return s, ErrDate
+	}
+	now := opts.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	if d := now.Sub(date); d > opts.MaxSkew || d < -opts.MaxSkew {
+		return s, ErrDate
+	}
+	if s.Expires > 0 && now.Unix() > s.Expires {
+		return s, ErrDate
+	}
+	if s.Has("digest") {
+		if err := CheckDigest(header.Get("Digest"), body); err != nil {
+			return s, err
+		}
+	}
+
+	key, err := lookup(s.KeyID)
+	if err != nil {
+		return s, err
+	}
+	return s, s.Verify(key, method, target, header)
+}
+
+// Sign signs a request with the key. It sets Date and Host when missing and Digest
+// for the body, which is what the request sends.
+func Sign(req *http.Request, body []byte, keyID string, key *rsa.PrivateKey) error {
+	if req.Header.Get("Date") == "" {
+		req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	}
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	req.Header.Set("Host", host)
+	headers := append([]string(nil), SignedHeaders...)
+	if len(body) > 0 || req.Method == http.MethodPost {
+		req.Header.Set("Digest", Digest(body))
+		headers = append(headers, "digest")
+	}
+
+	s := &Signature{KeyID: keyID, Algorithm: AlgorithmRSASHA256, Headers: headers}
+	str, err := s.SigningString(req.Method, req.URL.RequestURI(), req.Header)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256([]byte(str))
+	if s.Signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:]); err != nil {
+		return err
+	}
+	req.Header.Set("Signature", s.String())
+	return nil
+}
+
+// Digest returns the Digest header value of the body.
+func Digest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "SHA-256=" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// CheckDigest checks a Digest header against the body, its SHA-256 value has to match.
+func CheckDigest(v string, body []byte) error {
+	sum := sha256.Sum256(body)
+	for _, part := range strings.Split(v, ",") {
+		alg, val, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok || !strings.EqualFold(alg, "sha-256") {
+			continue
+		}
+		got, err := base64.StdEncoding.DecodeString(val)
+		if err != nil || string(got) != string(sum[:]) {
+			return ErrDigest
+		}
+		return nil
+	}
+	return ErrDigest
+}
+
+// GenerateKey generates an RSA key pair, PEM encoded as PKIX public and PKCS8 private key.
+func GenerateKey() (publicPEM, privatePEM string, err error) {
+	key, err := rsa.GenerateKey(rand.Reader, keyBits)
+	if err != nil {
+		return "", "", err
+	}
+	pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return "", "", err
+	}
+	priv, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub})), string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: priv})), nil
+}
+
+// ParsePublicKey parses a PEM encoded PKIX or PKCS1 RSA public key.
+func ParsePublicKey(s string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(s))
+	if block == nil {
+		return nil, ErrKey
+	}
+	if block.Type == "RSA PUBLIC KEY" {
+		key, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return nil, ErrKey
+		}
+		return key, nil
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, ErrKey
+	}
+	return key, nil
+}
+
+// ParsePrivateKey parses a PEM encoded PKCS8 or PKCS1 RSA private key.
+func ParsePrivateKey(s string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(s))
+	if block == nil {
+		return nil, ErrKey
+	}
+	if block.Type == "RSA PRIVATE KEY" {
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, ErrKey
+		}
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, ErrKey
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, ErrKey
+	}
+	return rsaKey, nil
+}
+
+// splitParams splits the comma separated params of a header, commas inside quotes
+// belong to the value.
+func splitParams(v string) []string {
+	var parts []string
+	quoted, start := false, 0
+	for i, r := range v {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ',' && !quoted:
+			parts = append(parts, v[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, v[start:])
+}
@@ -0,0 +1,103 @@
+package httpsig
+
+import (
+	"bytes"
+	"crypto"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func signed(t *testing.T, body string) (*http.Request, crypto.PublicKey) {
+	pubPEM, privPEM, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv, err := ParsePrivateKey(privPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := ParsePublicKey(pubPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest(http.MethodPost, "https://remote.example/activitypub/bob/inbox?x=1", bytes.NewReader([]byte(body)))
+	if err := Sign(req, []byte(body), "https://local.example/activitypub/alice/actor#main-key", priv); err != nil {
+		t.Fatal(err)
+	}
+	return req, pub
+}
+
+func TestSignVerify(t *testing.T) {
+	body := `{"type":"Follow"}`
+	req, pub := signed(t, body)
+	lookup := func(keyID string) (crypto.PublicKey, error) {
+		if keyID != "https://local.example/activitypub/alice/actor#main-key" {
+			t.Fatalf("keyId = %q", keyID)
+		}
+		return pub, nil
+	}
+	opts := Options{MaxSkew: time.Minute}
+
+	s, err := VerifyRequest(req.Method, req.URL.RequestURI(), req.Header, []byte(body), opts, lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(s.Headers, " ") != "(request-target) host date digest" {
+		t.Fatalf("signed headers %v", s.Headers)
+	}
+
+	if _, err := VerifyRequest(req.Method, req.URL.RequestURI(), req.Header, []byte(`{"type":"Like"}`), opts, lookup); !errors.Is(err, ErrDigest) {
+		t.Fatalf("tampered body: %v", err)
+	}
+	if _, err := VerifyRequest(req.Method, "/activitypub/carol/inbox", req.Header, []byte(body), opts, lookup); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("other target: %v", err)
+	}
+	opts.Now = time.Now().Add(2 * time.Minute)
+	if _, err := VerifyRequest(req.Method, req.URL.RequestURI(), req.Header, []byte(body), opts, lookup); !errors.Is(err, ErrDate) {
+		t.Fatalf("stale date: %v", err)
+	}
+}
+
+func TestVerifyRequiresHeaders(t *testing.T) {
+	body := `{}`
+	req, pub := signed(t, body)
+	s, _ := Parse(req.Header.Get("Signature"))
+	s.Headers = []string{RequestTarget, "host", "date"}
+	req.Header.Set("Signature", s.String())
+	_, err := VerifyRequest(req.Method, req.URL.RequestURI(), req.Header, []byte(body), Options{MaxSkew: time.Minute}, func(string) (crypto.PublicKey, error) { return pub, nil })
+	if !errors.Is(err, ErrHeaders) {
+		t.Fatalf("unsigned digest: %v", err)
+	}
+	req.Header.Del("Signature")
+	if _, err := VerifyRequest(req.Method, req.URL.RequestURI(), req.Header, []byte(body), Options{MaxSkew: time.Minute}, nil); !errors.Is(err, ErrMissing) {
+		t.Fatalf("no signature: %v", err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	s, err := Parse(`keyId="https://a.example/u#k",algorithm="hs2019",headers="(request-target) host date",signature="c2ln"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.KeyID != "https://a.example/u#k" || s.Algorithm != AlgorithmHS2019 || len(s.Headers) != 3 || string(s.Signature) != "sig" {
+		t.Fatalf("parsed %+v", s)
+	}
+	for _, v := range []string{`keyId="k"`, `keyId="k",signature="%%%"`, `nonsense`} {
+		if _, err := Parse(v); !errors.Is(err, ErrMalformed) {
+			t.Errorf("Parse(%q) err = %v", v, err)
+		}
+	}
+}
+
+func TestCheckDigest(t *testing.T) {
+	body := []byte("hello")
+	if err := CheckDigest("MD5=xyz, "+Digest(body), body); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckDigest(Digest([]byte("other")), body); !errors.Is(err, ErrDigest) {
+		t.Fatalf("mismatch: %v", err)
+	}
+}
@@ -0,0 +1,28 @@
+package activitypub
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	cfg "github.com/peers-touch/peers-touch/station/frame/core/config"
+	"github.com/peers-touch/peers-touch/station/frame/core/option"
+	"github.com/peers-touch/peers-touch/station/frame/core/pkg/config/source/memory"
+	"github.com/peers-touch/peers-touch/station/frame/touch/internal/storetest"
+)
+
+var config = []byte(`
+peers:
+  service:
+    server:
+      baseurl: https://station.example
+`)
+
+func TestMain(m *testing.M) {
+	if err := cfg.NewConfig(cfg.WithSources(memory.NewSource(option.WithRootCtx(context.Background()), memory.WithYAML(config)))).Init(); err != nil {
+		fmt.Fprintln(os.Stderr, "config:", err)
+		os.Exit(1)
+	}
+	storetest.Main(m)
+}
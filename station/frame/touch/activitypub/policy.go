@@ -0,0 +1,163 @@
+package activitypub
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	cfg "github.com/peers-touch/peers-touch/station/frame/core/config"
+	log "github.com/peers-touch/peers-touch/station/frame/core/logger"
+	"github.com/peers-touch/peers-touch/station/frame/core/store"
+	"github.com/peers-touch/peers-touch/station/frame/touch/activitypub/policy"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/actor"
+	ap "github.com/peers-touch/peers-touch/station/frame/vendors/activitypub"
+	"gorm.io/gorm"
+)
+
+const defaultAuditLimit = 50
+
+// federation returns the federation policy in force. The station federates only with the
+// allowed domains when peers.touch.activitypub.federation.allowlist is set.
+func federation(rds *gorm.DB) (*policy.Policy, error) {
+	allowlist := cfg.Get("peers", "touch", "activitypub", "federation", "allowlist").Bool(false)
+	return policy.Load(rds, BaseURL(), allowlist)
+}
+
+// refuser returns whether the federation policy rejects a host. Every host is refused
+// while no policy could be loaded.
+func refuser(c context.Context, rds *gorm.DB) func(host string) bool {
+	load := loader(c, rds)
+	return func(host string) bool {
+		p := load()
+		return p == nil || p.Rejects(host)
+	}
+}
+
+// restricter enforces the federation policy of their host on the objects fetched, before
+// they are stored.
+func restricter(c context.Context, rds *gorm.DB) func(ob *ap.Object) {
+	load := loader(c, rds)
+	return func(ob *ap.Object) {
+		if p := load(); p != nil {
+			p.For(string(ob.ID)).ApplyObject(ob)
+		}
+	}
+}
+
+// loader returns the federation policy in force. When the policy can't be loaded, the
+// one loaded last stays in force, nil while none was.
+func loader(c context.Context, rds *gorm.DB) func() *policy.Policy {
+	var last atomic.Pointer[policy.Policy]
+	return func() *policy.Policy {
+		p, err := federation(rds)
+		if err != nil {
+			log.Warnf(c, "Load the federation policy failed: %v", err)
+			return last.Load()
+		}
+		last.Store(p)
+		return p
+	}
+}
+
+type adminKey struct{}
+
+// WithAdmin marks the request as made by the named administrator of the station.
+func WithAdmin(c context.Context, name string) context.Context {
+	return context.WithValue(c, adminKey{}, name)
+}
+
+func adminName(c context.Context) string {
+	name, _ := c.Value(adminKey{}).(string)
+	return name
+}
+
+// GetDomainPolicies lists the federation policy of every domain, and whether the station
+// is in allowlist mode.
+func GetDomainPolicies(c context.Context, ctx *app.RequestContext) {
+	rds, ok := policyRDS(c, ctx)
+	if !ok {
+		return
+	}
+	p, err := federation(rds)
+	if err != nil {
+		failed(c, ctx, err)
+		return
+	}
+	rules, err := policy.List(rds)
+	if err != nil {
+		failed(c, ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{"allowlist": p.Allowlist(), "domains": rules})
+}
+
+// PutDomainPolicy sets the federation policy of the domain of the path, replacing the one
+// it had. The body is a policy.Rule, its reason goes to the audit.
+func PutDomainPolicy(c context.Context, ctx *app.RequestContext) {
+	rds, ok := policyRDS(c, ctx)
+	if !ok {
+		return
+	}
+	var rule policy.Rule
+	if err := json.Unmarshal(ctx.Request.Body(), &rule); err != nil {
+		failed(c, ctx, model.ErrPolicyInvalid)
+		return
+	}
+	rule.Domain = ctx.Param("domain")
+	set, err := policy.Set(rds, rule, adminName(c))
+	if err != nil {
+		failed(c, ctx, err)
+		return
+	}
+	log.Infof(c, "Federation policy of %s set by %s: %+v", set.Domain, set.UpdatedBy, set)
+	ctx.JSON(http.StatusOK, set)
+}
+
+// DeleteDomainPolicy removes the federation policy of the domain of the path, the reason
+// query goes to the audit.
+func DeleteDomainPolicy(c context.Context, ctx *app.RequestContext) {
+	rds, ok := policyRDS(c, ctx)
+	if !ok {
+		return
+	}
+	if err := policy.Remove(rds, ctx.Param("domain"), ctx.Query("reason"), adminName(c)); err != nil {
+		failed(c, ctx, err)
+		return
+	}
+	log.Infof(c, "Federation policy of %s removed by %s", ctx.Param("domain"), adminName(c))
+	ctx.SetStatusCode(http.StatusNoContent)
+}
+
+// GetPolicyAudits lists the changes of the federation policy, latest first. The domain
+// query narrows them to a domain, max_id pages to older changes and limit caps them.
+func GetPolicyAudits(c context.Context, ctx *app.RequestContext) {
+	rds, ok := policyRDS(c, ctx)
+	if !ok {
+		return
+	}
+	before, _ := strconv.ParseUint(ctx.Query("max_id"), 10, 64)
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", strconv.Itoa(defaultAuditLimit)))
+	if err != nil || limit <= 0 || limit > actor.MaxPageSize {
+		limit = defaultAuditLimit
+	}
+	audits, err := policy.Audits(rds, ctx.Query("domain"), before, limit)
+	if err != nil {
+		failed(c, ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, audits)
+}
+
+func policyRDS(c context.Context, ctx *app.RequestContext) (*gorm.DB, bool) {
+	rds, err := store.GetRDS(c)
+	if err != nil {
+		log.Errorf(c, "Failed to get database connection: %v", err)
+		ctx.JSON(http.StatusInternalServerError, "Database connection failed")
+		return nil, false
+	}
+	return rds, true
+}
@@ -0,0 +1,159 @@
+// Package policy holds the federation policy of the station: the remote domains it
+// rejects, silences, takes as unlisted or takes no media from, and, in allowlist mode,
+// the only domains it federates with. A policy of a domain covers its subdomains.
+package policy
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/peers-touch/peers-touch/station/frame/touch/model"
+	ap "github.com/peers-touch/peers-touch/station/frame/vendors/activitypub"
+)
+
+// Decision is what the policy does with a host
+type Decision struct {
+	// Reject refuses the activities of the host, fetches from it and deliveries to it
+	Reject bool
+	// RejectMedia drops the attachments and images of its objects
+	RejectMedia bool
+	// Silence keeps its posts off the public timelines
+	Silence bool
+	// ForceUnlisted takes its public posts as unlisted
+	ForceUnlisted bool
+}
+
+// Policy is the federation policy in force
+type Policy struct {
+	local     string
+	allowlist bool
+	rules     map[string]Rule
+}
+
+// New creates the policy of the station at the local host from the rules of the domains.
+// With allowlist set, the hosts no rule allows are rejected.
+func New(local string, allowlist bool, rules []Rule) *Policy {
+	p := &Policy{local: Host(local), allowlist: allowlist, rules: make(map[string]Rule, len(rules))}
+	for _, r := range rules {
+		p.rules[r.Domain] = r
+	}
+	return p
+}
+
+// Allowlist tells whether the station federates only with the allowed domains
+func (p *Policy) Allowlist() bool {
+	return p.allowlist
+}
+
+// For returns the decision for a host, or the host of an IRI, taken from the rule of the
+// host or, lacking one, of its closest parent domain. The local host is never restricted.
+func (p *Policy) For(host string) Decision {
+	host = Host(host)
+	if host == "" || host == p.local {
+		return Decision{}
+	}
+	rule, ok := p.rule(host)
+	if !ok {
+		return Decision{Reject: p.allowlist}
+	}
+	return Decision{
+		Reject:        rule.Reject || (p.allowlist && !rule.Allow),
+		RejectMedia:   rule.RejectMedia,
+		Silence:       rule.Silence,
+		ForceUnlisted: rule.ForceUnlisted,
+	}
+}
+
+// Rejects tells whether the host, or the host of an IRI, is rejected
+func (p *Policy) Rejects(host string) bool {
+	return p.For(host).Reject
+}
+
+func (p *Policy) rule(host string) (Rule, bool) {
+	for domain := host; domain != ""; {
+		if r, ok := p.rules[domain]; ok {
+			return r, true
+		}
+		_, parent, ok := strings.Cut(domain, ".")
+		if !ok {
+			break
+		}
+		domain = parent
+	}
+	return Rule{}, false
+}
+
+// Apply enforces the decision on an activity received from its host. The public
+// addressing of force-unlisted hosts moves from to to cc, so their posts are stored as
+// unlisted, and the object of the activity goes through ApplyObject. Silence changes
+// nothing in the activity, the timelines keep the posts of silenced hosts off.
+func (d Decision) Apply(a *ap.Activity) {
+	if d.ForceUnlisted {
+		unlist(&a.To, &a.CC)
+	}
+	_ = ap.OnObject(a.Object, func(ob *ap.Object) error {
+		d.ApplyObject(ob)
+		return nil
+	})
+}
+
+// ApplyObject enforces the decision on an object of its host, received or fetched. The
+// public posts of force-unlisted hosts are taken as unlisted, and with RejectMedia the
+// attachments and images of the object are dropped.
+func (d Decision) ApplyObject(ob *ap.Object) {
+	if d.ForceUnlisted {
+		unlist(&ob.To, &ob.CC)
+	}
+	if d.RejectMedia {
+		ob.Attachment, ob.Icon, ob.Image = nil, nil, nil
+	}
+}
+
+func unlist(to, cc *ap.ItemCollection) {
+	if !to.Contains(ap.PublicNS) {
+		return
+	}
+	to.Remove(ap.PublicNS)
+	if !cc.Contains(ap.PublicNS) {
+		_ = cc.Append(ap.PublicNS)
+	}
+}
+
+// Host returns the lower case host, without port, of an IRI or host
+func Host(s string) string {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "://") {
+		u, err := url.Parse(s)
+		if err != nil {
+			return ""
+		}
+		s = u.Host
+	}
+	if h, _, ok := strings.Cut(s, "/"); ok {
+		s = h
+	}
+	if i := strings.LastIndexByte(s, ':'); i >= 0 && !strings.Contains(s[i:], "]") {
+		s = s[:i]
+	}
+	return strings.TrimSuffix(strings.ToLower(s), ".")
+}
+
+// NormalizeDomain returns the domain a rule is set for, from a domain, a wildcard
+// *.domain or a URL of it.
+func NormalizeDomain(domain string) (string, error) {
+	d := strings.TrimPrefix(Host(domain), "*.")
+	if d == "" || len(d) > 253 {
+		return "", model.ErrPolicyInvalid
+	}
+	for _, label := range strings.Split(d, ".") {
+		if label == "" || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return "", model.ErrPolicyInvalid
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+				return "", model.ErrPolicyInvalid
+			}
+		}
+	}
+	return d, nil
+}
@@ -0,0 +1,120 @@
+package policy
+
+import (
+	"testing"
+
+	ap "github.com/peers-touch/peers-touch/station/frame/vendors/activitypub"
+)
+
+func TestFor(t *testing.T) {
+	rules := []Rule{
+		{Domain: "spam.example", Reject: true},
+		{Domain: "quiet.example", Silence: true, RejectMedia: true},
+		{Domain: "ok.quiet.example", Allow: true},
+		{Domain: "friends.example", Allow: true},
+	}
+	p := New("https://station.example:8443", false, rules)
+	for _, tc := range []struct {
+		host string
+		want Decision
+	}{
+		{"spam.example", Decision{Reject: true}},
+		{"https://a.b.SPAM.example:443/users/x", Decision{Reject: true}},
+		{"notspam.example", Decision{}},
+		{"quiet.example", Decision{Silence: true, RejectMedia: true}},
+		{"ok.quiet.example", Decision{}},
+		{"other.example", Decision{}},
+	} {
+		if got := p.For(tc.host); got != tc.want {
+			t.Errorf("For(%q) = %+v, want %+v", tc.host, got, tc.want)
+		}
+	}
+
+	p = New("https://station.example", true, rules)
+	for host, rejected := range map[string]bool{
+		"friends.example":          false,
+		"www.friends.example":      false,
+		"ok.quiet.example":         false,
+		"quiet.example":            true,
+		"other.example":            true,
+		"https://station.example/": false,
+	} {
+		if p.Rejects(host) != rejected {
+			t.Errorf("allowlist: Rejects(%q) = %v", host, !rejected)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	note := ap.ObjectNew(ap.NoteType)
+	note.To = ap.ItemCollection{ap.PublicNS}
+	note.CC = ap.ItemCollection{ap.IRI("https://quiet.example/users/a/followers")}
+	note.Attachment = ap.IRI("https://quiet.example/media/1.png")
+	create := ap.CreateNew("https://quiet.example/activities/1", note)
+	create.To = ap.ItemCollection{ap.PublicNS}
+
+	Decision{ForceUnlisted: true, RejectMedia: true}.Apply(create)
+	if create.To.Contains(ap.PublicNS) || !create.CC.Contains(ap.PublicNS) {
+		t.Fatalf("activity addressing = to %v cc %v", create.To, create.CC)
+	}
+	if note.To.Contains(ap.PublicNS) || !note.CC.Contains(ap.PublicNS) || len(note.CC) != 2 {
+		t.Fatalf("object addressing = to %v cc %v", note.To, note.CC)
+	}
+	if note.Attachment != nil {
+		t.Fatalf("attachment kept: %v", note.Attachment)
+	}
+}
+
+func TestApplySilence(t *testing.T) {
+	note := ap.ObjectNew(ap.NoteType)
+	note.To = ap.ItemCollection{ap.PublicNS}
+	note.Attachment = ap.IRI("https://quiet.example/media/1.png")
+	create := ap.CreateNew("https://quiet.example/activities/1", note)
+	create.To = ap.ItemCollection{ap.PublicNS}
+
+	// silenced posts stay public, the timelines leave them out
+	Decision{Silence: true}.Apply(create)
+	if !create.To.Contains(ap.PublicNS) || create.CC.Contains(ap.PublicNS) || !note.To.Contains(ap.PublicNS) || note.CC.Contains(ap.PublicNS) {
+		t.Fatalf("addressing = to %v cc %v, object to %v cc %v", create.To, create.CC, note.To, note.CC)
+	}
+	if note.Attachment == nil {
+		t.Fatal("attachment dropped")
+	}
+}
+
+func TestApplyObject(t *testing.T) {
+	note := ap.ObjectNew(ap.NoteType)
+	note.To = ap.ItemCollection{ap.PublicNS}
+	note.Attachment = ap.IRI("https://quiet.example/media/1.png")
+	note.Image = ap.IRI("https://quiet.example/media/2.png")
+
+	Decision{RejectMedia: true}.ApplyObject(note)
+	if note.Attachment != nil || note.Image != nil {
+		t.Fatalf("media kept: %v %v", note.Attachment, note.Image)
+	}
+	if !note.To.Contains(ap.PublicNS) {
+		t.Fatalf("addressing = to %v cc %v", note.To, note.CC)
+	}
+	Decision{ForceUnlisted: true}.ApplyObject(note)
+	if note.To.Contains(ap.PublicNS) || !note.CC.Contains(ap.PublicNS) {
+		t.Fatalf("unlisted addressing = to %v cc %v", note.To, note.CC)
+	}
+}
+
+func TestNormalizeDomain(t *testing.T) {
+	for in, want := range map[string]string{
+		"Example.COM":                  "example.com",
+		"*.example.com":                "example.com",
+		"https://example.com:8443/x/y": "example.com",
+		"example.com.":                 "example.com",
+		"":                             "",
+		"exa mple.com":                 "",
+		"-example.com":                 "",
+		"example..com":                 "",
+	} {
+		got, err := NormalizeDomain(in)
+		if got != want || (err == nil) != (want != "") {
+			t.Errorf("NormalizeDomain(%q) = %q, %v", in, got, err)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/peers-touch/peers-touch/station/frame/touch/model"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+	"gorm.io/gorm"
+)
+
+// cacheTTL is how long a policy loaded from the RDS is used before its rules are read
+// again, the changes made through Set and Remove are seen at once.
+const cacheTTL = time.Minute
+
+// Rule is the policy of a domain, as the management API shows and takes it
+type Rule struct {
+	Domain        string     `json:"domain"`
+	Reject        bool       `json:"reject"`
+	RejectMedia   bool       `json:"reject_media"`
+	Silence       bool       `json:"silence"`
+	ForceUnlisted bool       `json:"force_unlisted"`
+	Allow         bool       `json:"allow"`
+	Reason        string     `json:"reason,omitempty"`
+	UpdatedBy     string     `json:"updated_by,omitempty"`
+	UpdatedAt     *time.Time `json:"updated_at,omitempty"`
+}
+
+// Audit is a change of the policy of a domain
+type Audit struct {
+	ID        uint64    `json:"id,string"`
+	Domain    string    `json:"domain"`
+	Change    string    `json:"change"`
+	Previous  *Rule     `json:"previous,omitempty"`
+	Policy    *Rule     `json:"policy,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	By        string    `json:"by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+var cache struct {
+	mu        sync.Mutex
+	policy    *Policy
+	local     string
+	allowlist bool
+	expires   time.Time
+}
+
+// Load returns the policy of the station at the local host, with the rules of the RDS.
+// The policy is kept until Set or Remove change the rules, cacheTTL at most, so that
+// checking hosts doesn't read the rules every time.
+func Load(rds *gorm.DB, local string, allowlist bool) (*Policy, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if cache.policy == nil || cache.local != local || cache.allowlist != allowlist || !time.Now().Before(cache.expires) {
+		rules, err := List(rds)
+		if err != nil {
+			return nil, err
+		}
+		cache.policy, cache.local, cache.allowlist = New(local, allowlist, rules), local, allowlist
+		cache.expires = time.Now().Add(cacheTTL)
+	}
+	return cache.policy, nil
+}
+
+func invalidate() {
+	cache.mu.Lock()
+	cache.policy = nil
+	cache.mu.Unlock()
+}
+
+// List returns the rules of all domains, by domain.
+func List(rds *gorm.DB) ([]Rule, error) {
+	var rows []db.ActivityPubDomainPolicy
+	if err := rds.Order("domain").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	rules := make([]Rule, len(rows))
+	for i := range rows {
+		rules[i] = ruleOf(&rows[i])
+	}
+	return rules, nil
+}
+
+// Set stores the rule of a domain in place of the one it had, and audits the change
+// made by the administrator. A rule has to set at least one action.
+func Set(rds *gorm.DB, rule Rule, by string) (*Rule, error) {
+	domain, err := NormalizeDomain(rule.Domain)
+	if err != nil {
+		return nil, err
+	}
+	if !rule.Reject && !rule.RejectMedia && !rule.Silence && !rule.ForceUnlisted && !rule.Allow {
+		return nil, model.ErrPolicyInvalid
+	}
+
+	row := &db.ActivityPubDomainPolicy{
+		Domain:        domain,
+		Reject:        rule.Reject,
+		RejectMedia:   rule.RejectMedia,
+		Silence:       rule.Silence,
+		ForceUnlisted: rule.ForceUnlisted,
+		Allow:         rule.Allow,
+		Reason:        rule.Reason,
+		UpdatedBy:     by,
+	}
+	err = rds.Transaction(func(tx *gorm.DB) error {
+		prev, err := find(tx, domain)
+		if err != nil {
+			return err
+		}
+		if prev != nil {
+			row.ID, row.CreatedAt = prev.ID, prev.CreatedAt
+			err = tx.Select("*").Save(row).Error
+		} else {
+			err = tx.Select("*").Create(row).Error
+		}
+		if err != nil {
+			return err
+		}
+		return audit(tx, domain, db.PolicyChangeSet, prev, row, rule.Reason, by)
+	})
+	if err != nil {
+		return nil, err
+	}
+	invalidate()
+	set := ruleOf(row)
+	return &set, nil
+}
+
+// Remove drops the rule of a domain and audits the change made by the administrator.
+func Remove(rds *gorm.DB, domain, reason, by string) error {
+	domain, err := NormalizeDomain(domain)
+	if err != nil {
+		return err
+	}
+	err = rds.Transaction(func(tx *gorm.DB) error {
+		prev, err := find(tx, domain)
+		if err != nil {
+			return err
+		}
+		if prev == nil {
+			return model.ErrPolicyNotFound
+		}
+		if err := tx.Delete(prev).Error; err != nil {
+			return err
+		}
+		return audit(tx, domain, db.PolicyChangeRemove, prev, nil, reason, by)
+	})
+	if err != nil {
+		return err
+	}
+	invalidate()
+	return nil
+}
+
+// Audits returns up to limit changes, latest first, older than the change before when it
+// is set, of a domain or, when it is empty, of all domains.
+func Audits(rds *gorm.DB, domain string, before uint64, limit int) ([]Audit, error) {
+	q := rds.Model(&db.ActivityPubPolicyAudit{})
+	if domain != "" {
+		d, err := NormalizeDomain(domain)
+		if err != nil {
+			return nil, err
+		}
+		q = q.Where("domain = ?", d)
+	}
+	if before > 0 {
+		q = q.Where("id < ?", before)
+	}
+	var rows []db.ActivityPubPolicyAudit
+	if err := q.Order("id DESC").Limit(limit).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	audits := make([]Audit, len(rows))
+	for i, r := range rows {
+		audits[i] = Audit{ID: r.ID, Domain: r.Domain, Change: r.Change, Reason: r.Reason, By: r.By, CreatedAt: r.CreatedAt}
+		audits[i].Previous = unmarshalRule(r.Previous)
+		audits[i].Policy = unmarshalRule(r.Policy)
+	}
+	return audits, nil
+}
+
+func find(tx *gorm.DB, domain string) (*db.ActivityPubDomainPolicy, error) {
+	var row db.ActivityPubDomainPolicy
+	err := tx.Where("domain = ?", domain).First(&row).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &row, nil
+}
+
+func audit(tx *gorm.DB, domain, change string, prev, next *db.ActivityPubDomainPolicy, reason, by string) error {
+	return tx.Create(&db.ActivityPubPolicyAudit{
+		Domain:   domain,
+		Change:   change,
+		Previous: marshalRule(prev),
+		Policy:   marshalRule(next),
+		Reason:   reason,
+		By:       by,
+	}).Error
+}
+
+func ruleOf(row *db.ActivityPubDomainPolicy) Rule {
+	r := Rule{
+		Domain:        row.Domain,
+		Reject:        row.Reject,
+		RejectMedia:   row.RejectMedia,
+		Silence:       row.Silence,
+		ForceUnlisted: row.ForceUnlisted,
+		Allow:         row.Allow,
+		Reason:        row.Reason,
+		UpdatedBy:     row.UpdatedBy,
+	}
+	if !row.UpdatedAt.IsZero() {
+		updated := row.UpdatedAt
+		r.UpdatedAt = &updated
+	}
+	return r
+}
+
+func marshalRule(row *db.ActivityPubDomainPolicy) string {
+	if row == nil {
+		return ""
+	}
+	b, _ := json.Marshal(ruleOf(row))
+	return string(b)
+}
+
+func unmarshalRule(s string) *Rule {
+	if s == "" {
+		return nil
+	}
+	var r Rule
+	if err := json.Unmarshal([]byte(s), &r); err != nil {
+		return nil
+	}
+	return &r
+}
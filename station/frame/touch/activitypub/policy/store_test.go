@@ -0,0 +1,46 @@
+package policy
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestLoadKeepsThePolicyUntilTheRulesChange(t *testing.T) {
+	rds, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "touch.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(rds); err != nil {
+		t.Fatal(err)
+	}
+	invalidate()
+
+	p, err := Load(rds, "https://station.example", false)
+	if err != nil || p.Rejects("spam.example") {
+		t.Fatalf("policy = %v, %v", p, err)
+	}
+	if again, err := Load(rds, "https://station.example", false); err != nil || again != p {
+		t.Fatal("the policy is loaded again")
+	}
+	if other, err := Load(rds, "https://station.example", true); err != nil || other == p || !other.Rejects("friends.example") {
+		t.Fatal("the policy of allowlist mode is the cached one")
+	}
+
+	if _, err := Set(rds, Rule{Domain: "spam.example", Reject: true}, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if p, err = Load(rds, "https://station.example", false); err != nil || !p.Rejects("spam.example") {
+		t.Fatalf("rule set is not in force: %v", err)
+	}
+	if err := Remove(rds, "spam.example", "", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if p, err = Load(rds, "https://station.example", false); err != nil || p.Rejects("spam.example") {
+		t.Fatalf("rule removed is in force: %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package activitypub
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/peers-touch/peers-touch/station/frame/touch/activitypub/policy"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+	ap "github.com/peers-touch/peers-touch/station/frame/vendors/activitypub"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestRefuserFailsClosed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "touch.db")
+	open := func() *gorm.DB {
+		rds, err := gorm.Open(sqlite.Open(path), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rds
+	}
+	rds, lost := open(), open()
+	if err := db.AutoMigrate(rds); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := policy.Set(rds, policy.Rule{Domain: "spam.example", Reject: true}, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	refuse := refuser(context.Background(), lost)
+	if !refuse("spam.example") || refuse("remote.example") {
+		t.Fatal("the policy is not in force")
+	}
+
+	// the connection is lost and a change of the rules has the policy loaded again
+	sqlDB, err := lost.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sqlDB.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := policy.Set(rds, policy.Rule{Domain: "quiet.example", Silence: true}, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if !refuse("spam.example") || refuse("remote.example") {
+		t.Fatal("the policy loaded last is not kept")
+	}
+	if fresh := refuser(context.Background(), lost); !fresh("remote.example") {
+		t.Fatal("a host is let through without a policy")
+	}
+}
+
+func TestRestricterAppliesThePolicyOfTheHost(t *testing.T) {
+	rds, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "touch.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(rds); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := policy.Set(rds, policy.Rule{Domain: "quiet.example", RejectMedia: true}, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	restrict := restricter(context.Background(), rds)
+	for iri, stripped := range map[string]bool{
+		"https://media.quiet.example/notes/1": true,
+		"https://remote.example/notes/1":      false,
+	} {
+		note := ap.ObjectNew(ap.NoteType)
+		note.ID = ap.ID(iri)
+		note.Attachment = ap.IRI("https://remote.example/media/1.png")
+		restrict(note)
+		if (note.Attachment == nil) != stripped {
+			t.Errorf("%s: attachment %v", iri, note.Attachment)
+		}
+	}
+}
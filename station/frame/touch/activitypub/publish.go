@@ -0,0 +1,184 @@
+package activitypub
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	log "github.com/peers-touch/peers-touch/station/frame/core/logger"
+	"github.com/peers-touch/peers-touch/station/frame/core/store"
+	"github.com/peers-touch/peers-touch/station/frame/core/util/id"
+	o "github.com/peers-touch/peers-touch/station/frame/object"
+	"github.com/peers-touch/peers-touch/station/frame/touch/activitypub/compose"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/actor"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+	ap "github.com/peers-touch/peers-touch/station/frame/vendors/activitypub"
+)
+
+// plainText is the media type of the source of posts written as plain text
+const plainText ap.MimeType = "text/plain"
+
+// ObjectIRI returns the IRI of the local object with the id
+func ObjectIRI(id string) string {
+	return fmt.Sprintf("%s/%s/objects/%s", BaseURL(), routePrefix, url.PathEscape(id))
+}
+
+// TagIRI returns the IRI of the hashtag name, without #
+func TagIRI(name string) string {
+	return fmt.Sprintf("%s/tags/%s", BaseURL(), url.PathEscape(strings.ToLower(name)))
+}
+
+func localHost() string {
+	u, err := url.Parse(BaseURL())
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Host)
+}
+
+// GetObject serves a local object with the tags and addressing it was published with.
+// Deleted objects are answered with their Tombstone, those of followers-only and direct
+// posts are not served. The replies collection of the object counts its public replies.
+func GetObject(c context.Context, ctx *app.RequestContext) {
+	facade, ok := openFacade(c, ctx)
+	if !ok {
+		return
+	}
+	ob, visibility, err := facade.GetPublishedObject(o.ID(ObjectIRI(ctx.Param("id"))))
+	if err != nil {
+		failed(c, ctx, err)
+		return
+	}
+	switch {
+	case ob.Type == ap.TombstoneType:
+		respond(c, ctx, http.StatusGone, ob)
+	case visibility == db.VisibilityFollowers, visibility == db.VisibilityDirect:
+		failed(c, ctx, model.ErrActivityPubObjectNotFound)
+	default:
+		if ob.Replies, err = repliesOf(facade, string(ob.ID)); err != nil {
+			failed(c, ctx, err)
+			return
+		}
+		respond(c, ctx, http.StatusOK, ob)
+	}
+}
+
+// publishObject publishes an object of the local actor in a Create. The object gets an
+// IRI of the station, the mentions and hashtags of its text become tags, and the text is
+// rendered as HTML with them linked. Content sent as HTML is taken as the text it shows,
+// the markup of the client is not kept. A visibility addresses the object to its
+// audience and the actors mentioned, without one the addressing of the client is kept,
+// public when there is none, and the actors mentioned are added in cc.
+func publishObject(c context.Context, f *actor.DefaultActivityPubFacade, a *actor.Actor, item ap.Item, visibility string) (*o.Activity, error) {
+	if ap.IsNil(item) || ap.IsIRI(item) || ap.ActorTypes.Contains(item.GetType()) {
+		return nil, model.ErrActivityInvalid
+	}
+	err := ap.OnObject(item, func(ob *ap.Object) error {
+		ob.ID = ap.ID(ObjectIRI(id.NextULID()))
+		ob.AttributedTo = ap.IRI(a.ID)
+		ob.Published = time.Now()
+
+		source := ob.Content.First().Value.String()
+		if len(ob.Source.Content) > 0 && (ob.Source.MediaType == "" || ob.Source.MediaType == plainText) {
+			source = ob.Source.Content.First().Value.String()
+		} else if strings.Contains(source, "<") {
+			// the markup of clients is not published, it could carry scripts and styles
+			source = compose.PlainText(source)
+		}
+		text := compose.Parse(source)
+
+		hrefs := map[string]string{}
+		var mentioned []string
+		for i, handle := range text.Handles() {
+			if i == compose.MaxMentions {
+				log.Warnf(c, "Post of %s mentions more than %d actors, the others are ignored", a.ID, compose.MaxMentions)
+				break
+			}
+			iri, err := resolveMention(c, f, handle)
+			if err != nil {
+				log.Warnf(c, "Resolve mention %s failed: %v", handle, err)
+				continue
+			}
+			hrefs[strings.ToLower(handle)] = iri
+			mentioned = append(mentioned, iri)
+			if !hasTag(ob.Tag, ap.MentionType, iri) {
+				ob.Tag = append(ob.Tag, compose.MentionTag(handle, iri))
+			}
+		}
+		for _, name := range text.Tags() {
+			if href := TagIRI(name); !hasTag(ob.Tag, compose.HashtagType, href) {
+				ob.Tag = append(ob.Tag, compose.HashtagTag(name, href))
+			}
+		}
+		if source != "" {
+			ob.Content = ap.DefaultNaturalLanguageValue(text.HTML(hrefs, TagIRI))
+			ob.Source = ap.Source{Content: ap.DefaultNaturalLanguageValue(source), MediaType: plainText}
+		}
+
+		switch {
+		case visibility != "":
+			ob.To, ob.CC = compose.Address(visibility, link(a.Followers), mentioned)
+			ob.Bto, ob.BCC = nil, nil
+		case len(ob.To) == 0 && len(ob.CC) == 0 && len(ob.Bto) == 0 && len(ob.BCC) == 0:
+			ob.To, ob.CC = compose.Address(db.VisibilityPublic, link(a.Followers), mentioned)
+		default:
+			for _, iri := range mentioned {
+				if !ob.To.Contains(ap.IRI(iri)) && !ob.CC.Contains(ap.IRI(iri)) {
+					ob.CC = append(ob.CC, ap.IRI(iri))
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, model.ErrActivityInvalid
+	}
+	return f.CreateActivity(o.ActivityVocabularyType(ap.CreateType), o.ID(a.ID), &item)
+}
+
+// resolveMention returns the IRI of the actor a handle mentions, local users are
+// mentioned by name alone too.
+func resolveMention(c context.Context, f *actor.DefaultActivityPubFacade, handle string) (string, error) {
+	name, host, _ := strings.Cut(strings.TrimPrefix(handle, "@"), "@")
+	if host == "" || strings.EqualFold(host, localHost()) {
+		a, err := localActor(c, f, name)
+		if err != nil {
+			return "", err
+		}
+		return string(a.ID), nil
+	}
+	return resolveTarget(c, handle, true)
+}
+
+func hasTag(tags ap.ItemCollection, typ ap.ActivityVocabularyType, href string) bool {
+	for _, t := range tags {
+		if ap.IsNil(t) || t.GetType() != typ {
+			continue
+		}
+		found := false
+		_ = ap.OnLink(t, func(l *ap.Link) error {
+			found = string(l.Href) == href
+			return nil
+		})
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+// openFacade opens the facade, it answers the request itself when it fails.
+func openFacade(c context.Context, ctx *app.RequestContext) (*actor.DefaultActivityPubFacade, bool) {
+	rds, err := store.GetRDS(c)
+	if err != nil {
+		log.Errorf(c, "Failed to get database connection: %v", err)
+		ctx.JSON(http.StatusInternalServerError, "Database connection failed")
+		return nil, false
+	}
+	return actor.NewDefaultActivityPubFacade(rds).(*actor.DefaultActivityPubFacade), true
+}
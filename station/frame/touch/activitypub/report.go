@@ -0,0 +1,161 @@
+package activitypub
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	log "github.com/peers-touch/peers-touch/station/frame/core/logger"
+	o "github.com/peers-touch/peers-touch/station/frame/object"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/actor"
+)
+
+// CreateReport files a report of the local user against the actor, an IRI or
+// @user@host handle, and the objects of the body, for the moderators of the station.
+func CreateReport(c context.Context, ctx *app.RequestContext) {
+	user, facade, ok := prepare(c, ctx, "report")
+	if !ok {
+		return
+	}
+	var p struct {
+		Actor   string   `json:"actor"`
+		Objects []string `json:"objects"`
+		Comment string   `json:"comment"`
+	}
+	if err := json.Unmarshal(ctx.Request.Body(), &p); err != nil || (p.Actor == "" && len(p.Objects) == 0) {
+		failed(c, ctx, model.ErrReportInvalid)
+		return
+	}
+	a, err := activeActor(c, facade, user)
+	if err != nil {
+		failed(c, ctx, err)
+		return
+	}
+
+	var target string
+	if p.Actor != "" {
+		if target, err = resolveTarget(c, p.Actor, true); err != nil {
+			failed(c, ctx, err)
+			return
+		}
+	}
+	objects := make([]o.ID, 0, len(p.Objects))
+	for _, iri := range p.Objects {
+		if !strings.HasPrefix(iri, BaseURL()+"/") {
+			// remote objects are reported as they are known here
+			if r, err := remoteResolver(c); err == nil {
+				if _, err := r.ResolveObject(c, iri); err != nil {
+					log.Warnf(c, "Resolve reported object %s failed: %v", iri, err)
+				}
+			}
+		}
+		objects = append(objects, o.ID(iri))
+	}
+
+	report, err := facade.FileReport(o.ID(a.ID), o.ID(target), objects, p.Comment)
+	if err != nil {
+		failed(c, ctx, err)
+		return
+	}
+	log.Infof(c, "Report %d filed by %s against %s", report.ID, user, report.Target)
+	ctx.JSON(http.StatusCreated, report)
+}
+
+// GetReports lists the reports for the moderators, latest first. The status query
+// narrows them to open, resolved or dismissed ones, max_id pages to older reports and
+// limit caps them.
+func GetReports(c context.Context, ctx *app.RequestContext) {
+	facade, ok := openFacade(c, ctx)
+	if !ok {
+		return
+	}
+	before, _ := strconv.ParseUint(ctx.Query("max_id"), 10, 64)
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", strconv.Itoa(actor.DefaultPageSize)))
+	if err != nil || limit <= 0 || limit > actor.MaxPageSize {
+		limit = actor.DefaultPageSize
+	}
+	reports, err := facade.GetReports(ctx.Query("status"), before, limit)
+	if err != nil {
+		failed(c, ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, reports)
+}
+
+// GetReport returns the report of the path
+func GetReport(c context.Context, ctx *app.RequestContext) {
+	facade, ok := openFacade(c, ctx)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		failed(c, ctx, model.ErrReportNotFound)
+		return
+	}
+	report, err := facade.GetReport(id)
+	if err != nil {
+		failed(c, ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, report)
+}
+
+// PostReportAction takes the moderation action of the body on the report of the path:
+// suspend or unsuspend the actor reported, delete_object one of the objects reported,
+// forward the report to the server of the actor, or resolve, dismiss or reopen it.
+func PostReportAction(c context.Context, ctx *app.RequestContext) {
+	facade, ok := openFacade(c, ctx)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		failed(c, ctx, model.ErrReportNotFound)
+		return
+	}
+	var action actor.ReportAction
+	if err := json.Unmarshal(ctx.Request.Body(), &action); err != nil || action.Action == "" {
+		failed(c, ctx, model.ErrReportBadAction)
+		return
+	}
+	action.By = adminName(c)
+
+	// forwarded reports are sent by the moderator, not by the reporter
+	var moderator o.ID
+	if action.Action == actor.ReportForward {
+		a, err := localActor(c, facade, action.By)
+		if err != nil {
+			failed(c, ctx, err)
+			return
+		}
+		moderator = o.ID(a.ID)
+	}
+	report, err := facade.ModerateReport(id, action, moderator)
+	if err != nil {
+		failed(c, ctx, err)
+		return
+	}
+	log.Infof(c, "Report %d: %s by %s", report.ID, action.Action, action.By)
+	ctx.JSON(http.StatusOK, report)
+}
+
+// activeActor returns the ActivityPub actor of a local user who is not suspended
+func activeActor(c context.Context, f *actor.DefaultActivityPubFacade, username string) (*actor.Actor, error) {
+	a, err := localActor(c, f, username)
+	if err != nil {
+		return nil, err
+	}
+	suspended, err := f.Suspended(o.ID(a.ID))
+	if err != nil {
+		return nil, err
+	}
+	if suspended {
+		return nil, model.ErrActorSuspended
+	}
+	return a, nil
+}
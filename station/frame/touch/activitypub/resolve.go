@@ -0,0 +1,94 @@
+package activitypub
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	cfg "github.com/peers-touch/peers-touch/station/frame/core/config"
+	log "github.com/peers-touch/peers-touch/station/frame/core/logger"
+	"github.com/peers-touch/peers-touch/station/frame/core/store"
+	"github.com/peers-touch/peers-touch/station/frame/touch/activitypub/resolver"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/actor"
+	"github.com/peers-touch/peers-touch/station/frame/touch/webfinger"
+)
+
+const (
+	defaultResolverTTL = 24 * time.Hour
+	fetchTimeout       = 10 * time.Second
+)
+
+var (
+	resolverOnce sync.Once
+	remote       *resolver.Resolver
+
+	// finger resolves the @user@host handles of remote accounts
+	finger = webfinger.NewClient(resolver.NewClient(fetchTimeout, allowPrivate))
+)
+
+// remoteResolver returns the resolver of remote actors and objects, shared by all
+// requests so concurrent fetches of an IRI collapse. Stored items are fetched again
+// after peers.touch.activitypub.resolver.ttl. Nothing is fetched from the domains the
+// federation policy rejects, the objects fetched are stored as the policy of their
+// domain has them.
+func remoteResolver(c context.Context) (*resolver.Resolver, error) {
+	rds, err := store.GetRDS(c)
+	if err != nil {
+		return nil, err
+	}
+	resolverOnce.Do(func() {
+		ttl := cfg.Get("peers", "touch", "activitypub", "resolver", "ttl").Duration(defaultResolverTTL)
+		facade := actor.NewDefaultActivityPubFacade(rds).(*actor.DefaultActivityPubFacade)
+		remote = resolver.New(facade, resolver.NewClient(fetchTimeout, allowPrivate), ttl)
+		remote.Refuse = refuser(context.WithoutCancel(c), rds)
+		remote.Restrict = restricter(context.WithoutCancel(c), rds)
+	})
+	return remote, nil
+}
+
+// allowPrivate tells whether remote fetches and deliveries may reach private addresses,
+// peers.touch.activitypub.allow_private_addresses lets test servers federate on a
+// local network.
+func allowPrivate() bool {
+	return cfg.Get("peers", "touch", "activitypub", "allow_private_addresses").Bool(false)
+}
+
+// resolveTarget returns the IRI of the actor an IRI or @user@host handle names. With
+// fetch set, remote actors are resolved too, so they are known before they are
+// addressed.
+func resolveTarget(c context.Context, target string, fetch bool) (string, error) {
+	iri := target
+	if !strings.Contains(target, "://") {
+		var err error
+		if iri, err = finger.ResolveActor(c, target); err != nil {
+			if errors.Is(err, webfinger.ErrInvalidHandle) {
+				return "", model.ErrActivityInvalid
+			}
+			if !errors.Is(err, model.ErrActorNotFound) {
+				log.Warnf(c, "WebFinger lookup of %s failed: %v", target, err)
+			}
+			return "", model.ErrActorNotFound
+		}
+	}
+	if !fetch || strings.HasPrefix(iri, BaseURL()+"/") {
+		return iri, nil
+	}
+
+	r, err := remoteResolver(c)
+	if err != nil {
+		return "", err
+	}
+	_, err = r.ResolveActor(c, iri)
+	switch {
+	case errors.Is(err, resolver.ErrRefused):
+		return "", model.ErrFederationRejected
+	case errors.Is(err, resolver.ErrGone), errors.Is(err, resolver.ErrNotFound), errors.Is(err, resolver.ErrIDMismatch), errors.Is(err, resolver.ErrType):
+		return "", model.ErrActorNotFound
+	case err != nil:
+		return "", err
+	}
+	return iri, nil
+}
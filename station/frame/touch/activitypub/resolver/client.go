@@ -0,0 +1,82 @@
+package resolver
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// maxRedirects caps the redirects followed by the clients of NewClient.
+const maxRedirects = 5
+
+// ErrPrivateAddress is returned for connections to addresses of the local network.
+var ErrPrivateAddress = errors.New("resolver: private address refused")
+
+// NewClient returns an HTTP client for remote servers. IRIs come from remote documents,
+// so it refuses to connect to loopback, private, link-local, multicast and unspecified
+// addresses, checked on the address dialed after name resolution, for every redirect
+// too. allowPrivate, when set and true, lets them through, for federating test servers
+// on a local network. Proxies are not used, they would hide the address dialed.
+func NewClient(timeout time.Duration, allowPrivate func() bool) *http.Client {
+	allowed := func() bool {
+		return allowPrivate != nil && allowPrivate()
+	}
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			if allowed() {
+				return nil
+			}
+			return checkAddress(address)
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("resolver: stopped after %d redirects", maxRedirects)
+			}
+			if req.URL.Scheme != "https" && req.URL.Scheme != "http" {
+				return fmt.Errorf("resolver: redirect to %s refused", req.URL.Scheme)
+			}
+			if allowed() {
+				return nil
+			}
+			// names are checked once dialed, literal addresses right away
+			if ip, err := netip.ParseAddr(req.URL.Hostname()); err == nil && private(ip) {
+				return ErrPrivateAddress
+			}
+			return nil
+		},
+	}
+}
+
+// checkAddress refuses the host:port address dialed when it is a private one.
+func checkAddress(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if private(ip) {
+		return ErrPrivateAddress
+	}
+	return nil
+}
+
+func private(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified()
+}
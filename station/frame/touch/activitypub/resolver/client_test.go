@@ -0,0 +1,65 @@
+package resolver
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCheckAddress(t *testing.T) {
+	for address, refused := range map[string]bool{
+		"127.0.0.1:80":          true,
+		"10.1.2.3:443":          true,
+		"192.168.0.10:8080":     true,
+		"169.254.169.254:80":    true,
+		"0.0.0.0:80":            true,
+		"[::1]:443":             true,
+		"[fe80::1]:443":         true,
+		"[fd00::1]:443":         true,
+		"[::ffff:10.0.0.1]:443": true,
+		"93.184.216.34:443":     false,
+		"[2606:4700::1]:443":    false,
+	} {
+		err := checkAddress(address)
+		if refused != errors.Is(err, ErrPrivateAddress) {
+			t.Errorf("%s: got %v, refused %v", address, err, refused)
+		}
+	}
+}
+
+func TestClientRefusesPrivateAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	if _, err := NewClient(time.Second, nil).Get(srv.URL); !errors.Is(err, ErrPrivateAddress) {
+		t.Fatalf("got %v, want ErrPrivateAddress", err)
+	}
+	resp, err := NewClient(time.Second, func() bool { return true }).Get(srv.URL)
+	if err != nil {
+		t.Fatalf("private addresses allowed: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestClientChecksRedirects(t *testing.T) {
+	c := NewClient(time.Second, nil)
+	via := []*http.Request{httptest.NewRequest(http.MethodGet, "https://remote.example/users/bob", nil)}
+	for target, want := range map[string]error{
+		"https://other.example/users/bob":         nil,
+		"http://169.254.169.254/latest/meta-data": ErrPrivateAddress,
+		"http://[::1]/admin":                      ErrPrivateAddress,
+	} {
+		err := c.CheckRedirect(httptest.NewRequest(http.MethodGet, target, nil), via)
+		if !errors.Is(err, want) {
+			t.Errorf("%s: got %v, want %v", target, err, want)
+		}
+	}
+	if err := c.CheckRedirect(httptest.NewRequest(http.MethodGet, "ftp://remote.example/x", nil), via); err == nil {
+		t.Error("redirect to ftp followed")
+	}
+	if err := c.CheckRedirect(httptest.NewRequest(http.MethodGet, "https://remote.example/x", nil), make([]*http.Request, maxRedirects)); err == nil {
+		t.Error("redirects not capped")
+	}
+}
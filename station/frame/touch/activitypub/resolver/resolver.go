@@ -0,0 +1,330 @@
+// Package resolver dereferences the IRIs of remote actors and objects and keeps them
+// cached, refreshing them once they are older than a TTL.
+package resolver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	o "github.com/peers-touch/peers-touch/station/frame/object"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/actor"
+	ap "github.com/peers-touch/peers-touch/station/frame/vendors/activitypub"
+)
+
+// AcceptHeader asks remote servers for their ActivityPub representation
+const AcceptHeader = `application/activity+json, application/ld+json; profile="https://www.w3.org/ns/activitystreams"`
+
+// maxDocumentBytes caps the remote documents read.
+const maxDocumentBytes = 1 << 20
+
+var (
+	// ErrGone is returned for IRIs answering 410 Gone or a Tombstone.
+	ErrGone = errors.New("resolver: gone")
+	// ErrNotFound is returned for IRIs answering 404.
+	ErrNotFound = errors.New("resolver: not found")
+	// ErrIDMismatch is returned for documents whose id is not the IRI they were fetched from.
+	ErrIDMismatch = errors.New("resolver: document id does not match its IRI")
+	// ErrType is returned for documents that are not the kind of item asked for.
+	ErrType = errors.New("resolver: unexpected document type")
+	// ErrRedirected is returned for IRIs redirecting to another host, whose documents
+	// could claim the ids of the host asked.
+	ErrRedirected = errors.New("resolver: redirected to another host")
+	// ErrRefused is returned for IRIs of hosts the resolver is not to fetch from.
+	ErrRefused = errors.New("resolver: host refused")
+	// ErrAttribution is returned for objects attributed to actors of another host than
+	// their own.
+	ErrAttribution = errors.New("resolver: object attributed to an actor of another host")
+)
+
+// Store keeps the actors and objects fetched, the DefaultActivityPubFacade keeps them
+// in the activitypub tables.
+type Store interface {
+	FetchedActor(id o.ID) (*actor.Actor, actor.FetchState, error)
+	SaveActor(a *actor.Actor, local bool) error
+	GoneActor(id o.ID) error
+	FetchedObject(id o.ID) (*ap.Object, actor.FetchState, error)
+	SaveRemoteObject(ob *ap.Object) error
+	GoneObject(id o.ID) error
+}
+
+// Resolver dereferences remote IRIs through a Store.
+type Resolver struct {
+	// Refuse, when set, tells the hosts whose items are neither fetched nor resolved, as
+	// the federation policy rejects them.
+	Refuse func(host string) bool
+	// Restrict, when set, enforces the federation policy of their host on the objects
+	// fetched before they are stored.
+	Restrict func(ob *ap.Object)
+
+	store  Store
+	client *http.Client
+	ttl    time.Duration
+	now    func() time.Time
+
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+// call is a fetch in flight, concurrent fetches of its IRI wait for it.
+type call struct {
+	done chan struct{}
+	item ap.Item
+	err  error
+}
+
+// New creates a resolver fetching with the client. Stored items younger than ttl are
+// not fetched again.
+func New(store Store, client *http.Client, ttl time.Duration) *Resolver {
+	return &Resolver{store: store, client: client, ttl: ttl, now: time.Now, calls: map[string]*call{}}
+}
+
+// ResolveActor returns the actor of the IRI, fetching it when it is not stored or stale.
+// A stale actor is returned when its server cannot be reached.
+func (r *Resolver) ResolveActor(ctx context.Context, iri string) (*actor.Actor, error) {
+	if r.refused(iri) {
+		return nil, ErrRefused
+	}
+	a, state, err := r.store.FetchedActor(o.ID(iri))
+	if err != nil {
+		return nil, err
+	}
+	if a != nil && !state.Active {
+		return nil, ErrGone
+	}
+	if a != nil && (state.Local || r.fresh(state.LastFetched)) {
+		return a, nil
+	}
+	fetched, err := r.RefreshActor(ctx, iri)
+	if err != nil && a != nil && !errors.Is(err, ErrGone) && !errors.Is(err, ErrNotFound) {
+		return a, nil
+	}
+	return fetched, err
+}
+
+// RefreshActor fetches the actor of the IRI and stores it.
+func (r *Resolver) RefreshActor(ctx context.Context, iri string) (*actor.Actor, error) {
+	item, err := r.Fetch(ctx, iri)
+	if errors.Is(err, ErrGone) {
+		if err := r.store.GoneActor(o.ID(iri)); err != nil {
+			return nil, err
+		}
+		return nil, ErrGone
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !ap.ActorTypes.Contains(item.GetType()) {
+		return nil, ErrType
+	}
+	a, err := ap.ToActor(item)
+	if err != nil {
+		return nil, ErrType
+	}
+	if err := r.store.SaveActor((*actor.Actor)(a), false); err != nil {
+		return nil, err
+	}
+	return (*actor.Actor)(a), nil
+}
+
+// ResolveObject returns the object of the IRI, fetching it when it is not stored or
+// stale. A stale object is returned when its server cannot be reached.
+func (r *Resolver) ResolveObject(ctx context.Context, iri string) (*ap.Object, error) {
+	if r.refused(iri) {
+		return nil, ErrRefused
+	}
+	ob, state, err := r.store.FetchedObject(o.ID(iri))
+	if err != nil {
+		return nil, err
+	}
+	if ob != nil && !state.Active {
+		return nil, ErrGone
+	}
+	if ob != nil && (state.Local || r.fresh(state.LastFetched)) {
+		return ob, nil
+	}
+	fetched, err := r.RefreshObject(ctx, iri)
+	if err != nil && ob != nil && !errors.Is(err, ErrGone) && !errors.Is(err, ErrNotFound) {
+		return ob, nil
+	}
+	return fetched, err
+}
+
+// RefreshObject fetches the object of the IRI and stores it.
+func (r *Resolver) RefreshObject(ctx context.Context, iri string) (*ap.Object, error) {
+	item, err := r.Fetch(ctx, iri)
+	if errors.Is(err, ErrGone) {
+		if err := r.store.GoneObject(o.ID(iri)); err != nil {
+			return nil, err
+		}
+		return nil, ErrGone
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !ap.ObjectTypes.Contains(item.GetType()) {
+		return nil, ErrType
+	}
+	ob, err := ap.ToObject(item)
+	if err != nil {
+		return nil, ErrType
+	}
+	if !attributedToOwnHost(ob) {
+		return nil, ErrAttribution
+	}
+	if r.Restrict != nil {
+		r.Restrict(ob)
+	}
+	if err := r.store.SaveRemoteObject(ob); err != nil {
+		return nil, err
+	}
+	return ob, nil
+}
+
+// attributedToOwnHost tells whether the actors the object is attributed to are on the
+// host of the object, a server only speaks for its own actors.
+func attributedToOwnHost(ob *ap.Object) bool {
+	if ap.IsNil(ob.AttributedTo) {
+		return true
+	}
+	authors := ap.ItemCollection{ob.AttributedTo}
+	if col, ok := ob.AttributedTo.(ap.ItemCollection); ok {
+		authors = col
+	}
+	for _, a := range authors {
+		if ap.IsNil(a) || !actor.SameHost(string(a.GetLink()), string(ob.ID)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Fetch dereferences an IRI, concurrent fetches of the same IRI share one request.
+// Tombstones and 410 answers return ErrGone.
+func (r *Resolver) Fetch(ctx context.Context, iri string) (ap.Item, error) {
+	if r.refused(iri) {
+		return nil, ErrRefused
+	}
+	r.mu.Lock()
+	if c, ok := r.calls[iri]; ok {
+		r.mu.Unlock()
+		select {
+		case <-c.done:
+			return c.item, c.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	c := &call{done: make(chan struct{})}
+	r.calls[iri] = c
+	r.mu.Unlock()
+
+	c.item, c.err = r.fetch(ctx, iri)
+	r.mu.Lock()
+	delete(r.calls, iri)
+	r.mu.Unlock()
+	close(c.done)
+	return c.item, c.err
+}
+
+func (r *Resolver) fetch(ctx context.Context, iri string) (ap.Item, error) {
+	b, err := r.get(ctx, iri)
+	if err != nil {
+		return nil, err
+	}
+	item, err := ap.UnmarshalJSON(b)
+	if err != nil {
+		return nil, err
+	}
+	if ap.IsNil(item) || string(item.GetID()) != iri {
+		return nil, ErrIDMismatch
+	}
+	if item.GetType() == ap.TombstoneType {
+		return item, ErrGone
+	}
+	return item, nil
+}
+
+// Key is the public key a keyId of an HTTP signature names.
+type Key struct {
+	ID           string `json:"id"`
+	Owner        string `json:"owner"`
+	PublicKeyPem string `json:"publicKeyPem"`
+}
+
+// FetchKey dereferences the keyId of an HTTP signature. Its document is either the key
+// itself or, for keyIds with a fragment, the actor embedding it as its publicKey. Keys
+// are not stored, the actor owning them is.
+func (r *Resolver) FetchKey(ctx context.Context, keyID string) (*Key, error) {
+	if r.refused(keyID) {
+		return nil, ErrRefused
+	}
+	b, err := r.get(ctx, keyID)
+	if err != nil {
+		return nil, err
+	}
+	var doc struct {
+		Key
+		PublicKey *Key `json:"publicKey"`
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	key := &doc.Key
+	if doc.PublicKey != nil {
+		key = doc.PublicKey
+	}
+	if key.ID != keyID {
+		return nil, ErrIDMismatch
+	}
+	if key.Owner == "" || key.PublicKeyPem == "" {
+		return nil, ErrType
+	}
+	return key, nil
+}
+
+// get reads the document of the IRI. Redirects are followed on the host of the IRI only.
+func (r *Resolver) get(ctx context.Context, iri string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, iri, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", AcceptHeader)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if !strings.EqualFold(resp.Request.URL.Host, req.URL.Host) {
+		return nil, ErrRedirected
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusGone:
+		return nil, ErrGone
+	case http.StatusNotFound:
+		return nil, ErrNotFound
+	default:
+		return nil, fmt.Errorf("resolver: fetch %s: %s", iri, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxDocumentBytes))
+}
+
+func (r *Resolver) refused(iri string) bool {
+	if r.Refuse == nil {
+		return false
+	}
+	u, err := url.Parse(iri)
+	return err != nil || r.Refuse(u.Host)
+}
+
+func (r *Resolver) fresh(fetched *time.Time) bool {
+	return fetched != nil && r.now().Sub(*fetched) < r.ttl
+}
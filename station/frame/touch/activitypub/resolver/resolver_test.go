@@ -0,0 +1,361 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	o "github.com/peers-touch/peers-touch/station/frame/object"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/actor"
+	ap "github.com/peers-touch/peers-touch/station/frame/vendors/activitypub"
+)
+
+type memStore struct {
+	mu      sync.Mutex
+	actors  map[o.ID]*actor.Actor
+	objects map[o.ID]*ap.Object
+	fetched map[o.ID]time.Time
+	gone    map[o.ID]bool
+}
+
+func newMemStore() *memStore {
+	return &memStore{actors: map[o.ID]*actor.Actor{}, objects: map[o.ID]*ap.Object{}, fetched: map[o.ID]time.Time{}, gone: map[o.ID]bool{}}
+}
+
+func (s *memStore) state(id o.ID) actor.FetchState {
+	fetched := s.fetched[id]
+	return actor.FetchState{Active: !s.gone[id], LastFetched: &fetched}
+}
+
+func (s *memStore) FetchedActor(id o.ID) (*actor.Actor, actor.FetchState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if a, ok := s.actors[id]; ok {
+		return a, s.state(id), nil
+	}
+	return nil, actor.FetchState{}, nil
+}
+
+func (s *memStore) SaveActor(a *actor.Actor, local bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.actors[o.ID(a.ID)] = a
+	s.fetched[o.ID(a.ID)] = time.Now()
+	return nil
+}
+
+func (s *memStore) GoneActor(id o.ID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gone[id] = true
+	return nil
+}
+
+func (s *memStore) FetchedObject(id o.ID) (*ap.Object, actor.FetchState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ob, ok := s.objects[id]; ok {
+		return ob, s.state(id), nil
+	}
+	return nil, actor.FetchState{}, nil
+}
+
+func (s *memStore) SaveRemoteObject(ob *ap.Object) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[o.ID(ob.ID)] = ob
+	s.fetched[o.ID(ob.ID)] = time.Now()
+	return nil
+}
+
+func (s *memStore) GoneObject(id o.ID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gone[id] = true
+	return nil
+}
+
+// remote serves documents by path, with the server URL as {{base}}
+func remote(t *testing.T, handler func(w http.ResponseWriter, r *http.Request, base string)) (*httptest.Server, *atomic.Int64) {
+	var hits atomic.Int64
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if r.Header.Get("Accept") != AcceptHeader {
+			t.Errorf("fetched with Accept %q", r.Header.Get("Accept"))
+		}
+		handler(w, r, srv.URL)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &hits
+}
+
+func person(w http.ResponseWriter, id string) {
+	w.Header().Set("Content-Type", "application/activity+json")
+	fmt.Fprintf(w, `{"@context":"https://www.w3.org/ns/activitystreams","id":%q,"type":"Person","inbox":%q}`, id, id+"/inbox")
+}
+
+func TestResolveActorCachesWithinTTL(t *testing.T) {
+	srv, hits := remote(t, func(w http.ResponseWriter, r *http.Request, base string) {
+		person(w, base+r.URL.Path)
+	})
+	st := newMemStore()
+	r := New(st, srv.Client(), time.Hour)
+
+	iri := srv.URL + "/users/bob"
+	for i := 0; i < 2; i++ {
+		a, err := r.ResolveActor(context.Background(), iri)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(a.ID) != iri {
+			t.Fatalf("resolved %s", a.ID)
+		}
+	}
+	if hits.Load() != 1 {
+		t.Fatalf("fetched %d times within the TTL", hits.Load())
+	}
+
+	r.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if _, err := r.ResolveActor(context.Background(), iri); err != nil {
+		t.Fatal(err)
+	}
+	if hits.Load() != 2 {
+		t.Fatalf("stale actor fetched %d times", hits.Load())
+	}
+}
+
+func TestResolveActorKeepsStaleCopyWhenUnreachable(t *testing.T) {
+	var down atomic.Bool
+	srv, _ := remote(t, func(w http.ResponseWriter, r *http.Request, base string) {
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		person(w, base+r.URL.Path)
+	})
+	r := New(newMemStore(), srv.Client(), time.Hour)
+	iri := srv.URL + "/users/bob"
+	if _, err := r.ResolveActor(context.Background(), iri); err != nil {
+		t.Fatal(err)
+	}
+
+	down.Store(true)
+	r.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if a, err := r.ResolveActor(context.Background(), iri); err != nil || a == nil {
+		t.Fatalf("stale actor not returned: %v", err)
+	}
+}
+
+func TestFetchRejectsMismatchedID(t *testing.T) {
+	srv, _ := remote(t, func(w http.ResponseWriter, r *http.Request, base string) {
+		person(w, base+"/users/mallory")
+	})
+	st := newMemStore()
+	r := New(st, srv.Client(), time.Hour)
+	if _, err := r.ResolveActor(context.Background(), srv.URL+"/users/bob"); !errors.Is(err, ErrIDMismatch) {
+		t.Fatalf("got %v, want ErrIDMismatch", err)
+	}
+	if len(st.actors) != 0 {
+		t.Fatal("mismatched actor stored")
+	}
+}
+
+func TestFetchKey(t *testing.T) {
+	srv, _ := remote(t, func(w http.ResponseWriter, r *http.Request, base string) {
+		switch r.URL.Path {
+		case "/users/bob":
+			fmt.Fprintf(w, `{"id":%q,"type":"Person","publicKey":{"id":%q,"owner":%q,"publicKeyPem":"PEM"}}`, base+"/users/bob", base+"/users/bob#main-key", base+"/users/bob")
+		case "/users/bob/main-key":
+			fmt.Fprintf(w, `{"id":%q,"type":"Key","owner":%q,"publicKeyPem":"PEM"}`, base+"/users/bob/main-key", base+"/users/bob")
+		case "/keys/other":
+			fmt.Fprintf(w, `{"id":%q,"owner":%q,"publicKeyPem":"PEM"}`, base+"/keys/mallory", base+"/users/mallory")
+		}
+	})
+	r := New(newMemStore(), srv.Client(), time.Hour)
+	for _, keyID := range []string{srv.URL + "/users/bob#main-key", srv.URL + "/users/bob/main-key"} {
+		key, err := r.FetchKey(context.Background(), keyID)
+		if err != nil {
+			t.Fatalf("%s: %v", keyID, err)
+		}
+		if key.ID != keyID || key.Owner != srv.URL+"/users/bob" || key.PublicKeyPem != "PEM" {
+			t.Fatalf("%s: key = %+v", keyID, key)
+		}
+	}
+	for _, keyID := range []string{srv.URL + "/users/bob#other-key", srv.URL + "/keys/other"} {
+		if _, err := r.FetchKey(context.Background(), keyID); !errors.Is(err, ErrIDMismatch) {
+			t.Fatalf("%s: got %v, want ErrIDMismatch", keyID, err)
+		}
+	}
+}
+
+func TestFetchRefusesRedirectsToAnotherHost(t *testing.T) {
+	// the host of mallory serves documents claiming the ids of the host of bob
+	var victim string
+	evil, _ := remote(t, func(w http.ResponseWriter, r *http.Request, base string) {
+		switch r.URL.Path {
+		case "/users/bob":
+			person(w, victim+"/users/bob")
+		case "/users/bob/main-key":
+			fmt.Fprintf(w, `{"id":%q,"owner":%q,"publicKeyPem":"PEM"}`, victim+"/users/bob/main-key", victim+"/users/bob")
+		}
+	})
+	srv, _ := remote(t, func(w http.ResponseWriter, r *http.Request, base string) {
+		switch r.URL.Path {
+		case "/users/alice":
+			http.Redirect(w, r, "/users/alice/canonical", http.StatusFound)
+		case "/users/alice/canonical":
+			person(w, base+"/users/alice")
+		default:
+			http.Redirect(w, r, evil.URL+r.URL.Path, http.StatusFound)
+		}
+	})
+	victim = srv.URL
+	st := newMemStore()
+	r := New(st, srv.Client(), time.Hour)
+
+	if _, err := r.ResolveActor(context.Background(), srv.URL+"/users/bob"); !errors.Is(err, ErrRedirected) {
+		t.Fatalf("actor: got %v, want ErrRedirected", err)
+	}
+	if len(st.actors) != 0 {
+		t.Fatal("actor of another host stored")
+	}
+	if _, err := r.FetchKey(context.Background(), srv.URL+"/users/bob/main-key"); !errors.Is(err, ErrRedirected) {
+		t.Fatalf("key: got %v, want ErrRedirected", err)
+	}
+	if a, err := r.ResolveActor(context.Background(), srv.URL+"/users/alice"); err != nil || string(a.ID) != srv.URL+"/users/alice" {
+		t.Fatalf("redirect on the same host: %v, %v", a, err)
+	}
+}
+
+func TestRefreshObjectRejectsForeignAttribution(t *testing.T) {
+	srv, _ := remote(t, func(w http.ResponseWriter, r *http.Request, base string) {
+		author := base + "/users/bob"
+		if r.URL.Path == "/notes/forged" {
+			author = "https://other.example/users/alice"
+		}
+		fmt.Fprintf(w, `{"id":%q,"type":"Note","attributedTo":%q,"content":"hi"}`, base+r.URL.Path, author)
+	})
+	st := newMemStore()
+	r := New(st, srv.Client(), time.Hour)
+	if _, err := r.RefreshObject(context.Background(), srv.URL+"/notes/1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.RefreshObject(context.Background(), srv.URL+"/notes/forged"); !errors.Is(err, ErrAttribution) {
+		t.Fatalf("got %v, want ErrAttribution", err)
+	}
+	if len(st.objects) != 1 {
+		t.Fatalf("stored %d objects", len(st.objects))
+	}
+}
+
+func TestGoneActorAndObject(t *testing.T) {
+	srv, _ := remote(t, func(w http.ResponseWriter, r *http.Request, base string) {
+		switch r.URL.Path {
+		case "/users/bob":
+			w.WriteHeader(http.StatusGone)
+		case "/notes/1":
+			fmt.Fprintf(w, `{"id":%q,"type":"Tombstone","formerType":"Note"}`, base+r.URL.Path)
+		}
+	})
+	st := newMemStore()
+	r := New(st, srv.Client(), time.Hour)
+
+	bob := o.ID(srv.URL + "/users/bob")
+	st.actors[bob] = &actor.Actor{ID: ap.ID(bob)}
+	if _, err := r.ResolveActor(context.Background(), string(bob)); !errors.Is(err, ErrGone) {
+		t.Fatalf("got %v, want ErrGone", err)
+	}
+	if !st.gone[bob] {
+		t.Fatal("410 actor not marked gone")
+	}
+	if _, err := r.ResolveActor(context.Background(), string(bob)); !errors.Is(err, ErrGone) {
+		t.Fatalf("gone actor resolved: %v", err)
+	}
+
+	note := o.ID(srv.URL + "/notes/1")
+	if _, err := r.ResolveObject(context.Background(), string(note)); !errors.Is(err, ErrGone) {
+		t.Fatalf("got %v, want ErrGone", err)
+	}
+	if !st.gone[note] {
+		t.Fatal("tombstoned object not marked gone")
+	}
+}
+
+func TestConcurrentFetchesCollapse(t *testing.T) {
+	release := make(chan struct{})
+	srv, hits := remote(t, func(w http.ResponseWriter, r *http.Request, base string) {
+		<-release
+		person(w, base+r.URL.Path)
+	})
+	r := New(newMemStore(), srv.Client(), time.Hour)
+	iri := srv.URL + "/users/bob"
+
+	const n = 8
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			_, err := r.Fetch(context.Background(), iri)
+			errs <- err
+		}()
+	}
+	// let the fetches join the one in flight before answering it
+	for {
+		r.mu.Lock()
+		_, inFlight := r.calls[iri]
+		r.mu.Unlock()
+		if inFlight && hits.Load() == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	for i := 0; i < n; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if hits.Load() != 1 {
+		t.Fatalf("%d concurrent fetches made %d requests", n, hits.Load())
+	}
+}
+
+func TestRefusedHostsAreNotFetched(t *testing.T) {
+	srv, hits := remote(t, func(w http.ResponseWriter, r *http.Request, base string) {
+		person(w, base+r.URL.Path)
+	})
+	r := New(newMemStore(), srv.Client(), time.Hour)
+	r.Refuse = func(host string) bool { return strings.HasPrefix(host, "127.0.0.1") }
+
+	if _, err := r.ResolveActor(context.Background(), srv.URL+"/users/bob"); !errors.Is(err, ErrRefused) {
+		t.Fatalf("refused host resolved: %v", err)
+	}
+	if hits.Load() != 0 {
+		t.Fatalf("refused host fetched %d times", hits.Load())
+	}
+}
+
+func TestFetchedObjectsAreRestricted(t *testing.T) {
+	srv, _ := remote(t, func(w http.ResponseWriter, r *http.Request, base string) {
+		fmt.Fprintf(w, `{"id":%q,"type":"Note","content":"hi","attachment":{"type":"Image","url":%q}}`, base+r.URL.Path, base+"/media/1.png")
+	})
+	st := newMemStore()
+	r := New(st, srv.Client(), time.Hour)
+	r.Restrict = func(ob *ap.Object) { ob.Attachment = nil }
+
+	iri := srv.URL + "/notes/1"
+	if _, err := r.ResolveObject(context.Background(), iri); err != nil {
+		t.Fatal(err)
+	}
+	if ob := st.objects[o.ID(iri)]; ob == nil || ob.Attachment != nil {
+		t.Fatalf("stored %+v", ob)
+	}
+}
@@ -0,0 +1,141 @@
+package activitypub
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	cfg "github.com/peers-touch/peers-touch/station/frame/core/config"
+	log "github.com/peers-touch/peers-touch/station/frame/core/logger"
+	"github.com/peers-touch/peers-touch/station/frame/core/store"
+	o "github.com/peers-touch/peers-touch/station/frame/object"
+	"github.com/peers-touch/peers-touch/station/frame/touch/activitypub/httpsig"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/actor"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+)
+
+const (
+	defaultMaxSkew = time.Hour
+	defaultKeyTTL  = 24 * time.Hour
+)
+
+// verifyInbox checks the HTTP signature of an inbox POST and returns the IRI of the actor
+// owning the key. When the request acts for actorIRI, keyIds of another host are refused
+// before anything is fetched, and the key has to be owned by actorIRI. A cached remote
+// key that does not verify is fetched again once, the actor may have rotated it.
+func verifyInbox(c context.Context, ctx *app.RequestContext, actorIRI string) (string, error) {
+	header := http.Header{}
+	ctx.Request.Header.VisitAll(func(k, v []byte) {
+		header.Add(string(k), string(v))
+	})
+	if header.Get("Host") == "" {
+		header.Set("Host", string(ctx.Request.Host()))
+	}
+	method, target, body := string(ctx.Method()), string(ctx.Request.URI().RequestURI()), ctx.Request.Body()
+	opts := httpsig.Options{MaxSkew: cfg.Get("peers", "touch", "activitypub", "signature", "max_skew").Duration(defaultMaxSkew)}
+
+	var owner string
+	var cached bool
+	lookup := func(refresh bool) func(string) (crypto.PublicKey, error) {
+		return func(keyID string) (crypto.PublicKey, error) {
+			var pemKey string
+			var err error
+			owner, pemKey, cached, err = publicKey(c, keyID, actorIRI, refresh)
+			if err != nil {
+				return nil, err
+			}
+			return httpsig.ParsePublicKey(pemKey)
+		}
+	}
+	_, err := httpsig.VerifyRequest(method, target, header, body, opts, lookup(false))
+	if errors.Is(err, httpsig.ErrBadSignature) && cached {
+		_, err = httpsig.VerifyRequest(method, target, header, body, opts, lookup(true))
+	}
+	if err == nil {
+		return owner, nil
+	}
+	var e *model.Error
+	if errors.As(err, &e) {
+		return "", err
+	}
+	log.Warnf(c, "Rejected inbox request signed by %s: %v", owner, err)
+	return "", model.ErrSignatureInvalid
+}
+
+// publicKey returns the owner and PEM of the key, from the stored actor while it is fresh
+// and fetched from its server otherwise. cached tells whether it came from the RDS. The
+// keyId is dereferenced for its owner, which has to be on the host of the key, and be
+// actorIRI when it is set; the actor of the owner has to name the key as its own.
+func publicKey(c context.Context, keyID, actorIRI string, refresh bool) (owner, pemKey string, cached bool, err error) {
+	if actorIRI != "" && !actor.SameHost(keyID, actorIRI) {
+		return "", "", false, model.ErrSignatureActorMismatch
+	}
+	owner = actorIRI
+	if owner == "" {
+		owner, _, _ = strings.Cut(keyID, "#")
+	}
+	rds, err := store.GetRDS(c)
+	if err != nil {
+		return "", "", false, err
+	}
+	var row db.ActivityPubActor
+	if err := rds.Where("activity_pub_id = ?", owner).Limit(1).Find(&row).Error; err != nil {
+		return "", "", false, err
+	}
+	ttl := cfg.Get("peers", "touch", "activitypub", "signature", "key_ttl").Duration(defaultKeyTTL)
+	fresh := row.LastFetched != nil && time.Since(*row.LastFetched) < ttl
+	if row.ID != 0 && row.PublicKeyPem != "" && row.KeyID() == keyID && (row.IsLocal || (fresh && !refresh)) {
+		return owner, row.PublicKeyPem, true, nil
+	}
+	if row.IsLocal {
+		return "", "", false, model.ErrSignatureKeyNotFound
+	}
+
+	r, err := remoteResolver(c)
+	if err != nil {
+		return "", "", false, err
+	}
+	if base, _, _ := strings.Cut(keyID, "#"); base != owner {
+		// the key is a document of its own naming its owner, others are in the actor
+		key, err := r.FetchKey(c, keyID)
+		if err != nil {
+			log.Warnf(c, "Fetch key %s failed: %v", keyID, err)
+			return "", "", false, model.ErrSignatureKeyNotFound
+		}
+		if !actor.SameHost(key.Owner, keyID) || (actorIRI != "" && key.Owner != actorIRI) {
+			return "", "", false, model.ErrSignatureActorMismatch
+		}
+		owner = key.Owner
+	}
+	a, err := r.RefreshActor(c, owner)
+	if err != nil {
+		log.Warnf(c, "Fetch actor of key %s failed: %v", keyID, err)
+		return "", "", false, model.ErrSignatureKeyNotFound
+	}
+	if string(a.PublicKey.ID) != keyID || string(a.PublicKey.Owner) != owner || a.PublicKey.PublicKeyPem == "" {
+		return "", "", false, model.ErrSignatureKeyNotFound
+	}
+	return owner, a.PublicKey.PublicKeyPem, false, nil
+}
+
+// SignRequest signs an outgoing request of a local actor with its key, body is what the
+// request sends. Deliveries to remote inboxes are signed with it.
+func SignRequest(f *actor.DefaultActivityPubFacade, actorIRI string, req *http.Request, body []byte) error {
+	pemKey, err := f.PrivateKey(o.ID(actorIRI))
+	if err != nil {
+		return err
+	}
+	if pemKey == "" {
+		return model.ErrSignatureKeyNotFound
+	}
+	key, err := httpsig.ParsePrivateKey(pemKey)
+	if err != nil {
+		return err
+	}
+	return httpsig.Sign(req, body, actor.KeyID(actorIRI), key)
+}
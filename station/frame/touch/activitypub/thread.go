@@ -0,0 +1,170 @@
+package activitypub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	cfg "github.com/peers-touch/peers-touch/station/frame/core/config"
+	log "github.com/peers-touch/peers-touch/station/frame/core/logger"
+	"github.com/peers-touch/peers-touch/station/frame/core/store"
+	o "github.com/peers-touch/peers-touch/station/frame/object"
+	"github.com/peers-touch/peers-touch/station/frame/touch/activitypub/resolver"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/actor"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+	"github.com/peers-touch/peers-touch/station/frame/touch/util"
+	ap "github.com/peers-touch/peers-touch/station/frame/vendors/activitypub"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultBackfillInterval = 30 * time.Second
+	defaultBackfillBatch    = 20
+	backfillLease           = 5 * time.Minute
+)
+
+// GetReplies serves the public replies of a local object as a paged collection, newest
+// first.
+func GetReplies(c context.Context, ctx *app.RequestContext) {
+	facade, id, ok := threadObject(c, ctx)
+	if !ok {
+		return
+	}
+	q, err := pageQuery(ctx)
+	if err != nil {
+		failed(c, ctx, err)
+		return
+	}
+	respondPages(c, ctx, id+"/replies", q, func(q actor.PageQuery) (*actor.CollectionPage, error) {
+		return facade.GetRepliesPage(o.ID(id), q)
+	})
+}
+
+// GetContext serves the conversation of an object in one document: the tree of the
+// thread from its oldest ancestor stored, replies oldest first, so clients show it
+// without walking the replies. Ancestors not fetched yet are named by missing, they are
+// being backfilled. The object is the local one of the path, or the stored remote public
+// one the iri query names.
+func GetContext(c context.Context, ctx *app.RequestContext) {
+	facade, id, ok := contextObject(c, ctx)
+	if !ok {
+		return
+	}
+	thread, err := facade.GetThread(o.ID(id))
+	if err != nil {
+		failed(c, ctx, err)
+		return
+	}
+	thread.ID = id + "/context"
+	if !strings.HasPrefix(id, ObjectIRI("")) {
+		thread.ID = fmt.Sprintf("%s/%s/context?iri=%s", BaseURL(), routePrefix, url.QueryEscape(id))
+	}
+	ctx.JSON(http.StatusOK, thread)
+}
+
+// contextObject returns the IRI of the object of GetContext when its thread is served,
+// it answers the request itself when it is not. Remote objects are not fetched, only
+// those stored are served.
+func contextObject(c context.Context, ctx *app.RequestContext) (*actor.DefaultActivityPubFacade, string, bool) {
+	iri := ctx.Query("iri")
+	if iri == "" {
+		return threadObject(c, ctx)
+	}
+	facade, ok := openFacade(c, ctx)
+	if !ok {
+		return nil, "", false
+	}
+	if strings.HasPrefix(iri, ObjectIRI("")) {
+		return servedObject(c, ctx, facade, iri)
+	}
+	if err := facade.PublicRemoteObject(o.ID(iri)); err != nil {
+		failed(c, ctx, err)
+		return nil, "", false
+	}
+	return facade, iri, true
+}
+
+// threadObject returns the IRI of the local object of the path when it is served, it
+// answers the request itself when it is not.
+func threadObject(c context.Context, ctx *app.RequestContext) (*actor.DefaultActivityPubFacade, string, bool) {
+	facade, ok := openFacade(c, ctx)
+	if !ok {
+		return nil, "", false
+	}
+	return servedObject(c, ctx, facade, ObjectIRI(ctx.Param("id")))
+}
+
+// servedObject returns the IRI of a local object when it is served, neither followers
+// only nor direct, it answers the request itself when it is not.
+func servedObject(c context.Context, ctx *app.RequestContext, facade *actor.DefaultActivityPubFacade, id string) (*actor.DefaultActivityPubFacade, string, bool) {
+	_, visibility, err := facade.GetPublishedObject(o.ID(id))
+	if err == nil && (visibility == db.VisibilityFollowers || visibility == db.VisibilityDirect) {
+		err = model.ErrActivityPubObjectNotFound
+	}
+	if err != nil {
+		failed(c, ctx, err)
+		return nil, "", false
+	}
+	return facade, id, true
+}
+
+// repliesOf returns the replies collection a local object is served with
+func repliesOf(facade *actor.DefaultActivityPubFacade, id string) (ap.Item, error) {
+	count, err := facade.CountReplies(o.ID(id))
+	if err != nil {
+		return nil, err
+	}
+	col := ap.OrderedCollectionNew(ap.ID(id + "/replies"))
+	col.TotalItems = uint(count)
+	col.First = ap.IRI(pageIRI(id+"/replies", nil))
+	return col, nil
+}
+
+// startBackfill periodically fetches the ancestors of the replies stored that are
+// missing, so threads reach their root. It is configured under
+// peers.touch.activitypub.backfill: interval (0 disables it) and batch. Ancestors that
+// are gone, not found, refused by the federation policy or not objects are not asked
+// for again, others are retried backing off.
+func startBackfill(ctx context.Context, rds *gorm.DB) {
+	interval := cfg.Get("peers", "touch", "activitypub", "backfill", "interval").Duration(defaultBackfillInterval)
+	batch := cfg.Get("peers", "touch", "activitypub", "backfill", "batch").Int(defaultBackfillBatch)
+	facade := actor.NewDefaultActivityPubFacade(rds).(*actor.DefaultActivityPubFacade)
+	ctx = context.WithoutCancel(ctx)
+	util.RunEvery(ctx, "activitypub-backfill", interval, func(ctx context.Context) error {
+		due, err := facade.DueBackfills(batch, backfillLease)
+		if err != nil || len(due) == 0 {
+			return err
+		}
+		r, err := remoteResolver(ctx)
+		if err != nil {
+			return err
+		}
+		fetched := 0
+		for _, b := range due {
+			_, err := r.ResolveObject(ctx, b.ObjectID)
+			if err == nil {
+				fetched++
+				continue
+			}
+			permanent := errors.Is(err, resolver.ErrGone) || errors.Is(err, resolver.ErrNotFound) || errors.Is(err, resolver.ErrRefused) ||
+				errors.Is(err, resolver.ErrIDMismatch) || errors.Is(err, resolver.ErrType)
+			if err := facade.BackfillFailed(b, err, permanent); err != nil {
+				return err
+			}
+		}
+		if fetched > 0 {
+			log.Infof(ctx, "backfilled %d ancestors of replies", fetched)
+		}
+		return nil
+	})
+}
+
+func init() {
+	store.InitTableHooks(startBackfill)
+}
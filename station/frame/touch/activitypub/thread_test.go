@@ -0,0 +1,71 @@
+package activitypub
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	ap "github.com/peers-touch/peers-touch/station/frame/vendors/activitypub"
+)
+
+func TestGetContextOfRemoteObject(t *testing.T) {
+	_, facade := seedChat(t)
+	for _, n := range []struct {
+		id, inReplyTo string
+		to            ap.Item
+	}{
+		{bob + "/notes/1", "", ap.PublicNS},
+		{bob + "/notes/2", bob + "/notes/1", ap.PublicNS},
+		{bob + "/notes/3", "", ap.IRI(ActorIRI("alice"))},
+	} {
+		note := ap.ObjectNew(ap.NoteType)
+		note.ID = ap.ID(n.id)
+		note.AttributedTo = ap.IRI(bob)
+		note.To = ap.ItemCollection{n.to}
+		if n.inReplyTo != "" {
+			note.InReplyTo = ap.IRI(n.inReplyTo)
+		}
+		if err := facade.SaveRemoteObject(note); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, c := range []struct {
+		iri    string
+		status int
+	}{
+		{bob + "/notes/2", http.StatusOK},
+		{bob + "/notes/3", http.StatusNotFound},
+		{bob + "/notes/4", http.StatusNotFound},
+	} {
+		ctx := app.NewContext(0)
+		ctx.Request.SetRequestURI("/activitypub/context?iri=" + url.QueryEscape(c.iri))
+		GetContext(context.Background(), ctx)
+		if got := ctx.Response.StatusCode(); got != c.status {
+			t.Errorf("%s: status = %d, want %d", c.iri, got, c.status)
+			continue
+		}
+		if c.status != http.StatusOK {
+			continue
+		}
+		var thread struct {
+			ID   string `json:"id"`
+			Root struct {
+				Object  struct{ ID string }
+				Replies []struct{ Object struct{ ID string } }
+			}
+		}
+		if err := json.Unmarshal(ctx.Response.Body(), &thread); err != nil {
+			t.Fatal(err)
+		}
+		if thread.ID != BaseURL()+"/"+routePrefix+"/context?iri="+url.QueryEscape(c.iri) {
+			t.Errorf("id = %s", thread.ID)
+		}
+		if thread.Root.Object.ID != bob+"/notes/1" || len(thread.Root.Replies) != 1 || thread.Root.Replies[0].Object.ID != c.iri {
+			t.Errorf("thread = %s", ctx.Response.Body())
+		}
+	}
+}
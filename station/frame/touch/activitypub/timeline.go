@@ -0,0 +1,136 @@
+package activitypub
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	log "github.com/peers-touch/peers-touch/station/frame/core/logger"
+	"github.com/peers-touch/peers-touch/station/frame/core/store"
+	o "github.com/peers-touch/peers-touch/station/frame/object"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/actor"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+	ap "github.com/peers-touch/peers-touch/station/frame/vendors/activitypub"
+)
+
+// GetTimeline answers a page of the timeline of the path, home, local or federated, as
+// the local user sees it: without the actors it blocked or muted, nor the domains the
+// federation policy rejects, silenced ones only when it follows them. Pages are cut by
+// max_id and min_id like the pages of collections.
+func GetTimeline(c context.Context, ctx *app.RequestContext) {
+	user, facade, ok := prepare(c, ctx, "timeline")
+	if !ok {
+		return
+	}
+	a, err := activeActor(c, facade, user)
+	if err != nil {
+		failed(c, ctx, err)
+		return
+	}
+	pq, err := pageQuery(ctx)
+	if err != nil {
+		failed(c, ctx, err)
+		return
+	}
+	pq.Viewer = o.ID(a.ID)
+	q := actor.TimelineQuery{PageQuery: pq}
+	if rds, err := store.GetRDS(c); err == nil {
+		if p, err := federation(rds); err == nil {
+			q.Domain = func(host string) (bool, bool) {
+				d := p.For(host)
+				return d.Reject, d.Silence
+			}
+		} else {
+			log.Warnf(c, "Load the federation policy failed: %v", err)
+		}
+	}
+
+	feed := ctx.Param("feed")
+	page, err := facade.GetTimeline(feed, q)
+	if err != nil {
+		failed(c, ctx, err)
+		return
+	}
+	id := localIRI(user, "timelines/"+url.PathEscape(feed))
+	p := ap.OrderedCollectionPageNew(ap.OrderedCollectionNew(ap.ID(id)))
+	p.ID = ap.ID(pageIRI(id, cursor(pq)))
+	p.OrderedItems = ap.ItemCollection(page.Items)
+	p.First = ap.IRI(pageIRI(id, nil))
+	if page.HasOlder {
+		p.Next = ap.IRI(pageIRI(id, url.Values{"max_id": {strconv.FormatInt(page.Oldest, 10)}}))
+	}
+	if page.HasNewer {
+		p.Prev = ap.IRI(pageIRI(id, url.Values{"min_id": {strconv.FormatInt(page.Newest, 10)}}))
+	}
+	respond(c, ctx, http.StatusOK, p)
+}
+
+// RebuildHomeTimeline regenerates the home timeline of the local user of the path from
+// the stored activities, for the administrators.
+func RebuildHomeTimeline(c context.Context, ctx *app.RequestContext) {
+	user, facade, ok := prepare(c, ctx, "timeline rebuild")
+	if !ok {
+		return
+	}
+	a, err := localActor(c, facade, user)
+	if err != nil {
+		failed(c, ctx, err)
+		return
+	}
+	n, err := facade.RebuildHomeTimeline(o.ID(a.ID))
+	if err != nil {
+		failed(c, ctx, err)
+		return
+	}
+	log.Infof(c, "Home timeline of %s rebuilt by %s with %d entries", user, adminName(c), n)
+	ctx.JSON(http.StatusOK, map[string]interface{}{"actor": a.ID, "timeline": db.TimelineHome, "entries": n})
+}
+
+// GetBlocks retrieves the actors the user blocked
+func GetBlocks(c context.Context, ctx *app.RequestContext) {
+	respondCollection(c, ctx, "blocks", func(f *actor.DefaultActivityPubFacade, a *actor.Actor) (o.ItemCollection, error) {
+		return f.GetBlocks(o.ID(a.ID), db.BlockKindBlock)
+	})
+}
+
+// GetMutes retrieves the actors the user muted
+func GetMutes(c context.Context, ctx *app.RequestContext) {
+	respondCollection(c, ctx, "mutes", func(f *actor.DefaultActivityPubFacade, a *actor.Actor) (o.ItemCollection, error) {
+		return f.GetBlocks(o.ID(a.ID), db.BlockKindMute)
+	})
+}
+
+// CreateBlock blocks the actor in the object of the body, its IRI or its @user@host
+// handle
+func CreateBlock(c context.Context, ctx *app.RequestContext) {
+	relateTarget(c, ctx, "block", true, (*actor.DefaultActivityPubFacade).Block)
+}
+
+// CreateUnblock lifts the block of the actor in the object of the body
+func CreateUnblock(c context.Context, ctx *app.RequestContext) {
+	relateTarget(c, ctx, "unblock", false, (*actor.DefaultActivityPubFacade).Unblock)
+}
+
+// CreateMute mutes the actor in the object of the body, its IRI or its @user@host handle
+func CreateMute(c context.Context, ctx *app.RequestContext) {
+	relateTarget(c, ctx, "mute", false, (*actor.DefaultActivityPubFacade).Mute)
+}
+
+// CreateUnmute lifts the mute of the actor in the object of the body
+func CreateUnmute(c context.Context, ctx *app.RequestContext) {
+	relateTarget(c, ctx, "unmute", false, (*actor.DefaultActivityPubFacade).Unmute)
+}
+
+// relateTarget applies a relationship change of the local user to the actor the object
+// of the body names. With fetch set, remote actors are resolved before.
+func relateTarget(c context.Context, ctx *app.RequestContext, what string, fetch bool, fn func(f *actor.DefaultActivityPubFacade, actorID, target o.ID) error) {
+	relate(c, ctx, what, func(f *actor.DefaultActivityPubFacade, actorID, object o.ID) error {
+		target, err := resolveTarget(c, string(object), fetch)
+		if err != nil {
+			return err
+		}
+		return fn(f, actorID, o.ID(target))
+	})
+}
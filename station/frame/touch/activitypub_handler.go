@@ -2,11 +2,14 @@ package touch
 
 import (
 	"context"
-	"net/http"
 
 	"github.com/cloudwego/hertz/pkg/app"
+	log "github.com/peers-touch/peers-touch/station/frame/core/logger"
 	"github.com/peers-touch/peers-touch/station/frame/core/server"
 	"github.com/peers-touch/peers-touch/station/frame/touch/activitypub"
+	"github.com/peers-touch/peers-touch/station/frame/touch/actor"
+	"github.com/peers-touch/peers-touch/station/frame/touch/auth"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model"
 )
 
 // ActivityPubHandlerInfo represents a single handler's information
@@ -22,6 +25,36 @@ func GetActivityPubHandlers() []ActivityPubHandlerInfo {
 	commonWrapper := CommonAccessControlWrapper(RoutersNameActivityPub)
 
 	return []ActivityPubHandlerInfo{
+		{
+			RouterURL: ActivityPubRouterURLSharedInbox,
+			Handler:   PostSharedInbox,
+			Method:    server.POST,
+			Wrappers:  []server.Wrapper{commonWrapper},
+		},
+		{
+			RouterURL: ActivityPubRouterURLObject,
+			Handler:   GetObject,
+			Method:    server.GET,
+			Wrappers:  []server.Wrapper{commonWrapper},
+		},
+		{
+			RouterURL: ActivityPubRouterURLObjectReplies,
+			Handler:   GetObjectReplies,
+			Method:    server.GET,
+			Wrappers:  []server.Wrapper{commonWrapper},
+		},
+		{
+			RouterURL: ActivityPubRouterURLObjectContext,
+			Handler:   GetObjectContext,
+			Method:    server.GET,
+			Wrappers:  []server.Wrapper{commonWrapper},
+		},
+		{
+			RouterURL: ActivityPubRouterURLContext,
+			Handler:   GetObjectContext,
+			Method:    server.GET,
+			Wrappers:  []server.Wrapper{commonWrapper},
+		},
 		// User-specific ActivityPub endpoints
 		{
 			RouterURL: ActivityPubRouterURLActor,
@@ -53,6 +86,12 @@ func GetActivityPubHandlers() []ActivityPubHandlerInfo {
 			Method:    server.POST,
 			Wrappers:  []server.Wrapper{commonWrapper},
 		},
+		{
+			RouterURL: ActivityPubRouterURLDelivery,
+			Handler:   GetUserDeliveries,
+			Method:    server.GET,
+			Wrappers:  []server.Wrapper{commonWrapper},
+		},
 		{
 			RouterURL: ActivityPubRouterURLFollowers,
 			Handler:   GetUserFollowers,
@@ -96,6 +135,78 @@ func GetActivityPubHandlers() []ActivityPubHandlerInfo {
 			Method:    server.POST,
 			Wrappers:  []server.Wrapper{commonWrapper},
 		},
+		{
+			RouterURL: ActivityPubRouterURLRequests,
+			Handler:   GetFollowRequestsHandler,
+			Method:    server.GET,
+			Wrappers:  []server.Wrapper{commonWrapper},
+		},
+		{
+			RouterURL: ActivityPubRouterURLAccept,
+			Handler:   AcceptFollowHandler,
+			Method:    server.POST,
+			Wrappers:  []server.Wrapper{commonWrapper},
+		},
+		{
+			RouterURL: ActivityPubRouterURLReject,
+			Handler:   RejectFollowHandler,
+			Method:    server.POST,
+			Wrappers:  []server.Wrapper{commonWrapper},
+		},
+		{
+			RouterURL: ActivityPubRouterURLReports,
+			Handler:   CreateReportHandler,
+			Method:    server.POST,
+			Wrappers:  []server.Wrapper{commonWrapper},
+		},
+		{
+			RouterURL: ActivityPubRouterURLTimeline,
+			Handler:   GetTimelineHandler,
+			Method:    server.GET,
+			Wrappers:  []server.Wrapper{commonWrapper},
+		},
+		{
+			RouterURL: ActivityPubRouterURLBlocks,
+			Handler:   GetBlocksHandler,
+			Method:    server.GET,
+			Wrappers:  []server.Wrapper{commonWrapper},
+		},
+		{
+			RouterURL: ActivityPubRouterURLBlock,
+			Handler:   CreateBlockHandler,
+			Method:    server.POST,
+			Wrappers:  []server.Wrapper{commonWrapper},
+		},
+		{
+			RouterURL: ActivityPubRouterURLUnblock,
+			Handler:   CreateUnblockHandler,
+			Method:    server.POST,
+			Wrappers:  []server.Wrapper{commonWrapper},
+		},
+		{
+			RouterURL: ActivityPubRouterURLMutes,
+			Handler:   GetMutesHandler,
+			Method:    server.GET,
+			Wrappers:  []server.Wrapper{commonWrapper},
+		},
+		{
+			RouterURL: ActivityPubRouterURLMute,
+			Handler:   CreateMuteHandler,
+			Method:    server.POST,
+			Wrappers:  []server.Wrapper{commonWrapper},
+		},
+		{
+			RouterURL: ActivityPubRouterURLUnmute,
+			Handler:   CreateUnmuteHandler,
+			Method:    server.POST,
+			Wrappers:  []server.Wrapper{commonWrapper},
+		},
+		{
+			RouterURL: ActivityPubRouterURLChat,
+			Handler:   GetChatsHandler,
+			Method:    server.GET,
+			Wrappers:  []server.Wrapper{commonWrapper},
+		},
 		{
 			RouterURL: ActivityPubRouterURLChat,
 			Handler:   ChatHandler,
@@ -107,88 +218,187 @@ func GetActivityPubHandlers() []ActivityPubHandlerInfo {
 
 // Handler implementations
 
+// withActorOwner lets only the authenticated owner of the :username actor through, for
+// the client-to-server endpoints that act as the actor.
+func withActorOwner(next func(context.Context, *app.RequestContext)) func(context.Context, *app.RequestContext) {
+	return func(c context.Context, ctx *app.RequestContext) {
+		mw, err := auth.DefaultMiddleware(c)
+		if err != nil {
+			log.Errorf(c, "init auth middleware failed: %v", err)
+			accessFailed(ctx, err)
+			return
+		}
+		info := mw.Authenticate(c, ctx)
+		if info == nil {
+			accessFailed(ctx, model.ErrUnauthenticated)
+			return
+		}
+		a, err := actor.GetUserByID(c, info.ActorID)
+		if err != nil {
+			log.Warnf(c, "load authenticated actor %d failed: %v", info.ActorID, err)
+			accessFailed(ctx, model.ErrUnauthenticated)
+			return
+		}
+		if a.Name != ctx.Param("username") {
+			accessFailed(ctx, model.ErrActivityPubNotOwner)
+			return
+		}
+		next(c, ctx)
+	}
+}
+
+// withViewer shows the collections of the route in full to the authenticated user owning
+// them, other requests see what their HTTP signature lets them.
+func withViewer(next func(context.Context, *app.RequestContext)) func(context.Context, *app.RequestContext) {
+	return func(c context.Context, ctx *app.RequestContext) {
+		if mw, err := auth.DefaultMiddleware(c); err == nil {
+			if info := mw.Authenticate(c, ctx); info != nil {
+				if a, err := actor.GetUserByID(c, info.ActorID); err == nil && a.Name == ctx.Param("username") {
+					c = activitypub.WithOwner(c)
+				}
+			}
+		}
+		next(c, ctx)
+	}
+}
+
 func CreateFollowHandler(c context.Context, ctx *app.RequestContext) {
-	activitypub.CreateFollow(c, ctx)
+	withActorOwner(activitypub.CreateFollow)(c, ctx)
 }
 
 func CreateUnfollowHandler(c context.Context, ctx *app.RequestContext) {
-	activitypub.CreateUnfollow(c, ctx)
+	withActorOwner(activitypub.CreateUnfollow)(c, ctx)
 }
 
 func CreateLikeHandler(c context.Context, ctx *app.RequestContext) {
-	activitypub.CreateLike(c, ctx)
+	withActorOwner(activitypub.CreateLike)(c, ctx)
 }
 
 func CreateUndoHandler(c context.Context, ctx *app.RequestContext) {
-	activitypub.CreateUndo(c, ctx)
+	withActorOwner(activitypub.CreateUndo)(c, ctx)
+}
+
+func GetFollowRequestsHandler(c context.Context, ctx *app.RequestContext) {
+	withActorOwner(activitypub.GetFollowRequests)(c, ctx)
+}
+
+func AcceptFollowHandler(c context.Context, ctx *app.RequestContext) {
+	withActorOwner(activitypub.AcceptFollow)(c, ctx)
+}
+
+func RejectFollowHandler(c context.Context, ctx *app.RequestContext) {
+	withActorOwner(activitypub.RejectFollow)(c, ctx)
 }
 
+// CreateReportHandler handles the reports users file for the moderators
+func CreateReportHandler(c context.Context, ctx *app.RequestContext) {
+	withActorOwner(activitypub.CreateReport)(c, ctx)
+}
+
+// GetTimelineHandler handles the home, local and federated timelines of the user
+func GetTimelineHandler(c context.Context, ctx *app.RequestContext) {
+	withActorOwner(activitypub.GetTimeline)(c, ctx)
+}
+
+func GetBlocksHandler(c context.Context, ctx *app.RequestContext) {
+	withActorOwner(activitypub.GetBlocks)(c, ctx)
+}
+
+func CreateBlockHandler(c context.Context, ctx *app.RequestContext) {
+	withActorOwner(activitypub.CreateBlock)(c, ctx)
+}
+
+func CreateUnblockHandler(c context.Context, ctx *app.RequestContext) {
+	withActorOwner(activitypub.CreateUnblock)(c, ctx)
+}
+
+func GetMutesHandler(c context.Context, ctx *app.RequestContext) {
+	withActorOwner(activitypub.GetMutes)(c, ctx)
+}
+
+func CreateMuteHandler(c context.Context, ctx *app.RequestContext) {
+	withActorOwner(activitypub.CreateMute)(c, ctx)
+}
+
+func CreateUnmuteHandler(c context.Context, ctx *app.RequestContext) {
+	withActorOwner(activitypub.CreateUnmute)(c, ctx)
+}
+
+// GetChatsHandler lists the conversations of the user bridged with remote actors
+func GetChatsHandler(c context.Context, ctx *app.RequestContext) {
+	withActorOwner(activitypub.GetChats)(c, ctx)
+}
+
+// ChatHandler opens the conversation of the user bridged with a remote actor
 func ChatHandler(c context.Context, ctx *app.RequestContext) {
-	ctx.String(http.StatusOK, "Chat endpoint not implemented yet")
+	withActorOwner(activitypub.OpenChat)(c, ctx)
 }
 
 // User ActivityPub Handler Functions
 
 // GetUserActor handles GET requests for user actor
 func GetUserActor(c context.Context, ctx *app.RequestContext) {
-	// TODO: Implement ActivityPub user actor endpoint
-	ctx.JSON(200, map[string]interface{}{
-		"message": "GetUserActor not implemented yet",
-	})
+	activitypub.GetActor(c, ctx)
 }
 
-// GetUserInbox handles GET requests for user inbox
+// GetUserInbox handles GET requests for user inbox, only its owner reads it
 func GetUserInbox(c context.Context, ctx *app.RequestContext) {
-	// TODO: Implement ActivityPub user inbox GET endpoint
-	ctx.JSON(200, map[string]interface{}{
-		"message": "GetUserInbox not implemented yet",
-	})
+	withActorOwner(activitypub.GetInboxActivities)(c, ctx)
 }
 
 // PostUserInbox handles POST requests for user inbox
 func PostUserInbox(c context.Context, ctx *app.RequestContext) {
-	// TODO: Implement ActivityPub user inbox POST endpoint
-	ctx.JSON(200, map[string]interface{}{
-		"message": "PostUserInbox not implemented yet",
-	})
+	activitypub.HandleInboxActivity(c, ctx)
+}
+
+// PostSharedInbox handles POST requests for the shared inbox
+func PostSharedInbox(c context.Context, ctx *app.RequestContext) {
+	activitypub.HandleSharedInbox(c, ctx)
+}
+
+// GetObject handles GET requests for an object of the local actors
+func GetObject(c context.Context, ctx *app.RequestContext) {
+	activitypub.GetObject(c, ctx)
+}
+
+// GetObjectReplies handles GET requests for the replies of an object of the local actors
+func GetObjectReplies(c context.Context, ctx *app.RequestContext) {
+	activitypub.GetReplies(c, ctx)
+}
+
+// GetObjectContext handles GET requests for the thread of an object of the local actors,
+// or of a stored remote one
+func GetObjectContext(c context.Context, ctx *app.RequestContext) {
+	activitypub.GetContext(c, ctx)
 }
 
 // GetUserOutbox handles GET requests for user outbox
 func GetUserOutbox(c context.Context, ctx *app.RequestContext) {
-	// TODO: Implement ActivityPub user outbox GET endpoint
-	ctx.JSON(200, map[string]interface{}{
-		"message": "GetUserOutbox not implemented yet",
-	})
+	withViewer(activitypub.GetOutboxActivities)(c, ctx)
 }
 
 // PostUserOutbox handles POST requests for user outbox
 func PostUserOutbox(c context.Context, ctx *app.RequestContext) {
-	// TODO: Implement ActivityPub user outbox POST endpoint
-	ctx.JSON(200, map[string]interface{}{
-		"message": "PostUserOutbox not implemented yet",
-	})
+	withActorOwner(activitypub.CreateOutboxActivity)(c, ctx)
+}
+
+// GetUserDeliveries handles GET requests for the delivery status of a user's outbox,
+// only its owner reads it
+func GetUserDeliveries(c context.Context, ctx *app.RequestContext) {
+	withActorOwner(activitypub.GetDeliveries)(c, ctx)
 }
 
 // GetUserFollowers handles GET requests for user followers
 func GetUserFollowers(c context.Context, ctx *app.RequestContext) {
-	// TODO: Implement ActivityPub user followers endpoint
-	ctx.JSON(200, map[string]interface{}{
-		"message": "GetUserFollowers not implemented yet",
-	})
+	withViewer(activitypub.GetFollowers)(c, ctx)
 }
 
 // GetUserFollowing handles GET requests for user following
 func GetUserFollowing(c context.Context, ctx *app.RequestContext) {
-	// TODO: Implement ActivityPub user following endpoint
-	ctx.JSON(200, map[string]interface{}{
-		"message": "GetUserFollowing not implemented yet",
-	})
+	withViewer(activitypub.GetFollowing)(c, ctx)
 }
 
 // GetUserLiked handles GET requests for user liked
 func GetUserLiked(c context.Context, ctx *app.RequestContext) {
-	// TODO: Implement ActivityPub user liked endpoint
-	ctx.JSON(200, map[string]interface{}{
-		"message": "GetUserLiked not implemented yet",
-	})
+	withViewer(activitypub.GetLiked)(c, ctx)
 }
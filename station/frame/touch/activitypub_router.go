@@ -4,11 +4,25 @@ import (
 	"github.com/peers-touch/peers-touch/station/frame/core/server"
 )
 
+// The first segments of the routes below are names signup refuses, see model.ReservedNames,
+// as the routes of users of these names could not be reached.
 const (
-	// ActivityPub URLs (all user-scoped)
+	// ActivityPubRouterURLSharedInbox is the inbox shared by the local actors
+	ActivityPubRouterURLSharedInbox RouterPath = "/inbox"
+	// ActivityPubRouterURLObject is an object the local actors published
+	ActivityPubRouterURLObject RouterPath = "/objects/:id"
+	// ActivityPubRouterURLObjectReplies is the collection of the public replies of an object
+	ActivityPubRouterURLObjectReplies RouterPath = "/objects/:id/replies"
+	// ActivityPubRouterURLObjectContext is the thread of an object as one tree
+	ActivityPubRouterURLObjectContext RouterPath = "/objects/:id/context"
+	// ActivityPubRouterURLContext is the thread of the stored remote object of the iri query
+	ActivityPubRouterURLContext RouterPath = "/context"
+
+	// ActivityPub URLs (user-scoped)
 	ActivityPubRouterURLActor     RouterPath = "/:username/actor"
 	ActivityPubRouterURLInbox     RouterPath = "/:username/inbox"
 	ActivityPubRouterURLOutbox    RouterPath = "/:username/outbox"
+	ActivityPubRouterURLDelivery  RouterPath = "/:username/outbox/deliveries"
 	ActivityPubRouterURLFollowers RouterPath = "/:username/followers"
 	ActivityPubRouterURLFollowing RouterPath = "/:username/following"
 	ActivityPubRouterURLLiked     RouterPath = "/:username/liked"
@@ -16,7 +30,18 @@ const (
 	ActivityPubRouterURLUnfollow  RouterPath = "/:username/unfollow"
 	ActivityPubRouterURLLike      RouterPath = "/:username/like"
 	ActivityPubRouterURLUndo      RouterPath = "/:username/undo"
+	ActivityPubRouterURLRequests  RouterPath = "/:username/follow_requests"
+	ActivityPubRouterURLAccept    RouterPath = "/:username/accept"
+	ActivityPubRouterURLReject    RouterPath = "/:username/reject"
 	ActivityPubRouterURLChat      RouterPath = "/:username/chat"
+	ActivityPubRouterURLReports   RouterPath = "/:username/reports"
+	ActivityPubRouterURLTimeline  RouterPath = "/:username/timelines/:feed"
+	ActivityPubRouterURLBlocks    RouterPath = "/:username/blocks"
+	ActivityPubRouterURLBlock     RouterPath = "/:username/block"
+	ActivityPubRouterURLUnblock   RouterPath = "/:username/unblock"
+	ActivityPubRouterURLMutes     RouterPath = "/:username/mutes"
+	ActivityPubRouterURLMute      RouterPath = "/:username/mute"
+	ActivityPubRouterURLUnmute    RouterPath = "/:username/unmute"
 )
 
 // ActivityPubRouters provides general ActivityPub endpoints
@@ -7,6 +7,7 @@ import (
 	log "github.com/peers-touch/peers-touch/station/frame/core/logger"
 	"github.com/peers-touch/peers-touch/station/frame/core/store"
 	"github.com/peers-touch/peers-touch/station/frame/core/util/id"
+	"github.com/peers-touch/peers-touch/station/frame/touch/activitypub"
 	"github.com/peers-touch/peers-touch/station/frame/touch/model"
 	"github.com/peers-touch/peers-touch/station/frame/touch/model/db"
 	"golang.org/x/crypto/bcrypt"
@@ -90,6 +91,12 @@ func SignUp(c context.Context, actorParams *model.ActorSignParams) error {
 		return err
 	}
 
+	// Part 3: Create the ActivityPub actor with its signing keys, it is created on first
+	// use when this fails
+	if _, err = activitypub.ProvisionActor(c, a.Name); err != nil {
+		log.Warnf(c, "[SignUp] Provision ActivityPub actor err: %v", err)
+	}
+
 	log.Infof(c, "[SignUp] Actor and profile created successfully for actor %s with peers ID %s", a.Name, a.PeersActorID)
 	return nil
 }
@@ -6,7 +6,6 @@ import (
 	"encoding/hex"
 	"time"
 
-	"github.com/peers-touch/peers-touch/station/frame/core/store"
 	"github.com/peers-touch/peers-touch/station/frame/touch/model/db"
 )
 
@@ -123,17 +122,14 @@ type SessionLoginResult struct {
 
 // LoginWithSession handles JWT authentication and session creation
 func LoginWithSession(ctx context.Context, credentials *Credentials, clientIP, userAgent string) (*SessionLoginResult, error) {
-	// Get database connection for JWT provider
-	rds, err := store.GetRDS(ctx)
+	// Use the shared middleware so that the session is visible to authenticated routes
+	mw, err := DefaultMiddleware(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	// Initialize JWT provider
-	jwtProvider := NewJWTProvider(rds, "your-secret-key", 24*time.Hour, 7*24*time.Hour)
-
 	// Authenticate user
-	authResult, err := jwtProvider.Authenticate(ctx, credentials)
+	authResult, err := mw.jwtProvider.Authenticate(ctx, credentials)
 	if err != nil {
 		return nil, err
 	}
@@ -144,12 +140,8 @@ func LoginWithSession(ctx context.Context, credentials *Credentials, clientIP, u
 		return nil, err
 	}
 
-	// Create session manager and store session
-	sessionStore := NewMemorySessionStore(24 * time.Hour)
-	sessionManager := NewSessionManager(sessionStore, 24*time.Hour)
-
 	// Create session
-	session, err := sessionManager.CreateSession(ctx, authResult.Actor, sessionID, clientIP, userAgent)
+	session, err := mw.sessionManager.CreateSession(ctx, authResult.Actor, sessionID, clientIP, userAgent)
 	if err != nil {
 		return nil, err
 	}
@@ -4,9 +4,11 @@ import (
 	"context"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/cloudwego/hertz/pkg/app"
+	cfg "github.com/peers-touch/peers-touch/station/frame/core/config"
 	log "github.com/peers-touch/peers-touch/station/frame/core/logger"
 	"github.com/peers-touch/peers-touch/station/frame/core/store"
 )
@@ -18,6 +20,27 @@ const (
 	DefaultSessionDuration      = 24 * time.Hour
 )
 
+var (
+	defaultMiddleware     *AuthMiddleware
+	defaultMiddlewareErr  error
+	defaultMiddlewareOnce sync.Once
+)
+
+// DefaultMiddleware returns the process wide middleware. Logins and authenticated routes
+// share its JWT secret and session store. The secret is read from
+// peers.touch.security.jwt.secret, without it a random one is used and tokens don't
+// survive restarts.
+func DefaultMiddleware(c context.Context) (*AuthMiddleware, error) {
+	defaultMiddlewareOnce.Do(func() {
+		secret := cfg.Get("peers", "touch", "security", "jwt", "secret").String("")
+		if secret == "" {
+			log.Warnf(c, "peers.touch.security.jwt.secret is not set, using a random JWT secret")
+		}
+		defaultMiddleware, defaultMiddlewareErr = CreateAuthMiddleware(c, secret)
+	})
+	return defaultMiddleware, defaultMiddlewareErr
+}
+
 // AuthMiddleware provides authentication middleware functionality
 type AuthMiddleware struct {
 	jwtProvider    *JWTProvider
@@ -96,6 +119,15 @@ func (m *AuthMiddleware) RequireSession() func(context.Context, *app.RequestCont
 	}
 }
 
+// Authenticate returns the caller identified by a JWT bearer token or a session cookie,
+// nil if there is none. Unlike the Require* middlewares it writes nothing to the response.
+func (m *AuthMiddleware) Authenticate(c context.Context, ctx *app.RequestContext) *TokenInfo {
+	if userInfo := m.authenticateWithJWT(c, ctx); userInfo != nil {
+		return userInfo
+	}
+	return m.authenticateWithSession(c, ctx)
+}
+
 // authenticateWithJWT attempts to authenticate using JWT token from Authorization header
 func (m *AuthMiddleware) authenticateWithJWT(c context.Context, ctx *app.RequestContext) *TokenInfo {
 	if m.jwtProvider == nil {
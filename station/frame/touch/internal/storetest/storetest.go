@@ -0,0 +1,111 @@
+// Package storetest backs the tests of the touch packages with a SQLite store migrated
+// like the one of a station.
+package storetest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/peers-touch/peers-touch/station/frame/core/option"
+	"github.com/peers-touch/peers-touch/station/frame/core/store"
+	"github.com/peers-touch/peers-touch/station/frame/touch/message/search"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type testStore struct {
+	db *gorm.DB
+}
+
+func (s *testStore) Init(ctx context.Context, opts ...option.Option) error { return nil }
+
+func (s *testStore) RDS(ctx context.Context, opts ...store.RDSDMLOption) (*gorm.DB, error) {
+	return s.db, nil
+}
+
+func (s *testStore) Name() string { return "storetest" }
+
+// Main injects the store and runs the tests of the package, call it from TestMain. The
+// hooks of store.InitTableHooks are not run, they start the background jobs too.
+func Main(m *testing.M) {
+	os.Exit(run(m))
+}
+
+func run(m *testing.M) int {
+	dir, err := os.MkdirTemp("", "storetest")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+
+	// a file, not :memory:, so that every connection of the pool sees the same database
+	dsn := filepath.Join(dir, "touch.db") + "?_busy_timeout=5000&_journal_mode=WAL"
+	rds, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err == nil {
+		err = db.AutoMigrate(rds)
+	}
+	if err == nil {
+		err = migrateSearch(rds)
+	}
+	if err == nil {
+		err = store.InjectStore(context.Background(), &testStore{db: rds})
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "storetest:", err)
+		return 1
+	}
+	return m.Run()
+}
+
+// Reset empties every table, so that the test starts from an empty store, and returns it.
+func Reset(t testing.TB) *gorm.DB {
+	t.Helper()
+	rds, err := store.GetRDS(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables, err := rds.Migrator().GetTables()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var virtual []string
+	if err := rds.Raw("SELECT name FROM sqlite_master WHERE type = 'table' AND sql LIKE 'CREATE VIRTUAL TABLE%'").Scan(&virtual).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range tables {
+		if shadow(table, virtual) {
+			continue
+		}
+		if err := rds.Exec("DELETE FROM " + table).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rds
+}
+
+// migrateSearch creates the message search index, a station does it in an init hook.
+func migrateSearch(rds *gorm.DB) error {
+	idx, err := search.ForDB(rds)
+	if err != nil {
+		return err
+	}
+	return idx.Migrate(context.Background())
+}
+
+// shadow reports whether table backs one of the virtual tables, emptying the virtual
+// table empties it already and deleting its rows directly corrupts the index.
+func shadow(table string, virtual []string) bool {
+	for _, v := range virtual {
+		if strings.HasPrefix(table, v+"_") {
+			return true
+		}
+	}
+	return false
+}
@@ -5,7 +5,13 @@ import (
 	"net/http"
 
 	"github.com/cloudwego/hertz/pkg/app"
+	cfg "github.com/peers-touch/peers-touch/station/frame/core/config"
+	log "github.com/peers-touch/peers-touch/station/frame/core/logger"
 	"github.com/peers-touch/peers-touch/station/frame/core/server"
+	"github.com/peers-touch/peers-touch/station/frame/touch/activitypub"
+	"github.com/peers-touch/peers-touch/station/frame/touch/actor"
+	"github.com/peers-touch/peers-touch/station/frame/touch/auth"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model"
 )
 
 // ManageHandlerInfo represents a single handler's information
@@ -27,6 +33,54 @@ func GetManageHandlers() []ManageHandlerInfo {
 			Method:    server.GET,
 			Wrappers:  []server.Wrapper{commonWrapper},
 		},
+		{
+			RouterURL: ManageRouterURLDomainPolicies,
+			Handler:   withAdmin(activitypub.GetDomainPolicies),
+			Method:    server.GET,
+			Wrappers:  []server.Wrapper{commonWrapper},
+		},
+		{
+			RouterURL: ManageRouterURLDomainPolicy,
+			Handler:   withAdmin(activitypub.PutDomainPolicy),
+			Method:    server.PUT,
+			Wrappers:  []server.Wrapper{commonWrapper},
+		},
+		{
+			RouterURL: ManageRouterURLDomainPolicy,
+			Handler:   withAdmin(activitypub.DeleteDomainPolicy),
+			Method:    server.DELETE,
+			Wrappers:  []server.Wrapper{commonWrapper},
+		},
+		{
+			RouterURL: ManageRouterURLPolicyAudits,
+			Handler:   withAdmin(activitypub.GetPolicyAudits),
+			Method:    server.GET,
+			Wrappers:  []server.Wrapper{commonWrapper},
+		},
+		{
+			RouterURL: ManageRouterURLReports,
+			Handler:   withAdmin(activitypub.GetReports),
+			Method:    server.GET,
+			Wrappers:  []server.Wrapper{commonWrapper},
+		},
+		{
+			RouterURL: ManageRouterURLReport,
+			Handler:   withAdmin(activitypub.GetReport),
+			Method:    server.GET,
+			Wrappers:  []server.Wrapper{commonWrapper},
+		},
+		{
+			RouterURL: ManageRouterURLReportAction,
+			Handler:   withAdmin(activitypub.PostReportAction),
+			Method:    server.POST,
+			Wrappers:  []server.Wrapper{commonWrapper},
+		},
+		{
+			RouterURL: ManageRouterURLRebuildTimeline,
+			Handler:   withAdmin(activitypub.RebuildHomeTimeline),
+			Method:    server.POST,
+			Wrappers:  []server.Wrapper{commonWrapper},
+		},
 		// {
 		// 	RouterURL: ManageRouterURLPing,
 		// 	Handler:   PingHandler,
@@ -41,3 +95,34 @@ func GetManageHandlers() []ManageHandlerInfo {
 func HealthHandler(c context.Context, ctx *app.RequestContext) {
 	ctx.String(http.StatusOK, "hello world, health")
 }
+
+// withAdmin lets only the administrators of the station through, the actors named in
+// peers.touch.management.admins, by name or email. They moderate the reports too.
+func withAdmin(next func(context.Context, *app.RequestContext)) func(context.Context, *app.RequestContext) {
+	return func(c context.Context, ctx *app.RequestContext) {
+		mw, err := auth.DefaultMiddleware(c)
+		if err != nil {
+			log.Errorf(c, "init auth middleware failed: %v", err)
+			accessFailed(ctx, err)
+			return
+		}
+		info := mw.Authenticate(c, ctx)
+		if info == nil {
+			accessFailed(ctx, model.ErrUnauthenticated)
+			return
+		}
+		a, err := actor.GetUserByID(c, info.ActorID)
+		if err != nil {
+			log.Warnf(c, "load authenticated actor %d failed: %v", info.ActorID, err)
+			accessFailed(ctx, model.ErrUnauthenticated)
+			return
+		}
+		for _, admin := range cfg.Get("peers", "touch", "management", "admins").StringSlice(nil) {
+			if admin != "" && (admin == a.Name || admin == a.Email) {
+				next(activitypub.WithAdmin(c, a.Name), ctx)
+				return
+			}
+		}
+		accessFailed(ctx, model.ErrNotAdmin)
+	}
+}
@@ -7,6 +7,16 @@ import (
 const (
 	ManageRouterURLHealth RouterPath = "/health"
 	ManageRouterURLPing   RouterPath = "/ping"
+
+	ManageRouterURLDomainPolicies RouterPath = "/federation/domains"
+	ManageRouterURLDomainPolicy   RouterPath = "/federation/domains/:domain"
+	ManageRouterURLPolicyAudits   RouterPath = "/federation/audits"
+
+	ManageRouterURLReports      RouterPath = "/reports"
+	ManageRouterURLReport       RouterPath = "/reports/:id"
+	ManageRouterURLReportAction RouterPath = "/reports/:id/actions"
+
+	ManageRouterURLRebuildTimeline RouterPath = "/timelines/:username/rebuild"
 )
 
 // ManageRouters provides management endpoints for the service
@@ -0,0 +1,63 @@
+// Package blob stores the bytes of attachments. Blobs are immutable and addressed by
+// their CID, see package cas; each attachment names the backend holding it in
+// Attachment.Store.
+package blob
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "strings"
+    "sync"
+)
+
+// StoreLocal is the default backend, a directory of the local filesystem.
+const StoreLocal = "local"
+
+var (
+    ErrNotFound         = errors.New("blob not found")
+    ErrStoreUnsupported = func(name string) error { return fmt.Errorf("no blob store named[%s]", name) }
+)
+
+// Store is a blob backend.
+type Store interface {
+    // Put stores size bytes read from r under cid. Storing a cid that exists already is
+    // a no-op, the content is the same by construction.
+    Put(ctx context.Context, cid string, r io.Reader, size int64) error
+    // Open returns length bytes of the blob starting at offset, length < 0 reads to the end.
+    Open(ctx context.Context, cid string, offset, length int64) (io.ReadCloser, error)
+    // Stat returns the size of the blob, ErrNotFound if there is none.
+    Stat(ctx context.Context, cid string) (int64, error)
+    Delete(ctx context.Context, cid string) error
+}
+
+var (
+    lock   sync.RWMutex
+    stores = make(map[string]Store)
+)
+
+// Register makes a backend available under name, the value of Attachment.Store. A later
+// registration replaces the former one, so tests and deployments can swap backends.
+func Register(name string, s Store) {
+    lock.Lock()
+    defer lock.Unlock()
+    stores[name] = s
+}
+
+// Get returns the backend named name, the local one if name is empty.
+func Get(name string) (Store, error) {
+    if name == "" { name = StoreLocal }
+    lock.RLock()
+    defer lock.RUnlock()
+    s, ok := stores[name]
+    if !ok { return nil, ErrStoreUnsupported(name) }
+    return s, nil
+}
+
+// shard spreads blobs over subdirectories named by the last two characters of their CID,
+// the first ones are the same for every CID.
+func shard(cid string) string {
+    if len(cid) < 2 { return cid }
+    return strings.ToLower(cid[len(cid)-2:])
+}
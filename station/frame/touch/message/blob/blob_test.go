@@ -0,0 +1,119 @@
+package blob
+
+import (
+    "bytes"
+    "context"
+    "crypto/sha256"
+    "errors"
+    "io"
+    "strings"
+    "testing"
+
+    "github.com/peers-touch/peers-touch/station/frame/touch/message/cas"
+)
+
+func testStore(t *testing.T, s Store) {
+    ctx := context.Background()
+    data := []byte("the quick brown fox jumps over the lazy dog")
+    cid := cas.Sum(data)
+
+    if _, err := s.Stat(ctx, cid); !errors.Is(err, ErrNotFound) { t.Fatalf("stat of a missing blob: %v", err) }
+    if err := s.Put(ctx, cid, bytes.NewReader(data), int64(len(data))); err != nil { t.Fatal(err) }
+    if err := s.Put(ctx, cid, bytes.NewReader(data), int64(len(data))); err != nil { t.Fatalf("put twice: %v", err) }
+    if n, err := s.Stat(ctx, cid); err != nil || n != int64(len(data)) { t.Fatalf("stat = %d, %v", n, err) }
+
+    for _, tc := range []struct {
+        offset, length int64
+        want           string
+    }{
+        {0, -1, string(data)},
+        {4, 5, "quick"},
+        {40, -1, "dog"},
+        {40, 10, "dog"},
+    } {
+        rc, err := s.Open(ctx, cid, tc.offset, tc.length)
+        if err != nil { t.Fatal(err) }
+        got, err := io.ReadAll(rc)
+        rc.Close()
+        if err != nil { t.Fatal(err) }
+        if string(got) != tc.want { t.Errorf("open(%d, %d) = %q, want %q", tc.offset, tc.length, got, tc.want) }
+    }
+
+    if err := s.Delete(ctx, cid); err != nil { t.Fatal(err) }
+    if _, err := s.Open(ctx, cid, 0, -1); !errors.Is(err, ErrNotFound) { t.Fatalf("open after delete: %v", err) }
+    if err := s.Put(ctx, "../../etc/passwd", strings.NewReader("x"), 1); !errors.Is(err, cas.ErrInvalidCID) { t.Fatalf("put with a path as cid: %v", err) }
+}
+
+func TestLocalStore(t *testing.T) { testStore(t, NewLocalStore(t.TempDir())) }
+
+func TestS3Store(t *testing.T) { testStore(t, NewS3Store(NewMemObjectClient(), "touch", "blobs")) }
+
+func TestRegistry(t *testing.T) {
+    s := NewS3Store(NewMemObjectClient(), "touch", "")
+    Register("s3-test", s)
+    got, err := Get("s3-test")
+    if err != nil || got != s { t.Fatalf("get = %v, %v", got, err) }
+    if _, err := Get("nope"); err == nil { t.Fatal("unknown store resolved") }
+}
+
+func TestSpoolResume(t *testing.T) {
+    sp := NewSpool(t.TempDir())
+    n, err := sp.Append("u1", 0, strings.NewReader("hello "), 11)
+    if err != nil || n != 6 { t.Fatalf("first chunk = %d, %v", n, err) }
+    if _, err := sp.Append("u1", 3, strings.NewReader("x"), 8); err != nil { t.Fatalf("replaying an unacknowledged tail: %v", err) }
+    if _, err := sp.Append("u1", 9, strings.NewReader("x"), 2); !errors.Is(err, ErrOffsetMismatch) { t.Fatalf("gap: %v", err) }
+
+    if n, err = sp.Append("u1", 3, strings.NewReader("lo world!"), 8); !errors.Is(err, ErrTooLarge) || n != 3 { t.Fatalf("oversized chunk = %d, %v", n, err) }
+    if size, _ := sp.Size("u1"); size != 3 { t.Fatalf("size after a rejected chunk = %d, want 3", size) }
+    if n, err = sp.Append("u1", 3, strings.NewReader("lo world"), 8); err != nil || n != 11 { t.Fatalf("resumed chunk = %d, %v", n, err) }
+
+    f, err := sp.Open("u1")
+    if err != nil { t.Fatal(err) }
+    got, _ := io.ReadAll(f)
+    f.Close()
+    if string(got) != "hello world" { t.Fatalf("spooled %q", got) }
+    if err := sp.Remove("u1"); err != nil { t.Fatal(err) }
+    if _, err := sp.Append("../u1", 0, strings.NewReader("x"), 1); !errors.Is(err, ErrInvalidUpload) { t.Fatalf("path as upload id: %v", err) }
+}
+
+func TestParseDigest(t *testing.T) {
+    sum := sha256.Sum256([]byte("abc"))
+    v := FormatDigest(sum[:])
+    for _, in := range []string{v, "SHA-256=" + v[len("sha-256="):], "sha-256=:" + v[len("sha-256="):] + ":", "md5=xyz, " + v} {
+        d, err := ParseDigest(in)
+        if err != nil || !bytes.Equal(d, sum[:]) { t.Errorf("ParseDigest(%q) = %x, %v", in, d, err) }
+    }
+    for _, in := range []string{"", "md5=xyz", "sha-256=abc", "sha-256=" + strings.Repeat("A", 40)} {
+        if _, err := ParseDigest(in); !errors.Is(err, ErrInvalidDigest) { t.Errorf("ParseDigest(%q) err = %v", in, err) }
+    }
+}
+
+func TestParseRange(t *testing.T) {
+    for _, tc := range []struct {
+        h               string
+        offset, length  int64
+        partial, failed bool
+    }{
+        {"", 0, 100, false, false},
+        {"bytes=0-9", 0, 10, true, false},
+        {"bytes=90-", 90, 10, true, false},
+        {"bytes=90-200", 90, 10, true, false},
+        {"bytes=-5", 95, 5, true, false},
+        {"bytes=-500", 0, 100, true, false},
+        {"bytes=0-1,5-6", 0, 100, false, false},
+        {"items=0-1", 0, 100, false, false},
+        {"bytes=100-", 0, 0, false, true},
+        {"bytes=5-2", 0, 0, false, true},
+        {"bytes=x-", 0, 0, false, true},
+    } {
+        off, n, partial, err := ParseRange(tc.h, 100)
+        if tc.failed {
+            if !errors.Is(err, ErrRangeUnsatisfiable) { t.Errorf("ParseRange(%q) err = %v", tc.h, err) }
+            continue
+        }
+        if err != nil || off != tc.offset || n != tc.length || partial != tc.partial {
+            t.Errorf("ParseRange(%q) = %d, %d, %v, %v", tc.h, off, n, partial, err)
+        }
+    }
+    if got := ContentRange(90, 10, 100); got != "bytes 90-99/100" { t.Errorf("ContentRange = %q", got) }
+}
@@ -0,0 +1,64 @@
+package blob
+
+import (
+    "encoding/base64"
+    "errors"
+    "strconv"
+    "strings"
+)
+
+var (
+    ErrInvalidDigest      = errors.New("digest should be sha-256=<base64>")
+    ErrRangeUnsatisfiable = errors.New("range not satisfiable")
+)
+
+const digestAlg = "sha-256"
+
+// ParseDigest returns the sha256 digest of a Digest (RFC 3230) or Content-Digest
+// (RFC 9530) header value, e.g. "sha-256=X48E9q..." or "sha-256=:X48E9q...:". Other
+// algorithms of a list are skipped.
+func ParseDigest(v string) ([]byte, error) {
+    for _, part := range strings.Split(v, ",") {
+        alg, val, ok := strings.Cut(strings.TrimSpace(part), "=")
+        if !ok || !strings.EqualFold(alg, digestAlg) { continue }
+        d, err := base64.StdEncoding.DecodeString(strings.Trim(val, ":"))
+        if err != nil || len(d) != 32 { return nil, ErrInvalidDigest }
+        return d, nil
+    }
+    return nil, ErrInvalidDigest
+}
+
+// FormatDigest returns the Digest header value of a sha256 digest.
+func FormatDigest(d []byte) string { return digestAlg + "=" + base64.StdEncoding.EncodeToString(d) }
+
+// ParseRange resolves a Range header against a blob of size bytes. It returns the part
+// to send and whether it is partial; an empty header, multiple ranges or another unit
+// get the whole blob, as RFC 9110 lets servers ignore them.
+func ParseRange(h string, size int64) (offset, length int64, partial bool, err error) {
+    spec, ok := strings.CutPrefix(strings.TrimSpace(h), "bytes=")
+    if !ok || strings.Contains(spec, ",") { return 0, size, false, nil }
+    first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+    if !ok { return 0, 0, false, ErrRangeUnsatisfiable }
+
+    if first == "" {
+        // suffix range, the last n bytes
+        n, err := strconv.ParseInt(last, 10, 64)
+        if err != nil || n <= 0 || size == 0 { return 0, 0, false, ErrRangeUnsatisfiable }
+        if n > size { n = size }
+        return size - n, n, true, nil
+    }
+    start, err := strconv.ParseInt(first, 10, 64)
+    if err != nil || start < 0 || start >= size { return 0, 0, false, ErrRangeUnsatisfiable }
+    end := size - 1
+    if last != "" {
+        e, err := strconv.ParseInt(last, 10, 64)
+        if err != nil || e < start { return 0, 0, false, ErrRangeUnsatisfiable }
+        if e < end { end = e }
+    }
+    return start, end - start + 1, true, nil
+}
+
+// ContentRange returns the Content-Range header value of a partial response.
+func ContentRange(offset, length, size int64) string {
+    return "bytes " + strconv.FormatInt(offset, 10) + "-" + strconv.FormatInt(offset+length-1, 10) + "/" + strconv.FormatInt(size, 10)
+}
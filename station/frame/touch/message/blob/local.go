@@ -0,0 +1,76 @@
+package blob
+
+import (
+    "context"
+    "errors"
+    "io"
+    "os"
+    "path/filepath"
+
+    "github.com/peers-touch/peers-touch/station/frame/touch/message/cas"
+)
+
+// LocalStore keeps blobs as files below Root.
+type LocalStore struct {
+    Root string
+}
+
+func NewLocalStore(root string) *LocalStore { return &LocalStore{Root: root} }
+
+func (s *LocalStore) Put(ctx context.Context, cid string, r io.Reader, size int64) error {
+    path, err := s.path(cid)
+    if err != nil { return err }
+    if _, err := os.Stat(path); err == nil { return nil }
+    if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil { return err }
+
+    // write aside and rename, readers never see a partial blob
+    tmp, err := os.CreateTemp(filepath.Dir(path), ".put-*")
+    if err != nil { return err }
+    defer os.Remove(tmp.Name())
+    n, err := io.Copy(tmp, r)
+    if cerr := tmp.Close(); err == nil { err = cerr }
+    if err != nil { return err }
+    if n != size { return io.ErrUnexpectedEOF }
+    return os.Rename(tmp.Name(), path)
+}
+
+func (s *LocalStore) Open(ctx context.Context, cid string, offset, length int64) (io.ReadCloser, error) {
+    path, err := s.path(cid)
+    if err != nil { return nil, err }
+    f, err := os.Open(path)
+    if errors.Is(err, os.ErrNotExist) { return nil, ErrNotFound }
+    if err != nil { return nil, err }
+    if _, err := f.Seek(offset, io.SeekStart); err != nil { f.Close(); return nil, err }
+    if length < 0 { return f, nil }
+    return &limitedFile{Reader: io.LimitReader(f, length), f: f}, nil
+}
+
+func (s *LocalStore) Stat(ctx context.Context, cid string) (int64, error) {
+    path, err := s.path(cid)
+    if err != nil { return 0, err }
+    fi, err := os.Stat(path)
+    if errors.Is(err, os.ErrNotExist) { return 0, ErrNotFound }
+    if err != nil { return 0, err }
+    return fi.Size(), nil
+}
+
+func (s *LocalStore) Delete(ctx context.Context, cid string) error {
+    path, err := s.path(cid)
+    if err != nil { return err }
+    err = os.Remove(path)
+    if errors.Is(err, os.ErrNotExist) { return nil }
+    return err
+}
+
+// path maps a CID to its file. Only well formed CIDs get a path, so a CID never escapes Root.
+func (s *LocalStore) path(cid string) (string, error) {
+    if _, err := cas.Digest(cid); err != nil { return "", err }
+    return filepath.Join(s.Root, shard(cid), cid), nil
+}
+
+type limitedFile struct {
+    io.Reader
+    f *os.File
+}
+
+func (l *limitedFile) Close() error { return l.f.Close() }
@@ -0,0 +1,62 @@
+package blob
+
+import (
+    "context"
+    "io"
+    "path"
+
+    "github.com/peers-touch/peers-touch/station/frame/touch/message/cas"
+)
+
+// ObjectClient is the subset of the S3 API the S3 store relies on. Adapters of S3
+// compatible SDKs implement it; MemObjectClient is an in-memory stand-in.
+type ObjectClient interface {
+    PutObject(ctx context.Context, bucket, key string, body io.Reader, size int64) error
+    // GetObject reads the object from offset, length < 0 reads to the end, like a
+    // "Range: bytes=offset-" request.
+    GetObject(ctx context.Context, bucket, key string, offset, length int64) (io.ReadCloser, error)
+    // HeadObject returns the object size, ErrNotFound if there is none.
+    HeadObject(ctx context.Context, bucket, key string) (int64, error)
+    DeleteObject(ctx context.Context, bucket, key string) error
+}
+
+// S3Store keeps blobs as objects of Bucket, with keys below Prefix.
+type S3Store struct {
+    Client ObjectClient
+    Bucket string
+    Prefix string
+}
+
+func NewS3Store(client ObjectClient, bucket, prefix string) *S3Store {
+    return &S3Store{Client: client, Bucket: bucket, Prefix: prefix}
+}
+
+func (s *S3Store) Put(ctx context.Context, cid string, r io.Reader, size int64) error {
+    key, err := s.key(cid)
+    if err != nil { return err }
+    if _, err := s.Client.HeadObject(ctx, s.Bucket, key); err == nil { return nil }
+    return s.Client.PutObject(ctx, s.Bucket, key, r, size)
+}
+
+func (s *S3Store) Open(ctx context.Context, cid string, offset, length int64) (io.ReadCloser, error) {
+    key, err := s.key(cid)
+    if err != nil { return nil, err }
+    return s.Client.GetObject(ctx, s.Bucket, key, offset, length)
+}
+
+func (s *S3Store) Stat(ctx context.Context, cid string) (int64, error) {
+    key, err := s.key(cid)
+    if err != nil { return 0, err }
+    return s.Client.HeadObject(ctx, s.Bucket, key)
+}
+
+func (s *S3Store) Delete(ctx context.Context, cid string) error {
+    key, err := s.key(cid)
+    if err != nil { return err }
+    return s.Client.DeleteObject(ctx, s.Bucket, key)
+}
+
+func (s *S3Store) key(cid string) (string, error) {
+    if _, err := cas.Digest(cid); err != nil { return "", err }
+    return path.Join(s.Prefix, shard(cid), cid), nil
+}
@@ -0,0 +1,53 @@
+package blob
+
+import (
+    "bytes"
+    "context"
+    "io"
+    "sync"
+)
+
+// MemObjectClient is an in-memory ObjectClient, the local stand-in of an S3 compatible
+// service for tests and single node setups.
+type MemObjectClient struct {
+    mu      sync.RWMutex
+    objects map[string][]byte
+}
+
+func NewMemObjectClient() *MemObjectClient { return &MemObjectClient{objects: make(map[string][]byte)} }
+
+func (c *MemObjectClient) PutObject(ctx context.Context, bucket, key string, body io.Reader, size int64) error {
+    data, err := io.ReadAll(body)
+    if err != nil { return err }
+    if int64(len(data)) != size { return io.ErrUnexpectedEOF }
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.objects[bucket+"/"+key] = data
+    return nil
+}
+
+func (c *MemObjectClient) GetObject(ctx context.Context, bucket, key string, offset, length int64) (io.ReadCloser, error) {
+    c.mu.RLock()
+    data, ok := c.objects[bucket+"/"+key]
+    c.mu.RUnlock()
+    if !ok { return nil, ErrNotFound }
+    if offset > int64(len(data)) { offset = int64(len(data)) }
+    data = data[offset:]
+    if length >= 0 && length < int64(len(data)) { data = data[:length] }
+    return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (c *MemObjectClient) HeadObject(ctx context.Context, bucket, key string) (int64, error) {
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+    data, ok := c.objects[bucket+"/"+key]
+    if !ok { return 0, ErrNotFound }
+    return int64(len(data)), nil
+}
+
+func (c *MemObjectClient) DeleteObject(ctx context.Context, bucket, key string) error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    delete(c.objects, bucket+"/"+key)
+    return nil
+}
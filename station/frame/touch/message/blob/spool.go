@@ -0,0 +1,78 @@
+package blob
+
+import (
+    "errors"
+    "io"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+var (
+    ErrOffsetMismatch = errors.New("upload offset does not match the bytes received")
+    ErrTooLarge       = errors.New("upload exceeds its declared size")
+    ErrInvalidUpload  = errors.New("invalid upload id")
+)
+
+// Spool stages the chunks of resumable uploads in Dir until they are complete and
+// verified, the backends only ever get whole blobs.
+type Spool struct {
+    Dir string
+}
+
+func NewSpool(dir string) *Spool { return &Spool{Dir: dir} }
+
+// Append writes the chunk read from r at offset, the number of bytes acknowledged so
+// far, and returns the new size. Staged bytes beyond offset belong to a chunk that was
+// never acknowledged and are overwritten. At most max bytes are accepted; on any failure
+// the upload is truncated back to offset, so the client can resend the chunk.
+func (s *Spool) Append(id string, offset int64, r io.Reader, max int64) (int64, error) {
+    path, err := s.path(id)
+    if err != nil { return 0, err }
+    if err := os.MkdirAll(s.Dir, 0o755); err != nil { return 0, err }
+    f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0o644)
+    if err != nil { return 0, err }
+    defer f.Close()
+
+    fi, err := f.Stat()
+    if err != nil { return 0, err }
+    if fi.Size() < offset { return fi.Size(), ErrOffsetMismatch }
+    if err := f.Truncate(offset); err != nil { return offset, err }
+    if _, err := f.Seek(offset, io.SeekStart); err != nil { return offset, err }
+    n, err := io.Copy(f, io.LimitReader(r, max+1))
+    if err == nil && n > max { err = ErrTooLarge }
+    if err != nil {
+        if terr := f.Truncate(offset); terr != nil { return offset, terr }
+        return offset, err
+    }
+    return offset + n, nil
+}
+
+// Size returns the bytes received for the upload, 0 before its first chunk.
+func (s *Spool) Size(id string) (int64, error) {
+    path, err := s.path(id)
+    if err != nil { return 0, err }
+    fi, err := os.Stat(path)
+    if errors.Is(err, os.ErrNotExist) { return 0, nil }
+    if err != nil { return 0, err }
+    return fi.Size(), nil
+}
+
+func (s *Spool) Open(id string) (*os.File, error) {
+    path, err := s.path(id)
+    if err != nil { return nil, err }
+    return os.Open(path)
+}
+
+func (s *Spool) Remove(id string) error {
+    path, err := s.path(id)
+    if err != nil { return err }
+    err = os.Remove(path)
+    if errors.Is(err, os.ErrNotExist) { return nil }
+    return err
+}
+
+func (s *Spool) path(id string) (string, error) {
+    if id == "" || strings.ContainsAny(id, `/\.`) { return "", ErrInvalidUpload }
+    return filepath.Join(s.Dir, id), nil
+}
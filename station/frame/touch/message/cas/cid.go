@@ -0,0 +1,61 @@
+// Package cas computes the content identifiers used for blobs of the message layer.
+// Identifiers are CIDv1 with the raw codec and a sha2-256 multihash, multibase encoded
+// as lowercase base32, e.g. "bafkrei...".
+package cas
+
+import (
+    "crypto/sha256"
+    "encoding/base32"
+    "errors"
+    "hash"
+    "strings"
+)
+
+const (
+    cidVersion   = 0x01
+    codecRaw     = 0x55
+    mhSHA256     = 0x12
+    mhSHA256Size = sha256.Size
+    // multibasePrefix is the multibase code of RFC 4648 lowercase base32 without padding.
+    multibasePrefix = "b"
+)
+
+var (
+    ErrInvalidCID = errors.New("invalid cid")
+    ErrMismatch   = errors.New("content does not match cid")
+
+    encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// Sum returns the CID of data.
+func Sum(data []byte) string {
+    d := sha256.Sum256(data)
+    return FromDigest(d[:])
+}
+
+// FromDigest returns the CID of a sha256 digest.
+func FromDigest(digest []byte) string {
+    b := make([]byte, 0, 4+len(digest))
+    b = append(b, cidVersion, codecRaw, mhSHA256, mhSHA256Size)
+    b = append(b, digest...)
+    return multibasePrefix + strings.ToLower(encoding.EncodeToString(b))
+}
+
+// Digest returns the sha256 digest carried by cid.
+func Digest(cid string) ([]byte, error) {
+    if !strings.HasPrefix(cid, multibasePrefix) { return nil, ErrInvalidCID }
+    b, err := encoding.DecodeString(strings.ToUpper(cid[len(multibasePrefix):]))
+    if err != nil { return nil, ErrInvalidCID }
+    if len(b) != 4+mhSHA256Size || b[0] != cidVersion || b[1] != codecRaw || b[2] != mhSHA256 || b[3] != mhSHA256Size { return nil, ErrInvalidCID }
+    return b[4:], nil
+}
+
+// Verify checks that data is the content addressed by cid.
+func Verify(cid string, data []byte) error {
+    if _, err := Digest(cid); err != nil { return err }
+    if Sum(data) != cid { return ErrMismatch }
+    return nil
+}
+
+// NewHash returns the hash used by Sum for streamed content, pass its sum to FromDigest.
+func NewHash() hash.Hash { return sha256.New() }
@@ -0,0 +1,25 @@
+package cas
+
+import (
+    "testing"
+)
+
+func TestSum(t *testing.T) {
+    // CID of the empty raw block, as printed by `ipfs add --cid-version 1 --raw-leaves`.
+    const empty = "bafkreihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku"
+    if got := Sum(nil); got != empty {
+        t.Fatalf("Sum(nil) = %s, want %s", got, empty)
+    }
+
+    data := []byte("hello")
+    cid := Sum(data)
+    if err := Verify(cid, data); err != nil {
+        t.Errorf("Verify() error = %v", err)
+    }
+    if err := Verify(cid, []byte("hello!")); err != ErrMismatch {
+        t.Errorf("Verify() error = %v, want ErrMismatch", err)
+    }
+    if _, err := Digest("Qm" + cid); err != ErrInvalidCID {
+        t.Errorf("Digest() error = %v, want ErrInvalidCID", err)
+    }
+}
@@ -0,0 +1,250 @@
+// Package client is the reference Go client of the message endpoints. It keeps the
+// end-to-end encryption of package e2ee on the client side: group keys are generated,
+// wrapped and used here, the server only ever gets ciphertext and wrapped keys.
+package client
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "strconv"
+    "sync"
+
+    "github.com/peers-touch/peers-touch/station/frame/touch/message/e2ee"
+    "github.com/peers-touch/peers-touch/station/frame/touch/model"
+    m "github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+)
+
+// Message is a message with its body decrypted.
+type Message struct {
+    *m.Message
+    Plaintext []byte
+}
+
+type epochRef struct {
+    convID string
+    epoch  int
+}
+
+// Client talks to one station on behalf of one actor, authenticated with a bearer token.
+type Client struct {
+    BaseURL  string
+    Token    string
+    Identity *e2ee.Identity
+    HTTP     *http.Client
+
+    mu      sync.Mutex
+    keyring map[epochRef][]byte
+}
+
+func New(baseURL, token string, identity *e2ee.Identity) *Client {
+    return &Client{BaseURL: baseURL, Token: token, Identity: identity, HTTP: http.DefaultClient, keyring: make(map[epochRef][]byte)}
+}
+
+// PublishKey publishes the public key of the identity, so members can wrap keys for it.
+func (c *Client) PublishKey(ctx context.Context) error {
+    return c.do(ctx, http.MethodPut, "/keys", map[string]string{"alg": e2ee.Alg, "public_key": c.Identity.PublicKey()}, nil)
+}
+
+// CreateConversation creates an encrypted conversation owned by the caller and rotates it
+// to its first key epoch.
+func (c *Client) CreateConversation(ctx context.Context, convID, title string) (*m.Conversation, error) {
+    var conv m.Conversation
+    if err := c.do(ctx, http.MethodPost, "/conv", map[string]interface{}{"conv_id": convID, "type": "group", "title": title, "encrypted": true}, &conv); err != nil { return nil, err }
+    k, err := c.Rotate(ctx, convID)
+    if err != nil { return nil, err }
+    conv.Epoch = k.Epoch
+    return &conv, nil
+}
+
+// Rotate moves the conversation to a fresh group key wrapped for all its members.
+func (c *Client) Rotate(ctx context.Context, convID string) (*m.KeyEpoch, error) {
+    return c.rotate(ctx, convID, nil)
+}
+
+// AddMembers adds dids, giving them the current group key.
+func (c *Client) AddMembers(ctx context.Context, convID string, dids ...string) error {
+    epoch, err := c.epoch(ctx, convID)
+    if err != nil { return err }
+    body := map[string]interface{}{"add": dids}
+    if epoch > 0 {
+        key, err := c.groupKey(ctx, convID, epoch)
+        if err != nil { return err }
+        keys, err := c.keys(ctx, "/keys", dids)
+        if err != nil { return err }
+        wraps, err := wrapFor(key, keys, dids, convID, epoch)
+        if err != nil { return err }
+        body["wraps"] = wraps
+    }
+    return c.do(ctx, http.MethodPost, "/conv/"+url.PathEscape(convID)+"/members", body, nil)
+}
+
+// RemoveMembers removes dids and rotates the key in the same request, so they can't read
+// anything sent afterwards.
+func (c *Client) RemoveMembers(ctx context.Context, convID string, dids ...string) error {
+    _, err := c.rotate(ctx, convID, dids)
+    return err
+}
+
+// Send seals plaintext with the current group key and appends it to the conversation.
+func (c *Client) Send(ctx context.Context, convID string, plaintext []byte) (*m.Message, error) {
+    epoch, err := c.epoch(ctx, convID)
+    if err != nil { return nil, err }
+    key, err := c.groupKey(ctx, convID, epoch)
+    if err != nil { return nil, err }
+    body, err := e2ee.Seal(key, convID, epoch, plaintext)
+    if err != nil { return nil, err }
+    var msg m.Message
+    if err := c.do(ctx, http.MethodPost, "/conv/"+url.PathEscape(convID)+"/msg", map[string]interface{}{"type": "text", "body": body, "epoch": epoch}, &msg); err != nil { return nil, err }
+    return &msg, nil
+}
+
+// Messages lists the conversation page by page and decrypts every message with the key of
+// its epoch.
+func (c *Client) Messages(ctx context.Context, convID string) ([]*Message, error) {
+    var list []*m.Message
+    for after := ""; ; {
+        var page struct {
+            Messages []*m.Message `json:"messages"`
+            HasMore  bool         `json:"has_more"`
+            After    string       `json:"after"`
+        }
+        if err := c.do(ctx, http.MethodGet, "/conv/"+url.PathEscape(convID)+"/msg?after="+url.QueryEscape(after), nil, &page); err != nil { return nil, err }
+        list = append(list, page.Messages...)
+        if !page.HasMore || page.After == "" { break }
+        after = page.After
+    }
+    out := make([]*Message, 0, len(list))
+    for _, msg := range list {
+        if msg.Epoch == 0 || msg.Deleted { out = append(out, &Message{Message: msg, Plaintext: []byte(msg.Body)}); continue }
+        key, err := c.groupKey(ctx, convID, msg.Epoch)
+        if err != nil { return nil, err }
+        plain, err := e2ee.Open(key, convID, msg.Epoch, msg.Body)
+        if err != nil { return nil, fmt.Errorf("open message %s: %w", msg.ULID, err) }
+        out = append(out, &Message{Message: msg, Plaintext: plain})
+    }
+    return out, nil
+}
+
+// rotate wraps a fresh key for the members but removeDIDs and posts it, together with the
+// removal if there is one.
+func (c *Client) rotate(ctx context.Context, convID string, removeDIDs []string) (*m.KeyEpoch, error) {
+    epoch, err := c.epoch(ctx, convID)
+    if err != nil { return nil, err }
+    var members []*m.ConvMember
+    if err := c.do(ctx, http.MethodGet, "/conv/"+url.PathEscape(convID)+"/members", nil, &members); err != nil { return nil, err }
+    removed := make(map[string]struct{}, len(removeDIDs))
+    for _, d := range removeDIDs { removed[d] = struct{}{} }
+    var dids []string
+    for _, mbr := range members {
+        if _, ok := removed[mbr.DID]; !ok { dids = append(dids, mbr.DID) }
+    }
+    keys, err := c.keys(ctx, "/conv/"+url.PathEscape(convID)+"/member-keys", nil)
+    if err != nil { return nil, err }
+
+    key, err := e2ee.NewGroupKey()
+    if err != nil { return nil, err }
+    next := epoch + 1
+    wraps, err := wrapFor(key, keys, dids, convID, next)
+    if err != nil { return nil, err }
+    req := map[string]interface{}{"epoch": next, "alg": e2ee.Alg, "wraps": wraps}
+
+    var k m.KeyEpoch
+    if len(removeDIDs) > 0 {
+        if err := c.do(ctx, http.MethodPost, "/conv/"+url.PathEscape(convID)+"/members", map[string]interface{}{"remove": removeDIDs, "rotate": req}, nil); err != nil { return nil, err }
+        k = m.KeyEpoch{Epoch: next, Alg: e2ee.Alg, KeyMetaCID: e2ee.KeyMetaCID(e2ee.Alg, next, wraps)}
+    } else if err := c.do(ctx, http.MethodPost, "/conv/"+url.PathEscape(convID)+"/key-rotate", req, &k); err != nil {
+        return nil, err
+    }
+    c.remember(convID, next, key)
+    return &k, nil
+}
+
+func (c *Client) epoch(ctx context.Context, convID string) (int, error) {
+    var state struct{ Epoch int `json:"epoch"` }
+    if err := c.do(ctx, http.MethodGet, "/conv/"+url.PathEscape(convID)+"/state", nil, &state); err != nil { return 0, err }
+    return state.Epoch, nil
+}
+
+// groupKey returns the key of the epoch, unwrapping it from the server the first time.
+func (c *Client) groupKey(ctx context.Context, convID string, epoch int) ([]byte, error) {
+    c.mu.Lock()
+    key, ok := c.keyring[epochRef{convID, epoch}]
+    c.mu.Unlock()
+    if ok { return key, nil }
+
+    var ek struct{ Epoch int `json:"epoch"`; WrappedKey string `json:"wrapped_key"` }
+    if err := c.do(ctx, http.MethodGet, "/conv/"+url.PathEscape(convID)+"/key-epoch?epoch="+strconv.Itoa(epoch), nil, &ek); err != nil { return nil, err }
+    key, err := c.Identity.Unwrap(ek.WrappedKey, convID, epoch)
+    if err != nil { return nil, err }
+    c.remember(convID, epoch, key)
+    return key, nil
+}
+
+func (c *Client) remember(convID string, epoch int, key []byte) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.keyring[epochRef{convID, epoch}] = key
+}
+
+// keys fetches published keys by DID.
+func (c *Client) keys(ctx context.Context, path string, dids []string) (map[string]string, error) {
+    if len(dids) > 0 {
+        q := url.Values{"did": dids}
+        path += "?" + q.Encode()
+    }
+    var list []*m.DIDKey
+    if err := c.do(ctx, http.MethodGet, path, nil, &list); err != nil { return nil, err }
+    keys := make(map[string]string, len(list))
+    for _, k := range list { keys[k.DID] = k.PublicKey }
+    return keys, nil
+}
+
+func wrapFor(key []byte, keys map[string]string, dids []string, convID string, epoch int) (map[string]string, error) {
+    wraps := make(map[string]string, len(dids))
+    for _, d := range dids {
+        pub, ok := keys[d]
+        if !ok { return nil, fmt.Errorf("%s has not published a key", d) }
+        w, err := e2ee.Wrap(key, pub, convID, epoch)
+        if err != nil { return nil, err }
+        wraps[d] = w
+    }
+    return wraps, nil
+}
+
+// do sends in as JSON and decodes the data of the response envelope into out. Failures
+// come back as *model.Error.
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}) error {
+    var body io.Reader
+    if in != nil {
+        b, err := json.Marshal(in)
+        if err != nil { return err }
+        body = bytes.NewReader(b)
+    }
+    req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+    if err != nil { return err }
+    if in != nil { req.Header.Set("Content-Type", "application/json") }
+    req.Header.Set("Authorization", "Bearer "+c.Token)
+    resp, err := c.HTTP.Do(req)
+    if err != nil { return err }
+    defer resp.Body.Close()
+
+    var env struct {
+        Code    string          `json:"code"`
+        Msg     string          `json:"msg"`
+        Message string          `json:"message"`
+        Data    json.RawMessage `json:"data"`
+    }
+    if err := json.NewDecoder(resp.Body).Decode(&env); err != nil { return fmt.Errorf("%s %s: %s: %w", method, path, resp.Status, err) }
+    if env.Code != model.SuccessCode {
+        if env.Code == "" { return errors.New(method + " " + path + ": " + resp.Status) }
+        return model.NewError(env.Code, env.Message)
+    }
+    if out == nil || len(env.Data) == 0 { return nil }
+    return json.Unmarshal(env.Data, out)
+}
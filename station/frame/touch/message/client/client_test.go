@@ -0,0 +1,248 @@
+package client
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "strconv"
+    "strings"
+    "sync"
+    "testing"
+
+    "github.com/peers-touch/peers-touch/station/frame/touch/message/e2ee"
+    "github.com/peers-touch/peers-touch/station/frame/touch/model"
+    m "github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+)
+
+// fakeStation keeps the key schedule rules of the message service in memory, so the
+// client can be exercised without a database. Tokens are the DIDs themselves.
+type fakeStation struct {
+    mu      sync.Mutex
+    pubKeys map[string]string
+    convs   map[string]*m.Conversation
+    members map[string][]string
+    wraps   map[string]map[int]map[string]string
+    msgs    map[string][]*m.Message
+}
+
+func newFakeStation() *fakeStation {
+    return &fakeStation{pubKeys: map[string]string{}, convs: map[string]*m.Conversation{}, members: map[string][]string{}, wraps: map[string]map[int]map[string]string{}, msgs: map[string][]*m.Message{}}
+}
+
+func (f *fakeStation) handler() http.Handler {
+    mux := http.NewServeMux()
+    mux.HandleFunc("PUT /keys", f.wrap(func(did string, r *http.Request) (interface{}, error) {
+        var p struct{ PublicKey string `json:"public_key"` }
+        if err := json.NewDecoder(r.Body).Decode(&p); err != nil { return nil, err }
+        if err := e2ee.ValidatePublicKey(p.PublicKey); err != nil { return nil, model.ErrKeyInvalidPublicKey }
+        f.pubKeys[did] = p.PublicKey
+        return nil, nil
+    }))
+    mux.HandleFunc("GET /keys", f.wrap(func(did string, r *http.Request) (interface{}, error) {
+        return f.keysOf(r.URL.Query()["did"]), nil
+    }))
+    mux.HandleFunc("POST /conv", f.wrap(func(did string, r *http.Request) (interface{}, error) {
+        var p struct{ ConvID string `json:"conv_id"`; Encrypted bool `json:"encrypted"` }
+        if err := json.NewDecoder(r.Body).Decode(&p); err != nil { return nil, err }
+        c := &m.Conversation{ConvID: p.ConvID, Encrypted: p.Encrypted}
+        f.convs[p.ConvID], f.members[p.ConvID], f.wraps[p.ConvID] = c, []string{did}, map[int]map[string]string{}
+        return c, nil
+    }))
+    mux.HandleFunc("GET /conv/{id}/state", f.member(func(did string, c *m.Conversation, r *http.Request) (interface{}, error) {
+        return map[string]int{"epoch": c.Epoch}, nil
+    }))
+    mux.HandleFunc("GET /conv/{id}/members", f.member(func(did string, c *m.Conversation, r *http.Request) (interface{}, error) {
+        var list []*m.ConvMember
+        for _, d := range f.members[c.ConvID] { list = append(list, &m.ConvMember{DID: d}) }
+        return list, nil
+    }))
+    mux.HandleFunc("GET /conv/{id}/member-keys", f.member(func(did string, c *m.Conversation, r *http.Request) (interface{}, error) {
+        return f.keysOf(f.members[c.ConvID]), nil
+    }))
+    mux.HandleFunc("POST /conv/{id}/members", f.member(func(did string, c *m.Conversation, r *http.Request) (interface{}, error) {
+        var p struct{ Add []string `json:"add"`; Remove []string `json:"remove"`; Wraps map[string]string `json:"wraps"`; Rotate *rotateReq `json:"rotate"` }
+        if err := json.NewDecoder(r.Body).Decode(&p); err != nil { return nil, err }
+        if len(p.Add) > 0 {
+            if c.Epoch > 0 {
+                if len(p.Wraps) != len(p.Add) { return nil, model.ErrKeyWrapsMismatch }
+                for _, d := range p.Add { f.wraps[c.ConvID][c.Epoch][d] = p.Wraps[d] }
+            }
+            f.members[c.ConvID] = append(f.members[c.ConvID], p.Add...)
+        }
+        if len(p.Remove) > 0 {
+            if p.Rotate == nil { return nil, model.ErrKeyRotationRequired }
+            return nil, f.rotate(c, p.Rotate, p.Remove)
+        }
+        return nil, nil
+    }))
+    mux.HandleFunc("POST /conv/{id}/key-rotate", f.member(func(did string, c *m.Conversation, r *http.Request) (interface{}, error) {
+        var p rotateReq
+        if err := json.NewDecoder(r.Body).Decode(&p); err != nil { return nil, err }
+        if err := f.rotate(c, &p, nil); err != nil { return nil, err }
+        return &m.KeyEpoch{Epoch: p.Epoch, Alg: p.Alg, KeyMetaCID: e2ee.KeyMetaCID(p.Alg, p.Epoch, p.Wraps)}, nil
+    }))
+    mux.HandleFunc("GET /conv/{id}/key-epoch", f.member(func(did string, c *m.Conversation, r *http.Request) (interface{}, error) {
+        epoch, _ := strconv.Atoi(r.URL.Query().Get("epoch"))
+        w, ok := f.wraps[c.ConvID][epoch][did]
+        if !ok { return nil, model.ErrKeyEpochNotFound }
+        return map[string]interface{}{"epoch": epoch, "wrapped_key": w}, nil
+    }))
+    mux.HandleFunc("POST /conv/{id}/msg", f.member(func(did string, c *m.Conversation, r *http.Request) (interface{}, error) {
+        var p struct{ Body string `json:"body"`; Epoch int `json:"epoch"` }
+        if err := json.NewDecoder(r.Body).Decode(&p); err != nil { return nil, err }
+        if p.Epoch < 1 || p.Epoch != c.Epoch { return nil, model.ErrMessageEpoch }
+        msg := &m.Message{ULID: strconv.Itoa(len(f.msgs[c.ConvID]) + 1), ConvID: c.ConvID, SenderDID: did, Body: p.Body, Epoch: p.Epoch}
+        f.msgs[c.ConvID] = append(f.msgs[c.ConvID], msg)
+        return msg, nil
+    }))
+    mux.HandleFunc("GET /conv/{id}/msg", f.member(func(did string, c *m.Conversation, r *http.Request) (interface{}, error) {
+        // pages of one message, so clients have to follow the cursors
+        from, _ := strconv.Atoi(r.URL.Query().Get("after"))
+        list := f.msgs[c.ConvID]
+        if from >= len(list) { return map[string]interface{}{"messages": []*m.Message{}, "has_more": false}, nil }
+        return map[string]interface{}{"messages": list[from : from+1], "has_more": from+1 < len(list), "after": strconv.Itoa(from + 1)}, nil
+    }))
+    return mux
+}
+
+type rotateReq struct {
+    Epoch int               `json:"epoch"`
+    Alg   string            `json:"alg"`
+    Wraps map[string]string `json:"wraps"`
+}
+
+func (f *fakeStation) rotate(c *m.Conversation, p *rotateReq, remove []string) error {
+    if p.Epoch != c.Epoch+1 { return model.ErrKeyEpochStale }
+    var remaining []string
+    for _, d := range f.members[c.ConvID] {
+        if !contains(remove, d) { remaining = append(remaining, d) }
+    }
+    if len(p.Wraps) != len(remaining) { return model.ErrKeyWrapsMismatch }
+    for _, d := range remaining {
+        if p.Wraps[d] == "" { return model.ErrKeyWrapsMismatch }
+    }
+    f.members[c.ConvID], f.wraps[c.ConvID][p.Epoch], c.Epoch = remaining, p.Wraps, p.Epoch
+    return nil
+}
+
+func (f *fakeStation) keysOf(dids []string) []*m.DIDKey {
+    var list []*m.DIDKey
+    for _, d := range dids {
+        if k, ok := f.pubKeys[d]; ok { list = append(list, &m.DIDKey{DID: d, Alg: e2ee.Alg, PublicKey: k}) }
+    }
+    return list
+}
+
+func (f *fakeStation) wrap(h func(did string, r *http.Request) (interface{}, error)) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        f.mu.Lock()
+        defer f.mu.Unlock()
+        did := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+        data, err := h(did, r)
+        if err != nil {
+            var e *model.Error
+            if !errors.As(err, &e) { e = model.UndefinedError(err) }
+            w.WriteHeader(http.StatusBadRequest)
+            _ = json.NewEncoder(w).Encode(e)
+            return
+        }
+        _ = json.NewEncoder(w).Encode(model.NewSuccessResponse("success", data))
+    }
+}
+
+func (f *fakeStation) member(h func(did string, c *m.Conversation, r *http.Request) (interface{}, error)) http.HandlerFunc {
+    return f.wrap(func(did string, r *http.Request) (interface{}, error) {
+        c, ok := f.convs[r.PathValue("id")]
+        if !ok { return nil, model.ErrConvNotFound }
+        if !contains(f.members[c.ConvID], did) { return nil, model.ErrConvNotMember }
+        return h(did, c, r)
+    })
+}
+
+func contains(list []string, s string) bool {
+    for _, v := range list {
+        if v == s { return true }
+    }
+    return false
+}
+
+func newClient(t *testing.T, url, did string) *Client {
+    t.Helper()
+    id, err := e2ee.GenerateIdentity()
+    if err != nil { t.Fatal(err) }
+    c := New(url, did, id)
+    if err := c.PublishKey(context.Background()); err != nil { t.Fatalf("publish key of %s: %v", did, err) }
+    return c
+}
+
+func errCode(err error) string {
+    var e *model.Error
+    if errors.As(err, &e) { return e.Code }
+    return ""
+}
+
+func TestEncryptedConversationFlow(t *testing.T) {
+    ctx := context.Background()
+    station := newFakeStation()
+    srv := httptest.NewServer(station.handler())
+    defer srv.Close()
+
+    alice := newClient(t, srv.URL, "did:peers:alice")
+    bob := newClient(t, srv.URL, "did:peers:bob")
+    carol := newClient(t, srv.URL, "did:peers:carol")
+
+    conv, err := alice.CreateConversation(ctx, "c1", "secret")
+    if err != nil { t.Fatal(err) }
+    if conv.Epoch != 1 { t.Fatalf("epoch after create = %d, want 1", conv.Epoch) }
+    if err := alice.AddMembers(ctx, "c1", "did:peers:bob"); err != nil { t.Fatal(err) }
+
+    if _, err := alice.Send(ctx, "c1", []byte("hello bob")); err != nil { t.Fatal(err) }
+    msgs, err := bob.Messages(ctx, "c1")
+    if err != nil { t.Fatal(err) }
+    if len(msgs) != 1 || string(msgs[0].Plaintext) != "hello bob" { t.Fatalf("bob read %+v", msgs) }
+
+    // removing bob rotates the key, the new epoch is not wrapped for him
+    if err := alice.RemoveMembers(ctx, "c1", "did:peers:bob"); err != nil { t.Fatal(err) }
+    if station.convs["c1"].Epoch != 2 { t.Fatalf("epoch after removal = %d, want 2", station.convs["c1"].Epoch) }
+    if _, ok := station.wraps["c1"][2]["did:peers:bob"]; ok { t.Fatal("epoch 2 is wrapped for the removed member") }
+    if err := alice.AddMembers(ctx, "c1", "did:peers:carol"); err != nil { t.Fatal(err) }
+    if _, err := alice.Send(ctx, "c1", []byte("bob is gone")); err != nil { t.Fatal(err) }
+
+    // even with the epoch 1 key at hand bob can't open the epoch 2 message
+    last := station.msgs["c1"][1]
+    if last.Epoch != 2 { t.Fatalf("message epoch = %d, want 2", last.Epoch) }
+    if _, err := e2ee.Open(bob.keyring[epochRef{"c1", 1}], "c1", 2, last.Body); err == nil { t.Fatal("removed member opened a message of the next epoch") }
+    if _, err := bob.Messages(ctx, "c1"); errCode(err) != model.ErrConvNotMember.Code { t.Fatalf("removed member listing: %v", err) }
+
+    // carol joined at epoch 2: she reads what follows, not the history before her
+    if _, err := carol.groupKey(ctx, "c1", 2); err != nil { t.Fatal(err) }
+    if _, err := carol.groupKey(ctx, "c1", 1); errCode(err) != model.ErrKeyEpochNotFound.Code { t.Fatalf("epoch 1 for carol: %v", err) }
+
+    // the station never saw a plaintext
+    for _, msg := range station.msgs["c1"] {
+        for _, plain := range []string{"hello bob", "bob is gone"} {
+            if bytes.Contains([]byte(msg.Body), []byte(plain)) { t.Fatalf("station stored plaintext %q", plain) }
+        }
+    }
+}
+
+func TestSendRejectsStaleEpoch(t *testing.T) {
+    ctx := context.Background()
+    station := newFakeStation()
+    srv := httptest.NewServer(station.handler())
+    defer srv.Close()
+
+    alice := newClient(t, srv.URL, "did:peers:alice")
+    if _, err := alice.CreateConversation(ctx, "c1", ""); err != nil { t.Fatal(err) }
+    if _, err := alice.Rotate(ctx, "c1"); err != nil { t.Fatal(err) }
+
+    err := alice.do(ctx, http.MethodPost, "/conv/c1/msg", map[string]interface{}{"body": "x", "epoch": 1}, nil)
+    if errCode(err) != model.ErrMessageEpoch.Code { t.Fatalf("stale epoch send: %v", err) }
+    if _, err := alice.Send(ctx, "c1", []byte("fresh")); err != nil { t.Fatal(err) }
+
+    err = alice.do(ctx, http.MethodPost, "/conv/c1/key-rotate", map[string]interface{}{"epoch": 2, "alg": e2ee.Alg, "wraps": map[string]string{}}, nil)
+    if errCode(err) != model.ErrKeyEpochStale.Code { t.Fatalf("stale rotation: %v", err) }
+}
@@ -0,0 +1,40 @@
+// Package cursor encodes the positions clients page message listings with. A cursor is
+// the (TS, ULID) key of a message; clients treat it as an opaque string.
+package cursor
+
+import (
+    "encoding/base64"
+    "errors"
+    "strconv"
+    "strings"
+)
+
+// version prefixes encoded cursors, so the format can change without breaking the ones
+// clients hold.
+const version = "1"
+
+var ErrInvalid = errors.New("invalid cursor")
+
+var encoding = base64.RawURLEncoding
+
+// Cursor is the position of a message in the (TS, ULID) order of a listing.
+type Cursor struct {
+    TS   int64
+    ULID string
+}
+
+// Encode returns the opaque form of c.
+func (c Cursor) Encode() string {
+    return encoding.EncodeToString([]byte(version + ":" + strconv.FormatInt(c.TS, 10) + ":" + c.ULID))
+}
+
+// Parse decodes a cursor returned by Encode.
+func Parse(s string) (Cursor, error) {
+    b, err := encoding.DecodeString(s)
+    if err != nil { return Cursor{}, ErrInvalid }
+    parts := strings.SplitN(string(b), ":", 3)
+    if len(parts) != 3 || parts[0] != version || parts[2] == "" { return Cursor{}, ErrInvalid }
+    ts, err := strconv.ParseInt(parts[1], 10, 64)
+    if err != nil { return Cursor{}, ErrInvalid }
+    return Cursor{TS: ts, ULID: parts[2]}, nil
+}
@@ -0,0 +1,20 @@
+package cursor
+
+import (
+    "encoding/base64"
+    "errors"
+    "testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+    c := Cursor{TS: 1700000000123, ULID: "01HF7YAT00AAAAAAAAAAAAAAAA"}
+    got, err := Parse(c.Encode())
+    if err != nil || got != c { t.Fatalf("Parse(Encode()) = %+v, %v", got, err) }
+}
+
+func TestParseInvalid(t *testing.T) {
+    raw := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+    for _, s := range []string{"", "!!", "1700000000123", raw("2:1:01HF7YAT00AAAAAAAAAAAAAAAA"), raw("1:x:01HF7YAT00AAAAAAAAAAAAAAAA"), raw("1:1:"), raw("1:1")} {
+        if _, err := Parse(s); !errors.Is(err, ErrInvalid) { t.Errorf("Parse(%q) err = %v", s, err) }
+    }
+}
@@ -0,0 +1,185 @@
+// Package e2ee holds the primitives of the conversation group key schedule. Every key
+// epoch has a random 256 bit group key that encrypts the message bodies with AES-GCM.
+// The rotating member wraps it for each member X25519 public key, so the server only
+// ever stores ciphertext and wrapped keys.
+package e2ee
+
+import (
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/ecdh"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "sort"
+
+    "github.com/peers-touch/peers-touch/station/frame/touch/message/cas"
+    "golang.org/x/crypto/hkdf"
+)
+
+// Alg names the scheme of this package: X25519 ECDH, HKDF-SHA256 and AES-256-GCM.
+const Alg = "x25519-hkdf-sha256-aes256gcm"
+
+const keySize = 32
+
+var (
+    ErrInvalidPublicKey = errors.New("invalid x25519 public key")
+    ErrInvalidKey       = errors.New("invalid group key")
+    ErrMalformed        = errors.New("malformed ciphertext")
+
+    encoding = base64.StdEncoding
+)
+
+// Identity is the X25519 key pair a member receives wrapped keys with.
+type Identity struct {
+    priv *ecdh.PrivateKey
+}
+
+func GenerateIdentity() (*Identity, error) {
+    priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+    if err != nil { return nil, err }
+    return &Identity{priv: priv}, nil
+}
+
+// ParseIdentity loads an identity from the bytes returned by Bytes.
+func ParseIdentity(b []byte) (*Identity, error) {
+    priv, err := ecdh.X25519().NewPrivateKey(b)
+    if err != nil { return nil, err }
+    return &Identity{priv: priv}, nil
+}
+
+func (id *Identity) Bytes() []byte { return id.priv.Bytes() }
+
+// PublicKey returns the base64 public key to publish.
+func (id *Identity) PublicKey() string { return encoding.EncodeToString(id.priv.PublicKey().Bytes()) }
+
+// ValidatePublicKey checks a published public key.
+func ValidatePublicKey(pub string) error {
+    _, err := parsePublicKey(pub)
+    return err
+}
+
+// NewGroupKey returns a fresh group key for a new epoch.
+func NewGroupKey() ([]byte, error) {
+    key := make([]byte, keySize)
+    if _, err := io.ReadFull(rand.Reader, key); err != nil { return nil, err }
+    return key, nil
+}
+
+// Wrap encrypts the group key of an epoch for one member public key. The result is
+// base64 of the ephemeral public key, the nonce and the sealed key.
+func Wrap(groupKey []byte, memberPub, convID string, epoch int) (string, error) {
+    if len(groupKey) != keySize { return "", ErrInvalidKey }
+    pub, err := parsePublicKey(memberPub)
+    if err != nil { return "", err }
+    eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+    if err != nil { return "", err }
+    shared, err := eph.ECDH(pub)
+    if err != nil { return "", err }
+
+    ephPub := eph.PublicKey().Bytes()
+    aead, err := wrapAEAD(shared, ephPub, pub.Bytes(), convID, epoch)
+    if err != nil { return "", err }
+    out, err := seal(aead, ephPub, groupKey, nil)
+    if err != nil { return "", err }
+    return encoding.EncodeToString(out), nil
+}
+
+// Unwrap recovers the group key of an epoch wrapped for the identity.
+func (id *Identity) Unwrap(wrapped, convID string, epoch int) ([]byte, error) {
+    b, err := encoding.DecodeString(wrapped)
+    if err != nil || len(b) < keySize { return nil, ErrMalformed }
+    ephPub, err := ecdh.X25519().NewPublicKey(b[:keySize])
+    if err != nil { return nil, ErrMalformed }
+    shared, err := id.priv.ECDH(ephPub)
+    if err != nil { return nil, err }
+
+    aead, err := wrapAEAD(shared, b[:keySize], id.priv.PublicKey().Bytes(), convID, epoch)
+    if err != nil { return nil, err }
+    key, err := open(aead, b[keySize:], nil)
+    if err != nil { return nil, err }
+    if len(key) != keySize { return nil, ErrInvalidKey }
+    return key, nil
+}
+
+// Seal encrypts a message body under the group key of an epoch and returns it base64
+// encoded. The conversation and the epoch are authenticated.
+func Seal(groupKey []byte, convID string, epoch int, plaintext []byte) (string, error) {
+    aead, err := newAEAD(groupKey)
+    if err != nil { return "", err }
+    out, err := seal(aead, nil, plaintext, bodyAD(convID, epoch))
+    if err != nil { return "", err }
+    return encoding.EncodeToString(out), nil
+}
+
+// Open decrypts a body produced by Seal.
+func Open(groupKey []byte, convID string, epoch int, body string) ([]byte, error) {
+    b, err := encoding.DecodeString(body)
+    if err != nil { return nil, ErrMalformed }
+    aead, err := newAEAD(groupKey)
+    if err != nil { return nil, err }
+    return open(aead, b, bodyAD(convID, epoch))
+}
+
+// KeyMetaCID addresses the wraps of an epoch: the CID of the canonical JSON of the
+// algorithm, the epoch and the wraps sorted by member.
+func KeyMetaCID(alg string, epoch int, wraps map[string]string) string {
+    type wrap struct {
+        DID        string `json:"did"`
+        WrappedKey string `json:"wrapped_key"`
+    }
+    meta := struct {
+        Alg   string `json:"alg"`
+        Epoch int    `json:"epoch"`
+        Wraps []wrap `json:"wraps"`
+    }{Alg: alg, Epoch: epoch, Wraps: make([]wrap, 0, len(wraps))}
+    for did, w := range wraps { meta.Wraps = append(meta.Wraps, wrap{DID: did, WrappedKey: w}) }
+    sort.Slice(meta.Wraps, func(i, j int) bool { return meta.Wraps[i].DID < meta.Wraps[j].DID })
+    data, _ := json.Marshal(meta)
+    return cas.Sum(data)
+}
+
+func parsePublicKey(pub string) (*ecdh.PublicKey, error) {
+    b, err := encoding.DecodeString(pub)
+    if err != nil { return nil, ErrInvalidPublicKey }
+    k, err := ecdh.X25519().NewPublicKey(b)
+    if err != nil { return nil, ErrInvalidPublicKey }
+    return k, nil
+}
+
+func wrapAEAD(shared, ephPub, memberPub []byte, convID string, epoch int) (cipher.AEAD, error) {
+    salt := append(append([]byte{}, ephPub...), memberPub...)
+    kdf := hkdf.New(sha256.New, shared, salt, []byte(fmt.Sprintf("peers-touch/key-wrap/%s/%d", convID, epoch)))
+    kek := make([]byte, keySize)
+    if _, err := io.ReadFull(kdf, kek); err != nil { return nil, err }
+    return newAEAD(kek)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+    if len(key) != keySize { return nil, ErrInvalidKey }
+    block, err := aes.NewCipher(key)
+    if err != nil { return nil, err }
+    return cipher.NewGCM(block)
+}
+
+// seal appends the random nonce and the sealed plaintext to prefix.
+func seal(aead cipher.AEAD, prefix, plaintext, ad []byte) ([]byte, error) {
+    nonce := make([]byte, aead.NonceSize())
+    if _, err := io.ReadFull(rand.Reader, nonce); err != nil { return nil, err }
+    out := append(append([]byte{}, prefix...), nonce...)
+    return aead.Seal(out, nonce, plaintext, ad), nil
+}
+
+func open(aead cipher.AEAD, b, ad []byte) ([]byte, error) {
+    if len(b) < aead.NonceSize()+aead.Overhead() { return nil, ErrMalformed }
+    n := aead.NonceSize()
+    return aead.Open(nil, b[:n], b[n:], ad)
+}
+
+func bodyAD(convID string, epoch int) []byte {
+    return []byte(fmt.Sprintf("peers-touch/body/%s/%d", convID, epoch))
+}
@@ -0,0 +1,65 @@
+package e2ee
+
+import (
+    "bytes"
+    "testing"
+)
+
+func TestWrapAndSeal(t *testing.T) {
+    alice, _ := GenerateIdentity()
+    bob, _ := GenerateIdentity()
+    key, err := NewGroupKey()
+    if err != nil {
+        t.Fatalf("NewGroupKey() error = %v", err)
+    }
+
+    wrapped, err := Wrap(key, bob.PublicKey(), "c1", 1)
+    if err != nil {
+        t.Fatalf("Wrap() error = %v", err)
+    }
+    got, err := bob.Unwrap(wrapped, "c1", 1)
+    if err != nil || !bytes.Equal(got, key) {
+        t.Fatalf("Unwrap() = %x, %v, want the group key", got, err)
+    }
+    if _, err := alice.Unwrap(wrapped, "c1", 1); err == nil {
+        t.Errorf("Unwrap() by another identity succeeded")
+    }
+    if _, err := bob.Unwrap(wrapped, "c1", 2); err == nil {
+        t.Errorf("Unwrap() for another epoch succeeded")
+    }
+
+    body, err := Seal(key, "c1", 1, []byte("hello"))
+    if err != nil {
+        t.Fatalf("Seal() error = %v", err)
+    }
+    if bytes.Contains([]byte(body), []byte("hello")) {
+        t.Errorf("Seal() leaks the plaintext")
+    }
+    if pt, err := Open(key, "c1", 1, body); err != nil || string(pt) != "hello" {
+        t.Errorf("Open() = %q, %v", pt, err)
+    }
+    if _, err := Open(key, "c2", 1, body); err == nil {
+        t.Errorf("Open() in another conversation succeeded")
+    }
+}
+
+func TestKeyMetaCID(t *testing.T) {
+    a := KeyMetaCID(Alg, 1, map[string]string{"did:a": "x", "did:b": "y"})
+    b := KeyMetaCID(Alg, 1, map[string]string{"did:b": "y", "did:a": "x"})
+    if a != b {
+        t.Errorf("KeyMetaCID() depends on map order")
+    }
+    if a == KeyMetaCID(Alg, 2, map[string]string{"did:a": "x", "did:b": "y"}) {
+        t.Errorf("KeyMetaCID() ignores the epoch")
+    }
+}
+
+func TestValidatePublicKey(t *testing.T) {
+    id, _ := GenerateIdentity()
+    if err := ValidatePublicKey(id.PublicKey()); err != nil {
+        t.Errorf("ValidatePublicKey() error = %v", err)
+    }
+    if err := ValidatePublicKey("bm90IGEga2V5"); err != ErrInvalidPublicKey {
+        t.Errorf("ValidatePublicKey() error = %v, want ErrInvalidPublicKey", err)
+    }
+}
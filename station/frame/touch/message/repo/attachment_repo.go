@@ -2,25 +2,95 @@ package repo
 
 import (
     "context"
+    "errors"
+    "time"
 
-    "github.com/peers-touch/peers-touch/station/frame/core/store"
     m "github.com/peers-touch/peers-touch/station/frame/touch/model/db"
 )
 
+// ErrOffsetConflict is returned when a concurrent chunk advanced the upload first.
+var ErrOffsetConflict = errors.New("upload offset conflict")
+
 type AttachmentRepo struct{}
 
 func NewAttachmentRepo() *AttachmentRepo { return &AttachmentRepo{} }
 
 func (r *AttachmentRepo) Save(ctx context.Context, a *m.Attachment) error {
-    db, err := store.GetRDS(ctx)
+    db, err := rds(ctx)
     if err != nil { return err }
     return db.Create(a).Error
 }
 
-func (r *AttachmentRepo) Get(ctx context.Context, cid string) (*m.Attachment, error) {
-    db, err := store.GetRDS(ctx)
+// Get returns the attachment of the content in the conversation.
+func (r *AttachmentRepo) Get(ctx context.Context, convID, cid string) (*m.Attachment, error) {
+    db, err := rds(ctx)
     if err != nil { return nil, err }
     var a m.Attachment
-    if err := db.Where("cid = ?", cid).First(&a).Error; err != nil { return nil, err }
+    if err := db.Where("conv_id = ? AND cid = ?", convID, cid).First(&a).Error; err != nil { return nil, err }
     return &a, nil
-}
\ No newline at end of file
+}
+
+// ListByCID returns the attachments of the content in every conversation it was uploaded to.
+func (r *AttachmentRepo) ListByCID(ctx context.Context, cid string) ([]*m.Attachment, error) {
+    db, err := rds(ctx)
+    if err != nil { return nil, err }
+    var list []*m.Attachment
+    if err := db.Where("cid = ?", cid).Order("created_at ASC").Find(&list).Error; err != nil { return nil, err }
+    return list, nil
+}
+
+// Referenced returns which of the CIDs attachments still refer to.
+func (r *AttachmentRepo) Referenced(ctx context.Context, cids []string) (map[string]struct{}, error) {
+    db, err := rds(ctx)
+    if err != nil { return nil, err }
+    var found []string
+    if len(cids) > 0 {
+        if err := db.Model(&m.Attachment{}).Distinct("cid").Where("cid IN ?", cids).Pluck("cid", &found).Error; err != nil { return nil, err }
+    }
+    set := make(map[string]struct{}, len(found))
+    for _, c := range found { set[c] = struct{}{} }
+    return set, nil
+}
+type BlobUploadRepo struct{}
+
+func NewBlobUploadRepo() *BlobUploadRepo { return &BlobUploadRepo{} }
+
+func (r *BlobUploadRepo) Create(ctx context.Context, u *m.BlobUpload) error {
+    db, err := rds(ctx)
+    if err != nil { return err }
+    return db.Create(u).Error
+}
+
+func (r *BlobUploadRepo) Get(ctx context.Context, id string) (*m.BlobUpload, error) {
+    db, err := rds(ctx)
+    if err != nil { return nil, err }
+    var u m.BlobUpload
+    if err := db.Where("id = ?", id).First(&u).Error; err != nil { return nil, err }
+    return &u, nil
+}
+
+// Advance moves the bytes received of the upload from from to to. It fails with ErrOffsetConflict if
+// another chunk moved it meanwhile.
+func (r *BlobUploadRepo) Advance(ctx context.Context, id string, from, to int64) error {
+    db, err := rds(ctx)
+    if err != nil { return err }
+    res := db.Model(&m.BlobUpload{}).Where("id = ? AND received = ?", id, from).Update("received", to)
+    if res.Error != nil { return res.Error }
+    if res.RowsAffected == 0 { return ErrOffsetConflict }
+    return nil
+}
+
+func (r *BlobUploadRepo) Delete(ctx context.Context, id string) error {
+    db, err := rds(ctx)
+    if err != nil { return err }
+    return db.Where("id = ?", id).Delete(&m.BlobUpload{}).Error
+}
+
+// ListExpired returns up to limit uploads abandoned before now.
+func (r *BlobUploadRepo) ListExpired(ctx context.Context, now time.Time, limit int) ([]*m.BlobUpload, error) {
+    db, err := rds(ctx)
+    if err != nil { return nil, err }
+    var list []*m.BlobUpload
+    if err := db.Where("expires_at <= ?", now).Order("expires_at ASC").Limit(limit).Find(&list).Error; err != nil { return nil, err }
+    return list, nil
+}
@@ -2,8 +2,9 @@ package repo
 
 import (
     "context"
+    "errors"
+    "time"
 
-    "github.com/peers-touch/peers-touch/station/frame/core/store"
     m "github.com/peers-touch/peers-touch/station/frame/touch/model/db"
     "gorm.io/gorm"
 )
@@ -13,23 +14,23 @@ type ConversationRepo struct{}
 func NewConversationRepo() *ConversationRepo { return &ConversationRepo{} }
 
 func (r *ConversationRepo) Create(ctx context.Context, c *m.Conversation) error {
-    db, err := store.GetRDS(ctx)
+    db, err := rds(ctx)
     if err != nil { return err }
     return db.Create(c).Error
 }
 
 func (r *ConversationRepo) GetByConvID(ctx context.Context, convID string) (*m.Conversation, error) {
-    db, err := store.GetRDS(ctx)
+    db, err := rds(ctx)
     if err != nil { return nil, err }
     var c m.Conversation
     if err := db.Where("conv_id = ?", convID).First(&c).Error; err != nil { return nil, err }
     return &c, nil
 }
 
-func (r *ConversationRepo) IncEpoch(ctx context.Context, convID string) error {
-    db, err := store.GetRDS(ctx)
+func (r *ConversationRepo) Save(ctx context.Context, c *m.Conversation) error {
+    db, err := rds(ctx)
     if err != nil { return err }
-    return db.Model(&m.Conversation{}).Where("conv_id = ?", convID).UpdateColumn("epoch", gorm.Expr("epoch + 1")).Error
+    return db.Save(c).Error
 }
 
 type MemberRepo struct{}
@@ -37,22 +38,54 @@ type MemberRepo struct{}
 func NewMemberRepo() *MemberRepo { return &MemberRepo{} }
 
 func (r *MemberRepo) List(ctx context.Context, convID uint64) ([]*m.ConvMember, error) {
-    db, err := store.GetRDS(ctx)
+    db, err := rds(ctx)
     if err != nil { return nil, err }
     var list []*m.ConvMember
     if err := db.Where("conv_id = ?", convID).Find(&list).Error; err != nil { return nil, err }
     return list, nil
 }
 
+// Get returns the member of the conversation, nil if did is not a member.
+func (r *MemberRepo) Get(ctx context.Context, convID uint64, did string) (*m.ConvMember, error) {
+    db, err := rds(ctx)
+    if err != nil { return nil, err }
+    var mbr m.ConvMember
+    err = db.Where("conv_id = ? AND did = ?", convID, did).First(&mbr).Error
+    if errors.Is(err, gorm.ErrRecordNotFound) { return nil, nil }
+    if err != nil { return nil, err }
+    return &mbr, nil
+}
+
+func (r *MemberRepo) CountRole(ctx context.Context, convID uint64, role m.Role) (int64, error) {
+    db, err := rds(ctx)
+    if err != nil { return 0, err }
+    var n int64
+    if err := db.Model(&m.ConvMember{}).Where("conv_id = ? AND role = ?", convID, role).Count(&n).Error; err != nil { return 0, err }
+    return n, nil
+}
+
+func (r *MemberRepo) UpdateRole(ctx context.Context, convID uint64, did string, role m.Role) error {
+    db, err := rds(ctx)
+    if err != nil { return err }
+    return db.Model(&m.ConvMember{}).Where("conv_id = ? AND did = ?", convID, did).Update("role", role).Error
+}
+
 func (r *MemberRepo) Add(ctx context.Context, convID uint64, did string, role m.Role) error {
-    db, err := store.GetRDS(ctx)
+    db, err := rds(ctx)
+    if err != nil { return err }
+    mbr := &m.ConvMember{ConvID: convID, DID: did, Role: role, JoinedAt: time.Now()}
+    return db.Create(mbr).Error
+}
+
+// Create adds a fully populated member, e.g. one restored from a snapshot.
+func (r *MemberRepo) Create(ctx context.Context, mbr *m.ConvMember) error {
+    db, err := rds(ctx)
     if err != nil { return err }
-    mbr := &m.ConvMember{ConvID: convID, DID: did, Role: role}
     return db.Create(mbr).Error
 }
 
 func (r *MemberRepo) Remove(ctx context.Context, convID uint64, did string) error {
-    db, err := store.GetRDS(ctx)
+    db, err := rds(ctx)
     if err != nil { return err }
     return db.Where("conv_id = ? AND did = ?", convID, did).Delete(&m.ConvMember{}).Error
 }
\ No newline at end of file
@@ -0,0 +1,103 @@
+package repo
+
+import (
+    "context"
+    "errors"
+
+    m "github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+)
+
+// ErrEpochConflict is returned when the conversation rotated its key meanwhile.
+var ErrEpochConflict = errors.New("key epoch conflict")
+
+type KeyEpochRepo struct{}
+
+func NewKeyEpochRepo() *KeyEpochRepo { return &KeyEpochRepo{} }
+
+func (r *KeyEpochRepo) Add(ctx context.Context, k *m.KeyEpoch) error {
+    db, err := rds(ctx)
+    if err != nil { return err }
+    return db.Create(k).Error
+}
+
+// Get returns the key epoch of the conversation, nil if it is unknown.
+func (r *KeyEpochRepo) Get(ctx context.Context, convPK uint64, epoch int) (*m.KeyEpoch, error) {
+    db, err := rds(ctx)
+    if err != nil { return nil, err }
+    var k m.KeyEpoch
+    err = db.Where("conv_id = ? AND epoch = ?", convPK, epoch).First(&k).Error
+    if errors.Is(err, gorm.ErrRecordNotFound) { return nil, nil }
+    if err != nil { return nil, err }
+    return &k, nil
+}
+
+// Rotate moves the conversation from prevEpoch to the epoch of k in one transaction: it
+// stores k with its wraps and removes the members removeDIDs, whose leaving triggered
+// the rotation. It fails with ErrEpochConflict if the epoch moved meanwhile.
+func (r *KeyEpochRepo) Rotate(ctx context.Context, prevEpoch int, k *m.KeyEpoch, wraps []*m.KeyWrap, removeDIDs []string) error {
+    db, err := rds(ctx)
+    if err != nil { return err }
+    return db.Transaction(func(tx *gorm.DB) error {
+        res := tx.Model(&m.Conversation{}).Where("id = ? AND epoch = ?", k.ConvID, prevEpoch).UpdateColumn("epoch", k.Epoch)
+        if res.Error != nil { return res.Error }
+        if res.RowsAffected == 0 { return ErrEpochConflict }
+        if len(removeDIDs) > 0 {
+            if err := tx.Where("conv_id = ? AND did IN ?", k.ConvID, removeDIDs).Delete(&m.ConvMember{}).Error; err != nil { return err }
+        }
+        if err := tx.Create(k).Error; err != nil { return err }
+        if len(wraps) == 0 { return nil }
+        return tx.Create(&wraps).Error
+    })
+}
+
+// AddWraps stores the wraps of the current epoch for members joining after the rotation.
+func (r *KeyEpochRepo) AddWraps(ctx context.Context, wraps []*m.KeyWrap) error {
+    if len(wraps) == 0 { return nil }
+    db, err := rds(ctx)
+    if err != nil { return err }
+    return db.Clauses(clause.OnConflict{DoNothing: true}).Create(&wraps).Error
+}
+
+// GetWrap returns the wrap of the member for the epoch, nil if there is none.
+func (r *KeyEpochRepo) GetWrap(ctx context.Context, convPK uint64, epoch int, did string) (*m.KeyWrap, error) {
+    db, err := rds(ctx)
+    if err != nil { return nil, err }
+    var w m.KeyWrap
+    err = db.Where("conv_id = ? AND epoch = ? AND member_did = ?", convPK, epoch, did).First(&w).Error
+    if errors.Is(err, gorm.ErrRecordNotFound) { return nil, nil }
+    if err != nil { return nil, err }
+    return &w, nil
+}
+
+func (r *KeyEpochRepo) ListWraps(ctx context.Context, convPK uint64, epoch int) ([]*m.KeyWrap, error) {
+    db, err := rds(ctx)
+    if err != nil { return nil, err }
+    var list []*m.KeyWrap
+    if err := db.Where("conv_id = ? AND epoch = ?", convPK, epoch).Find(&list).Error; err != nil { return nil, err }
+    return list, nil
+}
+
+type DIDKeyRepo struct{}
+
+func NewDIDKeyRepo() *DIDKeyRepo { return &DIDKeyRepo{} }
+
+// Put publishes the key of a DID, replacing its former one.
+func (r *DIDKeyRepo) Put(ctx context.Context, k *m.DIDKey) error {
+    db, err := rds(ctx)
+    if err != nil { return err }
+    return db.Clauses(clause.OnConflict{
+        Columns:   []clause.Column{{Name: "did"}},
+        DoUpdates: clause.AssignmentColumns([]string{"alg", "public_key", "updated_at"}),
+    }).Create(k).Error
+}
+
+func (r *DIDKeyRepo) List(ctx context.Context, dids []string) ([]*m.DIDKey, error) {
+    db, err := rds(ctx)
+    if err != nil { return nil, err }
+    var list []*m.DIDKey
+    if len(dids) == 0 { return list, nil }
+    if err := db.Where("did IN ?", dids).Find(&list).Error; err != nil { return nil, err }
+    return list, nil
+}
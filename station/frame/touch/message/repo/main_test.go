@@ -0,0 +1,9 @@
+package repo
+
+import (
+    "testing"
+
+    "github.com/peers-touch/peers-touch/station/frame/touch/internal/storetest"
+)
+
+func TestMain(m *testing.M) { storetest.Main(m) }
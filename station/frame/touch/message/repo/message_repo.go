@@ -2,27 +2,227 @@ package repo
 
 import (
     "context"
+    "errors"
+    "time"
 
-    "github.com/peers-touch/peers-touch/station/frame/core/store"
+    log "github.com/peers-touch/peers-touch/station/frame/core/logger"
+    "github.com/peers-touch/peers-touch/station/frame/touch/message/cursor"
+    "github.com/peers-touch/peers-touch/station/frame/touch/message/search"
     m "github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+    "gorm.io/gorm"
 )
 
+// ErrRevisionConflict is returned when a message changed since it was loaded.
+var ErrRevisionConflict = errors.New("message revision conflict")
+
 type MessageRepo struct{}
 
 func NewMessageRepo() *MessageRepo { return &MessageRepo{} }
 
 func (r *MessageRepo) Append(ctx context.Context, msg *m.Message) error {
-    db, err := store.GetRDS(ctx)
+    db, err := rds(ctx)
+    if err != nil { return err }
+    if err := db.Create(msg).Error; err != nil { return err }
+    // the message is stored already, a failing index must not fail the append.
+    if err := indexMessage(ctx, db, msg); err != nil { log.Warnf(ctx, "index message %s failed: %v", msg.ULID, err) }
+    return nil
+}
+
+// indexMessage puts msg in the search index. Sealed bodies are ciphertext to the server,
+// so messages of encrypted conversations are never indexed. The index goes through db, so
+// that it follows the transaction the message is stored in, in a savepoint of its own, so
+// that a failing index leaves that transaction usable.
+func indexMessage(ctx context.Context, db *gorm.DB, msg *m.Message) error {
+    if msg.Epoch > 0 { return nil }
+    body, err := search.ResolveBody(ctx, msg.Body, msg.ContentCID)
     if err != nil { return err }
-    return db.Create(msg).Error
+    return db.Transaction(func(tx *gorm.DB) error {
+        idx, err := search.ForDB(tx)
+        if err != nil { return err }
+        return idx.Put(ctx, &search.Doc{ULID: msg.ULID, ConvID: msg.ConvID, SenderDID: msg.SenderDID, Type: string(msg.Type), TS: msg.TS, Body: body})
+    })
+}
+
+// MessageFilter selects the messages of a conversation. ThreadID narrows it to a thread
+// with its root message, ParentID to the direct replies of a message.
+type MessageFilter struct {
+    ConvID   string
+    ThreadID string
+    ParentID string
+}
+
+// ListAfter returns up to limit messages following the (ts, ulid) key in ascending order,
+// from the first message if after is nil. inclusive keeps the message at the key.
+func (r *MessageRepo) ListAfter(ctx context.Context, f *MessageFilter, after *cursor.Cursor, inclusive bool, limit int) ([]*m.Message, error) {
+    db, err := rds(ctx)
+    if err != nil { return nil, err }
+    q := f.apply(db)
+    if after != nil {
+        op := ">"
+        if inclusive { op = ">=" }
+        q = q.Where("(ts > ? OR (ts = ? AND ulid "+op+" ?))", after.TS, after.TS, after.ULID)
+    }
+    var list []*m.Message
+    if err := q.Order("ts ASC, ulid ASC").Limit(limit).Find(&list).Error; err != nil { return nil, err }
+    return list, nil
 }
 
-func (r *MessageRepo) List(ctx context.Context, convID string, afterTS int64, limit int) ([]*m.Message, error) {
-    db, err := store.GetRDS(ctx)
+// ListBefore returns up to limit messages preceding the (ts, ulid) key in ascending order,
+// the latest ones if before is nil.
+func (r *MessageRepo) ListBefore(ctx context.Context, f *MessageFilter, before *cursor.Cursor, limit int) ([]*m.Message, error) {
+    db, err := rds(ctx)
     if err != nil { return nil, err }
+    q := f.apply(db)
+    if before != nil { q = q.Where("(ts < ? OR (ts = ? AND ulid < ?))", before.TS, before.TS, before.ULID) }
     var list []*m.Message
-    q := db.Where("conv_id = ? AND ts > ?", convID, afterTS).Order("ts ASC")
+    if err := q.Order("ts DESC, ulid DESC").Limit(limit).Find(&list).Error; err != nil { return nil, err }
+    for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 { list[i], list[j] = list[j], list[i] }
+    return list, nil
+}
+
+func (f *MessageFilter) apply(db *gorm.DB) *gorm.DB {
+    q := notExpired(db.Where("conv_id = ?", f.ConvID), time.Now())
+    if f.ThreadID != "" { q = q.Where("(thread_id = ? OR ulid = ?)", f.ThreadID, f.ThreadID) }
+    if f.ParentID != "" { q = q.Where("parent_id = ?", f.ParentID) }
+    return q
+}
+
+// ListAfterULID returns the messages of a conversation whose ULID sorts after cursor.
+func (r *MessageRepo) ListAfterULID(ctx context.Context, convID string, cursor string, limit int) ([]*m.Message, error) {
+    db, err := rds(ctx)
+    if err != nil { return nil, err }
+    var list []*m.Message
+    q := notExpired(db.Where("conv_id = ? AND ulid > ?", convID, cursor), time.Now()).Order("ulid ASC")
     if limit > 0 { q = q.Limit(limit) }
     if err := q.Find(&list).Error; err != nil { return nil, err }
     return list, nil
-}
\ No newline at end of file
+}
+
+// ListChangedUpTo returns up to limit messages at or before the cursor ULID that were
+// edited or deleted after since, oldest change first.
+func (r *MessageRepo) ListChangedUpTo(ctx context.Context, convID string, cursor string, since time.Time, limit int) ([]*m.Message, error) {
+    db, err := rds(ctx)
+    if err != nil { return nil, err }
+    var list []*m.Message
+    q := notExpired(db.Where("conv_id = ? AND ulid <= ? AND (edited_at > ? OR deleted_at > ?)", convID, cursor, since, since), time.Now()).Order("updated_at ASC")
+    if limit > 0 { q = q.Limit(limit) }
+    if err := q.Find(&list).Error; err != nil { return nil, err }
+    return list, nil
+}
+
+// ListLatest returns the newest limit messages of a conversation in ascending ULID order.
+func (r *MessageRepo) ListLatest(ctx context.Context, convID string, limit int) ([]*m.Message, error) {
+    db, err := rds(ctx)
+    if err != nil { return nil, err }
+    var list []*m.Message
+    if err := notExpired(db.Where("conv_id = ?", convID), time.Now()).Order("ulid DESC").Limit(limit).Find(&list).Error; err != nil { return nil, err }
+    for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 { list[i], list[j] = list[j], list[i] }
+    return list, nil
+}
+
+// ExistingULIDs returns which of the ULIDs are stored already.
+func (r *MessageRepo) ExistingULIDs(ctx context.Context, ulids []string) (map[string]struct{}, error) {
+    db, err := rds(ctx)
+    if err != nil { return nil, err }
+    var found []string
+    if len(ulids) > 0 {
+        if err := db.Model(&m.Message{}).Where("ulid IN ?", ulids).Pluck("ulid", &found).Error; err != nil { return nil, err }
+    }
+    set := make(map[string]struct{}, len(found))
+    for _, u := range found { set[u] = struct{}{} }
+    return set, nil
+}
+
+func (r *MessageRepo) Get(ctx context.Context, convID, ulid string) (*m.Message, error) {
+    db, err := rds(ctx)
+    if err != nil { return nil, err }
+    var msg m.Message
+    if err := db.Where("conv_id = ? AND ulid = ?", convID, ulid).First(&msg).Error; err != nil { return nil, err }
+    return &msg, nil
+}
+
+// Edit keeps the former content of msg as rev and stores the new content of msg. It
+// fails with ErrRevisionConflict if the message got edited or deleted meanwhile.
+func (r *MessageRepo) Edit(ctx context.Context, msg *m.Message, rev *m.MessageRevision) error {
+    db, err := rds(ctx)
+    if err != nil { return err }
+    err = db.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Create(rev).Error; err != nil { return err }
+        res := tx.Model(&m.Message{}).Where("ulid = ? AND rev = ? AND deleted = ?", msg.ULID, rev.Rev, false).
+            Updates(map[string]interface{}{"rev": msg.Rev, "edited_at": msg.EditedAt, "body": msg.Body, "content_cid": msg.ContentCID})
+        if res.Error != nil { return res.Error }
+        if res.RowsAffected == 0 { return ErrRevisionConflict }
+        return nil
+    })
+    if err != nil { return err }
+    if err := indexMessage(ctx, db, msg); err != nil { log.Warnf(ctx, "index message %s failed: %v", msg.ULID, err) }
+    return nil
+}
+
+// SoftDelete turns msg into a tombstone: the row stays to keep the conversation history
+// in order, but its content and revisions are gone.
+func (r *MessageRepo) SoftDelete(ctx context.Context, msg *m.Message) error {
+    db, err := rds(ctx)
+    if err != nil { return err }
+    err = db.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Where("msg_ulid = ?", msg.ULID).Delete(&m.MessageRevision{}).Error; err != nil { return err }
+        return tx.Model(&m.Message{}).Where("ulid = ?", msg.ULID).
+            Updates(map[string]interface{}{"deleted": true, "deleted_at": msg.DeletedAt, "body": "", "content_cid": ""}).Error
+    })
+    if err != nil { return err }
+    unindexMessages(ctx, db, msg.ULID)
+    return nil
+}
+
+func (r *MessageRepo) ListRevisions(ctx context.Context, ulid string) ([]*m.MessageRevision, error) {
+    db, err := rds(ctx)
+    if err != nil { return nil, err }
+    var list []*m.MessageRevision
+    if err := db.Where("msg_ulid = ?", ulid).Order("rev ASC").Find(&list).Error; err != nil { return nil, err }
+    return list, nil
+}
+
+// ListExpired returns up to limit messages whose TTL passed at now.
+func (r *MessageRepo) ListExpired(ctx context.Context, now time.Time, limit int) ([]*m.Message, error) {
+    db, err := rds(ctx)
+    if err != nil { return nil, err }
+    var list []*m.Message
+    if err := db.Where("ttl_at > ? AND ttl_at <= ?", time.Time{}, now).Order("ttl_at ASC").Limit(limit).Find(&list).Error; err != nil { return nil, err }
+    return list, nil
+}
+
+// Purge hard deletes the messages with everything hanging off them and returns the
+// attachments it dropped, so that their blobs can be released.
+func (r *MessageRepo) Purge(ctx context.Context, ulids []string) ([]*m.Attachment, error) {
+    if len(ulids) == 0 { return nil, nil }
+    db, err := rds(ctx)
+    if err != nil { return nil, err }
+    var atts []*m.Attachment
+    err = db.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Where("msg_ulid IN ?", ulids).Find(&atts).Error; err != nil { return err }
+        for _, model := range []interface{}{&m.Attachment{}, &m.MessageRevision{}, &m.Reaction{}, &m.Receipt{}} {
+            if err := tx.Where("msg_ulid IN ?", ulids).Delete(model).Error; err != nil { return err }
+        }
+        return tx.Where("ulid IN ?", ulids).Delete(&m.Message{}).Error
+    })
+    if err != nil { return nil, err }
+    unindexMessages(ctx, db, ulids...)
+    return atts, nil
+}
+
+// notExpired hides messages past their TTL that the reaper has not purged yet.
+func notExpired(q *gorm.DB, now time.Time) *gorm.DB {
+    return q.Where("(ttl_at <= ? OR ttl_at > ?)", time.Time{}, now)
+}
+
+func unindexMessages(ctx context.Context, db *gorm.DB, ulids ...string) {
+    if _, err := search.ForDB(db); err != nil { log.Warnf(ctx, "unindex messages failed: %v", err); return }
+    for _, u := range ulids {
+        err := db.Transaction(func(tx *gorm.DB) error {
+            idx, err := search.ForDB(tx)
+            if err != nil { return err }
+            return idx.Delete(ctx, u)
+        })
+        if err != nil { log.Warnf(ctx, "unindex message %s failed: %v", u, err) }
+    }
+}
@@ -0,0 +1,63 @@
+package repo
+
+import (
+    "context"
+    "time"
+
+    m "github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+    "gorm.io/gorm/clause"
+)
+
+type ReactionRepo struct{}
+
+func NewReactionRepo() *ReactionRepo { return &ReactionRepo{} }
+
+// EmojiCount aggregates the reactions of a message on one emoji.
+type EmojiCount struct {
+    Emoji   string `json:"emoji"`
+    Count   int64  `json:"count"`
+    Reacted bool   `json:"reacted"`
+}
+
+// Apply records the op unless the member has a newer op on the same emoji already. On a
+// TS tie remove wins, so a repeated op is a no-op. It reports whether the op applied.
+func (r *ReactionRepo) Apply(ctx context.Context, rc *m.Reaction) (bool, error) {
+    db, err := rds(ctx)
+    if err != nil { return false, err }
+    res := db.Clauses(clause.OnConflict{
+        Columns:   []clause.Column{{Name: "msg_ulid"}, {Name: "member_did"}, {Name: "emoji"}},
+        DoUpdates: clause.AssignmentColumns([]string{"op", "ts", "updated_at"}),
+        Where: clause.Where{Exprs: []clause.Expression{clause.Expr{
+            SQL:  "touch_reaction.ts < excluded.ts OR (touch_reaction.ts = excluded.ts AND touch_reaction.op <> excluded.op AND excluded.op = ?)",
+            Vars: []interface{}{m.ReactionOpRemove},
+        }}},
+    }).Create(rc)
+    if res.Error != nil { return false, res.Error }
+    return res.RowsAffected > 0, nil
+}
+
+// Counts returns the per emoji counts of a message, most used first, and flags the
+// emojis memberDID reacted with.
+func (r *ReactionRepo) Counts(ctx context.Context, msgULID, memberDID string) ([]*EmojiCount, error) {
+    db, err := rds(ctx)
+    if err != nil { return nil, err }
+    list := []*EmojiCount{}
+    err = db.Model(&m.Reaction{}).
+        Select("emoji, COUNT(*) AS count, MAX(CASE WHEN member_did = ? THEN 1 ELSE 0 END) = 1 AS reacted", memberDID).
+        Where("msg_ulid = ? AND op = ?", msgULID, m.ReactionOpAdd).
+        Group("emoji").Order("count DESC, emoji ASC").Scan(&list).Error
+    if err != nil { return nil, err }
+    return list, nil
+}
+
+// ListSince returns up to limit reaction ops applied to the messages of the conversation
+// after since, oldest first.
+func (r *ReactionRepo) ListSince(ctx context.Context, convID string, since time.Time, limit int) ([]*m.Reaction, error) {
+    db, err := rds(ctx)
+    if err != nil { return nil, err }
+    var list []*m.Reaction
+    q := db.Where("updated_at > ? AND msg_ulid IN (?)", since, db.Model(&m.Message{}).Select("ulid").Where("conv_id = ?", convID)).Order("updated_at ASC")
+    if limit > 0 { q = q.Limit(limit) }
+    if err := q.Find(&list).Error; err != nil { return nil, err }
+    return list, nil
+}
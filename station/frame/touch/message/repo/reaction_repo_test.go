@@ -0,0 +1,67 @@
+package repo
+
+import (
+    "context"
+    "reflect"
+    "testing"
+
+    "github.com/peers-touch/peers-touch/station/frame/touch/internal/storetest"
+    m "github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+)
+
+const reactedMsg = "01HF7YAT00AAAAAAAAAAAAAAAA"
+
+func TestReactionApply(t *testing.T) {
+    db := storetest.Reset(t)
+    ctx, r := context.Background(), NewReactionRepo()
+    for _, s := range []struct {
+        name  string
+        op    string
+        ts    int64
+        apply bool
+        state string
+    }{
+        {"first add", m.ReactionOpAdd, 10, true, m.ReactionOpAdd},
+        {"repeated add", m.ReactionOpAdd, 10, false, m.ReactionOpAdd},
+        {"older remove", m.ReactionOpRemove, 5, false, m.ReactionOpAdd},
+        {"remove wins a tie", m.ReactionOpRemove, 10, true, m.ReactionOpRemove},
+        {"repeated remove", m.ReactionOpRemove, 10, false, m.ReactionOpRemove},
+        {"add loses a tie", m.ReactionOpAdd, 10, false, m.ReactionOpRemove},
+        {"newer add", m.ReactionOpAdd, 11, true, m.ReactionOpAdd},
+        {"older add", m.ReactionOpAdd, 3, false, m.ReactionOpAdd},
+    } {
+        applied, err := r.Apply(ctx, &m.Reaction{MsgULID: reactedMsg, MemberDID: "did:peers:alice", Emoji: "👍", Op: s.op, TS: s.ts})
+        if err != nil { t.Fatalf("%s: %v", s.name, err) }
+        if applied != s.apply { t.Errorf("%s: applied = %v, want %v", s.name, applied, s.apply) }
+        var rows []*m.Reaction
+        if err := db.Where("msg_ulid = ?", reactedMsg).Find(&rows).Error; err != nil { t.Fatal(err) }
+        if len(rows) != 1 || rows[0].Op != s.state { t.Fatalf("%s: rows = %+v, want one %s", s.name, rows, s.state) }
+    }
+}
+
+func TestReactionCounts(t *testing.T) {
+    storetest.Reset(t)
+    ctx, r := context.Background(), NewReactionRepo()
+    for _, rc := range []*m.Reaction{
+        {MemberDID: "did:peers:alice", Emoji: "👍", Op: m.ReactionOpAdd, TS: 1},
+        {MemberDID: "did:peers:bob", Emoji: "👍", Op: m.ReactionOpAdd, TS: 1},
+        {MemberDID: "did:peers:carol", Emoji: "👍", Op: m.ReactionOpAdd, TS: 1},
+        {MemberDID: "did:peers:carol", Emoji: "👍", Op: m.ReactionOpRemove, TS: 2},
+        {MemberDID: "did:peers:bob", Emoji: "🎉", Op: m.ReactionOpAdd, TS: 1},
+        {MemberDID: "did:peers:alice", Emoji: "❤️", Op: m.ReactionOpAdd, TS: 1},
+        {MemberDID: "did:peers:alice", Emoji: "❤️", Op: m.ReactionOpAdd, TS: 1},
+    } {
+        rc.MsgULID = reactedMsg
+        if _, err := r.Apply(ctx, rc); err != nil { t.Fatal(err) }
+    }
+    if _, err := r.Apply(ctx, &m.Reaction{MsgULID: "01HF7YAT00BBBBBBBBBBBBBBBB", MemberDID: "did:peers:alice", Emoji: "👍", Op: m.ReactionOpAdd, TS: 1}); err != nil { t.Fatal(err) }
+
+    counts, err := r.Counts(ctx, reactedMsg, "did:peers:bob")
+    if err != nil { t.Fatal(err) }
+    want := []*EmojiCount{{Emoji: "👍", Count: 2, Reacted: true}, {Emoji: "❤️", Count: 1}, {Emoji: "🎉", Count: 1, Reacted: true}}
+    if !reflect.DeepEqual(counts, want) {
+        for _, c := range counts { t.Logf("%+v", c) }
+        t.Fatalf("counts differ from %v", want)
+    }
+    if counts, err := r.Counts(ctx, "01HF7YAT00CCCCCCCCCCCCCCCC", "did:peers:bob"); err != nil || len(counts) != 0 { t.Fatalf("counts of a message without reactions = %v, %v", counts, err) }
+}
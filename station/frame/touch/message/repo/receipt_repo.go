@@ -2,27 +2,121 @@ package repo
 
 import (
     "context"
+    "fmt"
     "time"
 
+    log "github.com/peers-touch/peers-touch/station/frame/core/logger"
     "github.com/peers-touch/peers-touch/station/frame/core/store"
     m "github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
 )
 
 type ReceiptRepo struct{}
 
 func NewReceiptRepo() *ReceiptRepo { return &ReceiptRepo{} }
 
-func (r *ReceiptRepo) Add(ctx context.Context, rcpt *m.Receipt) error {
-    db, err := store.GetRDS(ctx)
+// AddFailure records a message that could not reach a member.
+func (r *ReceiptRepo) AddFailure(ctx context.Context, rcpt *m.Receipt) error {
+    db, err := rds(ctx)
     if err != nil { return err }
     return db.Create(rcpt).Error
 }
 
-func (r *ReceiptRepo) ListAfter(ctx context.Context, convID string, after int64) ([]*m.Receipt, error) {
-    db, err := store.GetRDS(ctx)
+// ListFailures returns the failures of the conversation recorded after after.
+func (r *ReceiptRepo) ListFailures(ctx context.Context, convID string, after time.Time) ([]*m.Receipt, error) {
+    db, err := rds(ctx)
     if err != nil { return nil, err }
     var list []*m.Receipt
-    t := time.UnixMilli(after)
-    if err := db.Table("touch_receipt r").Joins("JOIN touch_message m ON m.ulid = r.msg_ulid").Where("m.conv_id = ? AND r.delivered_at > ?", convID, t).Find(&list).Error; err != nil { return nil, err }
+    err = db.Table("touch_receipt r").Select("r.*").Joins("JOIN touch_message m ON m.ulid = r.msg_ulid").
+        Where("m.conv_id = ? AND r.fail_reason <> '' AND r.created_at > ?", convID, after).Order("r.created_at ASC").Find(&list).Error
+    if err != nil { return nil, err }
+    return list, nil
+}
+
+type WatermarkRepo struct{}
+
+func NewWatermarkRepo() *WatermarkRepo { return &WatermarkRepo{} }
+
+// Advance moves the watermarks of w.MemberDID forward to the ULIDs of w; empty or older
+// ULIDs leave the stored ones as they are. A read ULID advances the delivered one too.
+func (r *WatermarkRepo) Advance(ctx context.Context, w *m.Watermark) error {
+    db, err := rds(ctx)
+    if err != nil { return err }
+    return advanceWatermark(db, w)
+}
+
+// Get returns the watermarks of the member, zero ones if it has none yet.
+func (r *WatermarkRepo) Get(ctx context.Context, convID, did string) (*m.Watermark, error) {
+    db, err := rds(ctx)
+    if err != nil { return nil, err }
+    w := m.Watermark{ConvID: convID, MemberDID: did}
+    if err := db.Where("conv_id = ? AND member_did = ?", convID, did).Limit(1).Find(&w).Error; err != nil { return nil, err }
+    return &w, nil
+}
+
+func (r *WatermarkRepo) List(ctx context.Context, convID string) ([]*m.Watermark, error) {
+    db, err := rds(ctx)
+    if err != nil { return nil, err }
+    var list []*m.Watermark
+    if err := db.Where("conv_id = ?", convID).Order("member_did ASC").Find(&list).Error; err != nil { return nil, err }
     return list, nil
-}
\ No newline at end of file
+}
+
+// ListSince returns the watermarks of the conversation that moved after since.
+func (r *WatermarkRepo) ListSince(ctx context.Context, convID string, since time.Time) ([]*m.Watermark, error) {
+    db, err := rds(ctx)
+    if err != nil { return nil, err }
+    var list []*m.Watermark
+    if err := db.Where("conv_id = ? AND updated_at > ?", convID, since).Order("updated_at ASC").Find(&list).Error; err != nil { return nil, err }
+    return list, nil
+}
+
+// Unread counts the live messages of others after the read watermark of the member.
+func (r *WatermarkRepo) Unread(ctx context.Context, convID, did, readULID string) (int64, error) {
+    db, err := rds(ctx)
+    if err != nil { return 0, err }
+    var n int64
+    q := notExpired(db.Model(&m.Message{}).Where("conv_id = ? AND ulid > ? AND sender_did <> ? AND deleted = ?", convID, readULID, did, false), time.Now())
+    if err := q.Count(&n).Error; err != nil { return 0, err }
+    return n, nil
+}
+
+func advanceWatermark(db *gorm.DB, w *m.Watermark) error {
+    if w.ReadULID > w.DeliveredULID { w.DeliveredULID = w.ReadULID }
+    forward := func(col string) clause.Expr {
+        return gorm.Expr("CASE WHEN excluded."+col+" > touch_watermark."+col+" THEN excluded."+col+" ELSE touch_watermark."+col+" END")
+    }
+    return db.Clauses(clause.OnConflict{
+        Columns:   []clause.Column{{Name: "conv_id"}, {Name: "member_did"}},
+        DoUpdates: clause.Assignments(map[string]interface{}{"delivered_ulid": forward("delivered_ulid"), "read_ulid": forward("read_ulid"), "updated_at": time.Now()}),
+    }).Create(w).Error
+}
+
+// migrateReceipts folds the per message delivery and read receipts of former versions into
+// watermarks, keeping only the failures. It is a no-op once they are gone.
+func migrateReceipts(ctx context.Context, db *gorm.DB) (int, error) {
+    zero := time.Time{}
+    var marks []*m.Watermark
+    err := db.Table("touch_receipt r").Joins("JOIN touch_message m ON m.ulid = r.msg_ulid").
+        Select("m.conv_id AS conv_id, r.member_did AS member_did, COALESCE(MAX(CASE WHEN r.delivered_at > ? OR r.read_at > ? THEN r.msg_ulid END), '') AS delivered_ulid, COALESCE(MAX(CASE WHEN r.read_at > ? THEN r.msg_ulid END), '') AS read_ulid", zero, zero, zero).
+        Where("r.fail_reason = '' OR r.fail_reason IS NULL").Group("m.conv_id, r.member_did").Scan(&marks).Error
+    if err != nil { return 0, err }
+    if len(marks) == 0 { return 0, nil }
+
+    err = db.Transaction(func(tx *gorm.DB) error {
+        for _, w := range marks {
+            if err := advanceWatermark(tx, w); err != nil { return err }
+        }
+        return tx.Where("fail_reason = '' OR fail_reason IS NULL").Delete(&m.Receipt{}).Error
+    })
+    return len(marks), err
+}
+
+func init() {
+    store.InitTableHooks(func(ctx context.Context, rds *gorm.DB) {
+        n, err := migrateReceipts(ctx, rds)
+        if err != nil { panic(fmt.Errorf("migrate message receipts to watermarks failed: %v", err)) }
+        if n > 0 { log.Infof(ctx, "migrated receipts of %d members to watermarks", n) }
+    })
+}
@@ -0,0 +1,120 @@
+package repo
+
+import (
+    "context"
+    "fmt"
+    "testing"
+    "time"
+
+    "github.com/peers-touch/peers-touch/station/frame/touch/internal/storetest"
+    m "github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+)
+
+// markedMsg returns the i-th ULID of a conversation, they sort like i.
+func markedMsg(i int) string { return fmt.Sprintf("01HF7YAT00AAAAAAAAAAAAAA%02d", i) }
+
+func TestWatermarkAdvance(t *testing.T) {
+    storetest.Reset(t)
+    ctx, r := context.Background(), NewWatermarkRepo()
+    for _, s := range []struct {
+        name      string
+        delivered int
+        read      int
+        want      [2]int
+    }{
+        {"first delivery", 2, 0, [2]int{2, 0}},
+        {"read implies delivered", 0, 3, [2]int{3, 3}},
+        {"older delivery", 1, 0, [2]int{3, 3}},
+        {"older read", 0, 1, [2]int{3, 3}},
+        {"newer delivery", 5, 0, [2]int{5, 3}},
+        {"read below delivered", 0, 4, [2]int{5, 4}},
+        {"older of both", 2, 2, [2]int{5, 4}},
+    } {
+        w := &m.Watermark{ConvID: "conv-mark", MemberDID: "did:peers:bob"}
+        if s.delivered > 0 { w.DeliveredULID = markedMsg(s.delivered) }
+        if s.read > 0 { w.ReadULID = markedMsg(s.read) }
+        if err := r.Advance(ctx, w); err != nil { t.Fatalf("%s: %v", s.name, err) }
+
+        got, err := r.Get(ctx, "conv-mark", "did:peers:bob")
+        if err != nil { t.Fatal(err) }
+        want := m.Watermark{DeliveredULID: markedMsg(s.want[0])}
+        if s.want[1] > 0 { want.ReadULID = markedMsg(s.want[1]) }
+        if got.DeliveredULID != want.DeliveredULID || got.ReadULID != want.ReadULID {
+            t.Fatalf("%s: watermark = %s/%s, want %s/%s", s.name, got.DeliveredULID, got.ReadULID, want.DeliveredULID, want.ReadULID)
+        }
+    }
+    list, err := r.List(ctx, "conv-mark")
+    if err != nil || len(list) != 1 { t.Fatalf("list = %+v, %v", list, err) }
+}
+
+func TestWatermarkUnread(t *testing.T) {
+    db := storetest.Reset(t)
+    ctx, r := context.Background(), NewWatermarkRepo()
+    msgs := []*m.Message{
+        {ULID: markedMsg(1), ConvID: "conv-unread", SenderDID: "did:peers:bob", TS: 1},
+        {ULID: markedMsg(2), ConvID: "conv-unread", SenderDID: "did:peers:bob", TS: 2},
+        {ULID: markedMsg(3), ConvID: "conv-unread", SenderDID: "did:peers:alice", TS: 3},
+        {ULID: markedMsg(4), ConvID: "conv-unread", SenderDID: "did:peers:bob", TS: 4, Deleted: true},
+        {ULID: markedMsg(5), ConvID: "conv-unread", SenderDID: "did:peers:bob", TS: 5, TTLAt: time.Now().Add(-time.Minute)},
+        {ULID: markedMsg(6), ConvID: "conv-unread", SenderDID: "did:peers:bob", TS: 6, TTLAt: time.Now().Add(time.Hour)},
+        {ULID: markedMsg(7), ConvID: "conv-other", SenderDID: "did:peers:bob", TS: 7},
+    }
+    if err := db.Create(msgs).Error; err != nil { t.Fatal(err) }
+
+    unread := func() int64 {
+        t.Helper()
+        w, err := r.Get(ctx, "conv-unread", "did:peers:alice")
+        if err != nil { t.Fatal(err) }
+        n, err := r.Unread(ctx, "conv-unread", "did:peers:alice", w.ReadULID)
+        if err != nil { t.Fatal(err) }
+        return n
+    }
+    // own, deleted and expired messages don't count, nor those of other conversations
+    if n := unread(); n != 3 { t.Fatalf("unread without a watermark = %d, want 3", n) }
+    if err := r.Advance(ctx, &m.Watermark{ConvID: "conv-unread", MemberDID: "did:peers:alice", ReadULID: markedMsg(2)}); err != nil { t.Fatal(err) }
+    if n := unread(); n != 1 { t.Fatalf("unread after reading up to 2 = %d, want 1", n) }
+    if err := r.Advance(ctx, &m.Watermark{ConvID: "conv-unread", MemberDID: "did:peers:alice", ReadULID: markedMsg(1)}); err != nil { t.Fatal(err) }
+    if n := unread(); n != 1 { t.Fatalf("unread after an older read = %d, want 1", n) }
+    if err := r.Advance(ctx, &m.Watermark{ConvID: "conv-unread", MemberDID: "did:peers:alice", ReadULID: markedMsg(6)}); err != nil { t.Fatal(err) }
+    if n := unread(); n != 0 { t.Fatalf("unread after reading all = %d", n) }
+}
+
+func TestMigrateReceipts(t *testing.T) {
+    db := storetest.Reset(t)
+    ctx, r := context.Background(), NewWatermarkRepo()
+    var msgs []*m.Message
+    for i := 1; i <= 4; i++ { msgs = append(msgs, &m.Message{ULID: markedMsg(i), ConvID: "conv-legacy", SenderDID: "did:peers:alice", TS: int64(i)}) }
+    if err := db.Create(msgs).Error; err != nil { t.Fatal(err) }
+
+    at := time.Now().Add(-time.Hour)
+    receipts := []*m.Receipt{
+        {MsgULID: markedMsg(1), MemberDID: "did:peers:bob", DeliveredAt: at, ReadAt: at},
+        {MsgULID: markedMsg(2), MemberDID: "did:peers:bob", DeliveredAt: at, ReadAt: at},
+        {MsgULID: markedMsg(3), MemberDID: "did:peers:bob", DeliveredAt: at},
+        // read without a delivery of its own
+        {MsgULID: markedMsg(2), MemberDID: "did:peers:carol", ReadAt: at},
+        {MsgULID: markedMsg(4), MemberDID: "did:peers:carol", FailReason: "no prekeys"},
+        {MsgULID: markedMsg(1), MemberDID: "did:peers:dave", DeliveredAt: at},
+    }
+    if err := db.Create(receipts).Error; err != nil { t.Fatal(err) }
+    // dave got a watermark beyond his receipts already, the migration must not move it back
+    if err := r.Advance(ctx, &m.Watermark{ConvID: "conv-legacy", MemberDID: "did:peers:dave", ReadULID: markedMsg(4)}); err != nil { t.Fatal(err) }
+
+    n, err := migrateReceipts(ctx, db)
+    if err != nil { t.Fatal(err) }
+    if n != 3 { t.Fatalf("migrated %d members, want 3", n) }
+    for did, want := range map[string][2]string{
+        "did:peers:bob":   {markedMsg(3), markedMsg(2)},
+        "did:peers:carol": {markedMsg(2), markedMsg(2)},
+        "did:peers:dave":  {markedMsg(4), markedMsg(4)},
+    } {
+        w, err := r.Get(ctx, "conv-legacy", did)
+        if err != nil { t.Fatal(err) }
+        if w.DeliveredULID != want[0] || w.ReadULID != want[1] { t.Errorf("watermark of %s = %s/%s, want %s/%s", did, w.DeliveredULID, w.ReadULID, want[0], want[1]) }
+    }
+
+    var left []*m.Receipt
+    if err := db.Find(&left).Error; err != nil { t.Fatal(err) }
+    if len(left) != 1 || left[0].FailReason != "no prekeys" { t.Fatalf("receipts left = %+v", left) }
+    if n, err := migrateReceipts(ctx, db); n != 0 || err != nil { t.Fatalf("migrate again = %d, %v", n, err) }
+}
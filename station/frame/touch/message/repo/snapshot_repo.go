@@ -0,0 +1,63 @@
+package repo
+
+import (
+    "context"
+    "errors"
+
+    m "github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+    "gorm.io/gorm"
+)
+
+type SnapshotRepo struct{}
+
+func NewSnapshotRepo() *SnapshotRepo { return &SnapshotRepo{} }
+
+// Save stores the snapshot unless one with the same CID exists already, in which case
+// s is filled with the stored row.
+func (r *SnapshotRepo) Save(ctx context.Context, s *m.Snapshot) error {
+    db, err := rds(ctx)
+    if err != nil { return err }
+    return db.Where("cid = ?", s.CID).FirstOrCreate(s).Error
+}
+
+func (r *SnapshotRepo) Get(ctx context.Context, cid string) (*m.Snapshot, error) {
+    db, err := rds(ctx)
+    if err != nil { return nil, err }
+    var s m.Snapshot
+    if err := db.Where("cid = ?", cid).First(&s).Error; err != nil { return nil, err }
+    return &s, nil
+}
+
+// Latest returns the newest snapshot of the conversation, nil if there is none.
+func (r *SnapshotRepo) Latest(ctx context.Context, convID string) (*m.Snapshot, error) {
+    db, err := rds(ctx)
+    if err != nil { return nil, err }
+    var s m.Snapshot
+    err = db.Where("conv_id = ?", convID).Order("created_at DESC, id DESC").First(&s).Error
+    if errors.Is(err, gorm.ErrRecordNotFound) { return nil, nil }
+    if err != nil { return nil, err }
+    return &s, nil
+}
+
+// Prune deletes the snapshots of the conversation but the newest keep ones.
+func (r *SnapshotRepo) Prune(ctx context.Context, convID string, keep int) (int64, error) {
+    db, err := rds(ctx)
+    if err != nil { return 0, err }
+    var ids []uint64
+    if err := db.Model(&m.Snapshot{}).Where("conv_id = ?", convID).Order("created_at DESC, id DESC").Offset(keep).Pluck("id", &ids).Error; err != nil { return 0, err }
+    if len(ids) == 0 { return 0, nil }
+    res := db.Where("id IN ?", ids).Delete(&m.Snapshot{})
+    return res.RowsAffected, res.Error
+}
+
+// ListStale returns the conversations that got messages after their latest snapshot.
+func (r *SnapshotRepo) ListStale(ctx context.Context, limit int) ([]string, error) {
+    db, err := rds(ctx)
+    if err != nil { return nil, err }
+    var convIDs []string
+    q := db.Table("touch_message m").Select("m.conv_id").Group("m.conv_id").
+        Having("MAX(m.ulid) > COALESCE((SELECT MAX(s.head_ulid) FROM touch_snapshot s WHERE s.conv_id = m.conv_id), '')")
+    if limit > 0 { q = q.Limit(limit) }
+    if err := q.Pluck("m.conv_id", &convIDs).Error; err != nil { return nil, err }
+    return convIDs, nil
+}
@@ -0,0 +1,27 @@
+package repo
+
+import (
+    "context"
+
+    "github.com/peers-touch/peers-touch/station/frame/core/store"
+    "gorm.io/gorm"
+)
+
+type txKey struct{}
+
+// Transaction runs fn in one database transaction. The repos called with the context fn
+// gets work in that transaction, so everything fn stores is kept or rolled back at once.
+// Nested calls run in a savepoint of the outer one.
+func Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+    db, err := rds(ctx)
+    if err != nil { return err }
+    return db.Transaction(func(tx *gorm.DB) error {
+        return fn(context.WithValue(ctx, txKey{}, tx))
+    })
+}
+
+// rds returns the transaction of ctx if there is one, the default RDS otherwise.
+func rds(ctx context.Context) (*gorm.DB, error) {
+    if tx, ok := ctx.Value(txKey{}).(*gorm.DB); ok { return tx, nil }
+    return store.GetRDS(ctx)
+}
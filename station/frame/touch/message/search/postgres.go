@@ -0,0 +1,86 @@
+package search
+
+import (
+    "context"
+    "fmt"
+
+    "gorm.io/gorm"
+)
+
+const (
+    postgresTable = "touch_message_search"
+    // postgresConfig is language neutral, conversations mix languages.
+    postgresConfig = "simple"
+)
+
+// postgresIndex keeps bodies with a generated tsvector column behind a GIN index.
+type postgresIndex struct {
+    db *gorm.DB
+}
+
+func newPostgresIndex(db *gorm.DB) Index { return &postgresIndex{db: db} }
+
+func (p *postgresIndex) Migrate(ctx context.Context) error {
+    db := p.db.WithContext(ctx)
+    stmts := []string{
+        fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+            ulid VARCHAR(26) PRIMARY KEY,
+            conv_id VARCHAR(64) NOT NULL,
+            sender_did VARCHAR(256) NOT NULL,
+            type VARCHAR(16) NOT NULL,
+            ts BIGINT NOT NULL,
+            body TEXT NOT NULL,
+            tsv TSVECTOR GENERATED ALWAYS AS (to_tsvector('%s', body)) STORED
+        )`, postgresTable, postgresConfig),
+        fmt.Sprintf(`CREATE INDEX IF NOT EXISTS idx_%s_tsv ON %s USING GIN (tsv)`, postgresTable, postgresTable),
+        fmt.Sprintf(`CREATE INDEX IF NOT EXISTS idx_%s_conv_ts ON %s (conv_id, ts)`, postgresTable, postgresTable),
+    }
+    for _, stmt := range stmts {
+        if err := db.Exec(stmt).Error; err != nil { return err }
+    }
+    return nil
+}
+
+func (p *postgresIndex) Put(ctx context.Context, doc *Doc) error {
+    db := p.db.WithContext(ctx)
+    if doc.Body == "" { return p.Delete(ctx, doc.ULID) }
+    return db.Exec(`INSERT INTO `+postgresTable+` (ulid, conv_id, sender_did, type, ts, body) VALUES (?, ?, ?, ?, ?, ?)
+        ON CONFLICT (ulid) DO UPDATE SET conv_id = EXCLUDED.conv_id, sender_did = EXCLUDED.sender_did,
+            type = EXCLUDED.type, ts = EXCLUDED.ts, body = EXCLUDED.body`,
+        doc.ULID, doc.ConvID, doc.SenderDID, doc.Type, doc.TS, doc.Body).Error
+}
+
+func (p *postgresIndex) Delete(ctx context.Context, ulid string) error {
+    return p.db.WithContext(ctx).Exec(`DELETE FROM `+postgresTable+` WHERE ulid = ?`, ulid).Error
+}
+
+func (p *postgresIndex) Search(ctx context.Context, q *Query) (*Result, error) {
+    if err := q.normalize(); err != nil { return nil, err }
+
+    tsq := fmt.Sprintf("websearch_to_tsquery('%s', ?)", postgresConfig)
+    where, args := q.filters()
+    where = "tsv @@ " + tsq + " AND " + where
+    args = append([]interface{}{q.Text}, args...)
+
+    db := p.db.WithContext(ctx)
+    res := &Result{}
+    if err := db.Raw(`SELECT COUNT(*) FROM `+postgresTable+` WHERE `+where, args...).Scan(&res.Total).Error; err != nil { return nil, err }
+
+    opts := fmt.Sprintf("StartSel=%s, StopSel=%s, MaxFragments=2, MaxWords=24, MinWords=8", matchStart, matchEnd)
+    pageArgs := append([]interface{}{q.Text, opts, q.Text}, args...)
+    pageArgs = append(pageArgs, q.Limit, q.Offset)
+    err := db.Raw(fmt.Sprintf(`SELECT ulid, conv_id, sender_did, type, ts,
+            ts_headline('%s', body, %s, ?) AS highlight,
+            ts_rank(tsv, %s) AS rank
+        FROM %s WHERE %s
+        ORDER BY rank DESC, ts DESC
+        LIMIT ? OFFSET ?`, postgresConfig, tsq, tsq, postgresTable, where), pageArgs...).Scan(&res.Hits).Error
+    if err != nil { return nil, err }
+
+    res.finish(q)
+    return res, nil
+}
+
+func init() {
+    RegisterBackend("postgres", newPostgresIndex)
+}
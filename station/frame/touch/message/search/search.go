@@ -0,0 +1,191 @@
+package search
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "html"
+    "strings"
+    "sync"
+    "time"
+
+    log "github.com/peers-touch/peers-touch/station/frame/core/logger"
+    "github.com/peers-touch/peers-touch/station/frame/core/store"
+    "gorm.io/gorm"
+)
+
+const (
+    defaultLimit = 20
+    maxLimit     = 100
+
+    highlightStart = "<mark>"
+    highlightEnd   = "</mark>"
+    // the backends mark matches with control characters, the highlight tags replace them
+    // once the snippet is escaped.
+    matchStart = "\x02"
+    matchEnd   = "\x03"
+
+    // messageTable holds the messages the index is built from
+    messageTable = "touch_message"
+)
+
+var (
+    ErrEmptyQuery         = errors.New("search query is empty")
+    ErrBackendUnsupported = func(driver string) error { return fmt.Errorf("no message search backend for driver[%s]", driver) }
+)
+
+// Doc is the searchable projection of a message.
+type Doc struct {
+    ULID      string
+    ConvID    string
+    SenderDID string
+    Type      string
+    TS        int64
+    Body      string
+}
+
+// Query filters the messages of one conversation. Zero values are ignored.
+type Query struct {
+    ConvID    string
+    Text      string
+    SenderDID string
+    Type      string
+    FromTS    int64
+    ToTS      int64
+    Limit     int
+    Offset    int
+}
+
+type Hit struct {
+    ULID      string  `json:"ulid" gorm:"column:ulid"`
+    ConvID    string  `json:"conv_id"`
+    SenderDID string  `json:"sender_did" gorm:"column:sender_did"`
+    Type      string  `json:"type"`
+    TS        int64   `json:"ts"`
+    // Highlight is the matching part of the body, HTML-escaped, with the matches in mark tags.
+    Highlight string  `json:"highlight"`
+    Rank      float64 `json:"rank"`
+}
+
+type Result struct {
+    Hits       []*Hit `json:"hits"`
+    Total      int64  `json:"total"`
+    NextOffset int    `json:"next_offset,omitempty"`
+    HasMore    bool   `json:"has_more"`
+}
+
+// Index is a full-text index backend of message bodies.
+type Index interface {
+    // Migrate creates the backend tables if they are missing.
+    Migrate(ctx context.Context) error
+    // Put indexes the doc, replacing the former version of the same message.
+    Put(ctx context.Context, doc *Doc) error
+    Delete(ctx context.Context, ulid string) error
+    Search(ctx context.Context, q *Query) (*Result, error)
+}
+
+// ContentResolver loads the text of a message body stored by content id.
+type ContentResolver func(ctx context.Context, cid string) (string, error)
+
+var (
+    lock     sync.RWMutex
+    backends = make(map[string]func(db *gorm.DB) Index)
+    resolver ContentResolver
+)
+
+// RegisterBackend binds an index backend to a store driver name, the same name used by
+// store.RegisterDriver.
+func RegisterBackend(driver string, newIndex func(db *gorm.DB) Index) {
+    lock.Lock()
+    defer lock.Unlock()
+
+    if _, ok := backends[driver]; ok { panic("duplicate search backend " + driver) }
+    backends[driver] = newIndex
+}
+
+// SetContentResolver sets how bodies referenced by ContentCID are loaded for indexing.
+func SetContentResolver(r ContentResolver) {
+    lock.Lock()
+    defer lock.Unlock()
+    resolver = r
+}
+
+// ForDB returns the index backend matching the driver of db.
+func ForDB(db *gorm.DB) (Index, error) {
+    lock.RLock()
+    defer lock.RUnlock()
+
+    driver := db.Dialector.Name()
+    newIndex, ok := backends[driver]
+    if !ok { return nil, ErrBackendUnsupported(driver) }
+    return newIndex(db), nil
+}
+
+// Get returns the index of the default RDS.
+func Get(ctx context.Context) (Index, error) {
+    db, err := store.GetRDS(ctx)
+    if err != nil { return nil, err }
+    return ForDB(db)
+}
+
+// ResolveBody returns the text to index: the inline body if present, or the content
+// loaded through the registered resolver.
+func ResolveBody(ctx context.Context, body, contentCID string) (string, error) {
+    if body != "" || contentCID == "" { return body, nil }
+
+    lock.RLock()
+    r := resolver
+    lock.RUnlock()
+    if r == nil { return "", nil }
+    return r(ctx, contentCID)
+}
+
+func (q *Query) normalize() error {
+    q.Text = strings.TrimSpace(q.Text)
+    if q.Text == "" { return ErrEmptyQuery }
+    if q.Limit <= 0 { q.Limit = defaultLimit }
+    if q.Limit > maxLimit { q.Limit = maxLimit }
+    if q.Offset < 0 { q.Offset = 0 }
+    return nil
+}
+
+// finish escapes the highlights of the hits and sets the pagination of the result.
+func (r *Result) finish(q *Query) {
+    for _, h := range r.Hits { h.Highlight = highlight(h.Highlight) }
+    if int64(q.Offset+len(r.Hits)) < r.Total {
+        r.HasMore = true
+        r.NextOffset = q.Offset + len(r.Hits)
+    }
+}
+
+// highlight HTML-escapes a snippet of the backend and wraps its matches in mark tags, so
+// the markup of message bodies shows as text.
+func highlight(snippet string) string {
+    return highlighter.Replace(html.EscapeString(snippet))
+}
+
+var highlighter = strings.NewReplacer(matchStart, highlightStart, matchEnd, highlightEnd)
+
+// filters returns the shared WHERE clause of the backends.
+func (q *Query) filters() (string, []interface{}) {
+    // messages past their TTL stay indexed until the reaper purges them, they are left out
+    // like the message repo leaves them out
+    clause := []string{"conv_id = ?", "ulid NOT IN (SELECT ulid FROM " + messageTable + " WHERE conv_id = ? AND ttl_at > ? AND ttl_at <= ?)"}
+    args := []interface{}{q.ConvID, q.ConvID, time.Time{}, time.Now()}
+    if q.SenderDID != "" { clause = append(clause, "sender_did = ?"); args = append(args, q.SenderDID) }
+    if q.Type != "" { clause = append(clause, "type = ?"); args = append(args, q.Type) }
+    if q.FromTS > 0 { clause = append(clause, "ts >= ?"); args = append(args, q.FromTS) }
+    if q.ToTS > 0 { clause = append(clause, "ts <= ?"); args = append(args, q.ToTS) }
+    return strings.Join(clause, " AND "), args
+}
+
+func init() {
+    store.InitTableHooks(func(ctx context.Context, rds *gorm.DB) {
+        idx, err := ForDB(rds)
+        if err != nil {
+            log.Warnf(ctx, "message search disabled: %v", err)
+            return
+        }
+        if err := idx.Migrate(ctx); err != nil { panic(fmt.Errorf("migrate message search failed: %v", err)) }
+    })
+}
@@ -0,0 +1,54 @@
+package search
+
+import (
+    "testing"
+)
+
+func TestSQLiteMatch(t *testing.T) {
+    cases := map[string]string{
+        "hello":           `"hello*"`,
+        "  hello world ":  `"hello" "world*"`,
+        `say "hi" OR x`:   `"say" "hi" "OR" "x*"`,
+        `"quoted"`:        `"quoted*"`,
+        "":                "",
+    }
+    for in, want := range cases {
+        if got := sqliteMatch(in); got != want {
+            t.Errorf("sqliteMatch(%q) = %s, want %s", in, got, want)
+        }
+    }
+}
+
+func TestQueryPaginate(t *testing.T) {
+    q := &Query{Text: " hi ", Limit: 1000, Offset: -1}
+    if err := q.normalize(); err != nil {
+        t.Fatalf("normalize() error = %v", err)
+    }
+    if q.Text != "hi" || q.Limit != maxLimit || q.Offset != 0 {
+        t.Fatalf("normalize() = %+v", q)
+    }
+    if err := (&Query{Text: "  "}).normalize(); err != ErrEmptyQuery {
+        t.Errorf("normalize() error = %v, want ErrEmptyQuery", err)
+    }
+
+    q = &Query{Text: "hi", Limit: 2, Offset: 2}
+    r := &Result{Hits: []*Hit{{}, {}}, Total: 5}
+    r.finish(q)
+    if !r.HasMore || r.NextOffset != 4 {
+        t.Errorf("finish() = %+v, want next offset 4", r)
+    }
+    r = &Result{Hits: []*Hit{{}}, Total: 5}
+    q.Offset = 4
+    r.finish(q)
+    if r.HasMore {
+        t.Errorf("finish() has more on the last page")
+    }
+}
+
+func TestHighlight(t *testing.T) {
+    got := highlight("say <img src=x onerror=alert(1)> " + matchStart + "hello" + matchEnd + " & bye")
+    want := "say &lt;img src=x onerror=alert(1)&gt; <mark>hello</mark> &amp; bye"
+    if got != want {
+        t.Errorf("highlight() = %q, want %q", got, want)
+    }
+}
@@ -0,0 +1,83 @@
+package search
+
+import (
+    "context"
+    "strings"
+
+    "gorm.io/gorm"
+)
+
+const sqliteTable = "touch_message_fts"
+
+// sqliteIndex keeps bodies in an FTS4 virtual table, FTS5 is left out of the default build
+// of the SQLite driver. The filter columns are notindexed so that they don't take part in
+// MATCH.
+type sqliteIndex struct {
+    db *gorm.DB
+}
+
+func newSQLiteIndex(db *gorm.DB) Index { return &sqliteIndex{db: db} }
+
+func (s *sqliteIndex) Migrate(ctx context.Context) error {
+    return s.db.WithContext(ctx).Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS ` + sqliteTable + ` USING fts4(
+        body, ulid, conv_id, sender_did, type, ts,
+        notindexed=ulid, notindexed=conv_id, notindexed=sender_did, notindexed=type, notindexed=ts,
+        tokenize=unicode61 "remove_diacritics=2"
+    )`).Error
+}
+
+func (s *sqliteIndex) Put(ctx context.Context, doc *Doc) error {
+    return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+        if err := tx.Exec(`DELETE FROM `+sqliteTable+` WHERE ulid = ?`, doc.ULID).Error; err != nil { return err }
+        if doc.Body == "" { return nil }
+        return tx.Exec(`INSERT INTO `+sqliteTable+` (body, ulid, conv_id, sender_did, type, ts) VALUES (?, ?, ?, ?, ?, ?)`,
+            doc.Body, doc.ULID, doc.ConvID, doc.SenderDID, doc.Type, doc.TS).Error
+    })
+}
+
+func (s *sqliteIndex) Delete(ctx context.Context, ulid string) error {
+    return s.db.WithContext(ctx).Exec(`DELETE FROM `+sqliteTable+` WHERE ulid = ?`, ulid).Error
+}
+
+func (s *sqliteIndex) Search(ctx context.Context, q *Query) (*Result, error) {
+    if err := q.normalize(); err != nil { return nil, err }
+    match := sqliteMatch(q.Text)
+    if match == "" { return nil, ErrEmptyQuery }
+
+    where, args := q.filters()
+    where = sqliteTable + " MATCH ? AND " + where
+    args = append([]interface{}{match}, args...)
+
+    db := s.db.WithContext(ctx)
+    res := &Result{}
+    if err := db.Raw(`SELECT COUNT(*) FROM `+sqliteTable+` WHERE `+where, args...).Scan(&res.Total).Error; err != nil { return nil, err }
+
+    pageArgs := append([]interface{}{matchStart, matchEnd}, args...)
+    pageArgs = append(pageArgs, q.Limit, q.Offset)
+    // FTS4 has no ranking function, the rank is the number of matches offsets() lists,
+    // four integers each
+    err := db.Raw(`SELECT ulid, conv_id, sender_did, type, CAST(ts AS INTEGER) AS ts,
+            snippet(`+sqliteTable+`, ?, ?, '…', 0, 16) AS highlight,
+            (length(offsets(`+sqliteTable+`)) - length(replace(offsets(`+sqliteTable+`), ' ', '')) + 1) / 4 AS rank
+        FROM `+sqliteTable+` WHERE `+where+`
+        ORDER BY rank DESC, ts DESC
+        LIMIT ? OFFSET ?`, pageArgs...).Scan(&res.Hits).Error
+    if err != nil { return nil, err }
+
+    res.finish(q)
+    return res, nil
+}
+
+// sqliteMatch turns free text into an FTS4 expression. Every term is quoted so that
+// user input can't use the query syntax, and the last one matches as a prefix. Quotes
+// can't be escaped in a phrase, they are dropped like the tokenizer drops them.
+func sqliteMatch(text string) string {
+    terms := strings.Fields(strings.ReplaceAll(text, `"`, " "))
+    if n := len(terms); n > 0 { terms[n-1] += "*" }
+    for i, t := range terms { terms[i] = `"` + t + `"` }
+    return strings.Join(terms, " ")
+}
+
+func init() {
+    RegisterBackend("sqlite", newSQLiteIndex)
+}
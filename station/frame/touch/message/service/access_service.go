@@ -0,0 +1,40 @@
+package service
+
+import (
+    "context"
+    "errors"
+
+    "github.com/peers-touch/peers-touch/station/frame/touch/message/repo"
+    "github.com/peers-touch/peers-touch/station/frame/touch/model"
+    m "github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+    "gorm.io/gorm"
+)
+
+// AccessService decides what an authenticated actor may do in a conversation.
+type AccessService struct {
+    convRepo   *repo.ConversationRepo
+    memberRepo *repo.MemberRepo
+}
+
+func NewAccessService() *AccessService {
+    return &AccessService{convRepo: repo.NewConversationRepo(), memberRepo: repo.NewMemberRepo()}
+}
+
+// Access is an actor acting as a member of a conversation.
+type Access struct {
+    Conv   *m.Conversation
+    Member *m.ConvMember
+}
+
+// Check returns the membership of did in the conversation. With manage set the member
+// also needs a role that may change members and rotate keys.
+func (s *AccessService) Check(ctx context.Context, convID, did string, manage bool) (*Access, error) {
+    conv, err := s.convRepo.GetByConvID(ctx, convID)
+    if errors.Is(err, gorm.ErrRecordNotFound) { return nil, model.ErrConvNotFound }
+    if err != nil { return nil, err }
+    mbr, err := s.memberRepo.Get(ctx, conv.ID, did)
+    if err != nil { return nil, err }
+    if mbr == nil { return nil, model.ErrConvNotMember }
+    if manage && !mbr.Role.CanManage() { return nil, model.ErrConvForbidden }
+    return &Access{Conv: conv, Member: mbr}, nil
+}
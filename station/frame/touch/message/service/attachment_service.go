@@ -1,22 +1,267 @@
 package service
 
 import (
+    "bytes"
     "context"
+    "errors"
+    "io"
+    "path/filepath"
+    "strings"
+    "sync"
+    "time"
 
-    m "github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+    cfg "github.com/peers-touch/peers-touch/station/frame/core/config"
+    log "github.com/peers-touch/peers-touch/station/frame/core/logger"
+    "github.com/peers-touch/peers-touch/station/frame/core/store"
+    "github.com/peers-touch/peers-touch/station/frame/touch/message/blob"
+    "github.com/peers-touch/peers-touch/station/frame/touch/message/cas"
     "github.com/peers-touch/peers-touch/station/frame/touch/message/repo"
+    "github.com/peers-touch/peers-touch/station/frame/touch/message/search"
+    "github.com/peers-touch/peers-touch/station/frame/touch/model"
+    m "github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+    "github.com/peers-touch/peers-touch/station/frame/touch/util"
+    "gorm.io/gorm"
+)
+
+const (
+    defaultBlobRoot      = "data/blobs"
+    defaultBlobMaxBytes  = 100 << 20
+    defaultUploadTTL     = 24 * time.Hour
+    defaultUploadJanitor = 10 * time.Minute
+    // uploadBatch is the number of expired uploads dropped per round.
+    uploadBatch = 100
+    // maxIndexedContent caps the text attachments loaded to index a ContentCID body.
+    maxIndexedContent = 1 << 20
 )
 
+// uploadLocks serializes the chunks of an upload, keyed by upload id.
+var uploadLocks sync.Map
+
 type AttachmentService struct {
-    attRepo *repo.AttachmentRepo
+    attRepo    *repo.AttachmentRepo
+    uploadRepo *repo.BlobUploadRepo
+    spool      *blob.Spool
+    maxBytes   int64
+    uploadTTL  time.Duration
+}
+
+func NewAttachmentService() *AttachmentService {
+    return &AttachmentService{
+        attRepo:    repo.NewAttachmentRepo(),
+        uploadRepo: repo.NewBlobUploadRepo(),
+        spool:      blob.NewSpool(filepath.Join(blobRoot(), ".uploads")),
+        maxBytes:   int64(cfg.Get("peers", "touch", "message", "blob", "max_bytes").Int(defaultBlobMaxBytes)),
+        uploadTTL:  cfg.Get("peers", "touch", "message", "blob", "upload_ttl").Duration(defaultUploadTTL),
+    }
+}
+
+// UploadReq announces an attachment upload. Digest is the sha-256 Digest header value of
+// the whole content; CID, when set, is checked as well.
+type UploadReq struct {
+    ConvID      string
+    MsgULID     string
+    UploaderDID string
+    MIME        string
+    Bytes       int64
+    Digest      string
+    CID         string
+    Store       string
+}
+
+// CreateUpload opens a resumable upload, the content then comes in with UploadChunk.
+func (s *AttachmentService) CreateUpload(ctx context.Context, req *UploadReq) (*m.BlobUpload, error) {
+    if req.Bytes <= 0 || req.Bytes > s.maxBytes { return nil, model.ErrUploadTooLarge }
+    if _, err := blob.ParseDigest(req.Digest); err != nil { return nil, model.ErrUploadDigest }
+    if req.CID != "" {
+        if _, err := cas.Digest(req.CID); err != nil { return nil, model.ErrUploadCIDMismatch }
+    }
+    if req.Store == "" { req.Store = blob.StoreLocal }
+    if _, err := blob.Get(req.Store); err != nil { return nil, model.ErrBlobStoreUnsupported }
+
+    u := &m.BlobUpload{ConvID: req.ConvID, MsgULID: req.MsgULID, UploaderDID: req.UploaderDID, MIME: req.MIME, Bytes: req.Bytes, Digest: req.Digest, CID: req.CID, Store: req.Store, ExpiresAt: time.Now().Add(s.uploadTTL)}
+    if err := s.uploadRepo.Create(ctx, u); err != nil { return nil, err }
+    return u, nil
 }
 
-func NewAttachmentService() *AttachmentService { return &AttachmentService{attRepo: repo.NewAttachmentRepo()} }
+// Upload returns an upload of the uploader, a client resumes from its Received bytes.
+func (s *AttachmentService) Upload(ctx context.Context, convID, uploadID, did string) (*m.BlobUpload, error) {
+    u, err := s.uploadRepo.Get(ctx, uploadID)
+    if errors.Is(err, gorm.ErrRecordNotFound) { return nil, model.ErrUploadNotFound }
+    if err != nil { return nil, err }
+    if u.ConvID != convID || time.Now().After(u.ExpiresAt) { return nil, model.ErrUploadNotFound }
+    if u.UploaderDID != did { return nil, model.ErrUploadNotUploader }
+    return u, nil
+}
+
+// UploadChunk appends the chunk read from r at offset. The last chunk completes the
+// upload: the content is verified and stored, and the attachment is returned.
+func (s *AttachmentService) UploadChunk(ctx context.Context, convID, uploadID, did string, offset int64, r io.Reader) (*m.BlobUpload, *m.Attachment, error) {
+    mu, _ := uploadLocks.LoadOrStore(uploadID, &sync.Mutex{})
+    mu.(*sync.Mutex).Lock()
+    defer mu.(*sync.Mutex).Unlock()
+
+    u, err := s.Upload(ctx, convID, uploadID, did)
+    if err != nil { return nil, nil, err }
+    if offset != u.Received { return u, nil, model.ErrUploadOffset }
+    received, err := s.spool.Append(u.ID, offset, r, u.Bytes-offset)
+    if errors.Is(err, blob.ErrTooLarge) { return u, nil, model.ErrUploadTooLarge }
+    if err != nil { return u, nil, err }
+    if err := s.uploadRepo.Advance(ctx, u.ID, offset, received); err != nil {
+        if errors.Is(err, repo.ErrOffsetConflict) { return u, nil, model.ErrUploadOffset }
+        return u, nil, err
+    }
+    u.Received = received
+    if u.Received < u.Bytes { return u, nil, nil }
+
+    a, err := s.complete(ctx, u)
+    return u, a, err
+}
+
+// AbortUpload drops an upload and what it received.
+func (s *AttachmentService) AbortUpload(ctx context.Context, convID, uploadID, did string) error {
+    u, err := s.Upload(ctx, convID, uploadID, did)
+    if err != nil { return err }
+    return s.dropUpload(ctx, u)
+}
+
+// Get returns the attachment of the content in the conversation.
+func (s *AttachmentService) Get(ctx context.Context, convID, cid string) (*m.Attachment, error) {
+    a, err := s.attRepo.Get(ctx, convID, cid)
+    if errors.Is(err, gorm.ErrRecordNotFound) { return nil, model.ErrAttachmentNotFound }
+    return a, err
+}
+
+// List returns the attachments of the content addressed by cid, one per conversation it
+// was uploaded to.
+func (s *AttachmentService) List(ctx context.Context, cid string) ([]*m.Attachment, error) {
+    list, err := s.attRepo.ListByCID(ctx, cid)
+    if err != nil { return nil, err }
+    if len(list) == 0 { return nil, model.ErrAttachmentNotFound }
+    return list, nil
+}
 
-func (s *AttachmentService) Save(ctx context.Context, a *m.Attachment) error {
-    return s.attRepo.Save(ctx, a)
+// Open reads length bytes of the attachment content from offset, length < 0 reads to the end.
+func (s *AttachmentService) Open(ctx context.Context, a *m.Attachment, offset, length int64) (io.ReadCloser, error) {
+    st, err := blob.Get(a.Store)
+    if err != nil { return nil, model.ErrBlobStoreUnsupported }
+    rc, err := st.Open(ctx, a.CID, offset, length)
+    if errors.Is(err, blob.ErrNotFound) { return nil, model.ErrAttachmentNotFound }
+    return rc, err
 }
 
-func (s *AttachmentService) Get(ctx context.Context, cid string) (*m.Attachment, error) {
-    return s.attRepo.Get(ctx, cid)
-}
\ No newline at end of file
+// DropBlobs deletes the content of attachments whose rows are gone, e.g. purged with
+// their expired messages. Content other conversations still refer to is kept.
+func (s *AttachmentService) DropBlobs(ctx context.Context, atts []*m.Attachment) {
+    cids := make([]string, 0, len(atts))
+    for _, a := range atts { cids = append(cids, a.CID) }
+    kept, err := s.attRepo.Referenced(ctx, cids)
+    if err != nil { log.Warnf(ctx, "drop blobs: %v", err); return }
+    dropped := make(map[string]struct{}, len(atts))
+    for _, a := range atts {
+        if _, ok := kept[a.CID]; ok { continue }
+        if _, ok := dropped[a.CID]; ok { continue }
+        dropped[a.CID] = struct{}{}
+        st, err := blob.Get(a.Store)
+        if err != nil { log.Warnf(ctx, "drop blob %s: %v", a.CID, err); continue }
+        if err := st.Delete(ctx, a.CID); err != nil { log.Warnf(ctx, "drop blob %s: %v", a.CID, err) }
+    }
+}
+
+// PurgeUploads drops the uploads abandoned before now and returns how many it dropped.
+func (s *AttachmentService) PurgeUploads(ctx context.Context, now time.Time) (int, error) {
+    total := 0
+    for {
+        list, err := s.uploadRepo.ListExpired(ctx, now, uploadBatch)
+        if err != nil { return total, err }
+        for _, u := range list {
+            if err := s.dropUpload(ctx, u); err != nil { return total, err }
+        }
+        total += len(list)
+        if len(list) < uploadBatch { return total, nil }
+    }
+}
+
+// complete verifies the staged content against the digest announced, stores it under its
+// CID and records the attachment of the conversation. Content uploaded to other
+// conversations before shares the blob, each conversation has its own row. A mismatching
+// upload is dropped, the client starts over.
+func (s *AttachmentService) complete(ctx context.Context, u *m.BlobUpload) (*m.Attachment, error) {
+    want, _ := blob.ParseDigest(u.Digest)
+    f, err := s.spool.Open(u.ID)
+    if err != nil { return nil, err }
+    defer f.Close()
+
+    h := cas.NewHash()
+    if _, err := io.Copy(h, f); err != nil { return nil, err }
+    sum := h.Sum(nil)
+    if !bytes.Equal(sum, want) { return nil, s.reject(ctx, u, model.ErrUploadDigestMismatch) }
+    cid := cas.FromDigest(sum)
+    if u.CID != "" && u.CID != cid { return nil, s.reject(ctx, u, model.ErrUploadCIDMismatch) }
+
+    st, err := blob.Get(u.Store)
+    if err != nil { return nil, model.ErrBlobStoreUnsupported }
+    if _, err := f.Seek(0, io.SeekStart); err != nil { return nil, err }
+    if err := st.Put(ctx, cid, f, u.Bytes); err != nil { return nil, err }
+
+    a, err := s.attRepo.Get(ctx, u.ConvID, cid)
+    switch {
+    case errors.Is(err, gorm.ErrRecordNotFound):
+        a = &m.Attachment{CID: cid, ConvID: u.ConvID, MsgULID: u.MsgULID, MIME: u.MIME, Bytes: u.Bytes, Digest: blob.FormatDigest(sum), Store: u.Store}
+        if err := s.attRepo.Save(ctx, a); err != nil { return nil, err }
+    case err != nil:
+        return nil, err
+    }
+    if err := s.dropUpload(ctx, u); err != nil { log.Warnf(ctx, "drop completed upload %s: %v", u.ID, err) }
+    return a, nil
+}
+
+// resolveContent loads the text attachment of a message body referenced by CID for the
+// search index. Binary and large attachments are not indexed.
+func (s *AttachmentService) resolveContent(ctx context.Context, cid string) (string, error) {
+    list, err := s.List(ctx, cid)
+    if errors.Is(err, model.ErrAttachmentNotFound) { return "", nil }
+    if err != nil { return "", err }
+    a := list[0]
+    if !strings.HasPrefix(a.MIME, "text/") || a.Bytes > maxIndexedContent { return "", nil }
+    rc, err := s.Open(ctx, a, 0, -1)
+    if err != nil { return "", err }
+    defer rc.Close()
+    b, err := io.ReadAll(io.LimitReader(rc, maxIndexedContent))
+    return string(b), err
+}
+
+func (s *AttachmentService) reject(ctx context.Context, u *m.BlobUpload, cause error) error {
+    if err := s.dropUpload(ctx, u); err != nil { log.Warnf(ctx, "drop rejected upload %s: %v", u.ID, err) }
+    return cause
+}
+
+func (s *AttachmentService) dropUpload(ctx context.Context, u *m.BlobUpload) error {
+    uploadLocks.Delete(u.ID)
+    if err := s.spool.Remove(u.ID); err != nil { return err }
+    return s.uploadRepo.Delete(ctx, u.ID)
+}
+
+func blobRoot() string {
+    return cfg.Get("peers", "touch", "message", "blob", "root").String(defaultBlobRoot)
+}
+
+// startBlobStore registers the local store below peers.touch.message.blob.root, lets the
+// search index load text attachments referenced as message bodies, and drops abandoned
+// uploads every peers.touch.message.blob.janitor_interval, 0 disables it. Other backends, e.g. an S3Store, are registered by the deployment with blob.Register.
+func startBlobStore(ctx context.Context, _ *gorm.DB) {
+    if _, err := blob.Get(blob.StoreLocal); err != nil { blob.Register(blob.StoreLocal, blob.NewLocalStore(blobRoot())) }
+
+    svc := NewAttachmentService()
+    search.SetContentResolver(svc.resolveContent)
+
+    interval := cfg.Get("peers", "touch", "message", "blob", "janitor_interval").Duration(defaultUploadJanitor)
+    util.RunEvery(context.WithoutCancel(ctx), "blob-upload-janitor", interval, func(ctx context.Context) error {
+        n, err := svc.PurgeUploads(ctx, time.Now())
+        if n > 0 { log.Infof(ctx, "dropped %d abandoned uploads", n) }
+        return err
+    })
+}
+
+func init() {
+    store.InitTableHooks(startBlobStore)
+}
@@ -0,0 +1,60 @@
+package service
+
+import (
+    "bytes"
+    "context"
+    "crypto/sha256"
+    "errors"
+    "testing"
+    "time"
+
+    "github.com/peers-touch/peers-touch/station/frame/touch/internal/storetest"
+    "github.com/peers-touch/peers-touch/station/frame/touch/message/blob"
+    "github.com/peers-touch/peers-touch/station/frame/touch/message/repo"
+    "github.com/peers-touch/peers-touch/station/frame/touch/model"
+    m "github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+)
+
+func TestAttachmentSharedAcrossConversations(t *testing.T) {
+    rds := storetest.Reset(t)
+    ctx := context.Background()
+    blob.Register(blob.StoreLocal, blob.NewLocalStore(t.TempDir()))
+    // built by hand, NewAttachmentService reads its limits from the station config
+    svc := &AttachmentService{attRepo: repo.NewAttachmentRepo(), uploadRepo: repo.NewBlobUploadRepo(), spool: blob.NewSpool(t.TempDir()), maxBytes: 1 << 20, uploadTTL: time.Hour}
+
+    content := []byte("the same picture")
+    sum := sha256.Sum256(content)
+    upload := func(convID, did string) *m.Attachment {
+        t.Helper()
+        u, err := svc.CreateUpload(ctx, &UploadReq{ConvID: convID, UploaderDID: did, MIME: "image/png", Bytes: int64(len(content)), Digest: blob.FormatDigest(sum[:])})
+        if err != nil { t.Fatal(err) }
+        _, a, err := svc.UploadChunk(ctx, convID, u.ID, did, 0, bytes.NewReader(content))
+        if err != nil { t.Fatal(err) }
+        return a
+    }
+
+    a := upload("conv-a", "did:peers:alice")
+    // another member uploads the same content to a conversation of their own, unaware of the first one
+    b := upload("conv-b", "did:peers:bob")
+    if a.CID != b.CID || a.ConvID != "conv-a" || b.ConvID != "conv-b" {
+        t.Fatalf("attachments = %+v and %+v", a, b)
+    }
+    if again := upload("conv-a", "did:peers:alice"); again.CID != a.CID || again.ConvID != "conv-a" { t.Fatalf("upload again = %+v", again) }
+    list, err := svc.List(ctx, a.CID)
+    if err != nil || len(list) != 2 { t.Fatalf("list = %v, %v", list, err) }
+    if _, err := svc.Get(ctx, "conv-c", a.CID); !errors.Is(err, model.ErrAttachmentNotFound) { t.Fatalf("get in another conversation: %v", err) }
+
+    open := func() error {
+        rc, err := svc.Open(ctx, b, 0, -1)
+        if err == nil { rc.Close() }
+        return err
+    }
+    // conv-a drops its attachment, conv-b still refers to the content
+    if err := rds.Where("conv_id = ?", "conv-a").Delete(&m.Attachment{}).Error; err != nil { t.Fatal(err) }
+    svc.DropBlobs(ctx, []*m.Attachment{a})
+    if err := open(); err != nil { t.Fatalf("shared blob dropped: %v", err) }
+
+    if err := rds.Where("conv_id = ?", "conv-b").Delete(&m.Attachment{}).Error; err != nil { t.Fatal(err) }
+    svc.DropBlobs(ctx, []*m.Attachment{b})
+    if err := open(); !errors.Is(err, model.ErrAttachmentNotFound) { t.Fatalf("unreferenced blob kept: %v", err) }
+}
@@ -3,6 +3,7 @@ package service
 import (
     "context"
 
+    "github.com/peers-touch/peers-touch/station/frame/touch/model"
     m "github.com/peers-touch/peers-touch/station/frame/touch/model/db"
     "github.com/peers-touch/peers-touch/station/frame/touch/message/repo"
 )
@@ -10,10 +11,11 @@ import (
 type ConversationService struct {
     convRepo   *repo.ConversationRepo
     memberRepo *repo.MemberRepo
+    keys       *KeyService
 }
 
 func NewConversationService() *ConversationService {
-    return &ConversationService{convRepo: repo.NewConversationRepo(), memberRepo: repo.NewMemberRepo()}
+    return &ConversationService{convRepo: repo.NewConversationRepo(), memberRepo: repo.NewMemberRepo(), keys: NewKeyService()}
 }
 
 type CreateConvReq struct {
@@ -22,11 +24,25 @@ type CreateConvReq struct {
     Title     string
     AvatarCID string
     Policy    string
+    // Encrypted conversations carry ciphertext only; the owner starts them with a rotation
+    // to epoch 1.
+    Encrypted bool
+    // OwnerDID is the creator, who joins as the first owner.
+    OwnerDID  string
+    // Members join as members along with the owner. Members of encrypted conversations
+    // need wraps of the key, they are added with AddMembers once it exists.
+    Members   []string
 }
 
 func (s *ConversationService) Create(ctx context.Context, req *CreateConvReq) (*m.Conversation, error) {
-    c := &m.Conversation{ConvID: req.ConvID, Type: m.ConversationType(req.Type), Title: req.Title, AvatarCID: req.AvatarCID, Policy: req.Policy, Epoch: 0}
+    c := &m.Conversation{ConvID: req.ConvID, Type: m.ConversationType(req.Type), Title: req.Title, AvatarCID: req.AvatarCID, Policy: req.Policy, Encrypted: req.Encrypted, Epoch: 0}
+    if req.Encrypted && len(req.Members) > 0 { return nil, model.ErrKeyWrapsMismatch }
     if err := s.convRepo.Create(ctx, c); err != nil { return nil, err }
+    if err := s.memberRepo.Add(ctx, c.ID, req.OwnerDID, m.RoleOwner); err != nil { return nil, err }
+    for _, d := range req.Members {
+        if d == req.OwnerDID { continue }
+        if err := s.memberRepo.Add(ctx, c.ID, d, m.RoleMember); err != nil { return nil, err }
+    }
     return c, nil
 }
 
@@ -38,20 +54,61 @@ func (s *ConversationService) Members(ctx context.Context, convID uint64) ([]*m.
     return s.memberRepo.List(ctx, convID)
 }
 
-func (s *ConversationService) AddMembers(ctx context.Context, convID uint64, dids []string, role m.Role) error {
+// AddMembers adds the dids with role, member by default, on behalf of a managing member.
+// Only owners can make owners. DIDs that are members already are left as they are.
+// In an encrypted conversation wraps has to hold the current epoch key for every new member.
+func (s *ConversationService) AddMembers(ctx context.Context, a *Access, dids []string, role m.Role, wraps map[string]string) error {
+    if role == "" { role = m.RoleMember }
+    if role != m.RoleOwner && role != m.RoleAdmin && role != m.RoleMember { return model.ErrConvInvalidRole }
+    if role == m.RoleOwner && a.Member.Role != m.RoleOwner { return model.ErrConvForbidden }
+    added := make([]string, 0, len(dids))
     for _, d := range dids {
-        if err := s.memberRepo.Add(ctx, convID, d, role); err != nil { return err }
+        mbr, err := s.memberRepo.Get(ctx, a.Conv.ID, d)
+        if err != nil { return err }
+        if mbr != nil { continue }
+        added = append(added, d)
+    }
+    if a.Conv.Encrypted {
+        newWraps := make(map[string]string, len(added))
+        for _, d := range added { newWraps[d] = wraps[d] }
+        if err := s.keys.addWraps(ctx, a, added, newWraps); err != nil { return err }
+    }
+    for _, d := range added {
+        if err := s.memberRepo.Add(ctx, a.Conv.ID, d, role); err != nil { return err }
     }
     return nil
 }
 
-func (s *ConversationService) RemoveMembers(ctx context.Context, convID uint64, dids []string) error {
+// RemoveMembers removes the dids on behalf of a managing member. Only owners can remove
+// owners, and the last owner always stays.
+// Removed members of an encrypted conversation still hold the current key, so there
+// rotate is required and the removal happens together with the rotation.
+func (s *ConversationService) RemoveMembers(ctx context.Context, a *Access, dids []string, rotate *RotateReq) error {
+    owners, err := s.memberRepo.CountRole(ctx, a.Conv.ID, m.RoleOwner)
+    if err != nil { return err }
+    removed := make([]string, 0, len(dids))
     for _, d := range dids {
-        if err := s.memberRepo.Remove(ctx, convID, d); err != nil { return err }
+        mbr, err := s.memberRepo.Get(ctx, a.Conv.ID, d)
+        if err != nil { return err }
+        if mbr == nil { continue }
+        if mbr.Role == m.RoleOwner {
+            if a.Member.Role != m.RoleOwner { return model.ErrConvForbidden }
+            if owners <= 1 { return model.ErrConvLastOwner }
+            owners--
+        }
+        removed = append(removed, d)
+    }
+    if len(removed) == 0 { return nil }
+
+    // a conversation that never got a key has nothing to rotate
+    if a.Conv.Encrypted && a.Conv.Epoch > 0 {
+        if rotate == nil { return model.ErrKeyRotationRequired }
+        rotate.Reason = RotateReasonMemberRemoved
+        _, err := s.keys.rotate(ctx, a, rotate, removed)
+        return err
+    }
+    for _, d := range removed {
+        if err := s.memberRepo.Remove(ctx, a.Conv.ID, d); err != nil { return err }
     }
     return nil
 }
-
-func (s *ConversationService) KeyRotate(ctx context.Context, convID string) error {
-    return s.convRepo.IncEpoch(ctx, convID)
-}
\ No newline at end of file
@@ -0,0 +1,141 @@
+package service
+
+import (
+    "context"
+    "errors"
+
+    "github.com/peers-touch/peers-touch/station/frame/touch/message/e2ee"
+    "github.com/peers-touch/peers-touch/station/frame/touch/message/repo"
+    "github.com/peers-touch/peers-touch/station/frame/touch/message/stream"
+    "github.com/peers-touch/peers-touch/station/frame/touch/model"
+    m "github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+)
+
+// Rotation reasons recorded on key epochs.
+const (
+    RotateReasonManual        = "manual"
+    RotateReasonMemberRemoved = "member_removed"
+)
+
+// KeyService runs the group key schedule of encrypted conversations. Keys are generated
+// and wrapped by clients, see package e2ee; the service checks every member gets a wrap
+// and moves the epoch atomically.
+type KeyService struct {
+    keyRepo    *repo.KeyEpochRepo
+    didKeyRepo *repo.DIDKeyRepo
+    memberRepo *repo.MemberRepo
+    hub        *stream.Hub
+}
+
+func NewKeyService() *KeyService {
+    return &KeyService{keyRepo: repo.NewKeyEpochRepo(), didKeyRepo: repo.NewDIDKeyRepo(), memberRepo: repo.NewMemberRepo(), hub: stream.DefaultHub()}
+}
+
+// RotateReq carries the next epoch with the group key wrapped for every member, keyed by
+// member DID.
+type RotateReq struct {
+    Epoch  int               `json:"epoch"`
+    Alg    string            `json:"alg"`
+    Wraps  map[string]string `json:"wraps"`
+    Reason string            `json:"-"`
+}
+
+// EpochKey is a key epoch as seen by one member.
+type EpochKey struct {
+    Epoch      int    `json:"epoch"`
+    Alg        string `json:"alg"`
+    KeyMetaCID string `json:"key_meta_cid"`
+    WrappedKey string `json:"wrapped_key"`
+}
+
+// PublishKey sets the public key did receives wrapped keys with.
+func (s *KeyService) PublishKey(ctx context.Context, did, alg, pub string) (*m.DIDKey, error) {
+    if alg == "" { alg = e2ee.Alg }
+    if alg != e2ee.Alg { return nil, model.ErrKeyUnsupportedAlg }
+    if err := e2ee.ValidatePublicKey(pub); err != nil { return nil, model.ErrKeyInvalidPublicKey }
+    k := &m.DIDKey{DID: did, Alg: alg, PublicKey: pub}
+    if err := s.didKeyRepo.Put(ctx, k); err != nil { return nil, err }
+    return k, nil
+}
+
+// Keys returns the published keys of dids, the ones without a key are left out.
+func (s *KeyService) Keys(ctx context.Context, dids []string) ([]*m.DIDKey, error) {
+    return s.didKeyRepo.List(ctx, dids)
+}
+
+// MemberKeys returns the published keys of the conversation members.
+func (s *KeyService) MemberKeys(ctx context.Context, a *Access) ([]*m.DIDKey, error) {
+    members, err := s.memberRepo.List(ctx, a.Conv.ID)
+    if err != nil { return nil, err }
+    dids := make([]string, len(members))
+    for i, mbr := range members { dids[i] = mbr.DID }
+    return s.didKeyRepo.List(ctx, dids)
+}
+
+// Rotate moves the conversation to the next epoch.
+func (s *KeyService) Rotate(ctx context.Context, a *Access, req *RotateReq) (*m.KeyEpoch, error) {
+    if req.Reason == "" { req.Reason = RotateReasonManual }
+    return s.rotate(ctx, a, req, nil)
+}
+
+// Epoch returns the key epoch with the wrap of the caller, the current one if epoch is 0.
+func (s *KeyService) Epoch(ctx context.Context, a *Access, epoch int) (*EpochKey, error) {
+    if !a.Conv.Encrypted { return nil, model.ErrConvNotEncrypted }
+    if epoch <= 0 { epoch = a.Conv.Epoch }
+    k, err := s.keyRepo.Get(ctx, a.Conv.ID, epoch)
+    if err != nil { return nil, err }
+    if k == nil { return nil, model.ErrKeyEpochNotFound }
+    w, err := s.keyRepo.GetWrap(ctx, a.Conv.ID, epoch, a.Member.DID)
+    if err != nil { return nil, err }
+    // members only get the epochs they were wrapped for, e.g. not the ones before they joined
+    if w == nil { return nil, model.ErrKeyEpochNotFound }
+    return &EpochKey{Epoch: k.Epoch, Alg: k.Alg, KeyMetaCID: k.KeyMetaCID, WrappedKey: w.WrappedKey}, nil
+}
+
+// rotate stores the next epoch, removing the members removeDIDs in the same transaction.
+// The wraps have to cover exactly the members that remain.
+func (s *KeyService) rotate(ctx context.Context, a *Access, req *RotateReq, removeDIDs []string) (*m.KeyEpoch, error) {
+    if !a.Conv.Encrypted { return nil, model.ErrConvNotEncrypted }
+    if req.Alg == "" { req.Alg = e2ee.Alg }
+    if req.Alg != e2ee.Alg { return nil, model.ErrKeyUnsupportedAlg }
+    if req.Epoch != a.Conv.Epoch+1 { return nil, model.ErrKeyEpochStale }
+
+    members, err := s.memberRepo.List(ctx, a.Conv.ID)
+    if err != nil { return nil, err }
+    removed := make(map[string]struct{}, len(removeDIDs))
+    for _, d := range removeDIDs { removed[d] = struct{}{} }
+    remaining := make([]string, 0, len(members))
+    for _, mbr := range members {
+        if _, ok := removed[mbr.DID]; !ok { remaining = append(remaining, mbr.DID) }
+    }
+    if err := checkWraps(req.Wraps, remaining); err != nil { return nil, err }
+
+    k := &m.KeyEpoch{ConvID: a.Conv.ID, Epoch: req.Epoch, Alg: req.Alg, KeyMetaCID: e2ee.KeyMetaCID(req.Alg, req.Epoch, req.Wraps), RotatedBy: a.Member.DID, Reason: req.Reason}
+    wraps := make([]*m.KeyWrap, 0, len(remaining))
+    for _, d := range remaining { wraps = append(wraps, &m.KeyWrap{ConvID: a.Conv.ID, Epoch: req.Epoch, MemberDID: d, WrappedKey: req.Wraps[d]}) }
+    if err := s.keyRepo.Rotate(ctx, a.Conv.Epoch, k, wraps, removeDIDs); err != nil {
+        if errors.Is(err, repo.ErrEpochConflict) { return nil, model.ErrKeyEpochStale }
+        return nil, err
+    }
+    a.Conv.Epoch = k.Epoch
+    s.hub.Publish(&stream.Event{ConvID: a.Conv.ConvID, Type: stream.EventKey, Data: k})
+    return k, nil
+}
+
+// addWraps gives members joining an encrypted conversation the current epoch key.
+func (s *KeyService) addWraps(ctx context.Context, a *Access, dids []string, wraps map[string]string) error {
+    if a.Conv.Epoch == 0 { return nil }
+    if err := checkWraps(wraps, dids); err != nil { return err }
+    list := make([]*m.KeyWrap, 0, len(dids))
+    for _, d := range dids { list = append(list, &m.KeyWrap{ConvID: a.Conv.ID, Epoch: a.Conv.Epoch, MemberDID: d, WrappedKey: wraps[d]}) }
+    return s.keyRepo.AddWraps(ctx, list)
+}
+
+// checkWraps makes sure there is exactly one non empty wrap per did.
+func checkWraps(wraps map[string]string, dids []string) error {
+    if len(wraps) != len(dids) { return model.ErrKeyWrapsMismatch }
+    for _, d := range dids {
+        if wraps[d] == "" { return model.ErrKeyWrapsMismatch }
+    }
+    return nil
+}
@@ -0,0 +1,27 @@
+package service
+
+import (
+    "context"
+    "testing"
+
+    "github.com/peers-touch/peers-touch/station/frame/touch/internal/storetest"
+    m "github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+)
+
+func TestMain(m *testing.M) { storetest.Main(m) }
+
+// newTestConv creates a plaintext conversation owned by owner with the members.
+func newTestConv(t *testing.T, convID, owner string, members ...string) *m.Conversation {
+    t.Helper()
+    conv, err := NewConversationService().Create(context.Background(), &CreateConvReq{ConvID: convID, Type: "group", Title: convID, OwnerDID: owner, Members: members})
+    if err != nil { t.Fatal(err) }
+    return conv
+}
+
+// appendTestMessage appends a text message of sender at ts.
+func appendTestMessage(t *testing.T, convID, sender string, ts int64, body string) *m.Message {
+    t.Helper()
+    msg, err := NewMessageService().Append(context.Background(), &AppendReq{ConvID: convID, SenderDID: sender, TS: ts, Type: "text", Body: body})
+    if err != nil { t.Fatal(err) }
+    return msg
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+    "context"
+    "time"
+
+    cfg "github.com/peers-touch/peers-touch/station/frame/core/config"
+    log "github.com/peers-touch/peers-touch/station/frame/core/logger"
+    "github.com/peers-touch/peers-touch/station/frame/core/store"
+    "github.com/peers-touch/peers-touch/station/frame/touch/message/repo"
+    "github.com/peers-touch/peers-touch/station/frame/touch/message/stream"
+    m "github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+    "github.com/peers-touch/peers-touch/station/frame/touch/util"
+    "gorm.io/gorm"
+)
+
+const (
+    defaultReaperInterval = time.Minute
+    // reaperBatch is the number of messages purged per transaction.
+    reaperBatch = 200
+)
+
+// Reaper hard deletes the messages whose TTL has passed, along with their revisions,
+// reactions, receipts and attachments.
+type Reaper struct {
+    msgRepo *repo.MessageRepo
+    atts    *AttachmentService
+    hub     *stream.Hub
+}
+
+func NewReaper() *Reaper { return &Reaper{msgRepo: repo.NewMessageRepo(), atts: NewAttachmentService(), hub: stream.DefaultHub()} }
+
+// Reap purges every message expired at now and returns how many it purged.
+func (r *Reaper) Reap(ctx context.Context, now time.Time) (int, error) {
+    total := 0
+    for {
+        msgs, err := r.msgRepo.ListExpired(ctx, now, reaperBatch)
+        if err != nil { return total, err }
+        if len(msgs) == 0 { return total, nil }
+
+        ulids := make([]string, len(msgs))
+        for i, msg := range msgs { ulids[i] = msg.ULID }
+        atts, err := r.msgRepo.Purge(ctx, ulids)
+        if err != nil { return total, err }
+        if len(atts) > 0 {
+            r.atts.DropBlobs(ctx, atts)
+            log.Infof(ctx, "reaper dropped %d attachments of expired messages", len(atts))
+        }
+
+        for _, msg := range msgs {
+            r.hub.Publish(&stream.Event{ConvID: msg.ConvID, Type: stream.EventDelete, Data: &m.Message{ULID: msg.ULID, ConvID: msg.ConvID, SenderDID: msg.SenderDID, TS: msg.TS, Deleted: true, DeletedAt: now, TTLAt: msg.TTLAt}})
+        }
+        total += len(msgs)
+        if len(msgs) < reaperBatch { return total, nil }
+    }
+}
+
+// startReaper runs the reaper every peers.touch.message.reaper.interval, 0 disables it.
+func startReaper(ctx context.Context, _ *gorm.DB) {
+    interval := cfg.Get("peers", "touch", "message", "reaper", "interval").Duration(defaultReaperInterval)
+    reaper := NewReaper()
+    util.RunEvery(context.WithoutCancel(ctx), "message-reaper", interval, func(ctx context.Context) error {
+        n, err := reaper.Reap(ctx, time.Now())
+        if n > 0 { log.Infof(ctx, "reaper purged %d expired messages", n) }
+        return err
+    })
+}
+
+func init() {
+    store.InitTableHooks(startReaper)
+}
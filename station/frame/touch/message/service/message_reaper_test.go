@@ -0,0 +1,180 @@
+package service
+
+import (
+    "bytes"
+    "context"
+    "crypto/sha256"
+    "errors"
+    "testing"
+    "time"
+
+    "github.com/peers-touch/peers-touch/station/frame/core/util/id"
+    "github.com/peers-touch/peers-touch/station/frame/touch/internal/storetest"
+    "github.com/peers-touch/peers-touch/station/frame/touch/message/blob"
+    "github.com/peers-touch/peers-touch/station/frame/touch/message/repo"
+    "github.com/peers-touch/peers-touch/station/frame/touch/message/search"
+    "github.com/peers-touch/peers-touch/station/frame/touch/message/stream"
+    "github.com/peers-touch/peers-touch/station/frame/touch/model"
+    m "github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+)
+
+func TestEditKeepsRevisions(t *testing.T) {
+    storetest.Reset(t)
+    ctx, svc := context.Background(), NewMessageService()
+    newTestConv(t, "conv-edit", "did:peers:alice", "did:peers:bob")
+    msg := appendTestMessage(t, "conv-edit", "did:peers:alice", 1700000000000, "first draft")
+
+    if _, err := svc.Edit(ctx, &EditReq{ConvID: "conv-edit", ULID: msg.ULID, EditorDID: "did:peers:bob", Body: "not mine"}); !errors.Is(err, model.ErrMessageNotSender) { t.Fatalf("edit by another member: %v", err) }
+    for i, body := range []string{"second draft", "final"} {
+        edited, err := svc.Edit(ctx, &EditReq{ConvID: "conv-edit", ULID: msg.ULID, EditorDID: "did:peers:alice", Body: body})
+        if err != nil { t.Fatal(err) }
+        if edited.Rev != i+1 || edited.Body != body || edited.EditedAt.IsZero() { t.Fatalf("edit %d = %+v", i, edited) }
+    }
+
+    revs, err := svc.Revisions(ctx, "conv-edit", msg.ULID)
+    if err != nil { t.Fatal(err) }
+    if len(revs) != 2 || revs[0].Rev != 0 || revs[0].Body != "first draft" || revs[1].Rev != 1 || revs[1].Body != "second draft" { t.Fatalf("revisions = %+v", revs) }
+    page, err := svc.List(ctx, &ListReq{ConvID: "conv-edit"})
+    if err != nil { t.Fatal(err) }
+    if len(page.Messages) != 1 || page.Messages[0].Rev != 2 || page.Messages[0].Body != "final" { t.Fatalf("listed = %+v", page.Messages) }
+    if hits := searchHits(t, "conv-edit", "draft"); len(hits) != 0 { t.Fatalf("former content found: %+v", hits) }
+    if hits := searchHits(t, "conv-edit", "final"); len(hits) != 1 || hits[0].ULID != msg.ULID { t.Fatalf("edited content hits = %+v", hits) }
+}
+
+func TestDeleteLeavesTombstone(t *testing.T) {
+    storetest.Reset(t)
+    ctx, svc := context.Background(), NewMessageService()
+    newTestConv(t, "conv-delete", "did:peers:alice", "did:peers:bob")
+    msg := appendTestMessage(t, "conv-delete", "did:peers:alice", 1700000000000, "regrettable words")
+    next := appendTestMessage(t, "conv-delete", "did:peers:bob", 1700000000001, "what words")
+    if _, err := svc.Edit(ctx, &EditReq{ConvID: "conv-delete", ULID: msg.ULID, EditorDID: "did:peers:alice", Body: "regrettable words, edited"}); err != nil { t.Fatal(err) }
+    if hits := searchHits(t, "conv-delete", "regrettable"); len(hits) != 1 { t.Fatalf("hits before delete = %+v", hits) }
+
+    if _, err := svc.Delete(ctx, "conv-delete", msg.ULID, "did:peers:bob"); !errors.Is(err, model.ErrMessageNotSender) { t.Fatalf("delete by another member: %v", err) }
+    deleted, err := svc.Delete(ctx, "conv-delete", msg.ULID, "did:peers:alice")
+    if err != nil { t.Fatal(err) }
+    if !deleted.Deleted || deleted.Body != "" || deleted.DeletedAt.IsZero() { t.Fatalf("deleted = %+v", deleted) }
+
+    page, err := svc.List(ctx, &ListReq{ConvID: "conv-delete"})
+    if err != nil { t.Fatal(err) }
+    if len(page.Messages) != 2 || page.Messages[1].ULID != next.ULID { t.Fatalf("listed = %+v", page.Messages) }
+    if tomb := page.Messages[0]; tomb.ULID != msg.ULID || !tomb.Deleted || tomb.Body != "" || tomb.ContentCID != "" { t.Fatalf("tombstone = %+v", tomb) }
+    if hits := searchHits(t, "conv-delete", "regrettable"); len(hits) != 0 { t.Fatalf("tombstone found: %+v", hits) }
+    if hits := searchHits(t, "conv-delete", "words"); len(hits) != 1 || hits[0].ULID != next.ULID { t.Fatalf("hits = %+v", hits) }
+    revs, err := svc.Revisions(ctx, "conv-delete", msg.ULID)
+    if err != nil || len(revs) != 0 { t.Fatalf("revisions of a tombstone = %+v, %v", revs, err) }
+    if _, err := svc.Edit(ctx, &EditReq{ConvID: "conv-delete", ULID: msg.ULID, EditorDID: "did:peers:alice", Body: "again"}); !errors.Is(err, model.ErrMessageDeleted) { t.Fatalf("edit of a tombstone: %v", err) }
+    if _, err := svc.Delete(ctx, "conv-delete", msg.ULID, "did:peers:alice"); err != nil { t.Fatalf("delete again: %v", err) }
+}
+
+func TestReapPurgesExpiredMessages(t *testing.T) {
+    rds := storetest.Reset(t)
+    ctx, svc := context.Background(), NewMessageService()
+    blob.Register(blob.StoreLocal, blob.NewLocalStore(t.TempDir()))
+    atts := &AttachmentService{attRepo: repo.NewAttachmentRepo(), uploadRepo: repo.NewBlobUploadRepo(), spool: blob.NewSpool(t.TempDir()), maxBytes: 1 << 20, uploadTTL: time.Hour}
+    reaper := &Reaper{msgRepo: repo.NewMessageRepo(), atts: atts, hub: stream.NewHub(1, 8)}
+    newTestConv(t, "conv-ttl", "did:peers:alice", "did:peers:bob")
+
+    now := time.Now()
+    // ULIDs are monotonic, messages of the future would push the ULIDs of later tests there
+    ts := now.Add(-time.Hour).UnixMilli()
+    appendTTL := func(body string, ttl time.Duration) *m.Message {
+        t.Helper()
+        msg, err := svc.Append(ctx, &AppendReq{ConvID: "conv-ttl", SenderDID: "did:peers:alice", TS: ts, Type: "text", Body: body, TTLMillis: now.Add(ttl).UnixMilli()})
+        if err != nil { t.Fatal(err) }
+        ts++
+        return msg
+    }
+    attach := func(convID, msgULID string, content []byte) *m.Attachment {
+        t.Helper()
+        sum := sha256.Sum256(content)
+        u, err := atts.CreateUpload(ctx, &UploadReq{ConvID: convID, MsgULID: msgULID, UploaderDID: "did:peers:alice", MIME: "image/png", Bytes: int64(len(content)), Digest: blob.FormatDigest(sum[:])})
+        if err != nil { t.Fatal(err) }
+        _, a, err := atts.UploadChunk(ctx, convID, u.ID, "did:peers:alice", 0, bytes.NewReader(content))
+        if err != nil { t.Fatal(err) }
+        return a
+    }
+
+    gone := appendTTL("self destructing", time.Minute)
+    kept := appendTTL("lasting a while", time.Hour)
+    forever := appendTestMessage(t, "conv-ttl", "did:peers:alice", ts, "forever")
+    if _, err := svc.Edit(ctx, &EditReq{ConvID: "conv-ttl", ULID: gone.ULID, EditorDID: "did:peers:alice", Body: "self destructing, edited"}); err != nil { t.Fatal(err) }
+    if _, err := NewReactionService().React(ctx, &ReactReq{ConvID: "conv-ttl", MsgULID: gone.ULID, MemberDID: "did:peers:bob", Emoji: "👍", Op: m.ReactionOpAdd}); err != nil { t.Fatal(err) }
+    if _, err := NewReactionService().React(ctx, &ReactReq{ConvID: "conv-ttl", MsgULID: kept.ULID, MemberDID: "did:peers:bob", Emoji: "👍", Op: m.ReactionOpAdd}); err != nil { t.Fatal(err) }
+    orphan := attach("conv-ttl", gone.ULID, []byte("only in the expired message"))
+    attach("conv-ttl", gone.ULID, []byte("forwarded elsewhere"))
+    // alice forwarded the picture to another conversation, which keeps its content
+    newTestConv(t, "conv-forward", "did:peers:alice", "did:peers:carol")
+    forwarded := appendTestMessage(t, "conv-forward", "did:peers:alice", ts, "look")
+    shared := attach("conv-forward", forwarded.ULID, []byte("forwarded elsewhere"))
+
+    // more expired messages than one batch purges
+    batch := make([]*m.Message, reaperBatch)
+    for i := range batch {
+        batch[i] = &m.Message{ULID: id.NewULID(time.UnixMilli(ts)), ConvID: "conv-ttl", SenderDID: "did:peers:bob", TS: ts, Type: "text", Body: "batch", TTLAt: now.Add(time.Second)}
+        ts++
+    }
+    if err := rds.CreateInBatches(batch, 100).Error; err != nil { t.Fatal(err) }
+
+    n, err := reaper.Reap(ctx, now.Add(2*time.Minute))
+    if err != nil { t.Fatal(err) }
+    if n != reaperBatch+1 { t.Fatalf("reaped %d, want %d", n, reaperBatch+1) }
+
+    page, err := svc.List(ctx, &ListReq{ConvID: "conv-ttl"})
+    if err != nil { t.Fatal(err) }
+    if len(page.Messages) != 2 || page.Messages[0].ULID != kept.ULID || page.Messages[1].ULID != forever.ULID { t.Fatalf("listed = %+v", page.Messages) }
+    if hits := searchHits(t, "conv-ttl", "destructing"); len(hits) != 0 { t.Fatalf("purged message found: %+v", hits) }
+    for _, model := range []interface{}{&m.Message{}, &m.MessageRevision{}, &m.Reaction{}, &m.Attachment{}} {
+        var count int64
+        if err := rds.Model(model).Where(map[string]interface{}{msgColumn(model): gone.ULID}).Count(&count).Error; err != nil { t.Fatal(err) }
+        if count != 0 { t.Fatalf("%T rows of the purged message left: %d", model, count) }
+    }
+    var reactions int64
+    if err := rds.Model(&m.Reaction{}).Where("msg_ulid = ?", kept.ULID).Count(&reactions).Error; err != nil || reactions != 1 { t.Fatalf("reactions of the kept message = %d, %v", reactions, err) }
+
+    open := func(a *m.Attachment) error {
+        rc, err := atts.Open(ctx, a, 0, -1)
+        if err == nil { rc.Close() }
+        return err
+    }
+    if err := open(orphan); !errors.Is(err, model.ErrAttachmentNotFound) { t.Fatalf("orphaned blob kept: %v", err) }
+    if err := open(shared); err != nil { t.Fatalf("blob of the forwarded message dropped: %v", err) }
+
+    if n, err := reaper.Reap(ctx, now.Add(2*time.Minute)); n != 0 || err != nil { t.Fatalf("reap again = %d, %v", n, err) }
+}
+
+func TestSearchLeavesOutExpiredMessages(t *testing.T) {
+    storetest.Reset(t)
+    ctx, svc := context.Background(), NewMessageService()
+    newTestConv(t, "conv-expired", "did:peers:alice", "did:peers:bob")
+    now := time.Now()
+    ts := now.Add(-time.Hour).UnixMilli()
+    expired, err := svc.Append(ctx, &AppendReq{ConvID: "conv-expired", SenderDID: "did:peers:alice", TS: ts, Type: "text", Body: "vanishing note", TTLMillis: now.Add(-time.Minute).UnixMilli()})
+    if err != nil { t.Fatal(err) }
+    live, err := svc.Append(ctx, &AppendReq{ConvID: "conv-expired", SenderDID: "did:peers:alice", TS: ts + 1, Type: "text", Body: "lasting note", TTLMillis: now.Add(time.Hour).UnixMilli()})
+    if err != nil { t.Fatal(err) }
+    kept := appendTestMessage(t, "conv-expired", "did:peers:alice", ts+2, "plain note")
+
+    // the reaper has not run, the expired message is still indexed
+    res, err := NewSearchService().Search(ctx, &search.Query{ConvID: "conv-expired", Text: "note"})
+    if err != nil { t.Fatal(err) }
+    if res.Total != 2 || len(res.Hits) != 2 { t.Fatalf("hits = %d %+v", res.Total, res.Hits) }
+    for _, h := range res.Hits {
+        if h.ULID == expired.ULID { t.Fatalf("expired message found: %+v", h) }
+        if h.ULID != live.ULID && h.ULID != kept.ULID { t.Fatalf("unknown hit: %+v", h) }
+    }
+}
+
+// msgColumn names the column holding the message ULID of model.
+func msgColumn(model interface{}) string {
+    if _, ok := model.(*m.Message); ok { return "ulid" }
+    return "msg_ulid"
+}
+
+// searchHits returns the search hits of text in the conversation.
+func searchHits(t *testing.T, convID, text string) []*search.Hit {
+    t.Helper()
+    res, err := NewSearchService().Search(context.Background(), &search.Query{ConvID: convID, Text: text})
+    if err != nil { t.Fatal(err) }
+    return res.Hits
+}
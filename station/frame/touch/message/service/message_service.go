@@ -2,17 +2,40 @@ package service
 
 import (
     "context"
+    "errors"
+    "sort"
+    "strings"
     "time"
 
+    "github.com/peers-touch/peers-touch/station/frame/core/util/id"
+    "github.com/peers-touch/peers-touch/station/frame/touch/message/cursor"
+    "github.com/peers-touch/peers-touch/station/frame/touch/model"
     m "github.com/peers-touch/peers-touch/station/frame/touch/model/db"
     "github.com/peers-touch/peers-touch/station/frame/touch/message/repo"
+    "github.com/peers-touch/peers-touch/station/frame/touch/message/stream"
+    "gorm.io/gorm"
+)
+
+const (
+    // streamReplayLimit caps how many persisted messages a resuming subscriber gets replayed.
+    streamReplayLimit = 500
+    defaultPageLimit  = 50
+    maxPageLimit      = 200
+    // maxULIDSkew is how far the time of a ULID the client chose may be off the message TS.
+    maxULIDSkew = time.Minute
 )
 
 type MessageService struct {
-    msgRepo *repo.MessageRepo
+    msgRepo   *repo.MessageRepo
+    convRepo  *repo.ConversationRepo
+    markRepo  *repo.WatermarkRepo
+    reactRepo *repo.ReactionRepo
+    hub       *stream.Hub
 }
 
-func NewMessageService() *MessageService { return &MessageService{msgRepo: repo.NewMessageRepo()} }
+func NewMessageService() *MessageService {
+    return &MessageService{msgRepo: repo.NewMessageRepo(), convRepo: repo.NewConversationRepo(), markRepo: repo.NewWatermarkRepo(), reactRepo: repo.NewReactionRepo(), hub: stream.DefaultHub()}
+}
 
 type AppendReq struct {
     ULID       string
@@ -23,16 +46,261 @@ type AppendReq struct {
     ParentID   string
     ThreadID   string
     ContentCID string
+    Body       string
     TTLMillis  int64
+    // Epoch is the key epoch the body is sealed with, 0 for plaintext conversations.
+    Epoch      int
 }
 
+// Append stores a message. A ULID the client chose has to be a valid one, without one the
+// server assigns it from the message TS.
 func (s *MessageService) Append(ctx context.Context, req *AppendReq) (*m.Message, error) {
-    msg := &m.Message{ULID: req.ULID, ConvID: req.ConvID, SenderDID: req.SenderDID, TS: req.TS, Type: m.MessageType(req.Type), ParentID: req.ParentID, ThreadID: req.ThreadID, ContentCID: req.ContentCID}
+    if req.ULID == "" {
+        req.ULID = id.NewULID(time.UnixMilli(req.TS))
+    } else if err := checkULID(req); err != nil {
+        return nil, err
+    }
+    if err := s.checkEpoch(ctx, req.ConvID, req.Epoch); err != nil { return nil, err }
+    msg := &m.Message{ULID: req.ULID, ConvID: req.ConvID, SenderDID: req.SenderDID, TS: req.TS, Type: m.MessageType(req.Type), ParentID: req.ParentID, ThreadID: req.ThreadID, ContentCID: req.ContentCID, Body: req.Body, Epoch: req.Epoch}
     if req.TTLMillis > 0 { msg.TTLAt = time.UnixMilli(req.TTLMillis) }
     if err := s.msgRepo.Append(ctx, msg); err != nil { return nil, err }
+    s.hub.Publish(messageEvent(msg))
+    return msg, nil
+}
+
+// ListReq selects a page of messages. At most one of Before, After and Around is set, they
+// are cursors of former pages; Around also takes a message ULID. Without any of them the
+// page starts at the first message. ThreadID and ParentID narrow the listing to a thread.
+type ListReq struct {
+    ConvID   string
+    ThreadID string
+    ParentID string
+    Before   string
+    After    string
+    Around   string
+    Limit    int
+}
+
+// MessagePage is a page of messages in (TS, ULID) order. Before and After are the cursors
+// of the neighbouring pages, HasMore tells whether there are messages in the direction paged
+// in. An Around page tells both directions apart.
+type MessagePage struct {
+    Messages      []*m.Message `json:"messages"`
+    HasMore       bool         `json:"has_more"`
+    HasMoreBefore bool         `json:"has_more_before,omitempty"`
+    HasMoreAfter  bool         `json:"has_more_after,omitempty"`
+    Before        string       `json:"before,omitempty"`
+    After         string       `json:"after,omitempty"`
+}
+
+func (s *MessageService) List(ctx context.Context, req *ListReq) (*MessagePage, error) {
+    limit := req.Limit
+    if limit <= 0 { limit = defaultPageLimit }
+    if limit > maxPageLimit { limit = maxPageLimit }
+    if (req.Before != "" && req.After != "") || (req.Around != "" && req.Before+req.After != "") { return nil, model.ErrMessageInvalidCursor }
+    f := &repo.MessageFilter{ConvID: req.ConvID, ThreadID: req.ThreadID, ParentID: req.ParentID}
+
+    page := &MessagePage{}
+    switch {
+    case req.Around != "":
+        c, err := s.aroundCursor(ctx, req.ConvID, req.Around)
+        if err != nil { return nil, err }
+        older, err := s.msgRepo.ListBefore(ctx, f, &c, limit/2+1)
+        if err != nil { return nil, err }
+        if page.HasMoreBefore = len(older) > limit/2; page.HasMoreBefore { older = older[1:] }
+        newer, err := s.msgRepo.ListAfter(ctx, f, &c, true, limit-len(older)+1)
+        if err != nil { return nil, err }
+        if page.HasMoreAfter = len(newer) > limit-len(older); page.HasMoreAfter { newer = newer[:len(newer)-1] }
+        page.Messages = append(older, newer...)
+        page.HasMore = page.HasMoreBefore || page.HasMoreAfter
+    case req.Before != "":
+        c, err := parseCursor(req.Before)
+        if err != nil { return nil, err }
+        list, err := s.msgRepo.ListBefore(ctx, f, &c, limit+1)
+        if err != nil { return nil, err }
+        if page.HasMore = len(list) > limit; page.HasMore { list = list[1:] }
+        page.Messages = list
+    default:
+        var after *cursor.Cursor
+        if req.After != "" {
+            c, err := parseCursor(req.After)
+            if err != nil { return nil, err }
+            after = &c
+        }
+        list, err := s.msgRepo.ListAfter(ctx, f, after, false, limit+1)
+        if err != nil { return nil, err }
+        if page.HasMore = len(list) > limit; page.HasMore { list = list[:limit] }
+        page.Messages = list
+    }
+
+    if n := len(page.Messages); n > 0 {
+        page.Before = messageCursor(page.Messages[0]).Encode()
+        page.After = messageCursor(page.Messages[n-1]).Encode()
+    } else {
+        // an empty page keeps the position it was asked for, so a client polls from there
+        page.Before, page.After = req.Before, req.After
+    }
+    return page, nil
+}
+
+type EditReq struct {
+    ConvID     string
+    ULID       string
+    EditorDID  string
+    Body       string
+    ContentCID string
+    Epoch      int
+}
+
+// Edit replaces the content of a message by its sender. The former content is kept as a
+// revision, see Revisions.
+func (s *MessageService) Edit(ctx context.Context, req *EditReq) (*m.Message, error) {
+    if req.Body == "" && req.ContentCID == "" { return nil, model.ErrMessageEmpty }
+    msg, err := s.get(ctx, req.ConvID, req.ULID)
+    if err != nil { return nil, err }
+    if msg.Deleted { return nil, model.ErrMessageDeleted }
+    if msg.SenderDID != req.EditorDID { return nil, model.ErrMessageNotSender }
+    if err := s.checkEpoch(ctx, req.ConvID, req.Epoch); err != nil { return nil, err }
+
+    now := time.Now()
+    edited := msg.EditedAt
+    if edited.IsZero() { edited = msg.CreatedAt }
+    rev := &m.MessageRevision{MsgULID: msg.ULID, Rev: msg.Rev, ConvID: msg.ConvID, ContentCID: msg.ContentCID, Body: msg.Body, Epoch: msg.Epoch, EditedAt: edited}
+    msg.Rev, msg.EditedAt, msg.Body, msg.ContentCID, msg.Epoch = msg.Rev+1, now, req.Body, req.ContentCID, req.Epoch
+    if err := s.msgRepo.Edit(ctx, msg, rev); err != nil {
+        if errors.Is(err, repo.ErrRevisionConflict) { return nil, model.ErrMessageEditConflict }
+        return nil, err
+    }
+    s.hub.Publish(&stream.Event{ConvID: msg.ConvID, Type: stream.EventEdit, Data: msg})
+    return msg, nil
+}
+
+// Delete turns a message into a tombstone. Deleting a tombstone again is a no-op.
+func (s *MessageService) Delete(ctx context.Context, convID, ulid, actorDID string) (*m.Message, error) {
+    msg, err := s.get(ctx, convID, ulid)
+    if err != nil { return nil, err }
+    if msg.SenderDID != actorDID { return nil, model.ErrMessageNotSender }
+    if msg.Deleted { return msg, nil }
+
+    msg.Deleted, msg.DeletedAt, msg.Body, msg.ContentCID = true, time.Now(), "", ""
+    if err := s.msgRepo.SoftDelete(ctx, msg); err != nil { return nil, err }
+    s.hub.Publish(&stream.Event{ConvID: msg.ConvID, Type: stream.EventDelete, Data: msg})
     return msg, nil
 }
 
-func (s *MessageService) List(ctx context.Context, convID string, afterTS int64, limit int) ([]*m.Message, error) {
-    return s.msgRepo.List(ctx, convID, afterTS, limit)
+// Revisions returns the former contents of a message, oldest first.
+func (s *MessageService) Revisions(ctx context.Context, convID, ulid string) ([]*m.MessageRevision, error) {
+    msg, err := s.get(ctx, convID, ulid)
+    if err != nil { return nil, err }
+    return s.msgRepo.ListRevisions(ctx, msg.ULID)
+}
+
+// Subscribe registers a live subscriber of the conversation and returns the events it
+// missed after cursor. What the hub no longer buffers is replayed from the RDS: the
+// messages after cursor, then the current state of what changed since its time, edits
+// and deletions of older messages, reactions and receipts. Those carry new event ids.
+// Messages the reaper purged meanwhile and key rotations are not replayed, clients fetch
+// the current key epoch when they reconnect.
+func (s *MessageService) Subscribe(ctx context.Context, convID string, cursor string) (*stream.Subscription, []*stream.Event, error) {
+    sub, backlog, covered := s.hub.Subscribe(convID, cursor)
+    if covered { return sub, backlog, nil }
+
+    msgs, err := s.msgRepo.ListAfterULID(ctx, convID, cursor, streamReplayLimit)
+    if err != nil { sub.Close(); return nil, nil, err }
+    changes, err := s.changesSince(ctx, convID, cursor)
+    if err != nil { sub.Close(); return nil, nil, err }
+
+    events := make([]*stream.Event, 0, len(msgs)+len(changes)+len(backlog))
+    replayed := make(map[string]struct{}, len(msgs))
+    for _, msg := range msgs {
+        e := messageEvent(msg)
+        events = append(events, e)
+        replayed[e.ID] = struct{}{}
+    }
+    for _, e := range backlog {
+        if _, ok := replayed[e.ID]; !ok { events = append(events, e) }
+    }
+    sort.SliceStable(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+    // changes carry the current state, repeating the buffered ones is harmless
+    return sub, append(events, changes...), nil
+}
+
+// changesSince returns the events of the edits and deletions of the messages up to the
+// cursor, the reactions and the receipts that happened after the time of the cursor.
+func (s *MessageService) changesSince(ctx context.Context, convID string, cursor string) ([]*stream.Event, error) {
+    since, err := id.ULIDTime(cursor)
+    if err != nil { return nil, nil }
+    msgs, err := s.msgRepo.ListChangedUpTo(ctx, convID, cursor, since, streamReplayLimit)
+    if err != nil { return nil, err }
+    reactions, err := s.reactRepo.ListSince(ctx, convID, since, streamReplayLimit)
+    if err != nil { return nil, err }
+    marks, err := s.markRepo.ListSince(ctx, convID, since)
+    if err != nil { return nil, err }
+
+    events := make([]*stream.Event, 0, len(msgs)+len(reactions)+len(marks))
+    for _, msg := range msgs {
+        typ := stream.EventEdit
+        if msg.Deleted { typ = stream.EventDelete }
+        events = append(events, &stream.Event{ID: id.NextULID(), ConvID: convID, Type: typ, Data: msg})
+    }
+    for _, rc := range reactions { events = append(events, &stream.Event{ID: id.NextULID(), ConvID: convID, Type: stream.EventReaction, Data: rc}) }
+    for _, w := range marks { events = append(events, &stream.Event{ID: id.NextULID(), ConvID: convID, Type: stream.EventReceipt, Data: w}) }
+    return events, nil
+}
+
+// aroundCursor resolves the position of an Around page, a cursor or a message ULID.
+func (s *MessageService) aroundCursor(ctx context.Context, convID, around string) (cursor.Cursor, error) {
+    if c, err := cursor.Parse(around); err == nil { return c, nil }
+    msg, err := s.get(ctx, convID, around)
+    if err != nil { return cursor.Cursor{}, err }
+    return messageCursor(msg), nil
+}
+
+// checkULID uppercases the ULID the client chose and makes sure its time is close to the
+// message TS. The stream replay and the watermarks compare ULIDs as strings, a lowercase
+// one or one from the future would sort after every later message.
+func checkULID(req *AppendReq) error {
+    if !id.IsULID(req.ULID) { return model.ErrMessageInvalidULID }
+    req.ULID = strings.ToUpper(req.ULID)
+    t, err := id.ULIDTime(req.ULID)
+    if err != nil { return model.ErrMessageInvalidULID }
+    if d := t.Sub(time.UnixMilli(req.TS)); d > maxULIDSkew || d < -maxULIDSkew { return model.ErrMessageULIDSkew }
+    return nil
+}
+
+func parseCursor(s string) (cursor.Cursor, error) {
+    c, err := cursor.Parse(s)
+    if err != nil { return c, model.ErrMessageInvalidCursor }
+    return c, nil
+}
+
+func messageCursor(msg *m.Message) cursor.Cursor {
+    return cursor.Cursor{TS: msg.TS, ULID: msg.ULID}
+}
+
+// get loads a live message, messages past their TTL are gone even before the reaper
+// purges them.
+func (s *MessageService) get(ctx context.Context, convID, ulid string) (*m.Message, error) {
+    msg, err := s.msgRepo.Get(ctx, convID, ulid)
+    if errors.Is(err, gorm.ErrRecordNotFound) { return nil, model.ErrMessageNotFound }
+    if err != nil { return nil, err }
+    if msg.Expired(time.Now()) { return nil, model.ErrMessageNotFound }
+    return msg, nil
+}
+
+// checkEpoch makes sure content is sealed with the current key of an encrypted conversation,
+// and is plaintext otherwise. Senders that missed a rotation get ErrMessageEpoch and reseal.
+func (s *MessageService) checkEpoch(ctx context.Context, convID string, epoch int) error {
+    conv, err := s.convRepo.GetByConvID(ctx, convID)
+    if err != nil { return err }
+    if conv.Encrypted {
+        if epoch < 1 || epoch != conv.Epoch { return model.ErrMessageEpoch }
+        return nil
+    }
+    if epoch != 0 { return model.ErrMessageEpoch }
+    return nil
+}
+
+func messageEvent(msg *m.Message) *stream.Event {
+    return &stream.Event{ID: msg.ULID, ConvID: msg.ConvID, Type: stream.EventMessage, Data: msg}
 }
\ No newline at end of file
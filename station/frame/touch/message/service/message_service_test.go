@@ -0,0 +1,173 @@
+package service
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/peers-touch/peers-touch/station/frame/touch/internal/storetest"
+    "github.com/peers-touch/peers-touch/station/frame/touch/model"
+    m "github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+    "github.com/peers-touch/peers-touch/station/frame/touch/message/stream"
+)
+
+// clientULID builds the ULID a client would choose at t, n sets its entropy part. Unlike
+// id.NewULID it leaves the monotonic state of the server alone.
+func clientULID(t time.Time, n int) string {
+    const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+    ms := uint64(t.UnixMilli())
+    b := make([]byte, 10)
+    for i := len(b) - 1; i >= 0; i-- { b[i] = crockford[ms&31]; ms >>= 5 }
+    return string(b) + fmt.Sprintf("%016d", n)
+}
+
+func TestAppendChecksClientULID(t *testing.T) {
+    storetest.Reset(t)
+    ctx, svc := context.Background(), NewMessageService()
+    newTestConv(t, "conv-ulid", "did:peers:alice", "did:peers:bob")
+    now := time.Now()
+    appendULID := func(ulid string) (*m.Message, error) {
+        return svc.Append(ctx, &AppendReq{ULID: ulid, ConvID: "conv-ulid", SenderDID: "did:peers:alice", TS: now.UnixMilli(), Type: "text", Body: "hi"})
+    }
+
+    lower := clientULID(now.Add(-time.Second), 1)
+    msg, err := appendULID(strings.ToLower(lower))
+    if err != nil { t.Fatal(err) }
+    if msg.ULID != lower { t.Fatalf("stored ulid %s, want %s", msg.ULID, lower) }
+    if _, err := svc.get(ctx, "conv-ulid", lower); err != nil { t.Fatalf("get by the uppercase ulid: %v", err) }
+
+    for _, tc := range []struct {
+        name string
+        ulid string
+        want error
+    }{
+        {"close ahead", clientULID(now.Add(maxULIDSkew/2), 2), nil},
+        {"close behind", clientULID(now.Add(-maxULIDSkew/2), 3), nil},
+        {"far future", clientULID(now.Add(time.Hour), 4), model.ErrMessageULIDSkew},
+        {"far past", clientULID(now.Add(-time.Hour), 5), model.ErrMessageULIDSkew},
+        {"not a ulid", "not-a-ulid", model.ErrMessageInvalidULID},
+        {"beyond the ulid range", "8" + lower[1:], model.ErrMessageInvalidULID},
+    } {
+        if _, err := appendULID(tc.ulid); !errors.Is(err, tc.want) { t.Errorf("%s: err = %v, want %v", tc.name, err, tc.want) }
+    }
+}
+
+func TestListPages(t *testing.T) {
+    storetest.Reset(t)
+    ctx, svc := context.Background(), NewMessageService()
+    newTestConv(t, "conv-page", "did:peers:alice", "did:peers:bob")
+    base := time.Now().Add(-10 * time.Second)
+    // in (TS, ULID) order: three messages share a millisecond, and a later one carries a
+    // ULID lower than theirs
+    want := []*AppendReq{
+        {ULID: clientULID(base, 1), TS: base.UnixMilli()},
+        {ULID: clientULID(base, 2), TS: base.UnixMilli()},
+        {ULID: clientULID(base, 3), TS: base.UnixMilli()},
+        {ULID: clientULID(base, 0), TS: base.UnixMilli() + 1},
+        {ULID: clientULID(base.Add(time.Millisecond), 5), TS: base.UnixMilli() + 1},
+        {ULID: clientULID(base.Add(2*time.Millisecond), 1), TS: base.UnixMilli() + 2},
+        {ULID: clientULID(base.Add(2*time.Millisecond), 2), TS: base.UnixMilli() + 2},
+    }
+    for _, i := range []int{6, 2, 0, 4, 1, 5, 3} {
+        req := *want[i]
+        req.ConvID, req.SenderDID, req.Type, req.Body = "conv-page", "did:peers:alice", "text", fmt.Sprint("message ", i)
+        if _, err := svc.Append(ctx, &req); err != nil { t.Fatal(err) }
+    }
+    cursorOf := func(i int) string { return messageCursor(&m.Message{TS: want[i].TS, ULID: want[i].ULID}).Encode() }
+
+    list := func(req *ListReq) *MessagePage {
+        t.Helper()
+        req.ConvID = "conv-page"
+        page, err := svc.List(ctx, req)
+        if err != nil { t.Fatal(err) }
+        return page
+    }
+    check := func(name string, page *MessagePage, from, to int, hasMore bool) {
+        t.Helper()
+        var got, exp []string
+        for _, msg := range page.Messages { got = append(got, msg.ULID) }
+        for i := from; i < to; i++ { exp = append(exp, want[i].ULID) }
+        if strings.Join(got, ",") != strings.Join(exp, ",") { t.Fatalf("%s: messages %v, want %v", name, got, exp) }
+        if page.HasMore != hasMore { t.Fatalf("%s: has more = %v, want %v", name, page.HasMore, hasMore) }
+        if to > from && (page.Before != cursorOf(from) || page.After != cursorOf(to-1)) { t.Fatalf("%s: cursors %s %s", name, page.Before, page.After) }
+    }
+
+    check("all", list(&ListReq{}), 0, 7, false)
+    check("exactly the limit", list(&ListReq{Limit: 7}), 0, 7, false)
+    check("one short of the limit", list(&ListReq{Limit: 6}), 0, 6, true)
+
+    page := list(&ListReq{Limit: 3})
+    check("first page", page, 0, 3, true)
+    page = list(&ListReq{After: page.After, Limit: 3})
+    check("second page", page, 3, 6, true)
+    page = list(&ListReq{After: page.After, Limit: 3})
+    check("last page", page, 6, 7, false)
+    last := page.After
+    page = list(&ListReq{After: last, Limit: 3})
+    check("after the last page", page, 0, 0, false)
+    if page.After != last { t.Fatalf("empty page moved to %s", page.After) }
+
+    page = list(&ListReq{Before: cursorOf(6), Limit: 3})
+    check("page before", page, 3, 6, true)
+    page = list(&ListReq{Before: page.Before, Limit: 3})
+    check("first page before", page, 0, 3, false)
+
+    page = list(&ListReq{Around: want[3].ULID, Limit: 4})
+    check("around a message", page, 1, 5, true)
+    if !page.HasMoreBefore || !page.HasMoreAfter { t.Fatalf("around a message: has more %v before, %v after", page.HasMoreBefore, page.HasMoreAfter) }
+    page = list(&ListReq{Around: cursorOf(0), Limit: 4})
+    check("around the first cursor", page, 0, 4, true)
+    if page.HasMoreBefore || !page.HasMoreAfter { t.Fatalf("around the first cursor: has more %v before, %v after", page.HasMoreBefore, page.HasMoreAfter) }
+    page = list(&ListReq{Around: want[3].ULID, Limit: 7})
+    check("around everything", page, 0, 7, false)
+
+    for _, req := range []*ListReq{{Before: cursorOf(1), After: cursorOf(0)}, {Around: cursorOf(1), After: cursorOf(0)}, {Before: "garbage"}} {
+        req.ConvID = "conv-page"
+        if _, err := svc.List(ctx, req); !errors.Is(err, model.ErrMessageInvalidCursor) { t.Errorf("list %+v: err = %v", req, err) }
+    }
+    if _, err := svc.List(ctx, &ListReq{ConvID: "conv-page", Around: clientULID(base, 9)}); !errors.Is(err, model.ErrMessageNotFound) { t.Errorf("around an unknown message: %v", err) }
+}
+
+func TestSubscribeReplaysChangesSinceCursor(t *testing.T) {
+    storetest.Reset(t)
+    ctx := context.Background()
+    newTestConv(t, "conv-resume", "did:peers:alice", "did:peers:bob")
+    // the messages are older than the changes below, so the cursor time precedes them
+    ts := time.Now().Add(-time.Hour).UnixMilli()
+    m0 := appendTestMessage(t, "conv-resume", "did:peers:alice", ts, "first")
+    m1 := appendTestMessage(t, "conv-resume", "did:peers:alice", ts+1, "second")
+    m2 := appendTestMessage(t, "conv-resume", "did:peers:bob", ts+2, "third")
+
+    svc := NewMessageService()
+    if _, err := svc.Edit(ctx, &EditReq{ConvID: "conv-resume", ULID: m1.ULID, EditorDID: "did:peers:alice", Body: "second, edited"}); err != nil { t.Fatal(err) }
+    if _, err := svc.Delete(ctx, "conv-resume", m0.ULID, "did:peers:alice"); err != nil { t.Fatal(err) }
+    if _, err := NewReactionService().React(ctx, &ReactReq{ConvID: "conv-resume", MsgULID: m1.ULID, MemberDID: "did:peers:bob", Emoji: "👍", Op: m.ReactionOpAdd}); err != nil { t.Fatal(err) }
+    if _, err := NewReceiptService().Post(ctx, &PostReceiptReq{ConvID: "conv-resume", MsgULID: m1.ULID, MemberDID: "did:peers:bob", Read: true}); err != nil { t.Fatal(err) }
+
+    // a fresh hub buffers nothing, everything comes from the RDS
+    svc.hub = stream.NewHub(1, 8)
+    sub, events, err := svc.Subscribe(ctx, "conv-resume", m1.ULID)
+    if err != nil { t.Fatal(err) }
+    defer sub.Close()
+
+    want := []stream.EventType{stream.EventMessage, stream.EventEdit, stream.EventDelete, stream.EventReaction, stream.EventReceipt}
+    if len(events) != len(want) { t.Fatalf("got %d events, want %d: %+v", len(events), len(want), events) }
+    for i, e := range events {
+        if e.Type != want[i] { t.Fatalf("event %d is %s, want %s", i, e.Type, want[i]) }
+        if e.ConvID != "conv-resume" || e.ID == "" { t.Fatalf("event %d = %+v", i, e) }
+    }
+    if msg := events[0].Data.(*m.Message); msg.ULID != m2.ULID { t.Fatalf("replayed message %s, want %s", msg.ULID, m2.ULID) }
+    if msg := events[1].Data.(*m.Message); msg.ULID != m1.ULID || msg.Body != "second, edited" { t.Fatalf("edit = %+v", msg) }
+    if msg := events[2].Data.(*m.Message); msg.ULID != m0.ULID || !msg.Deleted { t.Fatalf("delete = %+v", msg) }
+    if w := events[4].Data.(*m.Watermark); w.MemberDID != "did:peers:bob" || w.ReadULID != m1.ULID { t.Fatalf("receipt = %+v", w) }
+
+    // without a ULID cursor there is no time to replay changes from
+    _, events, err = svc.Subscribe(ctx, "conv-resume", "not-a-ulid")
+    if err != nil { t.Fatal(err) }
+    for _, e := range events {
+        if e.Type != stream.EventMessage { t.Fatalf("unexpected %s event for a cursor without time", e.Type) }
+    }
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+    "context"
+    "time"
+
+    "github.com/peers-touch/peers-touch/station/frame/touch/message/repo"
+    "github.com/peers-touch/peers-touch/station/frame/touch/message/stream"
+    "github.com/peers-touch/peers-touch/station/frame/touch/model"
+    m "github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+)
+
+// maxEmojiBytes fits the longest emoji ZWJ sequences.
+const maxEmojiBytes = 64
+
+type ReactionService struct {
+    msgSvc    *MessageService
+    reactRepo *repo.ReactionRepo
+    hub       *stream.Hub
+}
+
+func NewReactionService() *ReactionService {
+    return &ReactionService{msgSvc: NewMessageService(), reactRepo: repo.NewReactionRepo(), hub: stream.DefaultHub()}
+}
+
+type ReactReq struct {
+    ConvID    string
+    MsgULID   string
+    MemberDID string
+    Emoji     string
+    Op        string
+    // TS orders the ops of a member, it defaults to now in unix millis.
+    TS int64
+}
+
+// ReactionResult is the state of the message reactions after an op.
+type ReactionResult struct {
+    Applied bool               `json:"applied"`
+    Counts  []*repo.EmojiCount `json:"counts"`
+}
+
+// React applies an add or remove op. Ops older than the one stored for the same member
+// and emoji are ignored, and so are repeated ones.
+func (s *ReactionService) React(ctx context.Context, req *ReactReq) (*ReactionResult, error) {
+    if req.Op != m.ReactionOpAdd && req.Op != m.ReactionOpRemove { return nil, model.ErrReactionInvalidOp }
+    if req.Emoji == "" || len(req.Emoji) > maxEmojiBytes { return nil, model.ErrReactionInvalidEmoji }
+    msg, err := s.msgSvc.get(ctx, req.ConvID, req.MsgULID)
+    if err != nil { return nil, err }
+    if msg.Deleted { return nil, model.ErrMessageDeleted }
+
+    ts := req.TS
+    if ts <= 0 { ts = time.Now().UnixMilli() }
+    rc := &m.Reaction{MsgULID: msg.ULID, MemberDID: req.MemberDID, Emoji: req.Emoji, Op: req.Op, TS: ts}
+    applied, err := s.reactRepo.Apply(ctx, rc)
+    if err != nil { return nil, err }
+    if applied { s.hub.Publish(&stream.Event{ConvID: msg.ConvID, Type: stream.EventReaction, Data: rc}) }
+
+    counts, err := s.reactRepo.Counts(ctx, msg.ULID, req.MemberDID)
+    if err != nil { return nil, err }
+    return &ReactionResult{Applied: applied, Counts: counts}, nil
+}
+
+// Counts returns the per emoji counts of a message seen by memberDID.
+func (s *ReactionService) Counts(ctx context.Context, convID, msgULID, memberDID string) ([]*repo.EmojiCount, error) {
+    msg, err := s.msgSvc.get(ctx, convID, msgULID)
+    if err != nil { return nil, err }
+    return s.reactRepo.Counts(ctx, msg.ULID, memberDID)
+}
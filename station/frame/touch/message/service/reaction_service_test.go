@@ -0,0 +1,53 @@
+package service
+
+import (
+    "context"
+    "errors"
+    "testing"
+
+    "github.com/peers-touch/peers-touch/station/frame/touch/internal/storetest"
+    "github.com/peers-touch/peers-touch/station/frame/touch/model"
+    m "github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+)
+
+func TestReact(t *testing.T) {
+    storetest.Reset(t)
+    ctx, svc := context.Background(), NewReactionService()
+    newTestConv(t, "conv-react", "did:peers:alice", "did:peers:bob")
+    msg := appendTestMessage(t, "conv-react", "did:peers:alice", 1700000000000, "hi")
+
+    react := func(member, op string, ts int64) *ReactionResult {
+        t.Helper()
+        res, err := svc.React(ctx, &ReactReq{ConvID: "conv-react", MsgULID: msg.ULID, MemberDID: member, Emoji: "👍", Op: op, TS: ts})
+        if err != nil { t.Fatal(err) }
+        return res
+    }
+    if res := react("did:peers:alice", m.ReactionOpAdd, 10); !res.Applied || len(res.Counts) != 1 || res.Counts[0].Count != 1 || !res.Counts[0].Reacted {
+        t.Fatalf("add = %+v %+v", res, res.Counts)
+    }
+    // another device of alice sends the same op again
+    if res := react("did:peers:alice", m.ReactionOpAdd, 10); res.Applied || res.Counts[0].Count != 1 { t.Fatalf("repeated add = %+v", res) }
+    if res := react("did:peers:bob", m.ReactionOpAdd, 5); !res.Applied || res.Counts[0].Count != 2 || !res.Counts[0].Reacted { t.Fatalf("add of bob = %+v", res) }
+    // a remove older than the add of bob came late
+    if res := react("did:peers:bob", m.ReactionOpRemove, 4); res.Applied || res.Counts[0].Count != 2 { t.Fatalf("late remove = %+v", res) }
+    if res := react("did:peers:bob", m.ReactionOpRemove, 6); !res.Applied || res.Counts[0].Count != 1 || res.Counts[0].Reacted { t.Fatalf("remove = %+v", res.Counts[0]) }
+
+    counts, err := svc.Counts(ctx, "conv-react", msg.ULID, "did:peers:alice")
+    if err != nil || len(counts) != 1 || counts[0].Count != 1 || !counts[0].Reacted { t.Fatalf("counts = %v, %v", counts, err) }
+
+    for _, tc := range []struct {
+        req  *ReactReq
+        want error
+    }{
+        {&ReactReq{ConvID: "conv-react", MsgULID: msg.ULID, MemberDID: "did:peers:bob", Emoji: "👍", Op: "toggle"}, model.ErrReactionInvalidOp},
+        {&ReactReq{ConvID: "conv-react", MsgULID: msg.ULID, MemberDID: "did:peers:bob", Op: m.ReactionOpAdd}, model.ErrReactionInvalidEmoji},
+        {&ReactReq{ConvID: "conv-other", MsgULID: msg.ULID, MemberDID: "did:peers:bob", Emoji: "👍", Op: m.ReactionOpAdd}, model.ErrMessageNotFound},
+    } {
+        if _, err := svc.React(ctx, tc.req); !errors.Is(err, tc.want) { t.Errorf("React(%+v) err = %v, want %v", tc.req, err, tc.want) }
+    }
+
+    if _, err := NewMessageService().Delete(ctx, "conv-react", msg.ULID, "did:peers:alice"); err != nil { t.Fatal(err) }
+    if _, err := svc.React(ctx, &ReactReq{ConvID: "conv-react", MsgULID: msg.ULID, MemberDID: "did:peers:bob", Emoji: "👍", Op: m.ReactionOpAdd}); !errors.Is(err, model.ErrMessageDeleted) {
+        t.Fatalf("reaction to a deleted message err = %v", err)
+    }
+}
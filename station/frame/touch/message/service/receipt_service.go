@@ -2,33 +2,91 @@ package service
 
 import (
     "context"
+    "errors"
     "time"
 
+    "github.com/peers-touch/peers-touch/station/frame/touch/model"
     m "github.com/peers-touch/peers-touch/station/frame/touch/model/db"
     "github.com/peers-touch/peers-touch/station/frame/touch/message/repo"
+    "github.com/peers-touch/peers-touch/station/frame/touch/message/stream"
+    "gorm.io/gorm"
 )
 
 type ReceiptService struct {
+    msgRepo  *repo.MessageRepo
     rcptRepo *repo.ReceiptRepo
+    markRepo *repo.WatermarkRepo
+    hub      *stream.Hub
 }
 
-func NewReceiptService() *ReceiptService { return &ReceiptService{rcptRepo: repo.NewReceiptRepo()} }
+func NewReceiptService() *ReceiptService {
+    return &ReceiptService{msgRepo: repo.NewMessageRepo(), rcptRepo: repo.NewReceiptRepo(), markRepo: repo.NewWatermarkRepo(), hub: stream.DefaultHub()}
+}
 
+// PostReceiptReq acknowledges every message up to MsgULID as delivered or read, or, with
+// FailReason, reports that MsgULID alone could not reach the member.
 type PostReceiptReq struct {
-    MsgULID   string
-    MemberDID string
-    Delivered bool
-    Read      bool
+    ConvID     string
+    MsgULID    string
+    MemberDID  string
+    Delivered  bool
+    Read       bool
+    FailReason string
+}
+
+// Receipts are the watermarks of the conversation members with the failures reported.
+type Receipts struct {
+    Watermarks []*m.Watermark `json:"watermarks"`
+    Failures   []*m.Receipt   `json:"failures"`
 }
 
-func (s *ReceiptService) Post(ctx context.Context, req *PostReceiptReq) (*m.Receipt, error) {
-    r := &m.Receipt{MsgULID: req.MsgULID, MemberDID: req.MemberDID}
-    if req.Delivered { r.DeliveredAt = time.Now() }
-    if req.Read { r.ReadAt = time.Now() }
-    if err := s.rcptRepo.Add(ctx, r); err != nil { return nil, err }
-    return r, nil
+// Unread is the read state of a member in a conversation.
+type Unread struct {
+    ConvID        string `json:"conv_id"`
+    Unread        int64  `json:"unread"`
+    DeliveredULID string `json:"delivered_ulid"`
+    ReadULID      string `json:"read_ulid"`
 }
 
-func (s *ReceiptService) List(ctx context.Context, convID string, after int64) ([]*m.Receipt, error) {
-    return s.rcptRepo.ListAfter(ctx, convID, after)
-}
\ No newline at end of file
+// Post records the receipt and returns the member watermark, which never goes backwards.
+// The message has to be one of the conversation, so a watermark never runs ahead of it.
+func (s *ReceiptService) Post(ctx context.Context, req *PostReceiptReq) (interface{}, error) {
+    if _, err := s.msgRepo.Get(ctx, req.ConvID, req.MsgULID); err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) { return nil, model.ErrMessageNotFound }
+        return nil, err
+    }
+    if req.FailReason != "" {
+        r := &m.Receipt{MsgULID: req.MsgULID, MemberDID: req.MemberDID, FailReason: req.FailReason}
+        if err := s.rcptRepo.AddFailure(ctx, r); err != nil { return nil, err }
+        s.hub.Publish(&stream.Event{ConvID: req.ConvID, Type: stream.EventReceipt, Data: r})
+        return r, nil
+    }
+
+    w := &m.Watermark{ConvID: req.ConvID, MemberDID: req.MemberDID}
+    if req.Delivered { w.DeliveredULID = req.MsgULID }
+    if req.Read { w.ReadULID = req.MsgULID }
+    if err := s.markRepo.Advance(ctx, w); err != nil { return nil, err }
+    w, err := s.markRepo.Get(ctx, req.ConvID, req.MemberDID)
+    if err != nil { return nil, err }
+    s.hub.Publish(&stream.Event{ConvID: req.ConvID, Type: stream.EventReceipt, Data: w})
+    return w, nil
+}
+
+// List returns the watermarks of the conversation and the failures reported after the
+// unix millis after.
+func (s *ReceiptService) List(ctx context.Context, convID string, after int64) (*Receipts, error) {
+    marks, err := s.markRepo.List(ctx, convID)
+    if err != nil { return nil, err }
+    fails, err := s.rcptRepo.ListFailures(ctx, convID, time.UnixMilli(after))
+    if err != nil { return nil, err }
+    return &Receipts{Watermarks: marks, Failures: fails}, nil
+}
+
+// Unread counts the messages of others the member has not read yet.
+func (s *ReceiptService) Unread(ctx context.Context, convID, did string) (*Unread, error) {
+    w, err := s.markRepo.Get(ctx, convID, did)
+    if err != nil { return nil, err }
+    n, err := s.markRepo.Unread(ctx, convID, did, w.ReadULID)
+    if err != nil { return nil, err }
+    return &Unread{ConvID: convID, Unread: n, DeliveredULID: w.DeliveredULID, ReadULID: w.ReadULID}, nil
+}
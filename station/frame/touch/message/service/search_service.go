@@ -0,0 +1,18 @@
+package service
+
+import (
+    "context"
+
+    "github.com/peers-touch/peers-touch/station/frame/touch/message/search"
+)
+
+type SearchService struct{}
+
+func NewSearchService() *SearchService { return &SearchService{} }
+
+// Search queries the index backend matching the store driver.
+func (s *SearchService) Search(ctx context.Context, q *search.Query) (*search.Result, error) {
+    idx, err := search.Get(ctx)
+    if err != nil { return nil, err }
+    return idx.Search(ctx, q)
+}
@@ -0,0 +1,39 @@
+package service
+
+import (
+    "context"
+    "time"
+
+    cfg "github.com/peers-touch/peers-touch/station/frame/core/config"
+    log "github.com/peers-touch/peers-touch/station/frame/core/logger"
+    "github.com/peers-touch/peers-touch/station/frame/core/store"
+    "github.com/peers-touch/peers-touch/station/frame/touch/util"
+    "gorm.io/gorm"
+)
+
+const (
+    defaultCompactionInterval = 10 * time.Minute
+    defaultSnapshotsKept      = 5
+    // compactionBatch caps the conversations snapshotted by one run.
+    compactionBatch = 100
+)
+
+// startSnapshotCompaction periodically snapshots the conversations that changed, so
+// clients can bootstrap from a recent snapshot. It is configured under
+// peers.touch.message.snapshot: interval (0 disables it), messages and keep.
+func startSnapshotCompaction(ctx context.Context, _ *gorm.DB) {
+    interval := cfg.Get("peers", "touch", "message", "snapshot", "interval").Duration(defaultCompactionInterval)
+    messages := cfg.Get("peers", "touch", "message", "snapshot", "messages").Int(DefaultSnapshotMessages)
+    keep := cfg.Get("peers", "touch", "message", "snapshot", "keep").Int(defaultSnapshotsKept)
+
+    svc := NewSnapshotService()
+    util.RunEvery(context.WithoutCancel(ctx), "snapshot-compaction", interval, func(ctx context.Context) error {
+        n, err := svc.Compact(ctx, messages, keep, compactionBatch)
+        if n > 0 { log.Infof(ctx, "snapshot compaction took %d snapshots", n) }
+        return err
+    })
+}
+
+func init() {
+    store.InitTableHooks(startSnapshotCompaction)
+}
@@ -0,0 +1,339 @@
+package service
+
+import (
+    "context"
+    "errors"
+    "time"
+
+    "github.com/peers-touch/peers-touch/station/frame/touch/message/repo"
+    "github.com/peers-touch/peers-touch/station/frame/touch/message/snapshot"
+    "github.com/peers-touch/peers-touch/station/frame/touch/model"
+    m "github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+    "gorm.io/gorm"
+)
+
+const (
+    // DefaultSnapshotMessages is the number of recent messages a snapshot carries.
+    DefaultSnapshotMessages = 50
+    maxSnapshotMessages     = 500
+)
+
+type SnapshotService struct {
+    convRepo   *repo.ConversationRepo
+    memberRepo *repo.MemberRepo
+    keyRepo    *repo.KeyEpochRepo
+    msgRepo    *repo.MessageRepo
+    markRepo   *repo.WatermarkRepo
+    snapRepo   *repo.SnapshotRepo
+}
+
+func NewSnapshotService() *SnapshotService {
+    return &SnapshotService{convRepo: repo.NewConversationRepo(), memberRepo: repo.NewMemberRepo(), keyRepo: repo.NewKeyEpochRepo(),
+        msgRepo: repo.NewMessageRepo(), markRepo: repo.NewWatermarkRepo(), snapRepo: repo.NewSnapshotRepo()}
+}
+
+// SnapshotView is a stored snapshot as returned to clients.
+type SnapshotView struct {
+    CID       string             `json:"cid"`
+    CreatedAt time.Time          `json:"created_at"`
+    Snapshot  *snapshot.Snapshot `json:"snapshot"`
+}
+
+// RestoreResult tells what a restore changed locally. CID names the snapshot stored, the
+// one restored less what the actor may not restore.
+type RestoreResult struct {
+    CID                 string `json:"cid"`
+    ConversationCreated bool   `json:"conversation_created"`
+    ConversationUpdated bool   `json:"conversation_updated"`
+    MembersAdded        int    `json:"members_added"`
+    KeyEpochAdded       bool   `json:"key_epoch_added"`
+    MessagesAdded       int    `json:"messages_added"`
+    MessagesRejected    int    `json:"messages_rejected"`
+    WatermarksAdvanced  int    `json:"watermarks_advanced"`
+}
+
+// Take captures the current state of the conversation with its newest limit messages
+// and stores it. Taking a snapshot of an unchanged conversation returns the stored one.
+func (s *SnapshotService) Take(ctx context.Context, convID string, limit int) (*SnapshotView, error) {
+    if limit <= 0 { limit = DefaultSnapshotMessages }
+    if limit > maxSnapshotMessages { limit = maxSnapshotMessages }
+
+    conv, err := s.convRepo.GetByConvID(ctx, convID)
+    if err != nil { return nil, err }
+    members, err := s.memberRepo.List(ctx, conv.ID)
+    if err != nil { return nil, err }
+    key, err := s.keyRepo.Get(ctx, conv.ID, conv.Epoch)
+    if err != nil { return nil, err }
+    var wraps []*m.KeyWrap
+    if key != nil {
+        if wraps, err = s.keyRepo.ListWraps(ctx, conv.ID, key.Epoch); err != nil { return nil, err }
+    }
+    msgs, err := s.msgRepo.ListLatest(ctx, convID, limit)
+    if err != nil { return nil, err }
+    marks, err := s.markRepo.List(ctx, convID)
+    if err != nil { return nil, err }
+
+    snap := &snapshot.Snapshot{
+        Version:      snapshot.Version,
+        ConvID:       convID,
+        Conversation: snapshot.Conversation{Type: string(conv.Type), Title: conv.Title, AvatarCID: conv.AvatarCID, Policy: conv.Policy, Epoch: conv.Epoch, Encrypted: conv.Encrypted},
+    }
+    known := make(map[string]struct{}, len(members))
+    for _, mbr := range members {
+        joined := mbr.JoinedAt
+        if joined.IsZero() { joined = mbr.CreatedAt }
+        snap.Members = append(snap.Members, &snapshot.Member{DID: mbr.DID, Role: string(mbr.Role), JoinedAt: joined.UnixMilli()})
+        known[mbr.DID] = struct{}{}
+    }
+    if key != nil {
+        snap.KeyEpoch = &snapshot.KeyEpoch{Epoch: key.Epoch, KeyMetaCID: key.KeyMetaCID, Alg: key.Alg}
+        for _, w := range wraps {
+            // wraps follow the member list, like watermarks below
+            if _, ok := known[w.MemberDID]; !ok { continue }
+            if snap.KeyEpoch.Wraps == nil { snap.KeyEpoch.Wraps = make(map[string]string, len(wraps)) }
+            snap.KeyEpoch.Wraps[w.MemberDID] = w.WrappedKey
+        }
+    }
+    for _, msg := range msgs {
+        sm := &snapshot.Message{ULID: msg.ULID, SenderDID: msg.SenderDID, TS: msg.TS, Type: string(msg.Type), ParentID: msg.ParentID, ThreadID: msg.ThreadID, ContentCID: msg.ContentCID, Body: msg.Body, Deleted: msg.Deleted, Epoch: msg.Epoch}
+        if !msg.TTLAt.IsZero() { sm.TTLAt = msg.TTLAt.UnixMilli() }
+        snap.Messages = append(snap.Messages, sm)
+        snap.HeadULID = msg.ULID
+    }
+    for _, w := range marks {
+        // receipts of former members are history, not state
+        if _, ok := known[w.MemberDID]; !ok { continue }
+        snap.Watermarks = append(snap.Watermarks, &snapshot.Watermark{MemberDID: w.MemberDID, DeliveredULID: w.DeliveredULID, ReadULID: w.ReadULID})
+    }
+
+    return s.save(ctx, snap)
+}
+
+// Get returns a stored snapshot of the conversation.
+func (s *SnapshotService) Get(ctx context.Context, convID, cid string) (*SnapshotView, error) {
+    row, err := s.snapRepo.Get(ctx, cid)
+    if errors.Is(err, gorm.ErrRecordNotFound) { return nil, model.ErrSnapshotNotFound }
+    if err != nil { return nil, err }
+    if row.ConvID != convID { return nil, model.ErrSnapshotNotFound }
+    return view(row)
+}
+
+// Restore validates an encoded snapshot and merges it into the local state in one
+// transaction. Merging only adds what is missing or newer, so restoring the same snapshot
+// twice is harmless. When cid is set the snapshot must match it. Only managing members can
+// restore into an existing conversation, a new one is created only if the snapshot lists
+// actorDID as owner. A restore grants no more than actorDID could grant otherwise, see
+// restrict.
+func (s *SnapshotService) Restore(ctx context.Context, convID, actorDID string, data []byte, cid string) (*RestoreResult, error) {
+    snap, sum, err := snapshot.Decode(data)
+    if err != nil { return nil, err }
+    if cid != "" && cid != sum { return nil, model.ErrSnapshotCIDMismatch }
+    if snap.ConvID != convID { return nil, model.ErrSnapshotConvIDMismatch }
+
+    res := &RestoreResult{}
+    err = repo.Transaction(ctx, func(ctx context.Context) error {
+        a, err := s.checkRestore(ctx, snap, actorDID)
+        if err != nil { return err }
+        wraps, err := s.restrict(ctx, snap, actorDID, a, res)
+        if err != nil { return err }
+
+        conv, err := s.mergeConversation(ctx, snap, res)
+        if err != nil { return err }
+        if err := s.mergeMembers(ctx, conv, snap, res); err != nil { return err }
+        if err := s.mergeKeyEpoch(ctx, conv, snap, wraps, res); err != nil { return err }
+        if err := s.mergeMessages(ctx, snap, res); err != nil { return err }
+        if err := s.mergeWatermarks(ctx, snap, res); err != nil { return err }
+
+        saved, err := s.save(ctx, snap)
+        if err != nil { return err }
+        res.CID = saved.CID
+        return nil
+    })
+    if err != nil { return nil, err }
+    return res, nil
+}
+
+// Compact snapshots up to limit conversations that changed since their latest snapshot
+// and keeps the newest keep snapshots of each.
+func (s *SnapshotService) Compact(ctx context.Context, messages, keep, limit int) (int, error) {
+    convIDs, err := s.snapRepo.ListStale(ctx, limit)
+    if err != nil { return 0, err }
+    for i, convID := range convIDs {
+        if _, err := s.Take(ctx, convID, messages); err != nil { return i, err }
+        if keep > 0 {
+            if _, err := s.snapRepo.Prune(ctx, convID, keep); err != nil { return i + 1, err }
+        }
+    }
+    return len(convIDs), nil
+}
+
+// checkRestore returns the access of actorDID to the existing conversation, nil when the
+// restore creates it.
+func (s *SnapshotService) checkRestore(ctx context.Context, snap *snapshot.Snapshot, actorDID string) (*Access, error) {
+    a, err := NewAccessService().Check(ctx, snap.ConvID, actorDID, true)
+    if !errors.Is(err, model.ErrConvNotFound) { return a, err }
+    for _, mbr := range snap.Members {
+        if mbr.DID == actorDID && m.Role(mbr.Role) == m.RoleOwner { return nil, nil }
+    }
+    return nil, model.ErrConvNotMember
+}
+
+// restrict drops from snap what actorDID may not restore, messages and watermarks of other
+// members, which only they can send, and returns the key wraps to restore: the ones of
+// joining members, and of every member when the snapshot brings a key epoch the
+// conversation does not have yet, as a rotation does. The wraps stay in snap, its key
+// meta CID covers them. Joining members get their roles on the terms of AddMembers, a is
+// nil for a new conversation, which actorDID owns.
+func (s *SnapshotService) restrict(ctx context.Context, snap *snapshot.Snapshot, actorDID string, a *Access, res *RestoreResult) (map[string]string, error) {
+    known := make(map[string]struct{})
+    newEpoch := true
+    if a != nil {
+        members, err := s.memberRepo.List(ctx, a.Conv.ID)
+        if err != nil { return nil, err }
+        for _, mbr := range members { known[mbr.DID] = struct{}{} }
+        if snap.KeyEpoch != nil {
+            key, err := s.keyRepo.Get(ctx, a.Conv.ID, snap.KeyEpoch.Epoch)
+            if err != nil { return nil, err }
+            newEpoch = key == nil
+        }
+    }
+
+    joining := make(map[string]struct{}, len(snap.Members))
+    for _, sm := range snap.Members {
+        if _, ok := known[sm.DID]; ok { continue }
+        role := m.Role(sm.Role)
+        if role != m.RoleOwner && role != m.RoleAdmin && role != m.RoleMember { return nil, model.ErrConvInvalidRole }
+        if role == m.RoleOwner && a != nil && a.Member.Role != m.RoleOwner { return nil, model.ErrConvForbidden }
+        joining[sm.DID] = struct{}{}
+    }
+    var wraps map[string]string
+    if sk := snap.KeyEpoch; sk != nil {
+        wraps = make(map[string]string, len(sk.Wraps))
+        for did, w := range sk.Wraps {
+            _, join := joining[did]
+            _, member := known[did]
+            if join || (newEpoch && member) { wraps[did] = w }
+        }
+    }
+
+    msgs := snap.Messages[:0]
+    snap.HeadULID = ""
+    for _, sm := range snap.Messages {
+        if sm.SenderDID != actorDID { res.MessagesRejected++; continue }
+        msgs = append(msgs, sm)
+        snap.HeadULID = sm.ULID
+    }
+    snap.Messages = msgs
+    marks := snap.Watermarks[:0]
+    for _, sw := range snap.Watermarks {
+        if sw.MemberDID == actorDID { marks = append(marks, sw) }
+    }
+    snap.Watermarks = marks
+    return wraps, nil
+}
+
+func (s *SnapshotService) save(ctx context.Context, snap *snapshot.Snapshot) (*SnapshotView, error) {
+    data, cid, err := snapshot.Encode(snap)
+    if err != nil { return nil, err }
+    row := &m.Snapshot{CID: cid, ConvID: snap.ConvID, Version: snap.Version, HeadULID: snap.HeadULID, Epoch: snap.Conversation.Epoch, Bytes: int64(len(data)), Data: data}
+    if err := s.snapRepo.Save(ctx, row); err != nil { return nil, err }
+    return &SnapshotView{CID: row.CID, CreatedAt: row.CreatedAt, Snapshot: snap}, nil
+}
+
+func (s *SnapshotService) mergeConversation(ctx context.Context, snap *snapshot.Snapshot, res *RestoreResult) (*m.Conversation, error) {
+    sc := snap.Conversation
+    conv, err := s.convRepo.GetByConvID(ctx, snap.ConvID)
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        conv = &m.Conversation{ConvID: snap.ConvID, Type: m.ConversationType(sc.Type), Title: sc.Title, AvatarCID: sc.AvatarCID, Policy: sc.Policy, Encrypted: sc.Encrypted}
+        if sc.Encrypted && keyed(snap) { conv.Epoch = sc.Epoch }
+        if err := s.convRepo.Create(ctx, conv); err != nil { return nil, err }
+        res.ConversationCreated = true
+        return conv, nil
+    }
+    if err != nil { return nil, err }
+
+    // metadata of a newer epoch wins, an older snapshot never rolls the conversation back.
+    // The epoch only moves along with its key, which mergeKeyEpoch stores in the same
+    // transaction, or the conversation would refuse every message sealed from then on.
+    if sc.Epoch > conv.Epoch && conv.Encrypted && keyed(snap) {
+        conv.Type, conv.Title, conv.AvatarCID, conv.Policy, conv.Epoch = m.ConversationType(sc.Type), sc.Title, sc.AvatarCID, sc.Policy, sc.Epoch
+        if err := s.convRepo.Save(ctx, conv); err != nil { return nil, err }
+        res.ConversationUpdated = true
+    }
+    return conv, nil
+}
+
+// keyed reports whether snap carries the key of the epoch of its conversation.
+func keyed(snap *snapshot.Snapshot) bool {
+    return snap.KeyEpoch != nil && snap.KeyEpoch.Epoch == snap.Conversation.Epoch
+}
+
+func (s *SnapshotService) mergeMembers(ctx context.Context, conv *m.Conversation, snap *snapshot.Snapshot, res *RestoreResult) error {
+    members, err := s.memberRepo.List(ctx, conv.ID)
+    if err != nil { return err }
+    known := make(map[string]struct{}, len(members))
+    for _, mbr := range members { known[mbr.DID] = struct{}{} }
+
+    for _, sm := range snap.Members {
+        if _, ok := known[sm.DID]; ok { continue }
+        mbr := &m.ConvMember{ConvID: conv.ID, DID: sm.DID, Role: m.Role(sm.Role)}
+        if sm.JoinedAt > 0 { mbr.JoinedAt = time.UnixMilli(sm.JoinedAt) }
+        if err := s.memberRepo.Create(ctx, mbr); err != nil { return err }
+        res.MembersAdded++
+    }
+    return nil
+}
+
+func (s *SnapshotService) mergeKeyEpoch(ctx context.Context, conv *m.Conversation, snap *snapshot.Snapshot, allowed map[string]string, res *RestoreResult) error {
+    sk := snap.KeyEpoch
+    if sk == nil { return nil }
+    key, err := s.keyRepo.Get(ctx, conv.ID, sk.Epoch)
+    if err != nil { return err }
+    if key == nil {
+        if err := s.keyRepo.Add(ctx, &m.KeyEpoch{ConvID: conv.ID, Epoch: sk.Epoch, KeyMetaCID: sk.KeyMetaCID, Alg: sk.Alg}); err != nil { return err }
+        res.KeyEpochAdded = true
+    }
+    wraps := make([]*m.KeyWrap, 0, len(allowed))
+    for did, w := range allowed { wraps = append(wraps, &m.KeyWrap{ConvID: conv.ID, Epoch: sk.Epoch, MemberDID: did, WrappedKey: w}) }
+    return s.keyRepo.AddWraps(ctx, wraps)
+}
+
+func (s *SnapshotService) mergeMessages(ctx context.Context, snap *snapshot.Snapshot, res *RestoreResult) error {
+    ulids := make([]string, 0, len(snap.Messages))
+    for _, sm := range snap.Messages { ulids = append(ulids, sm.ULID) }
+    existing, err := s.msgRepo.ExistingULIDs(ctx, ulids)
+    if err != nil { return err }
+
+    for _, sm := range snap.Messages {
+        if _, ok := existing[sm.ULID]; ok { continue }
+        msg := &m.Message{ULID: sm.ULID, ConvID: snap.ConvID, SenderDID: sm.SenderDID, TS: sm.TS, Type: m.MessageType(sm.Type), ParentID: sm.ParentID, ThreadID: sm.ThreadID, ContentCID: sm.ContentCID, Body: sm.Body, Deleted: sm.Deleted, Epoch: sm.Epoch}
+        if sm.TTLAt > 0 { msg.TTLAt = time.UnixMilli(sm.TTLAt) }
+        if err := s.msgRepo.Append(ctx, msg); err != nil { return err }
+        res.MessagesAdded++
+    }
+    return nil
+}
+
+// mergeWatermarks advances the local watermarks to the snapshot ones. Watermarks never
+// go backwards.
+func (s *SnapshotService) mergeWatermarks(ctx context.Context, snap *snapshot.Snapshot, res *RestoreResult) error {
+    marks, err := s.markRepo.List(ctx, snap.ConvID)
+    if err != nil { return err }
+    current := make(map[string]*m.Watermark, len(marks))
+    for _, w := range marks { current[w.MemberDID] = w }
+
+    for _, sw := range snap.Watermarks {
+        cur := current[sw.MemberDID]
+        if cur == nil { cur = &m.Watermark{} }
+        if sw.ReadULID <= cur.ReadULID && sw.DeliveredULID <= cur.DeliveredULID { continue }
+        if err := s.markRepo.Advance(ctx, &m.Watermark{ConvID: snap.ConvID, MemberDID: sw.MemberDID, DeliveredULID: sw.DeliveredULID, ReadULID: sw.ReadULID}); err != nil { return err }
+        res.WatermarksAdvanced++
+    }
+    return nil
+}
+
+func view(row *m.Snapshot) (*SnapshotView, error) {
+    snap, _, err := snapshot.Decode(row.Data)
+    if err != nil { return nil, err }
+    return &SnapshotView{CID: row.CID, CreatedAt: row.CreatedAt, Snapshot: snap}, nil
+}
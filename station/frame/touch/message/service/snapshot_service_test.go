@@ -0,0 +1,140 @@
+package service
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+
+    "github.com/peers-touch/peers-touch/station/frame/core/util/id"
+    "github.com/peers-touch/peers-touch/station/frame/touch/internal/storetest"
+    "github.com/peers-touch/peers-touch/station/frame/touch/message/e2ee"
+    "github.com/peers-touch/peers-touch/station/frame/touch/message/repo"
+    "github.com/peers-touch/peers-touch/station/frame/touch/message/snapshot"
+    "github.com/peers-touch/peers-touch/station/frame/touch/model"
+    m "github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+)
+
+func encodeTestSnapshot(t *testing.T, snap *snapshot.Snapshot) []byte {
+    t.Helper()
+    for _, msg := range snap.Messages {
+        if msg.ULID > snap.HeadULID { snap.HeadULID = msg.ULID }
+    }
+    data, _, err := snapshot.Encode(snap)
+    if err != nil { t.Fatal(err) }
+    return data
+}
+
+func TestRestoreTakesOnlyWhatTheActorMayGrant(t *testing.T) {
+    db := storetest.Reset(t)
+    ctx, svc := context.Background(), NewSnapshotService()
+    newTestConv(t, "conv-snap", "did:peers:alice", "did:peers:bob")
+    now := time.Now()
+    own, forged := id.NewULID(now), id.NewULID(now.Add(time.Millisecond))
+
+    data := encodeTestSnapshot(t, &snapshot.Snapshot{
+        Version: snapshot.Version, ConvID: "conv-snap", Conversation: snapshot.Conversation{Type: "group", Title: "conv-snap"},
+        Members: []*snapshot.Member{{DID: "did:peers:alice", Role: string(m.RoleOwner)}, {DID: "did:peers:bob", Role: string(m.RoleMember)}, {DID: "did:peers:dave", Role: string(m.RoleMember)}},
+        Messages: []*snapshot.Message{
+            {ULID: own, SenderDID: "did:peers:alice", TS: now.UnixMilli(), Type: "text", Body: "mine"},
+            {ULID: forged, SenderDID: "did:peers:bob", TS: now.UnixMilli(), Type: "text", Body: "not bob"},
+        },
+        Watermarks: []*snapshot.Watermark{{MemberDID: "did:peers:alice", ReadULID: forged}, {MemberDID: "did:peers:bob", ReadULID: forged}},
+    })
+    if _, err := svc.Restore(ctx, "conv-snap", "did:peers:bob", data, ""); !errors.Is(err, model.ErrConvForbidden) { t.Fatalf("restore of a member err = %v", err) }
+
+    res, err := svc.Restore(ctx, "conv-snap", "did:peers:alice", data, "")
+    if err != nil { t.Fatal(err) }
+    if res.MessagesAdded != 1 || res.MessagesRejected != 1 || res.MembersAdded != 1 || res.WatermarksAdvanced != 1 { t.Fatalf("restore = %+v", res) }
+    var senders []string
+    if err := db.Model(&m.Message{}).Where("conv_id = ?", "conv-snap").Pluck("sender_did", &senders).Error; err != nil { t.Fatal(err) }
+    if len(senders) != 1 || senders[0] != "did:peers:alice" { t.Fatalf("senders = %v", senders) }
+    marks, err := repo.NewWatermarkRepo().List(ctx, "conv-snap")
+    if err != nil { t.Fatal(err) }
+    for _, w := range marks {
+        if w.MemberDID == "did:peers:bob" { t.Fatalf("watermark of bob restored: %+v", w) }
+    }
+    stored, err := svc.Get(ctx, "conv-snap", res.CID)
+    if err != nil { t.Fatal(err) }
+    if len(stored.Snapshot.Messages) != 1 || stored.Snapshot.Messages[0].ULID != own || stored.Snapshot.HeadULID != own { t.Fatalf("stored snapshot = %+v", stored.Snapshot) }
+
+    // admins cannot make owners, and a refused restore leaves nothing behind
+    conv, err := NewConversationService().Get(ctx, "conv-snap")
+    if err != nil { t.Fatal(err) }
+    if err := repo.NewMemberRepo().Add(ctx, conv.ID, "did:peers:carol", m.RoleAdmin); err != nil { t.Fatal(err) }
+    data = encodeTestSnapshot(t, &snapshot.Snapshot{
+        Version: snapshot.Version, ConvID: "conv-snap", Conversation: snapshot.Conversation{Type: "group", Title: "conv-snap"},
+        Members:  []*snapshot.Member{{DID: "did:peers:carol", Role: string(m.RoleAdmin)}, {DID: "did:peers:eve", Role: string(m.RoleOwner)}},
+        Messages: []*snapshot.Message{{ULID: id.NewULID(now.Add(2 * time.Millisecond)), SenderDID: "did:peers:carol", TS: now.UnixMilli(), Type: "text", Body: "hi"}},
+    })
+    if _, err := svc.Restore(ctx, "conv-snap", "did:peers:carol", data, ""); !errors.Is(err, model.ErrConvForbidden) { t.Fatalf("owner granted by an admin err = %v", err) }
+    if mbr, err := repo.NewMemberRepo().Get(ctx, conv.ID, "did:peers:eve"); err != nil || mbr != nil { t.Fatalf("eve = %+v, %v", mbr, err) }
+    var count int64
+    if err := db.Model(&m.Message{}).Where("conv_id = ?", "conv-snap").Count(&count).Error; err != nil || count != 1 { t.Fatalf("messages = %d, %v", count, err) }
+}
+
+func TestRestoreNewConversation(t *testing.T) {
+    db := storetest.Reset(t)
+    ctx, svc := context.Background(), NewSnapshotService()
+    now := time.Now()
+    data := encodeTestSnapshot(t, &snapshot.Snapshot{
+        Version: snapshot.Version, ConvID: "conv-new", Conversation: snapshot.Conversation{Type: "group", Title: "conv-new"},
+        Members: []*snapshot.Member{{DID: "did:peers:frank", Role: string(m.RoleOwner)}, {DID: "did:peers:grace", Role: string(m.RoleMember)}},
+        Messages: []*snapshot.Message{
+            {ULID: id.NewULID(now), SenderDID: "did:peers:frank", TS: now.UnixMilli(), Type: "text", Body: "hi"},
+            {ULID: id.NewULID(now.Add(time.Millisecond)), SenderDID: "did:peers:grace", TS: now.UnixMilli(), Type: "text", Body: "not grace"},
+        },
+    })
+    if _, err := svc.Restore(ctx, "conv-new", "did:peers:grace", data, ""); !errors.Is(err, model.ErrConvNotMember) { t.Fatalf("restore by a member err = %v", err) }
+    if err := db.Where("conv_id = ?", "conv-new").First(&m.Conversation{}).Error; err == nil { t.Fatal("refused restore created the conversation") }
+
+    res, err := svc.Restore(ctx, "conv-new", "did:peers:frank", data, "")
+    if err != nil { t.Fatal(err) }
+    if !res.ConversationCreated || res.MembersAdded != 2 || res.MessagesAdded != 1 || res.MessagesRejected != 1 { t.Fatalf("restore = %+v", res) }
+    // restoring again is harmless
+    if res, err = svc.Restore(ctx, "conv-new", "did:peers:frank", data, ""); err != nil || res.MessagesAdded != 0 || res.MembersAdded != 0 { t.Fatalf("second restore = %+v, %v", res, err) }
+}
+
+func TestRestoreAdvancesTheEpochWithItsKey(t *testing.T) {
+    db := storetest.Reset(t)
+    ctx, svc := context.Background(), NewSnapshotService()
+    conv := newTestConv(t, "conv-epoch", "did:peers:alice", "did:peers:bob")
+    // an encrypted conversation at epoch 1
+    if err := db.Model(conv).Updates(map[string]interface{}{"encrypted": true, "epoch": 1}).Error; err != nil { t.Fatal(err) }
+    if err := repo.NewKeyEpochRepo().Add(ctx, &m.KeyEpoch{ConvID: conv.ID, Epoch: 1, KeyMetaCID: "meta-1", Alg: e2ee.Alg}); err != nil { t.Fatal(err) }
+    members := []*snapshot.Member{{DID: "did:peers:alice", Role: string(m.RoleOwner)}, {DID: "did:peers:bob", Role: string(m.RoleMember)}}
+    wraps := map[string]string{"did:peers:alice": "wrap-alice", "did:peers:bob": "wrap-bob"}
+    restore := func(key *snapshot.KeyEpoch) *RestoreResult {
+        t.Helper()
+        data := encodeTestSnapshot(t, &snapshot.Snapshot{
+            Version: snapshot.Version, ConvID: "conv-epoch", Members: members, KeyEpoch: key,
+            Conversation: snapshot.Conversation{Type: "channel", Title: "renamed", Policy: "open", Epoch: 2, Encrypted: true},
+        })
+        res, err := svc.Restore(ctx, "conv-epoch", "did:peers:alice", data, "")
+        if err != nil { t.Fatal(err) }
+        return res
+    }
+    appendSealed := func(epoch int) error {
+        _, err := NewMessageService().Append(ctx, &AppendReq{ConvID: "conv-epoch", SenderDID: "did:peers:alice", TS: time.Now().UnixMilli(), Type: "text", Body: "sealed", Epoch: epoch})
+        return err
+    }
+
+    for name, key := range map[string]*snapshot.KeyEpoch{
+        "without key":            nil,
+        "with the key of before": {Epoch: 1, KeyMetaCID: "meta-1", Alg: e2ee.Alg},
+    } {
+        if res := restore(key); res.ConversationUpdated || res.KeyEpochAdded { t.Fatalf("%s: restore = %+v", name, res) }
+        got, err := NewConversationService().Get(ctx, "conv-epoch")
+        if err != nil { t.Fatal(err) }
+        if got.Epoch != 1 || got.Title != "conv-epoch" || got.Policy == "open" || got.Type == "channel" { t.Fatalf("%s: conversation = %+v", name, got) }
+        if err := appendSealed(1); err != nil { t.Fatalf("%s: append at epoch 1: %v", name, err) }
+    }
+
+    res := restore(&snapshot.KeyEpoch{Epoch: 2, KeyMetaCID: e2ee.KeyMetaCID(e2ee.Alg, 2, wraps), Alg: e2ee.Alg, Wraps: wraps})
+    if !res.ConversationUpdated || !res.KeyEpochAdded { t.Fatalf("restore with the key = %+v", res) }
+    got, err := NewConversationService().Get(ctx, "conv-epoch")
+    if err != nil { t.Fatal(err) }
+    if got.Epoch != 2 || got.Title != "renamed" || got.Policy != "open" { t.Fatalf("conversation = %+v", got) }
+    if err := appendSealed(2); err != nil { t.Fatalf("append at epoch 2: %v", err) }
+    if err := appendSealed(1); !errors.Is(err, model.ErrMessageEpoch) { t.Fatalf("append at epoch 1: %v", err) }
+}
@@ -0,0 +1,139 @@
+// Package snapshot defines the versioned wire format of conversation snapshots.
+// A snapshot is encoded canonically, so the same state always has the same CID.
+package snapshot
+
+import (
+    "encoding/json"
+    "sort"
+
+    "github.com/peers-touch/peers-touch/station/frame/touch/message/cas"
+    "github.com/peers-touch/peers-touch/station/frame/touch/message/e2ee"
+    "github.com/peers-touch/peers-touch/station/frame/touch/model"
+)
+
+// Version is the format written by this build. Older versions are still accepted by Decode.
+const Version = 1
+
+type Snapshot struct {
+    Version      int          `json:"version"`
+    ConvID       string       `json:"conv_id"`
+    Conversation Conversation `json:"conversation"`
+    Members      []*Member    `json:"members"`
+    KeyEpoch     *KeyEpoch    `json:"key_epoch,omitempty"`
+    // HeadULID is the newest message of the conversation when the snapshot was taken.
+    HeadULID   string       `json:"head_ulid"`
+    Messages   []*Message   `json:"messages"`
+    Watermarks []*Watermark `json:"watermarks"`
+}
+
+type Conversation struct {
+    Type      string `json:"type"`
+    Title     string `json:"title"`
+    AvatarCID string `json:"avatar_cid"`
+    Policy    string `json:"policy"`
+    Epoch     int    `json:"epoch"`
+    Encrypted bool   `json:"encrypted,omitempty"`
+}
+
+type Member struct {
+    DID      string `json:"did"`
+    Role     string `json:"role"`
+    JoinedAt int64  `json:"joined_at"`
+}
+
+// KeyEpoch is the current key epoch. Wraps holds the member wraps of the epoch by DID,
+// so a restored encrypted conversation stays readable; KeyMetaCID addresses them.
+type KeyEpoch struct {
+    Epoch      int               `json:"epoch"`
+    KeyMetaCID string            `json:"key_meta_cid"`
+    Alg        string            `json:"alg,omitempty"`
+    Wraps      map[string]string `json:"wraps,omitempty"`
+}
+
+type Message struct {
+    ULID       string `json:"ulid"`
+    SenderDID  string `json:"sender_did"`
+    TS         int64  `json:"ts"`
+    Type       string `json:"type"`
+    ParentID   string `json:"parent_id,omitempty"`
+    ThreadID   string `json:"thread_id,omitempty"`
+    ContentCID string `json:"content_cid,omitempty"`
+    Body       string `json:"body,omitempty"`
+    Deleted    bool   `json:"deleted,omitempty"`
+    TTLAt      int64  `json:"ttl_at,omitempty"`
+    Epoch      int    `json:"epoch,omitempty"`
+}
+
+// Watermark is the newest message a member has got delivered and read.
+type Watermark struct {
+    MemberDID     string `json:"member_did"`
+    DeliveredULID string `json:"delivered_ulid"`
+    ReadULID      string `json:"read_ulid"`
+}
+
+// Encode sorts the snapshot in place and returns its canonical encoding with its CID.
+func Encode(s *Snapshot) ([]byte, string, error) {
+    if s.Version == 0 { s.Version = Version }
+    s.canonicalize()
+    data, err := json.Marshal(s)
+    if err != nil { return nil, "", err }
+    return data, cas.Sum(data), nil
+}
+
+// Decode parses and validates an encoded snapshot. The CID returned is the one of the
+// canonical encoding, so a snapshot that went through a client keeps its CID as long
+// as its content is unchanged.
+func Decode(data []byte) (*Snapshot, string, error) {
+    var s Snapshot
+    if err := json.Unmarshal(data, &s); err != nil { return nil, "", invalid(err.Error()) }
+    if err := s.Validate(); err != nil { return nil, "", err }
+    _, cid, err := Encode(&s)
+    if err != nil { return nil, "", err }
+    return &s, cid, nil
+}
+
+// Validate checks the snapshot is self consistent.
+func (s *Snapshot) Validate() error {
+    if s.Version < 1 || s.Version > Version { return model.ErrSnapshotVersion }
+    if s.ConvID == "" { return invalid("conv_id is empty") }
+
+    seen := make(map[string]struct{}, len(s.Members))
+    for _, mbr := range s.Members {
+        if mbr == nil || mbr.DID == "" { return invalid("member without did") }
+        if _, ok := seen[mbr.DID]; ok { return invalid("duplicate member " + mbr.DID) }
+        seen[mbr.DID] = struct{}{}
+    }
+
+    if k := s.KeyEpoch; k != nil {
+        if k.Epoch > s.Conversation.Epoch { return invalid("key epoch is ahead of the conversation epoch") }
+        if len(k.Wraps) > 0 && k.KeyMetaCID != e2ee.KeyMetaCID(k.Alg, k.Epoch, k.Wraps) { return invalid("key wraps do not match key_meta_cid") }
+    }
+
+    ulids := make(map[string]struct{}, len(s.Messages))
+    for _, msg := range s.Messages {
+        if msg == nil || msg.ULID == "" { return invalid("message without ulid") }
+        if _, ok := ulids[msg.ULID]; ok { return invalid("duplicate message " + msg.ULID) }
+        if msg.ULID > s.HeadULID { return invalid("message " + msg.ULID + " is newer than the head") }
+        if msg.Epoch > s.Conversation.Epoch { return invalid("message " + msg.ULID + " is sealed with a future key epoch") }
+        ulids[msg.ULID] = struct{}{}
+    }
+
+    for _, w := range s.Watermarks {
+        if w == nil || w.MemberDID == "" { return invalid("watermark without member") }
+        if _, ok := seen[w.MemberDID]; !ok { return invalid("watermark of unknown member " + w.MemberDID) }
+    }
+    return nil
+}
+
+func (s *Snapshot) canonicalize() {
+    sort.Slice(s.Members, func(i, j int) bool { return s.Members[i].DID < s.Members[j].DID })
+    sort.Slice(s.Messages, func(i, j int) bool { return s.Messages[i].ULID < s.Messages[j].ULID })
+    sort.Slice(s.Watermarks, func(i, j int) bool { return s.Watermarks[i].MemberDID < s.Watermarks[j].MemberDID })
+    if s.Members == nil { s.Members = []*Member{} }
+    if s.Messages == nil { s.Messages = []*Message{} }
+    if s.Watermarks == nil { s.Watermarks = []*Watermark{} }
+}
+
+func invalid(reason string) error {
+    return model.NewError(model.ErrSnapshotInvalid.Code, "invalid snapshot: "+reason)
+}
@@ -0,0 +1,68 @@
+package snapshot
+
+import (
+    "errors"
+    "testing"
+
+    "github.com/peers-touch/peers-touch/station/frame/touch/model"
+)
+
+func testSnapshot() *Snapshot {
+    return &Snapshot{
+        ConvID:       "c1",
+        Conversation: Conversation{Type: "group", Title: "t", Epoch: 2},
+        Members:      []*Member{{DID: "did:b", Role: "member"}, {DID: "did:a", Role: "owner"}},
+        KeyEpoch:     &KeyEpoch{Epoch: 2, KeyMetaCID: "bafk"},
+        HeadULID:     "02",
+        Messages:     []*Message{{ULID: "02", Body: "b"}, {ULID: "01", Body: "a"}},
+        Watermarks:   []*Watermark{{MemberDID: "did:a", ReadULID: "01"}},
+    }
+}
+
+func TestEncodeDecode(t *testing.T) {
+    data, cid, err := Encode(testSnapshot())
+    if err != nil {
+        t.Fatalf("Encode() error = %v", err)
+    }
+
+    s, decodedCID, err := Decode(data)
+    if err != nil {
+        t.Fatalf("Decode() error = %v", err)
+    }
+    if decodedCID != cid {
+        t.Errorf("Decode() cid = %s, want %s", decodedCID, cid)
+    }
+    if s.Version != Version || s.Members[0].DID != "did:a" || s.Messages[0].ULID != "01" {
+        t.Errorf("Decode() = %+v, want canonical order", s)
+    }
+
+    // member order must not change the content address
+    other := testSnapshot()
+    other.Members[0], other.Members[1] = other.Members[1], other.Members[0]
+    if _, otherCID, _ := Encode(other); otherCID != cid {
+        t.Errorf("Encode() cid depends on member order")
+    }
+}
+
+func TestValidate(t *testing.T) {
+    cases := map[string]func(s *Snapshot){
+        "version":           func(s *Snapshot) { s.Version = Version + 1 },
+        "conv id":           func(s *Snapshot) { s.ConvID = "" },
+        "duplicate member":  func(s *Snapshot) { s.Members[1].DID = "did:b" },
+        "key epoch":         func(s *Snapshot) { s.KeyEpoch.Epoch = 3 },
+        "message past head": func(s *Snapshot) { s.HeadULID = "01" },
+        "unknown watermark": func(s *Snapshot) { s.Watermarks[0].MemberDID = "did:x" },
+    }
+    for name, mutate := range cases {
+        s := testSnapshot()
+        s.Version = Version
+        mutate(s)
+        var e *model.Error
+        if err := s.Validate(); !errors.As(err, &e) {
+            t.Errorf("%s: Validate() error = %v, want a model error", name, err)
+        }
+    }
+    if err := testSnapshot().Validate(); !errors.Is(err, model.ErrSnapshotVersion) {
+        t.Errorf("Validate() without version error = %v", err)
+    }
+}
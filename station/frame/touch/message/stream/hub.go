@@ -0,0 +1,154 @@
+package stream
+
+import (
+    "sync"
+
+    "github.com/peers-touch/peers-touch/station/frame/core/util/id"
+)
+
+type EventType string
+
+const (
+    EventMessage  EventType = "message"
+    EventEdit     EventType = "edit"
+    // EventDelete carries the tombstone of a deleted or expired message.
+    EventDelete   EventType = "delete"
+    EventReceipt  EventType = "receipt"
+    EventReaction EventType = "reaction"
+    // EventKey announces a new key epoch, members fetch their wrap of it.
+    EventKey      EventType = "key"
+)
+
+const (
+    // defaultReplaySize is the number of recent events kept per conversation so that
+    // reconnecting subscribers can resume from a cursor without touching the RDS.
+    defaultReplaySize = 256
+    // defaultSubscriberBuffer is the channel size of a subscriber. A subscriber that
+    // falls further behind is dropped and expected to reconnect with its last cursor.
+    defaultSubscriberBuffer = 64
+)
+
+// Event is a single item pushed to conversation subscribers.
+// ID is a ULID and doubles as the resume cursor (SSE Last-Event-ID).
+type Event struct {
+    ID     string      `json:"id"`
+    ConvID string      `json:"conv_id"`
+    Type   EventType   `json:"type"`
+    Data   interface{} `json:"data"`
+}
+
+// Hub fans out conversation events to in-process subscribers.
+type Hub struct {
+    mu         sync.RWMutex
+    subs       map[string]map[*Subscription]struct{}
+    recent     map[string][]*Event
+    replaySize int
+    bufSize    int
+}
+
+// Subscription receives the events of one conversation until it is closed.
+type Subscription struct {
+    C <-chan *Event
+
+    ch     chan *Event
+    convID string
+    hub    *Hub
+    once   sync.Once
+}
+
+var (
+    defaultHub     *Hub
+    defaultHubOnce sync.Once
+)
+
+// DefaultHub returns the process wide hub used by the message services.
+func DefaultHub() *Hub {
+    defaultHubOnce.Do(func() { defaultHub = NewHub(defaultReplaySize, defaultSubscriberBuffer) })
+    return defaultHub
+}
+
+func NewHub(replaySize, bufSize int) *Hub {
+    if replaySize <= 0 { replaySize = defaultReplaySize }
+    if bufSize <= 0 { bufSize = defaultSubscriberBuffer }
+    return &Hub{subs: make(map[string]map[*Subscription]struct{}), recent: make(map[string][]*Event), replaySize: replaySize, bufSize: bufSize}
+}
+
+// Publish delivers the event to every subscriber of its conversation and keeps it
+// in the replay buffer. It never blocks on slow subscribers.
+func (h *Hub) Publish(e *Event) {
+    if e == nil || e.ConvID == "" { return }
+    if e.ID == "" { e.ID = id.NextULID() }
+
+    h.mu.Lock()
+    defer h.mu.Unlock()
+
+    buf := append(h.recent[e.ConvID], e)
+    if len(buf) > h.replaySize { buf = buf[len(buf)-h.replaySize:] }
+    h.recent[e.ConvID] = buf
+
+    for s := range h.subs[e.ConvID] {
+        select {
+        case s.ch <- e:
+        default:
+            // subscriber is too slow, drop it. The client reconnects and resumes
+            // from the last event id it has seen.
+            h.remove(s)
+        }
+    }
+}
+
+// Subscribe registers a subscriber for the conversation. It returns the buffered
+// events after cursor and whether the buffer covers the cursor. When covered is false
+// the caller has to replay older events from the persistent store first.
+func (h *Hub) Subscribe(convID, cursor string) (sub *Subscription, backlog []*Event, covered bool) {
+    ch := make(chan *Event, h.bufSize)
+    sub = &Subscription{C: ch, ch: ch, convID: convID, hub: h}
+
+    h.mu.Lock()
+    defer h.mu.Unlock()
+
+    if h.subs[convID] == nil { h.subs[convID] = make(map[*Subscription]struct{}) }
+    h.subs[convID][sub] = struct{}{}
+
+    buf := h.recent[convID]
+    if cursor == "" { return sub, nil, true }
+
+    for i, e := range buf {
+        if e.ID == cursor {
+            backlog = append(backlog, buf[i+1:]...)
+            return sub, backlog, true
+        }
+    }
+
+    // the cursor is unknown to the buffer, hand back everything newer than it and
+    // let the caller fill the gap.
+    for _, e := range buf {
+        if e.ID > cursor { backlog = append(backlog, e) }
+    }
+    return sub, backlog, false
+}
+
+// Subscribers returns the number of live subscribers of the conversation.
+func (h *Hub) Subscribers(convID string) int {
+    h.mu.RLock()
+    defer h.mu.RUnlock()
+    return len(h.subs[convID])
+}
+
+// Close unregisters the subscription. It is safe to call it more than once.
+func (s *Subscription) Close() {
+    s.hub.mu.Lock()
+    defer s.hub.mu.Unlock()
+    s.hub.remove(s)
+}
+
+// remove must be called with h.mu held.
+func (h *Hub) remove(s *Subscription) {
+    s.once.Do(func() {
+        if subs := h.subs[s.convID]; subs != nil {
+            delete(subs, s)
+            if len(subs) == 0 { delete(h.subs, s.convID) }
+        }
+        close(s.ch)
+    })
+}
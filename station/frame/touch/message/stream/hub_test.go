@@ -0,0 +1,71 @@
+package stream
+
+import (
+    "testing"
+)
+
+func TestHubPublishSubscribe(t *testing.T) {
+    h := NewHub(4, 8)
+
+    h.Publish(&Event{ID: "01", ConvID: "c1", Type: EventMessage})
+    h.Publish(&Event{ID: "02", ConvID: "c1", Type: EventMessage})
+
+    sub, backlog, covered := h.Subscribe("c1", "01")
+    if !covered {
+        t.Fatalf("Subscribe() covered = false, want true")
+    }
+    if len(backlog) != 1 || backlog[0].ID != "02" {
+        t.Fatalf("Subscribe() backlog = %v, want [02]", backlog)
+    }
+
+    h.Publish(&Event{ID: "03", ConvID: "c1", Type: EventReceipt})
+    h.Publish(&Event{ID: "x", ConvID: "c2", Type: EventMessage})
+
+    e := <-sub.C
+    if e.ID != "03" {
+        t.Errorf("received %s, want 03", e.ID)
+    }
+
+    sub.Close()
+    sub.Close()
+    if _, ok := <-sub.C; ok {
+        t.Errorf("subscription channel still open after Close")
+    }
+    if n := h.Subscribers("c1"); n != 0 {
+        t.Errorf("Subscribers() = %d, want 0", n)
+    }
+}
+
+func TestHubUnknownCursor(t *testing.T) {
+    h := NewHub(2, 8)
+    for _, id := range []string{"01", "02", "03"} {
+        h.Publish(&Event{ID: id, ConvID: "c1"})
+    }
+
+    sub, backlog, covered := h.Subscribe("c1", "01")
+    defer sub.Close()
+    if covered {
+        t.Fatalf("Subscribe() covered = true for an evicted cursor")
+    }
+    if len(backlog) != 2 || backlog[0].ID != "02" || backlog[1].ID != "03" {
+        t.Errorf("Subscribe() backlog = %v, want [02 03]", backlog)
+    }
+}
+
+func TestHubDropsSlowSubscriber(t *testing.T) {
+    h := NewHub(4, 1)
+    sub, _, _ := h.Subscribe("c1", "")
+
+    h.Publish(&Event{ID: "01", ConvID: "c1"})
+    h.Publish(&Event{ID: "02", ConvID: "c1"})
+
+    if n := h.Subscribers("c1"); n != 0 {
+        t.Fatalf("Subscribers() = %d, want slow subscriber dropped", n)
+    }
+    if e := <-sub.C; e.ID != "01" {
+        t.Errorf("received %s, want 01", e.ID)
+    }
+    if _, ok := <-sub.C; ok {
+        t.Errorf("dropped subscription channel still open")
+    }
+}
@@ -0,0 +1,37 @@
+package stream
+
+import (
+    "time"
+)
+
+// heartbeatInterval keeps idle connections open through proxies and reveals dead clients.
+const heartbeatInterval = 15 * time.Second
+
+// Serve writes the initial events and then every live event of sub to w, until the
+// client disconnects or the hub drops the subscription. It closes sub on return.
+func Serve(w Writer, sub *Subscription, initial []*Event) {
+    defer sub.Close()
+
+    sent := make(map[string]struct{}, len(initial))
+    for _, e := range initial {
+        if err := w.WriteEvent(e); err != nil { return }
+        sent[e.ID] = struct{}{}
+    }
+
+    ticker := time.NewTicker(heartbeatInterval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case e, ok := <-sub.C:
+            if !ok { return }
+            // the event may have been replayed already while subscribing
+            if _, dup := sent[e.ID]; dup { continue }
+            if err := w.WriteEvent(e); err != nil { return }
+        case <-ticker.C:
+            if err := w.Ping(); err != nil { return }
+        case <-w.Done():
+            return
+        }
+    }
+}
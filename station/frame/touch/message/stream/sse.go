@@ -0,0 +1,86 @@
+package stream
+
+import (
+    "bytes"
+    "encoding/json"
+    "net/http"
+    "sync"
+
+    "github.com/cloudwego/hertz/pkg/app"
+    "github.com/cloudwego/hertz/pkg/network"
+    hznetpoll "github.com/cloudwego/hertz/pkg/network/netpoll"
+    "github.com/cloudwego/hertz/pkg/protocol/http1/resp"
+    "github.com/cloudwego/netpoll"
+)
+
+// Writer pushes events to one connected client.
+type Writer interface {
+    WriteEvent(e *Event) error
+    // Ping keeps the connection alive and detects clients that went away.
+    Ping() error
+    // Done is closed once the client has gone, nil if the transport can't tell.
+    Done() <-chan struct{}
+    Close() error
+}
+
+// sseWriter implements Writer with Server-Sent Events over a chunked response.
+type sseWriter struct {
+    w    network.ExtWriter
+    done <-chan struct{}
+}
+
+// NewSSEWriter takes over the response of ctx and prepares it for event streaming.
+func NewSSEWriter(ctx *app.RequestContext) Writer {
+    ctx.SetStatusCode(http.StatusOK)
+    ctx.Response.Header.Set("Content-Type", "text/event-stream")
+    ctx.Response.Header.Set("Cache-Control", "no-cache")
+    ctx.Response.Header.Set("Connection", "keep-alive")
+    ctx.Response.Header.Set("X-Accel-Buffering", "no")
+    w := resp.NewChunkedBodyWriter(&ctx.Response, ctx.GetWriter())
+    ctx.Response.HijackWriter(w)
+    return &sseWriter{w: w, done: hangup(ctx.GetConn())}
+}
+
+// closeNotifier is implemented by netpoll connections, which call back when the peer
+// hangs up.
+type closeNotifier interface {
+    AddCloseCallback(callback netpoll.CloseCallback) error
+}
+
+// hangup returns a channel closed once the client of conn hangs up, nil when the transport
+// can't tell; the pings of the pump find those clients gone.
+func hangup(conn network.Conn) <-chan struct{} {
+    if c, ok := conn.(*hznetpoll.Conn); ok { conn = c.Conn }
+    n, ok := conn.(closeNotifier)
+    if !ok { return nil }
+    done := make(chan struct{})
+    var once sync.Once
+    if err := n.AddCloseCallback(func(netpoll.Connection) error { once.Do(func() { close(done) }); return nil }); err != nil { return nil }
+    return done
+}
+
+func (w *sseWriter) WriteEvent(e *Event) error {
+    data, err := json.Marshal(e)
+    if err != nil { return err }
+
+    var buf bytes.Buffer
+    buf.WriteString("id: ")
+    buf.WriteString(e.ID)
+    buf.WriteString("\nevent: ")
+    buf.WriteString(string(e.Type))
+    buf.WriteString("\ndata: ")
+    buf.Write(data)
+    buf.WriteString("\n\n")
+    return w.write(buf.Bytes())
+}
+
+func (w *sseWriter) Ping() error { return w.write([]byte(": ping\n\n")) }
+
+func (w *sseWriter) Done() <-chan struct{} { return w.done }
+
+func (w *sseWriter) Close() error { return nil }
+
+func (w *sseWriter) write(b []byte) error {
+    if _, err := w.w.Write(b); err != nil { return err }
+    return w.w.Flush()
+}
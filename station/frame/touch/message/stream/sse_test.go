@@ -0,0 +1,40 @@
+package stream
+
+import (
+    "testing"
+
+    "github.com/cloudwego/hertz/pkg/network"
+    hznetpoll "github.com/cloudwego/hertz/pkg/network/netpoll"
+    "github.com/cloudwego/netpoll"
+)
+
+// hangupConn stands for a netpoll connection, the methods of network.Conn are not called.
+type hangupConn struct {
+    network.Conn
+    callback netpoll.CloseCallback
+}
+
+func (c *hangupConn) AddCloseCallback(callback netpoll.CloseCallback) error {
+    c.callback = callback
+    return nil
+}
+
+func TestHangup(t *testing.T) {
+    conn := &hangupConn{}
+    done := hangup(&hznetpoll.Conn{Conn: conn})
+    if done == nil || conn.callback == nil { t.Fatal("hangup of a netpoll connection is not watched") }
+    select {
+    case <-done:
+        t.Fatal("done before the hangup")
+    default:
+    }
+    _ = conn.callback(nil)
+    _ = conn.callback(nil)
+    select {
+    case <-done:
+    default:
+        t.Fatal("not done after the hangup")
+    }
+
+    if done := hangup(struct{ network.Conn }{}); done != nil { t.Fatal("hangup of a connection that can't tell is watched") }
+}
@@ -0,0 +1,160 @@
+package stream
+
+import (
+    "crypto/sha1"
+    "encoding/base64"
+    "encoding/binary"
+    "encoding/json"
+    "errors"
+    "io"
+    "net/http"
+    "strings"
+    "sync"
+
+    "github.com/cloudwego/hertz/pkg/app"
+    "github.com/cloudwego/hertz/pkg/network"
+)
+
+// websocketGUID is the magic value of RFC 6455 section 1.3.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+    opText  = 0x1
+    opClose = 0x8
+    opPing  = 0x9
+    opPong  = 0xA
+
+    // maxControlPayload is the largest payload we accept from clients. The stream is
+    // server push only, so anything bigger than a control frame is unexpected.
+    maxControlPayload = 4096
+)
+
+var (
+    ErrBadHandshake = errors.New("bad websocket handshake")
+    ErrClosed       = errors.New("websocket closed")
+)
+
+// IsWebSocketUpgrade reports whether the request asks for a WebSocket upgrade.
+func IsWebSocketUpgrade(ctx *app.RequestContext) bool {
+    return strings.EqualFold(string(ctx.Request.Header.Peek("Upgrade")), "websocket") &&
+        strings.Contains(strings.ToLower(string(ctx.Request.Header.Peek("Connection"))), "upgrade")
+}
+
+// UpgradeWebSocket answers the handshake and runs serve on the hijacked connection once
+// the handler has returned. serve must not retain ctx.
+func UpgradeWebSocket(ctx *app.RequestContext, serve func(w Writer)) error {
+    key := string(ctx.Request.Header.Peek("Sec-WebSocket-Key"))
+    if key == "" || string(ctx.Request.Header.Peek("Sec-WebSocket-Version")) != "13" { return ErrBadHandshake }
+
+    ctx.SetStatusCode(http.StatusSwitchingProtocols)
+    ctx.Response.Header.Set("Upgrade", "websocket")
+    ctx.Response.Header.Set("Connection", "Upgrade")
+    ctx.Response.Header.Set("Sec-WebSocket-Accept", websocketAccept(key))
+    ctx.Hijack(func(conn network.Conn) {
+        ws := &wsWriter{conn: conn, done: make(chan struct{})}
+        go ws.readLoop()
+        serve(ws)
+        ws.Close()
+    })
+    return nil
+}
+
+func websocketAccept(key string) string {
+    h := sha1.New()
+    h.Write([]byte(key + websocketGUID))
+    return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// wsWriter implements Writer with unmasked server frames of RFC 6455.
+type wsWriter struct {
+    conn network.Conn
+    mu   sync.Mutex
+    done chan struct{}
+    once sync.Once
+}
+
+func (w *wsWriter) WriteEvent(e *Event) error {
+    data, err := json.Marshal(e)
+    if err != nil { return err }
+    return w.writeFrame(opText, data)
+}
+
+func (w *wsWriter) Ping() error { return w.writeFrame(opPing, nil) }
+
+func (w *wsWriter) Done() <-chan struct{} { return w.done }
+
+func (w *wsWriter) Close() error {
+    w.once.Do(func() {
+        close(w.done)
+        _ = w.writeFrame(opClose, nil)
+        _ = w.conn.Close()
+    })
+    return nil
+}
+
+func (w *wsWriter) writeFrame(op byte, payload []byte) error {
+    if op != opClose {
+        select {
+        case <-w.done:
+            return ErrClosed
+        default:
+        }
+    }
+
+    w.mu.Lock()
+    defer w.mu.Unlock()
+
+    header := make([]byte, 2, 10)
+    header[0] = 0x80 | op // FIN
+    switch n := len(payload); {
+    case n < 126:
+        header[1] = byte(n)
+    case n <= 0xffff:
+        header[1] = 126
+        header = binary.BigEndian.AppendUint16(header, uint16(n))
+    default:
+        header[1] = 127
+        header = binary.BigEndian.AppendUint64(header, uint64(n))
+    }
+
+    if _, err := w.conn.Write(append(header, payload...)); err != nil { return err }
+    return nil
+}
+
+// readLoop consumes client frames: it answers pings and stops on close or error.
+func (w *wsWriter) readLoop() {
+    defer w.Close()
+
+    var head [2]byte
+    for {
+        if _, err := io.ReadFull(w.conn, head[:]); err != nil { return }
+
+        op := head[0] & 0x0f
+        masked := head[1]&0x80 != 0
+        n := uint64(head[1] & 0x7f)
+        switch n {
+        case 126:
+            var ext [2]byte
+            if _, err := io.ReadFull(w.conn, ext[:]); err != nil { return }
+            n = uint64(binary.BigEndian.Uint16(ext[:]))
+        case 127:
+            var ext [8]byte
+            if _, err := io.ReadFull(w.conn, ext[:]); err != nil { return }
+            n = binary.BigEndian.Uint64(ext[:])
+        }
+        if n > maxControlPayload || !masked { return }
+
+        var mask [4]byte
+        if _, err := io.ReadFull(w.conn, mask[:]); err != nil { return }
+        payload := make([]byte, n)
+        if _, err := io.ReadFull(w.conn, payload); err != nil { return }
+        for i := range payload { payload[i] ^= mask[i%4] }
+
+        switch op {
+        case opClose:
+            return
+        case opPing:
+            if err := w.writeFrame(opPong, payload); err != nil { return }
+        }
+    }
+}
@@ -0,0 +1,85 @@
+package touch
+
+import (
+    "context"
+    "errors"
+    "net/http"
+
+    "github.com/cloudwego/hertz/pkg/app"
+    log "github.com/peers-touch/peers-touch/station/frame/core/logger"
+    "github.com/peers-touch/peers-touch/station/frame/touch/actor"
+    "github.com/peers-touch/peers-touch/station/frame/touch/auth"
+    "github.com/peers-touch/peers-touch/station/frame/touch/message/service"
+    "github.com/peers-touch/peers-touch/station/frame/touch/model"
+)
+
+// messageAccess is what a message endpoint requires from its caller.
+type messageAccess int
+
+const (
+    // accessActor requires an authenticated actor only.
+    accessActor messageAccess = iota
+    // accessMember requires the actor to be a member of the :id conversation.
+    accessMember
+    // accessManager requires a member whose role may change members and rotate keys.
+    accessManager
+)
+
+const (
+    ctxKeyActorDID   = "actor_did"
+    ctxKeyConvAccess = "conv_access"
+)
+
+// withMessageAccess authenticates the caller with the auth middleware and checks its
+// membership before calling next. Handlers read the caller with actorDID and convAccess.
+func withMessageAccess(access messageAccess, next func(context.Context, *app.RequestContext)) func(context.Context, *app.RequestContext) {
+    return func(c context.Context, ctx *app.RequestContext) {
+        mw, err := auth.DefaultMiddleware(c)
+        if err != nil { log.Errorf(c, "init auth middleware failed: %v", err); accessFailed(ctx, err); return }
+        info := mw.Authenticate(c, ctx)
+        if info == nil { accessFailed(ctx, model.ErrUnauthenticated); return }
+        a, err := actor.GetUserByID(c, info.ActorID)
+        if err != nil { log.Warnf(c, "load authenticated actor %d failed: %v", info.ActorID, err); accessFailed(ctx, model.ErrUnauthenticated); return }
+        did := a.DID()
+        ctx.Set(ctxKeyActorDID, did)
+
+        if access >= accessMember {
+            ca, err := service.NewAccessService().Check(c, ctx.Param("id"), did, access == accessManager)
+            if err != nil { accessFailed(ctx, err); return }
+            ctx.Set(ctxKeyConvAccess, ca)
+        }
+        next(c, ctx)
+    }
+}
+
+// actorDID returns the DID of the authenticated caller.
+func actorDID(ctx *app.RequestContext) string { return ctx.GetString(ctxKeyActorDID) }
+
+// convAccess returns the membership of the caller checked by withMessageAccess.
+func convAccess(ctx *app.RequestContext) *service.Access {
+    v, _ := ctx.Get(ctxKeyConvAccess)
+    a, _ := v.(*service.Access)
+    return a
+}
+
+// checkConvAccess checks the caller membership of a conversation that is not named by
+// the route, e.g. the one an attachment belongs to.
+func checkConvAccess(c context.Context, ctx *app.RequestContext, convID string) error {
+    _, err := service.NewAccessService().Check(c, convID, actorDID(ctx), false)
+    return err
+}
+
+func accessFailed(ctx *app.RequestContext, err error) {
+    status := http.StatusInternalServerError
+    switch {
+    case errors.Is(err, model.ErrUnauthenticated):
+        status = http.StatusUnauthorized
+    case errors.Is(err, model.ErrConvNotMember), errors.Is(err, model.ErrConvForbidden), errors.Is(err, model.ErrActivityPubNotOwner), errors.Is(err, model.ErrNotAdmin):
+        status = http.StatusForbidden
+    case errors.Is(err, model.ErrConvNotFound):
+        status = http.StatusNotFound
+    }
+    var e *model.Error
+    if !errors.As(err, &e) { e = model.UndefinedError(err) }
+    ctx.AbortWithStatusJSON(status, e)
+}
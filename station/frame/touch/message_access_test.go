@@ -0,0 +1,118 @@
+package touch
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "testing"
+
+    "github.com/cloudwego/hertz/pkg/app"
+    "github.com/cloudwego/hertz/pkg/route/param"
+    "github.com/peers-touch/peers-touch/station/frame/core/server"
+    "github.com/peers-touch/peers-touch/station/frame/touch/auth"
+    "github.com/peers-touch/peers-touch/station/frame/touch/internal/storetest"
+    "github.com/peers-touch/peers-touch/station/frame/touch/message/service"
+    "github.com/peers-touch/peers-touch/station/frame/touch/model"
+    m "github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+    "golang.org/x/crypto/bcrypt"
+    "gorm.io/gorm"
+)
+
+// testActor is a local actor with an access token.
+type testActor struct {
+    did   string
+    token string
+}
+
+func newTestActor(t *testing.T, rds *gorm.DB, name string) *testActor {
+    t.Helper()
+    hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+    if err != nil { t.Fatal(err) }
+    a := &m.Actor{PeersActorID: name, Name: name, Email: name + "@station.example", PasswordHash: string(hash)}
+    if err := rds.Create(a).Error; err != nil { t.Fatal(err) }
+    res, err := auth.NewJWTProvider(rds, testJWTSecret, 0, 0).Authenticate(context.Background(), &auth.Credentials{Email: a.Email, Password: "secret"})
+    if err != nil { t.Fatal(err) }
+    return &testActor{did: a.DID(), token: res.AccessToken}
+}
+
+// callMessageRoute calls the handler of the message route as a, nil calls it anonymously,
+// and returns the status and the error code of the response.
+func callMessageRoute(t *testing.T, a *testActor, method server.Method, route RouterPath, convID string, body interface{}) (int, string) {
+    t.Helper()
+    var handler func(context.Context, *app.RequestContext)
+    for _, info := range GetMessageHandlers() {
+        if info.RouterURL == route && info.Method == method { handler = info.Handler }
+    }
+    if handler == nil { t.Fatalf("no %s %s route", method, route) }
+
+    ctx := app.NewContext(0)
+    ctx.Request.Header.SetMethod(string(method))
+    ctx.Request.SetRequestURI("/message" + string(route))
+    ctx.Params = append(ctx.Params, param.Param{Key: "id", Value: convID})
+    if a != nil { ctx.Request.Header.Set("Authorization", "Bearer "+a.token) }
+    if body != nil {
+        b, err := json.Marshal(body)
+        if err != nil { t.Fatal(err) }
+        ctx.Request.Header.SetContentTypeBytes([]byte("application/json"))
+        ctx.Request.SetBody(b)
+        ctx.Request.Header.SetContentLength(len(b))
+    }
+    handler(context.Background(), ctx)
+
+    var e struct{ Code string `json:"code"` }
+    if ctx.Response.StatusCode() != http.StatusOK { _ = json.Unmarshal(ctx.Response.Body(), &e) }
+    return ctx.Response.StatusCode(), e.Code
+}
+
+func TestMessageAccess(t *testing.T) {
+    rds := storetest.Reset(t)
+    alice, bob, carol := newTestActor(t, rds, "alice"), newTestActor(t, rds, "bob"), newTestActor(t, rds, "carol")
+    if _, err := service.NewConversationService().Create(context.Background(), &service.CreateConvReq{ConvID: "conv-access", Type: "group", Title: "access", OwnerDID: alice.did, Members: []string{bob.did}}); err != nil { t.Fatal(err) }
+
+    for _, tc := range []struct {
+        name   string
+        who    *testActor
+        method server.Method
+        route  RouterPath
+        conv   string
+        body   interface{}
+        status int
+        code   string
+    }{
+        {"anonymous list", nil, server.GET, MessageRouterURLListMsg, "conv-access", nil, http.StatusUnauthorized, model.ErrUnauthenticated.Code},
+        {"non-member list", carol, server.GET, MessageRouterURLListMsg, "conv-access", nil, http.StatusForbidden, model.ErrConvNotMember.Code},
+        {"non-member append", carol, server.POST, MessageRouterURLAppendMsg, "conv-access", map[string]string{"type": "text", "body": "let me in"}, http.StatusForbidden, model.ErrConvNotMember.Code},
+        {"non-member stream", carol, server.GET, MessageRouterURLStream, "conv-access", nil, http.StatusForbidden, model.ErrConvNotMember.Code},
+        {"non-member members", carol, server.GET, MessageRouterURLMembers, "conv-access", nil, http.StatusForbidden, model.ErrConvNotMember.Code},
+        {"unknown conversation", alice, server.GET, MessageRouterURLListMsg, "conv-unknown", nil, http.StatusNotFound, model.ErrConvNotFound.Code},
+        {"member list", bob, server.GET, MessageRouterURLListMsg, "conv-access", nil, http.StatusOK, ""},
+        {"member reads the members", bob, server.GET, MessageRouterURLMembers, "conv-access", nil, http.StatusOK, ""},
+        {"member changes the members", bob, server.POST, MessageRouterURLMembers, "conv-access", map[string]interface{}{"add": []string{carol.did}}, http.StatusForbidden, model.ErrConvForbidden.Code},
+        {"member rotates the key", bob, server.POST, MessageRouterURLKeyRotate, "conv-access", map[string]interface{}{}, http.StatusForbidden, model.ErrConvForbidden.Code},
+        // the owner gets past the access check, the conversation has no key to rotate
+        {"owner rotates the key", alice, server.POST, MessageRouterURLKeyRotate, "conv-access", map[string]interface{}{}, http.StatusBadRequest, model.ErrConvNotEncrypted.Code},
+        {"owner changes the members", alice, server.POST, MessageRouterURLMembers, "conv-access", map[string]interface{}{"add": []string{carol.did}}, http.StatusOK, ""},
+        {"new member list", carol, server.GET, MessageRouterURLListMsg, "conv-access", nil, http.StatusOK, ""},
+    } {
+        status, code := callMessageRoute(t, tc.who, tc.method, tc.route, tc.conv, tc.body)
+        if status != tc.status || code != tc.code { t.Errorf("%s: %d %s, want %d %s", tc.name, status, code, tc.status, tc.code) }
+    }
+
+    page, err := service.NewMessageService().List(context.Background(), &service.ListReq{ConvID: "conv-access"})
+    if err != nil { t.Fatal(err) }
+    if len(page.Messages) != 0 { t.Fatalf("messages of non-members stored: %+v", page.Messages) }
+}
+
+func TestAppendMessageSender(t *testing.T) {
+    rds := storetest.Reset(t)
+    alice, bob := newTestActor(t, rds, "alice"), newTestActor(t, rds, "bob")
+    if _, err := service.NewConversationService().Create(context.Background(), &service.CreateConvReq{ConvID: "conv-sender", Type: "group", Title: "sender", OwnerDID: alice.did, Members: []string{bob.did}}); err != nil { t.Fatal(err) }
+
+    // bob claims to be alice
+    body := map[string]string{"type": "text", "body": "it was alice", "sender_did": alice.did}
+    if status, code := callMessageRoute(t, bob, server.POST, MessageRouterURLAppendMsg, "conv-sender", body); status != http.StatusOK { t.Fatalf("append: %d %s", status, code) }
+
+    page, err := service.NewMessageService().List(context.Background(), &service.ListReq{ConvID: "conv-sender"})
+    if err != nil { t.Fatal(err) }
+    if len(page.Messages) != 1 || page.Messages[0].SenderDID != bob.did || page.Messages[0].Body != "it was alice" { t.Fatalf("messages = %+v", page.Messages) }
+}
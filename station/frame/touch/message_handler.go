@@ -1,16 +1,26 @@
 package touch
 
 import (
+    "bytes"
     "context"
+    "mime"
+    "net/http"
     "strconv"
     "time"
 
     "github.com/cloudwego/hertz/pkg/app"
     "github.com/peers-touch/peers-touch/station/frame/core/server"
+    "github.com/peers-touch/peers-touch/station/frame/touch/message/blob"
+    "github.com/peers-touch/peers-touch/station/frame/touch/model"
     m "github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+    "github.com/peers-touch/peers-touch/station/frame/touch/message/search"
     "github.com/peers-touch/peers-touch/station/frame/touch/message/service"
+    "github.com/peers-touch/peers-touch/station/frame/touch/message/stream"
 )
 
+// headerUploadOffset carries the bytes of an upload received before a chunk.
+const headerUploadOffset = "Upload-Offset"
+
 type MessageHandlerInfo struct {
     RouterURL RouterPath
     Handler   func(context.Context, *app.RequestContext)
@@ -18,115 +28,226 @@ type MessageHandlerInfo struct {
     Wrappers  []server.Wrapper
 }
 
+// GetMessageHandlers returns the message endpoints. Every handler runs behind
+// withMessageAccess, so the caller is authenticated and, but for the ones creating or
+// resolving their conversation themselves, a member of the :id conversation.
 func GetMessageHandlers() []MessageHandlerInfo {
     commonWrapper := CommonAccessControlWrapper(RoutersNameMessage)
 
     return []MessageHandlerInfo{
-        {RouterURL: MessageRouterURLCreateConv, Handler: CreateConv, Method: server.POST, Wrappers: []server.Wrapper{commonWrapper}},
-        {RouterURL: MessageRouterURLGetConv, Handler: GetConv, Method: server.GET, Wrappers: []server.Wrapper{commonWrapper}},
-        {RouterURL: MessageRouterURLGetConvState, Handler: GetConvState, Method: server.GET, Wrappers: []server.Wrapper{commonWrapper}},
-        {RouterURL: MessageRouterURLMembers, Handler: UpdateMembers, Method: server.POST, Wrappers: []server.Wrapper{commonWrapper}},
-        {RouterURL: MessageRouterURLMembers, Handler: GetMembers, Method: server.GET, Wrappers: []server.Wrapper{commonWrapper}},
-        {RouterURL: MessageRouterURLKeyRotate, Handler: KeyRotate, Method: server.POST, Wrappers: []server.Wrapper{commonWrapper}},
-        {RouterURL: MessageRouterURLAppendMsg, Handler: AppendMessage, Method: server.POST, Wrappers: []server.Wrapper{commonWrapper}},
-        {RouterURL: MessageRouterURLListMsg, Handler: ListMessages, Method: server.GET, Wrappers: []server.Wrapper{commonWrapper}},
-        {RouterURL: MessageRouterURLStream, Handler: StreamMessages, Method: server.GET, Wrappers: []server.Wrapper{commonWrapper}},
-        {RouterURL: MessageRouterURLReceipt, Handler: PostReceipt, Method: server.POST, Wrappers: []server.Wrapper{commonWrapper}},
-        {RouterURL: MessageRouterURLReceipts, Handler: GetReceipts, Method: server.GET, Wrappers: []server.Wrapper{commonWrapper}},
-        {RouterURL: MessageRouterURLAttach, Handler: PostAttachment, Method: server.POST, Wrappers: []server.Wrapper{commonWrapper}},
-        {RouterURL: MessageRouterURLGetAttach, Handler: GetAttachment, Method: server.GET, Wrappers: []server.Wrapper{commonWrapper}},
-        {RouterURL: MessageRouterURLSearch, Handler: SearchMessages, Method: server.GET, Wrappers: []server.Wrapper{commonWrapper}},
-        {RouterURL: MessageRouterURLSnapshot, Handler: GetSnapshot, Method: server.GET, Wrappers: []server.Wrapper{commonWrapper}},
-        {RouterURL: MessageRouterURLSnapshot, Handler: PostSnapshot, Method: server.POST, Wrappers: []server.Wrapper{commonWrapper}},
+        {RouterURL: MessageRouterURLCreateConv, Handler: withMessageAccess(accessActor, CreateConv), Method: server.POST, Wrappers: []server.Wrapper{commonWrapper}},
+        {RouterURL: MessageRouterURLGetConv, Handler: withMessageAccess(accessMember, GetConv), Method: server.GET, Wrappers: []server.Wrapper{commonWrapper}},
+        {RouterURL: MessageRouterURLGetConvState, Handler: withMessageAccess(accessMember, GetConvState), Method: server.GET, Wrappers: []server.Wrapper{commonWrapper}},
+        {RouterURL: MessageRouterURLMembers, Handler: withMessageAccess(accessManager, UpdateMembers), Method: server.POST, Wrappers: []server.Wrapper{commonWrapper}},
+        {RouterURL: MessageRouterURLMembers, Handler: withMessageAccess(accessMember, GetMembers), Method: server.GET, Wrappers: []server.Wrapper{commonWrapper}},
+        {RouterURL: MessageRouterURLKeyRotate, Handler: withMessageAccess(accessManager, KeyRotate), Method: server.POST, Wrappers: []server.Wrapper{commonWrapper}},
+        {RouterURL: MessageRouterURLKeyEpoch, Handler: withMessageAccess(accessMember, GetKeyEpoch), Method: server.GET, Wrappers: []server.Wrapper{commonWrapper}},
+        {RouterURL: MessageRouterURLMemberKeys, Handler: withMessageAccess(accessMember, GetMemberKeys), Method: server.GET, Wrappers: []server.Wrapper{commonWrapper}},
+        {RouterURL: MessageRouterURLKeys, Handler: withMessageAccess(accessActor, PutKey), Method: server.PUT, Wrappers: []server.Wrapper{commonWrapper}},
+        {RouterURL: MessageRouterURLKeys, Handler: withMessageAccess(accessActor, GetKeys), Method: server.GET, Wrappers: []server.Wrapper{commonWrapper}},
+        {RouterURL: MessageRouterURLAppendMsg, Handler: withMessageAccess(accessMember, AppendMessage), Method: server.POST, Wrappers: []server.Wrapper{commonWrapper}},
+        {RouterURL: MessageRouterURLListMsg, Handler: withMessageAccess(accessMember, ListMessages), Method: server.GET, Wrappers: []server.Wrapper{commonWrapper}},
+        {RouterURL: MessageRouterURLMsg, Handler: withMessageAccess(accessMember, EditMessage), Method: server.PUT, Wrappers: []server.Wrapper{commonWrapper}},
+        {RouterURL: MessageRouterURLMsg, Handler: withMessageAccess(accessMember, DeleteMessage), Method: server.DELETE, Wrappers: []server.Wrapper{commonWrapper}},
+        {RouterURL: MessageRouterURLMsgRevisions, Handler: withMessageAccess(accessMember, ListMessageRevisions), Method: server.GET, Wrappers: []server.Wrapper{commonWrapper}},
+        {RouterURL: MessageRouterURLReactions, Handler: withMessageAccess(accessMember, PostReaction), Method: server.POST, Wrappers: []server.Wrapper{commonWrapper}},
+        {RouterURL: MessageRouterURLReactions, Handler: withMessageAccess(accessMember, GetReactions), Method: server.GET, Wrappers: []server.Wrapper{commonWrapper}},
+        {RouterURL: MessageRouterURLStream, Handler: withMessageAccess(accessMember, StreamMessages), Method: server.GET, Wrappers: []server.Wrapper{commonWrapper}},
+        {RouterURL: MessageRouterURLReceipt, Handler: withMessageAccess(accessMember, PostReceipt), Method: server.POST, Wrappers: []server.Wrapper{commonWrapper}},
+        {RouterURL: MessageRouterURLReceipts, Handler: withMessageAccess(accessMember, GetReceipts), Method: server.GET, Wrappers: []server.Wrapper{commonWrapper}},
+        {RouterURL: MessageRouterURLUnread, Handler: withMessageAccess(accessMember, GetUnread), Method: server.GET, Wrappers: []server.Wrapper{commonWrapper}},
+        {RouterURL: MessageRouterURLAttach, Handler: withMessageAccess(accessMember, PostAttachment), Method: server.POST, Wrappers: []server.Wrapper{commonWrapper}},
+        {RouterURL: MessageRouterURLUpload, Handler: withMessageAccess(accessMember, GetUpload), Method: server.GET, Wrappers: []server.Wrapper{commonWrapper}},
+        {RouterURL: MessageRouterURLUpload, Handler: withMessageAccess(accessMember, PatchUpload), Method: server.PATCH, Wrappers: []server.Wrapper{commonWrapper}},
+        {RouterURL: MessageRouterURLUpload, Handler: withMessageAccess(accessMember, DeleteUpload), Method: server.DELETE, Wrappers: []server.Wrapper{commonWrapper}},
+        {RouterURL: MessageRouterURLGetAttach, Handler: withMessageAccess(accessActor, GetAttachment), Method: server.GET, Wrappers: []server.Wrapper{commonWrapper}},
+        {RouterURL: MessageRouterURLAttachMeta, Handler: withMessageAccess(accessActor, GetAttachmentMeta), Method: server.GET, Wrappers: []server.Wrapper{commonWrapper}},
+        {RouterURL: MessageRouterURLSearch, Handler: withMessageAccess(accessMember, SearchMessages), Method: server.GET, Wrappers: []server.Wrapper{commonWrapper}},
+        {RouterURL: MessageRouterURLSnapshot, Handler: withMessageAccess(accessMember, GetSnapshot), Method: server.GET, Wrappers: []server.Wrapper{commonWrapper}},
+        {RouterURL: MessageRouterURLSnapshot, Handler: withMessageAccess(accessActor, PostSnapshot), Method: server.POST, Wrappers: []server.Wrapper{commonWrapper}},
     }
 }
 
 func CreateConv(c context.Context, ctx *app.RequestContext) {
-    var p struct{ ConvID string `json:"conv_id"`; Type string `json:"type"`; Title string `json:"title"`; AvatarCID string `json:"avatar_cid"`; Policy string `json:"policy"` }
+    var p struct{ ConvID string `json:"conv_id"`; Type string `json:"type"`; Title string `json:"title"`; AvatarCID string `json:"avatar_cid"`; Policy string `json:"policy"`; Encrypted bool `json:"encrypted"` }
     if err := ctx.Bind(&p); err != nil { FailedResponse(ctx, err); return }
     svc := service.NewConversationService()
-    conv, err := svc.Create(c, &service.CreateConvReq{ConvID: p.ConvID, Type: p.Type, Title: p.Title, AvatarCID: p.AvatarCID, Policy: p.Policy})
+    conv, err := svc.Create(c, &service.CreateConvReq{ConvID: p.ConvID, Type: p.Type, Title: p.Title, AvatarCID: p.AvatarCID, Policy: p.Policy, Encrypted: p.Encrypted, OwnerDID: actorDID(ctx)})
     if err != nil { FailedResponse(ctx, err); return }
     SuccessResponse(ctx, "", conv)
 }
 
 func GetConv(c context.Context, ctx *app.RequestContext) {
-    id := ctx.Param("id")
-    svc := service.NewConversationService()
-    conv, err := svc.Get(c, id)
-    if err != nil { FailedResponse(ctx, err); return }
-    SuccessResponse(ctx, "", conv)
+    SuccessResponse(ctx, "", convAccess(ctx).Conv)
 }
 
 func GetConvState(c context.Context, ctx *app.RequestContext) {
-    id := ctx.Param("id")
-    svc := service.NewConversationService()
-    conv, err := svc.Get(c, id)
-    if err != nil { FailedResponse(ctx, err); return }
-    SuccessResponse(ctx, "", map[string]interface{}{ "epoch": conv.Epoch })
+    SuccessResponse(ctx, "", map[string]interface{}{ "epoch": convAccess(ctx).Conv.Epoch })
 }
 
+// UpdateMembers adds and removes members. In encrypted conversations added members come
+// with their wraps of the current key and removing members takes the rotation to the next
+// epoch.
 func UpdateMembers(c context.Context, ctx *app.RequestContext) {
-    var p struct{ ConvPK uint64 `json:"conv_pk"`; Add []string `json:"add"`; Remove []string `json:"remove"`; Role string `json:"role"` }
+    var p struct{ Add []string `json:"add"`; Remove []string `json:"remove"`; Role string `json:"role"`; Wraps map[string]string `json:"wraps"`; Rotate *service.RotateReq `json:"rotate"` }
     if err := ctx.Bind(&p); err != nil { FailedResponse(ctx, err); return }
     svc := service.NewConversationService()
-    if len(p.Add) > 0 { if err := svc.AddMembers(c, p.ConvPK, p.Add, m.Role(p.Role)); err != nil { FailedResponse(ctx, err); return } }
-    if len(p.Remove) > 0 { if err := svc.RemoveMembers(c, p.ConvPK, p.Remove); err != nil { FailedResponse(ctx, err); return } }
+    a := convAccess(ctx)
+    if len(p.Add) > 0 { if err := svc.AddMembers(c, a, p.Add, m.Role(p.Role), p.Wraps); err != nil { FailedResponse(ctx, err); return } }
+    if len(p.Remove) > 0 { if err := svc.RemoveMembers(c, a, p.Remove, p.Rotate); err != nil { FailedResponse(ctx, err); return } }
     SuccessResponse(ctx, "", map[string]interface{}{"ok": true})
 }
 
 func GetMembers(c context.Context, ctx *app.RequestContext) {
-    var p struct{ ConvPK uint64 `json:"conv_pk"` }
-    if err := ctx.Bind(&p); err != nil { FailedResponse(ctx, err); return }
     svc := service.NewConversationService()
-    list, err := svc.Members(c, p.ConvPK)
+    list, err := svc.Members(c, convAccess(ctx).Conv.ID)
     if err != nil { FailedResponse(ctx, err); return }
     SuccessResponse(ctx, "", list)
 }
 
+// KeyRotate moves an encrypted conversation to the next key epoch, the body carries the new
+// key wrapped for every member.
 func KeyRotate(c context.Context, ctx *app.RequestContext) {
-    id := ctx.Param("id")
-    svc := service.NewConversationService()
-    if err := svc.KeyRotate(c, id); err != nil { FailedResponse(ctx, err); return }
-    SuccessResponse(ctx, "", map[string]interface{}{"ok": true})
+    var p service.RotateReq
+    if err := ctx.Bind(&p); err != nil { FailedResponse(ctx, err); return }
+    svc := service.NewKeyService()
+    k, err := svc.Rotate(c, convAccess(ctx), &p)
+    if err != nil { FailedResponse(ctx, err); return }
+    SuccessResponse(ctx, "", k)
+}
+
+// GetKeyEpoch returns the caller wrap of the ?epoch key, the current one by default.
+func GetKeyEpoch(c context.Context, ctx *app.RequestContext) {
+    var epoch int
+    if v := string(ctx.QueryArgs().Peek("epoch")); v != "" { if n, err := strconv.Atoi(v); err == nil { epoch = n } }
+    svc := service.NewKeyService()
+    k, err := svc.Epoch(c, convAccess(ctx), epoch)
+    if err != nil { FailedResponse(ctx, err); return }
+    SuccessResponse(ctx, "", k)
+}
+
+// GetMemberKeys returns the public keys of the members to wrap a new epoch key for.
+func GetMemberKeys(c context.Context, ctx *app.RequestContext) {
+    svc := service.NewKeyService()
+    list, err := svc.MemberKeys(c, convAccess(ctx))
+    if err != nil { FailedResponse(ctx, err); return }
+    SuccessResponse(ctx, "", list)
+}
+
+// GetKeys returns the published keys of the ?did DIDs, e.g. of members about to be added.
+func GetKeys(c context.Context, ctx *app.RequestContext) {
+    var dids []string
+    for _, v := range ctx.QueryArgs().PeekAll("did") { dids = append(dids, string(v)) }
+    svc := service.NewKeyService()
+    list, err := svc.Keys(c, dids)
+    if err != nil { FailedResponse(ctx, err); return }
+    SuccessResponse(ctx, "", list)
+}
+
+// PutKey publishes the public key of the caller.
+func PutKey(c context.Context, ctx *app.RequestContext) {
+    var p struct{ Alg string `json:"alg"`; PublicKey string `json:"public_key"` }
+    if err := ctx.Bind(&p); err != nil { FailedResponse(ctx, err); return }
+    svc := service.NewKeyService()
+    k, err := svc.PublishKey(c, actorDID(ctx), p.Alg, p.PublicKey)
+    if err != nil { FailedResponse(ctx, err); return }
+    SuccessResponse(ctx, "", k)
 }
 
 func AppendMessage(c context.Context, ctx *app.RequestContext) {
-    var p struct{ ULID string `json:"ulid"`; SenderDID string `json:"sender_did"`; Type string `json:"type"`; ParentID string `json:"parent_id"`; ThreadID string `json:"thread_id"`; ContentCID string `json:"content_cid"`; TTLMillis int64 `json:"ttl_ms"` }
+    var p struct{ ULID string `json:"ulid"`; Type string `json:"type"`; ParentID string `json:"parent_id"`; ThreadID string `json:"thread_id"`; ContentCID string `json:"content_cid"`; Body string `json:"body"`; TTLMillis int64 `json:"ttl_ms"`; Epoch int `json:"epoch"` }
     convID := ctx.Param("id")
     if err := ctx.Bind(&p); err != nil { FailedResponse(ctx, err); return }
     svc := service.NewMessageService()
     now := time.Now().UnixMilli()
-    msg, err := svc.Append(c, &service.AppendReq{ULID: p.ULID, ConvID: convID, SenderDID: p.SenderDID, TS: now, Type: p.Type, ParentID: p.ParentID, ThreadID: p.ThreadID, ContentCID: p.ContentCID, TTLMillis: p.TTLMillis})
+    msg, err := svc.Append(c, &service.AppendReq{ULID: p.ULID, ConvID: convID, SenderDID: actorDID(ctx), TS: now, Type: p.Type, ParentID: p.ParentID, ThreadID: p.ThreadID, ContentCID: p.ContentCID, Body: p.Body, TTLMillis: p.TTLMillis, Epoch: p.Epoch})
     if err != nil { FailedResponse(ctx, err); return }
+    // conversations bridged with remote actors deliver the message over ActivityPub too,
+    // the bridge job of the activitypub package picks it up
     SuccessResponse(ctx, "", msg)
 }
 
 func ListMessages(c context.Context, ctx *app.RequestContext) {
-    convID := ctx.Param("id")
-    afterStr := string(ctx.QueryArgs().Peek("after"))
-    limitStr := string(ctx.QueryArgs().Peek("limit"))
-    var after int64
-    var limit int
-    if afterStr != "" { if v, err := strconv.ParseInt(afterStr, 10, 64); err == nil { after = v } }
-    if limitStr != "" { if v, err := strconv.Atoi(limitStr); err == nil { limit = v } }
+    q := ctx.QueryArgs()
+    req := &service.ListReq{ConvID: ctx.Param("id"), ThreadID: string(q.Peek("thread_id")), ParentID: string(q.Peek("parent_id")), Before: string(q.Peek("before")), After: string(q.Peek("after")), Around: string(q.Peek("around"))}
+    if limitStr := string(q.Peek("limit")); limitStr != "" { if v, err := strconv.Atoi(limitStr); err == nil { req.Limit = v } }
+    svc := service.NewMessageService()
+    page, err := svc.List(c, req)
+    if err != nil { FailedResponse(ctx, err); return }
+    SuccessResponse(ctx, "", page)
+}
+
+func EditMessage(c context.Context, ctx *app.RequestContext) {
+    var p struct{ Body string `json:"body"`; ContentCID string `json:"content_cid"`; Epoch int `json:"epoch"` }
+    if err := ctx.Bind(&p); err != nil { FailedResponse(ctx, err); return }
+    svc := service.NewMessageService()
+    msg, err := svc.Edit(c, &service.EditReq{ConvID: ctx.Param("id"), ULID: ctx.Param("ulid"), EditorDID: actorDID(ctx), Body: p.Body, ContentCID: p.ContentCID, Epoch: p.Epoch})
+    if err != nil { FailedResponse(ctx, err); return }
+    SuccessResponse(ctx, "", msg)
+}
+
+// DeleteMessage soft deletes a message, the tombstone stays in the conversation listing.
+func DeleteMessage(c context.Context, ctx *app.RequestContext) {
+    svc := service.NewMessageService()
+    msg, err := svc.Delete(c, ctx.Param("id"), ctx.Param("ulid"), actorDID(ctx))
+    if err != nil { FailedResponse(ctx, err); return }
+    SuccessResponse(ctx, "", msg)
+}
+
+func ListMessageRevisions(c context.Context, ctx *app.RequestContext) {
     svc := service.NewMessageService()
-    list, err := svc.List(c, convID, after, limit)
+    list, err := svc.Revisions(c, ctx.Param("id"), ctx.Param("ulid"))
     if err != nil { FailedResponse(ctx, err); return }
     SuccessResponse(ctx, "", list)
 }
 
-func StreamMessages(c context.Context, ctx *app.RequestContext) { SuccessResponse(ctx, "", map[string]interface{}{"ok": true}) }
+// PostReaction applies an add or remove op of the member on an emoji of the message.
+func PostReaction(c context.Context, ctx *app.RequestContext) {
+    var p struct{ Emoji string `json:"emoji"`; Op string `json:"op"`; TS int64 `json:"ts"` }
+    if err := ctx.Bind(&p); err != nil { FailedResponse(ctx, err); return }
+    svc := service.NewReactionService()
+    res, err := svc.React(c, &service.ReactReq{ConvID: ctx.Param("id"), MsgULID: ctx.Param("ulid"), MemberDID: actorDID(ctx), Emoji: p.Emoji, Op: p.Op, TS: p.TS})
+    if err != nil { FailedResponse(ctx, err); return }
+    SuccessResponse(ctx, "", res)
+}
 
+// GetReactions returns the per emoji counts of the message and whether the caller reacted.
+func GetReactions(c context.Context, ctx *app.RequestContext) {
+    svc := service.NewReactionService()
+    counts, err := svc.Counts(c, ctx.Param("id"), ctx.Param("ulid"), actorDID(ctx))
+    if err != nil { FailedResponse(ctx, err); return }
+    SuccessResponse(ctx, "", counts)
+}
+
+// StreamMessages keeps the connection open and pushes the conversation events, over
+// WebSocket when the client asks for an upgrade and Server-Sent Events otherwise.
+// Clients resume with the Last-Event-ID header or the cursor query parameter.
+func StreamMessages(c context.Context, ctx *app.RequestContext) {
+    convID := ctx.Param("id")
+    cursor := string(ctx.Request.Header.Peek("Last-Event-ID"))
+    if cursor == "" { cursor = string(ctx.QueryArgs().Peek("cursor")) }
+    svc := service.NewMessageService()
+    sub, initial, err := svc.Subscribe(c, convID, cursor)
+    if err != nil { FailedResponse(ctx, err); return }
+    if stream.IsWebSocketUpgrade(ctx) {
+        if err := stream.UpgradeWebSocket(ctx, func(w stream.Writer) { stream.Serve(w, sub, initial) }); err != nil { sub.Close(); FailedResponse(ctx, err) }
+        return
+    }
+    stream.Serve(stream.NewSSEWriter(ctx), sub, initial)
+}
+
+// PostReceipt moves the caller watermarks up to msg_ulid, or reports with fail_reason that
+// the message could not be delivered.
 func PostReceipt(c context.Context, ctx *app.RequestContext) {
-    var p struct{ MsgULID string `json:"msg_ulid"`; MemberDID string `json:"member_did"`; Delivered bool `json:"delivered"`; Read bool `json:"read"` }
+    var p struct{ MsgULID string `json:"msg_ulid"`; Delivered bool `json:"delivered"`; Read bool `json:"read"`; FailReason string `json:"fail_reason"` }
     if err := ctx.Bind(&p); err != nil { FailedResponse(ctx, err); return }
     svc := service.NewReceiptService()
-    r, err := svc.Post(c, &service.PostReceiptReq{MsgULID: p.MsgULID, MemberDID: p.MemberDID, Delivered: p.Delivered, Read: p.Read})
+    r, err := svc.Post(c, &service.PostReceiptReq{ConvID: ctx.Param("id"), MsgULID: p.MsgULID, MemberDID: actorDID(ctx), Delivered: p.Delivered, Read: p.Read, FailReason: p.FailReason})
     if err != nil { FailedResponse(ctx, err); return }
     SuccessResponse(ctx, "", r)
 }
 
+// GetReceipts returns the member watermarks and the failures reported after ?after.
 func GetReceipts(c context.Context, ctx *app.RequestContext) {
     convID := ctx.Param("id")
     afterStr := string(ctx.QueryArgs().Peek("after"))
@@ -138,27 +259,168 @@ func GetReceipts(c context.Context, ctx *app.RequestContext) {
     SuccessResponse(ctx, "", list)
 }
 
+// GetUnread returns how many messages the caller has not read in the conversation.
+func GetUnread(c context.Context, ctx *app.RequestContext) {
+    svc := service.NewReceiptService()
+    u, err := svc.Unread(c, ctx.Param("id"), actorDID(ctx))
+    if err != nil { FailedResponse(ctx, err); return }
+    SuccessResponse(ctx, "", u)
+}
+
+// PostAttachment opens a resumable upload of an attachment. The body announces its mime,
+// bytes and sha-256 digest, the content follows in chunks with PatchUpload.
 func PostAttachment(c context.Context, ctx *app.RequestContext) {
-    var p struct{ CID string `json:"cid"`; MIME string `json:"mime"`; Bytes int64 `json:"bytes"`; Digest string `json:"digest"`; Store string `json:"store"` }
-    convID := ctx.Param("id")
-    msgID := string(ctx.QueryArgs().Peek("msg_ulid"))
+    var p struct{ CID string `json:"cid"`; MIME string `json:"mime"`; Bytes int64 `json:"bytes"`; Digest string `json:"digest"`; Store string `json:"store"`; MsgULID string `json:"msg_ulid"` }
     if err := ctx.Bind(&p); err != nil { FailedResponse(ctx, err); return }
-    a := &m.Attachment{CID: p.CID, ConvID: convID, MsgULID: msgID, MIME: p.MIME, Bytes: p.Bytes, Digest: p.Digest, Store: p.Store}
+    if p.MsgULID == "" { p.MsgULID = string(ctx.QueryArgs().Peek("msg_ulid")) }
     svc := service.NewAttachmentService()
-    if err := svc.Save(c, a); err != nil { FailedResponse(ctx, err); return }
-    SuccessResponse(ctx, "", a)
+    u, err := svc.CreateUpload(c, &service.UploadReq{ConvID: ctx.Param("id"), MsgULID: p.MsgULID, UploaderDID: actorDID(ctx), MIME: p.MIME, Bytes: p.Bytes, Digest: p.Digest, CID: p.CID, Store: p.Store})
+    if err != nil { FailedResponse(ctx, err); return }
+    SuccessResponse(ctx, "", u)
+}
+
+// GetUpload returns the upload state, a client resumes after its received bytes.
+func GetUpload(c context.Context, ctx *app.RequestContext) {
+    svc := service.NewAttachmentService()
+    u, err := svc.Upload(c, ctx.Param("id"), ctx.Param("upload"), actorDID(ctx))
+    if err != nil { FailedResponse(ctx, err); return }
+    SuccessResponse(ctx, "", u)
+}
+
+// PatchUpload appends the request body at the Upload-Offset header. The chunk reaching
+// the announced size completes the upload and the response carries the attachment; an
+// empty chunk at the full size retries a completion that failed.
+func PatchUpload(c context.Context, ctx *app.RequestContext) {
+    offset, err := strconv.ParseInt(string(ctx.GetHeader(headerUploadOffset)), 10, 64)
+    if err != nil { FailedResponse(ctx, model.ErrUploadOffset); return }
+    svc := service.NewAttachmentService()
+    u, a, err := svc.UploadChunk(c, ctx.Param("id"), ctx.Param("upload"), actorDID(ctx), offset, bytes.NewReader(ctx.Request.Body()))
+    if u != nil { ctx.Header(headerUploadOffset, strconv.FormatInt(u.Received, 10)) }
+    if err != nil { FailedResponse(ctx, err); return }
+    SuccessResponse(ctx, "", map[string]interface{}{"upload": u, "attachment": a})
+}
+
+func DeleteUpload(c context.Context, ctx *app.RequestContext) {
+    svc := service.NewAttachmentService()
+    if err := svc.AbortUpload(c, ctx.Param("id"), ctx.Param("upload"), actorDID(ctx)); err != nil { FailedResponse(ctx, err); return }
+    SuccessResponse(ctx, "", map[string]interface{}{"ok": true})
 }
 
+// GetAttachment streams the attachment content, honouring a single Range of bytes. Only
+// the media types of inlineMIME are shown inline, the others are downloads, so that
+// uploaded markup never runs in the origin of the station.
 func GetAttachment(c context.Context, ctx *app.RequestContext) {
-    cid := ctx.Param("cid")
     svc := service.NewAttachmentService()
-    a, err := svc.Get(c, cid)
+    a, ok := accessibleAttachment(c, ctx, svc)
+    if !ok { return }
+
+    offset, length, partial, err := blob.ParseRange(string(ctx.GetHeader("Range")), a.Bytes)
+    if err != nil {
+        ctx.Header("Content-Range", "bytes */"+strconv.FormatInt(a.Bytes, 10))
+        ctx.AbortWithStatus(http.StatusRequestedRangeNotSatisfiable)
+        return
+    }
+    rc, err := svc.Open(c, a, offset, length)
     if err != nil { FailedResponse(ctx, err); return }
+
+    ctx.Header("Accept-Ranges", "bytes")
+    ctx.Header("ETag", `"`+a.CID+`"`)
+    ctx.Header("Digest", a.Digest)
+    // content never changes under its cid
+    ctx.Header("Cache-Control", "private, max-age=31536000, immutable")
+    ctx.Header("X-Content-Type-Options", "nosniff")
+    ctx.Header("Content-Security-Policy", "default-src 'none'; sandbox")
+    typ := attachmentType(a.MIME)
+    disposition := "attachment"
+    if inlineMIME[typ] { disposition = "inline" }
+    ctx.Header("Content-Disposition", disposition)
+    ctx.SetContentType(typ)
+    status := http.StatusOK
+    if partial {
+        status = http.StatusPartialContent
+        ctx.Header("Content-Range", blob.ContentRange(offset, length, a.Bytes))
+    }
+    ctx.SetStatusCode(status)
+    ctx.SetBodyStream(rc, int(length))
+}
+
+// inlineMIME are the media types browsers render without running anything.
+var inlineMIME = map[string]bool{
+    "image/png": true, "image/jpeg": true, "image/gif": true, "image/webp": true, "image/avif": true,
+    "audio/mpeg": true, "audio/ogg": true, "audio/wav": true, "audio/webm": true, "audio/aac": true,
+    "video/mp4": true, "video/webm": true, "video/ogg": true,
+    "text/plain": true,
+}
+
+// attachmentType returns the media type the uploader announced without its parameters,
+// application/octet-stream when it has none or an invalid one.
+func attachmentType(announced string) string {
+    typ, _, err := mime.ParseMediaType(announced)
+    if err != nil || typ == "" { return "application/octet-stream" }
+    return typ
+}
+
+// GetAttachmentMeta returns the attachment record without its content.
+func GetAttachmentMeta(c context.Context, ctx *app.RequestContext) {
+    a, ok := accessibleAttachment(c, ctx, service.NewAttachmentService())
+    if !ok { return }
     SuccessResponse(ctx, "", a)
 }
 
-func SearchMessages(c context.Context, ctx *app.RequestContext) { SuccessResponse(ctx, "", []interface{}{}) }
+// accessibleAttachment returns the attachment of the content in a conversation the actor
+// has access to, content uploaded to several conversations has one in each. It answers
+// the request itself when there is none.
+func accessibleAttachment(c context.Context, ctx *app.RequestContext, svc *service.AttachmentService) (*m.Attachment, bool) {
+    list, err := svc.List(c, ctx.Param("cid"))
+    if err != nil { FailedResponse(ctx, err); return nil, false }
+    var denied error
+    for _, a := range list {
+        err := checkConvAccess(c, ctx, a.ConvID)
+        if err == nil { return a, true }
+        if denied == nil { denied = err }
+    }
+    accessFailed(ctx, denied)
+    return nil, false
+}
+
+// SearchMessages runs a full-text query over the conversation messages.
+// Query parameters: q, sender, type, from, to (unix millis), limit and offset.
+func SearchMessages(c context.Context, ctx *app.RequestContext) {
+    args := ctx.QueryArgs()
+    q := &search.Query{ConvID: ctx.Param("id"), Text: string(args.Peek("q")), SenderDID: string(args.Peek("sender")), Type: string(args.Peek("type"))}
+    if v, err := strconv.ParseInt(string(args.Peek("from")), 10, 64); err == nil { q.FromTS = v }
+    if v, err := strconv.ParseInt(string(args.Peek("to")), 10, 64); err == nil { q.ToTS = v }
+    if v, err := strconv.Atoi(string(args.Peek("limit"))); err == nil { q.Limit = v }
+    if v, err := strconv.Atoi(string(args.Peek("offset"))); err == nil { q.Offset = v }
+    svc := service.NewSearchService()
+    res, err := svc.Search(c, q)
+    if err != nil { FailedResponse(ctx, err); return }
+    SuccessResponse(ctx, "", res)
+}
 
-func GetSnapshot(c context.Context, ctx *app.RequestContext) { SuccessResponse(ctx, "", map[string]interface{}{"ok": true}) }
+// GetSnapshot returns the stored snapshot named by the cid query parameter, or takes a
+// snapshot of the current state with the newest limit messages.
+func GetSnapshot(c context.Context, ctx *app.RequestContext) {
+    convID := ctx.Param("id")
+    svc := service.NewSnapshotService()
+    if cid := string(ctx.QueryArgs().Peek("cid")); cid != "" {
+        v, err := svc.Get(c, convID, cid)
+        if err != nil { FailedResponse(ctx, err); return }
+        SuccessResponse(ctx, "", v)
+        return
+    }
+    limit, _ := strconv.Atoi(string(ctx.QueryArgs().Peek("limit")))
+    v, err := svc.Take(c, convID, limit)
+    if err != nil { FailedResponse(ctx, err); return }
+    SuccessResponse(ctx, "", v)
+}
 
-func PostSnapshot(c context.Context, ctx *app.RequestContext) { SuccessResponse(ctx, "", map[string]interface{}{"ok": true}) }
\ No newline at end of file
+// PostSnapshot restores a snapshot posted as the request body into the conversation.
+// The optional cid query parameter makes the server verify the content address.
+func PostSnapshot(c context.Context, ctx *app.RequestContext) {
+    convID := ctx.Param("id")
+    svc := service.NewSnapshotService()
+    res, err := svc.Restore(c, convID, actorDID(ctx), ctx.Request.Body(), string(ctx.QueryArgs().Peek("cid")))
+    if err != nil { FailedResponse(ctx, err); return }
+    SuccessResponse(ctx, "", res)
+}
\ No newline at end of file
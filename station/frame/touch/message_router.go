@@ -10,13 +10,22 @@ const (
     MessageRouterURLGetConvState RouterPath = "/conv/:id/state"
     MessageRouterURLMembers      RouterPath = "/conv/:id/members"
     MessageRouterURLKeyRotate    RouterPath = "/conv/:id/key-rotate"
+    MessageRouterURLKeyEpoch     RouterPath = "/conv/:id/key-epoch"
+    MessageRouterURLMemberKeys   RouterPath = "/conv/:id/member-keys"
+    MessageRouterURLKeys         RouterPath = "/keys"
     MessageRouterURLAppendMsg    RouterPath = "/conv/:id/msg"
     MessageRouterURLListMsg      RouterPath = "/conv/:id/msg"
+    MessageRouterURLMsg          RouterPath = "/conv/:id/msg/:ulid"
+    MessageRouterURLMsgRevisions RouterPath = "/conv/:id/msg/:ulid/revisions"
+    MessageRouterURLReactions    RouterPath = "/conv/:id/msg/:ulid/reactions"
     MessageRouterURLStream       RouterPath = "/conv/:id/stream"
     MessageRouterURLReceipt      RouterPath = "/conv/:id/receipt"
     MessageRouterURLReceipts     RouterPath = "/conv/:id/receipts"
+    MessageRouterURLUnread       RouterPath = "/conv/:id/unread"
     MessageRouterURLAttach       RouterPath = "/conv/:id/attach"
+    MessageRouterURLUpload       RouterPath = "/conv/:id/attach/:upload"
     MessageRouterURLGetAttach    RouterPath = "/attach/:cid"
+    MessageRouterURLAttachMeta   RouterPath = "/attach/:cid/meta"
     MessageRouterURLSearch       RouterPath = "/conv/:id/search"
     MessageRouterURLSnapshot     RouterPath = "/conv/:id/snapshot"
 )
@@ -13,7 +13,13 @@ func (r WebFingerResource) Prefix() string {
 }
 
 func (r WebFingerResource) Value() string {
-	return strings.Split(string(r), ":")[1]
+	_, value, _ := strings.Cut(string(r), ":")
+	return value
+}
+
+// IsURL tells whether the resource is the http(s) URL of an actor rather than an account
+func (r WebFingerResource) IsURL() bool {
+	return r.Prefix() == "https" || r.Prefix() == "http"
 }
 
 type WebFingerParams struct {
@@ -27,7 +33,7 @@ func (r WebFingerParams) Check() error {
 		return ErrWellKnownInvalidResourceFormat
 	}
 
-	if r.Resource.Prefix() != "acct" {
+	if r.Resource.Prefix() != "acct" && !r.Resource.IsURL() {
 		return ErrWellKnownUnsupportedPrefixType
 	}
 
@@ -42,6 +48,10 @@ const (
 	DefaultPasswordMaxLength = 20
 )
 
+// ReservedNames are the names of the ActivityPub routes beside those of the users, a user
+// of one of them could not be reached
+var ReservedNames = []string{"inbox", "objects", "context"}
+
 type ActorSignParams struct {
 	Params
 	Name     string `json:"name" form:"name"` // Will be base64 encoded
@@ -61,6 +71,11 @@ func (actor ActorSignParams) Check() error {
 	if err != nil {
 		return err
 	}
+	for _, reserved := range ReservedNames {
+		if strings.EqualFold(actor.Name, reserved) {
+			return ErrActorInvalidName
+		}
+	}
 	actor.Name = encodedName // Update to base64 encoded version
 
 	// Validate email format
@@ -0,0 +1,128 @@
+package actor
+
+import (
+	o "github.com/peers-touch/peers-touch/station/frame/object"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+	ap "github.com/peers-touch/peers-touch/station/frame/vendors/activitypub"
+	"gorm.io/gorm"
+)
+
+// Block blocks the target for the actor: the target gets a Block, the follows between
+// them end and its activities are left out of the timelines of the actor. Blocking twice
+// is a no-op.
+func (f *DefaultActivityPubFacade) Block(actorId o.ID, targetId o.ID) error {
+	return f.db.Transaction(func(tx *gorm.DB) error {
+		actor, blocked, err := loadBlock(tx, actorId, targetId, db.BlockKindBlock)
+		if err != nil || blocked != nil {
+			return err
+		}
+
+		block := ap.ActivityNew("", ap.BlockType, ap.IRI(targetId))
+		block.To = ap.ItemCollection{ap.IRI(targetId)}
+		if err := publish(tx, actor, block); err != nil {
+			return err
+		}
+		var follows []*db.ActivityPubFollow
+		if err := tx.Where("follower_id = ? AND following_id = ? AND is_active = ?", actor.ActivityPubID, string(targetId), true).Find(&follows).Error; err != nil {
+			return err
+		}
+		for _, follow := range follows {
+			if err := tx.Model(follow).Update("is_active", false).Error; err != nil {
+				return err
+			}
+			if err := publishUndo(tx, actor, follow.ActivityID); err != nil {
+				return err
+			}
+		}
+		// the target learns it no longer follows from the Block
+		err = tx.Model(&db.ActivityPubFollow{}).Where("follower_id = ? AND following_id = ? AND is_active = ?", string(targetId), actor.ActivityPubID, true).
+			Updates(map[string]interface{}{"accepted": false, "is_active": false}).Error
+		if err != nil {
+			return err
+		}
+		if err := unfeedActor(tx, actor.ActivityPubID, string(targetId)); err != nil {
+			return err
+		}
+		return tx.Select("*").Create(&db.ActivityPubBlock{
+			ActorID:    actor.ActivityPubID,
+			TargetID:   string(targetId),
+			Kind:       db.BlockKindBlock,
+			ActivityID: string(block.ID),
+		}).Error
+	})
+}
+
+// Unblock lifts a block of the actor and publishes the Undo of its Block
+func (f *DefaultActivityPubFacade) Unblock(actorId o.ID, targetId o.ID) error {
+	return f.db.Transaction(func(tx *gorm.DB) error {
+		actor, blocked, err := loadBlock(tx, actorId, targetId, db.BlockKindBlock)
+		if err != nil || blocked == nil {
+			return err
+		}
+		if err := tx.Delete(blocked).Error; err != nil {
+			return err
+		}
+		return publishUndo(tx, actor, blocked.ActivityID)
+	})
+}
+
+// Mute leaves the activities of the target out of the timelines of the actor, the
+// target is not told. Muting twice is a no-op.
+func (f *DefaultActivityPubFacade) Mute(actorId o.ID, targetId o.ID) error {
+	return f.db.Transaction(func(tx *gorm.DB) error {
+		actor, muted, err := loadBlock(tx, actorId, targetId, db.BlockKindMute)
+		if err != nil || muted != nil {
+			return err
+		}
+		return tx.Select("*").Create(&db.ActivityPubBlock{ActorID: actor.ActivityPubID, TargetID: string(targetId), Kind: db.BlockKindMute}).Error
+	})
+}
+
+// Unmute lifts a mute of the actor
+func (f *DefaultActivityPubFacade) Unmute(actorId o.ID, targetId o.ID) error {
+	return f.db.Where("actor_id = ? AND target_id = ? AND kind = ?", string(actorId), string(targetId), db.BlockKindMute).
+		Delete(&db.ActivityPubBlock{}).Error
+}
+
+// GetBlocks returns the actors the actor blocked, or muted, latest first
+func (f *DefaultActivityPubFacade) GetBlocks(actorId o.ID, kind string) (o.ItemCollection, error) {
+	var targets []string
+	err := f.db.Model(&db.ActivityPubBlock{}).Where("actor_id = ? AND kind = ?", string(actorId), kind).
+		Order("id DESC").Pluck("target_id", &targets).Error
+	if err != nil {
+		return nil, err
+	}
+	return iriCollection(targets), nil
+}
+
+// loadBlock loads the local actor and its block of the kind of the target, nil when
+// there is none.
+func loadBlock(tx *gorm.DB, actorId, targetId o.ID, kind string) (*db.ActivityPubActor, *db.ActivityPubBlock, error) {
+	actor, err := loadActor(tx, actorId)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !actor.IsLocal {
+		return nil, nil, model.ErrActivityPubNotOwner
+	}
+	if actor.ActivityPubID == string(targetId) {
+		return nil, nil, model.ErrBlockSelf
+	}
+	var row db.ActivityPubBlock
+	if err := tx.Where("actor_id = ? AND target_id = ? AND kind = ?", actor.ActivityPubID, string(targetId), kind).Limit(1).Find(&row).Error; err != nil {
+		return nil, nil, err
+	}
+	if row.ID == 0 {
+		return actor, nil, nil
+	}
+	return actor, &row, nil
+}
+
+// processBlock ends the follows between the actor and the local actor it blocks
+func processBlock(tx *gorm.DB, a *ap.Activity) error {
+	actor, target := link(a.Actor), link(a.Object)
+	return tx.Model(&db.ActivityPubFollow{}).
+		Where("is_active = ? AND ((follower_id = ? AND following_id = ?) OR (follower_id = ? AND following_id = ?))", true, actor, target, target, actor).
+		Updates(map[string]interface{}{"accepted": false, "is_active": false}).Error
+}
@@ -0,0 +1,183 @@
+package actor
+
+import (
+	"time"
+
+	o "github.com/peers-touch/peers-touch/station/frame/object"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+	ap "github.com/peers-touch/peers-touch/station/frame/vendors/activitypub"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DirectMessage is a direct object a remote actor sent to a local actor, to route into
+// the conversation bridging them. Chat is nil when they have none yet.
+type DirectMessage struct {
+	Local  string
+	Remote string
+	Object *ap.Object
+	Chat   *db.ActivityPubChat
+}
+
+// GetChat returns the chat bridging the local actor with the remote one, nil when there
+// is none.
+func (f *DefaultActivityPubFacade) GetChat(localId o.ID, remoteId o.ID) (*db.ActivityPubChat, error) {
+	return loadChat(f.db, "local_actor_id = ? AND remote_actor_id = ?", string(localId), string(remoteId))
+}
+
+// GetChatByConv returns the chat bridging a conversation, nil when it is not bridged.
+func (f *DefaultActivityPubFacade) GetChatByConv(convID string) (*db.ActivityPubChat, error) {
+	return loadChat(f.db, "conv_id = ?", convID)
+}
+
+// GetChats returns the chats of a local actor, latest first
+func (f *DefaultActivityPubFacade) GetChats(localId o.ID) ([]*db.ActivityPubChat, error) {
+	var chats []*db.ActivityPubChat
+	err := f.db.Where("local_actor_id = ?", string(localId)).Order("id DESC").Find(&chats).Error
+	return chats, err
+}
+
+// SaveChat stores a chat bridging a local actor with a remote one. It returns the chat
+// stored, the former one when they had one already.
+func (f *DefaultActivityPubFacade) SaveChat(chat *db.ActivityPubChat) (*db.ActivityPubChat, error) {
+	if chat.ObjectType != db.ChatTypeNote && chat.ObjectType != db.ChatTypeChatMessage {
+		return nil, model.ErrChatTypeInvalid
+	}
+	remote, err := loadActor(f.db, o.ID(chat.RemoteActorID))
+	if err != nil {
+		return nil, err
+	}
+	if remote.IsLocal {
+		return nil, model.ErrChatNotRemote
+	}
+	if err := f.db.Clauses(clause.OnConflict{DoNothing: true}).Select("*").Create(chat).Error; err != nil {
+		return nil, err
+	}
+	return f.GetChat(o.ID(chat.LocalActorID), o.ID(chat.RemoteActorID))
+}
+
+// LinkChatMessage links a message of a chat to its object. It returns false when the
+// object was linked already, its message is not to be added again.
+func (f *DefaultActivityPubFacade) LinkChatMessage(chat *db.ActivityPubChat, objectId o.ID, ulid string, outgoing bool) (bool, error) {
+	res := f.db.Clauses(clause.OnConflict{DoNothing: true}).Select("*").Create(&db.ActivityPubChatMessage{
+		ChatID:   chat.ID,
+		ObjectID: string(objectId),
+		MsgULID:  ulid,
+		Outgoing: outgoing,
+	})
+	return res.RowsAffected == 1, res.Error
+}
+
+// PendingChatMessages returns up to limit messages the local actors of chats appended
+// since the time that were not delivered yet, oldest first. The message stored in a
+// bridged conversation asks for its delivery in the transaction appending it, it is
+// pending until it is linked to the object it was published as.
+func (f *DefaultActivityPubFacade) PendingChatMessages(since time.Time, limit int) ([]*db.Message, error) {
+	var messages []*db.Message
+	err := f.db.Model(&db.Message{}).Select("touch_message.*").
+		Joins("JOIN activitypub_chats ON activitypub_chats.conv_id = touch_message.conv_id AND activitypub_chats.local_did = touch_message.sender_did").
+		Where("touch_message.body <> ? AND touch_message.deleted = ? AND touch_message.created_at >= ?", "", false, since).
+		Where("NOT EXISTS (?)", f.db.Model(&db.ActivityPubChatMessage{}).Select("1").
+			Where("activitypub_chat_messages.chat_id = activitypub_chats.id AND activitypub_chat_messages.msg_ulid = touch_message.ulid")).
+		Order("touch_message.ulid").Limit(limit).Find(&messages).Error
+	return messages, err
+}
+
+// InTransaction calls fn with a facade whose changes are committed together, when fn
+// returns no error.
+func (f *DefaultActivityPubFacade) InTransaction(fn func(tx *DefaultActivityPubFacade) error) error {
+	return f.db.Transaction(func(tx *gorm.DB) error {
+		return fn(&DefaultActivityPubFacade{db: tx})
+	})
+}
+
+// ChatObject returns the object a message of a chat was delivered as or received in,
+// empty when it has none.
+func (f *DefaultActivityPubFacade) ChatObject(chat *db.ActivityPubChat, ulid string) (string, error) {
+	var objects []string
+	err := f.db.Model(&db.ActivityPubChatMessage{}).Where("chat_id = ? AND msg_ulid = ?", chat.ID, ulid).Limit(1).Pluck("object_id", &objects).Error
+	if err != nil || len(objects) == 0 {
+		return "", err
+	}
+	return objects[0], nil
+}
+
+// ChatMessage returns the message of a chat an object was delivered as or received in,
+// empty when it is none.
+func (f *DefaultActivityPubFacade) ChatMessage(chat *db.ActivityPubChat, objectId o.ID) (string, error) {
+	var ulids []string
+	err := f.db.Model(&db.ActivityPubChatMessage{}).Where("chat_id = ? AND object_id = ?", chat.ID, string(objectId)).Limit(1).Pluck("msg_ulid", &ulids).Error
+	if err != nil || len(ulids) == 0 {
+		return "", err
+	}
+	return ulids[0], nil
+}
+
+// DirectMessages returns the direct messages a received Create carries: its Note or
+// ChatMessage when it is addressed to actors only, one for every local actor among them
+// that did not block or mute its author. Objects routed before are left out.
+func (f *DefaultActivityPubFacade) DirectMessages(activity *o.Activity) ([]*DirectMessage, error) {
+	a := (*ap.Activity)(activity)
+	if a == nil || a.Type != ap.CreateType || !chatObject(a.Object) || visibility(a) != db.VisibilityDirect {
+		return nil, nil
+	}
+	ob, err := ap.ToObject(a.Object)
+	if err != nil {
+		return nil, model.ErrActivityInvalid
+	}
+	remote := link(a.Actor)
+	if author := link(ob.AttributedTo); author != "" && author != remote {
+		return nil, nil
+	}
+
+	var messages []*DirectMessage
+	err = f.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&db.ActivityPubChatMessage{}).Where("object_id = ?", string(ob.ID)).Count(&count).Error; err != nil {
+			return err
+		}
+		sender, err := loadActor(tx, o.ID(remote))
+		if err != nil || count > 0 || sender.IsLocal {
+			return err
+		}
+
+		var locals []string
+		err = tx.Model(&db.ActivityPubActor{}).
+			Where("activity_pub_id IN ? AND is_local = ? AND is_active = ? AND suspended_at IS NULL", dedupe(append(iris(audience(a)), mentions(a.Object)...)), true, true).
+			Where("activity_pub_id NOT IN (?)", tx.Model(&db.ActivityPubBlock{}).Select("actor_id").Where("target_id = ?", remote)).
+			Pluck("activity_pub_id", &locals).Error
+		if err != nil {
+			return err
+		}
+		for _, local := range locals {
+			chat, err := loadChat(tx, "local_actor_id = ? AND remote_actor_id = ?", local, remote)
+			if err != nil {
+				return err
+			}
+			messages = append(messages, &DirectMessage{Local: local, Remote: remote, Object: ob, Chat: chat})
+		}
+		return nil
+	})
+	return messages, err
+}
+
+// chatObject tells whether an item is an object chats carry
+func chatObject(it ap.Item) bool {
+	if ap.IsNil(it) || ap.IsIRI(it) {
+		return false
+	}
+	typ := string(it.GetType())
+	return typ == db.ChatTypeNote || typ == db.ChatTypeChatMessage
+}
+
+func loadChat(tx *gorm.DB, query string, args ...interface{}) (*db.ActivityPubChat, error) {
+	var chat db.ActivityPubChat
+	if err := tx.Where(query, args...).Limit(1).Find(&chat).Error; err != nil {
+		return nil, err
+	}
+	if chat.ID == 0 {
+		return nil, nil
+	}
+	return &chat, nil
+}
@@ -0,0 +1,163 @@
+package actor
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	o "github.com/peers-touch/peers-touch/station/frame/object"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+	ap "github.com/peers-touch/peers-touch/station/frame/vendors/activitypub"
+	"gorm.io/gorm"
+)
+
+func TestChatObject(t *testing.T) {
+	for _, c := range []struct {
+		it   ap.Item
+		want bool
+	}{
+		{ap.ObjectNew(ap.NoteType), true},
+		{&ap.Object{Type: db.ChatTypeChatMessage}, true},
+		{ap.ObjectNew(ap.ArticleType), false},
+		{ap.IRI("https://remote.example/objects/1"), false},
+		{nil, false},
+	} {
+		if got := chatObject(c.it); got != c.want {
+			t.Errorf("chatObject(%v) = %v, want %v", c.it, got, c.want)
+		}
+	}
+
+	did := db.ActivityPubDID("https://remote.example/users/bob")
+	if !strings.HasPrefix(did, db.ActivityPubDIDPrefix) || len(did) != len(db.ActivityPubDIDPrefix)+40 {
+		t.Errorf("did = %q", did)
+	}
+	if did == db.ActivityPubDID("https://remote.example/users/carol") {
+		t.Error("actors share a did")
+	}
+}
+
+// direct returns a Create of bob carrying a Note addressed to the actors, the Note
+// attributed to author.
+func direct(id, author string, to ...string) *ap.Activity {
+	n := note(id+"/note", author, "hi")
+	for _, iri := range to {
+		n.To = append(n.To, ap.IRI(iri))
+	}
+	create := activity(id, ap.CreateType, bob, n)
+	create.To = n.To
+	return create
+}
+
+func TestDirectMessages(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		activity func(*testing.T, *gorm.DB) *ap.Activity
+		locals   []string
+		chat     bool
+	}{
+		{"to a local actor", func(*testing.T, *gorm.DB) *ap.Activity {
+			return direct("https://remote.example/activities/1", bob, alice)
+		}, []string{alice}, false},
+		{"to local and remote actors", func(*testing.T, *gorm.DB) *ap.Activity {
+			return direct("https://remote.example/activities/1", bob, alice, carol, mallory)
+		}, []string{alice, carol}, false},
+		{"with a chat already", func(t *testing.T, rds *gorm.DB) *ap.Activity {
+			saveChat(t, rds)
+			return direct("https://remote.example/activities/1", bob, alice)
+		}, []string{alice}, true},
+		{"public", func(*testing.T, *gorm.DB) *ap.Activity {
+			return direct("https://remote.example/activities/1", bob, string(ap.PublicNS), alice)
+		}, nil, false},
+		{"to followers", func(*testing.T, *gorm.DB) *ap.Activity {
+			return direct("https://remote.example/activities/1", bob, bob+"/followers", alice)
+		}, nil, false},
+		{"attributed to another actor", func(*testing.T, *gorm.DB) *ap.Activity {
+			return direct("https://remote.example/activities/1", mallory, alice)
+		}, nil, false},
+		{"not a Create", func(*testing.T, *gorm.DB) *ap.Activity {
+			a := direct("https://remote.example/activities/1", bob, alice)
+			a.Type = ap.UpdateType
+			return a
+		}, nil, false},
+		{"from a blocked actor", func(t *testing.T, rds *gorm.DB) *ap.Activity {
+			if err := rds.Create(&db.ActivityPubBlock{ActorID: alice, TargetID: bob, Kind: db.BlockKindBlock}).Error; err != nil {
+				t.Fatal(err)
+			}
+			return direct("https://remote.example/activities/1", bob, alice, carol)
+		}, []string{carol}, false},
+		{"routed before", func(t *testing.T, rds *gorm.DB) *ap.Activity {
+			a := direct("https://remote.example/activities/1", bob, alice)
+			f := NewDefaultActivityPubFacade(rds).(*DefaultActivityPubFacade)
+			if _, err := f.LinkChatMessage(saveChat(t, rds), o.ID(a.Object.GetLink()), "01J0000000000000000000000A", false); err != nil {
+				t.Fatal(err)
+			}
+			return a
+		}, nil, false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			rds := seedProcess(t)
+			f := NewDefaultActivityPubFacade(rds).(*DefaultActivityPubFacade)
+			messages, err := f.DirectMessages((*o.Activity)(c.activity(t, rds)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(messages) != len(c.locals) {
+				t.Fatalf("messages = %d, want %d", len(messages), len(c.locals))
+			}
+			for i, m := range messages {
+				if m.Local != c.locals[i] || m.Remote != bob || (m.Chat != nil) != c.chat {
+					t.Errorf("message %d = %s from %s, chat %v", i, m.Local, m.Remote, m.Chat)
+				}
+			}
+		})
+	}
+}
+
+func TestPendingChatMessages(t *testing.T) {
+	rds := seedProcess(t)
+	chat := saveChat(t, rds)
+	now := time.Now()
+	for _, m := range []*db.Message{
+		{ULID: "01J0000000000000000000000A", ConvID: chat.ConvID, SenderDID: chat.LocalDID, Body: "delivered"},
+		{ULID: "01J0000000000000000000000B", ConvID: chat.ConvID, SenderDID: chat.LocalDID, Body: "pending"},
+		{ULID: "01J0000000000000000000000C", ConvID: chat.ConvID, SenderDID: chat.RemoteDID, Body: "received"},
+		{ULID: "01J0000000000000000000000D", ConvID: chat.ConvID, SenderDID: chat.LocalDID},
+		{ULID: "01J0000000000000000000000E", ConvID: chat.ConvID, SenderDID: chat.LocalDID, Body: "deleted", Deleted: true},
+		{ULID: "01J0000000000000000000000F", ConvID: chat.ConvID, SenderDID: chat.LocalDID, Body: "old", CreatedAt: now.Add(-2 * time.Hour)},
+		{ULID: "01J0000000000000000000000G", ConvID: "other", SenderDID: chat.LocalDID, Body: "not bridged"},
+	} {
+		if err := rds.Create(m).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	f := NewDefaultActivityPubFacade(rds).(*DefaultActivityPubFacade)
+	if _, err := f.LinkChatMessage(chat, "https://local.example/activitypub/objects/1", "01J0000000000000000000000A", true); err != nil {
+		t.Fatal(err)
+	}
+
+	pending, err := f.PendingChatMessages(now.Add(-time.Hour), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].ULID != "01J0000000000000000000000B" || pending[0].Body != "pending" {
+		t.Fatalf("pending = %+v", pending)
+	}
+}
+
+// saveChat stores the chat bridging alice with bob
+func saveChat(t *testing.T, rds *gorm.DB) *db.ActivityPubChat {
+	t.Helper()
+	f := NewDefaultActivityPubFacade(rds).(*DefaultActivityPubFacade)
+	chat, err := f.SaveChat(&db.ActivityPubChat{
+		ConvID:        "01J00000000000000000000CNV",
+		LocalActorID:  alice,
+		RemoteActorID: bob,
+		LocalDID:      db.DIDPrefix + "alice",
+		RemoteDID:     db.ActivityPubDID(bob),
+		ObjectType:    db.ChatTypeNote,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chat
+}
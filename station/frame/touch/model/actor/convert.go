@@ -0,0 +1,196 @@
+package actor
+
+import (
+	"strings"
+	"time"
+
+	o "github.com/peers-touch/peers-touch/station/frame/object"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+	ap "github.com/peers-touch/peers-touch/station/frame/vendors/activitypub"
+)
+
+// KeyID returns the id of the public key of a local actor, the keyId its requests are
+// signed with.
+func KeyID(actorIRI string) string {
+	return actorIRI + "#main-key"
+}
+
+// actorToRow copies the actor into row, leaving the local state and private key alone.
+func actorToRow(a *Actor, row *db.ActivityPubActor) {
+	row.ActivityPubID = string(a.ID)
+	row.Type = string(a.Type)
+	row.Name = firstValue(a.Name)
+	row.PreferredUsername = firstValue(a.PreferredUsername)
+	row.Summary = firstValue(a.Summary)
+	row.InboxURL = link(a.Inbox)
+	row.OutboxURL = link(a.Outbox)
+	row.FollowersURL = link(a.Followers)
+	row.FollowingURL = link(a.Following)
+	row.LikedURL = link(a.Liked)
+	row.SharedInboxURL = ""
+	if a.Endpoints != nil {
+		row.SharedInboxURL = link(a.Endpoints.SharedInbox)
+	}
+	if a.PublicKey.PublicKeyPem != "" {
+		row.PublicKeyID = string(a.PublicKey.ID)
+		row.PublicKeyPem = a.PublicKey.PublicKeyPem
+	}
+}
+
+// actorFromRow builds the actor document of a stored actor.
+func actorFromRow(row *db.ActivityPubActor) *Actor {
+	a := ap.ActorNew(ap.ID(row.ActivityPubID), ap.ActivityVocabularyType(row.Type))
+	// ActorNew falls back to the Actor type for types it does not know
+	a.Type = ap.ActivityVocabularyType(row.Type)
+	setValue(&a.Name, row.Name)
+	setValue(&a.PreferredUsername, row.PreferredUsername)
+	setValue(&a.Summary, row.Summary)
+	a.Inbox = iri(row.InboxURL)
+	a.Outbox = iri(row.OutboxURL)
+	a.Followers = iri(row.FollowersURL)
+	a.Following = iri(row.FollowingURL)
+	a.Liked = iri(row.LikedURL)
+	if row.SharedInboxURL != "" {
+		a.Endpoints = &ap.Endpoints{SharedInbox: ap.IRI(row.SharedInboxURL)}
+	}
+	if row.PublicKeyPem != "" {
+		a.PublicKey = ap.PublicKey{ID: ap.ID(row.KeyID()), Owner: ap.IRI(row.ActivityPubID), PublicKeyPem: row.PublicKeyPem}
+	}
+	return (*Actor)(a)
+}
+
+// activityToRow indexes the activity in row and keeps its JSON as the content.
+func activityToRow(a *ap.Activity, row *db.ActivityPubActivity) error {
+	row.ActivityPubID = string(a.ID)
+	row.Type = string(a.Type)
+	row.ActorID = link(a.Actor)
+	row.ObjectID = link(a.Object)
+	row.TargetID = link(a.Target)
+	row.Published = a.Published
+	row.IsPublic = isPublic(a)
+	row.Visibility = visibility(a)
+	return row.SetContent(o.Activity(*a))
+}
+
+// blind drops the bto and bcc of the activity and of the object it carries, they tell
+// whom the station delivers to and are never served.
+func blind(a *ap.Activity) *ap.Activity {
+	a.Bto, a.BCC = nil, nil
+	if !ap.IsNil(a.Object) && !ap.IsIRI(a.Object) {
+		_ = ap.OnObject(a.Object, func(ob *ap.Object) error {
+			ob.Bto, ob.BCC = nil, nil
+			return nil
+		})
+	}
+	return a
+}
+
+func activityFromRow(row *db.ActivityPubActivity) (*o.Activity, error) {
+	a, err := row.GetContent()
+	if err != nil || a != nil {
+		return a, err
+	}
+	// rows without content keep what was indexed
+	act := ap.ActivityNew(ap.ID(row.ActivityPubID), ap.ActivityVocabularyType(row.Type), iri(row.ObjectID))
+	act.Actor = iri(row.ActorID)
+	act.Target = iri(row.TargetID)
+	act.Published = row.Published
+	return (*o.Activity)(act), nil
+}
+
+func objectToRow(ob *ap.Object, row *db.ActivityPubObject) {
+	row.ActivityPubID = string(ob.ID)
+	row.Type = string(ob.Type)
+	row.AttributedTo = link(ob.AttributedTo)
+	row.Name = firstValue(ob.Name)
+	row.Content = firstValue(ob.Content)
+	row.Summary = firstValue(ob.Summary)
+	row.URL = link(ob.URL)
+	row.Published = ob.Published
+	if row.Published.IsZero() {
+		row.Published = time.Now()
+	}
+	if !ob.Updated.IsZero() {
+		updated := ob.Updated
+		row.Updated = &updated
+	}
+	row.InReplyTo = link(ob.InReplyTo)
+	row.IsPublic = ob.To.Contains(ap.PublicNS) || ob.CC.Contains(ap.PublicNS)
+}
+
+func objectFromRow(row *db.ActivityPubObject) *ap.Object {
+	ob := ap.ObjectNew(ap.ActivityVocabularyType(row.Type))
+	ob.ID = ap.ID(row.ActivityPubID)
+	ob.AttributedTo = iri(row.AttributedTo)
+	setValue(&ob.Name, row.Name)
+	setValue(&ob.Content, row.Content)
+	setValue(&ob.Summary, row.Summary)
+	ob.URL = iri(row.URL)
+	ob.Published = row.Published
+	if row.Updated != nil {
+		ob.Updated = *row.Updated
+	}
+	ob.InReplyTo = iri(row.InReplyTo)
+	if row.IsPublic {
+		ob.To = ap.ItemCollection{ap.PublicNS}
+	}
+	return ob
+}
+
+// audience returns every addressee of the activity.
+func audience(a *ap.Activity) ap.ItemCollection {
+	var all ap.ItemCollection
+	for _, col := range []ap.ItemCollection{a.To, a.CC, a.Bto, a.BCC, a.Audience} {
+		for _, it := range col {
+			if !ap.IsNil(it) {
+				all = append(all, it)
+			}
+		}
+	}
+	return all
+}
+
+func isPublic(a *ap.Activity) bool {
+	return audience(a).Contains(ap.PublicNS)
+}
+
+// visibility tells who the addressing of the activity shows it to. Followers
+// collections are told apart by their path, the servers federating name them alike.
+func visibility(a *ap.Activity) string {
+	switch {
+	case a.To.Contains(ap.PublicNS):
+		return db.VisibilityPublic
+	case isPublic(a):
+		return db.VisibilityUnlisted
+	}
+	for _, it := range audience(a) {
+		if strings.HasSuffix(link(it), "/followers") {
+			return db.VisibilityFollowers
+		}
+	}
+	return db.VisibilityDirect
+}
+
+func link(it ap.Item) string {
+	if ap.IsNil(it) {
+		return ""
+	}
+	return string(it.GetLink())
+}
+
+func iri(s string) ap.Item {
+	if s == "" {
+		return nil
+	}
+	return ap.IRI(s)
+}
+
+func firstValue(n ap.NaturalLanguageValues) string {
+	return n.First().Value.String()
+}
+
+func setValue(n *ap.NaturalLanguageValues, s string) {
+	if s != "" {
+		*n = ap.DefaultNaturalLanguageValue(s)
+	}
+}
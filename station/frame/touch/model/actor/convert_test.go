@@ -0,0 +1,141 @@
+package actor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/peers-touch/peers-touch/station/frame/touch/internal/storetest"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+	ap "github.com/peers-touch/peers-touch/station/frame/vendors/activitypub"
+)
+
+func TestActorRoundTrip(t *testing.T) {
+	p := ap.PersonNew("https://example.com/activitypub/alice/actor")
+	p.Name = ap.DefaultNaturalLanguageValue("Alice")
+	p.PreferredUsername = ap.DefaultNaturalLanguageValue("alice")
+	p.Inbox = ap.IRI("https://example.com/activitypub/alice/inbox")
+	p.Outbox = ap.IRI("https://example.com/activitypub/alice/outbox")
+	p.Followers = ap.IRI("https://example.com/activitypub/alice/followers")
+
+	row := &db.ActivityPubActor{PublicKeyPem: "PEM"}
+	actorToRow((*Actor)(p), row)
+	if row.PreferredUsername != "alice" || row.InboxURL != "https://example.com/activitypub/alice/inbox" || row.FollowingURL != "" {
+		t.Fatalf("row = %+v", row)
+	}
+
+	got := actorFromRow(row)
+	if got.Type != ap.PersonType || firstValue(got.Name) != "Alice" || link(got.Followers) != row.FollowersURL || got.Following != nil {
+		t.Fatalf("actor = %+v", got)
+	}
+	if got.PublicKey.Owner != ap.IRI(row.ActivityPubID) || got.PublicKey.PublicKeyPem != "PEM" {
+		t.Fatalf("public key = %+v", got.PublicKey)
+	}
+	if string(got.PublicKey.ID) != KeyID(row.ActivityPubID) {
+		t.Fatalf("key id of a row without one = %s", got.PublicKey.ID)
+	}
+
+	p.PublicKey = ap.PublicKey{ID: "https://example.com/activitypub/alice/main-key", Owner: p.ID, PublicKeyPem: "PEM2"}
+	actorToRow((*Actor)(p), row)
+	if got := actorFromRow(row); got.PublicKey.ID != p.PublicKey.ID || got.PublicKey.PublicKeyPem != "PEM2" {
+		t.Fatalf("public key = %+v", got.PublicKey)
+	}
+}
+
+func TestActivityRoundTrip(t *testing.T) {
+	note := ap.ObjectNew(ap.NoteType)
+	note.ID = "https://example.com/notes/1"
+	note.Content = ap.DefaultNaturalLanguageValue("hello")
+	create := ap.ActivityNew("https://example.com/activities/1", ap.CreateType, note)
+	create.Actor = ap.IRI("https://example.com/activitypub/alice/actor")
+	create.To = ap.ItemCollection{ap.PublicNS}
+	create.Published = time.Now().UTC().Truncate(time.Second)
+
+	row := &db.ActivityPubActivity{}
+	if err := activityToRow(create, row); err != nil {
+		t.Fatal(err)
+	}
+	if row.ObjectID != string(note.ID) || row.ActorID != string(create.Actor.GetLink()) || !row.IsPublic {
+		t.Fatalf("row = %+v", row)
+	}
+
+	got, err := activityFromRow(row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != create.ID || got.Type != ap.CreateType || !got.Published.Equal(create.Published) {
+		t.Fatalf("activity = %+v", got)
+	}
+	ob, err := ap.ToObject(got.Object)
+	if err != nil || firstValue(ob.Content) != "hello" {
+		t.Fatalf("object = %+v, %v", ob, err)
+	}
+
+	row.Content = ""
+	if got, err = activityFromRow(row); err != nil || link(got.Object) != string(note.ID) {
+		t.Fatalf("activity without content = %+v, %v", got, err)
+	}
+}
+
+func TestActivityVisibility(t *testing.T) {
+	followers := ap.IRI("https://example.com/activitypub/alice/followers")
+	bob := ap.IRI("https://remote.example/users/bob")
+	for _, tc := range []struct {
+		to, cc ap.ItemCollection
+		want   string
+	}{
+		{ap.ItemCollection{ap.PublicNS}, ap.ItemCollection{followers}, db.VisibilityPublic},
+		{ap.ItemCollection{followers}, ap.ItemCollection{ap.PublicNS}, db.VisibilityUnlisted},
+		{ap.ItemCollection{followers}, ap.ItemCollection{bob}, db.VisibilityFollowers},
+		{ap.ItemCollection{bob}, nil, db.VisibilityDirect},
+	} {
+		a := ap.ActivityNew("https://example.com/activities/1", ap.CreateType, nil)
+		a.To, a.CC = tc.to, tc.cc
+		if got := visibility(a); got != tc.want {
+			t.Errorf("visibility to %v cc %v = %s, want %s", tc.to, tc.cc, got, tc.want)
+		}
+	}
+}
+
+func TestActivitiesAreServedBlind(t *testing.T) {
+	rds := storetest.Reset(t)
+	note := ap.ObjectNew(ap.NoteType)
+	note.ID = "https://example.com/notes/2"
+	note.To = ap.ItemCollection{ap.IRI("https://example.com/activitypub/bob/actor")}
+	note.BCC = ap.ItemCollection{ap.IRI("https://example.com/activitypub/carol/actor")}
+	create := ap.ActivityNew("https://example.com/activities/2", ap.CreateType, note)
+	create.Actor = ap.IRI("https://example.com/activitypub/alice/actor")
+	create.To, create.Bto = note.To, ap.ItemCollection{ap.IRI("https://example.com/activitypub/dave/actor")}
+	undo := ap.UndoNew("https://example.com/activities/3", ap.ActivityNew("https://example.com/activities/1", ap.FollowType, ap.IRI("https://remote.example/users/erin")))
+	undo.Actor = create.Actor
+	undo.Object.(*ap.Activity).BCC = ap.ItemCollection{ap.IRI("https://example.com/activitypub/carol/actor")}
+	for _, a := range []*ap.Activity{create, undo} {
+		row := &db.ActivityPubActivity{}
+		if err := activityToRow(a, row); err != nil {
+			t.Fatal(err)
+		}
+		if err := rds.Select("*").Create(row).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f := NewDefaultActivityPubFacade(rds).(*DefaultActivityPubFacade)
+	items, err := f.activitiesByID([]string{string(create.ID), string(undo.ID)})
+	if err != nil || len(items) != 2 {
+		t.Fatalf("items = %v, %v", items, err)
+	}
+	for _, it := range items {
+		a := it.(*ap.Activity)
+		if len(a.Bto) > 0 || len(a.BCC) > 0 {
+			t.Fatalf("%s served with bto %v and bcc %v", a.ID, a.Bto, a.BCC)
+		}
+		_ = ap.OnObject(a.Object, func(ob *ap.Object) error {
+			if len(ob.Bto) > 0 || len(ob.BCC) > 0 {
+				t.Fatalf("object of %s served with bto %v and bcc %v", a.ID, ob.Bto, ob.BCC)
+			}
+			return nil
+		})
+	}
+	if got := items[0].(*ap.Activity); len(got.To) != 1 {
+		t.Fatalf("to dropped: %v", got.To)
+	}
+}
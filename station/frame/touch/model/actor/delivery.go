@@ -0,0 +1,174 @@
+package actor
+
+import (
+	"net/url"
+	"time"
+
+	o "github.com/peers-touch/peers-touch/station/frame/object"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+	ap "github.com/peers-touch/peers-touch/station/frame/vendors/activitypub"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DeliveryStatus tells how far the delivery of an outgoing activity got.
+type DeliveryStatus struct {
+	ActivityID string            `json:"activity_id"`
+	Pending    int               `json:"pending"`
+	Delivered  int               `json:"delivered"`
+	Failed     int               `json:"failed"`
+	Failures   []DeliveryFailure `json:"failures,omitempty"`
+}
+
+// DeliveryFailure is an inbox the activity could not be delivered to.
+type DeliveryFailure struct {
+	Target string `json:"target"`
+	Reason string `json:"reason"`
+}
+
+// DeliveryStatus returns the delivery state of an activity
+func (f *DefaultActivityPubFacade) DeliveryStatus(activityId o.ID) (*DeliveryStatus, error) {
+	var counts []struct {
+		Status string
+		N      int
+	}
+	err := f.db.Model(&db.ActivityPubDelivery{}).Select("status, COUNT(*) AS n").
+		Where("activity_id = ?", string(activityId)).Group("status").Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+	var dead []*db.ActivityPubDeadLetter
+	if err := f.db.Where("activity_id = ?", string(activityId)).Order("created_at").Find(&dead).Error; err != nil {
+		return nil, err
+	}
+
+	status := &DeliveryStatus{ActivityID: string(activityId), Failed: len(dead)}
+	for _, c := range counts {
+		switch c.Status {
+		case db.DeliveryPending:
+			status.Pending = c.N
+		case db.DeliveryDelivered:
+			status.Delivered = c.N
+		}
+	}
+	for _, d := range dead {
+		status.Failures = append(status.Failures, DeliveryFailure{Target: d.Target, Reason: d.Reason})
+	}
+	return status, nil
+}
+
+// IsPending tells whether an activity still has deliveries outstanding
+func (f *DefaultActivityPubFacade) IsPending(activityId o.ID) (bool, error) {
+	var count int64
+	err := f.db.Model(&db.ActivityPubDelivery{}).Where("activity_id = ? AND status = ?", string(activityId), db.DeliveryPending).Count(&count).Error
+	return count > 0, err
+}
+
+// GetPendingActivities returns the activities of an actor that still have deliveries
+// outstanding, oldest first.
+func (f *DefaultActivityPubFacade) GetPendingActivities(actorId o.ID) (o.ItemCollection, error) {
+	pending := f.db.Model(&db.ActivityPubDelivery{}).Select("activity_id").
+		Where("sender_id = ? AND status = ?", string(actorId), db.DeliveryPending)
+	return f.activitiesIn(pending)
+}
+
+// enqueue delivers an activity of the sender to the recipients. Local actors get it in
+// their inbox right away, remote ones get a pending delivery the delivery queue sends.
+// Followers collections of local actors expand to the followers, Public and the sender
+// are skipped. Public activities and followers may go to shared inboxes.
+func enqueue(tx *gorm.DB, sender *db.ActivityPubActor, a *ap.Activity, recipients []string) error {
+	shared := isPublic(a)
+	seen := map[string]bool{sender.ActivityPubID: true, string(ap.PublicNS): true, "": true}
+	var locals []*db.ActivityPubActor
+	var deliveries []*db.ActivityPubDelivery
+	targets := map[string]bool{}
+	now := time.Now()
+
+	add := func(iri string, follower bool) error {
+		if seen[iri] {
+			return nil
+		}
+		seen[iri] = true
+		var row db.ActivityPubActor
+		if err := tx.Where("activity_pub_id = ?", iri).Limit(1).Find(&row).Error; err != nil {
+			return err
+		}
+		if row.ID != 0 && row.IsLocal {
+			locals = append(locals, &row)
+			return nil
+		}
+
+		d := &db.ActivityPubDelivery{
+			ActivityID:  string(a.ID),
+			SenderID:    sender.ActivityPubID,
+			Target:      iri,
+			Shared:      shared || follower,
+			Status:      db.DeliveryPending,
+			NextAttempt: now,
+		}
+		if row.ID != 0 && row.InboxURL != "" {
+			d.Inbox = row.InboxURL
+			if d.Shared && row.SharedInboxURL != "" {
+				d.Inbox = row.SharedInboxURL
+			}
+			d.Target = d.Inbox
+		}
+		u, err := url.Parse(d.Target)
+		if err != nil || u.Host == "" || targets[d.Target] {
+			return nil
+		}
+		targets[d.Target] = true
+		d.Host = u.Host
+		deliveries = append(deliveries, d)
+		return nil
+	}
+
+	for _, r := range recipients {
+		if seen[r] {
+			continue
+		}
+		var owner db.ActivityPubActor
+		if err := tx.Where("followers_url = ? AND is_local = ?", r, true).Limit(1).Find(&owner).Error; err != nil {
+			return err
+		}
+		if owner.ID == 0 {
+			if err := add(r, false); err != nil {
+				return err
+			}
+			continue
+		}
+		seen[r] = true
+		var followers []string
+		err := tx.Model(&db.ActivityPubFollow{}).Where("following_id = ? AND accepted = ? AND is_active = ?", owner.ActivityPubID, true, true).
+			Pluck("follower_id", &followers).Error
+		if err != nil {
+			return err
+		}
+		for _, follower := range followers {
+			if err := add(follower, true); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(locals) > 0 {
+		if _, err := receive(tx, a, locals); err != nil {
+			return err
+		}
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Select("*").Create(&deliveries).Error
+}
+
+// iris returns the IRIs of the items.
+func iris(items ap.ItemCollection) []string {
+	out := make([]string, 0, len(items))
+	for _, it := range items {
+		if !ap.IsNil(it) {
+			out = append(out, string(it.GetLink()))
+		}
+	}
+	return out
+}
@@ -1,15 +1,24 @@
 package actor
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
+	"github.com/peers-touch/peers-touch/station/frame/core/util/id"
 	o "github.com/peers-touch/peers-touch/station/frame/object"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/db"
 	ap "github.com/peers-touch/peers-touch/station/frame/vendors/activitypub"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// collectionItemActivity is the ItemType of activities in ActivityPubCollection rows
+const collectionItemActivity = "Activity"
+
 // DefaultActivityPubFacade provides a default implementation of ActivityPubFacade
+// backed by the activitypub_* tables of the RDS.
 type DefaultActivityPubFacade struct {
 	db *gorm.DB
 }
@@ -23,52 +32,250 @@ func NewDefaultActivityPubFacade(db *gorm.DB) ActivityPubFacade {
 
 // Facade interface implementation
 
-// CreateActor creates a new actor
+// CreateActor creates and stores a new local actor, its collections live below its id.
 func (f *DefaultActivityPubFacade) CreateActor(actorType o.ActivityVocabularyType, id o.ID) (*Actor, error) {
 	// Create a new ActivityPub actor using the vendor library
 	apActor := ap.ActorNew(ap.ID(id), ap.ActivityVocabularyType(actorType))
 	if apActor == nil {
 		return nil, fmt.Errorf("failed to create actor")
 	}
+	apActor.Inbox = ap.IRI(fmt.Sprintf("%s/inbox", id))
+	apActor.Outbox = ap.IRI(fmt.Sprintf("%s/outbox", id))
+	apActor.Followers = ap.IRI(fmt.Sprintf("%s/followers", id))
+	apActor.Following = ap.IRI(fmt.Sprintf("%s/following", id))
+	apActor.Liked = ap.IRI(fmt.Sprintf("%s/liked", id))
 
 	// Convert to our Actor type
 	actor := (*Actor)(apActor)
+	err := f.db.Transaction(func(tx *gorm.DB) error {
+		if _, err := loadActor(tx, id); err == nil {
+			return model.ErrActivityPubActorExists
+		} else if !errors.Is(err, model.ErrActorNotFound) {
+			return err
+		}
+		row := &db.ActivityPubActor{IsLocal: true, IsActive: true}
+		actorToRow(actor, row)
+		return tx.Create(row).Error
+	})
+	if err != nil {
+		return nil, err
+	}
 	return actor, nil
 }
 
+// SaveActor stores the actor, creating it or replacing the stored one. local marks the
+// actors of this station, everything else is a cached remote actor.
+func (f *DefaultActivityPubFacade) SaveActor(actor *Actor, local bool) error {
+	return f.saveActor(actor, local, "")
+}
+
+// SaveLocalActor stores a local actor with the private key its public key pairs with.
+func (f *DefaultActivityPubFacade) SaveLocalActor(actor *Actor, privateKeyPem string) error {
+	return f.saveActor(actor, true, privateKeyPem)
+}
+
+// PrivateKey returns the PEM private key of a local actor, empty if it has none yet.
+func (f *DefaultActivityPubFacade) PrivateKey(id o.ID) (string, error) {
+	row, err := loadActor(f.db, id)
+	if err != nil {
+		return "", err
+	}
+	return row.PrivateKeyPem, nil
+}
+
+func (f *DefaultActivityPubFacade) saveActor(actor *Actor, local bool, privateKeyPem string) error {
+	if actor == nil || actor.ID == "" {
+		return model.ErrActivityInvalid
+	}
+	return f.db.Transaction(func(tx *gorm.DB) error {
+		return storeActor(tx, actor, local, privateKeyPem)
+	})
+}
+
 // GetActor retrieves an actor by ID
 func (f *DefaultActivityPubFacade) GetActor(id o.ID) (*Actor, error) {
-	// TODO: Implement database lookup
-	return nil, fmt.Errorf("not implemented")
+	row, err := loadActor(f.db, id)
+	if err != nil {
+		return nil, err
+	}
+	return actorFromRow(row), nil
 }
 
 // UpdateActor updates an existing actor
 func (f *DefaultActivityPubFacade) UpdateActor(actor *Actor) error {
-	// TODO: Implement database update
-	return fmt.Errorf("not implemented")
+	if actor == nil {
+		return model.ErrActivityInvalid
+	}
+	return f.db.Transaction(func(tx *gorm.DB) error {
+		row, err := loadActor(tx, o.ID(actor.ID))
+		if err != nil {
+			return err
+		}
+		actorToRow(actor, row)
+		return tx.Select("*").Save(row).Error
+	})
 }
 
-// DeleteActor deletes an actor by ID
+// DeleteActor deletes an actor by ID together with its relationships and collections
 func (f *DefaultActivityPubFacade) DeleteActor(id o.ID) error {
-	// TODO: Implement database deletion
-	return fmt.Errorf("not implemented")
+	return f.db.Transaction(func(tx *gorm.DB) error {
+		row, err := loadActor(tx, id)
+		if err != nil {
+			return err
+		}
+		iri := row.ActivityPubID
+		if err := tx.Where("follower_id = ? OR following_id = ?", iri, iri).Delete(&db.ActivityPubFollow{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("actor_id = ?", iri).Delete(&db.ActivityPubLike{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("collection_id IN ?", []string{row.InboxURL, row.OutboxURL}).Delete(&db.ActivityPubCollection{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(row).Error
+	})
 }
 
-// Follow creates a follow relationship
+// Follow creates a follow relationship. Local targets accept right away unless they
+// approve followers manually, remote ones once their Accept arrives. Following twice is
+// a no-op.
 func (f *DefaultActivityPubFacade) Follow(actorId o.ID, targetId o.ID) error {
-	// TODO: Implement follow logic
-	return fmt.Errorf("not implemented")
+	return f.db.Transaction(func(tx *gorm.DB) error {
+		actor, err := loadActor(tx, actorId)
+		if err != nil {
+			return err
+		}
+		var existing db.ActivityPubFollow
+		err = tx.Where("follower_id = ? AND following_id = ? AND is_active = ?", actor.ActivityPubID, string(targetId), true).First(&existing).Error
+		if err == nil {
+			return nil
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		target, err := loadActor(tx, targetId)
+		if err != nil && !errors.Is(err, model.ErrActorNotFound) {
+			return err
+		}
+
+		follow := ap.FollowNew("", ap.IRI(targetId))
+		follow.To = ap.ItemCollection{ap.IRI(targetId)}
+		if err := publish(tx, actor, follow); err != nil {
+			return err
+		}
+		return tx.Select("*").Create(&db.ActivityPubFollow{
+			FollowerID:  actor.ActivityPubID,
+			FollowingID: string(targetId),
+			ActivityID:  string(follow.ID),
+			Accepted:    target != nil && target.IsLocal && !target.ManuallyApproves,
+			IsActive:    true,
+		}).Error
+	})
 }
 
-// Unfollow removes a follow relationship
+// Unfollow removes a follow relationship and publishes the Undo of its Follow, the
+// activities of the target leave the home timeline of the actor.
 func (f *DefaultActivityPubFacade) Unfollow(actorId o.ID, targetId o.ID) error {
-	// TODO: Implement unfollow logic
-	return fmt.Errorf("not implemented")
+	return f.db.Transaction(func(tx *gorm.DB) error {
+		actor, err := loadActor(tx, actorId)
+		if err != nil {
+			return err
+		}
+		var follows []*db.ActivityPubFollow
+		if err := tx.Where("follower_id = ? AND following_id = ? AND is_active = ?", actor.ActivityPubID, string(targetId), true).Find(&follows).Error; err != nil {
+			return err
+		}
+		for _, follow := range follows {
+			if err := tx.Model(follow).Update("is_active", false).Error; err != nil {
+				return err
+			}
+			if err := publishUndo(tx, actor, follow.ActivityID); err != nil {
+				return err
+			}
+		}
+		return unfeedActor(tx, actor.ActivityPubID, string(targetId))
+	})
+}
+
+// AcceptFollow accepts a pending follow of the actor and publishes the Accept
+func (f *DefaultActivityPubFacade) AcceptFollow(actorId o.ID, followId o.ID) error {
+	return f.answerFollow(actorId, followId, ap.AcceptType)
+}
+
+// RejectFollow rejects a follow of the actor, accepted or not, and publishes the Reject
+func (f *DefaultActivityPubFacade) RejectFollow(actorId o.ID, followId o.ID) error {
+	return f.answerFollow(actorId, followId, ap.RejectType)
+}
+
+func (f *DefaultActivityPubFacade) answerFollow(actorId o.ID, followId o.ID, answer ap.ActivityVocabularyType) error {
+	return f.db.Transaction(func(tx *gorm.DB) error {
+		actor, err := loadActor(tx, actorId)
+		if err != nil {
+			return err
+		}
+		var follow db.ActivityPubFollow
+		err = tx.Where("activity_id = ? AND following_id = ? AND is_active = ?", string(followId), actor.ActivityPubID, true).Limit(1).Find(&follow).Error
+		if err != nil {
+			return err
+		}
+		if follow.ID == 0 {
+			return model.ErrActivityNotFound
+		}
+		if answer == ap.AcceptType && follow.Accepted {
+			return nil
+		}
+		updates := map[string]interface{}{"accepted": answer == ap.AcceptType}
+		if answer == ap.RejectType {
+			updates["is_active"] = false
+		}
+		if err := tx.Model(&follow).Updates(updates).Error; err != nil {
+			return err
+		}
+
+		var row db.ActivityPubActivity
+		if err := tx.Where("activity_pub_id = ?", follow.ActivityID).Limit(1).Find(&row).Error; err != nil {
+			return err
+		}
+		var activity *ap.Activity
+		if row.ID != 0 {
+			stored, err := activityFromRow(&row)
+			if err != nil {
+				return err
+			}
+			activity = (*ap.Activity)(stored)
+		} else {
+			activity = ap.FollowNew(ap.ID(follow.ActivityID), ap.IRI(actor.ActivityPubID))
+			activity.Actor = ap.IRI(follow.FollowerID)
+		}
+		return answerFollow(tx, actor, activity, answer)
+	})
+}
+
+// GetFollowRequests returns the Follow activities of the actor waiting for its answer,
+// oldest first.
+func (f *DefaultActivityPubFacade) GetFollowRequests(actorId o.ID) (o.ItemCollection, error) {
+	pending := f.db.Model(&db.ActivityPubFollow{}).Select("activity_id").
+		Where("following_id = ? AND accepted = ? AND is_active = ?", string(actorId), false, true)
+	return f.activitiesIn(pending)
+}
+
+// SetManuallyApproves sets whether follows of a local actor wait for it to accept them
+func (f *DefaultActivityPubFacade) SetManuallyApproves(actorId o.ID, manual bool) error {
+	res := f.db.Model(&db.ActivityPubActor{}).Where("activity_pub_id = ? AND is_local = ?", string(actorId), true).Update("manually_approves", manual)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return model.ErrActorNotFound
+	}
+	return nil
 }
 
 // ActivityFacade interface implementation
 
-// CreateActivity creates a new activity
+// CreateActivity creates a new activity of the actor and stores it in its outbox. A
+// Create copies the addressing of its object.
 func (f *DefaultActivityPubFacade) CreateActivity(activityType o.ActivityVocabularyType, actorId o.ID, object o.Item) (*o.Activity, error) {
 	// Convert object to ap.Item
 	var apObject ap.Item
@@ -81,12 +288,23 @@ func (f *DefaultActivityPubFacade) CreateActivity(activityType o.ActivityVocabul
 	if apActivity == nil {
 		return nil, fmt.Errorf("failed to create activity")
 	}
+	if apActivity.Type == ap.CreateType && apObject != nil && !ap.IsIRI(apObject) {
+		_ = ap.OnObject(apObject, func(ob *ap.Object) error {
+			apActivity.To, apActivity.CC, apActivity.Bto, apActivity.BCC, apActivity.Audience = ob.To, ob.CC, ob.Bto, ob.BCC, ob.Audience
+			return nil
+		})
+	}
 
-	// Set the actor
-	apActivity.Actor = ap.IRI(actorId)
-
-	// Set published time
-	apActivity.Published = time.Now()
+	err := f.db.Transaction(func(tx *gorm.DB) error {
+		actor, err := loadActor(tx, actorId)
+		if err != nil {
+			return err
+		}
+		return publish(tx, actor, apActivity)
+	})
+	if err != nil {
+		return nil, err
+	}
 
 	// Convert to our Activity type
 	activity := (*o.Activity)(apActivity)
@@ -95,82 +313,527 @@ func (f *DefaultActivityPubFacade) CreateActivity(activityType o.ActivityVocabul
 
 // GetActivity retrieves an activity by ID
 func (f *DefaultActivityPubFacade) GetActivity(id o.ID) (*o.Activity, error) {
-	// TODO: Implement database lookup
-	return nil, fmt.Errorf("not implemented")
+	var row db.ActivityPubActivity
+	if err := f.db.Where("activity_pub_id = ?", string(id)).First(&row).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, model.ErrActivityNotFound
+		}
+		return nil, err
+	}
+	return activityFromRow(&row)
 }
 
-// DeleteActivity deletes an activity by ID
+// DeleteActivity deletes an activity by ID and drops it from the collections holding it
 func (f *DefaultActivityPubFacade) DeleteActivity(id o.ID) error {
-	// TODO: Implement database deletion
-	return fmt.Errorf("not implemented")
+	return f.db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Where("activity_pub_id = ?", string(id)).Delete(&db.ActivityPubActivity{})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return model.ErrActivityNotFound
+		}
+		return tx.Where("item_id = ? AND item_type = ?", string(id), collectionItemActivity).Delete(&db.ActivityPubCollection{}).Error
+	})
 }
 
-// Like creates a like activity
+// Like creates a like activity, liking twice is a no-op
 func (f *DefaultActivityPubFacade) Like(actorId o.ID, objectId o.ID) error {
-	// TODO: Implement like logic
-	return fmt.Errorf("not implemented")
+	return f.db.Transaction(func(tx *gorm.DB) error {
+		actor, err := loadActor(tx, actorId)
+		if err != nil {
+			return err
+		}
+		var count int64
+		if err := tx.Model(&db.ActivityPubLike{}).Where("actor_id = ? AND object_id = ? AND is_active = ?", actor.ActivityPubID, string(objectId), true).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return nil
+		}
+
+		like := ap.LikeNew("", ap.IRI(objectId))
+		var ob db.ActivityPubObject
+		if err := tx.Where("activity_pub_id = ?", string(objectId)).Limit(1).Find(&ob).Error; err != nil {
+			return err
+		}
+		if ob.AttributedTo != "" {
+			like.To = ap.ItemCollection{ap.IRI(ob.AttributedTo)}
+		}
+		if err := publish(tx, actor, like); err != nil {
+			return err
+		}
+		return tx.Select("*").Create(&db.ActivityPubLike{
+			ActorID:    actor.ActivityPubID,
+			ObjectID:   string(objectId),
+			ActivityID: string(like.ID),
+			IsActive:   true,
+		}).Error
+	})
 }
 
-// Unlike removes a like activity
+// Unlike removes a like activity and publishes its Undo
 func (f *DefaultActivityPubFacade) Unlike(actorId o.ID, objectId o.ID) error {
-	// TODO: Implement unlike logic
-	return fmt.Errorf("not implemented")
+	return f.db.Transaction(func(tx *gorm.DB) error {
+		actor, err := loadActor(tx, actorId)
+		if err != nil {
+			return err
+		}
+		var likes []*db.ActivityPubLike
+		if err := tx.Where("actor_id = ? AND object_id = ? AND is_active = ?", actor.ActivityPubID, string(objectId), true).Find(&likes).Error; err != nil {
+			return err
+		}
+		for _, like := range likes {
+			if err := tx.Model(like).Update("is_active", false).Error; err != nil {
+				return err
+			}
+			if err := publishUndo(tx, actor, like.ActivityID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
-// Announce creates an announce activity
+// Announce creates a public announce activity addressed to the followers of the actor
 func (f *DefaultActivityPubFacade) Announce(actorId o.ID, objectId o.ID) error {
-	// TODO: Implement announce logic
-	return fmt.Errorf("not implemented")
+	return f.db.Transaction(func(tx *gorm.DB) error {
+		actor, err := loadActor(tx, actorId)
+		if err != nil {
+			return err
+		}
+		announce := ap.AnnounceNew("", ap.IRI(objectId))
+		announce.To = ap.ItemCollection{ap.PublicNS}
+		if actor.FollowersURL != "" {
+			announce.CC = ap.ItemCollection{ap.IRI(actor.FollowersURL)}
+		}
+		return publish(tx, actor, announce)
+	})
+}
+
+// GetObject retrieves a stored object by ID
+func (f *DefaultActivityPubFacade) GetObject(id o.ID) (*ap.Object, error) {
+	var row db.ActivityPubObject
+	if err := f.db.Where("activity_pub_id = ?", string(id)).First(&row).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, model.ErrActivityPubObjectNotFound
+		}
+		return nil, err
+	}
+	return objectFromRow(&row), nil
+}
+
+// GetPublishedObject returns a local object as its Create published it, with its tags
+// and addressing, and the visibility of the Create. Tombstoned objects are returned as
+// their Tombstone.
+func (f *DefaultActivityPubFacade) GetPublishedObject(id o.ID) (*ap.Object, string, error) {
+	ob, err := f.GetObject(id)
+	if err != nil {
+		return nil, "", err
+	}
+	if ob.Type == ap.TombstoneType {
+		return ob, "", nil
+	}
+	var row db.ActivityPubActivity
+	err = f.db.Where("object_id = ? AND type = ? AND is_local = ?", string(id), string(ap.CreateType), true).Limit(1).Find(&row).Error
+	if err != nil {
+		return nil, "", err
+	}
+	if row.ID == 0 {
+		return nil, "", model.ErrActivityPubObjectNotFound
+	}
+	create, err := activityFromRow(&row)
+	if err != nil {
+		return nil, "", err
+	}
+	ob, err = ap.ToObject(blind((*ap.Activity)(create)).Object)
+	if err != nil {
+		return nil, "", err
+	}
+	return ob, row.Visibility, nil
 }
 
 // CollectionFacade interface implementation
 
-// GetInbox retrieves an actor's inbox
+// GetInbox retrieves an actor's inbox, most recent first
 func (f *DefaultActivityPubFacade) GetInbox(actorId o.ID) (o.ItemCollection, error) {
-	// TODO: Implement inbox retrieval
-	return nil, fmt.Errorf("not implemented")
+	actor, err := loadActor(f.db, actorId)
+	if err != nil {
+		return nil, err
+	}
+	return f.activities(actor.InboxURL)
 }
 
-// GetOutbox retrieves an actor's outbox
+// GetOutbox retrieves an actor's outbox, most recent first
 func (f *DefaultActivityPubFacade) GetOutbox(actorId o.ID) (o.ItemCollection, error) {
-	// TODO: Implement outbox retrieval
-	return nil, fmt.Errorf("not implemented")
+	actor, err := loadActor(f.db, actorId)
+	if err != nil {
+		return nil, err
+	}
+	return f.activities(actor.OutboxURL)
 }
 
 // GetFollowers retrieves an actor's followers
 func (f *DefaultActivityPubFacade) GetFollowers(actorId o.ID) (o.ItemCollection, error) {
-	// TODO: Implement followers retrieval
-	return nil, fmt.Errorf("not implemented")
+	var iris []string
+	err := f.db.Model(&db.ActivityPubFollow{}).Where("following_id = ? AND accepted = ? AND is_active = ?", string(actorId), true, true).
+		Order("created_at DESC").Pluck("follower_id", &iris).Error
+	if err != nil {
+		return nil, err
+	}
+	return iriCollection(iris), nil
 }
 
 // GetFollowing retrieves who an actor is following
 func (f *DefaultActivityPubFacade) GetFollowing(actorId o.ID) (o.ItemCollection, error) {
-	// TODO: Implement following retrieval
-	return nil, fmt.Errorf("not implemented")
+	var iris []string
+	err := f.db.Model(&db.ActivityPubFollow{}).Where("follower_id = ? AND accepted = ? AND is_active = ?", string(actorId), true, true).
+		Order("created_at DESC").Pluck("following_id", &iris).Error
+	if err != nil {
+		return nil, err
+	}
+	return iriCollection(iris), nil
 }
 
 // GetLiked retrieves an actor's liked items
 func (f *DefaultActivityPubFacade) GetLiked(actorId o.ID) (o.ItemCollection, error) {
-	// TODO: Implement liked retrieval
-	return nil, fmt.Errorf("not implemented")
+	var iris []string
+	err := f.db.Model(&db.ActivityPubLike{}).Where("actor_id = ? AND is_active = ?", string(actorId), true).
+		Order("created_at DESC").Pluck("object_id", &iris).Error
+	if err != nil {
+		return nil, err
+	}
+	return iriCollection(iris), nil
 }
 
 // DeliveryFacade interface implementation
 
-// SendActivity sends an activity to recipients
+// SendActivity delivers an activity of a local actor to the recipients, queueing a
+// delivery for every remote inbox. It does not store the activity.
 func (f *DefaultActivityPubFacade) SendActivity(activity *o.Activity, recipients o.ItemCollection) error {
-	// TODO: Implement activity delivery
-	return fmt.Errorf("not implemented")
+	if activity == nil || activity.ID == "" {
+		return model.ErrActivityInvalid
+	}
+	a := (*ap.Activity)(activity)
+	return f.db.Transaction(func(tx *gorm.DB) error {
+		sender, err := loadActor(tx, o.ID(link(a.Actor)))
+		if err != nil {
+			return err
+		}
+		if !sender.IsLocal {
+			return model.ErrActivityPubNotOwner
+		}
+		return enqueue(tx, sender, a, iris(ap.ItemCollection(recipients)))
+	})
 }
 
-// ReceiveActivity processes a received activity
+// ReceiveActivity stores a received activity and puts it in the inboxes of the local
+// actors it is meant for, the shared inbox receives activities with it. Receiving an
+// activity again is a no-op.
 func (f *DefaultActivityPubFacade) ReceiveActivity(activity *o.Activity) error {
-	// TODO: Implement activity processing
-	return fmt.Errorf("not implemented")
+	if activity == nil {
+		return model.ErrActivityInvalid
+	}
+	a := (*ap.Activity)(activity)
+	return f.db.Transaction(func(tx *gorm.DB) error {
+		locals, err := recipients(tx, a)
+		if err != nil {
+			return err
+		}
+		fresh, err := receive(tx, a, locals)
+		if err != nil || !fresh {
+			return err
+		}
+		return process(tx, a)
+	})
 }
 
-// ForwardActivity forwards an activity to a target
+// ReceiveActivityFor stores an activity delivered to the inbox of the actor.
+func (f *DefaultActivityPubFacade) ReceiveActivityFor(actorId o.ID, activity *o.Activity) error {
+	if activity == nil {
+		return model.ErrActivityInvalid
+	}
+	return f.db.Transaction(func(tx *gorm.DB) error {
+		actor, err := loadActor(tx, actorId)
+		if err != nil {
+			return err
+		}
+		a := (*ap.Activity)(activity)
+		fresh, err := receive(tx, a, []*db.ActivityPubActor{actor})
+		if err != nil || !fresh {
+			return err
+		}
+		return process(tx, a)
+	})
+}
+
+// ForwardActivity forwards a received activity to a target, a followers collection of a
+// local actor or an actor. The owner of the collection signs the forward, otherwise the
+// first local actor the activity is addressed to.
 func (f *DefaultActivityPubFacade) ForwardActivity(activity *o.Activity, targetId o.ID) error {
-	// TODO: Implement activity forwarding
-	return fmt.Errorf("not implemented")
+	if activity == nil || activity.ID == "" {
+		return model.ErrActivityInvalid
+	}
+	a := (*ap.Activity)(activity)
+	return f.db.Transaction(func(tx *gorm.DB) error {
+		var sender db.ActivityPubActor
+		if err := tx.Where("followers_url = ? AND is_local = ?", string(targetId), true).Limit(1).Find(&sender).Error; err != nil {
+			return err
+		}
+		if sender.ID == 0 {
+			addressed := iris(audience(a))
+			if len(addressed) > 0 {
+				if err := tx.Where("activity_pub_id IN ? AND is_local = ?", addressed, true).Limit(1).Find(&sender).Error; err != nil {
+					return err
+				}
+			}
+		}
+		if sender.ID == 0 {
+			return model.ErrActivityPubNotOwner
+		}
+		return enqueue(tx, &sender, a, []string{string(targetId)})
+	})
+}
+
+// activities loads the activities of a collection, most recent first. Items whose
+// activity is gone are returned as IRIs.
+func (f *DefaultActivityPubFacade) activities(collectionID string) (o.ItemCollection, error) {
+	var ids []string
+	if err := f.db.Model(&db.ActivityPubCollection{}).Where("collection_id = ?", collectionID).Order("position DESC").Pluck("item_id", &ids).Error; err != nil {
+		return nil, err
+	}
+	return f.activitiesByID(ids)
+}
+
+// activitiesByID loads the activities of the ids in their order, the ones gone are
+// returned as IRIs. Activities are blinded, as they are served.
+func (f *DefaultActivityPubFacade) activitiesByID(ids []string) (o.ItemCollection, error) {
+	var rows []*db.ActivityPubActivity
+	if len(ids) > 0 {
+		if err := f.db.Where("activity_pub_id IN ?", ids).Find(&rows).Error; err != nil {
+			return nil, err
+		}
+	}
+	byID := make(map[string]*db.ActivityPubActivity, len(rows))
+	for _, row := range rows {
+		byID[row.ActivityPubID] = row
+	}
+
+	items := make(o.ItemCollection, 0, len(ids))
+	for _, itemID := range ids {
+		row, ok := byID[itemID]
+		if !ok {
+			items = append(items, ap.IRI(itemID))
+			continue
+		}
+		activity, err := activityFromRow(row)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, blind((*ap.Activity)(activity)))
+	}
+	return items, nil
+}
+
+// storeActor creates the actor or replaces the stored one.
+func storeActor(tx *gorm.DB, actor *Actor, local bool, privateKeyPem string) error {
+	row, err := loadActor(tx, o.ID(actor.ID))
+	if errors.Is(err, model.ErrActorNotFound) {
+		row = &db.ActivityPubActor{IsActive: true}
+	} else if err != nil {
+		return err
+	}
+	actorToRow(actor, row)
+	row.IsLocal = local
+	if privateKeyPem != "" {
+		row.PrivateKeyPem = privateKeyPem
+	}
+	if !local {
+		now := time.Now()
+		row.LastFetched = &now
+	}
+	return tx.Select("*").Save(row).Error
+}
+
+// activitiesIn loads the activities whose ids the query selects, oldest first, blinded.
+func (f *DefaultActivityPubFacade) activitiesIn(ids *gorm.DB) (o.ItemCollection, error) {
+	var rows []*db.ActivityPubActivity
+	if err := f.db.Where("activity_pub_id IN (?)", ids).Order("published").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	items := make(o.ItemCollection, 0, len(rows))
+	for _, row := range rows {
+		activity, err := activityFromRow(row)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, blind((*ap.Activity)(activity)))
+	}
+	return items, nil
+}
+
+func loadActor(tx *gorm.DB, id o.ID) (*db.ActivityPubActor, error) {
+	var row db.ActivityPubActor
+	if err := tx.Where("activity_pub_id = ?", string(id)).First(&row).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, model.ErrActorNotFound
+		}
+		return nil, err
+	}
+	return &row, nil
+}
+
+// publish stores an activity of the actor, with the object it carries, appends it to
+// the outbox of the actor and queues its delivery to its audience. Activities without
+// an id get one below the outbox.
+func publish(tx *gorm.DB, actor *db.ActivityPubActor, a *ap.Activity) error {
+	if a.ID == "" {
+		a.ID = ap.ID(fmt.Sprintf("%s/%s", actor.OutboxURL, id.NextULID()))
+	}
+	a.Actor = ap.IRI(actor.ActivityPubID)
+	if a.Published.IsZero() {
+		a.Published = time.Now()
+	}
+
+	row := &db.ActivityPubActivity{IsLocal: actor.IsLocal}
+	if err := activityToRow(a, row); err != nil {
+		return err
+	}
+	if err := tx.Select("*").Create(row).Error; err != nil {
+		return err
+	}
+	if err := saveObject(tx, a.Object, actor.IsLocal); err != nil {
+		return err
+	}
+	if err := fanOut(tx, a, int64(row.ID), actor.IsLocal); err != nil {
+		return err
+	}
+	if err := addToCollection(tx, actor.OutboxURL, string(a.ID), collectionItemActivity); err != nil {
+		return err
+	}
+	return enqueue(tx, actor, a, iris(audience(a)))
+}
+
+// publishUndo publishes the Undo of a former activity of the actor, addressed like it.
+func publishUndo(tx *gorm.DB, actor *db.ActivityPubActor, activityID string) error {
+	var undone ap.Item = ap.IRI(activityID)
+	undo := ap.UndoNew("", undone)
+	var row db.ActivityPubActivity
+	if err := tx.Where("activity_pub_id = ?", activityID).Limit(1).Find(&row).Error; err != nil {
+		return err
+	}
+	if row.ID != 0 {
+		prev, err := activityFromRow(&row)
+		if err != nil {
+			return err
+		}
+		undo.Object = (*ap.Activity)(prev)
+		undo.To, undo.CC = prev.To, prev.CC
+	}
+	return publish(tx, actor, undo)
+}
+
+// receive stores a remote activity once and adds it to the inboxes of the recipients.
+// fresh tells whether the activity was new, only new activities are processed. The id
+// of the activity has to be on the host of its actor, see byReference for the objects
+// it embeds.
+func receive(tx *gorm.DB, a *ap.Activity, recipients []*db.ActivityPubActor) (fresh bool, err error) {
+	if a.ID == "" || ap.IsNil(a.Actor) || !SameHost(string(a.ID), link(a.Actor)) {
+		return false, model.ErrActivityInvalid
+	}
+	var count int64
+	if err := tx.Model(&db.ActivityPubActivity{}).Where("activity_pub_id = ?", string(a.ID)).Count(&count).Error; err != nil {
+		return false, err
+	}
+	if count == 0 {
+		byReference(a)
+		if a.Published.IsZero() {
+			a.Published = time.Now()
+		}
+		row := &db.ActivityPubActivity{}
+		if err := activityToRow(a, row); err != nil {
+			return false, err
+		}
+		if err := tx.Select("*").Create(row).Error; err != nil {
+			return false, err
+		}
+		if err := fanOut(tx, a, int64(row.ID), false); err != nil {
+			return false, err
+		}
+	}
+	if a.Type == ap.FlagType {
+		// reports go to the moderators, not to the inbox of the actor reported
+		recipients = nil
+	}
+	for _, r := range recipients {
+		if err := addToCollection(tx, r.InboxURL, string(a.ID), collectionItemActivity); err != nil {
+			return false, err
+		}
+	}
+	return count == 0, nil
+}
+
+// byReference replaces the objects a embeds whose ids are not on the host of its actor by
+// their IRIs, so that they are neither stored nor acted on as the actor tells them; their
+// own server is the one to ask for them.
+func byReference(a *ap.Activity) {
+	actor := link(a.Actor)
+	ref := func(it ap.Item) ap.Item {
+		if ap.IsNil(it) || ap.IsIRI(it) || SameHost(string(it.GetID()), actor) {
+			return it
+		}
+		return it.GetLink()
+	}
+	if col, ok := a.Object.(ap.ItemCollection); ok {
+		for i, it := range col {
+			col[i] = ref(it)
+		}
+		return
+	}
+	a.Object = ref(a.Object)
+}
+
+// saveObject stores the object an activity carries, activities, actors and bare IRIs
+// are not objects to store. The reply counts of its thread follow.
+func saveObject(tx *gorm.DB, it ap.Item, local bool) error {
+	if ap.IsNil(it) || ap.IsIRI(it) || !ap.ObjectTypes.Contains(it.GetType()) || it.GetID() == "" {
+		return nil
+	}
+	return ap.OnObject(it, func(ob *ap.Object) error {
+		row := &db.ActivityPubObject{IsLocal: local}
+		objectToRow(ob, row)
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "activity_pub_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"type", "attributed_to", "name", "content", "summary", "url", "published", "updated", "in_reply_to", "is_public", "updated_at"}),
+		}).Select("*").Create(row).Error
+		if err != nil {
+			return err
+		}
+		return threadObject(tx, row)
+	})
+}
+
+// addToCollection appends an item to a collection unless it is there already.
+func addToCollection(tx *gorm.DB, collectionID, itemID, itemType string) error {
+	if collectionID == "" {
+		return nil
+	}
+	var count int64
+	if err := tx.Model(&db.ActivityPubCollection{}).Where("collection_id = ? AND item_id = ?", collectionID, itemID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	now := time.Now()
+	// snowflake ids grow with time, so they order the items as they were added
+	return tx.Create(&db.ActivityPubCollection{CollectionID: collectionID, ItemID: itemID, ItemType: itemType, Position: int64(id.NextID()), AddedAt: now}).Error
+}
+
+func iriCollection(iris []string) o.ItemCollection {
+	items := make(o.ItemCollection, 0, len(iris))
+	for _, iri := range iris {
+		items = append(items, ap.IRI(iri))
+	}
+	return items
 }
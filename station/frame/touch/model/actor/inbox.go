@@ -16,6 +16,7 @@ type Inbox struct {
 	actorID    o.ID
 	mu         sync.RWMutex
 	listeners  []InboxListener
+	processor  InboxProcessor
 }
 
 // InboxListener defines the interface for inbox event listeners
@@ -23,11 +24,18 @@ type InboxListener interface {
 	OnActivityReceived(ctx context.Context, activity *o.Activity) error
 }
 
+// InboxProcessor applies the side effects of a received activity, the
+// DefaultActivityPubFacade processes them on the activitypub tables.
+type InboxProcessor interface {
+	ReceiveActivity(activity *o.Activity) error
+}
+
 // InboxOptions provides configuration options for creating an inbox
 type InboxOptions struct {
 	ActorID   o.ID
 	MaxItems  int
 	Listeners []InboxListener
+	Processor InboxProcessor
 }
 
 // NewInbox creates a new inbox for the specified actor
@@ -51,16 +59,26 @@ func NewInbox(opts InboxOptions) *Inbox {
 		collection: collection,
 		actorID:    opts.ActorID,
 		listeners:  opts.Listeners,
+		processor:  opts.Processor,
 	}
 }
 
-// ReceiveActivity adds an activity to the inbox
+// ReceiveActivity processes an activity and adds it to the inbox. An activity received
+// again is neither processed nor added twice.
 func (i *Inbox) ReceiveActivity(ctx context.Context, activity *o.Activity) error {
 	i.mu.Lock()
 	defer i.mu.Unlock()
 
 	// Convert to activitypub type
 	apActivity := (*ap.Activity)(activity)
+	if apActivity.ID != "" && i.collection.OrderedItems.Contains(apActivity.ID) {
+		return nil
+	}
+	if i.processor != nil {
+		if err := i.processor.ReceiveActivity(activity); err != nil {
+			return err
+		}
+	}
 
 	// Add to the beginning of the collection (most recent first)
 	i.collection.OrderedItems = append(ap.ItemCollection{apActivity}, i.collection.OrderedItems...)
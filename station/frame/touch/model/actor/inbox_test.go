@@ -0,0 +1,32 @@
+package actor
+
+import (
+	"context"
+	"testing"
+
+	o "github.com/peers-touch/peers-touch/station/frame/object"
+	ap "github.com/peers-touch/peers-touch/station/frame/vendors/activitypub"
+)
+
+type countingProcessor map[ap.ID]int
+
+func (p countingProcessor) ReceiveActivity(activity *o.Activity) error {
+	p[activity.ID]++
+	return nil
+}
+
+func TestInboxReceiveIsIdempotent(t *testing.T) {
+	processed := countingProcessor{}
+	inbox := NewInbox(InboxOptions{ActorID: "https://example.com/activitypub/alice/actor", Processor: processed})
+
+	follow := ap.FollowNew("https://remote.example/activities/1", ap.IRI("https://example.com/activitypub/alice/actor"))
+	follow.Actor = ap.IRI("https://remote.example/users/bob")
+	for i := 0; i < 2; i++ {
+		if err := inbox.ReceiveActivity(context.Background(), (*o.Activity)(follow)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if inbox.Count() != 1 || processed[follow.ID] != 1 {
+		t.Fatalf("redelivery counted %d times, processed %d times", inbox.Count(), processed[follow.ID])
+	}
+}
@@ -16,6 +16,7 @@ type Outbox struct {
 	actorID    o.ID
 	mu         sync.RWMutex
 	listeners  []OutboxListener
+	deliveries DeliveryTracker
 }
 
 // OutboxListener defines the interface for outbox event listeners
@@ -24,11 +25,18 @@ type OutboxListener interface {
 	OnActivityQueued(ctx context.Context, activity *o.Activity) error
 }
 
+// DeliveryTracker tells whether an activity still has deliveries outstanding, the
+// DefaultActivityPubFacade tracks them in the delivery queue.
+type DeliveryTracker interface {
+	IsPending(activityID o.ID) (bool, error)
+}
+
 // OutboxOptions provides configuration options for creating an outbox
 type OutboxOptions struct {
-	ActorID   o.ID
-	MaxItems  int
-	Listeners []OutboxListener
+	ActorID    o.ID
+	MaxItems   int
+	Listeners  []OutboxListener
+	Deliveries DeliveryTracker
 }
 
 // NewOutbox creates a new outbox for the specified actor
@@ -52,6 +60,7 @@ func NewOutbox(opts OutboxOptions) *Outbox {
 		collection: collection,
 		actorID:    opts.ActorID,
 		listeners:  opts.Listeners,
+		deliveries: opts.Deliveries,
 	}
 }
 
@@ -177,19 +186,28 @@ func (o *Outbox) Clear() {
 	o.collection.TotalItems = 0
 }
 
-// GetPendingActivities returns activities that are queued but not yet sent
-// This is a placeholder - in a real implementation, you'd track delivery status
+// GetPendingActivities returns activities that are queued but not yet delivered to
+// every recipient. Without a DeliveryTracker all activities count as pending.
 func (ob *Outbox) GetPendingActivities() ([]o.Activity, error) {
 	ob.mu.RLock()
 	defer ob.mu.RUnlock()
 
-	// For now, return all activities as this is a simple implementation
-	// In a production system, you'd track delivery status separately
 	activities := make([]o.Activity, 0, len(ob.collection.OrderedItems))
 	for _, item := range ob.collection.OrderedItems {
-		if activity, ok := item.(*ap.Activity); ok {
-			activities = append(activities, o.Activity(*activity))
+		activity, ok := item.(*ap.Activity)
+		if !ok {
+			continue
+		}
+		if ob.deliveries != nil {
+			pending, err := ob.deliveries.IsPending(o.ID(activity.ID))
+			if err != nil {
+				return nil, err
+			}
+			if !pending {
+				continue
+			}
 		}
+		activities = append(activities, o.Activity(*activity))
 	}
 
 	return activities, nil
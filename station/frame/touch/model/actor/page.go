@@ -0,0 +1,194 @@
+package actor
+
+import (
+	o "github.com/peers-touch/peers-touch/station/frame/object"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+	"gorm.io/gorm"
+)
+
+const (
+	// DefaultPageSize is the number of items of a collection page when none is asked for
+	DefaultPageSize = 20
+	// MaxPageSize caps the items of a collection page
+	MaxPageSize = 80
+)
+
+// PageQuery selects a page of a collection, newest first. Pages are cut at the positions
+// of their items: Before selects the items older than it, Reverse the ones right after
+// After, from the oldest end when After is 0.
+type PageQuery struct {
+	Before  int64
+	After   int64
+	Reverse bool
+	Limit   int
+	// Viewer is the actor the collection is shown to, empty for anonymous requests
+	Viewer o.ID
+}
+
+// CollectionPage is a page of a collection with the positions it is cut at
+type CollectionPage struct {
+	Items o.ItemCollection
+	// Total counts the items of the whole collection the viewer may see
+	Total int64
+	// Newest and Oldest are the positions of the first and last items, HasNewer and
+	// HasOlder tell whether the collection goes on past them.
+	Newest, Oldest     int64
+	HasNewer, HasOlder bool
+}
+
+// positioned is an item of a collection with its position
+type positioned struct {
+	Item     string
+	Position int64
+}
+
+// GetOutboxPage retrieves a page of an actor's outbox. Anonymous viewers see its public
+// activities, accepted followers its followers-only ones too and the actor all of them.
+func (f *DefaultActivityPubFacade) GetOutboxPage(actorId o.ID, q PageQuery) (*CollectionPage, error) {
+	actor, err := loadActor(f.db, actorId)
+	if err != nil {
+		return nil, err
+	}
+	var follower bool
+	if q.Viewer != "" && q.Viewer != actorId {
+		if follower, err = f.isFollower(q.Viewer, actorId); err != nil {
+			return nil, err
+		}
+	}
+	base := func() *gorm.DB {
+		tx := f.db.Table("activitypub_collections AS c").
+			Joins("JOIN activitypub_activities AS a ON a.activity_pub_id = c.item_id").
+			Where("c.collection_id = ?", actor.OutboxURL)
+		switch {
+		case q.Viewer == actorId:
+		case follower:
+			tx = tx.Where("a.is_public = ? OR a.visibility = ?", true, db.VisibilityFollowers)
+		default:
+			tx = tx.Where("a.is_public = ?", true)
+		}
+		return tx
+	}
+	rows, page, err := paginate(base, "c.item_id", "c.position", q)
+	if err != nil {
+		return nil, err
+	}
+	if page.Items, err = f.activitiesByID(rows); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// GetFollowersPage retrieves a page of an actor's followers
+func (f *DefaultActivityPubFacade) GetFollowersPage(actorId o.ID, q PageQuery) (*CollectionPage, error) {
+	return f.followPage("following_id", "follower_id", actorId, q)
+}
+
+// GetFollowingPage retrieves a page of who an actor is following
+func (f *DefaultActivityPubFacade) GetFollowingPage(actorId o.ID, q PageQuery) (*CollectionPage, error) {
+	return f.followPage("follower_id", "following_id", actorId, q)
+}
+
+// GetLikedPage retrieves a page of an actor's liked items
+func (f *DefaultActivityPubFacade) GetLikedPage(actorId o.ID, q PageQuery) (*CollectionPage, error) {
+	base := func() *gorm.DB {
+		return f.db.Model(&db.ActivityPubLike{}).Where("actor_id = ? AND is_active = ?", string(actorId), true)
+	}
+	rows, page, err := paginate(base, "object_id", "id", q)
+	if err != nil {
+		return nil, err
+	}
+	page.Items = iriCollection(rows)
+	return page, nil
+}
+
+// followPage pages the accepted follows whose by column is the actor, follows are
+// positioned by their snowflake ids.
+func (f *DefaultActivityPubFacade) followPage(by, item string, actorId o.ID, q PageQuery) (*CollectionPage, error) {
+	base := func() *gorm.DB {
+		return f.db.Model(&db.ActivityPubFollow{}).Where(by+" = ? AND accepted = ? AND is_active = ?", string(actorId), true, true)
+	}
+	rows, page, err := paginate(base, item, "id", q)
+	if err != nil {
+		return nil, err
+	}
+	page.Items = iriCollection(rows)
+	return page, nil
+}
+
+// isFollower tells whether the actor follows the followed one, and was accepted
+func (f *DefaultActivityPubFacade) isFollower(actorId, followedId o.ID) (bool, error) {
+	var count int64
+	err := f.db.Model(&db.ActivityPubFollow{}).
+		Where("follower_id = ? AND following_id = ? AND accepted = ? AND is_active = ?", string(actorId), string(followedId), true, true).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// paginate cuts the page the query asks for out of the rows of base, returning the
+// items of the page newest first. base returns a fresh query for every statement.
+func paginate(base func() *gorm.DB, item, position string, q PageQuery) ([]string, *CollectionPage, error) {
+	limit := q.Limit
+	if limit <= 0 || limit > MaxPageSize {
+		limit = DefaultPageSize
+	}
+	page := &CollectionPage{}
+	if err := base().Count(&page.Total).Error; err != nil {
+		return nil, nil, err
+	}
+
+	var rows []positioned
+	tx := base().Select(item + " AS item, " + position + " AS position").Limit(limit + 1)
+	if q.Reverse {
+		tx = tx.Where(position+" > ?", q.After).Order(position + " ASC")
+	} else {
+		if q.Before > 0 {
+			tx = tx.Where(position+" < ?", q.Before)
+		}
+		tx = tx.Order(position + " DESC")
+	}
+	if err := tx.Scan(&rows).Error; err != nil {
+		return nil, nil, err
+	}
+	more := len(rows) > limit
+	if more {
+		rows = rows[:limit]
+	}
+	if q.Reverse {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+
+	// whether the collection goes on at the end the page was not cut from
+	var beyond int64
+	switch {
+	case q.Reverse && q.After > 0:
+		if err := base().Where(position+" <= ?", q.After).Count(&beyond).Error; err != nil {
+			return nil, nil, err
+		}
+	case !q.Reverse && q.Before > 0:
+		if err := base().Where(position+" >= ?", q.Before).Count(&beyond).Error; err != nil {
+			return nil, nil, err
+		}
+	}
+	if q.Reverse {
+		page.HasNewer, page.HasOlder = more, beyond > 0
+	} else {
+		page.HasNewer, page.HasOlder = beyond > 0, more
+	}
+
+	items := make([]string, 0, len(rows))
+	for _, r := range rows {
+		items = append(items, r.Item)
+	}
+	switch {
+	case len(rows) > 0:
+		page.Newest, page.Oldest = rows[0].Position, rows[len(rows)-1].Position
+	case q.Reverse:
+		// an empty page still joins the items on either side of it
+		page.Newest, page.Oldest = q.After, q.After+1
+	case q.Before > 0:
+		page.Newest, page.Oldest = q.Before-1, q.Before
+	}
+	return items, page, nil
+}
@@ -0,0 +1,267 @@
+package actor
+
+import (
+	"errors"
+	"time"
+
+	o "github.com/peers-touch/peers-touch/station/frame/object"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+	ap "github.com/peers-touch/peers-touch/station/frame/vendors/activitypub"
+	"gorm.io/gorm"
+)
+
+// inboxHandlers apply the side effects of received activities by type. Activities of
+// other types are stored without acting on them.
+var inboxHandlers = map[ap.ActivityVocabularyType]func(tx *gorm.DB, a *ap.Activity) error{
+	ap.FollowType:   processFollow,
+	ap.AcceptType:   processAccept,
+	ap.RejectType:   processReject,
+	ap.UndoType:     processUndo,
+	ap.LikeType:     processLike,
+	ap.AnnounceType: processAnnounce,
+	ap.CreateType:   processCreate,
+	ap.UpdateType:   processUpdate,
+	ap.DeleteType:   processDelete,
+	ap.FlagType:     processFlag,
+	ap.BlockType:    processBlock,
+}
+
+// process applies the side effects of a received activity, it runs once per activity.
+func process(tx *gorm.DB, a *ap.Activity) error {
+	handle, ok := inboxHandlers[a.Type]
+	if !ok {
+		return nil
+	}
+	return handle(tx, a)
+}
+
+// processFollow records a follow of a local actor. Actors that do not approve their
+// followers manually accept it right away, the others accept or reject it later.
+func processFollow(tx *gorm.DB, a *ap.Activity) error {
+	target, err := loadActor(tx, o.ID(link(a.Object)))
+	if errors.Is(err, model.ErrActorNotFound) {
+		return nil
+	}
+	if err != nil || !target.IsLocal {
+		return err
+	}
+
+	follower := link(a.Actor)
+	var follow db.ActivityPubFollow
+	err = tx.Where("follower_id = ? AND following_id = ? AND is_active = ?", follower, target.ActivityPubID, true).Limit(1).Find(&follow).Error
+	if err != nil {
+		return err
+	}
+	if follow.ID == 0 {
+		follow = db.ActivityPubFollow{
+			FollowerID:  follower,
+			FollowingID: target.ActivityPubID,
+			ActivityID:  string(a.ID),
+			Accepted:    !target.ManuallyApproves,
+			IsActive:    true,
+		}
+		if err := tx.Select("*").Create(&follow).Error; err != nil {
+			return err
+		}
+	} else if err := tx.Model(&follow).Update("activity_id", string(a.ID)).Error; err != nil {
+		// a follower that follows again gets the answer to its new Follow
+		return err
+	}
+	if !follow.Accepted {
+		return nil
+	}
+	return answerFollow(tx, target, a, ap.AcceptType)
+}
+
+// answerFollow publishes the Accept or Reject of a Follow, addressed to the follower.
+func answerFollow(tx *gorm.DB, target *db.ActivityPubActor, follow *ap.Activity, answer ap.ActivityVocabularyType) error {
+	a := ap.ActivityNew("", answer, follow)
+	a.To = ap.ItemCollection{ap.IRI(link(follow.Actor))}
+	return publish(tx, target, a)
+}
+
+// processAccept accepts a follow of a local actor, the accepting actor has to be the
+// one followed.
+func processAccept(tx *gorm.DB, a *ap.Activity) error {
+	return tx.Model(&db.ActivityPubFollow{}).
+		Where("activity_id = ? AND following_id = ? AND is_active = ?", link(a.Object), link(a.Actor), true).
+		Update("accepted", true).Error
+}
+
+// processReject ends a follow of a local actor, accepted or not.
+func processReject(tx *gorm.DB, a *ap.Activity) error {
+	return tx.Model(&db.ActivityPubFollow{}).
+		Where("activity_id = ? AND following_id = ?", link(a.Object), link(a.Actor)).
+		Updates(map[string]interface{}{"accepted": false, "is_active": false}).Error
+}
+
+// processUndo reverses a Follow, Like or Announce of the same actor. The undone activity
+// is the stored one, or the embedded one when it was never received.
+func processUndo(tx *gorm.DB, a *ap.Activity) error {
+	actor := link(a.Actor)
+	undoneID := link(a.Object)
+	var row db.ActivityPubActivity
+	if err := tx.Where("activity_pub_id = ?", undoneID).Limit(1).Find(&row).Error; err != nil {
+		return err
+	}
+	typ, object := ap.ActivityVocabularyType(row.Type), row.ObjectID
+	if row.ID == 0 {
+		undone, err := ap.ToActivity(a.Object)
+		if err != nil {
+			return nil
+		}
+		typ, object = undone.Type, link(undone.Object)
+		row.ActorID = link(undone.Actor)
+	}
+	if row.ActorID != actor {
+		return model.ErrActivityPubNotOwner
+	}
+
+	switch typ {
+	case ap.FollowType:
+		return tx.Model(&db.ActivityPubFollow{}).
+			Where("follower_id = ? AND (activity_id = ? OR following_id = ?)", actor, undoneID, object).
+			Update("is_active", false).Error
+	case ap.LikeType:
+		return tx.Model(&db.ActivityPubLike{}).
+			Where("actor_id = ? AND (activity_id = ? OR object_id = ?)", actor, undoneID, object).
+			Update("is_active", false).Error
+	case ap.AnnounceType:
+		err := tx.Model(&db.ActivityPubAnnounce{}).
+			Where("actor_id = ? AND (activity_id = ? OR object_id = ?)", actor, undoneID, object).
+			Update("is_active", false).Error
+		if err != nil {
+			return err
+		}
+		return unfeed(tx, "activity_id", undoneID)
+	}
+	return nil
+}
+
+// processLike records a like, liking twice is a no-op.
+func processLike(tx *gorm.DB, a *ap.Activity) error {
+	actor, object := link(a.Actor), link(a.Object)
+	var count int64
+	if err := tx.Model(&db.ActivityPubLike{}).Where("actor_id = ? AND object_id = ? AND is_active = ?", actor, object, true).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 || object == "" {
+		return nil
+	}
+	return tx.Select("*").Create(&db.ActivityPubLike{ActorID: actor, ObjectID: object, ActivityID: string(a.ID), IsActive: true}).Error
+}
+
+// processAnnounce records an announce, announcing twice is a no-op.
+func processAnnounce(tx *gorm.DB, a *ap.Activity) error {
+	actor, object := link(a.Actor), link(a.Object)
+	var count int64
+	if err := tx.Model(&db.ActivityPubAnnounce{}).Where("actor_id = ? AND object_id = ? AND is_active = ?", actor, object, true).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 || object == "" {
+		return nil
+	}
+	return tx.Select("*").Create(&db.ActivityPubAnnounce{ActorID: actor, ObjectID: object, ActivityID: string(a.ID), IsActive: true}).Error
+}
+
+// processCreate stores the object created, it has to be attributed to the actor.
+func processCreate(tx *gorm.DB, a *ap.Activity) error {
+	return upsertObject(tx, a)
+}
+
+// processUpdate replaces a stored object of the actor, or the actor itself.
+func processUpdate(tx *gorm.DB, a *ap.Activity) error {
+	if ap.IsNil(a.Object) || ap.IsIRI(a.Object) {
+		return nil
+	}
+	if ap.ActorTypes.Contains(a.Object.GetType()) {
+		if link(a.Object) != link(a.Actor) {
+			return model.ErrActivityPubNotOwner
+		}
+		updated, err := ap.ToActor(a.Object)
+		if err != nil {
+			return model.ErrActivityInvalid
+		}
+		stored, err := loadActor(tx, o.ID(updated.ID))
+		if err == nil && stored.IsLocal {
+			return model.ErrActivityPubNotOwner
+		}
+		return storeActor(tx, (*Actor)(updated), false, "")
+	}
+	return upsertObject(tx, a)
+}
+
+// upsertObject stores the object an activity carries when the actor is its author, and
+// the object is on the host of the actor.
+func upsertObject(tx *gorm.DB, a *ap.Activity) error {
+	if ap.IsNil(a.Object) || ap.IsIRI(a.Object) || !ap.ObjectTypes.Contains(a.Object.GetType()) {
+		return nil
+	}
+	actor := link(a.Actor)
+	if !SameHost(link(a.Object), actor) {
+		return model.ErrActivityPubNotOwner
+	}
+	author := ""
+	_ = ap.OnObject(a.Object, func(ob *ap.Object) error {
+		author = link(ob.AttributedTo)
+		if author == "" {
+			ob.AttributedTo = ap.IRI(actor)
+			author = actor
+		}
+		return nil
+	})
+	if author != actor {
+		return model.ErrActivityPubNotOwner
+	}
+	var row db.ActivityPubObject
+	if err := tx.Where("activity_pub_id = ?", link(a.Object)).Limit(1).Find(&row).Error; err != nil {
+		return err
+	}
+	if row.ID != 0 && (row.AttributedTo != actor || row.IsLocal) {
+		return model.ErrActivityPubNotOwner
+	}
+	return saveObject(tx, a.Object, false)
+}
+
+// processDelete tombstones a stored object of the actor. An actor deleting itself is
+// deactivated together with its follows.
+func processDelete(tx *gorm.DB, a *ap.Activity) error {
+	actor, id := link(a.Actor), link(a.Object)
+	if id == actor {
+		return deactivateActor(tx, actor)
+	}
+
+	var row db.ActivityPubObject
+	if err := tx.Where("activity_pub_id = ?", id).Limit(1).Find(&row).Error; err != nil {
+		return err
+	}
+	if row.ID == 0 || row.Type == string(ap.TombstoneType) {
+		return nil
+	}
+	if row.AttributedTo != actor || row.IsLocal {
+		return model.ErrActivityPubNotOwner
+	}
+	return tombstone(tx, &row)
+}
+
+// tombstone replaces an object by a Tombstone, keeping its id, author and former type.
+// The activities of the object leave the timelines and its parent counts a reply less.
+func tombstone(tx *gorm.DB, row *db.ActivityPubObject) error {
+	now := time.Now()
+	if err := row.SetMetadata(map[string]interface{}{"formerType": row.Type, "deleted": now}); err != nil {
+		return err
+	}
+	row.Type = string(ap.TombstoneType)
+	row.Name, row.Content, row.Summary, row.URL = "", "", "", ""
+	row.Updated = &now
+	if err := tx.Select("*").Save(row).Error; err != nil {
+		return err
+	}
+	if row.InReplyTo != "" {
+		if err := countReplies(tx, row.InReplyTo); err != nil {
+			return err
+		}
+	}
+	return unfeed(tx, "object_id", row.ActivityPubID)
+}
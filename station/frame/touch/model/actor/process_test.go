@@ -0,0 +1,339 @@
+package actor
+
+import (
+	"errors"
+	"testing"
+
+	o "github.com/peers-touch/peers-touch/station/frame/object"
+	"github.com/peers-touch/peers-touch/station/frame/touch/internal/storetest"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+	ap "github.com/peers-touch/peers-touch/station/frame/vendors/activitypub"
+	"gorm.io/gorm"
+)
+
+const (
+	alice   = "https://local.example/activitypub/alice/actor"
+	carol   = "https://local.example/activitypub/carol/actor"
+	bob     = "https://remote.example/users/bob"
+	mallory = "https://evil.example/users/mallory"
+
+	pendingFollow = "https://local.example/activitypub/alice/outbox/follow-bob"
+	bobLike       = "https://remote.example/activities/like-1"
+	bobNote       = "https://remote.example/notes/1"
+	aliceNote     = "https://local.example/activitypub/alice/notes/1"
+)
+
+// seedProcess stores local alice and carol, carol approving her followers manually,
+// remote bob and mallory, a pending follow of bob by alice, a like of bob and a note of
+// bob and of alice.
+func seedProcess(t *testing.T) *gorm.DB {
+	t.Helper()
+	rds := storetest.Reset(t)
+	for _, a := range []struct {
+		iri   string
+		local bool
+	}{{alice, true}, {carol, true}, {bob, false}, {mallory, false}} {
+		p := ap.PersonNew(ap.ID(a.iri))
+		p.Inbox = ap.IRI(a.iri + "/inbox")
+		p.Outbox = ap.IRI(a.iri + "/outbox")
+		if err := storeActor(rds, (*Actor)(p), a.local, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rds.Model(&db.ActivityPubActor{}).Where("activity_pub_id = ?", carol).Update("manually_approves", true).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := rds.Select("*").Create(&db.ActivityPubFollow{FollowerID: alice, FollowingID: bob, ActivityID: pendingFollow, IsActive: true}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	like := ap.LikeNew(bobLike, ap.IRI(aliceNote))
+	like.Actor = ap.IRI(bob)
+	row := &db.ActivityPubActivity{}
+	if err := activityToRow(like, row); err != nil {
+		t.Fatal(err)
+	}
+	if err := rds.Select("*").Create(row).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := rds.Select("*").Create(&db.ActivityPubLike{ActorID: bob, ObjectID: aliceNote, ActivityID: bobLike, IsActive: true}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	for _, n := range []struct {
+		id, author string
+		local      bool
+	}{{bobNote, bob, false}, {aliceNote, alice, true}} {
+		if err := saveObject(rds, note(n.id, n.author, "hello"), n.local); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rds
+}
+
+func note(id, author, content string) *ap.Object {
+	n := ap.ObjectNew(ap.NoteType)
+	n.ID = ap.ID(id)
+	n.AttributedTo = ap.IRI(author)
+	n.Content = ap.DefaultNaturalLanguageValue(content)
+	return n
+}
+
+func activity(id string, typ ap.ActivityVocabularyType, actor string, object ap.Item) *ap.Activity {
+	a := ap.ActivityNew(ap.ID(id), typ, object)
+	a.Actor = ap.IRI(actor)
+	return a
+}
+
+func follow(t *testing.T, rds *gorm.DB, follower, following string) *db.ActivityPubFollow {
+	t.Helper()
+	var f db.ActivityPubFollow
+	if err := rds.Where("follower_id = ? AND following_id = ?", follower, following).Limit(1).Find(&f).Error; err != nil {
+		t.Fatal(err)
+	}
+	return &f
+}
+
+func storedObject(t *testing.T, rds *gorm.DB, id string) *db.ActivityPubObject {
+	t.Helper()
+	var row db.ActivityPubObject
+	if err := rds.Where("activity_pub_id = ?", id).Limit(1).Find(&row).Error; err != nil {
+		t.Fatal(err)
+	}
+	return &row
+}
+
+func likeActive(t *testing.T, rds *gorm.DB) bool {
+	t.Helper()
+	var like db.ActivityPubLike
+	if err := rds.Where("activity_id = ?", bobLike).First(&like).Error; err != nil {
+		t.Fatal(err)
+	}
+	return like.IsActive
+}
+
+func answers(t *testing.T, rds *gorm.DB, typ ap.ActivityVocabularyType, actor string) int64 {
+	t.Helper()
+	var n int64
+	if err := rds.Model(&db.ActivityPubActivity{}).Where("type = ? AND actor_id = ?", string(typ), actor).Count(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestProcess(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		activity *ap.Activity
+		want     error
+		check    func(t *testing.T, rds *gorm.DB)
+	}{
+		{
+			name:     "follow accepted right away",
+			activity: activity("https://remote.example/activities/follow-1", ap.FollowType, bob, ap.IRI(alice)),
+			check: func(t *testing.T, rds *gorm.DB) {
+				if f := follow(t, rds, bob, alice); !f.Accepted || !f.IsActive || f.ActivityID != "https://remote.example/activities/follow-1" {
+					t.Fatalf("follow = %+v", f)
+				}
+				if n := answers(t, rds, ap.AcceptType, alice); n != 1 {
+					t.Fatalf("alice published %d accepts", n)
+				}
+			},
+		},
+		{
+			name:     "follow waiting for a manual accept",
+			activity: activity("https://remote.example/activities/follow-2", ap.FollowType, bob, ap.IRI(carol)),
+			check: func(t *testing.T, rds *gorm.DB) {
+				if f := follow(t, rds, bob, carol); f.Accepted || !f.IsActive {
+					t.Fatalf("follow = %+v", f)
+				}
+				if n := answers(t, rds, ap.AcceptType, carol); n != 0 {
+					t.Fatalf("carol published %d accepts", n)
+				}
+			},
+		},
+		{
+			name:     "follow of a remote actor",
+			activity: activity("https://evil.example/activities/follow-3", ap.FollowType, mallory, ap.IRI(bob)),
+			check: func(t *testing.T, rds *gorm.DB) {
+				if f := follow(t, rds, mallory, bob); f.ID != 0 {
+					t.Fatalf("follow = %+v", f)
+				}
+			},
+		},
+		{
+			name:     "accept by the actor followed",
+			activity: activity("https://remote.example/activities/accept-1", ap.AcceptType, bob, ap.IRI(pendingFollow)),
+			check: func(t *testing.T, rds *gorm.DB) {
+				if f := follow(t, rds, alice, bob); !f.Accepted {
+					t.Fatalf("follow = %+v", f)
+				}
+			},
+		},
+		{
+			name:     "accept by another actor",
+			activity: activity("https://evil.example/activities/accept-2", ap.AcceptType, mallory, ap.IRI(pendingFollow)),
+			check: func(t *testing.T, rds *gorm.DB) {
+				if f := follow(t, rds, alice, bob); f.Accepted {
+					t.Fatalf("follow = %+v", f)
+				}
+			},
+		},
+		{
+			name:     "reject by the actor followed",
+			activity: activity("https://remote.example/activities/reject-1", ap.RejectType, bob, ap.IRI(pendingFollow)),
+			check: func(t *testing.T, rds *gorm.DB) {
+				if f := follow(t, rds, alice, bob); f.IsActive {
+					t.Fatalf("follow = %+v", f)
+				}
+			},
+		},
+		{
+			name:     "reject by another actor",
+			activity: activity("https://evil.example/activities/reject-2", ap.RejectType, mallory, ap.IRI(pendingFollow)),
+			check: func(t *testing.T, rds *gorm.DB) {
+				if f := follow(t, rds, alice, bob); !f.IsActive {
+					t.Fatalf("follow = %+v", f)
+				}
+			},
+		},
+		{
+			name:     "undo of an own like",
+			activity: activity("https://remote.example/activities/undo-1", ap.UndoType, bob, ap.IRI(bobLike)),
+			check: func(t *testing.T, rds *gorm.DB) {
+				if likeActive(t, rds) {
+					t.Fatal("like still active")
+				}
+			},
+		},
+		{
+			name:     "undo of the stored like of another actor",
+			activity: activity("https://evil.example/activities/undo-2", ap.UndoType, mallory, ap.IRI(bobLike)),
+			want:     model.ErrActivityPubNotOwner,
+			check: func(t *testing.T, rds *gorm.DB) {
+				if !likeActive(t, rds) {
+					t.Fatal("like undone")
+				}
+			},
+		},
+		{
+			name: "undo of an embedded activity of another actor",
+			activity: activity("https://evil.example/activities/undo-3", ap.UndoType, mallory,
+				activity("https://remote.example/activities/like-2", ap.LikeType, bob, ap.IRI(aliceNote))),
+			want: model.ErrActivityPubNotOwner,
+			check: func(t *testing.T, rds *gorm.DB) {
+				if !likeActive(t, rds) {
+					t.Fatal("like undone")
+				}
+			},
+		},
+		{
+			name:     "update of an own note",
+			activity: activity("https://remote.example/activities/update-1", ap.UpdateType, bob, note(bobNote, bob, "edited")),
+			check: func(t *testing.T, rds *gorm.DB) {
+				if row := storedObject(t, rds, bobNote); row.Content != "edited" {
+					t.Fatalf("content = %q", row.Content)
+				}
+			},
+		},
+		{
+			name:     "update of the note of another actor",
+			activity: activity("https://evil.example/activities/update-2", ap.UpdateType, mallory, note(bobNote, mallory, "forged")),
+			want:     model.ErrActivityPubNotOwner,
+		},
+		{
+			name:     "update of a local note",
+			activity: activity("https://remote.example/activities/update-3", ap.UpdateType, bob, note(aliceNote, bob, "forged")),
+			want:     model.ErrActivityPubNotOwner,
+		},
+		{
+			name:     "update of another actor",
+			activity: activity("https://evil.example/activities/update-4", ap.UpdateType, mallory, ap.PersonNew(ap.ID(bob))),
+			want:     model.ErrActivityPubNotOwner,
+		},
+		{
+			name:     "create attributed to another actor",
+			activity: activity("https://evil.example/activities/create-1", ap.CreateType, mallory, note("https://evil.example/notes/1", bob, "forged")),
+			want:     model.ErrActivityPubNotOwner,
+		},
+		{
+			name:     "create of an object of another host",
+			activity: activity("https://evil.example/activities/create-2", ap.CreateType, mallory, note("https://remote.example/notes/2", mallory, "forged")),
+			want:     model.ErrActivityPubNotOwner,
+			check: func(t *testing.T, rds *gorm.DB) {
+				if row := storedObject(t, rds, "https://remote.example/notes/2"); row.ID != 0 {
+					t.Fatalf("object stored: %+v", row)
+				}
+			},
+		},
+		{
+			name:     "delete of an own note",
+			activity: activity("https://remote.example/activities/delete-1", ap.DeleteType, bob, ap.IRI(bobNote)),
+			check: func(t *testing.T, rds *gorm.DB) {
+				if row := storedObject(t, rds, bobNote); row.Type != string(ap.TombstoneType) {
+					t.Fatalf("type = %s", row.Type)
+				}
+			},
+		},
+		{
+			name:     "delete of the note of another actor",
+			activity: activity("https://evil.example/activities/delete-2", ap.DeleteType, mallory, ap.IRI(bobNote)),
+			want:     model.ErrActivityPubNotOwner,
+		},
+		{
+			name:     "delete of a local note",
+			activity: activity("https://remote.example/activities/delete-3", ap.DeleteType, bob, ap.IRI(aliceNote)),
+			want:     model.ErrActivityPubNotOwner,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rds := seedProcess(t)
+			err := process(rds, tc.activity)
+			if !errors.Is(err, tc.want) {
+				t.Fatalf("got %v, want %v", err, tc.want)
+			}
+			if tc.want != nil {
+				// rejected activities leave the stored notes alone
+				for _, id := range []string{bobNote, aliceNote} {
+					if row := storedObject(t, rds, id); row.Content != "hello" || row.Type != string(ap.NoteType) {
+						t.Fatalf("%s changed: %+v", id, row)
+					}
+				}
+			}
+			if tc.check != nil {
+				tc.check(t, rds)
+			}
+		})
+	}
+}
+
+func TestReceiveChecksOrigin(t *testing.T) {
+	rds := seedProcess(t)
+	f := NewDefaultActivityPubFacade(rds).(*DefaultActivityPubFacade)
+
+	forged := activity("https://remote.example/activities/create-9", ap.CreateType, mallory, note("https://evil.example/notes/9", mallory, "hi"))
+	if err := f.ReceiveActivityFor(o.ID(alice), (*o.Activity)(forged)); !errors.Is(err, model.ErrActivityInvalid) {
+		t.Fatalf("activity id of another host: got %v", err)
+	}
+
+	// the announced note of another host is kept by reference, not as mallory tells it
+	announce := activity("https://evil.example/activities/announce-1", ap.AnnounceType, mallory, note("https://remote.example/notes/3", bob, "forged"))
+	if err := f.ReceiveActivityFor(o.ID(alice), (*o.Activity)(announce)); err != nil {
+		t.Fatal(err)
+	}
+	if row := storedObject(t, rds, "https://remote.example/notes/3"); row.ID != 0 {
+		t.Fatalf("embedded object of another host stored: %+v", row)
+	}
+	var row db.ActivityPubActivity
+	if err := rds.Where("activity_pub_id = ?", string(announce.ID)).First(&row).Error; err != nil {
+		t.Fatal(err)
+	}
+	stored, err := activityFromRow(&row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ap.IsIRI(stored.Object) || link(stored.Object) != "https://remote.example/notes/3" {
+		t.Fatalf("stored object = %#v", stored.Object)
+	}
+}
@@ -0,0 +1,74 @@
+package actor
+
+import (
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+	ap "github.com/peers-touch/peers-touch/station/frame/vendors/activitypub"
+	"gorm.io/gorm"
+)
+
+// recipients returns the local actors a received activity is meant for: its addressees,
+// the actors its object mentions and, when it is addressed to the public or to the
+// followers of its actor, the local followers of that actor.
+func recipients(tx *gorm.DB, a *ap.Activity) ([]*db.ActivityPubActor, error) {
+	addressed := audience(a)
+	candidates := append(iris(addressed), mentions(a.Object)...)
+
+	author := link(a.Actor)
+	var followers string
+	if err := tx.Model(&db.ActivityPubActor{}).Where("activity_pub_id = ?", author).Limit(1).Pluck("followers_url", &followers).Error; err != nil {
+		return nil, err
+	}
+	if addressed.Contains(ap.PublicNS) || (followers != "" && addressed.Contains(ap.IRI(followers))) {
+		var local []string
+		err := tx.Model(&db.ActivityPubFollow{}).
+			Joins("JOIN activitypub_actors ON activitypub_actors.activity_pub_id = activitypub_follows.follower_id").
+			Where("activitypub_follows.following_id = ? AND activitypub_follows.accepted = ? AND activitypub_follows.is_active = ? AND activitypub_actors.is_local = ?", author, true, true, true).
+			Pluck("activitypub_follows.follower_id", &local).Error
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, local...)
+	}
+
+	var locals []*db.ActivityPubActor
+	if len(candidates) == 0 {
+		return locals, nil
+	}
+	err := tx.Where("activity_pub_id IN ? AND is_local = ? AND is_active = ? AND suspended_at IS NULL", dedupe(candidates), true, true).Find(&locals).Error
+	return locals, err
+}
+
+// mentions returns the actors the Mention tags of an object link to
+func mentions(it ap.Item) []string {
+	var hrefs []string
+	if ap.IsNil(it) || ap.IsIRI(it) || !ap.ObjectTypes.Contains(it.GetType()) {
+		return hrefs
+	}
+	_ = ap.OnObject(it, func(ob *ap.Object) error {
+		for _, tag := range ob.Tag {
+			if ap.IsNil(tag) || tag.GetType() != ap.MentionType || !tag.IsLink() {
+				continue
+			}
+			_ = ap.OnLink(tag, func(l *ap.Link) error {
+				if l.Href != "" {
+					hrefs = append(hrefs, string(l.Href))
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return hrefs
+}
+
+func dedupe(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	unique := values[:0]
+	for _, v := range values {
+		if v != "" && !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
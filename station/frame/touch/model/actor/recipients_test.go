@@ -0,0 +1,30 @@
+package actor
+
+import (
+	"reflect"
+	"testing"
+
+	ap "github.com/peers-touch/peers-touch/station/frame/vendors/activitypub"
+)
+
+func TestMentions(t *testing.T) {
+	it, err := ap.UnmarshalJSON([]byte(`{
+		"id": "https://remote.example/notes/1",
+		"type": "Note",
+		"content": "hi @alice and #go",
+		"tag": [
+			{"type": "Mention", "href": "https://example.com/activitypub/alice/actor", "name": "@alice@example.com"},
+			{"type": "Hashtag", "href": "https://example.com/tags/go", "name": "#go"}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := mentions(it)
+	if want := []string{"https://example.com/activitypub/alice/actor"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("mentions = %v, want %v", got, want)
+	}
+	if got := mentions(ap.IRI("https://remote.example/notes/1")); len(got) != 0 {
+		t.Fatalf("mentions of an IRI = %v", got)
+	}
+}
@@ -0,0 +1,88 @@
+package actor
+
+import (
+	"time"
+
+	o "github.com/peers-touch/peers-touch/station/frame/object"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+	ap "github.com/peers-touch/peers-touch/station/frame/vendors/activitypub"
+	"gorm.io/gorm"
+)
+
+// FetchState tells where a stored actor or object came from and how fresh it is.
+type FetchState struct {
+	Local bool
+	// Active is false for deleted actors and tombstoned objects
+	Active      bool
+	LastFetched *time.Time
+}
+
+// FetchedActor returns a stored actor with its fetch state, nil when it is not stored
+func (f *DefaultActivityPubFacade) FetchedActor(id o.ID) (*Actor, FetchState, error) {
+	var row db.ActivityPubActor
+	if err := f.db.Where("activity_pub_id = ?", string(id)).Limit(1).Find(&row).Error; err != nil || row.ID == 0 {
+		return nil, FetchState{}, err
+	}
+	return actorFromRow(&row), FetchState{Local: row.IsLocal, Active: row.IsActive, LastFetched: row.LastFetched}, nil
+}
+
+// FetchedObject returns a stored object with its fetch state, nil when it is not stored
+func (f *DefaultActivityPubFacade) FetchedObject(id o.ID) (*ap.Object, FetchState, error) {
+	var row db.ActivityPubObject
+	if err := f.db.Where("activity_pub_id = ?", string(id)).Limit(1).Find(&row).Error; err != nil || row.ID == 0 {
+		return nil, FetchState{}, err
+	}
+	state := FetchState{Local: row.IsLocal, Active: row.Type != string(ap.TombstoneType), LastFetched: row.LastFetched}
+	return objectFromRow(&row), state, nil
+}
+
+// SaveRemoteObject stores a fetched remote object, it never replaces a local one
+func (f *DefaultActivityPubFacade) SaveRemoteObject(ob *ap.Object) error {
+	if ob == nil || ob.ID == "" {
+		return model.ErrActivityInvalid
+	}
+	return f.db.Transaction(func(tx *gorm.DB) error {
+		var row db.ActivityPubObject
+		if err := tx.Where("activity_pub_id = ?", string(ob.ID)).Limit(1).Find(&row).Error; err != nil {
+			return err
+		}
+		if row.IsLocal {
+			return model.ErrActivityPubNotOwner
+		}
+		if err := saveObject(tx, ob, false); err != nil {
+			return err
+		}
+		return tx.Model(&db.ActivityPubObject{}).Where("activity_pub_id = ?", string(ob.ID)).Update("last_fetched", time.Now()).Error
+	})
+}
+
+// GoneActor marks a remote actor that was deleted on its server, ending its follows
+func (f *DefaultActivityPubFacade) GoneActor(id o.ID) error {
+	return f.db.Transaction(func(tx *gorm.DB) error {
+		return deactivateActor(tx, string(id))
+	})
+}
+
+// GoneObject tombstones a remote object that was deleted on its server
+func (f *DefaultActivityPubFacade) GoneObject(id o.ID) error {
+	return f.db.Transaction(func(tx *gorm.DB) error {
+		var row db.ActivityPubObject
+		if err := tx.Where("activity_pub_id = ?", string(id)).Limit(1).Find(&row).Error; err != nil {
+			return err
+		}
+		if row.ID == 0 || row.IsLocal || row.Type == string(ap.TombstoneType) {
+			return nil
+		}
+		return tombstone(tx, &row)
+	})
+}
+
+// deactivateActor marks a remote actor deleted and ends the follows from and to it.
+func deactivateActor(tx *gorm.DB, iri string) error {
+	res := tx.Model(&db.ActivityPubActor{}).Where("activity_pub_id = ? AND is_local = ?", iri, false).Update("is_active", false)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return res.Error
+	}
+	return tx.Model(&db.ActivityPubFollow{}).Where("follower_id = ? OR following_id = ?", iri, iri).Update("is_active", false).Error
+}
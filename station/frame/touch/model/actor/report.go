@@ -0,0 +1,397 @@
+package actor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/peers-touch/peers-touch/station/frame/core/util/id"
+	o "github.com/peers-touch/peers-touch/station/frame/object"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+	ap "github.com/peers-touch/peers-touch/station/frame/vendors/activitypub"
+	"gorm.io/gorm"
+)
+
+// Actions the moderators take on a report
+const (
+	ReportSuspend      = "suspend"
+	ReportUnsuspend    = "unsuspend"
+	ReportDeleteObject = "delete_object"
+	ReportForward      = "forward"
+	ReportResolve      = "resolve"
+	ReportDismiss      = "dismiss"
+	ReportReopen       = "reopen"
+)
+
+// Report is a report as the moderation API shows it
+type Report struct {
+	ID         uint64         `json:"id,string"`
+	ActivityID string         `json:"activity_id,omitempty"`
+	Reporter   string         `json:"reporter"`
+	Target     string         `json:"target"`
+	Objects    []string       `json:"objects"`
+	Comment    string         `json:"comment,omitempty"`
+	Local      bool           `json:"local"`
+	Status     string         `json:"status"`
+	Forwarded  string         `json:"forwarded,omitempty"`
+	Actions    []ReportAction `json:"actions"`
+	ResolvedBy string         `json:"resolved_by,omitempty"`
+	ResolvedAt *time.Time     `json:"resolved_at,omitempty"`
+	CreatedAt  time.Time      `json:"created_at"`
+}
+
+// ReportAction is a moderation action taken on a report
+type ReportAction struct {
+	Action string    `json:"action"`
+	Object string    `json:"object,omitempty"` // Object deleted by delete_object
+	Note   string    `json:"note,omitempty"`
+	By     string    `json:"by"`
+	At     time.Time `json:"at"`
+}
+
+// FileReport files the report of a local actor against an actor and some of its objects.
+// Without a target, the author of the objects is reported.
+func (f *DefaultActivityPubFacade) FileReport(reporterId o.ID, targetId o.ID, objects []o.ID, comment string) (*Report, error) {
+	var report *Report
+	err := f.db.Transaction(func(tx *gorm.DB) error {
+		reporter, err := loadActor(tx, reporterId)
+		if err != nil {
+			return err
+		}
+		if !reporter.IsLocal {
+			return model.ErrActivityPubNotOwner
+		}
+		target, objectIDs, err := reported(tx, string(targetId), idStrings(objects))
+		if err != nil {
+			return err
+		}
+		if target == reporter.ActivityPubID {
+			return model.ErrReportInvalid
+		}
+		row := &db.ActivityPubReport{ReporterID: reporter.ActivityPubID, TargetID: target, Comment: comment, IsLocal: true}
+		if err := createReport(tx, row, objectIDs); err != nil {
+			return err
+		}
+		report, err = reportFromRow(row)
+		return err
+	})
+	return report, err
+}
+
+// processFlag files the report a remote server sends. Its object lists the actor
+// reported and some of its objects, the author of the objects being reported when the
+// actor is not listed. Flags about nothing stored are dropped.
+func processFlag(tx *gorm.DB, a *ap.Activity) error {
+	var items []string
+	if ap.IsItemCollection(a.Object) {
+		_ = ap.OnItemCollection(a.Object, func(col *ap.ItemCollection) error {
+			items = iris(*col)
+			return nil
+		})
+	} else if !ap.IsNil(a.Object) {
+		items = []string{link(a.Object)}
+	}
+	target, objects, err := reported(tx, "", items)
+	if errors.Is(err, model.ErrReportInvalid) || errors.Is(err, model.ErrActorNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	row := &db.ActivityPubReport{
+		ActivityID: string(a.ID),
+		ReporterID: link(a.Actor),
+		TargetID:   target,
+		Comment:    firstValue(a.Content),
+	}
+	return createReport(tx, row, objects)
+}
+
+// reported returns the actor a report is about and the stored objects of it among the
+// items reported. Items naming an actor set the target when it is not given.
+func reported(tx *gorm.DB, target string, items []string) (string, []string, error) {
+	var objectIDs []string
+	for _, iri := range items {
+		var a db.ActivityPubActor
+		if err := tx.Where("activity_pub_id = ?", iri).Limit(1).Find(&a).Error; err != nil {
+			return "", nil, err
+		}
+		if a.ID != 0 {
+			if target == "" {
+				target = iri
+			}
+			continue
+		}
+		objectIDs = append(objectIDs, iri)
+	}
+
+	var rows []db.ActivityPubObject
+	if len(objectIDs) > 0 {
+		if err := tx.Where("activity_pub_id IN ?", objectIDs).Find(&rows).Error; err != nil {
+			return "", nil, err
+		}
+	}
+	if target == "" && len(rows) > 0 {
+		target = rows[0].AttributedTo
+	}
+	if target == "" {
+		return "", nil, model.ErrReportInvalid
+	}
+	if _, err := loadActor(tx, o.ID(target)); err != nil {
+		return "", nil, err
+	}
+	objects := make([]string, 0, len(rows))
+	for _, r := range rows {
+		// only the objects of the actor reported go with the report
+		if r.AttributedTo == target {
+			objects = append(objects, r.ActivityPubID)
+		}
+	}
+	return target, objects, nil
+}
+
+func createReport(tx *gorm.DB, row *db.ActivityPubReport, objects []string) error {
+	b, err := json.Marshal(objects)
+	if err != nil {
+		return err
+	}
+	row.ObjectIDs = string(b)
+	row.Status = db.ReportOpen
+	return tx.Select("*").Create(row).Error
+}
+
+// GetReports returns up to limit reports with the status, or all of them when it is
+// empty, latest first and filed before the report before when it is set.
+func (f *DefaultActivityPubFacade) GetReports(status string, before uint64, limit int) ([]*Report, error) {
+	q := f.db.Model(&db.ActivityPubReport{})
+	if status != "" {
+		q = q.Where("status = ?", status)
+	}
+	if before > 0 {
+		q = q.Where("id < ?", before)
+	}
+	var rows []db.ActivityPubReport
+	if err := q.Order("id DESC").Limit(limit).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	reports := make([]*Report, 0, len(rows))
+	for i := range rows {
+		r, err := reportFromRow(&rows[i])
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, r)
+	}
+	return reports, nil
+}
+
+// GetReport returns a report
+func (f *DefaultActivityPubFacade) GetReport(reportId uint64) (*Report, error) {
+	row, err := loadReport(f.db, reportId)
+	if err != nil {
+		return nil, err
+	}
+	return reportFromRow(row)
+}
+
+// ModerateReport takes a moderation action on a report and records it. Suspending acts
+// on the actor reported, delete_object tombstones one of the objects reported, a local
+// one being deleted for its author, and forward sends the report as a Flag of the
+// moderator to the server of a remote actor reported.
+func (f *DefaultActivityPubFacade) ModerateReport(reportId uint64, action ReportAction, moderatorId o.ID) (*Report, error) {
+	var report *Report
+	err := f.db.Transaction(func(tx *gorm.DB) error {
+		row, err := loadReport(tx, reportId)
+		if err != nil {
+			return err
+		}
+		r, err := reportFromRow(row)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		action.At = now
+
+		switch action.Action {
+		case ReportSuspend:
+			err = suspend(tx, row.TargetID, &now)
+		case ReportUnsuspend:
+			err = suspend(tx, row.TargetID, nil)
+		case ReportDeleteObject:
+			err = deleteReported(tx, r, action.Object)
+		case ReportForward:
+			row.ForwardedID, err = forwardReport(tx, r, moderatorId)
+		case ReportResolve, ReportDismiss:
+			row.Status = db.ReportResolved
+			if action.Action == ReportDismiss {
+				row.Status = db.ReportDismissed
+			}
+			row.ResolvedBy, row.ResolvedAt = action.By, &now
+		case ReportReopen:
+			row.Status, row.ResolvedBy, row.ResolvedAt = db.ReportOpen, "", nil
+		default:
+			err = model.ErrReportBadAction
+		}
+		if err != nil {
+			return err
+		}
+
+		r.Actions = append(r.Actions, action)
+		b, err := json.Marshal(r.Actions)
+		if err != nil {
+			return err
+		}
+		row.Actions = string(b)
+		if err := tx.Select("*").Save(row).Error; err != nil {
+			return err
+		}
+		report, err = reportFromRow(row)
+		return err
+	})
+	return report, err
+}
+
+// Suspended tells whether a moderator suspended the actor
+func (f *DefaultActivityPubFacade) Suspended(id o.ID) (bool, error) {
+	var count int64
+	err := f.db.Model(&db.ActivityPubActor{}).Where("activity_pub_id = ? AND suspended_at IS NOT NULL", string(id)).Count(&count).Error
+	return count > 0, err
+}
+
+// suspend sets when the actor was suspended, nil lifting its suspension.
+func suspend(tx *gorm.DB, actorID string, at *time.Time) error {
+	res := tx.Model(&db.ActivityPubActor{}).Where("activity_pub_id = ?", actorID).Update("suspended_at", at)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return model.ErrActorNotFound
+	}
+	return nil
+}
+
+// deleteReported tombstones an object of a report. Local objects are deleted by their
+// author, so the deletion federates.
+func deleteReported(tx *gorm.DB, r *Report, objectID string) error {
+	found := false
+	for _, ob := range r.Objects {
+		found = found || ob == objectID
+	}
+	if !found {
+		return model.ErrActivityPubObjectNotFound
+	}
+	var row db.ActivityPubObject
+	if err := tx.Where("activity_pub_id = ?", objectID).Limit(1).Find(&row).Error; err != nil {
+		return err
+	}
+	if row.ID == 0 {
+		return model.ErrActivityPubObjectNotFound
+	}
+	if row.Type == string(ap.TombstoneType) {
+		return nil
+	}
+	if row.IsLocal {
+		author, err := loadActor(tx, o.ID(row.AttributedTo))
+		if err != nil {
+			return err
+		}
+		del := ap.DeleteNew("", ap.IRI(row.ActivityPubID))
+		del.To = ap.ItemCollection{ap.PublicNS}
+		if author.FollowersURL != "" {
+			del.CC = ap.ItemCollection{ap.IRI(author.FollowersURL)}
+		}
+		if err := publish(tx, author, del); err != nil {
+			return err
+		}
+	}
+	return tombstone(tx, &row)
+}
+
+// forwardReport sends a report to the server of the remote actor reported, as a Flag of
+// the moderator so the reporter stays anonymous. The Flag is stored for the delivery
+// queue but kept off the outbox of the moderator.
+func forwardReport(tx *gorm.DB, r *Report, moderatorId o.ID) (string, error) {
+	sender, err := loadActor(tx, moderatorId)
+	if err != nil {
+		return "", err
+	}
+	target, err := loadActor(tx, o.ID(r.Target))
+	if err != nil {
+		return "", err
+	}
+	if !sender.IsLocal {
+		return "", model.ErrActivityPubNotOwner
+	}
+	if target.IsLocal {
+		return "", model.ErrReportInvalid
+	}
+
+	flagged := ap.ItemCollection{ap.IRI(r.Target)}
+	for _, ob := range r.Objects {
+		flagged = append(flagged, ap.IRI(ob))
+	}
+	flag := ap.ActivityNew(ap.ID(fmt.Sprintf("%s/%s", sender.OutboxURL, id.NextULID())), ap.FlagType, flagged)
+	flag.Actor = ap.IRI(sender.ActivityPubID)
+	flag.To = ap.ItemCollection{ap.IRI(r.Target)}
+	flag.Published = time.Now()
+	setValue(&flag.Content, r.Comment)
+
+	row := &db.ActivityPubActivity{IsLocal: true}
+	if err := activityToRow(flag, row); err != nil {
+		return "", err
+	}
+	if err := tx.Select("*").Create(row).Error; err != nil {
+		return "", err
+	}
+	return string(flag.ID), enqueue(tx, sender, flag, []string{r.Target})
+}
+
+func loadReport(tx *gorm.DB, reportId uint64) (*db.ActivityPubReport, error) {
+	var row db.ActivityPubReport
+	if err := tx.Where("id = ?", reportId).Limit(1).Find(&row).Error; err != nil {
+		return nil, err
+	}
+	if row.ID == 0 {
+		return nil, model.ErrReportNotFound
+	}
+	return &row, nil
+}
+
+func reportFromRow(row *db.ActivityPubReport) (*Report, error) {
+	r := &Report{
+		ID:         row.ID,
+		ActivityID: row.ActivityID,
+		Reporter:   row.ReporterID,
+		Target:     row.TargetID,
+		Objects:    []string{},
+		Comment:    row.Comment,
+		Local:      row.IsLocal,
+		Status:     row.Status,
+		Forwarded:  row.ForwardedID,
+		Actions:    []ReportAction{},
+		ResolvedBy: row.ResolvedBy,
+		ResolvedAt: row.ResolvedAt,
+		CreatedAt:  row.CreatedAt,
+	}
+	if row.ObjectIDs != "" {
+		if err := json.Unmarshal([]byte(row.ObjectIDs), &r.Objects); err != nil {
+			return nil, err
+		}
+	}
+	if row.Actions != "" {
+		if err := json.Unmarshal([]byte(row.Actions), &r.Actions); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func idStrings(ids []o.ID) []string {
+	out := make([]string, len(ids))
+	for i, iri := range ids {
+		out[i] = string(iri)
+	}
+	return out
+}
@@ -0,0 +1,171 @@
+package actor
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	o "github.com/peers-touch/peers-touch/station/frame/object"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+	ap "github.com/peers-touch/peers-touch/station/frame/vendors/activitypub"
+)
+
+func TestReported(t *testing.T) {
+	rds := seedProcess(t)
+	for _, tc := range []struct {
+		name    string
+		target  string
+		items   []string
+		want    string
+		objects []string
+		err     error
+	}{
+		{"target and its object", bob, []string{bobNote}, bob, []string{bobNote}, nil},
+		{"author of the objects", "", []string{bobNote}, bob, []string{bobNote}, nil},
+		{"actor among the items", "", []string{bob, bobNote}, bob, []string{bobNote}, nil},
+		{"objects of others left out", "", []string{bob, aliceNote, bobNote}, bob, []string{bobNote}, nil},
+		{"target without its objects", mallory, []string{bobNote}, mallory, []string{}, nil},
+		{"objects not stored", bob, []string{"https://remote.example/notes/unknown"}, bob, []string{}, nil},
+		{"nothing stored", "", []string{"https://remote.example/notes/unknown"}, "", nil, model.ErrReportInvalid},
+		{"nothing", "", nil, "", nil, model.ErrReportInvalid},
+		{"target not stored", "https://remote.example/users/nobody", []string{bobNote}, "", nil, model.ErrActorNotFound},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			target, objects, err := reported(rds, tc.target, tc.items)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("err = %v, want %v", err, tc.err)
+			}
+			if target != tc.want || (tc.err == nil && !reflect.DeepEqual(objects, tc.objects)) {
+				t.Fatalf("reported %s %v, want %s %v", target, objects, tc.want, tc.objects)
+			}
+		})
+	}
+}
+
+func TestFileReport(t *testing.T) {
+	rds := seedProcess(t)
+	f := NewDefaultActivityPubFacade(rds).(*DefaultActivityPubFacade)
+	if _, err := f.FileReport(alice, alice, nil, ""); !errors.Is(err, model.ErrReportInvalid) {
+		t.Errorf("report of oneself: %v", err)
+	}
+	if _, err := f.FileReport(bob, mallory, nil, ""); !errors.Is(err, model.ErrActivityPubNotOwner) {
+		t.Errorf("report of a remote actor: %v", err)
+	}
+	r, err := f.FileReport(alice, "", []o.ID{bobNote}, "spam")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Reporter != alice || r.Target != bob || !reflect.DeepEqual(r.Objects, []string{bobNote}) || r.Comment != "spam" ||
+		!r.Local || r.Status != db.ReportOpen || len(r.Actions) != 0 {
+		t.Fatalf("report = %+v", r)
+	}
+}
+
+func TestModerateReport(t *testing.T) {
+	rds := seedProcess(t)
+	f := NewDefaultActivityPubFacade(rds).(*DefaultActivityPubFacade)
+	r, err := f.FileReport(alice, bob, []o.ID{bobNote}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		action    ReportAction
+		err       error
+		status    string
+		resolved  bool
+		suspended bool
+	}{
+		{ReportAction{Action: ReportSuspend}, nil, db.ReportOpen, false, true},
+		{ReportAction{Action: ReportUnsuspend}, nil, db.ReportOpen, false, false},
+		{ReportAction{Action: ReportResolve, By: carol}, nil, db.ReportResolved, true, false},
+		{ReportAction{Action: ReportReopen}, nil, db.ReportOpen, false, false},
+		{ReportAction{Action: ReportDismiss, By: carol}, nil, db.ReportDismissed, true, false},
+		{ReportAction{Action: "ban"}, model.ErrReportBadAction, db.ReportDismissed, true, false},
+		{ReportAction{Action: ReportDeleteObject, Object: aliceNote}, model.ErrActivityPubObjectNotFound, db.ReportDismissed, true, false},
+		{ReportAction{Action: ReportDeleteObject, Object: bobNote}, nil, db.ReportDismissed, true, false},
+	} {
+		before, err := f.GetReport(r.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := f.ModerateReport(r.ID, tc.action, carol)
+		if !errors.Is(err, tc.err) {
+			t.Fatalf("%s: err = %v, want %v", tc.action.Action, err, tc.err)
+		}
+		if err != nil {
+			// a failed action changes nothing
+			if got, err = f.GetReport(r.ID); err != nil {
+				t.Fatal(err)
+			}
+			if len(got.Actions) != len(before.Actions) {
+				t.Fatalf("%s: recorded", tc.action.Action)
+			}
+		} else if n := len(got.Actions); n != len(before.Actions)+1 || got.Actions[n-1].Action != tc.action.Action || got.Actions[n-1].At.IsZero() {
+			t.Fatalf("%s: actions = %+v", tc.action.Action, got.Actions)
+		}
+		if got.Status != tc.status || (got.ResolvedAt != nil) != tc.resolved || (got.ResolvedBy != "") != tc.resolved {
+			t.Fatalf("%s: status %s resolved by %q at %v", tc.action.Action, got.Status, got.ResolvedBy, got.ResolvedAt)
+		}
+		if suspended, err := f.Suspended(bob); err != nil || suspended != tc.suspended {
+			t.Fatalf("%s: suspended = %v, %v", tc.action.Action, suspended, err)
+		}
+	}
+	if ob := storedObject(t, rds, bobNote); ob.Type != string(ap.TombstoneType) {
+		t.Errorf("reported object is a %s", ob.Type)
+	}
+	if _, err := f.ModerateReport(r.ID+1, ReportAction{Action: ReportResolve}, carol); !errors.Is(err, model.ErrReportNotFound) {
+		t.Errorf("unknown report: %v", err)
+	}
+}
+
+func TestForwardReport(t *testing.T) {
+	rds := seedProcess(t)
+	f := NewDefaultActivityPubFacade(rds).(*DefaultActivityPubFacade)
+	local, err := f.FileReport(alice, carol, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.ModerateReport(local.ID, ReportAction{Action: ReportForward}, carol); !errors.Is(err, model.ErrReportInvalid) {
+		t.Fatalf("forward of a local target: %v", err)
+	}
+	if n := answers(t, rds, ap.FlagType, carol); n != 0 {
+		t.Fatalf("flags = %d", n)
+	}
+
+	remote, err := f.FileReport(alice, bob, []o.ID{bobNote}, "spam")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.ModerateReport(remote.ID, ReportAction{Action: ReportForward}, mallory); !errors.Is(err, model.ErrActivityPubNotOwner) {
+		t.Fatalf("forward by a remote actor: %v", err)
+	}
+	got, err := f.ModerateReport(remote.ID, ReportAction{Action: ReportForward}, carol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flag, err := f.GetActivity(o.ID(got.Forwarded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := (*ap.Activity)(flag)
+	if a.Type != ap.FlagType || link(a.Actor) != carol || !a.To.Contains(ap.IRI(bob)) {
+		t.Fatalf("flag = %s by %v to %v", a.Type, a.Actor, a.To)
+	}
+	var flagged []string
+	_ = ap.OnItemCollection(a.Object, func(col *ap.ItemCollection) error {
+		flagged = iris(*col)
+		return nil
+	})
+	if !reflect.DeepEqual(flagged, []string{bob, bobNote}) {
+		t.Fatalf("flagged = %v", flagged)
+	}
+	var deliveries []db.ActivityPubDelivery
+	if err := rds.Where("activity_id = ?", got.Forwarded).Find(&deliveries).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || deliveries[0].Inbox != bob+"/inbox" || deliveries[0].SenderID != carol {
+		t.Fatalf("deliveries = %+v", deliveries)
+	}
+}
@@ -0,0 +1,247 @@
+package actor
+
+import (
+	"time"
+
+	o "github.com/peers-touch/peers-touch/station/frame/object"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+	ap "github.com/peers-touch/peers-touch/station/frame/vendors/activitypub"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// MaxThreadDepth caps the ancestors walked up and backfilled from a reply
+	MaxThreadDepth = 64
+	// MaxThreadSize caps the objects of a thread
+	MaxThreadSize = 500
+	// MaxBackfillAttempts caps the fetches of a missing ancestor
+	MaxBackfillAttempts = 8
+)
+
+// ThreadNode is an object of a thread with its replies, oldest first
+type ThreadNode struct {
+	Object  *ap.Object    `json:"object"`
+	Replies []*ThreadNode `json:"replies,omitempty"`
+}
+
+// Thread is the conversation an object is part of, as a tree from the oldest ancestor
+// stored. Missing is the ancestor of the root that is not stored yet, Truncated tells
+// whether the thread has more objects than MaxThreadSize.
+type Thread struct {
+	ID        string      `json:"id,omitempty"`
+	Root      *ThreadNode `json:"root"`
+	Missing   string      `json:"missing,omitempty"`
+	Truncated bool        `json:"truncated,omitempty"`
+}
+
+// Backfill is a missing ancestor of a reply the backfill job fetches
+type Backfill struct {
+	ObjectID string
+	Depth    int
+	Attempts int
+}
+
+// PublicRemoteObject checks that a remote object is stored and public, its thread is
+// served then.
+func (f *DefaultActivityPubFacade) PublicRemoteObject(id o.ID) error {
+	var count int64
+	err := f.db.Model(&db.ActivityPubObject{}).
+		Where("activity_pub_id = ? AND is_local = ? AND is_public = ?", string(id), false, true).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return model.ErrActivityPubObjectNotFound
+	}
+	return nil
+}
+
+// GetThread returns the thread of an object: its public ancestors up to the root and
+// the public replies of the root, level by level. Deleted objects stay in it as their
+// Tombstones so the tree keeps its shape.
+func (f *DefaultActivityPubFacade) GetThread(id o.ID) (*Thread, error) {
+	var row db.ActivityPubObject
+	if err := f.db.Where("activity_pub_id = ?", string(id)).Limit(1).Find(&row).Error; err != nil {
+		return nil, err
+	}
+	if row.ID == 0 {
+		return nil, model.ErrActivityPubObjectNotFound
+	}
+
+	thread := &Thread{}
+	root := row
+	for depth := 0; root.InReplyTo != "" && depth < MaxThreadDepth; depth++ {
+		var parent db.ActivityPubObject
+		if err := f.db.Where("activity_pub_id = ?", root.InReplyTo).Limit(1).Find(&parent).Error; err != nil {
+			return nil, err
+		}
+		if parent.ID == 0 {
+			thread.Missing = root.InReplyTo
+			break
+		}
+		if !parent.IsPublic {
+			break
+		}
+		root = parent
+	}
+
+	thread.Root = &ThreadNode{Object: objectFromRow(&root)}
+	level := map[string]*ThreadNode{root.ActivityPubID: thread.Root}
+	size := 1
+	for len(level) > 0 && !thread.Truncated {
+		parents := make([]string, 0, len(level))
+		for iri := range level {
+			parents = append(parents, iri)
+		}
+		var rows []*db.ActivityPubObject
+		err := f.db.Where("in_reply_to IN ? AND is_public = ?", parents, true).
+			Order("published ASC, id ASC").Limit(MaxThreadSize - size + 1).Find(&rows).Error
+		if err != nil {
+			return nil, err
+		}
+		next := map[string]*ThreadNode{}
+		for _, r := range rows {
+			if size == MaxThreadSize {
+				thread.Truncated = true
+				break
+			}
+			node := &ThreadNode{Object: objectFromRow(r)}
+			level[r.InReplyTo].Replies = append(level[r.InReplyTo].Replies, node)
+			next[r.ActivityPubID] = node
+			size++
+		}
+		level = next
+	}
+	return thread, nil
+}
+
+// GetRepliesPage retrieves a page of the public replies of an object, positioned by the
+// snowflake ids of their rows.
+func (f *DefaultActivityPubFacade) GetRepliesPage(id o.ID, q PageQuery) (*CollectionPage, error) {
+	base := func() *gorm.DB {
+		return f.db.Model(&db.ActivityPubObject{}).
+			Where("in_reply_to = ? AND is_public = ? AND type <> ?", string(id), true, string(ap.TombstoneType))
+	}
+	rows, page, err := paginate(base, "activity_pub_id", "id", q)
+	if err != nil {
+		return nil, err
+	}
+	page.Items = iriCollection(rows)
+	return page, nil
+}
+
+// CountReplies returns the public replies stored of an object
+func (f *DefaultActivityPubFacade) CountReplies(id o.ID) (int64, error) {
+	var counts []int64
+	if err := f.db.Model(&db.ActivityPubObject{}).Where("activity_pub_id = ?", string(id)).Limit(1).Pluck("replies_count", &counts).Error; err != nil {
+		return 0, err
+	}
+	if len(counts) == 0 {
+		return 0, model.ErrActivityPubObjectNotFound
+	}
+	return counts[0], nil
+}
+
+// DueBackfills claims the missing ancestors whose fetch is due, they stay with the caller
+// for lease.
+func (f *DefaultActivityPubFacade) DueBackfills(limit int, lease time.Duration) ([]Backfill, error) {
+	now := time.Now()
+	var rows []*db.ActivityPubBackfill
+	err := f.db.Where("status = ? AND next_attempt <= ?", db.BackfillPending, now).
+		Order("next_attempt").Limit(limit).Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	due := make([]Backfill, 0, len(rows))
+	for _, r := range rows {
+		// another run may have taken it meanwhile, the lease goes to whoever moves it first
+		res := f.db.Model(&db.ActivityPubBackfill{}).
+			Where("id = ? AND next_attempt = ?", r.ID, r.NextAttempt).Update("next_attempt", now.Add(lease))
+		if res.Error != nil {
+			return nil, res.Error
+		}
+		if res.RowsAffected == 1 {
+			due = append(due, Backfill{ObjectID: r.ObjectID, Depth: r.Depth, Attempts: r.Attempts})
+		}
+	}
+	return due, nil
+}
+
+// BackfillFailed records a failed fetch of a missing ancestor. It is tried again later,
+// backing off, unless the failure is permanent or it ran out of attempts.
+func (f *DefaultActivityPubFacade) BackfillFailed(b Backfill, reason error, permanent bool) error {
+	updates := map[string]interface{}{"attempts": b.Attempts + 1, "last_error": reason.Error()}
+	if permanent || b.Attempts+1 >= MaxBackfillAttempts {
+		updates["status"] = db.BackfillFailed
+	} else {
+		updates["next_attempt"] = time.Now().Add(backfillDelay(b.Attempts + 1))
+	}
+	return f.db.Model(&db.ActivityPubBackfill{}).Where("object_id = ?", b.ObjectID).Updates(updates).Error
+}
+
+// backfillDelay is the wait before the next fetch of an ancestor after failed attempts,
+// doubling from a minute up to six hours.
+func backfillDelay(attempts int) time.Duration {
+	delay := time.Minute
+	for i := 1; i < attempts && delay < 6*time.Hour; i++ {
+		delay *= 2
+	}
+	return min(delay, 6*time.Hour)
+}
+
+// threadObject keeps the thread of an object stored: the reply counts of the object and
+// of its parent, and the backfill of the parent when it is missing. An object that was
+// a missing ancestor leaves the backfill.
+func threadObject(tx *gorm.DB, row *db.ActivityPubObject) error {
+	if err := countReplies(tx, row.ActivityPubID); err != nil {
+		return err
+	}
+	var own db.ActivityPubBackfill
+	if err := tx.Where("object_id = ?", row.ActivityPubID).Limit(1).Find(&own).Error; err != nil {
+		return err
+	}
+	if own.ID != 0 {
+		if err := tx.Delete(&own).Error; err != nil {
+			return err
+		}
+	}
+	if row.InReplyTo == "" {
+		return nil
+	}
+	var parent db.ActivityPubObject
+	if err := tx.Where("activity_pub_id = ?", row.InReplyTo).Limit(1).Find(&parent).Error; err != nil {
+		return err
+	}
+	if parent.ID != 0 {
+		return countReplies(tx, parent.ActivityPubID)
+	}
+
+	// the parent of a missing ancestor is one level further
+	depth := 1
+	if own.ID != 0 {
+		depth = own.Depth + 1
+	}
+	if depth > MaxThreadDepth {
+		return nil
+	}
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Select("*").Create(&db.ActivityPubBackfill{
+		ObjectID:    row.InReplyTo,
+		ReplyID:     row.ActivityPubID,
+		Depth:       depth,
+		Status:      db.BackfillPending,
+		NextAttempt: time.Now(),
+	}).Error
+}
+
+// countReplies stores the count of the public replies of an object
+func countReplies(tx *gorm.DB, iri string) error {
+	var count int64
+	err := tx.Model(&db.ActivityPubObject{}).
+		Where("in_reply_to = ? AND is_public = ? AND type <> ?", iri, true, string(ap.TombstoneType)).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	return tx.Model(&db.ActivityPubObject{}).Where("activity_pub_id = ?", iri).Update("replies_count", count).Error
+}
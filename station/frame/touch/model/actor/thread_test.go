@@ -0,0 +1,25 @@
+package actor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackfillDelay(t *testing.T) {
+	cases := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{4, 8 * time.Minute},
+		{9, 256 * time.Minute},
+		{10, 6 * time.Hour},
+		{40, 6 * time.Hour},
+	}
+	for _, c := range cases {
+		if got := backfillDelay(c.attempts); got != c.want {
+			t.Errorf("backfillDelay(%d) = %v, want %v", c.attempts, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,346 @@
+package actor
+
+import (
+	"net/url"
+	"strings"
+
+	o "github.com/peers-touch/peers-touch/station/frame/object"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+	ap "github.com/peers-touch/peers-touch/station/frame/vendors/activitypub"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// HomeRebuildSize caps the activities a rebuilt home timeline starts with
+	HomeRebuildSize = 800
+	// timelineScans caps the batches a page of a timeline reads while the domain
+	// policy filters their entries out
+	timelineScans = 5
+)
+
+// TimelineQuery selects a page of a timeline, cut like the pages of collections. Viewer
+// is the local actor it is shown to, its blocks and mutes are left out.
+type TimelineQuery struct {
+	PageQuery
+	// Domain tells whether the activities of the actors of a host are hidden, or
+	// silenced: only shown in the home timelines of their followers.
+	Domain func(host string) (hide, silence bool)
+}
+
+// GetTimeline returns a page of a timeline, home for the viewer, local or federated,
+// newest first. Total is not counted, Newest and Oldest are the positions of the
+// entries read, so the next page goes on where this one stopped even when the domain
+// policy left entries out.
+func (f *DefaultActivityPubFacade) GetTimeline(feed string, q TimelineQuery) (*CollectionPage, error) {
+	owner := ""
+	switch feed {
+	case db.TimelineHome:
+		if q.Viewer == "" {
+			return nil, model.ErrActorNotFound
+		}
+		owner = string(q.Viewer)
+	case db.TimelineLocal, db.TimelineFederated:
+	default:
+		return nil, model.ErrTimelineNotFound
+	}
+	limit := q.Limit
+	if limit <= 0 || limit > MaxPageSize {
+		limit = DefaultPageSize
+	}
+
+	base := func() *gorm.DB {
+		tx := f.db.Model(&db.ActivityPubTimelineEntry{}).Where("feed = ? AND owner_id = ?", feed, owner).
+			Where("actor_id NOT IN (?)", f.db.Model(&db.ActivityPubActor{}).Select("activity_pub_id").Where("suspended_at IS NOT NULL"))
+		if q.Viewer != "" {
+			hidden := f.db.Model(&db.ActivityPubBlock{}).Select("target_id").Where("actor_id = ?", string(q.Viewer))
+			tx = tx.Where("actor_id NOT IN (?) AND (author_id = '' OR author_id NOT IN (?))", hidden, hidden)
+		}
+		return tx
+	}
+
+	var kept []*db.ActivityPubTimelineEntry
+	cursor, scanned, exhausted := q.Before, int64(0), false
+	if q.Reverse {
+		cursor = q.After
+	}
+	following := map[string]bool{}
+	for scan := 0; scan < timelineScans && len(kept) <= limit; scan++ {
+		tx := base().Limit(limit + 1)
+		if q.Reverse {
+			tx = tx.Where("position > ?", cursor).Order("position ASC")
+		} else {
+			if cursor > 0 {
+				tx = tx.Where("position < ?", cursor)
+			}
+			tx = tx.Order("position DESC")
+		}
+		var rows []*db.ActivityPubTimelineEntry
+		if err := tx.Find(&rows).Error; err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			scanned = row.Position
+			show, err := f.shown(feed, row, q, following)
+			if err != nil {
+				return nil, err
+			}
+			if show {
+				kept = append(kept, row)
+				if len(kept) > limit {
+					break
+				}
+			}
+		}
+		if len(rows) <= limit {
+			exhausted = true
+			break
+		}
+		cursor = scanned
+	}
+
+	more := len(kept) > limit || !exhausted
+	if len(kept) > limit {
+		kept = kept[:limit]
+		scanned = kept[limit-1].Position
+	}
+	if q.Reverse {
+		for i, j := 0, len(kept)-1; i < j; i, j = i+1, j-1 {
+			kept[i], kept[j] = kept[j], kept[i]
+		}
+	}
+
+	page := &CollectionPage{}
+	switch {
+	case q.Reverse:
+		page.HasNewer, page.HasOlder = more, q.After > 0
+		page.Oldest, page.Newest = q.After+1, q.After
+		if scanned > 0 {
+			page.Newest = scanned
+		}
+		if len(kept) > 0 {
+			page.Oldest = kept[len(kept)-1].Position
+		}
+	default:
+		page.HasNewer, page.HasOlder = q.Before > 0, more
+		page.Newest, page.Oldest = q.Before-1, q.Before
+		if len(kept) > 0 {
+			page.Newest = kept[0].Position
+		}
+		if scanned > 0 {
+			page.Oldest = scanned
+		}
+	}
+
+	ids := make([]string, 0, len(kept))
+	for _, row := range kept {
+		ids = append(ids, row.ActivityID)
+	}
+	items, err := f.activitiesByID(ids)
+	if err != nil {
+		return nil, err
+	}
+	page.Items = items
+	return page, nil
+}
+
+// shown applies the domain policy to an entry of a timeline. Silenced actors are only
+// shown in the home timelines of their followers.
+func (f *DefaultActivityPubFacade) shown(feed string, row *db.ActivityPubTimelineEntry, q TimelineQuery, following map[string]bool) (bool, error) {
+	if q.Domain == nil {
+		return true, nil
+	}
+	for _, party := range [][2]string{{row.ActorID, row.ActorHost}, {row.AuthorID, row.AuthorHost}} {
+		if party[0] == "" {
+			continue
+		}
+		hide, silence := q.Domain(party[1])
+		if hide {
+			return false, nil
+		}
+		if !silence {
+			continue
+		}
+		if feed != db.TimelineHome {
+			return false, nil
+		}
+		follows, ok := following[party[0]]
+		if !ok {
+			var err error
+			if follows, err = f.isFollower(q.Viewer, o.ID(party[0])); err != nil {
+				return false, err
+			}
+			following[party[0]] = follows
+		}
+		if !follows {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// RebuildHomeTimeline regenerates the home timeline of a local actor from the stored
+// Creates and Announces of the actor and of the actors it follows, the newest
+// HomeRebuildSize of them. It returns the entries written.
+func (f *DefaultActivityPubFacade) RebuildHomeTimeline(actorId o.ID) (int, error) {
+	written := 0
+	err := f.db.Transaction(func(tx *gorm.DB) error {
+		owner, err := loadActor(tx, actorId)
+		if err != nil {
+			return err
+		}
+		if !owner.IsLocal {
+			return model.ErrActivityPubNotOwner
+		}
+		if err := tx.Where("feed = ? AND owner_id = ?", db.TimelineHome, owner.ActivityPubID).Delete(&db.ActivityPubTimelineEntry{}).Error; err != nil {
+			return err
+		}
+
+		var followed []string
+		err = tx.Model(&db.ActivityPubFollow{}).Where("follower_id = ? AND accepted = ? AND is_active = ?", owner.ActivityPubID, true, true).
+			Pluck("following_id", &followed).Error
+		if err != nil {
+			return err
+		}
+		var rows []*db.ActivityPubActivity
+		err = tx.Where("actor_id IN ? AND type IN ?", append(followed, owner.ActivityPubID), []string{string(ap.CreateType), string(ap.AnnounceType)}).
+			Order("id DESC").Limit(HomeRebuildSize).Find(&rows).Error
+		if err != nil {
+			return err
+		}
+
+		var entries []*db.ActivityPubTimelineEntry
+		for _, row := range rows {
+			a, err := activityFromRow(row)
+			if err != nil {
+				return err
+			}
+			act := (*ap.Activity)(a)
+			if !onHome(act, owner.ActivityPubID) {
+				continue
+			}
+			entries = append(entries, timelineEntry(tx, act, int64(row.ID), db.TimelineHome, owner.ActivityPubID))
+		}
+		written = len(entries)
+		return saveEntries(tx, entries)
+	})
+	return written, err
+}
+
+// fanOut writes a Create or Announce into the timelines it belongs to when it is stored:
+// the home timelines of the local followers of its actor, and of the actor itself when
+// it is local, and the local or federated timeline when it is a public Create. Direct
+// activities only go to the homes of the actors they are addressed to.
+func fanOut(tx *gorm.DB, a *ap.Activity, position int64, local bool) error {
+	if (a.Type != ap.CreateType && a.Type != ap.AnnounceType) || ap.IsNil(a.Object) {
+		return nil
+	}
+	actor := link(a.Actor)
+	var owners []string
+	err := tx.Model(&db.ActivityPubFollow{}).
+		Joins("JOIN activitypub_actors ON activitypub_actors.activity_pub_id = activitypub_follows.follower_id").
+		Where("activitypub_follows.following_id = ? AND activitypub_follows.accepted = ? AND activitypub_follows.is_active = ? AND activitypub_actors.is_local = ?", actor, true, true, true).
+		Pluck("activitypub_follows.follower_id", &owners).Error
+	if err != nil {
+		return err
+	}
+	if local {
+		owners = append(owners, actor)
+	}
+
+	var entries []*db.ActivityPubTimelineEntry
+	for _, owner := range dedupe(owners) {
+		if onHome(a, owner) {
+			entries = append(entries, timelineEntry(tx, a, position, db.TimelineHome, owner))
+		}
+	}
+	if a.Type == ap.CreateType && visibility(a) == db.VisibilityPublic {
+		feed := db.TimelineFederated
+		if local {
+			feed = db.TimelineLocal
+		}
+		entries = append(entries, timelineEntry(tx, a, position, feed, ""))
+	}
+	return saveEntries(tx, entries)
+}
+
+// onHome tells whether an activity goes to the home timeline of the owner, who is its
+// actor or follows it.
+func onHome(a *ap.Activity, owner string) bool {
+	return visibility(a) != db.VisibilityDirect || link(a.Actor) == owner || audience(a).Contains(ap.IRI(owner))
+}
+
+func timelineEntry(tx *gorm.DB, a *ap.Activity, position int64, feed, owner string) *db.ActivityPubTimelineEntry {
+	actor := link(a.Actor)
+	author := actor
+	if a.Type == ap.AnnounceType {
+		author = announcedAuthor(tx, a.Object)
+	}
+	return &db.ActivityPubTimelineEntry{
+		Feed:       feed,
+		OwnerID:    owner,
+		ActivityID: string(a.ID),
+		Position:   position,
+		ObjectID:   link(a.Object),
+		ActorID:    actor,
+		AuthorID:   author,
+		ActorHost:  hostOf(actor),
+		AuthorHost: hostOf(author),
+	}
+}
+
+// announcedAuthor returns the author of an object announced, as it is embedded or
+// stored, empty when it is not known.
+func announcedAuthor(tx *gorm.DB, it ap.Item) string {
+	author := ""
+	if !ap.IsIRI(it) {
+		_ = ap.OnObject(it, func(ob *ap.Object) error {
+			author = link(ob.AttributedTo)
+			return nil
+		})
+	}
+	if author == "" {
+		_ = tx.Model(&db.ActivityPubObject{}).Where("activity_pub_id = ?", link(it)).Limit(1).Pluck("attributed_to", &author).Error
+	}
+	return author
+}
+
+func saveEntries(tx *gorm.DB, entries []*db.ActivityPubTimelineEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Select("*").Create(&entries).Error
+}
+
+// unfeed drops the entries whose column has the value out of the timelines
+func unfeed(tx *gorm.DB, column, value string) error {
+	return tx.Where(column+" = ?", value).Delete(&db.ActivityPubTimelineEntry{}).Error
+}
+
+// unfeedActor drops the activities of an actor out of the home timeline of the owner
+func unfeedActor(tx *gorm.DB, owner, actor string) error {
+	return tx.Where("feed = ? AND owner_id = ? AND actor_id = ?", db.TimelineHome, owner, actor).Delete(&db.ActivityPubTimelineEntry{}).Error
+}
+
+func hostOf(iri string) string {
+	u, err := url.Parse(iri)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// SameHost tells whether the IRIs are served by the same host, a server only speaks for
+// the actors and objects of its own.
+func SameHost(a, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil || ua.Host == "" {
+		return false
+	}
+	ub, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+	return ua.Scheme == ub.Scheme && strings.EqualFold(ua.Host, ub.Host)
+}
@@ -0,0 +1,49 @@
+package actor
+
+import (
+	"testing"
+
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+	ap "github.com/peers-touch/peers-touch/station/frame/vendors/activitypub"
+)
+
+func TestOnHome(t *testing.T) {
+	author := "https://remote.example/users/bob"
+	alice := "https://example.com/activitypub/alice/actor"
+	carol := "https://example.com/activitypub/carol/actor"
+	create := func(to ...ap.Item) *ap.Activity {
+		a := ap.ActivityNew("https://remote.example/activities/1", ap.CreateType, ap.IRI("https://remote.example/notes/1"))
+		a.Actor = ap.IRI(author)
+		a.To = to
+		return a
+	}
+
+	for _, tc := range []struct {
+		name  string
+		a     *ap.Activity
+		owner string
+		want  bool
+	}{
+		{"public", create(ap.PublicNS), alice, true},
+		{"followers", create(ap.IRI(author + "/followers")), alice, true},
+		{"direct to the owner", create(ap.IRI(alice)), alice, true},
+		{"direct to another", create(ap.IRI(carol)), alice, false},
+		{"direct of the owner", create(ap.IRI(carol)), author, true},
+	} {
+		if got := onHome(tc.a, tc.owner); got != tc.want {
+			t.Errorf("%s: onHome = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestTimelineEntry(t *testing.T) {
+	a := ap.ActivityNew("https://Remote.example:8443/activities/1", ap.CreateType, ap.IRI("https://remote.example:8443/notes/1"))
+	a.Actor = ap.IRI("https://Remote.example:8443/users/bob")
+	e := timelineEntry(nil, a, 42, db.TimelineFederated, "")
+	if e.Position != 42 || e.ActorID != e.AuthorID || e.ActorHost != "remote.example" || e.AuthorHost != "remote.example" {
+		t.Fatalf("entry = %+v", e)
+	}
+	if e.ObjectID != "https://remote.example:8443/notes/1" || e.Feed != db.TimelineFederated || e.OwnerID != "" {
+		t.Fatalf("entry = %+v", e)
+	}
+}
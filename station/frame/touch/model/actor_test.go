@@ -0,0 +1,21 @@
+package model
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestActorSignParamsRefusesReservedNames(t *testing.T) {
+	for name, reserved := range map[string]bool{
+		"inbox":   true,
+		"Objects": true,
+		"context": true,
+		"inboxes": false,
+		"alice42": false,
+	} {
+		err := ActorSignParams{Name: name, Email: name + "@station.example", Password: "Secret12!"}.Check()
+		if errors.Is(err, ErrActorInvalidName) != reserved || (!reserved && err != nil) {
+			t.Errorf("signup as %s: %v", name, err)
+		}
+	}
+}
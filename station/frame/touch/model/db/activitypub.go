@@ -6,6 +6,7 @@ import (
 
 	"github.com/peers-touch/peers-touch/station/frame/core/util/id"
 	o "github.com/peers-touch/peers-touch/station/frame/object"
+	ap "github.com/peers-touch/peers-touch/station/frame/vendors/activitypub"
 	"gorm.io/gorm"
 )
 
@@ -22,11 +23,15 @@ type ActivityPubActor struct {
 	FollowersURL      string     `gorm:"size:512"`                        // Followers collection SubPath
 	FollowingURL      string     `gorm:"size:512"`                        // Following collection SubPath
 	LikedURL          string     `gorm:"size:512"`                        // Liked collection SubPath
+	SharedInboxURL    string     `gorm:"size:512"`                        // Shared inbox of the actor's server
+	PublicKeyID       string     `gorm:"size:512"`                        // Id of the public key, the keyId signatures name
 	PublicKeyPem      string     `gorm:"type:text"`                       // Public key for verification
 	PrivateKeyPem     string     `gorm:"type:text"`                       // Private key (for local actors)
 	IsLocal           bool       `gorm:"default:false;not null"`          // Whether this is a local actor
 	IsActive          bool       `gorm:"default:true;not null"`           // Whether the actor is active
+	ManuallyApproves  bool       `gorm:"default:false;not null"`          // Whether follows wait for the actor to accept them
 	LastFetched       *time.Time `gorm:"index"`                           // Last time remote actor was fetched
+	SuspendedAt       *time.Time `gorm:"index"`                           // When a moderator suspended the actor
 	Metadata          string     `gorm:"type:json"`                       // Additional metadata as JSON
 
 	CreatedAt time.Time `gorm:"created_at"`
@@ -37,6 +42,15 @@ func (*ActivityPubActor) TableName() string {
 	return "activitypub_actors"
 }
 
+// KeyID returns the id of the public key of the actor. Rows stored before it was kept
+// have the #main-key of the actor document, the one local actors use.
+func (a *ActivityPubActor) KeyID() string {
+	if a.PublicKeyID != "" {
+		return a.PublicKeyID
+	}
+	return a.ActivityPubID + "#main-key"
+}
+
 func (a *ActivityPubActor) BeforeCreate(tx *gorm.DB) error {
 	if a.ID == 0 {
 		a.ID = id.NextID()
@@ -55,12 +69,21 @@ type ActivityPubActivity struct {
 	Published     time.Time `gorm:"not null;index"`                  // When the activity was published
 	Content       string    `gorm:"type:json"`                       // Full activity JSON
 	IsLocal       bool      `gorm:"default:false;not null;index"`    // Whether this is a local activity
-	IsPublic      bool      `gorm:"default:true;not null;index"`     // Whether the activity is public
+	IsPublic      bool      `gorm:"default:false;not null;index"`    // Whether the activity is public
+	Visibility    string    `gorm:"size:16;index"`                   // Who its addressing shows it to
 
 	CreatedAt time.Time `gorm:"created_at"`
 	UpdatedAt time.Time `gorm:"updated_at"`
 }
 
+// Visibilities of activities, from their addressing
+const (
+	VisibilityPublic    = "public"    // to the public collection
+	VisibilityUnlisted  = "unlisted"  // public, but only in cc
+	VisibilityFollowers = "followers" // to a followers collection, not public
+	VisibilityDirect    = "direct"    // to actors only
+)
+
 func (*ActivityPubActivity) TableName() string {
 	return "activitypub_activities"
 }
@@ -85,8 +108,10 @@ type ActivityPubObject struct {
 	Published     time.Time  `gorm:"index"`                           // When the object was published
 	Updated       *time.Time `gorm:"index"`                           // When the object was last updated
 	InReplyTo     string     `gorm:"size:512;index"`                  // Reply target
+	RepliesCount  int64      `gorm:"not null;default:0"`              // Public replies stored, tombstones aside
 	IsLocal       bool       `gorm:"default:false;not null;index"`    // Whether this is a local object
-	IsPublic      bool       `gorm:"default:true;not null;index"`     // Whether the object is public
+	IsPublic      bool       `gorm:"default:false;not null;index"`    // Whether the object is public
+	LastFetched   *time.Time `gorm:"index"`                           // Last time remote object was fetched
 	Metadata      string     `gorm:"type:json"`                       // Additional metadata as JSON
 
 	CreatedAt time.Time `gorm:"created_at"`
@@ -151,6 +176,29 @@ func (l *ActivityPubLike) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
+// ActivityPubAnnounce represents an announce (boost) of an object in the database
+type ActivityPubAnnounce struct {
+	ID         uint64 `gorm:"primary_key;autoIncrement:false"` // Snowflake ID
+	ActorID    string `gorm:"size:512;not null;index"`         // Actor who announced
+	ObjectID   string `gorm:"size:512;not null;index"`         // Object being announced
+	ActivityID string `gorm:"size:512;uniqueIndex"`            // Announce activity ID
+	IsActive   bool   `gorm:"default:true;not null;index"`     // Whether the announce is still active
+
+	CreatedAt time.Time `gorm:"created_at"`
+	UpdatedAt time.Time `gorm:"updated_at"`
+}
+
+func (*ActivityPubAnnounce) TableName() string {
+	return "activitypub_announces"
+}
+
+func (a *ActivityPubAnnounce) BeforeCreate(tx *gorm.DB) error {
+	if a.ID == 0 {
+		a.ID = id.NextID()
+	}
+	return nil
+}
+
 // ActivityPubCollection represents a collection item relationship in the database
 type ActivityPubCollection struct {
 	ID           uint64    `gorm:"primary_key;autoIncrement:false"` // Snowflake ID
@@ -195,9 +243,9 @@ func (a *ActivityPubActor) GetMetadata(target interface{}) error {
 	return json.Unmarshal([]byte(a.Metadata), target)
 }
 
-// SetContent sets the activity content as JSON
+// SetContent sets the activity content as ActivityStreams JSON
 func (a *ActivityPubActivity) SetContent(activity o.Activity) error {
-	jsonData, err := json.Marshal(activity)
+	jsonData, err := ap.Activity(activity).MarshalJSON()
 	if err != nil {
 		return err
 	}
@@ -205,17 +253,16 @@ func (a *ActivityPubActivity) SetContent(activity o.Activity) error {
 	return nil
 }
 
-// GetContent gets the activity content from JSON
+// GetContent gets the activity content from ActivityStreams JSON
 func (a *ActivityPubActivity) GetContent() (*o.Activity, error) {
 	if a.Content == "" {
 		return nil, nil
 	}
-	var activity o.Activity
-	err := json.Unmarshal([]byte(a.Content), &activity)
-	if err != nil {
+	var activity ap.Activity
+	if err := activity.UnmarshalJSON([]byte(a.Content)); err != nil {
 		return nil, err
 	}
-	return &activity, nil
+	return (*o.Activity)(&activity), nil
 }
 
 // SetMetadata sets the metadata field as JSON
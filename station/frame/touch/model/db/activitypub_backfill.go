@@ -0,0 +1,42 @@
+package db
+
+import (
+	"time"
+
+	"github.com/peers-touch/peers-touch/station/frame/core/util/id"
+	"gorm.io/gorm"
+)
+
+// States of an ActivityPubBackfill
+const (
+	BackfillPending = "pending"
+	BackfillFailed  = "failed"
+)
+
+// ActivityPubBackfill is a missing ancestor of a stored reply, to fetch from its server.
+// Rows go away once the ancestor is stored, those that cannot be fetched stay failed so
+// the ancestor is not asked for again.
+type ActivityPubBackfill struct {
+	ID          uint64    `gorm:"primary_key;autoIncrement:false"` // Snowflake ID
+	ObjectID    string    `gorm:"size:512;not null;uniqueIndex"`   // Ancestor to fetch
+	ReplyID     string    `gorm:"size:512;not null"`               // Reply that needs it
+	Depth       int       `gorm:"not null;default:1"`              // Ancestors between it and the first reply stored
+	Status      string    `gorm:"size:16;not null;index:idx_activitypub_backfill_due,priority:1"`
+	Attempts    int       `gorm:"not null;default:0"`
+	NextAttempt time.Time `gorm:"not null;index:idx_activitypub_backfill_due,priority:2"` // When the fetch is due
+	LastError   string    `gorm:"type:text"`
+
+	CreatedAt time.Time `gorm:"created_at"`
+	UpdatedAt time.Time `gorm:"updated_at"`
+}
+
+func (*ActivityPubBackfill) TableName() string {
+	return "activitypub_backfills"
+}
+
+func (b *ActivityPubBackfill) BeforeCreate(tx *gorm.DB) error {
+	if b.ID == 0 {
+		b.ID = id.NextID()
+	}
+	return nil
+}
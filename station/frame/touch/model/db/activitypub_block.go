@@ -0,0 +1,38 @@
+package db
+
+import (
+	"time"
+
+	"github.com/peers-touch/peers-touch/station/frame/core/util/id"
+	"gorm.io/gorm"
+)
+
+// Kinds of an ActivityPubBlock
+const (
+	BlockKindBlock = "block" // the target gets a Block and the follows between them end
+	BlockKindMute  = "mute"  // only hides the target from the actor
+)
+
+// ActivityPubBlock is an actor a local actor blocked or muted, their activities are
+// left out of its timelines.
+type ActivityPubBlock struct {
+	ID         uint64 `gorm:"primary_key;autoIncrement:false"`                           // Snowflake ID
+	ActorID    string `gorm:"size:512;not null;uniqueIndex:idx_activitypub_block"`       // Local actor who blocked
+	TargetID   string `gorm:"size:512;not null;uniqueIndex:idx_activitypub_block;index"` // Actor blocked
+	Kind       string `gorm:"size:16;not null;uniqueIndex:idx_activitypub_block"`
+	ActivityID string `gorm:"size:512"` // Block sent to the target
+
+	CreatedAt time.Time `gorm:"created_at"`
+	UpdatedAt time.Time `gorm:"updated_at"`
+}
+
+func (*ActivityPubBlock) TableName() string {
+	return "activitypub_blocks"
+}
+
+func (b *ActivityPubBlock) BeforeCreate(tx *gorm.DB) error {
+	if b.ID == 0 {
+		b.ID = id.NextID()
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/peers-touch/peers-touch/station/frame/core/util/id"
+	"gorm.io/gorm"
+)
+
+// ConversationTypeActivityPub is the type of the conversations bridged with a remote
+// ActivityPub actor, see ActivityPubChat.
+const ConversationTypeActivityPub ConversationType = "activitypub"
+
+// Types of the objects of an ActivityPubChat
+const (
+	ChatTypeNote        = "Note"        // direct Notes, the direct messages of Mastodon
+	ChatTypeChatMessage = "ChatMessage" // the chat messages of Pleroma and Misskey
+)
+
+// ActivityPubDIDPrefix prefixes the DIDs remote ActivityPub actors are known by in
+// conversations.
+const ActivityPubDIDPrefix = "did:ap:"
+
+// ActivityPubDID returns the DID a remote ActivityPub actor sends the messages of its
+// bridged conversations with, derived from its IRI.
+func ActivityPubDID(iri string) string {
+	sum := sha256.Sum256([]byte(iri))
+	return ActivityPubDIDPrefix + hex.EncodeToString(sum[:20])
+}
+
+// ActivityPubChat bridges a touch conversation of a local actor with a remote actor:
+// the messages of the local actor are delivered as direct objects of ObjectType, and the
+// direct objects of the remote actor become messages sent by RemoteDID.
+type ActivityPubChat struct {
+	ID            uint64 `gorm:"primary_key;autoIncrement:false"`                    // Snowflake ID
+	ConvID        string `gorm:"size:64;not null;uniqueIndex"`                       // Conversation bridged
+	LocalActorID  string `gorm:"size:512;not null;uniqueIndex:idx_activitypub_chat"` // Local actor
+	RemoteActorID string `gorm:"size:512;not null;uniqueIndex:idx_activitypub_chat"` // Remote actor
+	LocalDID      string `gorm:"column:local_did;size:128;not null"`
+	RemoteDID     string `gorm:"column:remote_did;size:128;not null"`
+	ObjectType    string `gorm:"size:32;not null"` // ChatTypeNote or ChatTypeChatMessage
+
+	CreatedAt time.Time `gorm:"created_at"`
+	UpdatedAt time.Time `gorm:"updated_at"`
+}
+
+func (*ActivityPubChat) TableName() string {
+	return "activitypub_chats"
+}
+
+func (c *ActivityPubChat) BeforeCreate(tx *gorm.DB) error {
+	if c.ID == 0 {
+		c.ID = id.NextID()
+	}
+	return nil
+}
+
+// ActivityPubChatMessage links a message of a bridged conversation to the object it
+// was delivered as or received in, so objects are routed once.
+type ActivityPubChatMessage struct {
+	ID       uint64 `gorm:"primary_key;autoIncrement:false"` // Snowflake ID
+	ChatID   uint64 `gorm:"not null;index"`
+	ObjectID string `gorm:"size:512;not null;uniqueIndex"`          // Object IRI
+	MsgULID  string `gorm:"column:msg_ulid;size:32;not null;index"` // Message of the conversation
+	Outgoing bool   `gorm:"not null;default:false"`                 // Sent by the local actor
+
+	CreatedAt time.Time `gorm:"created_at"`
+}
+
+func (*ActivityPubChatMessage) TableName() string {
+	return "activitypub_chat_messages"
+}
+
+func (m *ActivityPubChatMessage) BeforeCreate(tx *gorm.DB) error {
+	if m.ID == 0 {
+		m.ID = id.NextID()
+	}
+	return nil
+}
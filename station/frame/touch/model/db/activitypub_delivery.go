@@ -0,0 +1,89 @@
+package db
+
+import (
+	"time"
+
+	"github.com/peers-touch/peers-touch/station/frame/core/util/id"
+	"gorm.io/gorm"
+)
+
+// Delivery states of an ActivityPubDelivery
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+)
+
+// ActivityPubDelivery is the delivery of an outgoing activity to one remote inbox. Rows
+// stay pending until the inbox accepts the activity, failed ones move to the dead letters.
+type ActivityPubDelivery struct {
+	ID          uint64     `gorm:"primary_key;autoIncrement:false"`                               // Snowflake ID
+	ActivityID  string     `gorm:"size:512;not null;uniqueIndex:idx_activitypub_delivery_target"` // Activity delivered
+	SenderID    string     `gorm:"size:512;not null;index"`                                       // Local actor signing the delivery
+	Target      string     `gorm:"size:512;not null;uniqueIndex:idx_activitypub_delivery_target"` // Inbox, or the actor IRI while it is not resolved
+	Inbox       string     `gorm:"size:512"`                                                      // Inbox URL posted to, empty until resolved
+	Shared      bool       `gorm:"default:false;not null"`                                        // Whether a shared inbox may take the delivery
+	Host        string     `gorm:"size:255;not null;index"`                                       // Host of the target
+	Status      string     `gorm:"size:16;not null;index:idx_activitypub_delivery_due,priority:1"`
+	Attempts    int        `gorm:"not null;default:0"`
+	NextAttempt time.Time  `gorm:"not null;index:idx_activitypub_delivery_due,priority:2"` // When the delivery is due, or its lease ends
+	LastError   string     `gorm:"type:text"`
+	DeliveredAt *time.Time // When the inbox accepted it
+
+	CreatedAt time.Time `gorm:"created_at"`
+	UpdatedAt time.Time `gorm:"updated_at"`
+}
+
+func (*ActivityPubDelivery) TableName() string {
+	return "activitypub_deliveries"
+}
+
+func (d *ActivityPubDelivery) BeforeCreate(tx *gorm.DB) error {
+	if d.ID == 0 {
+		d.ID = id.NextID()
+	}
+	return nil
+}
+
+// ActivityPubDeadLetter is a delivery given up on, kept for inspection and replay.
+type ActivityPubDeadLetter struct {
+	ID         uint64 `gorm:"primary_key;autoIncrement:false"` // ID of the delivery
+	ActivityID string `gorm:"size:512;not null;index"`
+	SenderID   string `gorm:"size:512;not null;index"`
+	Target     string `gorm:"size:512;not null"`
+	Inbox      string `gorm:"size:512"`
+	Host       string `gorm:"size:255;not null;index"`
+	Attempts   int    `gorm:"not null;default:0"`
+	Reason     string `gorm:"type:text"` // Why it was given up on
+
+	CreatedAt time.Time `gorm:"created_at"`
+}
+
+func (*ActivityPubDeadLetter) TableName() string {
+	return "activitypub_dead_letters"
+}
+
+// ActivityPubInstance is the delivery health of a remote host. Failing hosts open their
+// circuit and get no deliveries until OpenUntil.
+type ActivityPubInstance struct {
+	ID           uint64     `gorm:"primary_key;autoIncrement:false"` // Snowflake ID
+	Host         string     `gorm:"uniqueIndex;size:255;not null"`
+	Failures     int        `gorm:"not null;default:0"` // Consecutive failed deliveries
+	FirstFailure *time.Time // Start of the current run of failures
+	OpenUntil    *time.Time `gorm:"index"` // Circuit open until then
+	LastSuccess  *time.Time
+	LastError    string `gorm:"type:text"`
+
+	CreatedAt time.Time `gorm:"created_at"`
+	UpdatedAt time.Time `gorm:"updated_at"`
+}
+
+func (*ActivityPubInstance) TableName() string {
+	return "activitypub_instances"
+}
+
+func (i *ActivityPubInstance) BeforeCreate(tx *gorm.DB) error {
+	if i.ID == 0 {
+		i.ID = id.NextID()
+	}
+	return nil
+}
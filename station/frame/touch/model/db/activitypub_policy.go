@@ -0,0 +1,68 @@
+package db
+
+import (
+	"time"
+
+	"github.com/peers-touch/peers-touch/station/frame/core/util/id"
+	"gorm.io/gorm"
+)
+
+// Changes recorded by an ActivityPubPolicyAudit
+const (
+	PolicyChangeSet    = "set"
+	PolicyChangeRemove = "remove"
+)
+
+// ActivityPubDomainPolicy is what the station does with a remote domain and its
+// subdomains. When the station federates in allowlist mode, only the domains with Allow
+// set are federated with.
+type ActivityPubDomainPolicy struct {
+	ID            uint64 `gorm:"primary_key;autoIncrement:false"` // Snowflake ID
+	Domain        string `gorm:"uniqueIndex;size:255;not null"`   // Lower case, without port
+	Reject        bool   `gorm:"default:false;not null"`          // Refuse its activities, fetches and deliveries
+	RejectMedia   bool   `gorm:"default:false;not null"`          // Drop the attachments and images of its objects
+	Silence       bool   `gorm:"default:false;not null"`          // Keep its posts off the public timelines
+	ForceUnlisted bool   `gorm:"default:false;not null"`          // Take its public posts as unlisted
+	Allow         bool   `gorm:"default:false;not null"`          // Federate with it in allowlist mode
+	Reason        string `gorm:"type:text"`
+	UpdatedBy     string `gorm:"size:255"` // Administrator who set it last
+
+	CreatedAt time.Time `gorm:"created_at"`
+	UpdatedAt time.Time `gorm:"updated_at"`
+}
+
+func (*ActivityPubDomainPolicy) TableName() string {
+	return "activitypub_domain_policies"
+}
+
+func (p *ActivityPubDomainPolicy) BeforeCreate(tx *gorm.DB) error {
+	if p.ID == 0 {
+		p.ID = id.NextID()
+	}
+	return nil
+}
+
+// ActivityPubPolicyAudit records a change of the federation policy of a domain, with the
+// policy before and after it as JSON.
+type ActivityPubPolicyAudit struct {
+	ID       uint64 `gorm:"primary_key;autoIncrement:false"` // Snowflake ID
+	Domain   string `gorm:"size:255;not null;index"`
+	Change   string `gorm:"size:16;not null"` // PolicyChangeSet or PolicyChangeRemove
+	Previous string `gorm:"type:text"`        // Empty when the domain had no policy
+	Policy   string `gorm:"type:text"`        // Empty when the policy was removed
+	Reason   string `gorm:"type:text"`
+	By       string `gorm:"size:255;not null"` // Administrator who made the change
+
+	CreatedAt time.Time `gorm:"created_at;index"`
+}
+
+func (*ActivityPubPolicyAudit) TableName() string {
+	return "activitypub_policy_audits"
+}
+
+func (a *ActivityPubPolicyAudit) BeforeCreate(tx *gorm.DB) error {
+	if a.ID == 0 {
+		a.ID = id.NextID()
+	}
+	return nil
+}
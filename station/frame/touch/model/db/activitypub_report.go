@@ -0,0 +1,46 @@
+package db
+
+import (
+	"time"
+
+	"github.com/peers-touch/peers-touch/station/frame/core/util/id"
+	"gorm.io/gorm"
+)
+
+// Statuses of an ActivityPubReport
+const (
+	ReportOpen      = "open"
+	ReportResolved  = "resolved"
+	ReportDismissed = "dismissed"
+)
+
+// ActivityPubReport is a report of an actor, and some of its objects, for the moderators
+// of the station. Local users file them, remote servers send them as Flag activities.
+type ActivityPubReport struct {
+	ID          uint64     `gorm:"primary_key;autoIncrement:false"` // Snowflake ID
+	ActivityID  string     `gorm:"size:512;index"`                  // Flag received, empty for local reports
+	ReporterID  string     `gorm:"size:512;not null;index"`         // Actor who filed it
+	TargetID    string     `gorm:"size:512;not null;index"`         // Actor reported
+	ObjectIDs   string     `gorm:"type:text"`                       // JSON array of the objects reported
+	Comment     string     `gorm:"type:text"`
+	IsLocal     bool       `gorm:"default:false;not null"` // Whether a local user filed it
+	Status      string     `gorm:"size:16;not null;index"`
+	ForwardedID string     `gorm:"size:512"`  // Flag forwarded to the server of the target
+	Actions     string     `gorm:"type:text"` // JSON array of the moderation actions taken
+	ResolvedBy  string     `gorm:"size:255"`
+	ResolvedAt  *time.Time // When it was resolved or dismissed
+
+	CreatedAt time.Time `gorm:"created_at"`
+	UpdatedAt time.Time `gorm:"updated_at"`
+}
+
+func (*ActivityPubReport) TableName() string {
+	return "activitypub_reports"
+}
+
+func (r *ActivityPubReport) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == 0 {
+		r.ID = id.NextID()
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package db
+
+import (
+	"time"
+
+	"github.com/peers-touch/peers-touch/station/frame/core/util/id"
+	"gorm.io/gorm"
+)
+
+// Feeds of an ActivityPubTimelineEntry
+const (
+	TimelineHome      = "home"      // Creates and Announces of the actors a local actor follows
+	TimelineLocal     = "local"     // public posts of the local actors
+	TimelineFederated = "federated" // public posts of remote actors
+)
+
+// ActivityPubTimelineEntry is an activity of a timeline. Home timelines belong to a local
+// actor, the local and federated ones are shared. Entries are written when the activity
+// is published or received, and ordered by the row of the activity.
+type ActivityPubTimelineEntry struct {
+	ID         uint64 `gorm:"primary_key;autoIncrement:false"`                              // Snowflake ID
+	Feed       string `gorm:"size:16;not null;uniqueIndex:idx_activitypub_timeline_entry"`  // Timeline the entry is in
+	OwnerID    string `gorm:"size:512;not null;uniqueIndex:idx_activitypub_timeline_entry"` // Local actor of a home timeline, empty for shared ones
+	ActivityID string `gorm:"size:512;not null;uniqueIndex:idx_activitypub_timeline_entry"` // Create or Announce
+	Position   int64  `gorm:"not null;index"`                                               // ID of the activity row
+	ObjectID   string `gorm:"size:512;index"`                                               // Object created or announced
+	ActorID    string `gorm:"size:512;not null;index"`                                      // Actor of the activity
+	AuthorID   string `gorm:"size:512;index"`                                               // Author of the object, who an Announce shares
+	ActorHost  string `gorm:"size:255"`
+	AuthorHost string `gorm:"size:255"`
+
+	CreatedAt time.Time `gorm:"created_at"`
+}
+
+func (*ActivityPubTimelineEntry) TableName() string {
+	return "activitypub_timeline_entries"
+}
+
+func (e *ActivityPubTimelineEntry) BeforeCreate(tx *gorm.DB) error {
+	if e.ID == 0 {
+		e.ID = id.NextID()
+	}
+	return nil
+}
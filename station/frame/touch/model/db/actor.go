@@ -18,6 +18,14 @@ type Actor struct {
 	UpdatedAt time.Time `gorm:"updated_at"`
 }
 
+// DIDPrefix prefixes the peers actor id to build the DID of a local actor.
+const DIDPrefix = "did:peers:"
+
+// DID returns the decentralized identifier the actor is known by in conversations.
+func (a *Actor) DID() string {
+	return DIDPrefix + a.PeersActorID
+}
+
 func (*Actor) TableName() string {
 	return "touch_actor"
 }
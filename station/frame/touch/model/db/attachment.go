@@ -4,10 +4,12 @@ import (
     "time"
 )
 
+// Attachment is content uploaded to a conversation. The same content uploaded to several
+// conversations has a row in each, they share the blob stored under the CID.
 type Attachment struct {
-    CID       string    `gorm:"primary_key;size:128"`
-    ConvID    string    `gorm:"index;size:64"`
-    MsgULID   string    `gorm:"index;size:32"`
+    CID       string    `gorm:"column:cid;primary_key;size:128"`
+    ConvID    string    `gorm:"primary_key;index;size:64"`
+    MsgULID   string    `gorm:"column:msg_ulid;index;size:32"`
     MIME      string    `gorm:"size:64"`
     Bytes     int64     `gorm:"index"`
     Digest    string    `gorm:"size:128"`
@@ -12,21 +12,160 @@ import (
 // call it after store is initiated
 func init() {
 	store.InitTableHooks(func(ctx context.Context, rds *gorm.DB) {
-		err := rds.AutoMigrate(
-			&Actor{}, &PeerAddress{},
-			// ActivityPub models
-			&ActivityPubActor{}, &ActivityPubActivity{}, &ActivityPubObject{},
-			&ActivityPubFollow{}, &ActivityPubLike{}, &ActivityPubCollection{},
-			&Conversation{},
-			&ConvMember{},
-			&Message{},
-			&Attachment{},
-			&Receipt{},
-			&Reaction{},
-			&KeyEpoch{},
-		)
-		if err != nil {
+		if err := AutoMigrate(rds); err != nil {
 			panic(fmt.Errorf("auto migrate failed: %v", err))
 		}
 	})
 }
+
+// AutoMigrate creates or updates the tables of the touch models in rds
+func AutoMigrate(rds *gorm.DB) error {
+	if err := renameColumns(rds); err != nil {
+		return err
+	}
+	if err := stageAttachments(rds); err != nil {
+		return err
+	}
+	err := rds.AutoMigrate(
+		&Actor{}, &PeerAddress{},
+		// ActivityPub models
+		&ActivityPubActor{}, &ActivityPubActivity{}, &ActivityPubObject{},
+		&ActivityPubFollow{}, &ActivityPubLike{}, &ActivityPubAnnounce{}, &ActivityPubCollection{},
+		&ActivityPubDelivery{}, &ActivityPubDeadLetter{}, &ActivityPubInstance{},
+		&ActivityPubDomainPolicy{}, &ActivityPubPolicyAudit{}, &ActivityPubReport{},
+		&ActivityPubTimelineEntry{}, &ActivityPubBlock{}, &ActivityPubBackfill{},
+		&ActivityPubChat{}, &ActivityPubChatMessage{},
+		&Conversation{},
+		&ConvMember{},
+		&Message{},
+		&MessageRevision{},
+		&Attachment{},
+		&BlobUpload{},
+		&Receipt{},
+		&Watermark{},
+		&Reaction{},
+		&KeyEpoch{},
+		&KeyWrap{},
+		&DIDKey{},
+		&Snapshot{},
+	)
+	if err != nil {
+		return err
+	}
+	if err := restoreAttachments(rds); err != nil {
+		return err
+	}
+	return unpublishPrivateRows(rds)
+}
+
+// unpublishPrivateRows clears is_public of the followers-only and direct activities and
+// of the objects they created. gorm stores the default of a column for a zero value, so
+// while is_public defaulted to true every activity and object was stored public.
+func unpublishPrivateRows(rds *gorm.DB) error {
+	private := []string{VisibilityFollowers, VisibilityDirect}
+	return rds.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&ActivityPubObject{}).Where("is_public = ?", true).
+			Where("activity_pub_id IN (?)", tx.Model(&ActivityPubActivity{}).Select("object_id").Where("type = ? AND visibility IN ?", "Create", private)).
+			Update("is_public", false).Error
+		if err != nil {
+			return fmt.Errorf("unpublish private objects: %w", err)
+		}
+		err = tx.Model(&ActivityPubActivity{}).Where("is_public = ? AND visibility IN ?", true, private).Update("is_public", false).Error
+		if err != nil {
+			return fmt.Errorf("unpublish private activities: %w", err)
+		}
+		return nil
+	})
+}
+
+// splitColumns are the columns named after the default naming of gorm, which splits
+// initialisms like ULID into ul_id, before the models named them the way queries use.
+var splitColumns = []struct {
+	model    interface{}
+	old, new string
+}{
+	{&Conversation{}, "avatar_c_id", "avatar_cid"},
+	{&ConvMember{}, "d_id", "did"},
+	{&Message{}, "ul_id", "ulid"},
+	{&Message{}, "sender_d_id", "sender_did"},
+	{&Message{}, "content_c_id", "content_cid"},
+	{&MessageRevision{}, "msg_ul_id", "msg_ulid"},
+	{&MessageRevision{}, "content_c_id", "content_cid"},
+	{&Attachment{}, "c_id", "cid"},
+	{&Attachment{}, "msg_ul_id", "msg_ulid"},
+	{&BlobUpload{}, "msg_ul_id", "msg_ulid"},
+	{&BlobUpload{}, "uploader_d_id", "uploader_did"},
+	{&BlobUpload{}, "c_id", "cid"},
+	{&Receipt{}, "msg_ul_id", "msg_ulid"},
+	{&Receipt{}, "member_d_id", "member_did"},
+	{&Watermark{}, "member_d_id", "member_did"},
+	{&Watermark{}, "delivered_ul_id", "delivered_ulid"},
+	{&Watermark{}, "read_ul_id", "read_ulid"},
+	{&Reaction{}, "msg_ul_id", "msg_ulid"},
+	{&Reaction{}, "member_d_id", "member_did"},
+	{&KeyEpoch{}, "key_meta_c_id", "key_meta_cid"},
+	{&KeyWrap{}, "member_d_id", "member_did"},
+	{&DIDKey{}, "d_id", "did"},
+	{&Snapshot{}, "c_id", "cid"},
+	{&Snapshot{}, "head_ul_id", "head_ulid"},
+	{&ActivityPubChat{}, "local_d_id", "local_did"},
+	{&ActivityPubChat{}, "remote_d_id", "remote_did"},
+	{&ActivityPubChatMessage{}, "msg_ul_id", "msg_ulid"},
+}
+
+// renameColumns gives the split columns of existing tables their new names, so that the
+// rows stored before are kept.
+func renameColumns(rds *gorm.DB) error {
+	m := rds.Migrator()
+	for _, c := range splitColumns {
+		if !m.HasTable(c.model) || !m.HasColumn(c.model, c.old) || m.HasColumn(c.model, c.new) {
+			continue
+		}
+		if err := m.RenameColumn(c.model, c.old, c.new); err != nil {
+			return fmt.Errorf("rename column %s to %s: %w", c.old, c.new, err)
+		}
+	}
+	return nil
+}
+
+// stagedAttachments keeps the rows of an attachment table keyed by cid alone while the
+// table is created again keyed by cid and conversation.
+const stagedAttachments = "touch_attachment_staged"
+
+// stageAttachments copies the attachments of a table keyed by cid alone aside and drops
+// it, so that AutoMigrate creates it keyed by cid and conversation. restoreAttachments
+// brings the rows back, a migration cut short goes on from the staged rows.
+func stageAttachments(rds *gorm.DB) error {
+	m := rds.Migrator()
+	if !m.HasTable(&Attachment{}) || m.HasTable(stagedAttachments) {
+		return nil
+	}
+	cols, err := m.ColumnTypes(&Attachment{})
+	if err != nil {
+		return err
+	}
+	for _, c := range cols {
+		if pk, ok := c.PrimaryKey(); c.Name() == "conv_id" && (pk || !ok) {
+			return nil
+		}
+	}
+	return rds.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("CREATE TABLE " + stagedAttachments + " AS SELECT * FROM " + (&Attachment{}).TableName()).Error; err != nil {
+			return fmt.Errorf("stage attachments: %w", err)
+		}
+		return tx.Migrator().DropTable(&Attachment{})
+	})
+}
+
+func restoreAttachments(rds *gorm.DB) error {
+	if !rds.Migrator().HasTable(stagedAttachments) {
+		return nil
+	}
+	return rds.Transaction(func(tx *gorm.DB) error {
+		cols := "cid, conv_id, msg_ulid, mime, bytes, digest, store, created_at, updated_at"
+		if err := tx.Exec("INSERT INTO " + (&Attachment{}).TableName() + " (" + cols + ") SELECT " + cols + " FROM " + stagedAttachments).Error; err != nil {
+			return fmt.Errorf("restore attachments: %w", err)
+		}
+		return tx.Migrator().DropTable(stagedAttachments)
+	})
+}
@@ -0,0 +1,117 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestAutoMigrateRenamesSplitColumns(t *testing.T) {
+	rds, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "touch.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the table as the default naming of gorm created it
+	if err := rds.Exec(`CREATE TABLE touch_reaction (id INTEGER PRIMARY KEY, msg_ul_id TEXT, member_d_id TEXT, emoji TEXT, op TEXT, ts INTEGER)`).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := rds.Exec(`INSERT INTO touch_reaction (id, msg_ul_id, member_d_id, emoji, op, ts) VALUES (1, 'm1', 'did:peers:alice', 'x', 'add', 1)`).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := AutoMigrate(rds); err != nil {
+		t.Fatal(err)
+	}
+	var rc Reaction
+	if err := rds.Where("msg_ulid = ? AND member_did = ?", "m1", "did:peers:alice").First(&rc).Error; err != nil {
+		t.Fatalf("reaction stored before is lost: %v", err)
+	}
+	if rds.Migrator().HasColumn(&Reaction{}, "msg_ul_id") {
+		t.Fatal("split column is left")
+	}
+	// migrating again leaves the columns alone
+	if err := AutoMigrate(rds); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAutoMigrateKeysAttachmentsByConversation(t *testing.T) {
+	rds, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "touch.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the table keyed by cid alone
+	if err := rds.Exec(`CREATE TABLE touch_attachment (cid TEXT PRIMARY KEY, conv_id TEXT, msg_ulid TEXT, mime TEXT, bytes INTEGER, digest TEXT, store TEXT, created_at DATETIME, updated_at DATETIME)`).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := rds.Exec(`CREATE INDEX idx_touch_attachment_conv_id ON touch_attachment (conv_id)`).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := rds.Exec(`INSERT INTO touch_attachment (cid, conv_id, mime, bytes, store) VALUES ('c1', 'conv-a', 'image/png', 3, 'local')`).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := AutoMigrate(rds); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var a Attachment
+	if err := rds.Where("cid = ? AND conv_id = ?", "c1", "conv-a").First(&a).Error; err != nil || a.MIME != "image/png" {
+		t.Fatalf("attachment stored before = %+v, %v", a, err)
+	}
+	if err := rds.Create(&Attachment{CID: "c1", ConvID: "conv-b", MIME: "image/png", Bytes: 3, Store: "local"}).Error; err != nil {
+		t.Fatalf("the content cannot be attached to a second conversation: %v", err)
+	}
+	if rds.Migrator().HasTable(stagedAttachments) {
+		t.Fatal("staged attachments are left")
+	}
+}
+
+func TestAutoMigrateUnpublishesPrivateRows(t *testing.T) {
+	rds, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "touch.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := AutoMigrate(rds); err != nil {
+		t.Fatal(err)
+	}
+	// the rows as they were stored while is_public defaulted to true
+	for i, r := range []struct {
+		id, visibility string
+	}{{"public", VisibilityPublic}, {"followers", VisibilityFollowers}, {"direct", VisibilityDirect}} {
+		if err := rds.Exec(`INSERT INTO activitypub_activities (id, activity_pub_id, type, actor_id, object_id, published, is_local, is_public, visibility) VALUES (?, ?, 'Create', 'alice', ?, ?, true, true, ?)`,
+			i+1, "create-"+r.id, "note-"+r.id, time.Now(), r.visibility).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := rds.Exec(`INSERT INTO activitypub_objects (id, activity_pub_id, type, is_local, is_public) VALUES (?, ?, 'Note', true, true)`, i+1, "note-"+r.id).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := AutoMigrate(rds); err != nil {
+		t.Fatal(err)
+	}
+	var activities, objects []string
+	if err := rds.Model(&ActivityPubActivity{}).Where("is_public = ?", true).Pluck("activity_pub_id", &activities).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := rds.Model(&ActivityPubObject{}).Where("is_public = ?", true).Pluck("activity_pub_id", &objects).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(activities) != 1 || activities[0] != "create-public" || len(objects) != 1 || objects[0] != "note-public" {
+		t.Fatalf("public activities %v and objects %v", activities, objects)
+	}
+	// rows stored now keep a false is_public
+	if err := rds.Select("*").Create(&ActivityPubObject{ActivityPubID: "note-new", Type: "Note"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	var ob ActivityPubObject
+	if err := rds.Where("activity_pub_id = ?", "note-new").First(&ob).Error; err != nil || ob.IsPublic {
+		t.Fatalf("object = %+v, %v", ob, err)
+	}
+}
@@ -0,0 +1,35 @@
+package db
+
+import (
+    "time"
+
+    "github.com/peers-touch/peers-touch/station/frame/core/util/id"
+    "gorm.io/gorm"
+)
+
+// BlobUpload is a resumable attachment upload in progress. Received counts the bytes
+// staged so far; once it reaches Bytes the content is verified against Digest and the
+// upload turns into an Attachment.
+type BlobUpload struct {
+    ID          string    `gorm:"primary_key;size:32"`
+    ConvID      string    `gorm:"index;size:64;not null"`
+    MsgULID     string    `gorm:"column:msg_ulid;size:32"`
+    UploaderDID string    `gorm:"column:uploader_did;size:128;not null"`
+    MIME        string    `gorm:"size:64"`
+    Bytes       int64     `gorm:"not null"`
+    Received    int64
+    Digest      string    `gorm:"size:128;not null"`
+    // CID is the content id announced by the client, checked on completion when set.
+    CID         string    `gorm:"column:cid;size:128"`
+    Store       string    `gorm:"size:32"`
+    ExpiresAt   time.Time `gorm:"index"`
+    CreatedAt   time.Time `gorm:"created_at"`
+    UpdatedAt   time.Time `gorm:"updated_at"`
+}
+
+func (*BlobUpload) TableName() string { return "touch_blob_upload" }
+
+func (u *BlobUpload) BeforeCreate(tx *gorm.DB) error {
+    if u.ID == "" { u.ID = id.NextULID() }
+    return nil
+}
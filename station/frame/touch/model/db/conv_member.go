@@ -9,10 +9,19 @@ import (
 
 type Role string
 
+const (
+    RoleOwner  Role = "owner"
+    RoleAdmin  Role = "admin"
+    RoleMember Role = "member"
+)
+
+// CanManage reports whether the role may change members and rotate keys.
+func (r Role) CanManage() bool { return r == RoleOwner || r == RoleAdmin }
+
 type ConvMember struct {
     ID        uint64    `gorm:"primary_key;autoIncrement:false"`
     ConvID    uint64    `gorm:"index;not null"`
-    DID       string    `gorm:"size:128;not null"`
+    DID       string    `gorm:"column:did;size:128;not null"`
     Role      Role      `gorm:"size:16"`
     JoinedAt  time.Time `gorm:"index"`
     CreatedAt time.Time `gorm:"created_at"`
@@ -14,8 +14,10 @@ type Conversation struct {
     ConvID    string           `gorm:"uniqueIndex;size:64;not null"`
     Type      ConversationType `gorm:"size:16;index"`
     Title     string           `gorm:"size:255"`
-    AvatarCID string           `gorm:"size:128"`
+    AvatarCID string           `gorm:"column:avatar_cid;size:128"`
     Policy    string           `gorm:"size:255"`
+    // Encrypted conversations carry ciphertext only, Epoch is their current key epoch.
+    Encrypted bool
     Epoch     int              `gorm:"index"`
     CreatedAt time.Time        `gorm:"created_at"`
     UpdatedAt time.Time        `gorm:"updated_at"`
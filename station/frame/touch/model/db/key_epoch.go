@@ -7,18 +7,60 @@ import (
     "gorm.io/gorm"
 )
 
+// KeyEpoch is one generation of the group key of an encrypted conversation. The key itself
+// never reaches the server, members get it through their KeyWrap of the epoch.
+// KeyMetaCID addresses the canonical list of the wraps, so clients can check they got
+// all of them.
 type KeyEpoch struct {
+    ID         uint64    `gorm:"primary_key;autoIncrement:false"`
+    ConvID     uint64    `gorm:"index;uniqueIndex:idx_key_epoch_conv_epoch;not null"`
+    Epoch      int       `gorm:"index;uniqueIndex:idx_key_epoch_conv_epoch"`
+    KeyMetaCID string    `gorm:"column:key_meta_cid;size:128"`
+    Alg        string    `gorm:"size:32"`
+    RotatedBy  string    `gorm:"size:128"`
+    Reason     string    `gorm:"size:32"`
+    CreatedAt  time.Time `gorm:"created_at"`
+    UpdatedAt  time.Time `gorm:"updated_at"`
+}
+
+func (*KeyEpoch) TableName() string { return "touch_key_epoch" }
+
+func (k *KeyEpoch) BeforeCreate(tx *gorm.DB) error {
+    if k.ID == 0 { k.ID = id.NextID() }
+    return nil
+}
+
+// KeyWrap is the epoch key of a conversation wrapped for one member public key. It is
+// opaque to the server.
+type KeyWrap struct {
+    ID         uint64    `gorm:"primary_key;autoIncrement:false"`
+    ConvID     uint64    `gorm:"index;uniqueIndex:idx_key_wrap_member;not null"`
+    Epoch      int       `gorm:"uniqueIndex:idx_key_wrap_member"`
+    MemberDID  string    `gorm:"column:member_did;uniqueIndex:idx_key_wrap_member;size:128;not null"`
+    WrappedKey string    `gorm:"type:text;not null"`
+    CreatedAt  time.Time `gorm:"created_at"`
+}
+
+func (*KeyWrap) TableName() string { return "touch_key_wrap" }
+
+func (k *KeyWrap) BeforeCreate(tx *gorm.DB) error {
+    if k.ID == 0 { k.ID = id.NextID() }
+    return nil
+}
+
+// DIDKey is the public key a DID publishes to receive wrapped conversation keys.
+type DIDKey struct {
     ID        uint64    `gorm:"primary_key;autoIncrement:false"`
-    ConvID    uint64    `gorm:"index;not null"`
-    Epoch     int       `gorm:"index"`
-    KeyMetaCID string   `gorm:"size:128"`
+    DID       string    `gorm:"column:did;uniqueIndex;size:128;not null"`
+    Alg       string    `gorm:"size:32;not null"`
+    PublicKey string    `gorm:"size:256;not null"`
     CreatedAt time.Time `gorm:"created_at"`
     UpdatedAt time.Time `gorm:"updated_at"`
 }
 
-func (*KeyEpoch) TableName() string { return "touch_key_epoch" }
+func (*DIDKey) TableName() string { return "touch_did_key" }
 
-func (k *KeyEpoch) BeforeCreate(tx *gorm.DB) error {
+func (k *DIDKey) BeforeCreate(tx *gorm.DB) error {
     if k.ID == 0 { k.ID = id.NextID() }
     return nil
-}
\ No newline at end of file
+}
@@ -11,21 +11,30 @@ type MessageType string
 
 type Message struct {
     ID          uint64      `gorm:"primary_key;autoIncrement:false"`
-    ULID        string      `gorm:"uniqueIndex;size:32;not null"`
+    ULID        string      `gorm:"column:ulid;uniqueIndex;index:idx_touch_message_page,priority:3;size:32;not null"`
     ConvPK      uint64      `gorm:"index;not null"`
-    ConvID      string      `gorm:"index;size:64;not null"`
-    SenderDID   string      `gorm:"size:128;index"`
-    TS          int64       `gorm:"index"`
+    ConvID      string      `gorm:"index;index:idx_touch_message_page,priority:1;size:64;not null"`
+    SenderDID   string      `gorm:"column:sender_did;size:128;index"`
+    TS          int64       `gorm:"index;index:idx_touch_message_page,priority:2"`
     Type        MessageType `gorm:"size:16;index"`
-    ParentID    string      `gorm:"size:32"`
-    ThreadID    string      `gorm:"size:32"`
-    ContentCID  string      `gorm:"size:128"`
+    ParentID    string      `gorm:"size:32;index"`
+    ThreadID    string      `gorm:"size:32;index"`
+    ContentCID  string      `gorm:"column:content_cid;size:128"`
+    Body        string      `gorm:"type:text"`
+    // Epoch is the key epoch Body is encrypted under, 0 for plaintext.
+    Epoch       int
+    Rev         int
+    EditedAt    time.Time
     Deleted     bool        `gorm:"index"`
+    DeletedAt   time.Time
     TTLAt       time.Time   `gorm:"index"`
     CreatedAt   time.Time   `gorm:"created_at"`
     UpdatedAt   time.Time   `gorm:"updated_at"`
 }
 
+// Expired reports whether the message outlived its TTL at now.
+func (m *Message) Expired(now time.Time) bool { return !m.TTLAt.IsZero() && !m.TTLAt.After(now) }
+
 func (*Message) TableName() string { return "touch_message" }
 
 func (m *Message) BeforeCreate(tx *gorm.DB) error {
@@ -0,0 +1,29 @@
+package db
+
+import (
+    "time"
+
+    "github.com/peers-touch/peers-touch/station/frame/core/util/id"
+    "gorm.io/gorm"
+)
+
+// MessageRevision is a former content of an edited message. Rev counts from 0, the
+// content the message was created with.
+type MessageRevision struct {
+    ID         uint64    `gorm:"primary_key;autoIncrement:false"`
+    MsgULID    string    `gorm:"column:msg_ulid;uniqueIndex:idx_msg_rev;size:32;not null"`
+    Rev        int       `gorm:"uniqueIndex:idx_msg_rev"`
+    ConvID     string    `gorm:"index;size:64;not null"`
+    ContentCID string    `gorm:"column:content_cid;size:128"`
+    Body       string    `gorm:"type:text"`
+    Epoch      int
+    EditedAt   time.Time
+    CreatedAt  time.Time `gorm:"created_at"`
+}
+
+func (*MessageRevision) TableName() string { return "touch_message_revision" }
+
+func (r *MessageRevision) BeforeCreate(tx *gorm.DB) error {
+    if r.ID == 0 { r.ID = id.NextID() }
+    return nil
+}
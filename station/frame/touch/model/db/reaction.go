@@ -7,11 +7,18 @@ import (
     "gorm.io/gorm"
 )
 
+const (
+    ReactionOpAdd    = "add"
+    ReactionOpRemove = "remove"
+)
+
+// Reaction is the last op of a member on one emoji of a message. Ops are applied in TS
+// order, so the row converges whatever order the devices of a member send them in.
 type Reaction struct {
     ID        uint64    `gorm:"primary_key;autoIncrement:false"`
-    MsgULID   string    `gorm:"index;size:32;not null"`
-    MemberDID string    `gorm:"index;size:128;not null"`
-    Emoji     string    `gorm:"size:16;not null"`
+    MsgULID   string    `gorm:"column:msg_ulid;index;uniqueIndex:idx_reaction_member_emoji;size:32;not null"`
+    MemberDID string    `gorm:"column:member_did;index;uniqueIndex:idx_reaction_member_emoji;size:128;not null"`
+    Emoji     string    `gorm:"uniqueIndex:idx_reaction_member_emoji;size:64;not null"`
     Op        string    `gorm:"size:8"`
     TS        int64     `gorm:"index"`
     CreatedAt time.Time `gorm:"created_at"`
@@ -7,10 +7,12 @@ import (
     "gorm.io/gorm"
 )
 
+// Receipt records a message that failed to reach a member, FailReason tells why.
+// Deliveries and reads are tracked by the member Watermark instead.
 type Receipt struct {
     ID          uint64    `gorm:"primary_key;autoIncrement:false"`
-    MsgULID     string    `gorm:"index;size:32;not null"`
-    MemberDID   string    `gorm:"index;size:128;not null"`
+    MsgULID     string    `gorm:"column:msg_ulid;index;size:32;not null"`
+    MemberDID   string    `gorm:"column:member_did;index;size:128;not null"`
     DeliveredAt time.Time `gorm:"index"`
     ReadAt      time.Time `gorm:"index"`
     FailReason  string    `gorm:"size:128"`
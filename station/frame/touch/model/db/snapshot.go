@@ -0,0 +1,30 @@
+package db
+
+import (
+    "time"
+
+    "github.com/peers-touch/peers-touch/station/frame/core/util/id"
+    "gorm.io/gorm"
+)
+
+// Snapshot is a content-addressed capture of a conversation state. Data holds the
+// encoded snapshot and CID is computed over it.
+type Snapshot struct {
+    ID        uint64    `gorm:"primary_key;autoIncrement:false"`
+    CID       string    `gorm:"column:cid;uniqueIndex;size:128;not null"`
+    ConvID    string    `gorm:"index;size:64;not null"`
+    Version   int       `gorm:"not null"`
+    HeadULID  string    `gorm:"column:head_ulid;size:32"`
+    Epoch     int
+    Bytes     int64
+    Data      []byte
+    CreatedAt time.Time `gorm:"created_at;index"`
+    UpdatedAt time.Time `gorm:"updated_at"`
+}
+
+func (*Snapshot) TableName() string { return "touch_snapshot" }
+
+func (s *Snapshot) BeforeCreate(tx *gorm.DB) error {
+    if s.ID == 0 { s.ID = id.NextID() }
+    return nil
+}
@@ -0,0 +1,28 @@
+package db
+
+import (
+    "time"
+
+    "github.com/peers-touch/peers-touch/station/frame/core/util/id"
+    "gorm.io/gorm"
+)
+
+// Watermark is how far a member got in a conversation: every message up to DeliveredULID
+// reached one of its devices and every message up to ReadULID was read. Watermarks only
+// move forward, and read implies delivered.
+type Watermark struct {
+    ID            uint64    `gorm:"primary_key;autoIncrement:false"`
+    ConvID        string    `gorm:"uniqueIndex:idx_watermark_member;size:64;not null"`
+    MemberDID     string    `gorm:"column:member_did;uniqueIndex:idx_watermark_member;size:128;not null"`
+    DeliveredULID string    `gorm:"column:delivered_ulid;size:32"`
+    ReadULID      string    `gorm:"column:read_ulid;size:32"`
+    CreatedAt     time.Time `gorm:"created_at"`
+    UpdatedAt     time.Time `gorm:"updated_at"`
+}
+
+func (*Watermark) TableName() string { return "touch_watermark" }
+
+func (w *Watermark) BeforeCreate(tx *gorm.DB) error {
+    if w.ID == 0 { w.ID = id.NextID() }
+    return nil
+}
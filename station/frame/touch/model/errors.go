@@ -8,7 +8,7 @@ var (
 	ErrUndefined = NewError("t00000", "undefined")
 
 	ErrWellKnownInvalidResourceFormat = NewError("t10001", "invalid resource format, should be <type>:<value>, e.g. acct:$EMAIL")
-	ErrWellKnownUnsupportedPrefixType = NewError("t10002", "unsupported type, only acct is supported")
+	ErrWellKnownUnsupportedPrefixType = NewError("t10002", "unsupported type, only acct and https are supported")
 	ErrActorInvalidName               = NewError("t10003", "signup with an invalid name")
 	ErrActorInvalidEmail              = NewError("t10004", "signup with an invalid email")
 	ErrActorInvalidPassword           = NewError("t10005", "signup with an invalid password")
@@ -17,6 +17,78 @@ var (
 	ErrActorNotFound                  = NewError("t10008", "actor not found")
 	ErrActorInvalidCredentials        = NewError("t10009", "invalid email or password")
 	ErrPeerAddrExists                 = NewError("t10010", "peer address already exists")
+	ErrNotAdmin                       = NewError("t10011", "only administrators can manage the station")
+
+	ErrActivityInvalid           = NewError("t20001", "invalid activity")
+	ErrActivityNotFound          = NewError("t20002", "activity not found")
+	ErrActivityPubActorExists    = NewError("t20003", "actor already exists")
+	ErrActivityPubObjectNotFound = NewError("t20004", "object not found")
+	ErrActivityPubNotOwner       = NewError("t20005", "only the owner can act as the actor")
+
+	ErrSignatureInvalid       = NewError("t20010", "missing or invalid http signature")
+	ErrSignatureKeyNotFound   = NewError("t20011", "http signature key not found")
+	ErrSignatureActorMismatch = NewError("t20012", "activity actor does not own the signature key")
+
+	ErrFederationRejected = NewError("t20020", "the domain is rejected by the federation policy")
+	ErrPolicyInvalid      = NewError("t20021", "invalid federation policy, it needs a domain and an action")
+	ErrPolicyNotFound     = NewError("t20022", "no federation policy for the domain")
+
+	ErrActorSuspended  = NewError("t20030", "the actor is suspended")
+	ErrReportInvalid   = NewError("t20031", "invalid report, it needs a reported actor or object")
+	ErrReportNotFound  = NewError("t20032", "report not found")
+	ErrReportBadAction = NewError("t20033", "report action should be suspend, unsuspend, delete_object, forward, resolve, dismiss or reopen")
+
+	ErrVisibilityInvalid = NewError("t20040", "visibility should be public, unlisted, followers or direct")
+
+	ErrTimelineNotFound = NewError("t20050", "timeline should be home, local or federated")
+	ErrBlockSelf        = NewError("t20051", "actors cannot block or mute themselves")
+
+	ErrChatNotRemote   = NewError("t20060", "chats are bridged with remote actors only")
+	ErrChatTypeInvalid = NewError("t20061", "chat type should be Note or ChatMessage")
+
+	ErrSnapshotInvalid        = NewError("t30001", "invalid snapshot")
+	ErrSnapshotVersion        = NewError("t30002", "unsupported snapshot version")
+	ErrSnapshotCIDMismatch    = NewError("t30003", "snapshot content does not match its cid")
+	ErrSnapshotNotFound       = NewError("t30004", "snapshot not found")
+	ErrSnapshotConvIDMismatch = NewError("t30005", "snapshot belongs to another conversation")
+
+	ErrMessageNotFound      = NewError("t30010", "message not found")
+	ErrMessageDeleted       = NewError("t30011", "message is deleted")
+	ErrMessageNotSender     = NewError("t30012", "only the sender can change the message")
+	ErrMessageEditConflict  = NewError("t30013", "message changed meanwhile, reload and retry")
+	ErrMessageEmpty         = NewError("t30014", "message has neither body nor content")
+	ErrMessageInvalidCursor = NewError("t30015", "invalid message cursor")
+	ErrMessageInvalidULID   = NewError("t30016", "message ulid is not a valid ULID")
+	ErrMessageULIDSkew      = NewError("t30017", "message ulid time is too far from the server time")
+
+	ErrReactionInvalidOp    = NewError("t30020", "reaction op should be add or remove")
+	ErrReactionInvalidEmoji = NewError("t30021", "reaction emoji is empty or too long")
+
+	ErrUnauthenticated = NewError("t30030", "authentication required")
+	ErrConvNotFound    = NewError("t30031", "conversation not found")
+	ErrConvNotMember   = NewError("t30032", "not a member of the conversation")
+	ErrConvForbidden   = NewError("t30033", "the member role is not allowed to do this")
+	ErrConvInvalidRole = NewError("t30034", "role should be owner, admin or member")
+	ErrConvLastOwner   = NewError("t30035", "the last owner can't leave the conversation")
+
+	ErrConvNotEncrypted    = NewError("t30040", "conversation is not end-to-end encrypted")
+	ErrKeyEpochStale       = NewError("t30041", "key epoch is not the current one, reload and retry")
+	ErrKeyEpochNotFound    = NewError("t30042", "key epoch not found")
+	ErrKeyWrapsMismatch    = NewError("t30043", "wrapped keys should cover exactly the members")
+	ErrKeyInvalidPublicKey = NewError("t30044", "invalid public key")
+	ErrKeyUnsupportedAlg   = NewError("t30045", "unsupported key algorithm")
+	ErrKeyRotationRequired = NewError("t30046", "removing members of an encrypted conversation requires a key rotation")
+	ErrMessageEpoch        = NewError("t30047", "message key epoch does not match the conversation")
+
+	ErrAttachmentNotFound   = NewError("t30050", "attachment not found")
+	ErrUploadNotFound       = NewError("t30052", "upload not found or expired")
+	ErrUploadNotUploader    = NewError("t30053", "only the uploader can continue the upload")
+	ErrUploadOffset         = NewError("t30054", "chunk offset does not match the bytes received")
+	ErrUploadTooLarge       = NewError("t30055", "upload is empty or exceeds the size limit")
+	ErrUploadDigest         = NewError("t30056", "digest should be sha-256=<base64>")
+	ErrUploadDigestMismatch = NewError("t30057", "uploaded content does not match its digest")
+	ErrUploadCIDMismatch    = NewError("t30058", "uploaded content does not match its cid")
+	ErrBlobStoreUnsupported = NewError("t30059", "unknown attachment store")
 )
 
 type Error struct {
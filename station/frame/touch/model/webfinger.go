@@ -231,6 +231,22 @@ func BuildWebFingerResponse(actor *WebFingerActivityPubActor, baseURL string, re
 		})
 	}
 
+	if actor.Followers != "" {
+		response.Links = append(response.Links, WebFingerLink{
+			Rel:  RelActivityPubFollowers,
+			Type: ContentTypeActivityJSON,
+			Href: actor.Followers,
+		})
+	}
+
+	if actor.Following != "" {
+		response.Links = append(response.Links, WebFingerLink{
+			Rel:  RelActivityPubFollowing,
+			Type: ContentTypeActivityJSON,
+			Href: actor.Following,
+		})
+	}
+
 	return response
 }
 
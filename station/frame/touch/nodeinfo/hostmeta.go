@@ -0,0 +1,65 @@
+package nodeinfo
+
+import (
+	"encoding/xml"
+	"strings"
+)
+
+const (
+	// ContentTypeXRD is the media type of the XRD host-meta document
+	ContentTypeXRD = "application/xrd+xml"
+	// ContentTypeJRD is the media type of the JSON host-meta document
+	ContentTypeJRD = "application/jrd+json"
+	// RelLRDD is the rel of the link templating the WebFinger queries of the station
+	RelLRDD = "lrdd"
+
+	xrdNamespace = "http://docs.oasis-open.org/ns/xri/xrd-1.0"
+)
+
+// HostMeta is the host-meta document (RFC 6415), it links the WebFinger endpoint. It
+// marshals to XRD with encoding/xml and to JRD with encoding/json.
+type HostMeta struct {
+	XMLName xml.Name       `xml:"XRD" json:"-"`
+	XMLNS   string         `xml:"xmlns,attr" json:"-"`
+	Links   []HostMetaLink `xml:"Link" json:"links"`
+}
+
+// HostMetaLink is a link of the host-meta document
+type HostMetaLink struct {
+	Rel      string `xml:"rel,attr" json:"rel"`
+	Type     string `xml:"type,attr,omitempty" json:"type,omitempty"`
+	Template string `xml:"template,attr" json:"template"`
+}
+
+// NewHostMeta returns the host-meta document of the station at baseURL
+func NewHostMeta(baseURL string) *HostMeta {
+	return &HostMeta{
+		XMLNS: xrdNamespace,
+		Links: []HostMetaLink{{
+			Rel:      RelLRDD,
+			Type:     ContentTypeXRD,
+			Template: strings.TrimSuffix(baseURL, "/") + "/.well-known/webfinger?resource={uri}",
+		}},
+	}
+}
+
+// XRD renders the document as XRD
+func (h *HostMeta) XRD() ([]byte, error) {
+	b, err := xml.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), b...), nil
+}
+
+// JRD returns the document to render as JSON, its links templating JRD documents
+func (h *HostMeta) JRD() *HostMeta {
+	jrd := &HostMeta{Links: make([]HostMetaLink, len(h.Links))}
+	for i, l := range h.Links {
+		if l.Type == ContentTypeXRD {
+			l.Type = ContentTypeJRD
+		}
+		jrd.Links[i] = l
+	}
+	return jrd
+}
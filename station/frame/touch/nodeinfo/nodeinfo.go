@@ -0,0 +1,187 @@
+// Package nodeinfo describes the station to other fediverse software: the NodeInfo 2.1
+// document with its usage statistics, and the host-meta document linking WebFinger.
+package nodeinfo
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	cfg "github.com/peers-touch/peers-touch/station/frame/core/config"
+	"github.com/peers-touch/peers-touch/station/frame/core/store"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/db"
+	ap "github.com/peers-touch/peers-touch/station/frame/vendors/activitypub"
+	"gorm.io/gorm"
+)
+
+const (
+	// SchemaVersion is the NodeInfo schema version served
+	SchemaVersion = "2.1"
+	// Rel is the rel of the NodeInfo 2.1 document in the discovery document
+	Rel = "http://nodeinfo.diaspora.software/ns/schema/2.1"
+	// ContentType is the media type of the NodeInfo 2.1 document
+	ContentType = `application/json; profile="http://nodeinfo.diaspora.software/ns/schema/2.1#"`
+
+	// SoftwareName identifies the software, as NodeInfo restricts it to [a-z0-9-]
+	SoftwareName       = "peers-touch"
+	softwareRepository = "https://github.com/peers-touch/peers-touch"
+
+	defaultStatsTTL = 30 * time.Minute
+)
+
+// Discovery is the /.well-known/nodeinfo document linking the NodeInfo documents
+type Discovery struct {
+	Links []Link `json:"links"`
+}
+
+// Link is a link of the discovery document
+type Link struct {
+	Rel  string `json:"rel"`
+	Href string `json:"href"`
+}
+
+// NodeInfo is the NodeInfo 2.1 document
+type NodeInfo struct {
+	Version           string                 `json:"version"`
+	Software          Software               `json:"software"`
+	Protocols         []string               `json:"protocols"`
+	Services          Services               `json:"services"`
+	OpenRegistrations bool                   `json:"openRegistrations"`
+	Usage             Usage                  `json:"usage"`
+	Metadata          map[string]interface{} `json:"metadata"`
+}
+
+// Software names the software running the station
+type Software struct {
+	Name       string `json:"name"`
+	Version    string `json:"version"`
+	Repository string `json:"repository,omitempty"`
+	Homepage   string `json:"homepage,omitempty"`
+}
+
+// Services lists the third party sites the station can exchange with
+type Services struct {
+	Inbound  []string `json:"inbound"`
+	Outbound []string `json:"outbound"`
+}
+
+// Usage carries the usage statistics of the station
+type Usage struct {
+	Users         Users `json:"users"`
+	LocalPosts    int64 `json:"localPosts"`
+	LocalComments int64 `json:"localComments"`
+}
+
+// Users counts the local users, and those active over the last month and half year
+type Users struct {
+	Total          int64 `json:"total"`
+	ActiveMonth    int64 `json:"activeMonth"`
+	ActiveHalfyear int64 `json:"activeHalfyear"`
+}
+
+// NewDiscovery returns the discovery document of the station at baseURL
+func NewDiscovery(baseURL string) *Discovery {
+	return &Discovery{Links: []Link{{Rel: Rel, Href: strings.TrimSuffix(baseURL, "/") + "/.well-known/nodeinfo/" + SchemaVersion}}}
+}
+
+// Get returns the NodeInfo document of the station. Its usage statistics are computed
+// from the RDS and cached for peers.touch.nodeinfo.stats_ttl.
+func Get(ctx context.Context) (*NodeInfo, error) {
+	conf := func(key string) string {
+		return cfg.Get("peers", "touch", "nodeinfo", key).String("")
+	}
+	usage, err := stats.get(ctx, cfg.Get("peers", "touch", "nodeinfo", "stats_ttl").Duration(defaultStatsTTL))
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := map[string]interface{}{}
+	if name := conf("node_name"); name != "" {
+		metadata["nodeName"] = name
+	}
+	if description := conf("node_description"); description != "" {
+		metadata["nodeDescription"] = description
+	}
+	return &NodeInfo{
+		Version: SchemaVersion,
+		Software: Software{
+			Name:       SoftwareName,
+			Version:    cfg.Get("peers", "service", "server", "version").String("dev"),
+			Repository: softwareRepository,
+			Homepage:   softwareRepository,
+		},
+		Protocols:         []string{"activitypub"},
+		Services:          Services{Inbound: []string{}, Outbound: []string{}},
+		OpenRegistrations: cfg.Get("peers", "touch", "nodeinfo", "open_registrations").Bool(true),
+		Usage:             *usage,
+		Metadata:          metadata,
+	}, nil
+}
+
+var stats = &usageCache{load: loadUsage, now: time.Now}
+
+// usageCache keeps the usage statistics for a while, counting them on every fetch of
+// the document would let anyone load the RDS.
+type usageCache struct {
+	load func(ctx context.Context) (*Usage, error)
+	now  func() time.Time
+
+	mu      sync.Mutex
+	usage   *Usage
+	expires time.Time
+}
+
+func (c *usageCache) get(ctx context.Context, ttl time.Duration) (*Usage, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.usage != nil && c.now().Before(c.expires) {
+		return c.usage, nil
+	}
+	usage, err := c.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.usage, c.expires = usage, c.now().Add(ttl)
+	return usage, nil
+}
+
+// loadUsage counts the users of touch_actor, those who published an activity lately and
+// the local objects that are not tombstones, replies counting as comments.
+func loadUsage(ctx context.Context) (*Usage, error) {
+	rds, err := store.GetRDS(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var usage Usage
+	if err := rds.Model(&db.Actor{}).Count(&usage.Users.Total).Error; err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if usage.Users.ActiveMonth, err = activeSince(rds, now.AddDate(0, 0, -30)); err != nil {
+		return nil, err
+	}
+	if usage.Users.ActiveHalfyear, err = activeSince(rds, now.AddDate(0, 0, -180)); err != nil {
+		return nil, err
+	}
+
+	objects := func() *gorm.DB {
+		return rds.Model(&db.ActivityPubObject{}).Where("is_local = ? AND type <> ?", true, string(ap.TombstoneType))
+	}
+	if err := objects().Where("in_reply_to = ''").Count(&usage.LocalPosts).Error; err != nil {
+		return nil, err
+	}
+	if err := objects().Where("in_reply_to <> ''").Count(&usage.LocalComments).Error; err != nil {
+		return nil, err
+	}
+	return &usage, nil
+}
+
+// activeSince counts the local actors who published an activity since the time
+func activeSince(rds *gorm.DB, since time.Time) (int64, error) {
+	var count int64
+	err := rds.Model(&db.ActivityPubActivity{}).
+		Where("is_local = ? AND published >= ?", true, since).
+		Distinct("actor_id").Count(&count).Error
+	return count, err
+}
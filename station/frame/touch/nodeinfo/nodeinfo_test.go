@@ -0,0 +1,57 @@
+package nodeinfo
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUsageCache(t *testing.T) {
+	loads := 0
+	now := time.Now()
+	c := &usageCache{
+		load: func(context.Context) (*Usage, error) {
+			loads++
+			return &Usage{Users: Users{Total: int64(loads)}}, nil
+		},
+		now: func() time.Time { return now },
+	}
+	for i := 0; i < 3; i++ {
+		if u, err := c.get(context.Background(), defaultStatsTTL); err != nil || u.Users.Total != 1 {
+			t.Fatalf("usage = %+v, %v", u, err)
+		}
+	}
+	now = now.Add(defaultStatsTTL)
+	if u, _ := c.get(context.Background(), defaultStatsTTL); u.Users.Total != 2 {
+		t.Fatalf("expired usage not counted again, loaded %d times", loads)
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	d := NewDiscovery("https://example.com/")
+	if len(d.Links) != 1 || d.Links[0].Rel != Rel || d.Links[0].Href != "https://example.com/.well-known/nodeinfo/2.1" {
+		t.Fatalf("links = %+v", d.Links)
+	}
+}
+
+func TestHostMeta(t *testing.T) {
+	h := NewHostMeta("https://example.com")
+	xrd, err := h.XRD()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<Link rel="lrdd" type="application/xrd+xml" template="https://example.com/.well-known/webfinger?resource={uri}"></Link>`
+	if !strings.Contains(string(xrd), want) || !strings.Contains(string(xrd), `<XRD xmlns="`+xrdNamespace+`">`) {
+		t.Fatalf("xrd = %s", xrd)
+	}
+
+	b, err := json.Marshal(h.JRD())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"links":[{"rel":"lrdd","type":"application/jrd+json","template":"https://example.com/.well-known/webfinger?resource={uri}"}]}` {
+		t.Fatalf("jrd = %s", b)
+	}
+}
@@ -0,0 +1,43 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/peers-touch/peers-touch/station/frame/core/logger"
+)
+
+// RunEvery starts a background job that calls run every interval until ctx is done.
+// Errors and panics of a run are logged and never stop the job.
+func RunEvery(ctx context.Context, name string, interval time.Duration, run func(ctx context.Context) error) {
+	if interval <= 0 {
+		log.Infof(ctx, "job[%s] is disabled", name)
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := runOnce(ctx, run); err != nil {
+					log.Warnf(ctx, "job[%s] failed: %v", name, err)
+				}
+			}
+		}
+	}()
+}
+
+func runOnce(ctx context.Context, run func(ctx context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return run(ctx)
+}
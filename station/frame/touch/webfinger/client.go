@@ -0,0 +1,109 @@
+package webfinger
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/peers-touch/peers-touch/station/frame/touch/model"
+)
+
+// maxResponseBytes caps the JRD documents read from remote servers.
+const maxResponseBytes = 256 << 10
+
+var (
+	// ErrInvalidHandle is returned for handles that are not user@host.
+	ErrInvalidHandle = errors.New("webfinger: invalid handle, expected @user@host")
+	// ErrNoActor is returned when the JRD of an account links no ActivityPub actor.
+	ErrNoActor = errors.New("webfinger: no ActivityPub actor linked")
+)
+
+// Client resolves the handles of remote accounts through the WebFinger endpoint of
+// their servers.
+type Client struct {
+	http *http.Client
+	// scheme the servers are queried with, https but in tests
+	scheme string
+}
+
+// NewClient creates a client querying with the HTTP client.
+func NewClient(client *http.Client) *Client {
+	return &Client{http: client, scheme: "https"}
+}
+
+// ParseHandle splits a handle, @user@host, user@host or acct:user@host, in its user and
+// host.
+func ParseHandle(handle string) (user, host string, err error) {
+	handle = strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(handle), "acct:"), "@")
+	user, host, ok := strings.Cut(handle, "@")
+	if !ok || user == "" || host == "" || strings.ContainsAny(host, "@/?#") {
+		return "", "", ErrInvalidHandle
+	}
+	return user, host, nil
+}
+
+// Lookup fetches the JRD of the account of the handle from its server.
+func (c *Client) Lookup(ctx context.Context, handle string) (*model.WebFingerResponse, error) {
+	user, host, err := ParseHandle(handle)
+	if err != nil {
+		return nil, err
+	}
+	resource := fmt.Sprintf("acct:%s@%s", user, host)
+	target := fmt.Sprintf("%s://%s/.well-known/webfinger?resource=%s", c.scheme, host, url.QueryEscape(resource))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", model.ContentTypeJRD+", "+model.ContentTypeJSON)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusGone:
+		return nil, model.ErrActorNotFound
+	default:
+		return nil, fmt.Errorf("webfinger: lookup %s: %s", resource, resp.Status)
+	}
+
+	var jrd model.WebFingerResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseBytes)).Decode(&jrd); err != nil {
+		return nil, fmt.Errorf("webfinger: lookup %s: %w", resource, err)
+	}
+	return &jrd, nil
+}
+
+// ResolveActor returns the IRI of the ActivityPub actor of the handle, the self link of
+// its JRD.
+func (c *Client) ResolveActor(ctx context.Context, handle string) (string, error) {
+	jrd, err := c.Lookup(ctx, handle)
+	if err != nil {
+		return "", err
+	}
+	if iri := ActorLink(jrd); iri != "" {
+		return iri, nil
+	}
+	return "", ErrNoActor
+}
+
+// ActorLink returns the href of the self link of a JRD typed as an ActivityPub document,
+// empty when it has none.
+func ActorLink(jrd *model.WebFingerResponse) string {
+	for _, l := range jrd.Links {
+		if l.Rel != model.RelSelf || l.Href == "" {
+			continue
+		}
+		mediaType := strings.TrimSpace(strings.Split(l.Type, ";")[0])
+		if mediaType == model.ContentTypeActivityJSON || mediaType == "application/ld+json" {
+			return l.Href
+		}
+	}
+	return ""
+}
@@ -0,0 +1,83 @@
+package webfinger
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/peers-touch/peers-touch/station/frame/touch/model"
+)
+
+func TestParseHandle(t *testing.T) {
+	for _, tc := range []struct {
+		handle, user, host string
+		ok                 bool
+	}{
+		{"@alice@remote.example", "alice", "remote.example", true},
+		{"alice@remote.example:8443", "alice", "remote.example:8443", true},
+		{"acct:alice@remote.example", "alice", "remote.example", true},
+		{"alice", "", "", false},
+		{"@alice@", "", "", false},
+		{"alice@remote.example/users", "", "", false},
+	} {
+		user, host, err := ParseHandle(tc.handle)
+		if (err == nil) != tc.ok || user != tc.user || host != tc.host {
+			t.Errorf("ParseHandle(%q) = %q, %q, %v", tc.handle, user, host, err)
+		}
+	}
+}
+
+func TestResolveActor(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/.well-known/webfinger" {
+			http.NotFound(w, r)
+			return
+		}
+		resource := r.URL.Query().Get("resource")
+		if !strings.HasPrefix(resource, "acct:alice@") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", model.ContentTypeJRD)
+		_ = json.NewEncoder(w).Encode(model.WebFingerResponse{
+			Subject: resource,
+			Links: []model.WebFingerLink{
+				{Rel: model.RelProfilePage, Type: model.ContentTypeHTML, Href: "https://remote.example/@alice"},
+				{Rel: model.RelSelf, Type: `application/ld+json; profile="https://www.w3.org/ns/activitystreams"`, Href: "https://remote.example/users/alice"},
+			},
+		})
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.Client())
+	c.scheme = "http"
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	iri, err := c.ResolveActor(context.Background(), "@alice@"+host)
+	if err != nil || iri != "https://remote.example/users/alice" {
+		t.Fatalf("ResolveActor = %q, %v", iri, err)
+	}
+	if _, err := c.ResolveActor(context.Background(), "@bob@"+host); !errors.Is(err, model.ErrActorNotFound) {
+		t.Fatalf("unknown account: %v", err)
+	}
+}
+
+func TestFilterRequestedRelationships(t *testing.T) {
+	jrd := model.BuildWebFingerResponse(&model.WebFingerActivityPubActor{
+		ID:                "https://example.com/activitypub/alice/actor",
+		PreferredUsername: "alice",
+		Inbox:             "https://example.com/activitypub/alice/inbox",
+	}, "https://example.com", "acct:alice@example.com")
+
+	filtered := FilterRequestedRelationships(jrd, []string{model.RelSelf})
+	if len(filtered.Links) != 1 || ActorLink(filtered) != "https://example.com/activitypub/alice/actor" {
+		t.Fatalf("links = %+v", filtered.Links)
+	}
+	if len(jrd.Links) != 3 {
+		t.Fatalf("filtering changed the response: %+v", jrd.Links)
+	}
+}
@@ -2,35 +2,160 @@ package webfinger
 
 import (
 	"context"
+	"fmt"
+	"net"
+	"net/url"
 	"strings"
 
 	cfg "github.com/peers-touch/peers-touch/station/frame/core/config"
+	"github.com/peers-touch/peers-touch/station/frame/core/store"
 	"github.com/peers-touch/peers-touch/station/frame/touch/model"
+	"github.com/peers-touch/peers-touch/station/frame/touch/model/db"
 )
 
+// actorRoutePrefix is the path the ActivityPub routes of local actors are mounted below
+const actorRoutePrefix = "activitypub"
+
 // DiscoverUser discovers a user by WebFinger resource and returns a WebFinger response
 func DiscoverUser(ctx context.Context, params *model.WebFingerParams) (*model.WebFingerResponse, error) {
-	panic("implement me")
+	return DiscoverActor(ctx, params)
 }
 
-// DiscoverActor discovers an actor by WebFinger resource and returns a WebFinger response
+// DiscoverActor discovers an actor by WebFinger resource and returns a WebFinger response.
+// The resource is an acct: account or the https: URL of the actor or its profile page,
+// both on this server; other resources are not found.
 func DiscoverActor(ctx context.Context, params *model.WebFingerParams) (*model.WebFingerResponse, error) {
-	panic("implement me")
-	return nil, nil
+	username, err := localUsername(ctx, params.Resource)
+	if err != nil {
+		return nil, err
+	}
+	actor, err := GetActivityPubActor(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+
+	baseURL := strings.TrimSuffix(getBaseURL(), "/")
+	subject := model.WebFingerResource(fmt.Sprintf("acct:%s@%s", actor.PreferredUsername, localDomain()))
+	response := model.BuildWebFingerResponse(actor, baseURL, subject)
+	response.Aliases = append(response.Aliases, profileURL(baseURL, actor.PreferredUsername))
+	return response, nil
 }
 
 // GetActivityPubActor returns the ActivityPub actor representation for an actor
 func GetActivityPubActor(ctx context.Context, username string) (*model.WebFingerActivityPubActor, error) {
-	// Look up the user in the database
-	panic("implement me")
-	return nil, nil
+	rds, err := store.GetRDS(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var row db.ActivityPubActor
+	if err := rds.Where("preferred_username = ? AND is_local = ? AND is_active = ?", username, true, true).Limit(1).Find(&row).Error; err != nil {
+		return nil, err
+	}
+	if row.ID != 0 {
+		actor := &model.WebFingerActivityPubActor{
+			ID:                row.ActivityPubID,
+			Type:              row.Type,
+			PreferredUsername: row.PreferredUsername,
+			Name:              row.Name,
+			Summary:           row.Summary,
+			Inbox:             row.InboxURL,
+			Outbox:            row.OutboxURL,
+			Followers:         row.FollowersURL,
+			Following:         row.FollowingURL,
+			Liked:             row.LikedURL,
+			CreatedAt:         row.CreatedAt,
+			UpdatedAt:         row.UpdatedAt,
+		}
+		if row.PublicKeyPem != "" {
+			actor.PublicKey = &model.ActivityPubPublicKey{ID: row.ActivityPubID + "#main-key", Owner: row.ActivityPubID, PublicKeyPem: row.PublicKeyPem}
+		}
+		return actor, nil
+	}
+
+	// users whose ActivityPub actor was not created yet get it on its first fetch,
+	// it will live at the IRIs the ActivityPub routes give it
+	var user db.Actor
+	if err := rds.Where("name = ?", username).Limit(1).Find(&user).Error; err != nil {
+		return nil, err
+	}
+	if user.ID == 0 {
+		return nil, model.ErrActorNotFound
+	}
+	iri := func(name string) string {
+		return fmt.Sprintf("%s/%s/%s/%s", strings.TrimSuffix(getBaseURL(), "/"), actorRoutePrefix, url.PathEscape(username), name)
+	}
+	return &model.WebFingerActivityPubActor{
+		ID:                iri("actor"),
+		Type:              "Person",
+		PreferredUsername: username,
+		Name:              user.Name,
+		Inbox:             iri("inbox"),
+		Outbox:            iri("outbox"),
+		Followers:         iri("followers"),
+		Following:         iri("following"),
+		Liked:             iri("liked"),
+		CreatedAt:         user.CreatedAt,
+		UpdatedAt:         user.UpdatedAt,
+	}, nil
+}
+
+// localUsername returns the username of the local actor a resource names
+func localUsername(ctx context.Context, resource model.WebFingerResource) (string, error) {
+	if !resource.IsURL() {
+		req, err := model.ParseActorDiscoveryRequest(resource, nil)
+		if err != nil {
+			return "", model.ErrWellKnownInvalidResourceFormat
+		}
+		if !isLocalDomain(req.Domain) {
+			return "", model.ErrActorNotFound
+		}
+		return req.Username, nil
+	}
+
+	u, err := url.Parse(string(resource))
+	if err != nil || !isLocalDomain(u.Hostname()) {
+		return "", model.ErrActorNotFound
+	}
+	rds, err := store.GetRDS(ctx)
+	if err != nil {
+		return "", err
+	}
+	var row db.ActivityPubActor
+	if err := rds.Where("activity_pub_id = ? AND is_local = ?", string(resource), true).Limit(1).Find(&row).Error; err != nil {
+		return "", err
+	}
+	if row.ID != 0 {
+		return row.PreferredUsername, nil
+	}
+
+	// the profile page /@user, or the actor route of a user without an actor yet
+	parts := strings.Split(strings.Trim(u.EscapedPath(), "/"), "/")
+	switch {
+	case len(parts) == 1 && strings.HasPrefix(parts[0], "@"):
+		return url.PathUnescape(strings.TrimPrefix(parts[0], "@"))
+	case len(parts) == 3 && parts[0] == actorRoutePrefix && parts[2] == "actor":
+		return url.PathUnescape(parts[1])
+	}
+	return "", model.ErrActorNotFound
+}
+
+func profileURL(baseURL, username string) string {
+	return fmt.Sprintf("%s/@%s", baseURL, username)
 }
 
 // isLocalDomain checks if the given domain matches our server's domain
 func isLocalDomain(domain string) bool {
-	baseURL := getBaseURL()
+	if host, _, err := net.SplitHostPort(domain); err == nil {
+		domain = host
+	}
+	return strings.EqualFold(localDomain(), domain)
+}
+
+// localDomain returns the host name of our server, without its port
+func localDomain() string {
+	serverDomain := getBaseURL()
 	// Extract domain from base SubPath
-	serverDomain := baseURL
 	if strings.HasPrefix(serverDomain, "http://") {
 		serverDomain = strings.TrimPrefix(serverDomain, "http://")
 	} else if strings.HasPrefix(serverDomain, "https://") {
@@ -47,7 +172,7 @@ func isLocalDomain(domain string) bool {
 		serverDomain = serverDomain[:slashIndex]
 	}
 
-	return strings.EqualFold(serverDomain, domain)
+	return serverDomain
 }
 
 // GetSupportedRelationships returns the relationships supported by this server
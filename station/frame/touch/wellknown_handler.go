@@ -2,13 +2,17 @@ package touch
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"net/http"
 	"strings"
 
 	"github.com/cloudwego/hertz/pkg/app"
 	log "github.com/peers-touch/peers-touch/station/frame/core/logger"
 	"github.com/peers-touch/peers-touch/station/frame/core/server"
+	"github.com/peers-touch/peers-touch/station/frame/touch/activitypub"
 	"github.com/peers-touch/peers-touch/station/frame/touch/model"
+	"github.com/peers-touch/peers-touch/station/frame/touch/nodeinfo"
 	"github.com/peers-touch/peers-touch/station/frame/touch/webfinger"
 )
 
@@ -35,6 +39,30 @@ func GetWellKnownHandlers() []WellKnownHandlerInfo {
 			Method:    server.GET,
 			Wrappers:  []server.Wrapper{CommonAccessControlWrapper("WellKnown")},
 		},
+		{
+			RouterURL: RouterURLWellKnownNodeInfo,
+			Handler:   NodeInfoDiscoveryHandler,
+			Method:    server.GET,
+			Wrappers:  []server.Wrapper{CommonAccessControlWrapper("WellKnown")},
+		},
+		{
+			RouterURL: RouterURLWellKnownNodeInfo2,
+			Handler:   NodeInfoHandler,
+			Method:    server.GET,
+			Wrappers:  []server.Wrapper{CommonAccessControlWrapper("WellKnown")},
+		},
+		{
+			RouterURL: RouterURLWellKnownHostMeta,
+			Handler:   HostMetaHandler,
+			Method:    server.GET,
+			Wrappers:  []server.Wrapper{CommonAccessControlWrapper("WellKnown")},
+		},
+		{
+			RouterURL: RouterURLWellKnownHostJSON,
+			Handler:   HostMetaJSONHandler,
+			Method:    server.GET,
+			Wrappers:  []server.Wrapper{CommonAccessControlWrapper("WellKnown")},
+		},
 	}
 }
 
@@ -69,9 +97,9 @@ func WebfingerHandler(c context.Context, ctx *app.RequestContext) {
 
 	// Parse requested relationships (rel parameter can appear multiple times)
 	requestedRels := make([]string, 0)
-	if relParam := ctx.Query("rel"); relParam != "" {
+	for _, relParam := range ctx.QueryArgs().PeekAll("rel") {
 		// Handle comma-separated values or multiple rel parameters
-		rels := strings.Split(relParam, ",")
+		rels := strings.Split(string(relParam), ",")
 		for _, rel := range rels {
 			rel = strings.TrimSpace(rel)
 			if rel != "" {
@@ -85,7 +113,15 @@ func WebfingerHandler(c context.Context, ctx *app.RequestContext) {
 	if err != nil {
 		log.Warnf(c, "[Webfinger] discovery failed: %v", err)
 
-		if strings.Contains(err.Error(), "actor not found") {
+		if errors.Is(err, model.ErrWellKnownInvalidResourceFormat) || errors.Is(err, model.ErrWellKnownUnsupportedPrefixType) {
+			ctx.JSON(http.StatusBadRequest, map[string]string{
+				"error":   "invalid_resource",
+				"message": err.Error(),
+			})
+			return
+		}
+
+		if errors.Is(err, model.ErrActorNotFound) {
 			ctx.JSON(http.StatusNotFound, map[string]string{
 				"error":   "not_found",
 				"message": "Resource not found",
@@ -113,3 +149,60 @@ func WebfingerHandler(c context.Context, ctx *app.RequestContext) {
 
 	ctx.JSON(http.StatusOK, response)
 }
+
+// NodeInfoDiscoveryHandler serves the document linking the NodeInfo 2.1 document
+func NodeInfoDiscoveryHandler(c context.Context, ctx *app.RequestContext) {
+	ctx.Header("Access-Control-Allow-Origin", "*")
+	ctx.JSON(http.StatusOK, nodeinfo.NewDiscovery(activitypub.BaseURL()))
+}
+
+// NodeInfoHandler serves the NodeInfo 2.1 document
+func NodeInfoHandler(c context.Context, ctx *app.RequestContext) {
+	info, err := nodeinfo.Get(c)
+	if err != nil {
+		log.Errorf(c, "[NodeInfo] load node info failed: %v", err)
+		ctx.JSON(http.StatusInternalServerError, map[string]string{
+			"error":   "server_error",
+			"message": "Internal server error occurred",
+		})
+		return
+	}
+	b, err := json.Marshal(info)
+	if err != nil {
+		log.Errorf(c, "[NodeInfo] encode node info failed: %v", err)
+		ctx.SetStatusCode(http.StatusInternalServerError)
+		return
+	}
+	ctx.Header("Access-Control-Allow-Origin", "*")
+	ctx.Data(http.StatusOK, nodeinfo.ContentType, b)
+}
+
+// HostMetaHandler serves the host-meta document as XRD, or as JSON to requests that
+// only accept JSON
+func HostMetaHandler(c context.Context, ctx *app.RequestContext) {
+	accept := string(ctx.GetHeader("Accept"))
+	if strings.Contains(accept, "json") && !strings.Contains(accept, "xml") {
+		HostMetaJSONHandler(c, ctx)
+		return
+	}
+	b, err := nodeinfo.NewHostMeta(activitypub.BaseURL()).XRD()
+	if err != nil {
+		log.Errorf(c, "[HostMeta] encode host-meta failed: %v", err)
+		ctx.SetStatusCode(http.StatusInternalServerError)
+		return
+	}
+	ctx.Header("Access-Control-Allow-Origin", "*")
+	ctx.Data(http.StatusOK, nodeinfo.ContentTypeXRD+"; charset=utf-8", b)
+}
+
+// HostMetaJSONHandler serves the host-meta document as JSON
+func HostMetaJSONHandler(c context.Context, ctx *app.RequestContext) {
+	b, err := json.Marshal(nodeinfo.NewHostMeta(activitypub.BaseURL()).JRD())
+	if err != nil {
+		log.Errorf(c, "[HostMeta] encode host-meta failed: %v", err)
+		ctx.SetStatusCode(http.StatusInternalServerError)
+		return
+	}
+	ctx.Header("Access-Control-Allow-Origin", "*")
+	ctx.Data(http.StatusOK, nodeinfo.ContentTypeJRD+"; charset=utf-8", b)
+}
@@ -7,6 +7,10 @@ import (
 const (
 	RouterURLWellKnown          RouterPath = "/"
 	RouterURLWellKnownWebFinger RouterPath = "/webfinger"
+	RouterURLWellKnownNodeInfo  RouterPath = "/nodeinfo"
+	RouterURLWellKnownNodeInfo2 RouterPath = "/nodeinfo/2.1"
+	RouterURLWellKnownHostMeta  RouterPath = "/host-meta"
+	RouterURLWellKnownHostJSON  RouterPath = "/host-meta.json"
 )
 
 // WellKnownRouters provides .well-known endpoints for the service
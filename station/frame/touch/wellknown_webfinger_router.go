@@ -2,6 +2,7 @@ package touch
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"strings"
 
@@ -36,9 +37,9 @@ func Webfinger(c context.Context, ctx *app.RequestContext) {
 
 	// Parse requested relationships (rel parameter can appear multiple times)
 	requestedRels := make([]string, 0)
-	if relParam := ctx.Query("rel"); relParam != "" {
+	for _, relParam := range ctx.QueryArgs().PeekAll("rel") {
 		// Handle comma-separated values or multiple rel parameters
-		rels := strings.Split(relParam, ",")
+		rels := strings.Split(string(relParam), ",")
 		for _, rel := range rels {
 			rel = strings.TrimSpace(rel)
 			if rel != "" {
@@ -53,7 +54,15 @@ func Webfinger(c context.Context, ctx *app.RequestContext) {
 		log.Warnf(c, "[Webfinger] user discovery failed: %v", err)
 
 		// Check if it's a "not found" error
-		if strings.Contains(err.Error(), "user not found") {
+		if errors.Is(err, model.ErrWellKnownInvalidResourceFormat) || errors.Is(err, model.ErrWellKnownUnsupportedPrefixType) {
+			ctx.JSON(http.StatusBadRequest, map[string]string{
+				"error":   "invalid_resource",
+				"message": err.Error(),
+			})
+			return
+		}
+
+		if errors.Is(err, model.ErrActorNotFound) {
 			ctx.JSON(http.StatusNotFound, map[string]string{
 				"error":   "not_found",
 				"message": "The requested resource was not found",